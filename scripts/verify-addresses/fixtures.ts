@@ -0,0 +1,344 @@
+/**
+ * Conformance Fixture Emitters
+ *
+ * Renders the verified vectors as ready-to-run fixture modules so every
+ * implementation in the cross-check matrix runs exactly the same cases:
+ *
+ *   vectors.json                        - canonical data, for any language
+ *   test_verified_vectors.py            - pytest module
+ *   verified_vectors.rs                 - Rust module with a test macro
+ *   verified-vectors.conformance.ts     - vitest suite factory
+ *
+ * Each module embeds the same data and is generated from the same JSON
+ * document, so the fixtures cannot drift apart between languages. Because
+ * each embeds its own copy, each is signed and checked on its own:
+ * verify-vectors in the Go verifier checks vectors.json and every module
+ * beside it before any suite runs them.
+ */
+
+import { writeFileSync, mkdirSync, existsSync } from 'fs';
+import { join } from 'path';
+
+import type { VerifiedSingleSigVector, VerifiedMultisigVector } from './types.js';
+
+/**
+ * Version of the fixture document layout, bumped on incompatible changes
+ */
+export const FIXTURE_SCHEMA_VERSION = 1;
+
+export interface ConformanceFixtures {
+  schemaVersion: number;
+  generated: string;
+  implementations: string[];
+  mnemonic: string;
+  singleSig: VerifiedSingleSigVector[];
+  multisig: VerifiedMultisigVector[];
+}
+
+const HEADER_LINES = [
+  'VERIFIED ADDRESS CONFORMANCE FIXTURES',
+  '',
+  'DO NOT MODIFY MANUALLY - regenerate using:',
+  '  cd scripts/verify-addresses && npm run generate',
+  '',
+  'Only run this module once its minisign signature has been checked:',
+  '  cd scripts/verify-addresses/implementations &&',
+  '  go run . verify-vectors ../output/fixtures/vectors.json --pubkey <key>',
+];
+
+function header(fixtures: ConformanceFixtures, comment: string): string {
+  const lines = [
+    ...HEADER_LINES,
+    '',
+    `Generated: ${fixtures.generated}`,
+    `Vectors: ${fixtures.singleSig.length} single-sig, ${fixtures.multisig.length} multisig`,
+    'Verified by:',
+    ...fixtures.implementations.map(i => `  - ${i}`),
+  ];
+  return lines.map(l => (l ? `${comment} ${l}` : comment)).join('\n');
+}
+
+// =============================================================================
+// JSON
+// =============================================================================
+
+export function emitJsonFixtures(fixtures: ConformanceFixtures): string {
+  return JSON.stringify(fixtures, null, 2) + '\n';
+}
+
+// =============================================================================
+// Python (pytest)
+// =============================================================================
+
+/**
+ * The pytest module loads the implementation under test from the
+ * CONFORMANCE_IMPL environment variable (a module name or a .py path),
+ * defaulting to the bip_utils wrapper in implementations/. The module must
+ * provide derive_single_sig and derive_multisig (a _bip_utils suffix is
+ * also accepted).
+ */
+export function emitPytestFixtures(fixtures: ConformanceFixtures): string {
+  const data = JSON.stringify(
+    { singleSig: fixtures.singleSig, multisig: fixtures.multisig },
+    null,
+    2
+  );
+
+  return `${header(fixtures, '#')}
+
+import importlib
+import importlib.util
+import json
+import os
+
+import pytest
+
+FIXTURES = json.loads(r'''
+${data}
+''')
+
+SINGLESIG_VECTORS = FIXTURES["singleSig"]
+MULTISIG_VECTORS = FIXTURES["multisig"]
+
+_DEFAULT_IMPL = os.path.join(
+    os.path.dirname(os.path.abspath(__file__)), "..", "..", "implementations", "python-verify.py"
+)
+
+
+def _load_impl():
+    target = os.environ.get("CONFORMANCE_IMPL", _DEFAULT_IMPL)
+    if target.endswith(".py"):
+        spec = importlib.util.spec_from_file_location("conformance_impl", target)
+        module = importlib.util.module_from_spec(spec)
+        spec.loader.exec_module(module)
+        return module
+    return importlib.import_module(target)
+
+
+def _resolve(module, name):
+    for candidate in (name, name + "_bip_utils"):
+        fn = getattr(module, candidate, None)
+        if fn is not None:
+            return fn
+    pytest.skip("implementation does not provide %s" % name)
+
+
+@pytest.fixture(scope="module")
+def impl():
+    return _load_impl()
+
+
+@pytest.mark.parametrize("vector", SINGLESIG_VECTORS, ids=[v["description"] for v in SINGLESIG_VECTORS])
+def test_single_sig(impl, vector):
+    derive = _resolve(impl, "derive_single_sig")
+    address = derive(vector["xpub"], vector["index"], vector["scriptType"], vector["change"], vector["network"])
+    assert address == vector["expectedAddress"]
+
+
+@pytest.mark.parametrize("vector", MULTISIG_VECTORS, ids=[v["description"] for v in MULTISIG_VECTORS])
+def test_multisig(impl, vector):
+    derive = _resolve(impl, "derive_multisig")
+    address = derive(
+        vector["xpubs"],
+        vector["threshold"],
+        vector["index"],
+        vector["scriptType"],
+        vector["change"],
+        vector["network"],
+    )
+    assert address == vector["expectedAddress"]
+`;
+}
+
+// =============================================================================
+// Rust
+// =============================================================================
+
+function rustString(s: string): string {
+  return JSON.stringify(s);
+}
+
+/**
+ * The Rust module is dependency-free. include!() it in a test module and
+ * invoke conformance_tests!(single_sig_fn, multisig_fn) to generate one
+ * #[test] per vector family.
+ */
+export function emitRustFixtures(fixtures: ConformanceFixtures): string {
+  const single = fixtures.singleSig
+    .map(v => `    SingleSigVector {
+        description: ${rustString(v.description)},
+        path: ${rustString(v.path)},
+        xpub: ${rustString(v.xpub)},
+        script_type: ${rustString(v.scriptType)},
+        network: ${rustString(v.network)},
+        index: ${v.index},
+        change: ${v.change},
+        expected_address: ${rustString(v.expectedAddress)},
+    },`)
+    .join('\n');
+
+  const multi = fixtures.multisig
+    .map(v => `    MultisigVector {
+        description: ${rustString(v.description)},
+        xpubs: &[${v.xpubs.map(rustString).join(', ')}],
+        threshold: ${v.threshold},
+        script_type: ${rustString(v.scriptType)},
+        network: ${rustString(v.network)},
+        index: ${v.index},
+        change: ${v.change},
+        expected_address: ${rustString(v.expectedAddress)},
+    },`)
+    .join('\n');
+
+  return `${header(fixtures, '//')}
+
+pub const TEST_MNEMONIC: &str = ${rustString(fixtures.mnemonic)};
+
+#[derive(Debug, Clone, Copy)]
+pub struct SingleSigVector {
+    pub description: &'static str,
+    pub path: &'static str,
+    pub xpub: &'static str,
+    pub script_type: &'static str,
+    pub network: &'static str,
+    pub index: u32,
+    pub change: bool,
+    pub expected_address: &'static str,
+}
+
+#[derive(Debug, Clone, Copy)]
+pub struct MultisigVector {
+    pub description: &'static str,
+    pub xpubs: &'static [&'static str],
+    pub threshold: usize,
+    pub script_type: &'static str,
+    pub network: &'static str,
+    pub index: u32,
+    pub change: bool,
+    pub expected_address: &'static str,
+}
+
+pub const SINGLESIG_VECTORS: &[SingleSigVector] = &[
+${single}
+];
+
+pub const MULTISIG_VECTORS: &[MultisigVector] = &[
+${multi}
+];
+
+/// Generates conformance tests against the given derivation functions:
+///
+///   fn single(xpub: &str, index: u32, script_type: &str, change: bool, network: &str) -> String
+///   fn multi(xpubs: &[&str], threshold: usize, index: u32, script_type: &str, change: bool, network: &str) -> String
+macro_rules! conformance_tests {
+    ($single:path, $multi:path) => {
+        #[test]
+        fn conformance_single_sig() {
+            for v in SINGLESIG_VECTORS {
+                let address = $single(v.xpub, v.index, v.script_type, v.change, v.network);
+                assert_eq!(address, v.expected_address, "{}", v.description);
+            }
+        }
+
+        #[test]
+        fn conformance_multisig() {
+            for v in MULTISIG_VECTORS {
+                let address = $multi(v.xpubs, v.threshold, v.index, v.script_type, v.change, v.network);
+                assert_eq!(address, v.expected_address, "{}", v.description);
+            }
+        }
+    };
+}
+`;
+}
+
+// =============================================================================
+// TypeScript (vitest)
+// =============================================================================
+
+/**
+ * The TypeScript module exports the vectors and defineConformanceSuite(),
+ * which registers vitest cases for anything shaped like AddressDeriver.
+ */
+export function emitTypeScriptFixtures(fixtures: ConformanceFixtures): string {
+  return `/**
+${header(fixtures, ' *')}
+ */
+
+import { describe, it, expect } from 'vitest';
+
+export interface ConformanceDeriver {
+  name: string;
+  deriveSingleSig(
+    xpub: string,
+    index: number,
+    scriptType: string,
+    change: boolean,
+    network: string
+  ): Promise<string> | string;
+  deriveMultisig(
+    xpubs: string[],
+    threshold: number,
+    index: number,
+    scriptType: string,
+    change: boolean,
+    network: string
+  ): Promise<string> | string;
+}
+
+export const SINGLESIG_VECTORS = ${JSON.stringify(fixtures.singleSig, null, 2)} as const;
+
+export const MULTISIG_VECTORS = ${JSON.stringify(fixtures.multisig, null, 2)} as const;
+
+export function defineConformanceSuite(deriver: ConformanceDeriver): void {
+  describe(\`\${deriver.name} conformance\`, () => {
+    describe('single-sig', () => {
+      it.each(SINGLESIG_VECTORS.map(v => [v.description, v] as const))('%s', async (_, v) => {
+        const address = await deriver.deriveSingleSig(v.xpub, v.index, v.scriptType, v.change, v.network);
+        expect(address).toBe(v.expectedAddress);
+      });
+    });
+
+    describe('multisig', () => {
+      it.each(MULTISIG_VECTORS.map(v => [v.description, v] as const))('%s', async (_, v) => {
+        const address = await deriver.deriveMultisig(
+          [...v.xpubs],
+          v.threshold,
+          v.index,
+          v.scriptType,
+          v.change,
+          v.network
+        );
+        expect(address).toBe(v.expectedAddress);
+      });
+    });
+  });
+}
+`;
+}
+
+// =============================================================================
+// Writer
+// =============================================================================
+
+const EMITTERS: Array<{ file: string; emit: (f: ConformanceFixtures) => string }> = [
+  { file: 'vectors.json', emit: emitJsonFixtures },
+  { file: 'test_verified_vectors.py', emit: emitPytestFixtures },
+  { file: 'verified_vectors.rs', emit: emitRustFixtures },
+  { file: 'verified-vectors.conformance.ts', emit: emitTypeScriptFixtures },
+];
+
+/**
+ * Write every fixture module to outputDir, returning the written paths
+ */
+export function writeConformanceFixtures(fixtures: ConformanceFixtures, outputDir: string): string[] {
+  if (!existsSync(outputDir)) {
+    mkdirSync(outputDir, { recursive: true });
+  }
+
+  return EMITTERS.map(({ file, emit }) => {
+    const path = join(outputDir, file);
+    writeFileSync(path, emit(fixtures));
+    return path;
+  });
+}
@@ -45,6 +45,8 @@ import { caravanImpl } from './implementations/caravan.js';
 import { pythonImpl } from './implementations/python.js';
 import { goImpl } from './implementations/go.js';
 
+import { FIXTURE_SCHEMA_VERSION, writeConformanceFixtures } from './fixtures.js';
+
 const __filename = fileURLToPath(import.meta.url);
 const __dirname = dirname(__filename);
 
@@ -734,6 +736,22 @@ async function main() {
     console.log(`  Note: Could not write to fixtures directory: ${error instanceof Error ? error.message : String(error)}`);
   }
 
+  // Conformance fixtures: the same cases for every language in the matrix
+  const conformancePaths = writeConformanceFixtures(
+    {
+      schemaVersion: FIXTURE_SCHEMA_VERSION,
+      generated: new Date().toISOString().split('T')[0],
+      implementations: implementationNames,
+      mnemonic: TEST_MNEMONIC,
+      singleSig: verifiedSingleSig,
+      multisig: verifiedMultisig,
+    },
+    join(outputDir, 'fixtures')
+  );
+  for (const path of conformancePaths) {
+    console.log(`  Written: ${path}`);
+  }
+
   // Summary
   console.log();
   console.log('='.repeat(60));
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Wallet specs and bundles may be encrypted in the age format
+// (age-encryption.org/v1), to X25519 recipients ("age1...") or to a
+// passphrase, so they can be archived and passed around without exposing
+// the xpubs. Files are interchangeable with the age and rage tools. This
+// is the part of the format specs need: X25519 and scrypt stanzas, the
+// STREAM payload and ASCII armor.
+
+const (
+	ageIntro       = "age-encryption.org/v1"
+	ageArmorBegin  = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd    = "-----END AGE ENCRYPTED FILE-----"
+	ageChunkSize   = 64 * 1024
+	ageX25519Label = "age-encryption.org/v1/X25519"
+	ageScryptLabel = "age-encryption.org/v1/scrypt"
+	// ageScryptLogN is the work factor passphrase encryption uses, and
+	// ageMaxScryptLogN the most a file may ask to be decrypted with.
+	ageScryptLogN    = 18
+	ageMaxScryptLogN = 22
+)
+
+var ageB64 = base64.RawStdEncoding
+
+// ageStanza is one recipient stanza of a header.
+type ageStanza struct {
+	kind string
+	args []string
+	body []byte
+}
+
+// isAgeFile reports whether data is an age file, binary or armored.
+func isAgeFile(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte(ageIntro+"\n")) || bytes.HasPrefix(trimmed, []byte(ageArmorBegin))
+}
+
+// encryptAge encrypts plaintext to the X25519 recipients or, with none, to
+// the passphrase, and returns an armored age file.
+func encryptAge(plaintext []byte, recipients []string, passphrase []byte) ([]byte, error) {
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, fmt.Errorf("failed to generate age file key: %w", err)
+	}
+	defer clear(fileKey)
+
+	var stanzas []ageStanza
+	for _, r := range recipients {
+		public, err := parseAgeRecipient(r)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral := make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(ephemeral); err != nil {
+			return nil, fmt.Errorf("failed to generate age ephemeral key: %w", err)
+		}
+		share, _ := curve25519.X25519(ephemeral, curve25519.Basepoint)
+		shared, err := curve25519.X25519(ephemeral, public)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %s", r)
+		}
+		body, err := ageWrap(hkdfKey(shared, append(share, public...), ageX25519Label), fileKey)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, ageStanza{kind: "X25519", args: []string{ageB64.EncodeToString(share)}, body: body})
+	}
+	if len(recipients) == 0 {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate age scrypt salt: %w", err)
+		}
+		key, err := scrypt.Key(passphrase, append([]byte(ageScryptLabel), salt...), 1<<ageScryptLogN, 8, 1, 32)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ageWrap(key, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, ageStanza{kind: "scrypt", args: []string{ageB64.EncodeToString(salt), strconv.Itoa(ageScryptLogN)}, body: body})
+	}
+
+	var out bytes.Buffer
+	out.WriteString(ageIntro + "\n")
+	for _, s := range stanzas {
+		fmt.Fprintf(&out, "-> %s\n", strings.Join(append([]string{s.kind}, s.args...), " "))
+		encoded := ageB64.EncodeToString(s.body)
+		for len(encoded) >= 64 {
+			out.WriteString(encoded[:64] + "\n")
+			encoded = encoded[64:]
+		}
+		out.WriteString(encoded + "\n")
+	}
+	out.WriteString("---")
+	mac := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	mac.Write(out.Bytes())
+	out.WriteString(" " + ageB64.EncodeToString(mac.Sum(nil)) + "\n")
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate age payload nonce: %w", err)
+	}
+	out.Write(nonce)
+	aead, _ := chacha20poly1305.New(hkdfKey(fileKey, nonce, "payload"))
+	for counter := uint64(0); ; counter++ {
+		chunk := plaintext[:min(len(plaintext), ageChunkSize)]
+		plaintext = plaintext[len(chunk):]
+		last := len(plaintext) == 0
+		out.Write(aead.Seal(nil, ageChunkNonce(counter, last), chunk, nil))
+		if last {
+			break
+		}
+	}
+	return ageArmor(out.Bytes()), nil
+}
+
+// decryptAge decrypts an age file with the identities, or with the
+// passphrase for a file encrypted to one.
+func decryptAge(data []byte, identities [][]byte, passphrase func() ([]byte, error)) ([]byte, error) {
+	data, err := ageDearmor(data)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	stanzas, header, mac, err := parseAgeHeader(r)
+	if err != nil {
+		return nil, errorWithCode(errDecryptionFailed, "invalid age header: %v", err)
+	}
+
+	var fileKey []byte
+	for _, s := range stanzas {
+		switch s.kind {
+		case "X25519":
+			if len(s.args) != 1 {
+				return nil, errorWithCode(errDecryptionFailed, "invalid age X25519 stanza")
+			}
+			share, err := ageB64.DecodeString(s.args[0])
+			if err != nil || len(share) != curve25519.PointSize {
+				return nil, errorWithCode(errDecryptionFailed, "invalid age X25519 stanza")
+			}
+			for _, identity := range identities {
+				shared, err := curve25519.X25519(identity, share)
+				if err != nil {
+					continue
+				}
+				public, _ := curve25519.X25519(identity, curve25519.Basepoint)
+				if key, err := ageUnwrap(hkdfKey(shared, append(share, public...), ageX25519Label), s.body); err == nil {
+					fileKey = key
+					break
+				}
+			}
+		case "scrypt":
+			if len(stanzas) != 1 || len(s.args) != 2 {
+				return nil, errorWithCode(errDecryptionFailed, "an age scrypt stanza must be the only one")
+			}
+			salt, err := ageB64.DecodeString(s.args[0])
+			logN, nerr := strconv.Atoi(s.args[1])
+			if err != nil || len(salt) != 16 || nerr != nil || logN < 1 || s.args[1] != strconv.Itoa(logN) {
+				return nil, errorWithCode(errDecryptionFailed, "invalid age scrypt stanza")
+			}
+			if logN > ageMaxScryptLogN {
+				return nil, errorWithCode(errDecryptionFailed, "age scrypt work factor %d is above %d", logN, ageMaxScryptLogN)
+			}
+			pass, err := passphrase()
+			if err != nil {
+				return nil, err
+			}
+			key, err := scrypt.Key(pass, append([]byte(ageScryptLabel), salt...), 1<<logN, 8, 1, 32)
+			if err != nil {
+				return nil, err
+			}
+			if fileKey, err = ageUnwrap(key, s.body); err != nil {
+				return nil, errorWithCode(errDecryptionFailed, "wrong passphrase")
+			}
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil && len(identities) == 0 {
+		return nil, errorWithCode(errDecryptionFailed, "the file is encrypted to age recipients; give --identity <file>")
+	}
+	if fileKey == nil {
+		return nil, errorWithCode(errDecryptionFailed, "none of the identities can decrypt this file")
+	}
+	defer clear(fileKey)
+	check := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	check.Write(header)
+	if !hmac.Equal(check.Sum(nil), mac) {
+		return nil, errorWithCode(errDecryptionFailed, "age header MAC does not match")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, errorWithCode(errDecryptionFailed, "truncated age payload")
+	}
+	aead, _ := chacha20poly1305.New(hkdfKey(fileKey, nonce, "payload"))
+	payload, _ := io.ReadAll(r)
+	var plaintext []byte
+	for counter := uint64(0); ; counter++ {
+		size := min(len(payload), ageChunkSize+aead.Overhead())
+		chunk := payload[:size]
+		payload = payload[size:]
+		last := len(payload) == 0
+		opened, err := aead.Open(nil, ageChunkNonce(counter, last), chunk, nil)
+		if err != nil {
+			return nil, errorWithCode(errDecryptionFailed, "age payload is corrupt or truncated")
+		}
+		if len(opened) == 0 && counter > 0 {
+			return nil, errorWithCode(errDecryptionFailed, "age payload has an empty final chunk")
+		}
+		plaintext = append(plaintext, opened...)
+		if last {
+			return plaintext, nil
+		}
+	}
+}
+
+// parseAgeHeader reads the header, returning the stanzas, the bytes the
+// MAC covers, and the MAC.
+func parseAgeHeader(r *bufio.Reader) ([]ageStanza, []byte, []byte, error) {
+	var header bytes.Buffer
+	line := func() (string, error) {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("truncated header")
+		}
+		return strings.TrimSuffix(l, "\n"), nil
+	}
+	intro, err := line()
+	if err != nil || intro != ageIntro {
+		return nil, nil, nil, fmt.Errorf("not an age v1 file")
+	}
+	header.WriteString(intro + "\n")
+	var stanzas []ageStanza
+	for {
+		l, err := line()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if mac, ok := strings.CutPrefix(l, "--- "); ok {
+			header.WriteString("---")
+			sum, err := ageB64.DecodeString(mac)
+			if err != nil || len(sum) != sha256.Size {
+				return nil, nil, nil, fmt.Errorf("invalid MAC")
+			}
+			if len(stanzas) == 0 {
+				return nil, nil, nil, fmt.Errorf("no recipients")
+			}
+			return stanzas, header.Bytes(), sum, nil
+		}
+		fields, ok := strings.CutPrefix(l, "-> ")
+		if !ok || fields == "" {
+			return nil, nil, nil, fmt.Errorf("malformed stanza %q", l)
+		}
+		header.WriteString(l + "\n")
+		parts := strings.Split(fields, " ")
+		s := ageStanza{kind: parts[0], args: parts[1:]}
+		for {
+			b, err := line()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			header.WriteString(b + "\n")
+			decoded, err := ageB64.Strict().DecodeString(b)
+			if err != nil || len(b) > 64 {
+				return nil, nil, nil, fmt.Errorf("malformed stanza body")
+			}
+			s.body = append(s.body, decoded...)
+			if len(b) < 64 {
+				break
+			}
+		}
+		stanzas = append(stanzas, s)
+	}
+}
+
+func hkdfKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key)
+	return key
+}
+
+func ageWrap(key, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil), nil
+}
+
+func ageUnwrap(key, body []byte) ([]byte, error) {
+	if len(body) != 16+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("wrapped file key has the wrong size")
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), body, nil)
+}
+
+// ageChunkNonce is the STREAM nonce of a payload chunk: an 11-byte big
+// endian counter and a final-chunk flag.
+func ageChunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func ageArmor(data []byte) []byte {
+	var out bytes.Buffer
+	out.WriteString(ageArmorBegin + "\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(len(encoded), 64)
+		out.WriteString(encoded[:n] + "\n")
+		encoded = encoded[n:]
+	}
+	out.WriteString(ageArmorEnd + "\n")
+	return out.Bytes()
+}
+
+func ageDearmor(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	body, ok := bytes.CutPrefix(trimmed, []byte(ageArmorBegin))
+	if !ok {
+		return data, nil
+	}
+	body, ok = bytes.CutSuffix(body, []byte(ageArmorEnd))
+	if !ok {
+		return nil, errorWithCode(errDecryptionFailed, "age armor has no end line")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	if err != nil {
+		return nil, errorWithCode(errDecryptionFailed, "invalid age armor: %v", err)
+	}
+	return decoded, nil
+}
+
+// parseAgeRecipient decodes an "age1..." X25519 recipient.
+func parseAgeRecipient(s string) ([]byte, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err == nil && hrp == "age" {
+		if key, err := bech32.ConvertBits(data, 5, 8, false); err == nil && len(key) == curve25519.PointSize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid age recipient: %q", s)
+}
+
+// loadAgeIdentities reads the "AGE-SECRET-KEY-1..." lines of an identity
+// file, as age-keygen writes it.
+func loadAgeIdentities(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %v", err)
+	}
+	defer clear(data)
+	var identities [][]byte
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, words, err := bech32.Decode(line)
+		if err != nil || strings.ToLower(hrp) != "age-secret-key-" {
+			return nil, fmt.Errorf("identity file %s holds something other than age X25519 identities", path)
+		}
+		key, err := bech32.ConvertBits(words, 5, 8, false)
+		if err != nil || len(key) != curve25519.ScalarSize {
+			return nil, fmt.Errorf("identity file %s holds an invalid identity", path)
+		}
+		identities = append(identities, key)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("identity file %s holds no identities", path)
+	}
+	return identities, nil
+}
+
+// specPassphrase is the passphrase of --passphrase-env or --passphrase-fd,
+// read the first time a spec needs it.
+var specPassphrase struct {
+	sync.Mutex
+	value []byte
+}
+
+func agePassphrase() ([]byte, error) {
+	specPassphrase.Lock()
+	defer specPassphrase.Unlock()
+	if specPassphrase.value != nil {
+		return specPassphrase.value, nil
+	}
+	if options.passphraseEnv == "" && options.passphraseFD == "" {
+		return nil, errorWithCode(errDecryptionFailed, "the file is encrypted to a passphrase; give --passphrase-env <name> or --passphrase-fd <n>")
+	}
+	secret, err := readSecret(options.passphraseEnv, options.passphraseFD)
+	if err != nil {
+		return nil, errorWithCode(errDecryptionFailed, "%v", err)
+	}
+	passphrase := bytes.TrimRight(secret, "\r\n")
+	if len(passphrase) == 0 {
+		return nil, errorWithCode(errDecryptionFailed, "the passphrase is empty")
+	}
+	specPassphrase.value = passphrase
+	return passphrase, nil
+}
+
+// decryptWalletSpec decrypts an encrypted wallet spec with the run's
+// --identity or passphrase.
+func decryptWalletSpec(data []byte) ([]byte, error) {
+	var identities [][]byte
+	if options.identity != "" {
+		var err error
+		if identities, err = loadAgeIdentities(options.identity); err != nil {
+			return nil, errorWithCode(errDecryptionFailed, "%v", err)
+		}
+	}
+	return decryptAge(data, identities, agePassphrase)
+}
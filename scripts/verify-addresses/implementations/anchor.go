@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// AnchorReport is the result of checking a transaction's OP_RETURN outputs
+// against an expected commitment.
+type AnchorReport struct {
+	TxID     string         `json:"txid"`
+	Expected string         `json:"expected"`
+	Outputs  []AnchorOutput `json:"outputs"`
+	// Verified is set when some OP_RETURN output commits to exactly the
+	// expected payload.
+	Verified bool `json:"verified"`
+}
+
+// AnchorOutput is one OP_RETURN output and what it carries.
+type AnchorOutput struct {
+	Vout  uint32 `json:"vout"`
+	Value int64  `json:"value"`
+	Data  string `json:"data"`
+	Match bool   `json:"match"`
+}
+
+// anchorHashes are the commitment schemes verify-anchor can derive the
+// expected payload with, from the anchored document.
+var anchorHashes = map[string]func([]byte) []byte{
+	"sha256": func(b []byte) []byte {
+		h := sha256.Sum256(b)
+		return h[:]
+	},
+	"sha256d": chainhash.DoubleHashB,
+}
+
+// anchorPayload resolves the expected payload: hex given directly, or with
+// a hash scheme, the digest of a document (file, or "-" for stdin), in
+// either case after prefix.
+func anchorPayload(payload, scheme, prefix string) ([]byte, error) {
+	expected, err := hex.DecodeString(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix: %v", err)
+	}
+	if scheme == "" {
+		data, err := hex.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload hex: %v", err)
+		}
+		return append(expected, data...), nil
+	}
+
+	hash, ok := anchorHashes[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown commitment hash: %s (want sha256 or sha256d)", scheme)
+	}
+	var doc []byte
+	if payload == "-" {
+		doc, err = io.ReadAll(os.Stdin)
+	} else {
+		doc, err = os.ReadFile(payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read anchored document: %v", err)
+	}
+	return append(expected, hash(doc)...), nil
+}
+
+// parseRawTx decodes a raw transaction given as hex, or a file holding it.
+func parseRawTx(arg string) (*wire.MsgTx, error) {
+	raw := arg
+	if data, err := os.ReadFile(arg); err == nil {
+		raw = string(data)
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hex: %v", err)
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %v", err)
+	}
+	return &tx, nil
+}
+
+// verifyAnchor checks every OP_RETURN output of tx against the expected
+// payload. The data of an output is all of its pushes concatenated.
+func verifyAnchor(tx *wire.MsgTx, expected []byte) (*AnchorReport, error) {
+	report := &AnchorReport{
+		TxID:     tx.TxHash().String(),
+		Expected: hex.EncodeToString(expected),
+		Outputs:  []AnchorOutput{},
+	}
+	for vout, out := range tx.TxOut {
+		if len(out.PkScript) == 0 || out.PkScript[0] != txscript.OP_RETURN {
+			continue
+		}
+		pushes, err := txscript.PushedData(out.PkScript)
+		if err != nil {
+			return nil, fmt.Errorf("output %d has a malformed OP_RETURN script: %v", vout, err)
+		}
+		data := bytes.Join(pushes, nil)
+		match := bytes.Equal(data, expected)
+		report.Outputs = append(report.Outputs, AnchorOutput{
+			Vout:  uint32(vout),
+			Value: out.Value,
+			Data:  hex.EncodeToString(data),
+			Match: match,
+		})
+		report.Verified = report.Verified || match
+	}
+	if len(report.Outputs) == 0 {
+		return nil, fmt.Errorf("transaction %s has no OP_RETURN output", report.TxID)
+	}
+	return report, nil
+}
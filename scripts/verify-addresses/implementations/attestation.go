@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// AttestationReport is the result of checking a device address export.
+type AttestationReport struct {
+	Format    string          `json:"format"`
+	Checks    []AddressCheck  `json:"checks"`
+	Signature *SignatureCheck `json:"signature,omitempty"`
+	Verified  bool            `json:"verified"`
+}
+
+// SignatureCheck describes the signed-message envelope of an attestation.
+type SignatureCheck struct {
+	Address string `json:"address"`
+	Valid   bool   `json:"valid"`
+	Owned   bool   `json:"owned"`
+	// FileHash is set when the signature covers a file digest rather than
+	// the address list itself (Coldcard's detached .sig files).
+	FileHash string `json:"file_hash,omitempty"`
+	HashOK   bool   `json:"hash_ok,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// verifyAttestation checks a device address export against the wallet.
+// Supported inputs are Coldcard address explorer CSV dumps (optionally with
+// the detached .sig file), and signed messages listing "path address" or
+// CSV rows, which is how Jade and other devices attest address lists.
+func verifyAttestation(spec *WalletSpec, dumpPath, sigPath string) (*AttestationReport, error) {
+	dump, err := os.ReadFile(dumpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation: %v", err)
+	}
+
+	report := &AttestationReport{Format: "coldcard-csv", Verified: true}
+	rowsText := string(dump)
+
+	var sm *signedMessage
+	if sigPath != "" {
+		sigData, err := os.ReadFile(sigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature: %v", err)
+		}
+		if sm, err = parseSignedMessage(string(sigData)); err != nil {
+			return nil, err
+		}
+		if sm == nil {
+			return nil, fmt.Errorf("signature file has no signed message")
+		}
+	} else if sm, err = parseSignedMessage(rowsText); err != nil {
+		return nil, err
+	} else if sm != nil {
+		report.Format = "signed-message"
+		rowsText = sm.Message
+	}
+
+	rows, err := parseAttestationRows(rowsText, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("attestation lists no addresses")
+	}
+
+	var verified []string
+	for _, row := range rows {
+		derived, err := spec.deriveAddress(row.Change, row.Index)
+		if err != nil {
+			return nil, err
+		}
+		match := addressesMatch(derived, row.Address)
+		report.Checks = append(report.Checks, AddressCheck{
+			Change:   row.Change,
+			Index:    row.Index,
+			Expected: row.Address,
+			Derived:  derived,
+			Match:    match,
+			Hint:     mismatchHint(match, row.Address),
+		})
+		if match {
+			verified = append(verified, derived)
+		} else {
+			report.Verified = false
+		}
+	}
+
+	if sm != nil {
+		// Scan every verified address rather than look it up, so the time
+		// taken does not depend on which (if any) is the signing address.
+		owned := false
+		for _, address := range verified {
+			owned = addressesMatch(address, sm.Address) || owned
+		}
+		check := &SignatureCheck{Address: sm.Address, Owned: owned}
+		if err := verifySignedMessage(sm, spec.Network); err != nil {
+			check.Error = err.Error()
+		} else {
+			check.Valid = true
+		}
+		if sigPath != "" {
+			sum := sha256.Sum256(dump)
+			check.FileHash = hex.EncodeToString(sum[:])
+			check.HashOK = signedDigestMatches(sm.Message, check.FileHash)
+			if !check.HashOK && check.Error == "" {
+				check.Error = "signed digest does not match the address file"
+			}
+		}
+		report.Signature = check
+		if !check.Valid || !check.Owned || (sigPath != "" && !check.HashOK) {
+			report.Verified = false
+		}
+	}
+
+	return report, nil
+}
+
+// signedDigestMatches reports whether a detached signature message
+// ("<sha256>  <filename>" lines) lists the given digest.
+func signedDigestMatches(message, digest string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// attestationRow is one address claimed by an attestation.
+type attestationRow struct {
+	Change  bool
+	Index   uint32
+	Address string
+}
+
+// parseAttestationRows reads CSV or whitespace-separated rows. Each row
+// needs an address and either a derivation path (".../<change>/<index>")
+// or a bare index (receive chain). Header rows are skipped.
+func parseAttestationRows(text, network string) ([]attestationRow, error) {
+	net := getNetwork(network)
+	var rows []attestationRow
+
+	for lineNo, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields, err := splitAttestationLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("attestation line %d: %v", lineNo+1, err)
+		}
+
+		row := attestationRow{}
+		havePath, haveIndex := false, false
+		for _, f := range fields {
+			f = strings.TrimSuffix(strings.TrimSpace(f), ":")
+			switch {
+			case row.Address == "" && isAddressForNet(f, net):
+				row.Address = f
+			case !havePath && (strings.HasPrefix(f, "m/") || strings.HasPrefix(f, "M/")):
+				change, index, err := pathChainIndex(f)
+				if err != nil {
+					return nil, fmt.Errorf("attestation line %d: %v", lineNo+1, err)
+				}
+				row.Change, row.Index, havePath = change, index, true
+			case !havePath && !haveIndex:
+				if n, err := strconv.ParseUint(f, 10, 31); err == nil {
+					row.Index, haveIndex = uint32(n), true
+				}
+			}
+		}
+
+		if row.Address == "" {
+			if havePath || haveIndex {
+				return nil, fmt.Errorf("attestation line %d: no %s address found", lineNo+1, network)
+			}
+			continue // header
+		}
+		if !havePath && !haveIndex {
+			return nil, fmt.Errorf("attestation line %d: address has no index or derivation path", lineNo+1)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func splitAttestationLine(line string) ([]string, error) {
+	if strings.Contains(line, ",") {
+		r := csv.NewReader(strings.NewReader(line))
+		r.FieldsPerRecord = -1
+		fields, err := r.Read()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return fields, nil
+	}
+	return strings.Fields(line), nil
+}
+
+// isAddressForNet reports whether s is a valid address on net.
+func isAddressForNet(s string, net *chaincfg.Params) bool {
+	addr, err := btcutil.DecodeAddress(s, net)
+	return err == nil && addr.IsForNet(net)
+}
+
+// pathChainIndex returns the last two (unhardened) steps of a path.
+func pathChainIndex(path string) (bool, uint32, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 {
+		return false, 0, fmt.Errorf("derivation path %s is too short", path)
+	}
+	chain, errChain := strconv.ParseUint(parts[len(parts)-2], 10, 31)
+	index, errIndex := strconv.ParseUint(parts[len(parts)-1], 10, 31)
+	if errChain != nil || errIndex != nil || chain > 1 {
+		return false, 0, fmt.Errorf("derivation path %s does not end in /<0|1>/<index>", path)
+	}
+	return chain == 1, uint32(index), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Every request run or watch executes has a request ID: the one the
+// request file gives, or a generated one. It is echoed in the request's
+// result, prefixes the diagnostics written while it runs, and keys its
+// entry in the --audit-log.
+
+// requestID is the ID of the batch request running, if any.
+var requestID string
+
+// newRequestID generates an ID for a request that did not bring one.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "req-" + hex.EncodeToString(b[:])
+}
+
+// AuditEntry is one line of the audit log. Args are as requested, with
+// anything that looks like private key material replaced by "[redacted]".
+// PrevSHA256 is the hash of the previous line, so lines cannot be removed
+// or edited without breaking the chain.
+type AuditEntry struct {
+	Time         string   `json:"time"`
+	RequestID    string   `json:"request_id"`
+	InputSHA256  string   `json:"input_sha256"`
+	Command      string   `json:"command"`
+	Args         []string `json:"args"`
+	OK           bool     `json:"ok"`
+	Code         string   `json:"code,omitempty"`
+	ResultSHA256 string   `json:"result_sha256"`
+	PrevSHA256   string   `json:"prev_sha256"`
+}
+
+// auditLog appends entries to the --audit-log file.
+type auditLog struct {
+	f    *os.File
+	prev string
+}
+
+// openAuditLog opens the configured audit log for appending, or returns nil
+// when there is none. The hash chain continues from the file's last line.
+func openAuditLog() (*auditLog, error) {
+	if options.auditLog == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(options.auditLog, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	log := &auditLog{f: f}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			log.prev = sha256Hex(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// record appends the entry of a request that has run. The line is synced
+// before the next request runs.
+func (l *auditLog) record(id, inputSHA256 string, args []string, result json.RawMessage) error {
+	if l == nil {
+		return nil
+	}
+	var doc struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	json.Unmarshal(result, &doc)
+	redacted := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		redacted[i] = redactArg(arg)
+	}
+	line, err := json.Marshal(AuditEntry{
+		Time:         time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID:    id,
+		InputSHA256:  inputSHA256,
+		Command:      args[0],
+		Args:         redacted,
+		OK:           doc.Error == "",
+		Code:         doc.Code,
+		ResultSHA256: sha256Hex(result),
+		PrevSHA256:   l.prev,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	l.prev = sha256Hex(line)
+	return nil
+}
+
+func (l *auditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// privateKeyPrefixes start the base58 serializations of extended private
+// keys, SLIP-132 variants included.
+var privateKeyPrefixes = []string{"xprv", "tprv", "yprv", "zprv", "uprv", "vprv", "Yprv", "Zprv", "Uprv", "Vprv"}
+
+// redactArg hides an argument carrying an extended private key, or that
+// reads like a mnemonic (twelve or more lower-case words).
+func redactArg(arg string) string {
+	for _, prefix := range privateKeyPrefixes {
+		if strings.Contains(arg, prefix) {
+			return "[redacted]"
+		}
+	}
+	words := strings.Fields(arg)
+	if len(words) >= 12 {
+		mnemonic := true
+		for _, w := range words {
+			mnemonic = mnemonic && strings.Trim(w, "abcdefghijklmnopqrstuvwxyz") == ""
+		}
+		if mnemonic {
+			return "[redacted]"
+		}
+	}
+	return arg
+}
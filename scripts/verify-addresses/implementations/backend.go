@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ChainBackend is a source of chain data. Every feature that needs to know
+// about address usage, balances or UTXOs goes through this interface, so it
+// behaves the same whichever backend is configured.
+type ChainBackend interface {
+	// Name identifies the backend kind ("core", "electrum", ...).
+	Name() string
+
+	// TipHeight returns the height of the best block.
+	TipHeight() (int64, error)
+
+	// AddressHistory lists the transactions touching an address, confirmed
+	// and unconfirmed. An address with no history has never been used.
+	AddressHistory(address string) ([]TxRef, error)
+
+	// AddressUTXOs lists the unspent outputs paying to an address.
+	AddressUTXOs(address string) ([]UTXO, error)
+
+	Close() error
+}
+
+// batchBackend is implemented by backends that can look up many addresses
+// in one round trip. The results are in the order of addresses.
+type batchBackend interface {
+	AddressHistories(addresses []string) ([][]TxRef, error)
+	AddressUTXOSets(addresses []string) ([][]UTXO, error)
+}
+
+// lookupHistories looks up the history of each address, in one batch if
+// the backend supports it. Errors keep their code, such as a consensus
+// backend's backend_disagreement.
+func lookupHistories(backend ChainBackend, addresses []string) ([][]TxRef, error) {
+	if b, ok := backend.(batchBackend); ok && len(addresses) > 1 {
+		histories, err := b.AddressHistories(addresses)
+		if err != nil {
+			return nil, fmt.Errorf("%s lookup of %d addresses failed: %w", backend.Name(), len(addresses), err)
+		}
+		return histories, nil
+	}
+	histories := make([][]TxRef, len(addresses))
+	for i, address := range addresses {
+		history, err := backend.AddressHistory(address)
+		if err != nil {
+			return nil, fmt.Errorf("%s lookup of %s failed: %w", backend.Name(), address, err)
+		}
+		histories[i] = history
+	}
+	return histories, nil
+}
+
+// lookupUTXOs looks up the unspent outputs of each address, in one batch
+// if the backend supports it.
+func lookupUTXOs(backend ChainBackend, addresses []string) ([][]UTXO, error) {
+	if b, ok := backend.(batchBackend); ok && len(addresses) > 1 {
+		sets, err := b.AddressUTXOSets(addresses)
+		if err != nil {
+			return nil, fmt.Errorf("%s lookup of %d addresses failed: %w", backend.Name(), len(addresses), err)
+		}
+		return sets, nil
+	}
+	sets := make([][]UTXO, len(addresses))
+	for i, address := range addresses {
+		utxos, err := backend.AddressUTXOs(address)
+		if err != nil {
+			return nil, fmt.Errorf("%s lookup of %s failed: %w", backend.Name(), address, err)
+		}
+		sets[i] = utxos
+	}
+	return sets, nil
+}
+
+// txBackend is implemented by backends that can fetch a transaction by
+// txid, from the mempool or the chain. Wrappers implement it whatever they
+// wrap, failing with errNoTransactions when the backend underneath cannot.
+type txBackend interface {
+	RawTransaction(txid string) ([]byte, error)
+}
+
+var errNoTransactions = errors.New("the backend cannot fetch transactions")
+
+// rawTransaction fetches a transaction through backend, if it can.
+func rawTransaction(backend ChainBackend, txid string) ([]byte, error) {
+	b, ok := backend.(txBackend)
+	if !ok {
+		return nil, errNoTransactions
+	}
+	return b.RawTransaction(txid)
+}
+
+// feeBackend is implemented by backends that can estimate the fee rate, in
+// sat/kvB, for confirmation within target blocks. mode is "conservative"
+// or "economical"; backends with only one estimate ignore it. Wrappers
+// implement it whatever they wrap, failing with errNoFeeEstimates when the
+// backend underneath cannot.
+type feeBackend interface {
+	FeeRate(target int, mode string) (int64, error)
+}
+
+var errNoFeeEstimates = errors.New("the backend cannot estimate fees")
+
+// backendFeeRate asks backend for a fee rate estimate, if it can.
+func backendFeeRate(backend ChainBackend, target int, mode string) (int64, error) {
+	b, ok := backend.(feeBackend)
+	if !ok {
+		return 0, errNoFeeEstimates
+	}
+	return b.FeeRate(target, mode)
+}
+
+// fetchTransaction fetches and decodes a transaction. A transaction that
+// does not hash to txid is rejected, so the backend need not be trusted
+// for it.
+func fetchTransaction(backend ChainBackend, txid string) (*wire.MsgTx, error) {
+	raw, err := rawTransaction(backend, txid)
+	if err != nil {
+		return nil, err
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("%s returned an undecodable transaction for %s: %v", backend.Name(), txid, err)
+	}
+	if got := tx.TxHash().String(); got != txid {
+		return nil, fmt.Errorf("%s returned transaction %s for %s", backend.Name(), got, txid)
+	}
+	return &tx, nil
+}
+
+// blockBackend is implemented by backends that can fetch a block of the
+// active chain, by height or by hash. Wrappers implement it whatever they
+// wrap, failing with errNoBlocks when the backend underneath cannot.
+type blockBackend interface {
+	BlockHash(height int64) (string, error)
+	RawBlock(hash string) ([]byte, error)
+}
+
+var errNoBlocks = errors.New("the backend cannot fetch blocks")
+
+// backendBlockHash asks backend for the hash of the block at height, if it
+// can.
+func backendBlockHash(backend ChainBackend, height int64) (string, error) {
+	b, ok := backend.(blockBackend)
+	if !ok {
+		return "", errNoBlocks
+	}
+	return b.BlockHash(height)
+}
+
+// rawBlock fetches a block through backend, if it can.
+func rawBlock(backend ChainBackend, hash string) ([]byte, error) {
+	b, ok := backend.(blockBackend)
+	if !ok {
+		return nil, errNoBlocks
+	}
+	return b.RawBlock(hash)
+}
+
+// fetchBlock fetches and decodes a block. A block whose header does not
+// hash to hash, or whose transactions do not match its merkle root, is
+// rejected, so the backend need not be trusted for it.
+func fetchBlock(backend ChainBackend, hash string) (*wire.MsgBlock, error) {
+	raw, err := rawBlock(backend, hash)
+	if err != nil {
+		return nil, err
+	}
+	var block wire.MsgBlock
+	if err := block.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("%s returned an undecodable block for %s: %v", backend.Name(), hash, err)
+	}
+	if got := block.BlockHash().String(); got != hash {
+		return nil, fmt.Errorf("%s returned block %s for %s", backend.Name(), got, hash)
+	}
+	if root := merkleRoot(block.Transactions); root != block.Header.MerkleRoot {
+		return nil, fmt.Errorf("%s returned block %s with transactions that do not match its merkle root", backend.Name(), hash)
+	}
+	return &block, nil
+}
+
+// merkleRoot computes the merkle root of txs by txid.
+func merkleRoot(txs []*wire.MsgTx) chainhash.Hash {
+	if len(txs) == 0 {
+		return chainhash.Hash{}
+	}
+	level := make([]chainhash.Hash, len(txs))
+	for i, tx := range txs {
+		level[i] = tx.TxHash()
+	}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]chainhash.Hash, len(level)/2)
+		for i := range next {
+			next[i] = chainhash.DoubleHashH(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// TxRef is one transaction in an address history. Height is 0 for
+// mempool transactions.
+type TxRef struct {
+	TxID   string `json:"txid"`
+	Height int64  `json:"height"`
+}
+
+// UTXO is an unspent output. Height is 0 while unconfirmed.
+type UTXO struct {
+	TxID    string `json:"txid"`
+	Vout    uint32 `json:"vout"`
+	Value   int64  `json:"value"`
+	Height  int64  `json:"height"`
+	Address string `json:"address"`
+}
+
+// BackendConfig selects and configures the chain backend. It is read from
+// the --config file, or from $VERIFY_ADDRESSES_CONFIG; without either, the
+// Core backend is used with the BITCOIN_RPC_* variables shared with the
+// TypeScript tooling.
+type BackendConfig struct {
+	Backend   string          `json:"backend"`
+	Core      CoreConfig      `json:"core"`
+	Electrum  ElectrumConfig  `json:"electrum"`
+	Esplora   EsploraConfig   `json:"esplora"`
+	Neutrino  NeutrinoConfig  `json:"neutrino"`
+	Replay    ReplayConfig    `json:"replay"`
+	Consensus ConsensusConfig `json:"consensus"`
+	// Record names a fixture file the backend's responses are saved to,
+	// for the replay backend to serve.
+	Record string `json:"record,omitempty"`
+	// Networks lists the networks backends may be opened for; empty
+	// allows every network.
+	Networks  []string  `json:"networks,omitempty"`
+	RateLimit RateLimit `json:"rate_limit,omitempty"`
+	// Webhooks are posted monitor events; see webhook.go.
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+}
+
+// backendFactories builds a backend from its config section. Backends
+// register themselves from init in their backend_*.go file.
+var backendFactories = map[string]func(cfg *BackendConfig, network string) (ChainBackend, error){}
+
+// unavailableBackends names backends this build knows of but cannot open,
+// with the reason.
+var unavailableBackends = map[string]string{}
+
+// localBackends names backends that serve chain data without network
+// access, so they stay usable offline.
+var localBackends = map[string]bool{}
+
+func registerBackend(name string, factory func(cfg *BackendConfig, network string) (ChainBackend, error)) {
+	if _, dup := backendFactories[name]; dup {
+		panic("duplicate chain backend: " + name)
+	}
+	backendFactories[name] = factory
+}
+
+func backendNames() []string {
+	var names []string
+	for name := range backendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadBackendConfig reads the backend config from path, falling back to
+// $VERIFY_ADDRESSES_CONFIG and then to environment defaults. Under watch,
+// requests use the config watch has loaded instead.
+func loadBackendConfig(path string) (*BackendConfig, error) {
+	if watchedConfig != nil {
+		cfg := *watchedConfig
+		return &cfg, nil
+	}
+	return readBackendConfig(path)
+}
+
+func readBackendConfig(path string) (*BackendConfig, error) {
+	if path == "" {
+		path = os.Getenv("VERIFY_ADDRESSES_CONFIG")
+	}
+	cfg := &BackendConfig{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %v", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %v", err)
+		}
+	}
+	for _, n := range cfg.Networks {
+		if err := checkNetwork(n); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.RateLimit.check(); err != nil {
+		return nil, err
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = "core"
+	}
+	cfg.Core.applyEnvDefaults()
+	return cfg, nil
+}
+
+// allowNetwork fails for a network the config does not list.
+func (c *BackendConfig) allowNetwork(network string) error {
+	if len(c.Networks) == 0 {
+		return nil
+	}
+	for _, n := range c.Networks {
+		if n == network {
+			return nil
+		}
+	}
+	return errorWithCode(errInvalidNetwork, "the backend config does not allow %s (networks: %s)", network, strings.Join(c.Networks, ", "))
+}
+
+// backendFactory returns the factory of the configured backend, if this
+// build and mode can open it.
+func backendFactory(cfg *BackendConfig) (func(cfg *BackendConfig, network string) (ChainBackend, error), error) {
+	if !localBackends[cfg.Backend] {
+		if err := requireOnline("the " + cfg.Backend + " backend"); err != nil {
+			return nil, err
+		}
+	}
+	if reason, ok := unavailableBackends[cfg.Backend]; ok {
+		return nil, fmt.Errorf("%s backend is not available: %s", cfg.Backend, reason)
+	}
+	factory, ok := backendFactories[cfg.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain backend: %q (available: %v)", cfg.Backend, backendNames())
+	}
+	return factory, nil
+}
+
+// openBackend builds the configured backend for a network.
+func openBackend(cfg *BackendConfig, network string) (ChainBackend, error) {
+	if err := cfg.allowNetwork(network); err != nil {
+		return nil, err
+	}
+	factory, err := backendFactory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := openRetrying(cfg.Backend, cfg.retryPolicy(), func() (ChainBackend, error) {
+		backend, err := factory(cfg, network)
+		if err != nil {
+			return nil, err
+		}
+		return rateLimitBackend(backend, cfg.RateLimit), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Record == "" {
+		return traceBackend(backend), nil
+	}
+	recording, err := newRecordingBackend(backend, cfg.Record, network)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return traceBackend(recording), nil
+}
+
+// retryPolicy returns the retry policy of the configured backend.
+func (c *BackendConfig) retryPolicy() RetryPolicy {
+	switch c.Backend {
+	case "core":
+		return c.Core.Retry
+	case "electrum":
+		return c.Electrum.Retry
+	case "esplora":
+		return c.Esplora.Retry
+	case "neutrino":
+		return c.Neutrino.Retry
+	}
+	return RetryPolicy{}
+}
+
+// openConfiguredBackend loads the config named by the global --config flag
+// and opens its backend.
+func openConfiguredBackend(network string) (ChainBackend, error) {
+	cfg, err := loadBackendConfig(options.configPath)
+	if err != nil {
+		return nil, err
+	}
+	return openBackend(cfg, network)
+}
+
+// addressScript returns the scriptPubKey an address pays to. P2PK and bare
+// multisig outputs, which have no address, are given as their scriptPubKey
+// in hex.
+func addressScript(address, network string) ([]byte, error) {
+	if script, err := hex.DecodeString(address); err == nil {
+		switch txscript.GetScriptClass(script) {
+		case txscript.PubKeyTy, txscript.MultiSigTy:
+			return script, nil
+		}
+	}
+	addr, err := btcutil.DecodeAddress(address, getNetwork(network))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", address, addressError(err))
+	}
+	return txscript.PayToAddrScript(addr)
+}
+
+// electrumScriptHash is the Electrum protocol's address key: the reversed
+// SHA-256 of the scriptPubKey, in hex.
+func electrumScriptHash(script []byte) string {
+	h := chainhash.HashB(script)
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+	return hex.EncodeToString(h)
+}
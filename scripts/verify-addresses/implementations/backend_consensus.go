@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// The consensus backend asks two or more independent backends every
+// question and only answers with a value enough of them agree on, so a
+// single lying or lagging server cannot hide funds or invent history.
+
+// ConsensusConfig configures the consensus backend. Backends names the
+// member backends, each configured by its own section; Quorum is how many
+// must agree (default all of them).
+type ConsensusConfig struct {
+	Backends []string `json:"backends"`
+	Quorum   int      `json:"quorum,omitempty"`
+}
+
+// consensusTipSlack is how many blocks apart member tips may be and still
+// agree, as servers see new blocks at slightly different times. The lowest
+// of agreeing tips is reported.
+const consensusTipSlack = 2
+
+type consensusBackend struct {
+	members []ChainBackend
+	quorum  int
+}
+
+func init() {
+	registerBackend("consensus", func(cfg *BackendConfig, network string) (ChainBackend, error) {
+		names := cfg.Consensus.Backends
+		if len(names) < 2 {
+			return nil, fmt.Errorf("consensus backend needs at least two consensus.backends")
+		}
+		quorum := cfg.Consensus.Quorum
+		if quorum == 0 {
+			quorum = len(names)
+		}
+		if quorum < 2 || quorum > len(names) {
+			return nil, fmt.Errorf("invalid consensus.quorum %d: want 2 to %d", quorum, len(names))
+		}
+
+		b := &consensusBackend{quorum: quorum}
+		seen := map[string]bool{}
+		for _, name := range names {
+			if name == "consensus" || seen[name] {
+				b.Close()
+				return nil, fmt.Errorf("consensus.backends must name distinct backends other than consensus")
+			}
+			seen[name] = true
+			member := *cfg
+			member.Backend, member.Record = name, ""
+			backend, err := openBackend(&member, network)
+			if err != nil {
+				b.Close()
+				return nil, fmt.Errorf("consensus member %s: %v", name, err)
+			}
+			b.members = append(b.members, backend)
+		}
+		return b, nil
+	})
+}
+
+func (b *consensusBackend) Name() string { return "consensus" }
+
+func (b *consensusBackend) Close() error {
+	var first error
+	for _, m := range b.members {
+		if err := m.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// agreement groups the members by the canonical form of their answer and
+// returns the index of a member whose answer has the quorum. Dissenting
+// members are reported on stderr.
+func (b *consensusBackend) agreement(what string, answers []string) (int, error) {
+	groups := map[string][]int{}
+	var order []string
+	for i, a := range answers {
+		if _, ok := groups[a]; !ok {
+			order = append(order, a)
+		}
+		groups[a] = append(groups[a], i)
+	}
+	if len(groups) == 1 {
+		return 0, nil
+	}
+
+	var summary []string
+	winner := -1
+	for _, a := range order {
+		var names []string
+		for _, i := range groups[a] {
+			names = append(names, b.members[i].Name())
+		}
+		summary = append(summary, strings.Join(names, ", ")+": "+a)
+		if len(groups[a]) >= b.quorum {
+			winner = groups[a][0]
+		}
+	}
+	if winner < 0 {
+		return 0, errorWithCode(errBackendDisagreement, "backends disagree on %s (%s)", what, strings.Join(summary, "; "))
+	}
+	diagnostic("backends disagree on %s, using the quorum answer (%s)", what, strings.Join(summary, "; "))
+	return winner, nil
+}
+
+func (b *consensusBackend) TipHeight() (int64, error) {
+	tips := make([]int64, len(b.members))
+	for i, m := range b.members {
+		tip, err := m.TipHeight()
+		if err != nil {
+			return 0, fmt.Errorf("consensus member %s: %v", m.Name(), err)
+		}
+		tips[i] = tip
+	}
+	// Each member answers the lowest tip within the slack of its own.
+	agreed := make([]int64, len(tips))
+	answers := make([]string, len(tips))
+	for i, tip := range tips {
+		agreed[i] = tip
+		for _, t := range tips {
+			if t < agreed[i] && tip-t <= consensusTipSlack {
+				agreed[i] = t
+			}
+		}
+		answers[i] = fmt.Sprint(agreed[i])
+	}
+	i, err := b.agreement("the tip height", answers)
+	if err != nil {
+		return 0, err
+	}
+	return agreed[i], nil
+}
+
+func (b *consensusBackend) AddressHistory(address string) ([]TxRef, error) {
+	histories, err := b.AddressHistories([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return histories[0], nil
+}
+
+func (b *consensusBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	sets, err := b.AddressUTXOSets([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return sets[0], nil
+}
+
+// RawTransaction needs no agreement: a transaction is checked against its
+// txid, so the first member that returns one that hashes to it is enough.
+func (b *consensusBackend) RawTransaction(txid string) ([]byte, error) {
+	err := errNoTransactions
+	for _, m := range b.members {
+		var tx *wire.MsgTx
+		if tx, err = fetchTransaction(m, txid); err == nil {
+			var buf bytes.Buffer
+			if err = tx.Serialize(&buf); err == nil {
+				return buf.Bytes(), nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// BlockHash asks every member, as TipHeight does: a member on a different
+// chain tip answers a different hash for the same height.
+func (b *consensusBackend) BlockHash(height int64) (string, error) {
+	hashes := make([]string, len(b.members))
+	for i, m := range b.members {
+		hash, err := backendBlockHash(m, height)
+		if err != nil {
+			return "", fmt.Errorf("consensus member %s: %v", m.Name(), err)
+		}
+		hashes[i] = hash
+	}
+	i, err := b.agreement(fmt.Sprintf("the block hash at height %d", height), hashes)
+	if err != nil {
+		return "", err
+	}
+	return hashes[i], nil
+}
+
+// RawBlock needs no agreement, as a block is checked against its hash and
+// merkle root. Members that cannot fetch blocks are skipped.
+func (b *consensusBackend) RawBlock(hash string) ([]byte, error) {
+	err := errNoBlocks
+	for _, m := range b.members {
+		var block *wire.MsgBlock
+		if block, err = fetchBlock(m, hash); err == nil {
+			var buf bytes.Buffer
+			if err = block.Serialize(&buf); err == nil {
+				return buf.Bytes(), nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// FeeRate needs no agreement either, as estimates always differ: it takes
+// the highest of the members that give one, so the fee is enough by every
+// member's reckoning.
+func (b *consensusBackend) FeeRate(target int, mode string) (int64, error) {
+	best, err := int64(0), errNoFeeEstimates
+	for _, m := range b.members {
+		rate, merr := backendFeeRate(m, target, mode)
+		if merr != nil {
+			if best == 0 && !errors.Is(merr, errNoFeeEstimates) {
+				err = fmt.Errorf("consensus member %s: %v", m.Name(), merr)
+			}
+			continue
+		}
+		best = max(best, rate)
+	}
+	if best == 0 {
+		return 0, err
+	}
+	return best, nil
+}
+
+func (b *consensusBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	answers := make([][][]TxRef, len(b.members))
+	for i, m := range b.members {
+		histories, err := lookupHistories(m, addresses)
+		if err != nil {
+			return nil, fmt.Errorf("consensus member %s: %v", m.Name(), err)
+		}
+		answers[i] = histories
+	}
+	result := make([][]TxRef, len(addresses))
+	for j, address := range addresses {
+		canonical := make([]string, len(b.members))
+		for i := range b.members {
+			refs := append([]TxRef{}, answers[i][j]...)
+			sort.Slice(refs, func(x, y int) bool { return refs[x].TxID < refs[y].TxID })
+			canonical[i] = canonicalJSON(refs)
+		}
+		i, err := b.agreement("the history of "+address, canonical)
+		if err != nil {
+			return nil, err
+		}
+		result[j] = answers[i][j]
+	}
+	return result, nil
+}
+
+func (b *consensusBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	answers := make([][][]UTXO, len(b.members))
+	for i, m := range b.members {
+		sets, err := lookupUTXOs(m, addresses)
+		if err != nil {
+			return nil, fmt.Errorf("consensus member %s: %v", m.Name(), err)
+		}
+		answers[i] = sets
+	}
+	result := make([][]UTXO, len(addresses))
+	for j, address := range addresses {
+		canonical := make([]string, len(b.members))
+		for i := range b.members {
+			utxos := append([]UTXO{}, answers[i][j]...)
+			sort.Slice(utxos, func(x, y int) bool {
+				if utxos[x].TxID != utxos[y].TxID {
+					return utxos[x].TxID < utxos[y].TxID
+				}
+				return utxos[x].Vout < utxos[y].Vout
+			})
+			canonical[i] = canonicalJSON(utxos)
+		}
+		i, err := b.agreement("the UTXOs of "+address, canonical)
+		if err != nil {
+			return nil, err
+		}
+		result[j] = answers[i][j]
+	}
+	return result, nil
+}
+
+// canonicalJSON renders a sorted answer for comparison.
+func canonicalJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// CoreConfig configures the Bitcoin Core JSON-RPC backend. Wallet names a
+// watch-only wallet holding the spec's descriptors (see provision-core);
+// without it only UTXO scans via scantxoutset are possible.
+type CoreConfig struct {
+	URL        string      `json:"url"`
+	User       string      `json:"user"`
+	Pass       string      `json:"pass"`
+	CookieFile string      `json:"cookie_file,omitempty"`
+	Wallet     string      `json:"wallet,omitempty"`
+	Retry      RetryPolicy `json:"retry,omitempty"`
+	// ZMQRawTx and ZMQRawBlock are the node's -zmqpubrawtx and
+	// -zmqpubrawblock endpoints, which monitor subscribes to.
+	ZMQRawTx    string `json:"zmq_rawtx,omitempty"`
+	ZMQRawBlock string `json:"zmq_rawblock,omitempty"`
+}
+
+// applyEnvDefaults fills unset fields from the BITCOIN_RPC_* environment,
+// matching the defaults of the TypeScript Core wrapper.
+func (c *CoreConfig) applyEnvDefaults() {
+	defaults := []struct {
+		field *string
+		env   string
+		def   string
+	}{
+		{&c.URL, "BITCOIN_RPC_URL", "http://127.0.0.1:18443"},
+		{&c.User, "BITCOIN_RPC_USER", "verify"},
+		{&c.Pass, "BITCOIN_RPC_PASS", "verify"},
+	}
+	for _, d := range defaults {
+		if *d.field != "" {
+			continue
+		}
+		if v := os.Getenv(d.env); v != "" {
+			*d.field = v
+		} else {
+			*d.field = d.def
+		}
+	}
+}
+
+// rpcInWarmup is Core's error code while it is still loading at startup.
+const rpcInWarmup = -28
+
+type coreBackend struct {
+	cfg     CoreConfig
+	client  *http.Client
+	network string
+	// chain is the node's own network, used to re-encode addresses for
+	// wallet RPCs (a regtest node rejects tb1 addresses).
+	chain *chaincfg.Params
+}
+
+func init() {
+	registerBackend("core", func(cfg *BackendConfig, network string) (ChainBackend, error) {
+		return newCoreBackend(cfg, network)
+	})
+}
+
+// newCoreBackend connects to the configured node and checks that it is on
+// the wallet's network.
+func newCoreBackend(cfg *BackendConfig, network string) (*coreBackend, error) {
+	if err := requireOnline("the core backend"); err != nil {
+		return nil, err
+	}
+	if err := checkRouteURL(cfg.Core.URL); err != nil {
+		return nil, err
+	}
+	b := &coreBackend{cfg: cfg.Core, client: newHTTPClient(5 * time.Minute), network: network}
+	if cfg.Core.CookieFile != "" {
+		cookie, err := os.ReadFile(cfg.Core.CookieFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RPC cookie: %v", err)
+		}
+		user, pass, ok := strings.Cut(strings.TrimSpace(string(cookie)), ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed RPC cookie file")
+		}
+		b.cfg.User, b.cfg.Pass = user, pass
+	}
+
+	var info struct {
+		Chain string `json:"chain"`
+	}
+	if err := b.call("", "getblockchaininfo", nil, &info); err != nil {
+		return nil, err
+	}
+	switch info.Chain {
+	case "main":
+		b.chain = &chaincfg.MainNetParams
+	case "regtest":
+		b.chain = &chaincfg.RegressionNetParams
+	case "signet":
+		b.chain = &chaincfg.SigNetParams
+	default:
+		b.chain = &chaincfg.TestNet3Params
+	}
+	if (info.Chain == "main") != (network == "mainnet") {
+		return nil, fmt.Errorf("Core node is on %s, wallet is %s", info.Chain, network)
+	}
+	return b, nil
+}
+
+func (b *coreBackend) Name() string { return "core" }
+
+func (b *coreBackend) probe() (string, *bool, error) {
+	var network struct {
+		Subversion string `json:"subversion"`
+	}
+	if err := b.call("", "getnetworkinfo", nil, &network); err != nil {
+		return "", nil, err
+	}
+	var chain struct {
+		Pruned bool `json:"pruned"`
+	}
+	if err := b.call("", "getblockchaininfo", nil, &chain); err != nil {
+		return "", nil, err
+	}
+	return network.Subversion, &chain.Pruned, nil
+}
+
+func (b *coreBackend) Close() error { return nil }
+
+func (b *coreBackend) call(wallet, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      "verify",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(b.cfg.URL, "/")
+	if wallet != "" {
+		url += "/wallet/" + wallet
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(b.cfg.User, b.cfg.Pass)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return transient(fmt.Errorf("RPC request failed: %v", err))
+	}
+	defer resp.Body.Close()
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		err = fmt.Errorf("RPC request failed: %s", resp.Status)
+		if resp.StatusCode >= 500 {
+			// Core answers 503 when its RPC work queue is full.
+			err = transient(err)
+		}
+		return err
+	}
+	if rpcResp.Error != nil {
+		err := fmt.Errorf("RPC error: %s (code: %d)", rpcResp.Error.Message, rpcResp.Error.Code)
+		if rpcResp.Error.Code == rpcInWarmup {
+			err = transient(err)
+		}
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+func (b *coreBackend) TipHeight() (int64, error) {
+	var height int64
+	err := b.call("", "getblockcount", nil, &height)
+	return height, err
+}
+
+// RawTransaction asks the watch-only wallet first, which knows its own
+// transactions without -txindex, then the node.
+func (b *coreBackend) RawTransaction(txid string) ([]byte, error) {
+	if b.cfg.Wallet != "" {
+		var tx struct {
+			Hex string `json:"hex"`
+		}
+		if err := b.call(b.cfg.Wallet, "gettransaction", []interface{}{txid, true}, &tx); err == nil {
+			return hex.DecodeString(tx.Hex)
+		}
+	}
+	var raw string
+	if err := b.call("", "getrawtransaction", []interface{}{txid, false}, &raw); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(raw)
+}
+
+func (b *coreBackend) BlockHash(height int64) (string, error) {
+	var hash string
+	err := b.call("", "getblockhash", []interface{}{height}, &hash)
+	return hash, err
+}
+
+// RawBlock asks getblock at verbosity 0, which answers in hex. A pruned
+// node fails for blocks it no longer keeps.
+func (b *coreBackend) RawBlock(hash string) ([]byte, error) {
+	var raw string
+	if err := b.call("", "getblock", []interface{}{hash, 0}, &raw); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(raw)
+}
+
+// FeeRate asks estimatesmartfee, which answers in BTC/kvB.
+func (b *coreBackend) FeeRate(target int, mode string) (int64, error) {
+	var estimate struct {
+		FeeRate *float64 `json:"feerate"`
+		Errors  []string `json:"errors"`
+	}
+	if err := b.call("", "estimatesmartfee", []interface{}{target, mode}, &estimate); err != nil {
+		return 0, err
+	}
+	if estimate.FeeRate == nil {
+		return 0, fmt.Errorf("Core has no fee estimate for %d blocks: %s", target, strings.Join(estimate.Errors, "; "))
+	}
+	return btcToSats(*estimate.FeeRate), nil
+}
+
+// nodeAddress re-encodes an address for the node's own chain.
+func (b *coreBackend) nodeAddress(address string) (string, error) {
+	script, err := addressScript(address, b.network)
+	if err != nil {
+		return "", err
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, b.chain)
+	if err != nil || len(addrs) != 1 {
+		return "", fmt.Errorf("cannot encode %s for %s", address, b.chain.Name)
+	}
+	return addrs[0].EncodeAddress(), nil
+}
+
+func (b *coreBackend) AddressHistory(address string) ([]TxRef, error) {
+	if b.cfg.Wallet == "" {
+		return nil, fmt.Errorf("Core backend needs a watch-only wallet for address history (set core.wallet, see provision-core)")
+	}
+	nodeAddr, err := b.nodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var received []struct {
+		TxIDs []string `json:"txids"`
+	}
+	if err := b.call(b.cfg.Wallet, "listreceivedbyaddress", []interface{}{0, true, true, nodeAddr}, &received); err != nil {
+		return nil, err
+	}
+
+	refs := []TxRef{}
+	for _, r := range received {
+		for _, txid := range r.TxIDs {
+			var tx struct {
+				BlockHeight int64 `json:"blockheight"`
+			}
+			if err := b.call(b.cfg.Wallet, "gettransaction", []interface{}{txid, true}, &tx); err != nil {
+				return nil, err
+			}
+			refs = append(refs, TxRef{TxID: txid, Height: tx.BlockHeight})
+		}
+	}
+	return refs, nil
+}
+
+func (b *coreBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	utxos := []UTXO{}
+
+	if b.cfg.Wallet != "" {
+		nodeAddr, err := b.nodeAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		tip, err := b.TipHeight()
+		if err != nil {
+			return nil, err
+		}
+		var unspent []struct {
+			TxID          string  `json:"txid"`
+			Vout          uint32  `json:"vout"`
+			Amount        float64 `json:"amount"`
+			Confirmations int64   `json:"confirmations"`
+		}
+		if err := b.call(b.cfg.Wallet, "listunspent", []interface{}{0, 9999999, []string{nodeAddr}}, &unspent); err != nil {
+			return nil, err
+		}
+		for _, u := range unspent {
+			height := int64(0)
+			if u.Confirmations > 0 {
+				height = tip - u.Confirmations + 1
+			}
+			utxos = append(utxos, UTXO{TxID: u.TxID, Vout: u.Vout, Value: btcToSats(u.Amount), Height: height, Address: address})
+		}
+		return utxos, nil
+	}
+
+	// Without a wallet, scan the UTXO set for the raw script (confirmed
+	// outputs only). raw() keeps this independent of the node's address
+	// encoding.
+	script, err := addressScript(address, b.network)
+	if err != nil {
+		return nil, err
+	}
+	var scan struct {
+		Unspents []struct {
+			TxID   string  `json:"txid"`
+			Vout   uint32  `json:"vout"`
+			Amount float64 `json:"amount"`
+			Height int64   `json:"height"`
+		} `json:"unspents"`
+	}
+	desc := []map[string]string{{"desc": "raw(" + hex.EncodeToString(script) + ")"}}
+	if err := b.call("", "scantxoutset", []interface{}{"start", desc}, &scan); err != nil {
+		return nil, err
+	}
+	for _, u := range scan.Unspents {
+		utxos = append(utxos, UTXO{TxID: u.TxID, Vout: u.Vout, Value: btcToSats(u.Amount), Height: u.Height, Address: address})
+	}
+	return utxos, nil
+}
+
+func btcToSats(amount float64) int64 {
+	return int64(math.Round(amount * 1e8))
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ElectrumConfig configures an Electrum protocol server (ElectrumX, Fulcrum,
+// electrs). Server is "host:port".
+type ElectrumConfig struct {
+	Server string `json:"server"`
+	TLS    bool   `json:"tls,omitempty"`
+	// Insecure skips TLS certificate verification, for self-signed
+	// personal servers.
+	Insecure bool `json:"insecure,omitempty"`
+	// CertSHA256 and SPKISHA256 pin the server's certificate, or its
+	// public key (which survives renewal with the same key), by SHA-256 in
+	// hex. A pinned server is trusted on the pin alone, so self-signed
+	// certificates work, and any other certificate is refused.
+	CertSHA256 string `json:"cert_sha256,omitempty"`
+	SPKISHA256 string `json:"spki_sha256,omitempty"`
+	// Pipeline is how many requests of a batch are written before their
+	// responses are read (default 100; 1 sends them one at a time).
+	Pipeline int         `json:"pipeline,omitempty"`
+	Retry    RetryPolicy `json:"retry,omitempty"`
+}
+
+// defaultElectrumPipeline bounds the requests in flight on the connection,
+// well under the per-session limits public servers enforce.
+const defaultElectrumPipeline = 100
+
+type electrumBackend struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	nextID   int
+	network  string
+	pipeline int
+	// version is the server software and protocol version it reported.
+	version string
+}
+
+func init() {
+	registerBackend("electrum", func(cfg *BackendConfig, network string) (ChainBackend, error) {
+		if cfg.Electrum.Server == "" {
+			return nil, fmt.Errorf("electrum backend needs electrum.server")
+		}
+		host, _, err := net.SplitHostPort(cfg.Electrum.Server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid electrum.server %q: %v", cfg.Electrum.Server, err)
+		}
+		if err := checkRoute(host); err != nil {
+			return nil, err
+		}
+		tlsConfig, err := electrumTLSConfig(cfg.Electrum, host)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialBackend(context.Background(), cfg.Electrum.Server, 30*time.Second)
+		if err == nil && cfg.Electrum.TLS {
+			tlsConn := tls.Client(conn, tlsConfig)
+			tlsConn.SetDeadline(time.Now().Add(30 * time.Second))
+			if err = tlsConn.Handshake(); err != nil {
+				conn.Close()
+				// Retrying cannot fix a certificate that is not the pinned one.
+				if errorCode(err) == errCertificateMismatch {
+					return nil, err
+				}
+			}
+			conn = tlsConn
+		}
+		if err != nil {
+			return nil, transient(fmt.Errorf("failed to connect to Electrum server: %v", err))
+		}
+
+		b := &electrumBackend{conn: conn, reader: bufio.NewReader(conn), network: network, pipeline: cfg.Electrum.Pipeline}
+		if b.pipeline < 1 {
+			b.pipeline = defaultElectrumPipeline
+		}
+		var version []string
+		if err := b.call("server.version", []interface{}{"verify-addresses", "1.4"}, &version); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if len(version) == 2 {
+			b.version = version[0] + " (protocol " + version[1] + ")"
+		}
+		return b, nil
+	})
+}
+
+// electrumTLSConfig builds the TLS config for the server, with any pins.
+// Pins fail closed: a pinned server must use TLS, and a malformed pin is an
+// error rather than ignored.
+func electrumTLSConfig(cfg ElectrumConfig, host string) (*tls.Config, error) {
+	c := &tls.Config{ServerName: host, InsecureSkipVerify: cfg.Insecure}
+	if cfg.CertSHA256 == "" && cfg.SPKISHA256 == "" {
+		return c, nil
+	}
+	if !cfg.TLS {
+		return nil, fmt.Errorf("electrum certificate pins need electrum.tls")
+	}
+	var certPin, spkiPin []byte
+	for _, p := range []struct {
+		name  string
+		value string
+		pin   *[]byte
+	}{
+		{"cert_sha256", cfg.CertSHA256, &certPin},
+		{"spki_sha256", cfg.SPKISHA256, &spkiPin},
+	} {
+		if p.value == "" {
+			continue
+		}
+		// openssl prints fingerprints as colon-separated hex.
+		pin, err := hex.DecodeString(strings.ReplaceAll(p.value, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid electrum.%s: want a SHA-256 in hex", p.name)
+		}
+		*p.pin = pin
+	}
+
+	// The pin replaces CA verification, which self-signed personal
+	// servers would fail.
+	c.InsecureSkipVerify = true
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errorWithCode(errCertificateMismatch, "Electrum server sent no certificate")
+		}
+		leaf := cs.PeerCertificates[0]
+		certHash := sha256.Sum256(leaf.Raw)
+		spkiHash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		if (certPin != nil && subtle.ConstantTimeCompare(certHash[:], certPin) != 1) ||
+			(spkiPin != nil && subtle.ConstantTimeCompare(spkiHash[:], spkiPin) != 1) {
+			return errorWithCode(errCertificateMismatch, "Electrum server certificate does not match the pin (cert_sha256 %x, spki_sha256 %x)", certHash, spkiHash)
+		}
+		return nil
+	}
+	return c, nil
+}
+
+func (b *electrumBackend) Name() string { return "electrum" }
+
+func (b *electrumBackend) probe() (string, *bool, error) {
+	var features struct {
+		Pruning *int64 `json:"pruning"`
+	}
+	if err := b.call("server.features", []interface{}{}, &features); err != nil {
+		return "", nil, err
+	}
+	pruned := features.Pruning != nil
+	return b.version, &pruned, nil
+}
+
+func (b *electrumBackend) Close() error { return b.conn.Close() }
+
+// call sends one request and waits for its response.
+func (b *electrumBackend) call(method string, params []interface{}, result interface{}) error {
+	return b.callPipelined(method, [][]interface{}{params}, []interface{}{result})
+}
+
+// callBatch makes one call per params entry, decoding each response into
+// the matching results entry, pipelining up to b.pipeline calls at a time.
+func (b *electrumBackend) callBatch(method string, params [][]interface{}, results []interface{}) error {
+	for start := 0; start < len(params); start += b.pipeline {
+		end := min(start+b.pipeline, len(params))
+		if err := b.callPipelined(method, params[start:end], results[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callPipelined writes all requests at once and then reads responses,
+// which servers may return in any order, matching them by id. Subscription
+// notifications the server interleaves, and stale responses to an earlier
+// failed batch, are skipped.
+func (b *electrumBackend) callPipelined(method string, params [][]interface{}, results []interface{}) error {
+	pending := map[int]int{}
+	var reqs []byte
+	for i, p := range params {
+		b.nextID++
+		pending[b.nextID] = i
+		req, err := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      b.nextID,
+			"method":  method,
+			"params":  p,
+		})
+		if err != nil {
+			return err
+		}
+		reqs = append(append(reqs, req...), '\n')
+	}
+	b.conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	if _, err := b.conn.Write(reqs); err != nil {
+		return transient(fmt.Errorf("Electrum request failed: %v", err))
+	}
+
+	for len(pending) > 0 {
+		line, err := b.reader.ReadBytes('\n')
+		if err != nil {
+			return transient(fmt.Errorf("Electrum request failed: %v", err))
+		}
+		var resp struct {
+			ID     *int            `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			// The stream has lost its framing; only a new connection
+			// recovers.
+			return transient(fmt.Errorf("invalid Electrum response: %v", err))
+		}
+		if resp.ID == nil {
+			continue
+		}
+		i, ok := pending[*resp.ID]
+		if !ok {
+			continue
+		}
+		delete(pending, *resp.ID)
+		if resp.Error != nil {
+			return fmt.Errorf("Electrum error: %s (code: %d)", resp.Error.Message, resp.Error.Code)
+		}
+		if results[i] != nil {
+			if err := json.Unmarshal(resp.Result, results[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *electrumBackend) scriptHash(address string) (string, error) {
+	script, err := addressScript(address, b.network)
+	if err != nil {
+		return "", err
+	}
+	return electrumScriptHash(script), nil
+}
+
+func (b *electrumBackend) TipHeight() (int64, error) {
+	var header struct {
+		Height int64 `json:"height"`
+	}
+	err := b.call("blockchain.headers.subscribe", []interface{}{}, &header)
+	return header.Height, err
+}
+
+// electrumHistory is one entry of blockchain.scripthash.get_history.
+type electrumHistory struct {
+	TxHash string `json:"tx_hash"`
+	Height int64  `json:"height"`
+}
+
+// electrumUnspent is one entry of blockchain.scripthash.listunspent.
+type electrumUnspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height int64  `json:"height"`
+	Value  int64  `json:"value"`
+}
+
+// scriptHashParams builds one params list per address for the
+// blockchain.scripthash methods.
+func (b *electrumBackend) scriptHashParams(addresses []string) ([][]interface{}, error) {
+	params := make([][]interface{}, len(addresses))
+	for i, address := range addresses {
+		sh, err := b.scriptHash(address)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = []interface{}{sh}
+	}
+	return params, nil
+}
+
+func (b *electrumBackend) RawTransaction(txid string) ([]byte, error) {
+	var raw string
+	if err := b.call("blockchain.transaction.get", []interface{}{txid}, &raw); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(raw)
+}
+
+// FeeRate calls blockchain.estimatefee, which answers in BTC/kvB, or -1
+// when the server's node has no estimate. The protocol has no modes.
+func (b *electrumBackend) FeeRate(target int, _ string) (int64, error) {
+	var rate float64
+	if err := b.call("blockchain.estimatefee", []interface{}{target}, &rate); err != nil {
+		return 0, err
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("the Electrum server has no fee estimate for %d blocks", target)
+	}
+	return btcToSats(rate), nil
+}
+
+func (b *electrumBackend) AddressHistory(address string) ([]TxRef, error) {
+	histories, err := b.AddressHistories([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return histories[0], nil
+}
+
+func (b *electrumBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	params, err := b.scriptHashParams(addresses)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([][]electrumHistory, len(addresses))
+	results := make([]interface{}, len(addresses))
+	for i := range raw {
+		results[i] = &raw[i]
+	}
+	if err := b.callBatch("blockchain.scripthash.get_history", params, results); err != nil {
+		return nil, err
+	}
+
+	histories := make([][]TxRef, len(addresses))
+	for i, history := range raw {
+		refs := []TxRef{}
+		for _, h := range history {
+			// Mempool entries report 0, or -1 when they have unconfirmed
+			// parents.
+			height := h.Height
+			if height < 0 {
+				height = 0
+			}
+			refs = append(refs, TxRef{TxID: h.TxHash, Height: height})
+		}
+		histories[i] = refs
+	}
+	return histories, nil
+}
+
+func (b *electrumBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	sets, err := b.AddressUTXOSets([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return sets[0], nil
+}
+
+func (b *electrumBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	params, err := b.scriptHashParams(addresses)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([][]electrumUnspent, len(addresses))
+	results := make([]interface{}, len(addresses))
+	for i := range raw {
+		results[i] = &raw[i]
+	}
+	if err := b.callBatch("blockchain.scripthash.listunspent", params, results); err != nil {
+		return nil, err
+	}
+
+	sets := make([][]UTXO, len(addresses))
+	for i, unspent := range raw {
+		utxos := []UTXO{}
+		for _, u := range unspent {
+			height := u.Height
+			if height < 0 {
+				height = 0
+			}
+			utxos = append(utxos, UTXO{TxID: u.TxHash, Vout: u.TxPos, Value: u.Value, Height: height, Address: addresses[i]})
+		}
+		sets[i] = utxos
+	}
+	return sets, nil
+}
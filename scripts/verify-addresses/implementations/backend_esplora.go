@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EsploraConfig configures an Esplora REST API (Blockstream's esplora or
+// mempool.space), e.g. "https://mempool.space/testnet/api".
+type EsploraConfig struct {
+	URL   string      `json:"url"`
+	Retry RetryPolicy `json:"retry,omitempty"`
+}
+
+// esploraPageSize is how many confirmed transactions Esplora returns per
+// /txs/chain page.
+const esploraPageSize = 25
+
+type esploraBackend struct {
+	base   string
+	client *http.Client
+}
+
+type esploraStatus struct {
+	Confirmed   bool  `json:"confirmed"`
+	BlockHeight int64 `json:"block_height"`
+}
+
+func init() {
+	registerBackend("esplora", func(cfg *BackendConfig, network string) (ChainBackend, error) {
+		if cfg.Esplora.URL == "" {
+			return nil, fmt.Errorf("esplora backend needs esplora.url")
+		}
+		if err := checkRouteURL(cfg.Esplora.URL); err != nil {
+			return nil, err
+		}
+		return &esploraBackend{
+			base:   strings.TrimSuffix(cfg.Esplora.URL, "/"),
+			client: newHTTPClient(2 * time.Minute),
+		}, nil
+	})
+}
+
+func (b *esploraBackend) Name() string { return "esplora" }
+
+func (b *esploraBackend) Close() error { return nil }
+
+func (b *esploraBackend) get(path string) ([]byte, error) {
+	resp, err := b.client.Get(b.base + path)
+	if err != nil {
+		return nil, transient(fmt.Errorf("Esplora request failed: %v", err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transient(fmt.Errorf("Esplora request failed: %v", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("Esplora request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			err = transient(err)
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+func (b *esploraBackend) TipHeight() (int64, error) {
+	body, err := b.get("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	height, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tip height: %v", err)
+	}
+	return height, nil
+}
+
+func (b *esploraBackend) RawTransaction(txid string) ([]byte, error) {
+	body, err := b.get("/tx/" + txid + "/hex")
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(body)))
+}
+
+func (b *esploraBackend) BlockHash(height int64) (string, error) {
+	body, err := b.get("/block-height/" + strconv.FormatInt(height, 10))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (b *esploraBackend) RawBlock(hash string) ([]byte, error) {
+	return b.get("/block/" + hash + "/raw")
+}
+
+// FeeRate reads /fee-estimates, sat/vB by confirmation target, and takes
+// the estimate for the largest target up to the one asked for. Esplora
+// (and mempool.space's Esplora API) has one estimate per target, so mode
+// is ignored.
+func (b *esploraBackend) FeeRate(target int, _ string) (int64, error) {
+	body, err := b.get("/fee-estimates")
+	if err != nil {
+		return 0, err
+	}
+	var estimates map[string]float64
+	if err := json.Unmarshal(body, &estimates); err != nil {
+		return 0, fmt.Errorf("invalid Esplora response: %v", err)
+	}
+	best, rate := 0, 0.0
+	for key, value := range estimates {
+		if n, err := strconv.Atoi(key); err == nil && n <= target && n > best {
+			best, rate = n, value
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("Esplora has no fee estimate for %d blocks", target)
+	}
+	return int64(math.Ceil(rate * 1000)), nil
+}
+
+func (b *esploraBackend) AddressHistory(address string) ([]TxRef, error) {
+	type tx struct {
+		TxID   string        `json:"txid"`
+		Status esploraStatus `json:"status"`
+	}
+
+	// The first page carries mempool transactions plus the newest confirmed
+	// ones; older confirmed transactions are paged by the last txid seen.
+	refs := []TxRef{}
+	path := "/address/" + address + "/txs"
+	for {
+		body, err := b.get(path)
+		if err != nil {
+			return nil, err
+		}
+		var page []tx
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid Esplora response: %v", err)
+		}
+
+		confirmed := 0
+		lastConfirmed := ""
+		for _, t := range page {
+			height := int64(0)
+			if t.Status.Confirmed {
+				height = t.Status.BlockHeight
+				confirmed++
+				lastConfirmed = t.TxID
+			}
+			refs = append(refs, TxRef{TxID: t.TxID, Height: height})
+		}
+		if confirmed < esploraPageSize {
+			return refs, nil
+		}
+		path = "/address/" + address + "/txs/chain/" + lastConfirmed
+	}
+}
+
+func (b *esploraBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	body, err := b.get("/address/" + address + "/utxo")
+	if err != nil {
+		return nil, err
+	}
+	var unspent []struct {
+		TxID   string        `json:"txid"`
+		Vout   uint32        `json:"vout"`
+		Value  int64         `json:"value"`
+		Status esploraStatus `json:"status"`
+	}
+	if err := json.Unmarshal(body, &unspent); err != nil {
+		return nil, fmt.Errorf("invalid Esplora response: %v", err)
+	}
+
+	utxos := []UTXO{}
+	for _, u := range unspent {
+		height := int64(0)
+		if u.Status.Confirmed {
+			height = u.Status.BlockHeight
+		}
+		utxos = append(utxos, UTXO{TxID: u.TxID, Vout: u.Vout, Value: u.Value, Height: height, Address: address})
+	}
+	return utxos, nil
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// NeutrinoConfig configures a BIP-157/158 compact block filter client.
+// Peers are "host" or "host:port" and must serve filters (Bitcoin Core
+// with peerblockfilters=1); there is no peer discovery. Every peer must
+// agree on every filter header, so naming several, run by different
+// people, is what makes the filters trustworthy.
+type NeutrinoConfig struct {
+	Peers []string `json:"peers,omitempty"`
+	// DataDir keeps the headers, filter headers and filters between runs;
+	// without it they are downloaded again by every run.
+	DataDir string `json:"data_dir,omitempty"`
+	// StartHeight is the first block scanned; set it at or before the
+	// wallet's birthday. Filter headers before it are taken on the peers'
+	// agreement rather than checked from genesis.
+	StartHeight int64       `json:"start_height,omitempty"`
+	Retry       RetryPolicy `json:"retry,omitempty"`
+}
+
+// filterBucket is how many filters are kept per file in the data dir,
+// which is also the most one getcfilters may ask for.
+const filterBucket = wire.MaxGetCFiltersReqRange
+
+// neutrinoBackend follows the header chain its peers offer, checks the
+// filter header chain they serve against each other, and scans filters
+// for the scripts it is asked about, fetching the blocks that match. It
+// sees confirmed transactions only: there is no mempool.
+type neutrinoBackend struct {
+	params  *chaincfg.Params
+	network string
+	peers   []*p2pPeer
+	// heights are how far each peer is known to have the chain.
+	heights []int32
+	chain   *headerChain
+	filters *filterHeaders
+	// dir is the network's data dir, or "".
+	dir string
+	// tip is the newest block every peer has: the last block scanned, and
+	// the height TipHeight reports.
+	tip int32
+	// results are the scripts looked up so far, by script.
+	results map[string]*neutrinoResult
+	// txs are the transactions found touching them.
+	txs map[chainhash.Hash][]byte
+}
+
+// neutrinoResult is what the blocks up to through hold for one script.
+type neutrinoResult struct {
+	address string
+	script  []byte
+	through int32
+	history []TxRef
+	utxos   map[wire.OutPoint]UTXO
+}
+
+func init() {
+	registerBackend("neutrino", func(cfg *BackendConfig, network string) (ChainBackend, error) {
+		if isLiquid(network) {
+			return nil, fmt.Errorf("neutrino backend does not serve %s, which has no compact block filters", network)
+		}
+		return newNeutrinoBackend(cfg.Neutrino, network, getNetwork(network))
+	})
+}
+
+func newNeutrinoBackend(cfg NeutrinoConfig, network string, params *chaincfg.Params) (*neutrinoBackend, error) {
+	if len(cfg.Peers) == 0 {
+		return nil, fmt.Errorf("neutrino backend needs neutrino.peers")
+	}
+	if cfg.StartHeight < 0 {
+		return nil, fmt.Errorf("invalid neutrino.start_height: %d", cfg.StartHeight)
+	}
+	b := &neutrinoBackend{
+		params:  params,
+		network: network,
+		results: map[string]*neutrinoResult{},
+		txs:     map[chainhash.Hash][]byte{},
+	}
+	if cfg.DataDir != "" {
+		b.dir = filepath.Join(cfg.DataDir, network)
+		if err := os.MkdirAll(filepath.Join(b.dir, "filters"), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create neutrino.data_dir: %v", err)
+		}
+	}
+	var err error
+	if b.chain, err = loadHeaderChain(params, b.file("headers.dat")); err != nil {
+		return nil, err
+	}
+	if b.filters, err = loadFilterHeaders(int32(cfg.StartHeight), b.file("filterheaders.dat")); err != nil {
+		return nil, err
+	}
+	for _, addr := range cfg.Peers {
+		p, err := dialPeer(addr, params)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.peers = append(b.peers, p)
+		b.heights = append(b.heights, p.height)
+	}
+	if err := b.sync(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// file names a file in the data dir, or "" without one.
+func (b *neutrinoBackend) file(name string) string {
+	if b.dir == "" {
+		return ""
+	}
+	return filepath.Join(b.dir, name)
+}
+
+func (b *neutrinoBackend) Name() string { return "neutrino" }
+
+// TipHeight syncs with the peers first, so a monitor polling it sees new
+// blocks.
+func (b *neutrinoBackend) TipHeight() (int64, error) {
+	if err := b.sync(); err != nil {
+		return 0, err
+	}
+	return int64(b.tip), nil
+}
+
+// sync brings the headers up to date from every peer, then the filter
+// headers up to the newest block all of them have.
+func (b *neutrinoBackend) sync() error {
+	for i, p := range b.peers {
+		if err := b.syncHeaders(i, p); err != nil {
+			return err
+		}
+	}
+	tip := b.chain.tip()
+	for i, p := range b.peers {
+		if b.heights[i] > b.chain.tip() {
+			b.heights[i] = b.chain.tip()
+		}
+		if b.heights[i] < b.chain.tip() {
+			if err := b.probeHeight(i, p); err != nil {
+				return err
+			}
+		}
+		if b.heights[i] < tip {
+			tip = b.heights[i]
+		}
+	}
+	if err := b.syncFilterHeaders(tip); err != nil {
+		return err
+	}
+	b.tip = tip
+	return nil
+}
+
+func (b *neutrinoBackend) getHeaders(p *p2pPeer, locator []*chainhash.Hash, stop chainhash.Hash) ([]*wire.BlockHeader, error) {
+	var headers []*wire.BlockHeader
+	msg := &wire.MsgGetHeaders{ProtocolVersion: p.pver, BlockLocatorHashes: locator, HashStop: stop}
+	err := p.request(msg, func(reply wire.Message) (bool, error) {
+		m, ok := reply.(*wire.MsgHeaders)
+		if ok {
+			headers = m.Headers
+		}
+		return ok, nil
+	})
+	return headers, err
+}
+
+// syncHeaders asks peer i for the headers past the chain's tip until it
+// has no more.
+func (b *neutrinoBackend) syncHeaders(i int, p *p2pPeer) error {
+	for {
+		headers, err := b.getHeaders(p, b.chain.locator(b.chain.tip()), chainhash.Hash{})
+		if err != nil || len(headers) == 0 {
+			return err
+		}
+		fork, changed, err := b.chain.connect(headers)
+		if err != nil {
+			return fmt.Errorf("peer %s: %v", p.addr, err)
+		}
+		if changed {
+			b.rewind(fork)
+		}
+		if h := b.chain.find(headers[len(headers)-1].BlockHash()); h > b.heights[i] {
+			b.heights[i] = h
+		}
+		if !changed || len(headers) < wire.MaxBlockHeadersPerMsg {
+			return nil
+		}
+	}
+}
+
+// probeHeight finds how far peer i has the chain, when it sent no headers
+// past the tip: it may have the tip, or be some blocks behind it.
+func (b *neutrinoBackend) probeHeight(i int, p *p2pPeer) error {
+	for b.heights[i] < b.chain.tip() {
+		headers, err := b.getHeaders(p, b.chain.locator(b.heights[i]), b.chain.hashes[b.chain.tip()])
+		if err != nil {
+			return err
+		}
+		height := b.heights[i]
+		for _, header := range headers {
+			h := b.chain.find(header.BlockHash())
+			if h < 0 {
+				break
+			}
+			b.heights[i] = h
+		}
+		if b.heights[i] == height {
+			return nil
+		}
+	}
+	return nil
+}
+
+// rewind drops what was derived from blocks after fork, which a reorg
+// replaced.
+func (b *neutrinoBackend) rewind(fork int32) {
+	for i := range b.heights {
+		if b.heights[i] > fork {
+			b.heights[i] = fork
+		}
+	}
+	if b.filters.truncate(fork) || b.tip > fork {
+		b.results = map[string]*neutrinoResult{}
+	}
+	if b.tip > fork {
+		b.tip = fork
+	}
+}
+
+// syncFilterHeaders fetches the filter hashes up to tip from every peer,
+// failing if any two disagree.
+func (b *neutrinoBackend) syncFilterHeaders(tip int32) error {
+	f := b.filters
+	for next := f.next(); next <= tip; next = f.next() {
+		stop := next + wire.MaxCFHeadersPerMsg - 1
+		if stop > tip {
+			stop = tip
+		}
+		var agreed *wire.MsgCFHeaders
+		var from *p2pPeer
+		for _, p := range b.peers {
+			m, err := b.getFilterHeaders(p, next, stop)
+			if err != nil {
+				return err
+			}
+			if len(m.FilterHashes) != int(stop-next+1) {
+				return fmt.Errorf("peer %s sent %d filter hashes for blocks %d-%d", p.addr, len(m.FilterHashes), next, stop)
+			}
+			if agreed == nil {
+				agreed, from = m, p
+				continue
+			}
+			if m.PrevFilterHeader != agreed.PrevFilterHeader {
+				return errorWithCode(errBackendDisagreement, "neutrino peers %s and %s disagree on the filter header of block %d", from.addr, p.addr, next-1)
+			}
+			for j, hash := range m.FilterHashes {
+				if *hash != *agreed.FilterHashes[j] {
+					return errorWithCode(errBackendDisagreement, "neutrino peers %s and %s disagree on the filter of block %d", from.addr, p.addr, next+int32(j))
+				}
+			}
+		}
+		if err := f.add(agreed.PrevFilterHeader, agreed.FilterHashes); err != nil {
+			return fmt.Errorf("peer %s: %v", from.addr, err)
+		}
+	}
+	return f.save()
+}
+
+func (b *neutrinoBackend) getFilterHeaders(p *p2pPeer, start, stop int32) (*wire.MsgCFHeaders, error) {
+	stopHash := b.chain.hashes[stop]
+	var headers *wire.MsgCFHeaders
+	err := p.request(wire.NewMsgGetCFHeaders(wire.GCSFilterRegular, uint32(start), &stopHash), func(reply wire.Message) (bool, error) {
+		m, ok := reply.(*wire.MsgCFHeaders)
+		if ok && m.FilterType == wire.GCSFilterRegular && m.StopHash == stopHash {
+			headers = m
+			return true, nil
+		}
+		return false, nil
+	})
+	return headers, err
+}
+
+// eachFilter passes the filter of each block from from to to, checked
+// against its filter hash, to visit. Filters are read from the data dir
+// when they are kept there, and fetched a bucket at a time otherwise.
+func (b *neutrinoBackend) eachFilter(from, to int32, visit func(height int32, filter *blockFilter) error) error {
+	for bucket := from - from%filterBucket; bucket <= to; bucket += filterBucket {
+		first, last := bucket, bucket+filterBucket-1
+		if first < b.filters.start {
+			first = b.filters.start
+		}
+		complete := last <= b.tip
+		if !complete {
+			last = b.tip
+		}
+		filters, err := b.filterBucket(first, last, complete)
+		if err != nil {
+			return err
+		}
+		for h := first; h <= last; h++ {
+			if h < from || h > to {
+				continue
+			}
+			filter, err := parseBlockFilter(filters[h-first])
+			if err != nil {
+				return fmt.Errorf("filter of block %d: %v", h, err)
+			}
+			if err := visit(h, filter); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filterBucket returns the filters of blocks first to last, from the data
+// dir or the first peer that serves ones matching their filter hashes. A
+// complete bucket is written to the data dir.
+func (b *neutrinoBackend) filterBucket(first, last int32, complete bool) ([][]byte, error) {
+	path := b.file(filepath.Join("filters", fmt.Sprintf("%d.dat", first/filterBucket)))
+	if complete && path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			if filters, err := readFilters(data, int(last-first+1)); err == nil && b.checkFilters(first, filters) == nil {
+				return filters, nil
+			}
+		}
+	}
+
+	var errs []string
+	for _, p := range b.peers {
+		filters, err := b.getFilters(p, first, last)
+		if err == nil {
+			err = b.checkFilters(first, filters)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("peer %s: %v", p.addr, err))
+			continue
+		}
+		if complete && path != "" {
+			if err := os.WriteFile(path, writeFilters(filters), 0o600); err != nil {
+				return nil, err
+			}
+		}
+		return filters, nil
+	}
+	return nil, fmt.Errorf("no peer served the filters of blocks %d-%d: %s", first, last, strings.Join(errs, "; "))
+}
+
+func (b *neutrinoBackend) getFilters(p *p2pPeer, first, last int32) ([][]byte, error) {
+	stopHash := b.chain.hashes[last]
+	var filters [][]byte
+	err := p.request(wire.NewMsgGetCFilters(wire.GCSFilterRegular, uint32(first), &stopHash), func(reply wire.Message) (bool, error) {
+		m, ok := reply.(*wire.MsgCFilter)
+		if !ok || m.FilterType != wire.GCSFilterRegular {
+			return false, nil
+		}
+		// Anything else is left over from an earlier request.
+		h := first + int32(len(filters))
+		if m.BlockHash != b.chain.hashes[h] {
+			return false, nil
+		}
+		filters = append(filters, m.Data)
+		return h == last, nil
+	})
+	return filters, err
+}
+
+// checkFilters checks filters, of the blocks from first on, against the
+// filter hashes the peers agreed on.
+func (b *neutrinoBackend) checkFilters(first int32, filters [][]byte) error {
+	for i, filter := range filters {
+		h := first + int32(i)
+		if filterHash(filter) != b.filters.hash(h) {
+			return fmt.Errorf("filter of block %d does not match its filter header", h)
+		}
+	}
+	return nil
+}
+
+// readFilters and writeFilters are the bucket file format: each filter as
+// a CompactSize length and its bytes.
+func readFilters(data []byte, count int) ([][]byte, error) {
+	r := bytes.NewReader(data)
+	filters := make([][]byte, count)
+	for i := range filters {
+		var err error
+		if filters[i], err = wire.ReadVarBytes(r, 0, wire.MaxMessagePayload, "filter"); err != nil {
+			return nil, err
+		}
+	}
+	return filters, nil
+}
+
+func writeFilters(filters [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, filter := range filters {
+		wire.WriteVarBytes(&buf, 0, filter)
+	}
+	return buf.Bytes()
+}
+
+// block fetches the block at height from the first peer that serves it
+// whole: hashing to the header and matching its merkle root.
+func (b *neutrinoBackend) block(hash chainhash.Hash) (*wire.MsgBlock, error) {
+	var errs []string
+	for _, p := range b.peers {
+		getData := wire.NewMsgGetData()
+		getData.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &hash))
+		var block *wire.MsgBlock
+		err := p.request(getData, func(reply wire.Message) (bool, error) {
+			m, ok := reply.(*wire.MsgBlock)
+			if ok && m.BlockHash() == hash {
+				block = m
+				return true, nil
+			}
+			return false, nil
+		})
+		if err == nil && merkleRoot(block.Transactions) != block.Header.MerkleRoot {
+			err = fmt.Errorf("sent block %s with transactions that do not match its merkle root", hash)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("peer %s: %v", p.addr, err))
+			continue
+		}
+		return block, nil
+	}
+	return nil, fmt.Errorf("no peer served block %s: %s", hash, strings.Join(errs, "; "))
+}
+
+// lookup returns the result of each address, scanning the blocks each
+// has not been scanned through.
+func (b *neutrinoBackend) lookup(addresses []string) ([]*neutrinoResult, error) {
+	results := make([]*neutrinoResult, len(addresses))
+	stale := map[int32][]*neutrinoResult{}
+	for i, address := range addresses {
+		script, err := addressScript(address, b.network)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := b.results[string(script)]
+		if !ok {
+			r = &neutrinoResult{address: address, script: script, through: b.filters.start - 1, utxos: map[wire.OutPoint]UTXO{}}
+			b.results[string(script)] = r
+			stale[r.through] = append(stale[r.through], r)
+		} else if r.through < b.tip && !containsResult(stale[r.through], r) {
+			stale[r.through] = append(stale[r.through], r)
+		}
+		results[i] = r
+	}
+	for through, group := range stale {
+		if err := b.scan(group, through+1, b.tip); err != nil {
+			for _, r := range group {
+				if r.through < b.filters.start {
+					delete(b.results, string(r.script))
+				}
+			}
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func containsResult(results []*neutrinoResult, r *neutrinoResult) bool {
+	for _, other := range results {
+		if other == r {
+			return true
+		}
+	}
+	return false
+}
+
+// scan adds what blocks from to to hold for results: the outputs paying
+// their scripts and the transactions spending those outputs. Results are
+// only updated once every block is read, so a failed scan can be retried.
+func (b *neutrinoBackend) scan(results []*neutrinoResult, from, to int32) error {
+	scripts := make([][]byte, len(results))
+	byScript := map[string]int{}
+	histories := make([][]TxRef, len(results))
+	utxos := make([]map[wire.OutPoint]UTXO, len(results))
+	owners := map[wire.OutPoint]int{}
+	for i, r := range results {
+		scripts[i] = r.script
+		byScript[string(r.script)] = i
+		histories[i] = append([]TxRef(nil), r.history...)
+		utxos[i] = make(map[wire.OutPoint]UTXO, len(r.utxos))
+		for op, u := range r.utxos {
+			utxos[i][op] = u
+			owners[op] = i
+		}
+	}
+
+	var matched []int32
+	if from <= to {
+		err := b.eachFilter(from, to, func(height int32, filter *blockFilter) error {
+			ok, err := filter.matchAny(b.chain.hashes[height], scripts)
+			if ok {
+				matched = append(matched, height)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	txs := map[chainhash.Hash][]byte{}
+	for _, height := range matched {
+		block, err := b.block(b.chain.hashes[height])
+		if err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			txid := tx.TxHash()
+			touched := map[int]bool{}
+			for _, in := range tx.TxIn {
+				if i, ok := owners[in.PreviousOutPoint]; ok {
+					delete(utxos[i], in.PreviousOutPoint)
+					delete(owners, in.PreviousOutPoint)
+					touched[i] = true
+				}
+			}
+			for vout, out := range tx.TxOut {
+				i, ok := byScript[string(out.PkScript)]
+				if !ok {
+					continue
+				}
+				op := wire.OutPoint{Hash: txid, Index: uint32(vout)}
+				utxos[i][op] = UTXO{TxID: txid.String(), Vout: uint32(vout), Value: out.Value, Height: int64(height), Address: results[i].address}
+				owners[op] = i
+				touched[i] = true
+			}
+			if len(touched) == 0 {
+				continue
+			}
+			for i := range results {
+				if touched[i] {
+					histories[i] = append(histories[i], TxRef{TxID: txid.String(), Height: int64(height)})
+				}
+			}
+			var raw bytes.Buffer
+			if err := tx.Serialize(&raw); err != nil {
+				return err
+			}
+			txs[txid] = raw.Bytes()
+		}
+	}
+
+	for i, r := range results {
+		r.history, r.utxos, r.through = histories[i], utxos[i], to
+	}
+	for txid, raw := range txs {
+		b.txs[txid] = raw
+	}
+	return nil
+}
+
+func (b *neutrinoBackend) AddressHistory(address string) ([]TxRef, error) {
+	histories, err := b.AddressHistories([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return histories[0], nil
+}
+
+func (b *neutrinoBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	sets, err := b.AddressUTXOSets([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return sets[0], nil
+}
+
+func (b *neutrinoBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	results, err := b.lookup(addresses)
+	if err != nil {
+		return nil, err
+	}
+	histories := make([][]TxRef, len(results))
+	for i, r := range results {
+		histories[i] = append([]TxRef{}, r.history...)
+	}
+	return histories, nil
+}
+
+func (b *neutrinoBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	results, err := b.lookup(addresses)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([][]UTXO, len(results))
+	for i, r := range results {
+		sets[i] = []UTXO{}
+		for _, u := range r.utxos {
+			sets[i] = append(sets[i], u)
+		}
+		utxos := sets[i]
+		sort.Slice(utxos, func(x, y int) bool {
+			if utxos[x].Height != utxos[y].Height {
+				return utxos[x].Height < utxos[y].Height
+			}
+			if utxos[x].TxID != utxos[y].TxID {
+				return utxos[x].TxID < utxos[y].TxID
+			}
+			return utxos[x].Vout < utxos[y].Vout
+		})
+	}
+	return sets, nil
+}
+
+// RawTransaction serves the transactions found touching the addresses
+// looked up; a light client has no way to fetch any other by txid.
+func (b *neutrinoBackend) RawTransaction(txid string) ([]byte, error) {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid txid %s: %v", txid, err)
+	}
+	if raw, ok := b.txs[*hash]; ok {
+		return raw, nil
+	}
+	return nil, fmt.Errorf("%w: the neutrino backend has only the transactions of addresses it has looked up, not %s", errNoTransactions, txid)
+}
+
+func (b *neutrinoBackend) BlockHash(height int64) (string, error) {
+	if height < 0 || height > int64(b.chain.tip()) {
+		return "", fmt.Errorf("no block at height %d", height)
+	}
+	return b.chain.hashes[height].String(), nil
+}
+
+func (b *neutrinoBackend) RawBlock(hash string) ([]byte, error) {
+	h, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block hash %s: %v", hash, err)
+	}
+	block, err := b.block(*h)
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	if err := block.Serialize(&raw); err != nil {
+		return nil, err
+	}
+	return raw.Bytes(), nil
+}
+
+// probe reports the peers' user agents. A peer that keeps only recent
+// blocks (NODE_NETWORK_LIMITED) counts as pruned.
+func (b *neutrinoBackend) probe() (string, *bool, error) {
+	var agents []string
+	pruned := false
+	for _, p := range b.peers {
+		agents = append(agents, p.userAgent)
+		if p.services&wire.SFNodeNetwork == 0 {
+			pruned = true
+		}
+	}
+	return strings.Join(agents, ", "), &pruned, nil
+}
+
+func (b *neutrinoBackend) Close() error {
+	for _, p := range b.peers {
+		p.Close()
+	}
+	return nil
+}
+
+// filterHeaders is the filter header chain from block start on, as every
+// peer served it: the header before start and the filter hash of each
+// block since.
+type filterHeaders struct {
+	start  int32
+	prev   chainhash.Hash
+	hashes []chainhash.Hash
+	// last is the filter header of the last block.
+	last chainhash.Hash
+	path string
+	// dirty is set once the hashes differ from the file.
+	dirty bool
+}
+
+// loadFilterHeaders reads the filter headers kept at path. A file for
+// another start height is started over.
+func loadFilterHeaders(start int32, path string) (*filterHeaders, error) {
+	f := &filterHeaders{start: start, path: path}
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if len(data) < 36 || int32(binary.LittleEndian.Uint32(data)) != start {
+		f.dirty = true
+		return f, nil
+	}
+	copy(f.prev[:], data[4:36])
+	f.last = f.prev
+	for data = data[36:]; len(data) >= chainhash.HashSize; data = data[chainhash.HashSize:] {
+		var hash chainhash.Hash
+		copy(hash[:], data)
+		f.hashes = append(f.hashes, hash)
+		f.last = nextFilterHeader(hash, f.last)
+	}
+	return f, nil
+}
+
+// next is the first block without a filter hash.
+func (f *filterHeaders) next() int32 { return f.start + int32(len(f.hashes)) }
+
+func (f *filterHeaders) hash(height int32) chainhash.Hash { return f.hashes[height-f.start] }
+
+// add appends the filter hashes of a cfheaders reply, which must follow
+// on from the filter header chain so far.
+func (f *filterHeaders) add(prev chainhash.Hash, hashes []*chainhash.Hash) error {
+	switch {
+	case len(f.hashes) == 0 && f.start == 0 && prev != chainhash.Hash{}:
+		return fmt.Errorf("sent a filter header before genesis")
+	case len(f.hashes) == 0:
+		f.prev, f.last = prev, prev
+	case prev != f.last:
+		return fmt.Errorf("sent filter headers that do not follow on from block %d", f.next()-1)
+	}
+	for _, hash := range hashes {
+		f.hashes = append(f.hashes, *hash)
+		f.last = nextFilterHeader(*hash, f.last)
+	}
+	f.dirty = true
+	return nil
+}
+
+// truncate drops the filter hashes of blocks after fork, reporting
+// whether there were any.
+func (f *filterHeaders) truncate(fork int32) bool {
+	if fork >= f.next()-1 {
+		return false
+	}
+	keep := fork - f.start + 1
+	if keep < 0 {
+		keep = 0
+	}
+	f.hashes = f.hashes[:keep]
+	f.last = f.prev
+	for _, hash := range f.hashes {
+		f.last = nextFilterHeader(hash, f.last)
+	}
+	f.dirty = true
+	return true
+}
+
+func (f *filterHeaders) save() error {
+	if f.path == "" || !f.dirty {
+		return nil
+	}
+	data := make([]byte, 36, 36+chainhash.HashSize*len(f.hashes))
+	binary.LittleEndian.PutUint32(data, uint32(f.start))
+	copy(data[4:], f.prev[:])
+	for _, hash := range f.hashes {
+		data = append(data, hash[:]...)
+	}
+	if err := os.WriteFile(f.path, data, 0o600); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Setting "record" in the backend config saves every response of the
+// configured backend to a fixture file; the replay backend serves a
+// fixture back without network access, so scans and balances can be
+// tested deterministically.
+
+// ReplayConfig configures the replay backend.
+type ReplayConfig struct {
+	Fixture string `json:"fixture"`
+}
+
+// Fixture holds the last response a backend gave to each query, keyed by
+// address.
+type Fixture struct {
+	Backend   string             `json:"backend"`
+	Network   string             `json:"network"`
+	TipHeight *int64             `json:"tip_height,omitempty"`
+	Histories map[string][]TxRef `json:"histories"`
+	UTXOs     map[string][]UTXO  `json:"utxos"`
+	// Transactions holds the transactions fetched, in hex, by txid.
+	Transactions map[string]string `json:"transactions,omitempty"`
+	// BlockHashes holds the block hashes given by height, and Blocks the
+	// blocks fetched, in hex, by hash.
+	BlockHashes map[string]string `json:"block_hashes,omitempty"`
+	Blocks      map[string]string `json:"blocks,omitempty"`
+	// FeeRates holds the fee rate estimates given, in sat/kvB, keyed by
+	// "<target>/<mode>".
+	FeeRates map[string]int64 `json:"fee_rates,omitempty"`
+}
+
+func loadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %v", err)
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %v", path, err)
+	}
+	if f.Histories == nil {
+		f.Histories = map[string][]TxRef{}
+	}
+	if f.UTXOs == nil {
+		f.UTXOs = map[string][]UTXO{}
+	}
+	if f.Transactions == nil {
+		f.Transactions = map[string]string{}
+	}
+	if f.BlockHashes == nil {
+		f.BlockHashes = map[string]string{}
+	}
+	if f.Blocks == nil {
+		f.Blocks = map[string]string{}
+	}
+	if f.FeeRates == nil {
+		f.FeeRates = map[string]int64{}
+	}
+	return &f, nil
+}
+
+type replayBackend struct {
+	path    string
+	fixture *Fixture
+}
+
+func init() {
+	registerBackend("replay", func(cfg *BackendConfig, network string) (ChainBackend, error) {
+		if cfg.Replay.Fixture == "" {
+			return nil, fmt.Errorf("replay backend needs replay.fixture")
+		}
+		if cfg.Record != "" {
+			return nil, fmt.Errorf("the replay backend cannot record")
+		}
+		f, err := loadFixture(cfg.Replay.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		if f.Network != network {
+			return nil, fmt.Errorf("fixture %s was recorded on %s, wallet is %s", cfg.Replay.Fixture, f.Network, network)
+		}
+		return &replayBackend{path: cfg.Replay.Fixture, fixture: f}, nil
+	})
+	localBackends["replay"] = true
+}
+
+func (b *replayBackend) Name() string { return "replay" }
+
+func (b *replayBackend) Close() error { return nil }
+
+// A query the fixture does not hold fails rather than reading as unused,
+// so a test whose lookups drift from the recording notices.
+
+func (b *replayBackend) TipHeight() (int64, error) {
+	if b.fixture.TipHeight == nil {
+		return 0, fmt.Errorf("fixture %s has no tip height", b.path)
+	}
+	return *b.fixture.TipHeight, nil
+}
+
+func (b *replayBackend) AddressHistory(address string) ([]TxRef, error) {
+	refs, ok := b.fixture.Histories[address]
+	if !ok {
+		return nil, fmt.Errorf("fixture %s has no history for %s", b.path, address)
+	}
+	return refs, nil
+}
+
+func (b *replayBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	utxos, ok := b.fixture.UTXOs[address]
+	if !ok {
+		return nil, fmt.Errorf("fixture %s has no UTXOs for %s", b.path, address)
+	}
+	return utxos, nil
+}
+
+func (b *replayBackend) RawTransaction(txid string) ([]byte, error) {
+	raw, ok := b.fixture.Transactions[txid]
+	if !ok {
+		return nil, fmt.Errorf("fixture %s has no transaction %s", b.path, txid)
+	}
+	return hex.DecodeString(raw)
+}
+
+func (b *replayBackend) BlockHash(height int64) (string, error) {
+	hash, ok := b.fixture.BlockHashes[strconv.FormatInt(height, 10)]
+	if !ok {
+		return "", fmt.Errorf("fixture %s has no block hash for height %d", b.path, height)
+	}
+	return hash, nil
+}
+
+func (b *replayBackend) RawBlock(hash string) ([]byte, error) {
+	raw, ok := b.fixture.Blocks[hash]
+	if !ok {
+		return nil, fmt.Errorf("fixture %s has no block %s", b.path, hash)
+	}
+	return hex.DecodeString(raw)
+}
+
+func (b *replayBackend) FeeRate(target int, mode string) (int64, error) {
+	rate, ok := b.fixture.FeeRates[feeRateKey(target, mode)]
+	if !ok {
+		return 0, fmt.Errorf("fixture %s has no fee estimate for %d blocks (%s)", b.path, target, mode)
+	}
+	return rate, nil
+}
+
+// feeRateKey keys a fee estimate in a fixture.
+func feeRateKey(target int, mode string) string {
+	return fmt.Sprintf("%d/%s", target, mode)
+}
+
+// The batch lookups keep replayed scans on the same path as recorded ones.
+
+func (b *replayBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	histories := make([][]TxRef, len(addresses))
+	for i, address := range addresses {
+		refs, err := b.AddressHistory(address)
+		if err != nil {
+			return nil, err
+		}
+		histories[i] = refs
+	}
+	return histories, nil
+}
+
+func (b *replayBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	sets := make([][]UTXO, len(addresses))
+	for i, address := range addresses {
+		utxos, err := b.AddressUTXOs(address)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = utxos
+	}
+	return sets, nil
+}
+
+// recordingBackend saves the successful responses of another backend to a
+// fixture, written when the backend is closed. An existing fixture is
+// extended, so the requests of a run or watch share one file.
+type recordingBackend struct {
+	inner   ChainBackend
+	path    string
+	fixture *Fixture
+}
+
+// recordingBatchBackend is a recordingBackend over a batchBackend.
+type recordingBatchBackend struct {
+	*recordingBackend
+}
+
+func newRecordingBackend(inner ChainBackend, path, network string) (ChainBackend, error) {
+	f := &Fixture{Histories: map[string][]TxRef{}, UTXOs: map[string][]UTXO{}, Transactions: map[string]string{}, FeeRates: map[string]int64{}}
+	if _, err := os.Stat(path); err == nil {
+		if f, err = loadFixture(path); err != nil {
+			return nil, err
+		}
+		if f.Network != network {
+			return nil, fmt.Errorf("fixture %s was recorded on %s, wallet is %s", path, f.Network, network)
+		}
+	}
+	f.Backend, f.Network = inner.Name(), network
+	r := &recordingBackend{inner: inner, path: path, fixture: f}
+	if _, ok := inner.(batchBackend); ok {
+		return recordingBatchBackend{r}, nil
+	}
+	return r, nil
+}
+
+func (r *recordingBackend) Name() string { return r.inner.Name() }
+
+func (r *recordingBackend) Close() error {
+	data, err := json.MarshalIndent(r.fixture, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(r.path, append(data, '\n'))
+	}
+	if err != nil {
+		// Deferred closes drop the error, and a lost recording would
+		// otherwise go unnoticed until a replay fails.
+		diagnostic("failed to write fixture %s: %v", r.path, err)
+	}
+	if cerr := r.inner.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *recordingBackend) TipHeight() (int64, error) {
+	height, err := r.inner.TipHeight()
+	if err == nil {
+		r.fixture.TipHeight = &height
+	}
+	return height, err
+}
+
+func (r *recordingBackend) AddressHistory(address string) ([]TxRef, error) {
+	refs, err := r.inner.AddressHistory(address)
+	if err == nil {
+		r.fixture.Histories[address] = refs
+	}
+	return refs, err
+}
+
+func (r *recordingBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	utxos, err := r.inner.AddressUTXOs(address)
+	if err == nil {
+		r.fixture.UTXOs[address] = utxos
+	}
+	return utxos, err
+}
+
+func (r *recordingBackend) RawTransaction(txid string) ([]byte, error) {
+	raw, err := rawTransaction(r.inner, txid)
+	if err == nil {
+		r.fixture.Transactions[txid] = hex.EncodeToString(raw)
+	}
+	return raw, err
+}
+
+func (r *recordingBackend) BlockHash(height int64) (string, error) {
+	hash, err := backendBlockHash(r.inner, height)
+	if err == nil {
+		r.fixture.BlockHashes[strconv.FormatInt(height, 10)] = hash
+	}
+	return hash, err
+}
+
+func (r *recordingBackend) RawBlock(hash string) ([]byte, error) {
+	raw, err := rawBlock(r.inner, hash)
+	if err == nil {
+		r.fixture.Blocks[hash] = hex.EncodeToString(raw)
+	}
+	return raw, err
+}
+
+func (r *recordingBackend) FeeRate(target int, mode string) (int64, error) {
+	rate, err := backendFeeRate(r.inner, target, mode)
+	if err == nil {
+		r.fixture.FeeRates[feeRateKey(target, mode)] = rate
+	}
+	return rate, err
+}
+
+func (r recordingBatchBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	histories, err := r.inner.(batchBackend).AddressHistories(addresses)
+	if err == nil {
+		for i, address := range addresses {
+			r.fixture.Histories[address] = histories[i]
+		}
+	}
+	return histories, err
+}
+
+func (r recordingBatchBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	sets, err := r.inner.(batchBackend).AddressUTXOSets(addresses)
+	if err == nil {
+		for i, address := range addresses {
+			r.fixture.UTXOs[address] = sets[i]
+		}
+	}
+	return sets, err
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// indexRange is an inclusive range of child indices.
+type indexRange struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+// parseIndexRange parses "start-end" (inclusive) or a single index.
+func parseIndexRange(s string) (indexRange, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		endStr = startStr
+	}
+	start, errStart := strconv.ParseUint(strings.TrimSpace(startStr), 10, 31)
+	end, errEnd := strconv.ParseUint(strings.TrimSpace(endStr), 10, 31)
+	if errStart != nil || errEnd != nil || end < start {
+		return indexRange{}, errorWithCode(errInvalidIndex, "invalid index range: %q (want start-end)", s)
+	}
+	return indexRange{Start: uint32(start), End: uint32(end)}, nil
+}
+
+// walletAddressUTXOs is the unspent outputs of one wallet address.
+type walletAddressUTXOs struct {
+	Change  bool
+	Index   uint32
+	Address string
+	UTXOs   []UTXO
+}
+
+// chainRanges returns the indices to look at on each chain: the given range
+// on both, or without one, everything up to the last used index found by a
+// gap scan of each chain.
+func chainRanges(spec *WalletSpec, backend ChainBackend, store *walletStore, r *indexRange, gap int) (map[bool]*indexRange, error) {
+	ranges := map[bool]*indexRange{}
+	for _, change := range []bool{false, true} {
+		if r != nil {
+			ranges[change] = r
+			continue
+		}
+		scan, err := scanChain(spec, backend, store, change, gap)
+		if err != nil {
+			return nil, err
+		}
+		if scan.LastUsed >= 0 {
+			ranges[change] = &indexRange{Start: 0, End: uint32(scan.LastUsed)}
+		}
+	}
+	return ranges, nil
+}
+
+// utxoBatchSize is how many addresses collectUTXOs looks up at a time.
+const utxoBatchSize = 100
+
+// utxoSweep is the saved state of an unfinished UTXO sweep of one chain:
+// the range being swept and the addresses found holding outputs so far.
+type utxoSweep struct {
+	Range     indexRange   `json:"range"`
+	Addresses []sweptFunds `json:"addresses"`
+}
+
+type sweptFunds struct {
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+	UTXOs   []UTXO `json:"utxos"`
+}
+
+// collectUTXOs looks up the unspent outputs of every address in the ranges,
+// handing each address to visit as soon as its batch has been looked up.
+// With a store, the sweep of each chain is saved after every batch, and an
+// interrupted sweep of the same range resumes by replaying what it had
+// found. Replayed outputs are as of the height the sweep was made at.
+func collectUTXOs(spec *WalletSpec, backend ChainBackend, store *walletStore, ranges map[bool]*indexRange, visit func(walletAddressUTXOs) error) error {
+	var id string
+	if store != nil {
+		var err error
+		if id, err = store.registerWallet(spec); err != nil {
+			return err
+		}
+	}
+	for _, change := range []bool{false, true} {
+		r := ranges[change]
+		if r == nil {
+			continue
+		}
+		var tip int64
+		resumeAt := uint64(r.Start)
+		sweep := utxoSweep{Range: *r, Addresses: []sweptFunds{}}
+		if store != nil {
+			var err error
+			if tip, err = backend.TipHeight(); err != nil {
+				return err
+			}
+			if resumeAt, err = resumeSweep(spec, store, id, change, backend, &sweep, visit); err != nil {
+				return err
+			}
+		}
+
+		for start := resumeAt; start <= uint64(r.End); start += utxoBatchSize {
+			end := min(start+utxoBatchSize-1, uint64(r.End))
+			var addresses []string
+			for index := start; index <= end; index++ {
+				address, err := spec.deriveAddress(change, uint32(index))
+				if err != nil {
+					return err
+				}
+				addresses = append(addresses, address)
+			}
+			sets, err := lookupUTXOs(backend, addresses)
+			if err != nil {
+				return err
+			}
+			for i, utxos := range sets {
+				index := uint32(start) + uint32(i)
+				if err := visit(walletAddressUTXOs{Change: change, Index: index, Address: addresses[i], UTXOs: utxos}); err != nil {
+					return err
+				}
+				if len(utxos) > 0 {
+					sweep.Addresses = append(sweep.Addresses, sweptFunds{Index: index, Address: addresses[i], UTXOs: utxos})
+				}
+			}
+			if store != nil && end < uint64(r.End) {
+				state, err := json.Marshal(sweep)
+				if err != nil {
+					return err
+				}
+				p := scanProgress{Backend: backend.Name(), TipHeight: tip, NextIndex: uint32(end) + 1, State: string(state)}
+				if err := store.saveProgress(id, progressUTXOScan, change, p); err != nil {
+					return err
+				}
+			}
+		}
+		if store != nil {
+			if err := store.clearProgress(id, progressUTXOScan, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resumeSweep replays the saved part of an unfinished sweep of the same
+// range through visit, filling in sweep, and returns the index to continue
+// from. Addresses the sweep found empty are derived again so visit sees
+// every index in order.
+func resumeSweep(spec *WalletSpec, store *walletStore, id string, change bool, backend ChainBackend, sweep *utxoSweep, visit func(walletAddressUTXOs) error) (uint64, error) {
+	r := sweep.Range
+	p, err := resumableProgress(store, id, progressUTXOScan, change, backend)
+	if err != nil || p == nil {
+		return uint64(r.Start), err
+	}
+	var saved utxoSweep
+	if err := json.Unmarshal([]byte(p.State), &saved); err != nil || saved.Range != r || p.NextIndex <= r.Start || p.NextIndex > r.End {
+		return uint64(r.Start), nil
+	}
+	diagnostic("resuming the UTXO sweep of %s at index %d (outputs below it are as of height %d)", chainName(change), p.NextIndex, p.TipHeight)
+
+	funds := map[uint32]sweptFunds{}
+	for _, f := range saved.Addresses {
+		funds[f.Index] = f
+	}
+	for index := r.Start; index < p.NextIndex; index++ {
+		a := walletAddressUTXOs{Change: change, Index: index}
+		if f, ok := funds[index]; ok {
+			a.Address, a.UTXOs = f.Address, f.UTXOs
+		} else if a.Address, err = spec.deriveAddress(change, index); err != nil {
+			return 0, err
+		}
+		if err := visit(a); err != nil {
+			return 0, err
+		}
+	}
+	sweep.Addresses = saved.Addresses
+	return uint64(p.NextIndex), nil
+}
+
+// BalanceReport sums a wallet's unspent outputs, in satoshis. Outputs in
+// the mempool count as unconfirmed.
+type BalanceReport struct {
+	Backend     string           `json:"backend"`
+	TipHeight   int64            `json:"tip_height"`
+	Range       *indexRange      `json:"range,omitempty"`
+	Confirmed   int64            `json:"confirmed"`
+	Unconfirmed int64            `json:"unconfirmed"`
+	Total       int64            `json:"total"`
+	Receive     ChainBalance     `json:"receive"`
+	Change      ChainBalance     `json:"change"`
+	Addresses   []AddressBalance `json:"addresses,omitempty"`
+}
+
+// ChainBalance is the balance held on one chain of the wallet.
+type ChainBalance struct {
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
+	// Checked is the number of addresses looked up on this chain.
+	Checked int `json:"checked"`
+}
+
+// AddressBalance is the per-address detail reported with --verbose, for
+// every address holding funds.
+type AddressBalance struct {
+	Change      bool   `json:"change"`
+	Index       uint32 `json:"index"`
+	Address     string `json:"address"`
+	Confirmed   int64  `json:"confirmed"`
+	Unconfirmed int64  `json:"unconfirmed"`
+	UTXOs       int    `json:"utxos"`
+}
+
+// walletBalance sums the balance across both chains. r limits the indices
+// looked at; without it each chain is gap-scanned. With a store, an
+// interrupted run resumes where it stopped.
+func walletBalance(spec *WalletSpec, backend ChainBackend, store *walletStore, r *indexRange, gap int, verbose bool) (*BalanceReport, error) {
+	tip, err := backend.TipHeight()
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := chainRanges(spec, backend, store, r, gap)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BalanceReport{Backend: backend.Name(), TipHeight: tip, Range: r}
+	err = collectUTXOs(spec, backend, store, ranges, func(a walletAddressUTXOs) error {
+		chain := &report.Receive
+		if a.Change {
+			chain = &report.Change
+		}
+		chain.Checked++
+
+		detail := AddressBalance{Change: a.Change, Index: a.Index, Address: a.Address, UTXOs: len(a.UTXOs)}
+		for _, u := range a.UTXOs {
+			if u.Height > 0 {
+				detail.Confirmed += u.Value
+			} else {
+				detail.Unconfirmed += u.Value
+			}
+		}
+		chain.Confirmed += detail.Confirmed
+		chain.Unconfirmed += detail.Unconfirmed
+		if verbose && detail.UTXOs > 0 {
+			if streaming() {
+				streamRecord("address", detail)
+			} else {
+				report.Addresses = append(report.Addresses, detail)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Confirmed = report.Receive.Confirmed + report.Change.Confirmed
+	report.Unconfirmed = report.Receive.Unconfirmed + report.Change.Unconfirmed
+	report.Total = report.Confirmed + report.Unconfirmed
+	return report, nil
+}
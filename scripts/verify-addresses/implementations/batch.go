@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+)
+
+// A batch carries requests from an online coordinator to an offline
+// verifier and results back, as files (on an SD card, say), rather than
+// over a connection. run executes a request file and writes a results file
+// bound to it by hash; run --verify checks a results file on the way back.
+
+// BatchFile is a request file: each request is a command line, command
+// name first, run exactly as on the CLI with the global flags of the run.
+type BatchFile struct {
+	Requests []BatchRequest `json:"requests"`
+	// Traceparent is the W3C trace context of the coordinator, continued
+	// by the batch's spans when it runs under watch.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// BatchRequest is one command line of a batch. ID is echoed in its result;
+// requests without one are given a generated ID.
+type BatchRequest struct {
+	ID   string   `json:"id,omitempty"`
+	Args []string `json:"args"`
+}
+
+// BatchResults is a results file. InputSHA256 is the hash of the request
+// file as read; ResultsSHA256 is the hash of the results array exactly as
+// written, so a truncated or edited file no longer matches.
+type BatchResults struct {
+	InputSHA256   string          `json:"input_sha256"`
+	ResultsSHA256 string          `json:"results_sha256"`
+	Results       json.RawMessage `json:"results"`
+}
+
+// BatchResult is the result document of one request, in JSON whatever the
+// --format of the run.
+type BatchResult struct {
+	ID      string          `json:"id,omitempty"`
+	Command string          `json:"command"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// BatchReport is the output of run itself.
+type BatchReport struct {
+	In            string `json:"in,omitempty"`
+	Out           string `json:"out"`
+	Requests      int    `json:"requests"`
+	Failed        int    `json:"failed"`
+	InputSHA256   string `json:"input_sha256"`
+	ResultsSHA256 string `json:"results_sha256"`
+	// Verified is set by run --verify when the hashes match.
+	Verified bool `json:"verified,omitempty"`
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runBatch executes every request of the file at in and writes the results
+// file to out.
+func runBatch(in, out string) (*BatchReport, error) {
+	input, err := os.ReadFile(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read requests: %v", err)
+	}
+	var batch BatchFile
+	if err := json.Unmarshal(input, &batch); err != nil {
+		return nil, fmt.Errorf("failed to parse requests: %v", err)
+	}
+	for i, r := range batch.Requests {
+		if len(r.Args) == 0 {
+			return nil, fmt.Errorf("request %d has no command", i+1)
+		}
+	}
+
+	audit, err := openAuditLog()
+	if err != nil {
+		return nil, err
+	}
+	defer audit.Close()
+
+	s := startRemoteSpan("batch", batch.Traceparent, spanAttr{"batch.requests", len(batch.Requests)})
+	defer s.end(nil)
+
+	report := &BatchReport{In: in, Out: out, Requests: len(batch.Requests), InputSHA256: sha256Hex(input)}
+	results := []BatchResult{}
+	for _, r := range batch.Requests {
+		id := r.ID
+		if id == "" {
+			id = newRequestID()
+		}
+		rs := startSpan(r.Args[0], spanInternal, spanAttr{"command", r.Args[0]}, spanAttr{"request.id", id})
+		var result json.RawMessage
+		if draining.Load() {
+			msg := "verifier is shutting down; retry the request"
+			rs.fail(msg)
+			result, _ = json.Marshal(Result{Error: msg, Code: errShuttingDown})
+		} else {
+			requestID = id
+			result = runBatchRequest(r.Args)
+			requestID = ""
+		}
+		rs.end(nil)
+		if err := audit.record(id, report.InputSHA256, r.Args, result); err != nil {
+			return nil, err
+		}
+		if resultFailed(result) {
+			report.Failed++
+		}
+		results = append(results, BatchResult{ID: id, Command: r.Args[0], Result: result})
+	}
+
+	encoded, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	report.ResultsSHA256 = sha256Hex(encoded)
+	data, err := json.Marshal(BatchResults{InputSHA256: report.InputSHA256, ResultsSHA256: report.ResultsSHA256, Results: encoded})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(out, append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write results: %v", err)
+	}
+	return report, nil
+}
+
+// runBatchRequest runs one command line and returns its result document.
+// The run's stdout and output options are swapped out meanwhile, so the
+// command writes exactly one JSON document, to a buffer.
+func runBatchRequest(args []string) json.RawMessage {
+	var buf bytes.Buffer
+	savedStdout, savedOptions, savedWritten := stdout, options, resultWritten
+	stdout, resultWritten = &buf, false
+	options.format, options.porcelain = "json", true
+	defer func() {
+		stdout, options, resultWritten = savedStdout, savedOptions, savedWritten
+	}()
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				diagnostic("panic: %v\n%s", r, debug.Stack())
+				if !resultWritten {
+					outputJSON(Result{Error: fmt.Sprintf("internal error: %v", r), Code: errInternal})
+				}
+			}
+		}()
+		cmd := findCommand(args[0])
+		switch {
+		case cmd == nil:
+			outputError("Unknown command: " + args[0])
+		case cmd.name == "run" || cmd.name == "watch" || cmd.name == "ui" || cmd.name == "tui":
+			outputError(cmd.name + " cannot be nested in a batch")
+		default:
+			cmd.run(args[1:])
+		}
+	}()
+	if !resultWritten {
+		outputError("command produced no result")
+	}
+
+	out := bytes.TrimSpace(buf.Bytes())
+	if !json.Valid(out) {
+		// Plain text results (proto-schema) are carried as a string.
+		out, _ = json.Marshal(string(out))
+	}
+	return out
+}
+
+// resultFailed reports whether a result document is an error.
+func resultFailed(result json.RawMessage) bool {
+	var doc struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(result, &doc) == nil && doc.Error != ""
+}
+
+// verifyBatch checks the hashes of a results file, and that it answers the
+// request file at in if one is given.
+func verifyBatch(out, in string) (*BatchReport, error) {
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read results: %v", err)
+	}
+	var file BatchResults
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse results: %v", err)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(file.Results, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse results: %v", err)
+	}
+	if got := sha256Hex(file.Results); got != file.ResultsSHA256 {
+		return nil, errorWithCode(errHashMismatch, "results hash is %s, but the file records %s", got, file.ResultsSHA256)
+	}
+	if in != "" {
+		input, err := os.ReadFile(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read requests: %v", err)
+		}
+		if got := sha256Hex(input); got != file.InputSHA256 {
+			return nil, errorWithCode(errHashMismatch, "requests hash is %s, but the results answer %s", got, file.InputSHA256)
+		}
+	}
+
+	report := &BatchReport{In: in, Out: out, Requests: len(results), InputSHA256: file.InputSHA256, ResultsSHA256: file.ResultsSHA256, Verified: true}
+	for _, r := range results {
+		if resultFailed(r.Result) {
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a reader never sees a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// An independent BIP-32 public derivation engine. It shares no code with
+// btcsuite: extended keys are base58check-decoded here and the curve
+// arithmetic is plain math/big, so a bug in either library shows up as a
+// disagreement under --paranoid rather than as a wrong address. It is slow
+// and not constant time, which is fine for public keys.
+
+var (
+	secp256k1P  = bigHex("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+	secp256k1N  = bigHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	secp256k1Gx = bigHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	secp256k1Gy = bigHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+)
+
+func bigHex(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 16)
+	return n
+}
+
+// ecPoint is an affine secp256k1 point; a nil x is the point at infinity.
+type ecPoint struct {
+	x, y *big.Int
+}
+
+func (p ecPoint) infinity() bool { return p.x == nil }
+
+func ecAdd(a, b ecPoint) ecPoint {
+	if a.infinity() {
+		return b
+	}
+	if b.infinity() {
+		return a
+	}
+	P := secp256k1P
+	var m *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		if sum := new(big.Int).Add(a.y, b.y); sum.Mod(sum, P).Sign() == 0 {
+			return ecPoint{}
+		}
+		// m = 3x^2 / 2y
+		num := new(big.Int).Mul(a.x, a.x)
+		num.Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(a.y, 1)
+		m = num.Mul(num, den.ModInverse(den, P))
+	} else {
+		// m = (y2-y1) / (x2-x1)
+		num := new(big.Int).Sub(b.y, a.y)
+		den := new(big.Int).Sub(b.x, a.x)
+		den.Mod(den, P)
+		m = num.Mul(num, den.ModInverse(den, P))
+	}
+	m.Mod(m, P)
+	x := new(big.Int).Mul(m, m)
+	x.Sub(x, a.x).Sub(x, b.x).Mod(x, P)
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, m).Sub(y, a.y).Mod(y, P)
+	return ecPoint{x, y}
+}
+
+func ecScalarBaseMult(k *big.Int) ecPoint {
+	var r ecPoint
+	addend := ecPoint{secp256k1Gx, secp256k1Gy}
+	for i := 0; i < k.BitLen(); i++ {
+		if k.Bit(i) == 1 {
+			r = ecAdd(r, addend)
+		}
+		addend = ecAdd(addend, addend)
+	}
+	return r
+}
+
+// ecDecompress parses a 33-byte compressed SEC1 point.
+func ecDecompress(b []byte) (ecPoint, error) {
+	if len(b) != 33 || (b[0] != 2 && b[0] != 3) {
+		return ecPoint{}, fmt.Errorf("not a compressed public key")
+	}
+	P := secp256k1P
+	x := new(big.Int).SetBytes(b[1:])
+	if x.Cmp(P) >= 0 {
+		return ecPoint{}, fmt.Errorf("public key x coordinate out of range")
+	}
+	// y = sqrt(x^3 + 7), which is (x^3 + 7)^((p+1)/4) as p = 3 mod 4.
+	rhs := new(big.Int).Exp(x, big.NewInt(3), P)
+	rhs.Add(rhs, big.NewInt(7)).Mod(rhs, P)
+	exp := new(big.Int).Add(P, big.NewInt(1))
+	y := new(big.Int).Exp(rhs, exp.Rsh(exp, 2), P)
+	if new(big.Int).Exp(y, big.NewInt(2), P).Cmp(rhs) != 0 {
+		return ecPoint{}, fmt.Errorf("public key is not on the curve")
+	}
+	if y.Bit(0) != uint(b[0]&1) {
+		y.Sub(P, y)
+	}
+	return ecPoint{x, y}, nil
+}
+
+func (p ecPoint) compressed() []byte {
+	out := make([]byte, 33)
+	out[0] = 2 | byte(p.y.Bit(0))
+	p.x.FillBytes(out[1:])
+	return out
+}
+
+// bip32PubKey is an extended public key: a point and its chain code.
+type bip32PubKey struct {
+	point     ecPoint
+	chainCode []byte
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58CheckDecode decodes base58 and verifies the trailing double-SHA256
+// checksum.
+func base58CheckDecode(s string) ([]byte, error) {
+	n := new(big.Int)
+	for _, c := range []byte(s) {
+		d := bytes.IndexByte([]byte(base58Alphabet), c)
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, big.NewInt(58)).Add(n, big.NewInt(int64(d)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	b := append(make([]byte, zeros), n.Bytes()...)
+	if len(b) < 4 {
+		return nil, fmt.Errorf("base58check data too short")
+	}
+	payload, checksum := b[:len(b)-4], b[len(b)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return nil, fmt.Errorf("base58check checksum mismatch")
+	}
+	return payload, nil
+}
+
+// parseBip32PubKey decodes a serialized extended public key. Any version
+// bytes are accepted, so SLIP-132 forms (zpub, Vpub, ...) parse directly.
+func parseBip32PubKey(s string) (*bip32PubKey, error) {
+	b, err := base58CheckDecode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 78 {
+		return nil, fmt.Errorf("extended key is %d bytes, want 78", len(b))
+	}
+	point, err := ecDecompress(b[45:78])
+	if err != nil {
+		return nil, err
+	}
+	return &bip32PubKey{point: point, chainCode: b[13:45]}, nil
+}
+
+// child derives the non-hardened child i (CKDpub).
+func (k *bip32PubKey) child(i uint32) (*bip32PubKey, error) {
+	if i >= 1<<31 {
+		return nil, fmt.Errorf("cannot derive hardened child %d from a public key", i)
+	}
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(k.point.compressed())
+	binary.Write(mac, binary.BigEndian, i)
+	sum := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(secp256k1N) >= 0 {
+		return nil, fmt.Errorf("child %d is invalid (IL >= n)", i)
+	}
+	point := ecAdd(ecScalarBaseMult(il), k.point)
+	if point.infinity() {
+		return nil, fmt.Errorf("child %d is the point at infinity", i)
+	}
+	return &bip32PubKey{point: point, chainCode: sum[32:]}, nil
+}
+
+// derivePoint derives the public key below k along path.
+func (k *bip32PubKey) derivePoint(path []uint32) (ecPoint, error) {
+	var err error
+	for _, i := range path {
+		if k, err = k.child(i); err != nil {
+			return ecPoint{}, err
+		}
+	}
+	return k.point, nil
+}
+
+// derivePath derives the compressed public key below k along path.
+func (k *bip32PubKey) derivePath(path []uint32) ([]byte, error) {
+	point, err := k.derivePoint(path)
+	if err != nil {
+		return nil, err
+	}
+	return point.compressed(), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// BIP-39 mnemonics in the English wordlist, the only one hardware wallets
+// agree on. bip39-english.txt is the BIP's english.txt, byte for byte;
+// check --deep compares its hash with the published one.
+
+//go:embed bip39-english.txt
+var bip39WordlistText string
+
+// bip39WordlistSHA256 is the SHA-256 of english.txt in the BIPs repository.
+const bip39WordlistSHA256 = "2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda"
+
+var bip39Words = strings.Fields(bip39WordlistText)
+
+// entropyToMnemonic encodes 16 to 32 bytes of entropy, a multiple of 4, as
+// a mnemonic: the entropy and the first bits of its SHA-256, in 11-bit
+// words.
+func entropyToMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("BIP-39 entropy must be 16 to 32 bytes in steps of 4, not %d", len(entropy))
+	}
+	checksum := sha256.Sum256(entropy)
+	bits := len(entropy) * 8
+	bit := func(i int) int {
+		if i < bits {
+			return int(entropy[i/8]>>(7-i%8)) & 1
+		}
+		i -= bits
+		return int(checksum[i/8]>>(7-i%8)) & 1
+	}
+	words := make([]string, (bits+bits/32)/11)
+	for w := range words {
+		index := 0
+		for i := 0; i < 11; i++ {
+			index = index<<1 | bit(w*11+i)
+		}
+		words[w] = bip39Words[index]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// mnemonicSeed returns the BIP-39 seed of a mnemonic and passphrase.
+func mnemonicSeed(mnemonic, passphrase string) []byte {
+	return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
+
+// bip39WordIndex maps each word, and each four-letter prefix, which BIP-39
+// makes unique, to its index. Metal backups often keep only the prefix.
+var bip39WordIndex = func() map[string]int {
+	index := make(map[string]int, 2*len(bip39Words))
+	for i, w := range bip39Words {
+		index[w] = i
+		if len(w) > 4 {
+			index[w[:4]] = i
+		}
+	}
+	return index
+}()
+
+// normalizeMnemonic lowercases a mnemonic, collapses its whitespace and
+// expands four-letter prefixes to full words.
+func normalizeMnemonic(mnemonic string) ([]string, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	for i, w := range words {
+		index, ok := bip39WordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("word %d of the mnemonic is not in the BIP-39 English wordlist", i+1)
+		}
+		words[i] = bip39Words[index]
+	}
+	return words, nil
+}
+
+// mnemonicToEntropy decodes a normalized mnemonic and checks its checksum.
+func mnemonicToEntropy(words []string) ([]byte, error) {
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("a BIP-39 mnemonic has 12, 15, 18, 21 or 24 words, not %d", len(words))
+	}
+	bits := len(words) * 11
+	entropyBits := bits * 32 / 33
+	data := make([]byte, (bits+7)/8)
+	for w, word := range words {
+		index := bip39WordIndex[word]
+		for i := 0; i < 11; i++ {
+			if index>>(10-i)&1 != 0 {
+				bit := w*11 + i
+				data[bit/8] |= 0x80 >> (bit % 8)
+			}
+		}
+	}
+	defer clear(data)
+	entropy := append([]byte(nil), data[:entropyBits/8]...)
+	checksum := sha256.Sum256(entropy)
+	expected := checksum[0] >> (8 - (bits - entropyBits))
+	got := data[entropyBits/8] >> (8 - (bits - entropyBits))
+	if expected != got {
+		clear(entropy)
+		return nil, errorWithCode(errInvalidMnemonic, "mnemonic checksum mismatch: a word is wrong or out of order")
+	}
+	return entropy, nil
+}
+
+// parseKeyPath parses a BIP-32 path such as "m/84'/0'/0'" or "m/84h/0h/0h".
+func parseKeyPath(path string) ([]uint32, error) {
+	steps := strings.Split(strings.TrimSpace(path), "/")
+	if steps[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q (want m/...)", path)
+	}
+	var children []uint32
+	for _, step := range steps[1:] {
+		offset := uint32(0)
+		if n, ok := strings.CutSuffix(step, "'"); ok {
+			step, offset = n, hdkeychain.HardenedKeyStart
+		} else if n, ok := strings.CutSuffix(step, "h"); ok {
+			step, offset = n, hdkeychain.HardenedKeyStart
+		}
+		n, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path step %q in %s", step, path)
+		}
+		children = append(children, uint32(n)+offset)
+	}
+	return children, nil
+}
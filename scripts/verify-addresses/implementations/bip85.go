@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// BIP-85 derives child secrets (a BIP-39 seed, an xprv, a WIF key, raw
+// entropy) from a master private key, so one backed-up master seeds many
+// wallets. bip85 re-derives a child from the master, read through
+// --key-env or --key-fd, and reports the child wallet's account xpub and
+// descriptor, which can be compared with the wallet in use without the
+// child secret ever being written down again. The child secret itself is
+// only output with --reveal. It refuses to run unless offline.
+
+// bip85Purpose is the first step of every BIP-85 path, 83696968'.
+const bip85Purpose = 83696968
+
+// bip85EntropyKey is the HMAC key turning a derived private key into
+// entropy.
+const bip85EntropyKey = "bip-entropy-from-k"
+
+// bip85Applications lists the applications bip85 derives, by the name
+// --app takes, with their BIP-85 application number.
+var bip85Applications = map[string]uint32{
+	"bip39": 39,
+	"wif":   2,
+	"xprv":  32,
+	"hex":   128169,
+}
+
+// bip85MnemonicLengths maps a mnemonic's word count to its entropy size.
+var bip85MnemonicLengths = map[int]int{12: 16, 18: 24, 24: 32}
+
+// purposeOfScriptType returns the BIP-44 style purpose of a single-sig
+// script type.
+func purposeOfScriptType(scriptType string) (uint32, bool) {
+	for purpose, t := range purposeScriptTypes {
+		if t == scriptType {
+			return purpose, true
+		}
+	}
+	return 0, false
+}
+
+// Bip85Report describes one BIP-85 child. Entropy and the child secret are
+// only set under --reveal.
+type Bip85Report struct {
+	Application string `json:"application"`
+	Path        string `json:"path"`
+	Network     string `json:"network"`
+	Words       int    `json:"words,omitempty"`
+	Bytes       int    `json:"bytes,omitempty"`
+	Revealed    bool   `json:"revealed"`
+	Entropy     string `json:"entropy,omitempty"`
+	Mnemonic    string `json:"mnemonic,omitempty"`
+	Xprv        string `json:"xprv,omitempty"`
+	WIF         string `json:"wif,omitempty"`
+	// PublicKey is the public key of a WIF child.
+	PublicKey string `json:"public_key,omitempty"`
+	// Fingerprint, AccountPath, AccountXpub and Descriptor describe the
+	// child wallet of a bip39 or xprv child.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	AccountPath string `json:"account_path,omitempty"`
+	AccountXpub string `json:"account_xpub,omitempty"`
+	Descriptor  string `json:"descriptor,omitempty"`
+}
+
+// bip85Request is a parsed bip85 command line.
+type bip85Request struct {
+	app        string
+	words      int
+	bytes      int
+	index      uint32
+	scriptType string
+	account    uint32
+	reveal     bool
+}
+
+// path returns the derivation path of a request's child.
+func (req *bip85Request) path() []uint32 {
+	steps := []uint32{bip85Purpose, bip85Applications[req.app]}
+	switch req.app {
+	case "bip39":
+		// Language 0 is English.
+		steps = append(steps, 0, uint32(req.words))
+	case "hex":
+		steps = append(steps, uint32(req.bytes))
+	}
+	steps = append(steps, req.index)
+	for i := range steps {
+		steps[i] += hdkeychain.HardenedKeyStart
+	}
+	return steps
+}
+
+// formatPath renders child numbers as a path from the master key.
+func formatPath(steps []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, i := range steps {
+		if i >= hdkeychain.HardenedKeyStart {
+			fmt.Fprintf(&b, "/%d'", i-hdkeychain.HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&b, "/%d", i)
+		}
+	}
+	return b.String()
+}
+
+// bip85Entropy derives the 64 bytes of BIP-85 entropy at path below
+// master.
+func bip85Entropy(master *hdkeychain.ExtendedKey, path []uint32) ([]byte, error) {
+	key, err := deriveKeyPath(master, path)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Zero()
+	priv, err := key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Zero()
+	k := priv.Key.Bytes()
+	defer clear(k[:])
+	mac := hmac.New(sha512.New, []byte(bip85EntropyKey))
+	mac.Write(k[:])
+	return mac.Sum(nil), nil
+}
+
+// deriveBip85 derives the child req asks for below master.
+func deriveBip85(master *hdkeychain.ExtendedKey, req *bip85Request) (*Bip85Report, error) {
+	if !master.IsPrivate() {
+		return nil, errorWithCode(errInvalidKey, "BIP-85 needs the master extended private key, not an xpub")
+	}
+	network := networkFromXpub(master.String())
+	net := getNetwork(network)
+	path := req.path()
+	report := &Bip85Report{Application: req.app, Path: formatPath(path), Network: network, Revealed: req.reveal}
+
+	entropy, err := bip85Entropy(master, path)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(entropy)
+
+	var child *hdkeychain.ExtendedKey
+	switch req.app {
+	case "bip39":
+		report.Words = req.words
+		size := bip85MnemonicLengths[req.words]
+		mnemonic, err := entropyToMnemonic(entropy[:size])
+		if err != nil {
+			return nil, err
+		}
+		seed := mnemonicSeed(mnemonic, "")
+		defer clear(seed)
+		if child, err = hdkeychain.NewMaster(seed, net); err != nil {
+			return nil, err
+		}
+		if req.reveal {
+			report.Entropy = hex.EncodeToString(entropy[:size])
+			report.Mnemonic = mnemonic
+		}
+	case "xprv":
+		// The chain code comes first, then the private key.
+		child = hdkeychain.NewExtendedKey(net.HDPrivateKeyID[:], append([]byte(nil), entropy[32:]...), append([]byte(nil), entropy[:32]...), []byte{0, 0, 0, 0}, 0, 0, true)
+		if req.reveal {
+			report.Entropy = hex.EncodeToString(entropy)
+			report.Xprv = child.String()
+		}
+	case "wif":
+		priv, pub := btcec.PrivKeyFromBytes(entropy[:32])
+		defer priv.Zero()
+		report.PublicKey = hex.EncodeToString(pub.SerializeCompressed())
+		if req.reveal {
+			wif, err := btcutil.NewWIF(priv, net, true)
+			if err != nil {
+				return nil, err
+			}
+			report.Entropy = hex.EncodeToString(entropy[:32])
+			report.WIF = wif.String()
+		}
+	case "hex":
+		report.Bytes = req.bytes
+		if req.reveal {
+			report.Entropy = hex.EncodeToString(entropy[:req.bytes])
+		}
+	}
+	if child == nil {
+		return report, nil
+	}
+	defer child.Zero()
+	if err := describeChildWallet(report, child, req, network); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// describeChildWallet fills in the account xpub and descriptor of the
+// wallet a child master key seeds, at the BIP-44 style account of the
+// requested script type.
+func describeChildWallet(report *Bip85Report, child *hdkeychain.ExtendedKey, req *bip85Request, network string) error {
+	pub, err := child.ECPubKey()
+	if err != nil {
+		return err
+	}
+	fingerprint := hex.EncodeToString(btcutil.Hash160(pub.SerializeCompressed())[:4])
+	path, err := accountPath(req.scriptType, network, req.account)
+	if err != nil {
+		return err
+	}
+	account, err := deriveKeyPath(child, path)
+	if err != nil {
+		return err
+	}
+	defer account.Zero()
+	xpub, err := account.Neuter()
+	if err != nil {
+		return err
+	}
+	report.Fingerprint = fingerprint
+	report.AccountPath = formatPath(path)
+	report.AccountXpub = xpub.String()
+	spec := &WalletSpec{
+		Network:    network,
+		ScriptType: req.scriptType,
+		Keys:       []WalletKey{{Fingerprint: fingerprint, Path: report.AccountPath, Xpub: report.AccountXpub}},
+	}
+	report.Descriptor, err = walletDescriptor(spec, false)
+	return err
+}
+
+// deriveKeyPath derives the key at path below key, zeroing the keys in
+// between.
+func deriveKeyPath(key *hdkeychain.ExtendedKey, path []uint32) (*hdkeychain.ExtendedKey, error) {
+	ext := key
+	for _, i := range path {
+		child, err := ext.Derive(i)
+		if ext != key {
+			ext.Zero()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive child %d: %v", i, err)
+		}
+		ext = child
+	}
+	return ext, nil
+}
+
+// parseBip85Request parses bip85's --app, --words, --bytes, --index,
+// --type and --account flags.
+func parseBip85Request(flags map[string]string, reveal bool) (*bip85Request, error) {
+	req := &bip85Request{app: flags["app"], scriptType: "native_segwit", reveal: reveal}
+	if _, ok := bip85Applications[req.app]; !ok {
+		return nil, fmt.Errorf("unknown BIP-85 application: %q (want bip39, xprv, wif or hex)", req.app)
+	}
+	var err error
+	if req.index, err = parseIndex(flags["index"]); err != nil {
+		return nil, err
+	}
+	if value, ok := flags["words"]; ok || req.app == "bip39" {
+		if req.app != "bip39" {
+			return nil, fmt.Errorf("--words applies to the bip39 application only")
+		}
+		req.words = 12
+		if ok {
+			n, err := strconv.Atoi(value)
+			if _, known := bip85MnemonicLengths[n]; err != nil || !known {
+				return nil, fmt.Errorf("invalid word count: %q (want 12, 18 or 24)", value)
+			}
+			req.words = n
+		}
+	}
+	if value, ok := flags["bytes"]; ok || req.app == "hex" {
+		if req.app != "hex" {
+			return nil, fmt.Errorf("--bytes applies to the hex application only")
+		}
+		req.bytes = 64
+		if ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 16 || n > 64 {
+				return nil, fmt.Errorf("invalid byte count: %q (want 16 to 64)", value)
+			}
+			req.bytes = n
+		}
+	}
+	_, hasType := flags["type"]
+	_, hasAccount := flags["account"]
+	if (hasType || hasAccount) && req.app != "bip39" && req.app != "xprv" {
+		return nil, fmt.Errorf("--type and --account apply to the bip39 and xprv applications only")
+	}
+	if hasType {
+		req.scriptType = flags["type"]
+		if _, ok := purposeOfScriptType(req.scriptType); !ok {
+			return nil, fmt.Errorf("no BIP-44 style account for script type %q (want legacy, nested_segwit, native_segwit or taproot)", req.scriptType)
+		}
+	}
+	if hasAccount {
+		if req.account, err = parseIndex(flags["account"]); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// secretMasterKey reads bip85's master key from --key-env or --key-fd.
+func secretMasterKey(env, fd string) (*hdkeychain.ExtendedKey, error) {
+	secret, err := secretInput(env, fd)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	key, err := hdkeychain.NewKeyFromString(string(bytes.TrimSpace(secret)))
+	if err != nil {
+		return nil, errorWithCode(errInvalidKey, "invalid extended key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BIP-158 basic block filters, as the Neutrino backend reads them. A
+// filter is a Golomb-coded set of every output script a block creates and
+// every output script its inputs spend, so a client can tell from it
+// (with false positives at 1 in M) whether the block touches any script
+// it watches without fetching the block. Only matching is implemented;
+// the filters come from peers and are checked against filter headers.
+
+const (
+	// filterP is the Golomb-Rice parameter of basic filters.
+	filterP = 19
+	// filterM is the inverse false positive rate of basic filters.
+	filterM = 784931
+)
+
+// blockFilter is a decoded basic filter.
+type blockFilter struct {
+	n    uint64
+	data []byte
+}
+
+// parseBlockFilter reads a serialized filter: its element count as a
+// CompactSize, then the Golomb-Rice coded deltas.
+func parseBlockFilter(raw []byte) (*blockFilter, error) {
+	r := bytes.NewReader(raw)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block filter: %v", err)
+	}
+	return &blockFilter{n: n, data: raw[len(raw)-r.Len():]}, nil
+}
+
+// filterHash is how a filter is committed to in its filter header.
+func filterHash(raw []byte) chainhash.Hash {
+	return chainhash.DoubleHashH(raw)
+}
+
+// nextFilterHeader chains a filter's hash onto the previous block's filter
+// header, as BIP-157 defines the filter header chain.
+func nextFilterHeader(hash, prev chainhash.Hash) chainhash.Hash {
+	return chainhash.DoubleHashH(append(hash[:], prev[:]...))
+}
+
+// matchAny reports whether any of scripts is in the filter of the block
+// with the given hash.
+func (f *blockFilter) matchAny(block chainhash.Hash, scripts [][]byte) (bool, error) {
+	if f.n == 0 || len(scripts) == 0 {
+		return false, nil
+	}
+	k0 := binary.LittleEndian.Uint64(block[0:8])
+	k1 := binary.LittleEndian.Uint64(block[8:16])
+	modulus := f.n * filterM
+	targets := make([]uint64, len(scripts))
+	for i, script := range scripts {
+		targets[i], _ = bits.Mul64(sipHash24(k0, k1, script), modulus)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	r := bitReader{data: f.data}
+	var value uint64
+	for i := uint64(0); i < f.n; i++ {
+		delta, err := r.golombRice()
+		if err != nil {
+			return false, err
+		}
+		value += delta
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false, nil
+		}
+		if targets[0] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// bitReader reads a bit stream most significant bit first.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) bit() (uint64, error) {
+	if r.pos >= 8*len(r.data) {
+		return 0, fmt.Errorf("invalid block filter: truncated")
+	}
+	b := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint64(b), nil
+}
+
+// golombRice reads one value: its quotient in unary, then filterP bits of
+// remainder.
+func (r *bitReader) golombRice() (uint64, error) {
+	var q uint64
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 0 {
+			break
+		}
+		q++
+	}
+	value := q
+	for i := 0; i < filterP; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | b
+	}
+	return value, nil
+}
+
+// sipHash24 is SipHash-2-4 keyed by k0 and k1, as BIP-158 hashes filter
+// elements with.
+func sipHash24(k0, k1 uint64, msg []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	compress := func(m uint64) {
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	n := len(msg)
+	for len(msg) >= 8 {
+		compress(binary.LittleEndian.Uint64(msg))
+		msg = msg[8:]
+	}
+	var last [8]byte
+	copy(last[:], msg)
+	last[7] = byte(n)
+	compress(binary.LittleEndian.Uint64(last[:]))
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// scan-block reads one block and lists the transactions in it that pay or
+// spend the wallet's addresses. The addresses are those monitor watches:
+// each chain up to the gap limit past its last used index, or --range. A
+// block the monitor has seen can so be checked against the events it
+// gave, and a missed block's history recovered without an address index.
+// An output is the wallet's by its script. An input names only the output
+// it spends, which is the wallet's if an earlier transaction in the block
+// created it, or if the script its witness or scriptSig reveals is; a
+// P2PK or taproot key-path spend reveals none, and with --prevouts the
+// previous transaction is fetched instead.
+
+// BlockScanReport lists the transactions of a block touching the wallet.
+type BlockScanReport struct {
+	Network string `json:"network"`
+	Hash    string `json:"hash"`
+	// Height is omitted when the block was named by hash and its coinbase
+	// does not give it.
+	Height       *int64 `json:"height,omitempty"`
+	Time         string `json:"time"`
+	Transactions int    `json:"transactions"`
+	// Receive and Change are the index ranges searched.
+	Receive indexRange `json:"receive"`
+	Change  indexRange `json:"change"`
+	// Unresolved counts the inputs whose spent output is not known to be
+	// the wallet's or not, so a spend among them would be missed.
+	Unresolved int           `json:"unresolved_inputs"`
+	Matches    []BlockScanTx `json:"matches"`
+	Received   int64         `json:"received"`
+	// Spent is omitted when the value of a wallet input is not known.
+	Spent *int64 `json:"spent,omitempty"`
+}
+
+// BlockScanTx is one transaction touching the wallet, with only its
+// wallet inputs and outputs.
+type BlockScanTx struct {
+	TxID string `json:"txid"`
+	// Position is the transaction's index in the block.
+	Position int               `json:"position"`
+	Inputs   []BlockScanInput  `json:"inputs"`
+	Outputs  []BlockScanOutput `json:"outputs"`
+	Received int64             `json:"received"`
+	Spent    *int64            `json:"spent,omitempty"`
+}
+
+// BlockScanInput is an input spending a wallet output. MatchedBy is
+// "block" when an earlier transaction in the block created the output,
+// "script" when the input reveals its script and "prevout" when the
+// previous transaction was fetched.
+type BlockScanInput struct {
+	Vin       int     `json:"vin"`
+	Outpoint  string  `json:"outpoint"`
+	Address   string  `json:"address"`
+	Value     *int64  `json:"value,omitempty"`
+	MatchedBy string  `json:"matched_by"`
+	Wallet    TxOwner `json:"wallet"`
+}
+
+// BlockScanOutput is an output paying a wallet address.
+type BlockScanOutput struct {
+	Vout    int     `json:"vout"`
+	Address string  `json:"address"`
+	Value   int64   `json:"value"`
+	Wallet  TxOwner `json:"wallet"`
+}
+
+// blockScanRanges returns the index ranges of each chain scan-block
+// searches: r on both, or each chain up to gap past its last used index.
+func blockScanRanges(q *chainQuery) (map[bool]*indexRange, error) {
+	ranges := map[bool]*indexRange{}
+	for _, change := range []bool{false, true} {
+		if q.r != nil {
+			ranges[change] = q.r
+			continue
+		}
+		scan, err := scanChain(q.spec, q.backend, q.store, change, q.gap)
+		if err != nil {
+			return nil, err
+		}
+		ranges[change] = &indexRange{Start: 0, End: uint32(scan.LastUsed + int64(q.gap))}
+	}
+	return ranges, nil
+}
+
+// resolveBlock returns the hash of the block named by ref, a height or a
+// block hash, and the height when ref gives it.
+func resolveBlock(backend ChainBackend, ref string) (string, *int64, error) {
+	if len(ref) == 64 {
+		if _, err := hex.DecodeString(ref); err == nil {
+			return ref, nil, nil
+		}
+	}
+	height, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil || height < 0 {
+		return "", nil, fmt.Errorf("invalid block: %q is neither a height nor a block hash", ref)
+	}
+	hash, err := backendBlockHash(backend, height)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to look up block %d: %w", height, err)
+	}
+	return hash, &height, nil
+}
+
+// scanBlock finds the transactions of block touching the addresses in
+// ranges. With prevouts, the previous transactions of inputs that do not
+// reveal their script, and of wallet inputs for their value, are fetched
+// through q's backend.
+func scanBlock(q *chainQuery, block *wire.MsgBlock, ranges map[bool]*indexRange, prevouts bool) (*BlockScanReport, error) {
+	owners, err := rangeScripts(q.spec, ranges)
+	if err != nil {
+		return nil, err
+	}
+	net := getNetwork(q.spec.Network)
+	r := &BlockScanReport{
+		Network:      q.spec.Network,
+		Hash:         block.BlockHash().String(),
+		Time:         block.Header.Timestamp.UTC().Format(time.RFC3339),
+		Transactions: len(block.Transactions),
+		Receive:      *ranges[false],
+		Change:       *ranges[true],
+		Matches:      []BlockScanTx{},
+	}
+	if height, ok := coinbaseHeight(block); ok {
+		r.Height = &height
+	}
+
+	// created holds the wallet outputs of the block's transactions so far,
+	// which later transactions in it may spend.
+	type walletOutput struct {
+		script []byte
+		value  int64
+	}
+	created := map[wire.OutPoint]walletOutput{}
+	spent, spentKnown := int64(0), true
+	for pos, tx := range block.Transactions {
+		txid := tx.TxHash()
+		m := BlockScanTx{TxID: txid.String(), Position: pos, Inputs: []BlockScanInput{}, Outputs: []BlockScanOutput{}}
+		txSpent, txSpentKnown := int64(0), true
+		// The coinbase spends nothing.
+		var inputs []*wire.TxIn
+		if pos > 0 {
+			inputs = tx.TxIn
+		}
+		for i, in := range inputs {
+			var script []byte
+			var value *int64
+			matchedBy := "block"
+			if o, ok := created[in.PreviousOutPoint]; ok {
+				script, value = o.script, &o.value
+			} else if script = spentScript(in); script != nil {
+				matchedBy = "script"
+			}
+			_, own := owners[string(script)]
+			if prevouts && (script == nil || own && value == nil) {
+				prev, err := fetchTransaction(q.backend, in.PreviousOutPoint.Hash.String())
+				if err != nil {
+					return nil, fmt.Errorf("transaction %s input %d: %v", txid, i, err)
+				}
+				if int(in.PreviousOutPoint.Index) >= len(prev.TxOut) {
+					return nil, fmt.Errorf("transaction %s input %d spends output %d of %s, which has %d", txid, i, in.PreviousOutPoint.Index, in.PreviousOutPoint.Hash, len(prev.TxOut))
+				}
+				out := prev.TxOut[in.PreviousOutPoint.Index]
+				script, value, matchedBy = out.PkScript, &out.Value, "prevout"
+			}
+			if script == nil {
+				r.Unresolved++
+				continue
+			}
+			owner, ok := owners[string(script)]
+			if !ok {
+				continue
+			}
+			d := BlockScanInput{Vin: i, Outpoint: in.PreviousOutPoint.String(), Value: value, MatchedBy: matchedBy, Wallet: owner}
+			_, d.Address = describeScript(script, net)
+			if value != nil {
+				txSpent += *value
+			} else {
+				txSpentKnown = false
+			}
+			m.Inputs = append(m.Inputs, d)
+		}
+		for j, out := range tx.TxOut {
+			owner, ok := owners[string(out.PkScript)]
+			if !ok {
+				continue
+			}
+			created[wire.OutPoint{Hash: txid, Index: uint32(j)}] = walletOutput{script: out.PkScript, value: out.Value}
+			d := BlockScanOutput{Vout: j, Value: out.Value, Wallet: owner}
+			_, d.Address = describeScript(out.PkScript, net)
+			m.Received += out.Value
+			m.Outputs = append(m.Outputs, d)
+		}
+		if len(m.Inputs) == 0 && len(m.Outputs) == 0 {
+			continue
+		}
+		if txSpentKnown {
+			if len(m.Inputs) > 0 {
+				m.Spent = &txSpent
+			}
+		} else {
+			spentKnown = false
+		}
+		spent += txSpent
+		r.Received += m.Received
+		r.Matches = append(r.Matches, m)
+	}
+	if spentKnown {
+		r.Spent = &spent
+	}
+	return r, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// blueWalletFormats maps the setup file "Format:" values to script types.
+var blueWalletFormats = map[string]string{
+	"P2WSH":      "p2wsh",
+	"P2SH-P2WSH": "p2sh_p2wsh",
+	"P2WSH-P2SH": "p2sh_p2wsh",
+	"P2SH":       "p2sh",
+}
+
+// slip132MultisigTypes maps SLIP-132 multisig key prefixes to the script
+// type they declare.
+var slip132MultisigTypes = map[string]string{
+	"Zpub": "p2wsh",
+	"Vpub": "p2wsh",
+	"Ypub": "p2sh_p2wsh",
+	"Upub": "p2sh_p2wsh",
+}
+
+// isBlueWalletSetup reports whether text looks like a BlueWallet (or
+// Coldcard-compatible) multisig setup file.
+func isBlueWalletSetup(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "Policy:") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBlueWalletSetup parses the multisig setup file format:
+//
+//	Name: Vault
+//	Policy: 2 of 3
+//	Derivation: m/48'/0'/0'/2'
+//	Format: P2WSH
+//
+//	73C5DA0A: Zpub...
+//
+// A "Derivation:" (or "# derivation:") line applies to the keys after it.
+func parseBlueWalletSetup(text string) (*WalletSpec, error) {
+	spec := &WalletSpec{ScriptType: "p2sh"}
+	total := 0
+	derivation := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			comment := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			if strings.HasPrefix(strings.ToLower(comment), "derivation:") {
+				derivation = strings.TrimSpace(comment[len("derivation:"):])
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		sep := strings.Index(line, ":")
+		if sep < 0 {
+			return nil, fmt.Errorf("setup file line %d: expected \"key: value\"", lineNo)
+		}
+		field, value := strings.TrimSpace(line[:sep]), strings.TrimSpace(line[sep+1:])
+
+		switch strings.ToLower(field) {
+		case "name":
+			spec.Name = value
+		case "policy":
+			var err error
+			spec.Threshold, total, err = parsePolicy(value)
+			if err != nil {
+				return nil, fmt.Errorf("setup file line %d: %v", lineNo, err)
+			}
+		case "derivation":
+			derivation = value
+		case "format":
+			scriptType, ok := blueWalletFormats[strings.ToUpper(value)]
+			if !ok {
+				return nil, fmt.Errorf("setup file line %d: unsupported format %q", lineNo, value)
+			}
+			spec.ScriptType = scriptType
+		default:
+			if _, err := hex.DecodeString(field); err != nil || len(field) != 8 {
+				return nil, fmt.Errorf("setup file line %d: invalid fingerprint %q", lineNo, field)
+			}
+			if len(value) < 12 {
+				return nil, fmt.Errorf("setup file line %d: invalid extended key", lineNo)
+			}
+			spec.Keys = append(spec.Keys, WalletKey{
+				Xpub:        value,
+				Fingerprint: strings.ToLower(field),
+				Path:        derivation,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read setup file: %v", err)
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("setup file has no Policy line")
+	}
+	if len(spec.Keys) != total {
+		return nil, fmt.Errorf("setup file policy expects %d keys, found %d", total, len(spec.Keys))
+	}
+	for _, k := range spec.Keys {
+		if declared, ok := slip132MultisigTypes[k.Xpub[:4]]; ok && declared != spec.ScriptType {
+			return nil, fmt.Errorf("key %s... is a %s key but the setup file format is %s", k.Xpub[:12], declared, spec.ScriptType)
+		}
+	}
+	spec.Network = networkFromXpub(spec.Keys[0].Xpub)
+
+	return spec, nil
+}
+
+// parsePolicy parses an "M of N" policy.
+func parsePolicy(policy string) (int, int, error) {
+	parts := strings.Fields(policy)
+	if len(parts) != 3 || parts[1] != "of" {
+		return 0, 0, fmt.Errorf("invalid policy %q", policy)
+	}
+	m, errM := strconv.Atoi(parts[0])
+	n, errN := strconv.Atoi(parts[2])
+	if errM != nil || errN != nil || m < 1 || m > n {
+		return 0, 0, fmt.Errorf("invalid policy %q", policy)
+	}
+	return m, n, nil
+}
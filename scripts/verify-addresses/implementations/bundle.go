@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const walletBundleVersion = 1
+
+// WalletBundle is the archival export of a wallet: both descriptors, the
+// first addresses of each chain, and cosigner origins, sealed by a hash of
+// its own content. Feeding a bundle back in as a wallet spec re-derives and
+// checks every archived address.
+type WalletBundle struct {
+	Version           int              `json:"version"`
+	Name              string           `json:"name,omitempty"`
+	Network           string           `json:"network"`
+	ScriptType        string           `json:"script_type"`
+	Threshold         int              `json:"threshold,omitempty"`
+	ReceiveDescriptor string           `json:"receive_descriptor"`
+	ChangeDescriptor  string           `json:"change_descriptor"`
+	Cosigners         []WalletKey      `json:"cosigners"`
+	Receive           []DerivedAddress `json:"receive"`
+	Change            []DerivedAddress `json:"change"`
+	ContentHash       string           `json:"content_hash,omitempty"`
+}
+
+// contentHash hashes the bundle's JSON encoding with the hash field unset.
+func (b WalletBundle) contentHash() (string, error) {
+	b.ContentHash = ""
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func exportWalletBundle(spec *WalletSpec, count int) (*WalletBundle, error) {
+	report, err := verifyWallet(spec, count)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Verified {
+		return nil, fmt.Errorf("refusing to export: wallet spec addresses do not match derivation")
+	}
+	changeDescriptor, err := walletDescriptor(spec, true)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &WalletBundle{
+		Version:           walletBundleVersion,
+		Name:              spec.Name,
+		Network:           spec.Network,
+		ScriptType:        spec.ScriptType,
+		Threshold:         spec.Threshold,
+		ReceiveDescriptor: report.Descriptor,
+		ChangeDescriptor:  changeDescriptor,
+		Cosigners:         spec.Keys,
+		Receive:           report.Receive,
+		Change:            report.Change,
+	}
+	if bundle.ContentHash, err = bundle.contentHash(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// isWalletBundle reports whether a JSON object is an exported bundle.
+func isWalletBundle(probe map[string]json.RawMessage) bool {
+	_, ok := probe["receive_descriptor"]
+	return ok
+}
+
+// parseWalletBundle checks a bundle's content hash and turns it back into a
+// wallet spec whose expected addresses are everything the bundle archived.
+func parseWalletBundle(data []byte) (*WalletSpec, error) {
+	var b WalletBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet bundle: %v", err)
+	}
+	if b.Version != walletBundleVersion {
+		return nil, fmt.Errorf("unsupported wallet bundle version %d", b.Version)
+	}
+	hash, err := b.contentHash()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(hash, b.ContentHash) {
+		return nil, fmt.Errorf("wallet bundle content hash mismatch: got %s, computed %s", b.ContentHash, hash)
+	}
+
+	spec, err := parseDescriptor(b.ReceiveDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("bundle receive descriptor: %v", err)
+	}
+	change, err := parseDescriptor(b.ChangeDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("bundle change descriptor: %v", err)
+	}
+	if !sameWalletKeys(spec, change) {
+		return nil, fmt.Errorf("bundle change descriptor does not match receive descriptor")
+	}
+	if spec.ScriptType != b.ScriptType || spec.Network != b.Network {
+		return nil, fmt.Errorf("bundle metadata does not match its descriptors")
+	}
+
+	spec.Name = b.Name
+	if len(b.Cosigners) == len(spec.Keys) {
+		for i, c := range b.Cosigners {
+			spec.Keys[i].Device = c.Device
+			spec.Keys[i].Label = c.Label
+		}
+	}
+	for _, a := range b.Receive {
+		spec.Expected = append(spec.Expected, ExpectedAddress{Index: a.Index, Address: a.Address})
+	}
+	for _, a := range b.Change {
+		spec.Expected = append(spec.Expected, ExpectedAddress{Change: true, Index: a.Index, Address: a.Address})
+	}
+	return spec, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// With --cache, wallet addresses are cached in the --store database keyed
+// by (wallet ID, chain, index), so repeated audits of a large wallet skip
+// the derivations and only check each entry's checksum. The ID cached under
+// also names the engine and curve backend that derived the address, so an
+// address is only ever answered by the code that computed it: --engine
+// internal is not served what btcsuite derived. The checksum
+// catches corruption and hand edits; it is not a MAC, so --paranoid, which
+// exists for distrusting the machine, always derives afresh.
+
+// cacheFlushSize is how many new entries are buffered before they are
+// written in one transaction.
+const cacheFlushSize = 1000
+
+type derivationCache struct {
+	path    string
+	store   *walletStore
+	ids     map[*WalletSpec]string
+	pending []cacheEntry
+	hits    int
+	misses  int
+}
+
+type cacheEntry struct {
+	id      string
+	change  bool
+	index   uint32
+	address string
+}
+
+// activeCache is the cache of the current --store, opened on first use.
+var activeCache *derivationCache
+
+// derivationCacheFor returns the cache to use, or nil when caching is off.
+func derivationCacheFor() (*derivationCache, error) {
+	if !options.cache || options.paranoid || options.storePath == "" {
+		return nil, nil
+	}
+	if activeCache != nil && activeCache.path == options.storePath {
+		return activeCache, nil
+	}
+	closeDerivationCache()
+	store, err := openStore(options.storePath)
+	if err != nil {
+		return nil, err
+	}
+	activeCache = &derivationCache{path: options.storePath, store: store, ids: map[*WalletSpec]string{}}
+	return activeCache, nil
+}
+
+// closeDerivationCache writes any buffered entries and closes the cache.
+func closeDerivationCache() {
+	c := activeCache
+	if c == nil {
+		return
+	}
+	activeCache = nil
+	if err := c.flush(); err != nil {
+		diagnostic("failed to write derivation cache: %v", err)
+	}
+	if options.verbose {
+		diagnostic("derivation cache: %d hits, %d misses", c.hits, c.misses)
+	}
+	c.store.Close()
+}
+
+// cacheChecksum binds an address to its wallet, chain and index.
+func cacheChecksum(e cacheEntry) string {
+	h := sha256.New()
+	h.Write([]byte(e.id))
+	var pos [5]byte
+	if e.change {
+		pos[0] = 1
+	}
+	binary.BigEndian.PutUint32(pos[1:], e.index)
+	h.Write(pos[:])
+	h.Write([]byte(e.address))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// walletID returns the cache key of a wallet under the selected engine and
+// curve backend, or "" for wallets without a descriptor form, which are not
+// cached.
+func (c *derivationCache) walletID(spec *WalletSpec) string {
+	id, ok := c.ids[spec]
+	if !ok {
+		if id, _ = walletID(spec); id != "" {
+			sum := sha256.Sum256([]byte(id + "\x00" + selectedEngine().name + "\x00" + activeCurve.name))
+			id = hex.EncodeToString(sum[:16])
+		}
+		c.ids[spec] = id
+	}
+	return id
+}
+
+// lookup returns a cached address whose checksum holds. A corrupt entry is
+// reported and derived again.
+func (c *derivationCache) lookup(spec *WalletSpec, change bool, index uint32) (string, bool, error) {
+	e := cacheEntry{id: c.walletID(spec), change: change, index: index}
+	if e.id == "" {
+		return "", false, nil
+	}
+	var checksum string
+	err := c.store.db.QueryRow(
+		`SELECT address, checksum FROM derivation_cache WHERE wallet_id = ? AND change = ? AND idx = ?`,
+		e.id, change, index,
+	).Scan(&e.address, &checksum)
+	if err == sql.ErrNoRows {
+		c.misses++
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read derivation cache: %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(cacheChecksum(e)), []byte(checksum)) != 1 {
+		diagnostic("derivation cache entry %s/%s fails its checksum; deriving it again", e.id, chainIndex(change, index))
+		c.misses++
+		return "", false, nil
+	}
+	c.hits++
+	return e.address, true, nil
+}
+
+// add buffers a derived address for the cache.
+func (c *derivationCache) add(spec *WalletSpec, change bool, index uint32, address string) error {
+	e := cacheEntry{id: c.walletID(spec), change: change, index: index, address: address}
+	if e.id == "" {
+		return nil
+	}
+	c.pending = append(c.pending, e)
+	if len(c.pending) >= cacheFlushSize {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *derivationCache) flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	tx, err := c.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, e := range c.pending {
+		if _, err := tx.Exec(
+			`INSERT INTO derivation_cache (wallet_id, change, idx, address, checksum) VALUES (?, ?, ?, ?, ?)
+			 ON CONFLICT(wallet_id, change, idx) DO UPDATE SET address = excluded.address, checksum = excluded.checksum`,
+			e.id, e.change, e.index, e.address, cacheChecksum(e),
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to cache address: %v", err)
+		}
+	}
+	c.pending = nil
+	return tx.Commit()
+}
@@ -0,0 +1,115 @@
+package main
+
+// capabilitiesVersion is bumped whenever the meaning of an advertised
+// capability changes, so orchestrators never have to guess from the set
+// alone.
+const capabilitiesVersion = 1
+
+// Capabilities is what check advertises about this binary, letting an
+// orchestrator negotiate features across mixed verifier versions during
+// rolling upgrades.
+type Capabilities struct {
+	Version             int      `json:"version"`
+	Commands            []string `json:"commands"`
+	ScriptTypes         []string `json:"script_types"`
+	MultisigScriptTypes []string `json:"multisig_script_types"`
+	Networks            []string `json:"networks"`
+	WalletFormats       []string `json:"wallet_formats"`
+	Backends            []string `json:"backends"`
+	Formats             []string `json:"formats"`
+	Features            []string `json:"features"`
+	CurveBackend        string   `json:"curve_backend"`
+	Engines             []string `json:"engines"`
+	// Offline is set when network access is disabled; Backends then only
+	// lists those that need no network.
+	Offline bool `json:"offline"`
+}
+
+var walletSpecFormats = []string{
+	"json",
+	"descriptor",
+	"specter",
+	"bluewallet",
+	"ur",
+	"bundle",
+}
+
+// Feature flags for behavior that isn't visible from the command list.
+var featureFlags = []string{
+	"bip67-sorted-multisig",
+	"bip86-taproot",
+	"descriptor-checksum",
+	"slip132-keys",
+	"ur-fountain",
+	"ur-hdkey-xpub-args",
+	"stdin-wallet-spec",
+	"bip137-signed-message",
+	"self-check-deep",
+	"musig2-descriptors",
+	"taproot-script-tree",
+	"frost-groups-experimental",
+	"paranoid-dual-engine",
+	"socks5-proxy",
+	"electrum-certificate-pinning",
+	"backend-consensus",
+	"otlp-tracing",
+	"secret-key-input",
+	"age-encrypted-specs",
+	"signed-vectors",
+	"signed-results",
+	"monitor-webhooks",
+	"core-zmq",
+	"hardened-private-derivation",
+	"bip85",
+	"bip39-mnemonic-restore",
+	"slip39-shares",
+	"seed-xor",
+	"passphrase-search",
+	"lookalike-detection",
+	"bech32-typo-hints",
+	"migration-map",
+	"sweep-psbt",
+	"dust-analysis",
+	"fee-estimation",
+	"coin-selection",
+	"size-estimation",
+	"taproot-spend-paths",
+	"psbt-lint",
+	"psbt-combine",
+	"psbt-ur-encode",
+	"tx-decode", "block-scan",
+}
+
+func capabilities() *Capabilities {
+	c := &Capabilities{
+		Version:             capabilitiesVersion,
+		ScriptTypes:         scriptTypeNames(false),
+		MultisigScriptTypes: scriptTypeNames(true),
+		Networks:            networkNames,
+		WalletFormats:       walletSpecFormats,
+		Backends:            backendNames(),
+		Formats:             outputFormats,
+		Features:            featureFlags,
+		CurveBackend:        activeCurve.name,
+		Engines:             engineNames(),
+		Offline:             offlineMode(),
+	}
+	if c.Offline {
+		c.Backends = []string{}
+		for _, name := range backendNames() {
+			if localBackends[name] {
+				c.Backends = append(c.Backends, name)
+			}
+		}
+	}
+	if storeDriver != "" {
+		c.Features = append(append([]string{}, featureFlags...), "wallet-store", "wallet-registry")
+		if osKeychain != nil {
+			c.Features = append(c.Features, "os-keychain")
+		}
+	}
+	for _, cmd := range commands {
+		c.Commands = append(c.Commands, cmd.name)
+	}
+	return c
+}
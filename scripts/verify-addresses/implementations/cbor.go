@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// cborTag is a tagged CBOR data item.
+type cborTag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// decodeCBOR decodes a single CBOR data item. Only the subset used by UR
+// payloads is supported: integers, byte/text strings, arrays, maps, tags,
+// booleans and null. Maps decode to map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, error) {
+	v, rest, err := decodeCBORItem(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("cbor: %d trailing bytes", len(rest))
+	}
+	return v, nil
+}
+
+func decodeCBORItem(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	arg, data, err := decodeCBORArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		return arg, data, nil
+	case 1:
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if uint64(len(data)) < arg {
+			return nil, nil, fmt.Errorf("cbor: string length %d exceeds data", arg)
+		}
+		if major == 2 {
+			return append([]byte(nil), data[:arg]...), data[arg:], nil
+		}
+		return string(data[:arg]), data[arg:], nil
+	case 4:
+		if uint64(len(data)) < arg {
+			return nil, nil, fmt.Errorf("cbor: array length %d exceeds data", arg)
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, data, err = decodeCBORItem(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if uint64(len(data)) < arg {
+			return nil, nil, fmt.Errorf("cbor: map length %d exceeds data", arg)
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			key, data, err = decodeCBORItem(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case uint64, int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			value, data, err = decodeCBORItem(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	case 6:
+		var content interface{}
+		content, data, err = decodeCBORItem(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cborTag{Number: arg, Content: content}, data, nil
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case info >= 28:
+		return 0, nil, fmt.Errorf("cbor: indefinite or reserved length %d not supported", info)
+	}
+	return 0, nil, fmt.Errorf("cbor: unexpected end of data")
+}
+
+// cborUint extracts an unsigned integer value.
+func cborUint(v interface{}) (uint64, bool) {
+	u, ok := v.(uint64)
+	return u, ok
+}
+
+// cborMap looks up an integer key in a decoded CBOR map.
+func cborMap(v interface{}, key uint64) (interface{}, bool) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	value, ok := m[key]
+	return value, ok
+}
+
+// encodeCBOR encodes a value decoded from JSON (with json.Decoder.UseNumber)
+// as CBOR. Map keys are sorted as RFC 8949 core deterministic encoding
+// requires, so the same document always encodes to the same bytes.
+func encodeCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case string:
+		return append(appendCBORHead(b, 3, uint64(len(v))), v...), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if n < 0 {
+				return appendCBORHead(b, 1, uint64(-1-n)), nil
+			}
+			return appendCBORHead(b, 0, uint64(n)), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("cbor: invalid number %s", v)
+		}
+		b = append(b, 0xfb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case []interface{}:
+		b = appendCBORHead(b, 4, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = encodeCBOR(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// Encoded text keys sort by length first, then bytewise.
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		b = appendCBORHead(b, 5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(appendCBORHead(b, 3, uint64(len(k))), k...)
+			var err error
+			if b, err = encodeCBOR(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cbor: cannot encode %T", v)
+}
+
+// appendCBORHead appends the initial byte and shortest argument for a
+// major type.
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+}
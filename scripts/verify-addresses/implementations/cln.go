@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Core Lightning derives every wallet key at m/0/0/<index> below the BIP-32
+// root of its hsm_secret, with no separate change chain: change outputs
+// take the next index like deposits do. Each index has both a p2wpkh
+// ("bech32") and a BIP-86 p2tr address, as `lightning-cli newaddr all`
+// shows. `lightning-hsmtool dumponchaindescriptors` prints the root xpub.
+
+// clnKeySuffix is the path of CLN wallet keys below the root.
+const clnKeySuffix = "/0/0/*"
+
+// CLNReport lists the wallet addresses of a CLN node, and where an address
+// shown by newaddr sits among them.
+type CLNReport struct {
+	Network   string       `json:"network"`
+	Xpub      string       `json:"xpub"`
+	Addresses []CLNAddress `json:"addresses"`
+	Address   string       `json:"address,omitempty"`
+	// Match locates Address; it is nil if no derived address matched.
+	Match *CLNMatch `json:"match,omitempty"`
+	// Verified is set when the given address was found, or when no
+	// address was given.
+	Verified bool `json:"verified"`
+}
+
+// CLNAddress is the pair of addresses of one wallet key.
+type CLNAddress struct {
+	Index  uint32 `json:"index"`
+	Path   string `json:"path"`
+	Bech32 string `json:"bech32"`
+	P2TR   string `json:"p2tr"`
+}
+
+// CLNMatch is the index and address type an address was derived at.
+type CLNMatch struct {
+	Index uint32 `json:"index"`
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+}
+
+// clnKeyPattern finds root keys, with any path suffix, in hsmtool output.
+var clnKeyPattern = regexp.MustCompile(`\b([xt]pub[1-9A-HJ-NP-Za-km-z]+)(/[0-9/*]+)?`)
+
+// loadCLNRoot reads the root xpub from an xpub or the descriptors printed
+// by hsmtool, as a file, inline text or "-" for stdin.
+func loadCLNRoot(arg string) (xpub, network string, err error) {
+	var data []byte
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if os.IsNotExist(err) {
+			data, err = []byte(arg), nil
+		}
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read CLN root key: %v", err)
+	}
+
+	for _, m := range clnKeyPattern.FindAllStringSubmatch(string(data), -1) {
+		if m[2] != "" && m[2] != clnKeySuffix {
+			return "", "", fmt.Errorf("CLN wallet keys are derived at %s, not %s", clnKeySuffix, m[2])
+		}
+		if xpub != "" && m[1] != xpub {
+			return "", "", fmt.Errorf("CLN descriptors name more than one root key")
+		}
+		xpub = m[1]
+	}
+	if xpub == "" {
+		return "", "", fmt.Errorf("no xpub or tpub found in CLN root key input")
+	}
+	network = "mainnet"
+	if strings.HasPrefix(xpub, "tpub") {
+		network = "testnet"
+	}
+	return xpub, network, nil
+}
+
+// deriveCLNAddresses derives the first count wallet addresses of a CLN
+// node, and looks for address among them.
+func deriveCLNAddresses(xpub, network string, count int, address string) (*CLNReport, error) {
+	report := &CLNReport{Network: network, Xpub: xpub, Addresses: []CLNAddress{}, Address: address}
+	expr := xpub + clnKeySuffix
+	for i := 0; i < count; i++ {
+		index := uint32(i)
+		entry := CLNAddress{Index: index, Path: fmt.Sprintf("m/0/0/%d", index)}
+		var err error
+		if entry.Bech32, err = deriveSingleSig(expr, index, "native_segwit", false, network); err != nil {
+			return nil, err
+		}
+		if entry.P2TR, err = deriveSingleSig(expr, index, "taproot", false, network); err != nil {
+			return nil, err
+		}
+		for _, candidate := range []struct{ kind, derived string }{{"bech32", entry.Bech32}, {"p2tr", entry.P2TR}} {
+			if address != "" && addressesMatch(candidate.derived, address) {
+				report.Match = &CLNMatch{Index: index, Path: entry.Path, Type: candidate.kind}
+			}
+		}
+		report.Addresses = append(report.Addresses, entry)
+	}
+	report.Verified = address == "" || report.Match != nil
+	return report, nil
+}
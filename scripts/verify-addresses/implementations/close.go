@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// A cooperative close pays the node's share to a delivery script, either
+// committed up front (upfront_shutdown_script) or sent in the shutdown
+// message. verify-close confirms that script belongs to the node's own
+// wallet before the close is signed.
+
+// defaultCloseSearch is how many indices of each chain verify-close
+// searches by default.
+const defaultCloseSearch = 1000
+
+// CloseReport is what verify-close found about a delivery script.
+type CloseReport struct {
+	Node     string `json:"node"`
+	Network  string `json:"network"`
+	Delivery string `json:"delivery"`
+	// Script is the delivery scriptPubKey in hex, and Address its address
+	// where it has one.
+	Script   string `json:"script"`
+	Address  string `json:"address,omitempty"`
+	Searched int    `json:"searched"`
+	// Match locates the script in the wallet; it is nil if no derived
+	// address pays to it.
+	Match    *CloseMatch `json:"match,omitempty"`
+	Verified bool        `json:"verified"`
+}
+
+// CloseMatch is a wallet address, with the account and address type for
+// node wallets that have them.
+type CloseMatch struct {
+	Account     string `json:"account,omitempty"`
+	AddressType string `json:"address_type,omitempty"`
+	Change      bool   `json:"change"`
+	Index       uint32 `json:"index"`
+	Path        string `json:"path"`
+	Address     string `json:"address"`
+}
+
+// deliveryScript resolves a delivery address or scriptPubKey hex.
+func deliveryScript(delivery, network string) ([]byte, error) {
+	if script, err := hex.DecodeString(delivery); err == nil && txscript.GetScriptClass(script) != txscript.NonStandardTy {
+		return script, nil
+	}
+	return addressScript(delivery, network)
+}
+
+// closeCandidates derives the first count addresses of each chain of a
+// node wallet: a wallet spec, the output of `lncli wallet accounts list`,
+// or a CLN root key.
+func closeCandidates(node, wallet string, count int) (string, []CloseMatch, error) {
+	var candidates []CloseMatch
+	switch node {
+	case "wallet":
+		spec, err := loadWalletSpec(wallet)
+		if err != nil {
+			return "", nil, err
+		}
+		for i := 0; i < count; i++ {
+			for _, change := range []bool{false, true} {
+				address, err := spec.deriveAddress(change, uint32(i))
+				if err != nil {
+					return "", nil, err
+				}
+				candidates = append(candidates, CloseMatch{Change: change, Index: uint32(i), Path: chainIndex(change, uint32(i)), Address: address})
+			}
+		}
+		return spec.Network, candidates, nil
+
+	case "lnd":
+		accounts, err := loadLNDAccounts(wallet)
+		if err != nil {
+			return "", nil, err
+		}
+		report, err := deriveLNDAddresses(accounts, count, "", "")
+		if err != nil {
+			return "", nil, err
+		}
+		for _, a := range report.Accounts {
+			for _, change := range []bool{false, true} {
+				addresses := a.Receive
+				if change {
+					addresses = a.Change
+				}
+				for _, d := range addresses {
+					candidates = append(candidates, CloseMatch{
+						Account:     a.Name,
+						AddressType: a.AddressType,
+						Change:      change,
+						Index:       d.Index,
+						Path:        a.DerivationPath + "/" + chainIndex(change, d.Index),
+						Address:     d.Address,
+					})
+				}
+			}
+		}
+		return report.Network, candidates, nil
+
+	case "cln":
+		xpub, network, err := loadCLNRoot(wallet)
+		if err != nil {
+			return "", nil, err
+		}
+		report, err := deriveCLNAddresses(xpub, network, count, "")
+		if err != nil {
+			return "", nil, err
+		}
+		for _, a := range report.Addresses {
+			candidates = append(candidates,
+				CloseMatch{AddressType: "bech32", Index: a.Index, Path: a.Path, Address: a.Bech32},
+				CloseMatch{AddressType: "p2tr", Index: a.Index, Path: a.Path, Address: a.P2TR})
+		}
+		return network, candidates, nil
+	}
+	return "", nil, fmt.Errorf("unknown node wallet type: %s (want wallet, lnd or cln)", node)
+}
+
+// verifyCloseAddress checks that a delivery script pays to one of the
+// node wallet's addresses.
+func verifyCloseAddress(node, wallet, delivery string, count int) (*CloseReport, error) {
+	network, candidates, err := closeCandidates(node, wallet, count)
+	if err != nil {
+		return nil, err
+	}
+	script, err := deliveryScript(delivery, network)
+	if err != nil {
+		return nil, err
+	}
+	report := &CloseReport{
+		Node:     node,
+		Network:  network,
+		Delivery: delivery,
+		Script:   hex.EncodeToString(script),
+		Searched: len(candidates),
+	}
+	// P2PK and bare multisig scripts have no address of their own.
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(script, getNetwork(network))
+	if err == nil && len(addrs) == 1 && class != txscript.PubKeyTy && class != txscript.MultiSigTy {
+		report.Address = addrs[0].EncodeAddress()
+	}
+
+	for _, c := range candidates {
+		candidateScript, err := addressScript(c.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		if addressesMatch(hex.EncodeToString(candidateScript), report.Script) {
+			match := c
+			report.Match = &match
+		}
+	}
+	report.Verified = report.Match != nil
+	return report, nil
+}
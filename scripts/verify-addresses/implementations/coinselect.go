@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// simulate-spend shows what a wallet would spend for a payment, without
+// building a transaction. It runs Bitcoin Core's two selection algorithms
+// over the wallet's unspent outputs: branch and bound, which searches for
+// inputs that pay the amount and fee with no change, and the knapsack
+// solver, which approximates the smallest set leaving enough change. Each
+// output counts at its effective value, its value less the fee to spend it,
+// and each selection is scored by Core's waste metric: what its inputs cost
+// now over what they would cost at the long-term fee rate, plus the cost of
+// its change or the excess left to the fee. The knapsack solver is
+// randomized; a fixed seed keeps simulations repeatable.
+
+const (
+	// bnbTries bounds the branch and bound search, as in Core.
+	bnbTries = 100_000
+	// knapsackIterations is how many random subsets the knapsack solver
+	// tries.
+	knapsackIterations = 1000
+	knapsackSeed       = 1
+
+	// minChangeTarget is the change the knapsack solver aims to leave,
+	// Core's CHANGE_LOWER.
+	minChangeTarget = 50_000
+
+	// defaultLongTermFeeRate is Core's -consolidatefeerate in sat/kvB, the
+	// rate change is assumed to be spent at later.
+	defaultLongTermFeeRate = 10_000
+
+	// roundAmount is the granularity below which a payment of whole
+	// multiples reads as made by a person, and its other output as change.
+	roundAmount = 10_000
+)
+
+// SpendSimulation is the outcome of selecting coins for a payment.
+type SpendSimulation struct {
+	Network string `json:"network"`
+	Backend string `json:"backend"`
+	Amount  int64  `json:"amount"`
+	// Recipient is the payment address given with --to; without it the
+	// payment is sized as an output of the wallet's own script type.
+	Recipient       string           `json:"recipient,omitempty"`
+	FeeRate         float64          `json:"fee_rate"`
+	FeeEstimate     *FeeRateEstimate `json:"fee_estimate,omitempty"`
+	LongTermFeeRate float64          `json:"long_term_fee_rate"`
+	// CostOfChange is the fee for a change output now plus the fee to
+	// spend it at the long-term rate.
+	CostOfChange int64 `json:"cost_of_change"`
+	// Available counts the outputs worth more than the fee to spend them
+	// and Spendable sums their effective values.
+	Available int             `json:"available"`
+	Spendable int64           `json:"spendable"`
+	Results   []CoinSelection `json:"results"`
+	// Chosen is the algorithm whose selection wastes least, the one Core
+	// would use.
+	Chosen string `json:"chosen,omitempty"`
+}
+
+// CoinSelection is what one algorithm selected.
+type CoinSelection struct {
+	Algorithm  string          `json:"algorithm"`
+	Found      bool            `json:"found"`
+	Error      string          `json:"error,omitempty"`
+	Inputs     []SelectedInput `json:"inputs"`
+	InputTotal int64           `json:"input_total"`
+	Fee        int64           `json:"fee"`
+	Change     int64           `json:"change"`
+	// Excess is what a selection without change overpays, left to the
+	// fee.
+	Excess  int64    `json:"excess,omitempty"`
+	VSize   int      `json:"vsize"`
+	Waste   int64    `json:"waste"`
+	Privacy []string `json:"privacy"`
+}
+
+// SelectedInput is an output a selection spends.
+type SelectedInput struct {
+	Outpoint string `json:"outpoint"`
+	Address  string `json:"address"`
+	Value    int64  `json:"value"`
+	Change   bool   `json:"change"`
+	Index    uint32 `json:"index"`
+}
+
+// spendRequest is a parsed simulate-spend command line.
+type spendRequest struct {
+	amount           int64
+	to               string
+	fee              feeChoice
+	longTermFeeRate  int64 // sat/kvB
+	minConfirmations int64
+}
+
+// coinCandidate is an unspent output with its effective value.
+type coinCandidate struct {
+	utxo      WalletUTXO
+	effective int64
+}
+
+// selectionContext is what the algorithms and scoring share.
+type selectionContext struct {
+	spec         *WalletSpec
+	size         inputSize
+	feeRate      int64
+	amount       int64
+	recipient    []byte
+	change       []byte
+	inputFee     int64
+	longTermFee  int64
+	baseFee      int64
+	changeFee    int64
+	costOfChange int64
+	all          []WalletUTXO
+}
+
+// simulateSpend selects coins of q's wallet for req's payment with each
+// algorithm.
+func simulateSpend(q *chainQuery, req *spendRequest) (*SpendSimulation, error) {
+	spec := q.spec
+	size, err := walletInputSize(spec)
+	if err != nil {
+		return nil, err
+	}
+	feeRate, estimate, err := req.fee.resolve(q.backend, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient := req.to
+	if recipient != "" && !isAddressForNet(recipient, getNetwork(spec.Network)) {
+		return nil, fmt.Errorf("%s is not a %s address", recipient, spec.Network)
+	}
+	if recipient == "" {
+		if recipient, err = spec.deriveAddress(false, 0); err != nil {
+			return nil, err
+		}
+	}
+	recipientScript, err := addressScript(recipient, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	changeAddress, err := spec.deriveAddress(true, 0)
+	if err != nil {
+		return nil, err
+	}
+	changeScript, err := addressScript(changeAddress, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	if req.amount < dustThreshold(recipientScript) {
+		return nil, fmt.Errorf("amount %d is below the dust threshold of %d sats", req.amount, dustThreshold(recipientScript))
+	}
+
+	utxos, err := listWalletUTXOs(spec, q.backend, q.store, q.r, q.gap, false)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &selectionContext{
+		spec:        spec,
+		size:        size,
+		feeRate:     feeRate,
+		amount:      req.amount,
+		recipient:   recipientScript,
+		change:      changeScript,
+		inputFee:    feeForWeight(feeRate, size.weight()),
+		longTermFee: feeForWeight(req.longTermFeeRate, size.weight()),
+		// The transaction less its inputs: everything txWeight counts for
+		// one input besides the input itself.
+		baseFee:   feeForWeight(feeRate, txWeight([]inputSize{size}, [][]byte{recipientScript})-size.weight()),
+		changeFee: feeForWeight(feeRate, 4*outputSize(changeScript)),
+		all:       utxos.UTXOs,
+	}
+	c.costOfChange = c.changeFee + c.longTermFee
+
+	report := &SpendSimulation{
+		Network:         spec.Network,
+		Backend:         q.backend.Name(),
+		Amount:          req.amount,
+		Recipient:       req.to,
+		FeeRate:         float64(feeRate) / 1000,
+		FeeEstimate:     estimate,
+		LongTermFeeRate: float64(req.longTermFeeRate) / 1000,
+		CostOfChange:    c.costOfChange,
+	}
+	var pool []coinCandidate
+	for _, u := range utxos.UTXOs {
+		if u.Confirmations < req.minConfirmations || u.Value <= c.inputFee {
+			continue
+		}
+		pool = append(pool, coinCandidate{utxo: u, effective: u.Value - c.inputFee})
+		report.Spendable += u.Value - c.inputFee
+	}
+	report.Available = len(pool)
+
+	target := req.amount + c.baseFee
+	results := []struct {
+		name     string
+		selected []coinCandidate
+		failure  string
+	}{
+		{"branch_and_bound", branchAndBound(pool, target, c.costOfChange, c.inputFee-c.longTermFee), "no selection pays the amount and fee without change"},
+		{"knapsack", knapsack(pool, target+c.changeFee, rand.New(rand.NewSource(knapsackSeed))), "no selection pays the amount and fee"},
+	}
+	best := int64(math.MaxInt64)
+	for _, r := range results {
+		selection := c.score(r.name, r.selected)
+		if len(r.selected) == 0 {
+			selection.Error = r.failure
+			if report.Spendable < target {
+				selection.Error = fmt.Sprintf("insufficient funds: %d sats spendable at this fee rate", report.Spendable)
+			}
+		}
+		if selection.Found && selection.Waste < best {
+			best = selection.Waste
+			report.Chosen = selection.Algorithm
+		}
+		report.Results = append(report.Results, selection)
+	}
+	return report, nil
+}
+
+// branchAndBound searches, largest outputs first, for the selection
+// whose effective value lands in [target, target+costOfChange] with the
+// least waste, so no change is needed. It is Core's SelectCoinsBnB.
+func branchAndBound(pool []coinCandidate, target, costOfChange, inputWaste int64) []coinCandidate {
+	sorted := append([]coinCandidate(nil), pool...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].effective > sorted[j].effective })
+	var available int64
+	for _, c := range sorted {
+		available += c.effective
+	}
+	if available < target {
+		return nil
+	}
+
+	var value, waste int64
+	bestWaste := int64(math.MaxInt64)
+	var current, best []int
+	// Above the long-term rate, adding inputs only adds waste, so a branch
+	// already wasting more than the best is cut.
+	feeRateHigh := inputWaste > 0
+	index := 0
+	for try := 0; try < bnbTries; try, index = try+1, index+1 {
+		backtrack := false
+		switch {
+		case value+available < target || value > target+costOfChange || waste > bestWaste && feeRateHigh:
+			backtrack = true
+		case value >= target:
+			if waste+value-target <= bestWaste {
+				best = append(best[:0], current...)
+				bestWaste = waste + value - target
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(current) == 0 {
+				break
+			}
+			// Put the outputs passed over back in the lookahead before
+			// trying the branch without the last one included.
+			last := current[len(current)-1]
+			for index--; index > last; index-- {
+				available += sorted[index].effective
+			}
+			value -= sorted[index].effective
+			waste -= inputWaste
+			current = current[:len(current)-1]
+			continue
+		}
+		available -= sorted[index].effective
+		// Excluding an output equal to one just excluded explores the same
+		// selections again.
+		if len(current) == 0 || index-1 == current[len(current)-1] || sorted[index].effective != sorted[index-1].effective {
+			current = append(current, index)
+			value += sorted[index].effective
+			waste += inputWaste
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	selected := make([]coinCandidate, len(best))
+	for i, j := range best {
+		selected[i] = sorted[j]
+	}
+	return selected
+}
+
+// knapsack is Core's KnapsackSolver: an exact match if there is one, else
+// the smallest random subset of the outputs below target+minChangeTarget
+// that covers it, or the smallest single output above, whichever
+// overshoots less.
+func knapsack(pool []coinCandidate, target int64, rng *rand.Rand) []coinCandidate {
+	shuffled := append([]coinCandidate(nil), pool...)
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	var lower []coinCandidate
+	var lowerTotal int64
+	var larger *coinCandidate
+	for i := range shuffled {
+		c := shuffled[i]
+		switch {
+		case c.effective == target:
+			return []coinCandidate{c}
+		case c.effective < target+minChangeTarget:
+			lower = append(lower, c)
+			lowerTotal += c.effective
+		case larger == nil || c.effective < larger.effective:
+			larger = &shuffled[i]
+		}
+	}
+	if lowerTotal == target {
+		return lower
+	}
+	if lowerTotal < target {
+		if larger == nil {
+			return nil
+		}
+		return []coinCandidate{*larger}
+	}
+
+	sort.SliceStable(lower, func(i, j int) bool { return lower[i].effective > lower[j].effective })
+	included, bestTotal := approximateBestSubset(lower, lowerTotal, target, rng)
+	if bestTotal != target && lowerTotal >= target+minChangeTarget {
+		included, bestTotal = approximateBestSubset(lower, lowerTotal, target+minChangeTarget, rng)
+	}
+	if larger != nil && (bestTotal != target && bestTotal < target+minChangeTarget || larger.effective <= bestTotal) {
+		return []coinCandidate{*larger}
+	}
+	var selected []coinCandidate
+	for i, in := range included {
+		if in {
+			selected = append(selected, lower[i])
+		}
+	}
+	return selected
+}
+
+// approximateBestSubset tries random subsets of the outputs, then adds the
+// rest in order, keeping the smallest total that reaches target.
+func approximateBestSubset(outputs []coinCandidate, total, target int64, rng *rand.Rand) ([]bool, int64) {
+	best := make([]bool, len(outputs))
+	for i := range best {
+		best[i] = true
+	}
+	bestTotal := total
+	included := make([]bool, len(outputs))
+	for rep := 0; rep < knapsackIterations && bestTotal != target; rep++ {
+		clear(included)
+		var sum int64
+		reached := false
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i, c := range outputs {
+				if pass == 0 && rng.Intn(2) == 0 || pass == 1 && included[i] {
+					continue
+				}
+				sum += c.effective
+				included[i] = true
+				if sum >= target {
+					reached = true
+					if sum < bestTotal {
+						bestTotal = sum
+						copy(best, included)
+					}
+					sum -= c.effective
+					included[i] = false
+				}
+			}
+		}
+	}
+	return best, bestTotal
+}
+
+// score prices a selection exactly: its fee with or without change, the
+// change, the waste and what the transaction would reveal.
+func (c *selectionContext) score(algorithm string, selected []coinCandidate) CoinSelection {
+	s := CoinSelection{Algorithm: algorithm, Inputs: []SelectedInput{}, Privacy: []string{}}
+	if len(selected) == 0 {
+		return s
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].utxo.Outpoint < selected[j].utxo.Outpoint })
+	for _, in := range selected {
+		u := in.utxo
+		s.Inputs = append(s.Inputs, SelectedInput{Outpoint: u.Outpoint, Address: u.Address, Value: u.Value, Change: u.Change, Index: u.Index})
+		s.InputTotal += u.Value
+	}
+	sizes := repeatInputSize(c.size, len(selected))
+
+	withChange := txWeight(sizes, [][]byte{c.recipient, c.change})
+	s.Fee = feeForWeight(c.feeRate, withChange)
+	s.Change = s.InputTotal - c.amount - s.Fee
+	weight := withChange
+	// Change is only worth making if it is relayable and worth more than
+	// spending it will cost.
+	if s.Change < max(dustThreshold(c.change), c.longTermFee+1) {
+		weight = txWeight(sizes, [][]byte{c.recipient})
+		s.Fee = feeForWeight(c.feeRate, weight)
+		s.Excess = s.InputTotal - c.amount - s.Fee
+		s.Change = 0
+		if s.Excess < 0 {
+			s.Error = fmt.Sprintf("the selection falls %d sats short of the amount and fee", -s.Excess)
+			return s
+		}
+		s.Fee += s.Excess
+	}
+	s.Found = true
+	s.VSize = vsize(weight)
+	s.Waste = int64(len(selected)) * (c.inputFee - c.longTermFee)
+	if s.Change > 0 {
+		s.Waste += c.costOfChange
+	} else {
+		s.Waste += s.Excess
+	}
+	s.Privacy = c.privacy(s)
+	return s
+}
+
+// privacy lists what a selection's transaction would reveal to chain
+// analysis.
+func (c *selectionContext) privacy(s CoinSelection) []string {
+	notes := []string{}
+	spent := map[string]int{}
+	smallest := int64(math.MaxInt64)
+	for _, in := range s.Inputs {
+		spent[in.Address]++
+		smallest = min(smallest, in.Value)
+	}
+	if len(spent) > 1 {
+		notes = append(notes, fmt.Sprintf("spending from %d addresses links them as one owner's (common-input ownership)", len(spent)))
+	}
+	left := 0
+	for _, u := range c.all {
+		if n, ok := spent[u.Address]; ok {
+			if n > 0 {
+				spent[u.Address] = n - 1
+			} else {
+				left++
+			}
+		}
+	}
+	switch {
+	case left == 1:
+		notes = append(notes, "another output stays on an address spent from, linked to this payment by address reuse")
+	case left > 1:
+		notes = append(notes, fmt.Sprintf("%d other outputs stay on the addresses spent from, linked to this payment by address reuse", left))
+	}
+	if s.Change == 0 {
+		return append(notes, "no change output: nothing in the transaction leads back to the wallet")
+	}
+	if txscript.GetScriptClass(c.recipient) != txscript.GetScriptClass(c.change) {
+		notes = append(notes, fmt.Sprintf("the change output is %s and the payment %s, which marks the change", txscript.GetScriptClass(c.change), txscript.GetScriptClass(c.recipient)))
+	}
+	if c.amount%roundAmount == 0 {
+		notes = append(notes, "the payment is a round amount, so the other output reads as change")
+	}
+	// Had the smaller output been the payment, the smallest input would not
+	// have been needed.
+	if len(s.Inputs) > 1 && s.Change < smallest {
+		notes = append(notes, "the change is smaller than every input, which the unnecessary-input heuristic takes as marking it")
+	}
+	return notes
+}
@@ -0,0 +1,2351 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// command is one CLI subcommand. args excludes the program and command
+// names.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string)
+}
+
+// commands is the CLI command table, in usage order. It is filled in by
+// init because check reports it back as a capability.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"derive", "derive --xpub <xpub>|--key-env <name>|--key-fd <n> --index <n> --type <script_type> [--network <network>] [--change] [--uncompressed] | derive --multisig <xpubs_json> --threshold <m> --index <n> --type <script_type> [--network <network>] [--change]", cmdDerive},
+		{"single", "single <xpub> <index> <script_type> <change> <network> [--uncompressed]", cmdSingle},
+		{"multi", "multi <xpubs_json> <threshold> <index> <script_type> <change> <network>", cmdMulti},
+		{"derive-hardened", "derive-hardened --key-env <name>|--key-fd <n> [count]", cmdDeriveHardened},
+		{"bip85", "bip85 --key-env <name>|--key-fd <n> --app bip39|xprv|wif|hex --index <n> [--words 12|18|24] [--bytes <n>] [--type <script_type>] [--account <n>] [--reveal]", cmdBip85},
+		{"mnemonic", "mnemonic --key-env <name>|--key-fd <n> [--bip39-passphrase-env <name>|--bip39-passphrase-fd <n>] [--type <script_type>] [--path <path>] [--network <network>] [count]", cmdMnemonic},
+		{"seed-xor", "seed-xor --key-env <name>|--key-fd <n> [--bip39-passphrase-env <name>|--bip39-passphrase-fd <n>] [--type <script_type>] [--path <path>] [--network <network>] [count]", cmdSeedXOR},
+		{"find-passphrase", "find-passphrase --key-env <name>|--key-fd <n> --candidates <file> --address <address> --path <path> [--type <script_type>] [--network <network>] [--reveal]", cmdFindPassphrase},
+		{"slip39", "slip39 --key-env <name>|--key-fd <n> [--slip39-passphrase-env <name>|--slip39-passphrase-fd <n>] [--type <script_type>] [--path <path>] [--network <network>] [count]", cmdSLIP39},
+		{"verify-wallet", "verify-wallet <wallet_spec> [count]", cmdVerifyWallet},
+		{"export-bundle", "export-bundle <wallet_spec> [count]", cmdExportBundle},
+		{"report", "report <wallet_spec> [count]", cmdReport},
+		{"diff-report", "diff-report <old_report> <new_report>", cmdDiffReport},
+		{"encrypt-spec", "encrypt-spec <wallet_spec> [--recipient <recipients>]", cmdEncryptSpec},
+		{"verify-attestation", "verify-attestation <wallet_spec> <attestation_file> [signature_file]", cmdVerifyAttestation},
+		{"next-address", "next-address <wallet_spec> [--gap <n>]", cmdNextAddress},
+		{"balance", "balance <wallet_spec> [--range <start-end>] [--gap <n>]", cmdBalance},
+		{"utxos", "utxos <wallet_spec> [--range <start-end>] [--gap <n>]", cmdUTXOs},
+		{"monitor", "monitor <wallet_spec> [--range <start-end>] [--gap <n>] [--interval <seconds>] [--events <file>] [--state <file>] [--confirmations <n>] [--thresholds <n,...>] [--once]", cmdMonitor},
+		{"provision-core", "provision-core <wallet_spec> [wallet_name] [--range <n>]", cmdProvisionCore},
+		{"verify-node", "verify-node <wallet_spec> [count]", cmdVerifyNode},
+		{"backend-check", "backend-check [<backend>...] [--network <name>]", cmdBackendCheck},
+		{"label", "label <wallet_spec> [<address> <label>]", cmdLabel},
+		{"register-wallet", "register-wallet <name> <wallet_spec> [--keychain]", cmdRegisterWallet},
+		{"unregister-wallet", "unregister-wallet <name|wallet_id>", cmdUnregisterWallet},
+		{"list-wallets", "list-wallets", cmdListWallets},
+		{"wallet-address", "wallet-address <wallet_spec> <index> [change]", cmdWalletAddress},
+		{"verify-list", "verify-list <wallet_spec> <list_file|->", cmdVerifyList},
+		{"migrate-map", "migrate-map <old_wallet_spec> <new_wallet_spec> [--range <start-end>] [--gap <n>]", cmdMigrateMap},
+		{"dust-report", "dust-report <wallet_spec> [--feerates <sat/vB,...>] [--range <start-end>] [--gap <n>]", cmdDustReport},
+		{"feerate", "feerate [--target <blocks>] [--mode conservative|economical] [--network <network>]", cmdFeeRate},
+		{"sweep-psbt", "sweep-psbt <old_wallet_spec> <new_wallet_spec> [--feerate <sat/vB> | --fee-target <blocks> [--fee-mode conservative|economical]] [--amount <sats>] [--min-confirmations <n>] [--include-uneconomic] [--out <file>] [--range <start-end>] [--gap <n>]", cmdSweepPSBT},
+		{"simulate-spend", "simulate-spend <wallet_spec> <sats> [--to <address>] [--feerate <sat/vB> | --fee-target <blocks> [--fee-mode conservative|economical]] [--long-term-feerate <sat/vB>] [--min-confirmations <n>] [--range <start-end>] [--gap <n>]", cmdSimulateSpend},
+		{"estimate-size", "estimate-size <script_type> <inputs> <outputs> [--threshold <m> --keys <n>] [--output-type <script_type>] [--uncompressed] [--feerate <sat/vB>]", cmdEstimateSize},
+		{"spend-paths", "spend-paths <wallet_spec> [--feerate <sat/vB> | --fee-target <blocks> [--fee-mode conservative|economical]]", cmdSpendPaths},
+		{"lint-psbt", "lint-psbt <psbt_file|psbt|->", cmdLintPSBT},
+		{"combine-psbt", "combine-psbt <psbt_file|psbt|->... [--wallet <wallet_spec>] [--out <file>]", cmdCombinePSBT},
+		{"decode-tx", "decode-tx <tx_hex|tx_file> [--wallet <wallet_spec>] [--count <n>] [--network <network>] [--prevouts]", cmdDecodeTx},
+		{"scan-block", "scan-block <wallet_spec> <height|hash> [--range <start-end>] [--gap <n>] [--prevouts]", cmdScanBlock},
+		{"decode-address", "decode-address <address> [--network <network>]", cmdDecodeAddress},
+		{"check-lookalikes", "check-lookalikes <wallet_spec> <history_file|-> [count] [--prefix <n>] [--suffix <n>]", cmdCheckLookalikes},
+		{"verify-anchor", "verify-anchor <tx_hex|tx_file> <payload_hex|document> [--hash sha256|sha256d] [--prefix <hex>]", cmdVerifyAnchor},
+		{"frost-addresses", "frost-addresses <group_file> [count]", cmdFrostAddresses},
+		{"lnd", "lnd <accounts_json> [count] [--account <name>] [--address <address>]", cmdLND},
+		{"cln", "cln <xpub|descriptors> [count] [--address <address>]", cmdCLN},
+		{"verify-close", "verify-close <wallet> <delivery_address|script_hex> [count] [--node wallet|lnd|cln]", cmdVerifyClose},
+		{"verify-vectors", "verify-vectors <vectors.json> --pubkey <key|file> [--sig <file>] [--unsigned]", cmdVerifyVectors},
+		{"verify-result", "verify-result <result_file|-> [--pubkey <key|file|fingerprint>]", cmdVerifyResult},
+		{"diff-engines", "diff-engines <request.json>", cmdDiffEngines},
+		{"run", "run --in <requests.json> --out <results.json> | run --verify <results.json> [--in <requests.json>]", cmdRun},
+		{"watch", "watch <dir|fifo|pipe> [--results <dir>] [--archive <dir>] [--interval <seconds>] [--pprof <host:port>]", cmdWatch},
+		{"ui", "ui [<host:port>]", cmdUI},
+		{"tui", "tui", cmdTUI},
+		{"decode-ur", "decode-ur <ur> [<ur>...]", cmdDecodeUR},
+		{"encode-psbt-ur", "encode-psbt-ur <psbt_file|psbt|-> [--fragment-size <bytes>] [--parts <n>] [--type crypto-psbt|psbt] [--qr <dir>]", cmdEncodePSBTUR},
+		{"proto-schema", "proto-schema", cmdProtoSchema},
+		{"completion", "completion bash|zsh|fish", cmdCompletion},
+		{"check", "check [--deep]", cmdCheck},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func (c *command) usageError() {
+	outputError("Usage: " + c.usage)
+}
+
+// commandFlags separates a command's own "--name value" flags from its
+// positional arguments. Only the named flags are accepted.
+func commandFlags(args []string, names ...string) ([]string, map[string]string, error) {
+	var positional []string
+	flags := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			positional = append(positional, args[i])
+			continue
+		}
+		name, value, hasValue := strings.Cut(args[i][2:], "=")
+		known := false
+		for _, n := range names {
+			known = known || n == name
+		}
+		if !known {
+			return nil, nil, fmt.Errorf("unknown flag: --%s", name)
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		flags[name] = value
+	}
+	return positional, flags, nil
+}
+
+// commandSwitches removes a command's own "--name" switches, which take no
+// value, from args and reports which were given.
+func commandSwitches(args []string, names ...string) ([]string, map[string]bool) {
+	var rest []string
+	set := map[string]bool{}
+	for _, arg := range args {
+		name, isFlag := strings.CutPrefix(arg, "--")
+		known := false
+		for _, n := range names {
+			known = known || isFlag && n == name
+		}
+		if known {
+			set[name] = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, set
+}
+
+// gapLimitFlag reads --gap, defaulting to the BIP-44 gap limit.
+func gapLimitFlag(flags map[string]string) (int, error) {
+	value, ok := flags["gap"]
+	if !ok {
+		return defaultGapLimit, nil
+	}
+	gap, err := strconv.Atoi(value)
+	if err != nil || gap < 1 {
+		return 0, errorWithCode(errInvalidCount, "invalid gap limit: %q", value)
+	}
+	return gap, nil
+}
+
+func cmdCheck(args []string) {
+	result := Result{
+		Available:    true,
+		Version:      "0.24.2",
+		Name:         "btcd/btcutil",
+		Capabilities: capabilities(),
+		Build:        buildProvenance(),
+	}
+	if len(args) > 0 && args[0] == "--deep" {
+		result.SelfCheck = runSelfCheck()
+		result.Available = result.SelfCheck.Passed
+	}
+	outputJSON(result)
+}
+
+func cmdProtoSchema(args []string) {
+	if len(args) != 0 {
+		findCommand("proto-schema").usageError()
+		return
+	}
+	outputText(protoSchema())
+}
+
+func cmdCompletion(args []string) {
+	if len(args) != 1 {
+		findCommand("completion").usageError()
+		return
+	}
+	script, err := completionScript(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputText(script)
+}
+
+// deriveRequest is one address derivation, as derive's flags or the
+// positional arguments of single and multi give it. Xpubs holds one key
+// for a single-sig address.
+type deriveRequest struct {
+	xpubs        []string
+	multisig     bool
+	threshold    int
+	index        uint32
+	scriptType   string
+	change       bool
+	network      string
+	uncompressed bool
+}
+
+// cmdDerive derives one address from flags:
+//
+//	derive --xpub <xpub>|--key-env <name>|--key-fd <n> --index <n> --type <script_type> [--network <network>] [--change] [--uncompressed]
+//	derive --multisig <xpubs_json> --threshold <m> --index <n> --type <script_type> [--network <network>] [--change]
+//
+// --network defaults to the network of the (first) key's version bytes.
+// --key-env and --key-fd read the key, which may be private, from outside
+// argv; see secretKey.
+func cmdDerive(args []string) {
+	c := findCommand("derive")
+	args, switches := commandSwitches(args, "change", "uncompressed")
+	args, flags, err := commandFlags(args, "xpub", "key-env", "key-fd", "multisig", "threshold", "index", "type", "network", "change")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	sources := 0
+	for _, name := range []string{"xpub", "key-env", "key-fd"} {
+		if _, ok := flags[name]; ok {
+			sources++
+		}
+	}
+	single := sources == 1
+	_, multisig := flags["multisig"]
+	_, hasIndex := flags["index"]
+	_, hasType := flags["type"]
+	_, hasThreshold := flags["threshold"]
+	if len(args) != 0 || sources > 1 || single == multisig || !hasIndex || !hasType || multisig != hasThreshold || multisig && switches["uncompressed"] {
+		c.usageError()
+		return
+	}
+
+	req := deriveRequest{multisig: multisig, scriptType: flags["type"], uncompressed: switches["uncompressed"]}
+	if multisig {
+		if req.xpubs, err = parseXpubsJSON(flags["multisig"]); err != nil {
+			outputFailure(err)
+			return
+		}
+		if req.threshold, err = parseThreshold(flags["threshold"], len(req.xpubs)); err != nil {
+			outputFailure(err)
+			return
+		}
+	} else {
+		var xpub string
+		if value, ok := flags["xpub"]; ok {
+			xpub, err = xpubFromInput(value)
+		} else {
+			xpub, err = secretKey(flags["key-env"], flags["key-fd"])
+		}
+		if err != nil {
+			outputFailure(err)
+			return
+		}
+		req.xpubs = []string{xpub}
+	}
+	if req.index, err = parseIndex(flags["index"]); err != nil {
+		outputFailure(err)
+		return
+	}
+	req.change = switches["change"]
+	if value, ok := flags["change"]; ok {
+		if req.change, err = parseBool("change", value); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	req.network = flags["network"]
+	if req.network == "" && len(req.xpubs) > 0 {
+		req.network = networkFromXpub(req.xpubs[0])
+	}
+	if err := checkNetwork(req.network); err != nil {
+		outputFailure(err)
+		return
+	}
+	runDerive(&req)
+}
+
+// cmdSingle is derive --xpub with positional arguments, kept so scripts
+// written before derive keep working. Its results carry a deprecation.
+func cmdSingle(args []string) {
+	req, err := singleRequest(args)
+	runDeprecated("single", req, err)
+}
+
+func singleRequest(args []string) (*deriveRequest, error) {
+	uncompressed := len(args) == 6 && args[5] == "--uncompressed"
+	if uncompressed {
+		args = args[:5]
+	}
+	if len(args) != 5 {
+		return nil, fmt.Errorf("Usage: %s", findCommand("single").usage)
+	}
+	req := &deriveRequest{scriptType: args[2], network: args[4], uncompressed: uncompressed}
+	var err error
+	if req.index, err = parseIndex(args[1]); err != nil {
+		return nil, err
+	}
+	if req.change, err = parseBool("change", args[3]); err != nil {
+		return nil, err
+	}
+	if err := checkNetwork(req.network); err != nil {
+		return nil, err
+	}
+	xpub, err := xpubFromInput(args[0])
+	if err != nil {
+		return nil, err
+	}
+	req.xpubs = []string{xpub}
+	return req, nil
+}
+
+// cmdMulti is derive --multisig with positional arguments, kept like
+// single.
+func cmdMulti(args []string) {
+	req, err := multiRequest(args)
+	runDeprecated("multi", req, err)
+}
+
+func multiRequest(args []string) (*deriveRequest, error) {
+	if len(args) != 6 {
+		return nil, fmt.Errorf("Usage: %s", findCommand("multi").usage)
+	}
+	req := &deriveRequest{multisig: true, scriptType: args[3], network: args[5]}
+	var err error
+	if req.xpubs, err = parseXpubsJSON(args[0]); err != nil {
+		return nil, err
+	}
+	if req.threshold, err = parseThreshold(args[1], len(req.xpubs)); err != nil {
+		return nil, err
+	}
+	if req.index, err = parseIndex(args[2]); err != nil {
+		return nil, err
+	}
+	if req.change, err = parseBool("change", args[4]); err != nil {
+		return nil, err
+	}
+	if err := checkNetwork(req.network); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func cmdDeriveHardened(args []string) {
+	c := findCommand("derive-hardened")
+	positional, flags, err := commandFlags(args, "key-env", "key-fd")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	_, env := flags["key-env"]
+	_, fd := flags["key-fd"]
+	if len(positional) > 1 || env == fd {
+		c.usageError()
+		return
+	}
+	count := defaultHardenedCount
+	if len(positional) == 1 {
+		if count, err = parseCount(positional[0]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	if err := requireBtcsuiteEngine("hardened derivations"); err != nil {
+		outputFailure(err)
+		return
+	}
+	desc, err := secretInput(flags["key-env"], flags["key-fd"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer clear(desc)
+	report, err := deriveHardened(string(desc), count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if report.PrivateDerivation {
+		diagnostic("warning: %s", report.Warning)
+	}
+	outputJSON(report)
+}
+
+func cmdBip85(args []string) {
+	c := findCommand("bip85")
+	args, switches := commandSwitches(args, "reveal")
+	positional, flags, err := commandFlags(args, "key-env", "key-fd", "app", "index", "words", "bytes", "type", "account")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	_, env := flags["key-env"]
+	_, fd := flags["key-fd"]
+	_, hasApp := flags["app"]
+	_, hasIndex := flags["index"]
+	if len(positional) != 0 || env == fd || !hasApp || !hasIndex {
+		c.usageError()
+		return
+	}
+	if err := requireOffline("bip85"); err != nil {
+		outputFailure(err)
+		return
+	}
+	req, err := parseBip85Request(flags, switches["reveal"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	master, err := secretMasterKey(flags["key-env"], flags["key-fd"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer master.Zero()
+	report, err := deriveBip85(master, req)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdMnemonic(args []string) {
+	runRestore("mnemonic", "bip39-passphrase", args, func(secret, passphrase string, req *mnemonicRequest) (interface{}, error) {
+		return restoreMnemonic(secret, passphrase, req)
+	})
+}
+
+func cmdSeedXOR(args []string) {
+	runRestore("seed-xor", "bip39-passphrase", args, func(secret, passphrase string, req *mnemonicRequest) (interface{}, error) {
+		return restoreSeedXOR(secret, passphrase, req)
+	})
+}
+
+func cmdFindPassphrase(args []string) {
+	c := findCommand("find-passphrase")
+	args, switches := commandSwitches(args, "reveal")
+	positional, flags, err := commandFlags(args, "key-env", "key-fd", "candidates", "address", "path", "type", "network")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	_, env := flags["key-env"]
+	_, fd := flags["key-fd"]
+	if len(positional) > 0 || env == fd || flags["candidates"] == "" || flags["address"] == "" || flags["path"] == "" {
+		c.usageError()
+		return
+	}
+	if err := requireOffline("find-passphrase"); err != nil {
+		outputFailure(err)
+		return
+	}
+	search := &passphraseSearch{address: flags["address"], network: "mainnet", reveal: switches["reveal"]}
+	if value, ok := flags["network"]; ok {
+		search.network = value
+	}
+	if err := checkNetwork(search.network); err != nil {
+		outputFailure(err)
+		return
+	}
+	if search.path, err = parseKeyPath(flags["path"]); err != nil {
+		outputFailure(err)
+		return
+	}
+	if search.scriptType, err = searchScriptType(flags["type"], search.path); err != nil {
+		outputFailure(err)
+		return
+	}
+	if !isAddressForNet(search.address, getNetwork(search.network)) {
+		outputFailure(fmt.Errorf("%s is not a %s address", search.address, search.network))
+		return
+	}
+	data, err := os.ReadFile(flags["candidates"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	candidates, err := parseCandidates(string(data))
+	clear(data)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+
+	secret, err := secretInput(flags["key-env"], flags["key-fd"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer clear(secret)
+	report, err := findPassphrase(string(secret), candidates, search)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdSLIP39(args []string) {
+	runRestore("slip39", "slip39-passphrase", args, func(secret, passphrase string, req *mnemonicRequest) (interface{}, error) {
+		return restoreSLIP39(secret, passphrase, req)
+	})
+}
+
+// runRestore runs a command restoring a wallet from a seed backup, read
+// through --key-env or --key-fd with its passphrase from the
+// <passphrase>-env or <passphrase>-fd flag.
+func runRestore(name, passphraseFlag string, args []string, restore func(secret, passphrase string, req *mnemonicRequest) (interface{}, error)) {
+	c := findCommand(name)
+	passEnvFlag, passFdFlag := passphraseFlag+"-env", passphraseFlag+"-fd"
+	positional, flags, err := commandFlags(args, "key-env", "key-fd", passEnvFlag, passFdFlag, "type", "path", "network")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	_, env := flags["key-env"]
+	_, fd := flags["key-fd"]
+	_, passEnv := flags[passEnvFlag]
+	_, passFd := flags[passFdFlag]
+	if len(positional) > 1 || env == fd || passEnv && passFd {
+		c.usageError()
+		return
+	}
+	if err := requireOffline(name); err != nil {
+		outputFailure(err)
+		return
+	}
+	req := &mnemonicRequest{scriptType: "native_segwit", network: "mainnet", count: defaultMnemonicCount}
+	if len(positional) == 1 {
+		if req.count, err = parseCount(positional[0]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	if value, ok := flags["type"]; ok {
+		req.scriptType = value
+	}
+	if value, ok := flags["network"]; ok {
+		req.network = value
+	}
+	if err := checkNetwork(req.network); err != nil {
+		outputFailure(err)
+		return
+	}
+	if t, err := lookupScriptType(req.scriptType); err != nil || t.multisig {
+		outputFailure(fmt.Errorf("%s derives single-sig wallets; unsupported script type: %s", name, req.scriptType))
+		return
+	}
+	if value, ok := flags["path"]; ok {
+		req.path, err = parseKeyPath(value)
+	} else {
+		req.path, err = accountPath(req.scriptType, req.network, 0)
+	}
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+
+	secret, err := secretInput(flags["key-env"], flags["key-fd"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer clear(secret)
+	var passphrase []byte
+	if passEnv || passFd {
+		if passphrase, err = readSecret(flags[passEnvFlag], flags[passFdFlag]); err != nil {
+			outputFailure(err)
+			return
+		}
+		defer clear(passphrase)
+	}
+	report, err := restore(string(secret), strings.TrimRight(string(passphrase), "\r\n"), req)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+// parseXpubsJSON reads a JSON array of cosigner keys, decoding any given as
+// URs.
+func parseXpubsJSON(arg string) ([]string, error) {
+	var xpubs []string
+	if err := json.Unmarshal([]byte(arg), &xpubs); err != nil {
+		return nil, fmt.Errorf("Failed to parse xpubs: %v", err)
+	}
+	for i, input := range xpubs {
+		// A path suffix stays attached for deriveMultisig to apply.
+		if !strings.HasPrefix(strings.ToLower(input), "ur:") {
+			continue
+		}
+		xpub, err := xpubFromInput(input)
+		if err != nil {
+			return nil, err
+		}
+		xpubs[i] = xpub
+	}
+	return xpubs, nil
+}
+
+func (req *deriveRequest) derive() (string, error) {
+	for _, xpub := range req.xpubs {
+		if err := checkKeyNetwork(xpub, req.network); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case req.multisig:
+		return deriveMultisig(req.xpubs, req.threshold, req.index, req.scriptType, req.change, req.network)
+	case req.uncompressed:
+		return deriveUncompressed(req.xpubs[0], req.index, req.scriptType, req.change, req.network)
+	}
+	return deriveSingleSig(req.xpubs[0], req.index, req.scriptType, req.change, req.network)
+}
+
+// deriveArgs is the derive invocation equivalent to req.
+func (req *deriveRequest) deriveArgs() []string {
+	var args []string
+	if req.multisig {
+		xpubs, _ := json.Marshal(req.xpubs)
+		args = []string{"--multisig", string(xpubs), "--threshold", strconv.Itoa(req.threshold)}
+	} else {
+		args = []string{"--xpub", req.xpubs[0]}
+	}
+	args = append(args, "--index", strconv.FormatUint(uint64(req.index), 10), "--type", req.scriptType, "--network", req.network)
+	if req.change {
+		args = append(args, "--change")
+	}
+	if req.uncompressed {
+		args = append(args, "--uncompressed")
+	}
+	return append([]string{"derive"}, args...)
+}
+
+func runDerive(req *deriveRequest) {
+	address, err := req.derive()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(Result{Address: address, Backend: curveBackendName()})
+}
+
+// runDeprecated derives the request of a deprecated positional command,
+// successful or not, and marks the result with its replacement. A request
+// that parsed has its exact derive invocation in the deprecation.
+func runDeprecated(name string, req *deriveRequest, err error) {
+	result := Result{Deprecation: &Deprecation{Command: name, Replacement: "derive"}}
+	if err == nil {
+		result.Deprecation.Args = req.deriveArgs()
+		result.Address, err = req.derive()
+	}
+	diagnostic("warning: %s is deprecated, use derive instead (see the result's deprecation field)", name)
+	if err != nil {
+		tracer.current.fail(err.Error())
+		result.Address, result.Error, result.Code = "", err.Error(), errorCode(err)
+	} else {
+		result.Backend = curveBackendName()
+	}
+	outputJSON(result)
+}
+
+func cmdVerifyWallet(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		findCommand("verify-wallet").usageError()
+		return
+	}
+	count := 10
+	if len(args) == 2 {
+		var err error
+		if count, err = parseCount(args[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	store, err := openConfiguredStore()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if store != nil {
+		defer store.Close()
+		// A streamed report does not keep the addresses to record.
+		if streaming() {
+			outputError("--store cannot record addresses streamed with --format ndjson")
+			return
+		}
+		if err := restoreLabels(store, spec); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	report, err := verifyWallet(spec, count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if store != nil {
+		if err := recordWalletReport(store, spec, report); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	outputJSON(report)
+}
+
+// restoreLabels merges labels saved in the store into the spec. Labels
+// carried by the spec itself win.
+func restoreLabels(store *walletStore, spec *WalletSpec) error {
+	id, err := store.registerWallet(spec)
+	if err != nil {
+		return err
+	}
+	labels, err := store.labels(id)
+	if err != nil {
+		return err
+	}
+	if spec.Labels == nil {
+		spec.Labels = map[string]string{}
+	}
+	for address, label := range labels {
+		if _, ok := spec.Labels[address]; !ok {
+			spec.Labels[address] = label
+		}
+	}
+	return nil
+}
+
+func recordWalletReport(store *walletStore, spec *WalletSpec, report *WalletReport) error {
+	id, err := walletID(spec)
+	if err != nil {
+		return err
+	}
+	if err := store.recordAddresses(id, false, report.Receive); err != nil {
+		return err
+	}
+	return store.recordAddresses(id, true, report.Change)
+}
+
+func cmdNextAddress(args []string) {
+	args, flags, err := commandFlags(args, "gap")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) != 1 {
+		findCommand("next-address").usageError()
+		return
+	}
+	gap, err := gapLimitFlag(flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	backend, err := openConfiguredBackend(spec.Network)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer backend.Close()
+	store, err := openConfiguredStore()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if store != nil {
+		defer store.Close()
+	}
+
+	next, err := nextUnusedAddress(spec, backend, store, gap)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(next)
+}
+
+// chainQuery is the wallet, backend and index selection shared by the
+// commands that read chain data for a range of addresses.
+type chainQuery struct {
+	spec    *WalletSpec
+	backend ChainBackend
+	store   *walletStore
+	r       *indexRange
+	gap     int
+}
+
+// parseChainQuery handles "<wallet_spec> [--range <start-end>] [--gap <n>]"
+// and opens the configured backend and store. The caller closes them with
+// q.Close.
+func parseChainQuery(c *command, args []string) (*chainQuery, error) {
+	q, _, err := parseChainQueryFlags(c, args)
+	return q, err
+}
+
+// parseChainQueryFlags is parseChainQuery for commands taking more flags,
+// which are returned.
+func parseChainQueryFlags(c *command, args []string, names ...string) (*chainQuery, map[string]string, error) {
+	args, flags, err := commandFlags(args, append([]string{"range", "gap"}, names...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) != 1 {
+		return nil, nil, fmt.Errorf("Usage: %s", c.usage)
+	}
+	q, err := openChainQuery(args[0], flags)
+	return q, flags, err
+}
+
+// openChainQuery loads the wallet spec at path, applies the --range and
+// --gap flags, and opens the configured backend and store.
+func openChainQuery(path string, flags map[string]string) (*chainQuery, error) {
+	var err error
+	q := &chainQuery{}
+	if q.gap, err = gapLimitFlag(flags); err != nil {
+		return nil, err
+	}
+	if value, ok := flags["range"]; ok {
+		r, err := parseIndexRange(value)
+		if err != nil {
+			return nil, err
+		}
+		q.r = &r
+	}
+	if q.spec, err = loadWalletSpec(path); err != nil {
+		return nil, err
+	}
+	if q.backend, err = openConfiguredBackend(q.spec.Network); err != nil {
+		return nil, err
+	}
+	if q.store, err = openConfiguredStore(); err != nil {
+		q.backend.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *chainQuery) Close() {
+	q.backend.Close()
+	if q.store != nil {
+		q.store.Close()
+	}
+}
+
+func cmdBalance(args []string) {
+	q, err := parseChainQuery(findCommand("balance"), args)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer q.Close()
+
+	report, err := walletBalance(q.spec, q.backend, q.store, q.r, q.gap, options.verbose)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdUTXOs(args []string) {
+	q, err := parseChainQuery(findCommand("utxos"), args)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer q.Close()
+
+	report, err := walletUTXOs(q.spec, q.backend, q.store, q.r, q.gap)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdMonitor(args []string) {
+	args, switches := commandSwitches(args, "once")
+	q, flags, err := parseChainQueryFlags(findCommand("monitor"), args, "interval", "events", "state", "confirmations", "thresholds")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer q.Close()
+	if _, ok := flags["events"]; !ok && options.porcelain {
+		outputError("monitor writes events to stdout and needs --events <file> with --porcelain")
+		return
+	}
+	m, err := newAddressMonitor(q, flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer m.Close()
+	if err := m.run(switches["once"]); err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(m.report)
+}
+
+func cmdProvisionCore(args []string) {
+	args, flags, err := commandFlags(args, "range")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) != 1 && len(args) != 2 {
+		findCommand("provision-core").usageError()
+		return
+	}
+	count := defaultProvisionRange
+	if value, ok := flags["range"]; ok {
+		if count, err = parseCount(value); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	cfg, err := loadBackendConfig(options.configPath)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := provisionCoreWallet(spec, cfg, name, count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdVerifyNode(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		findCommand("verify-node").usageError()
+		return
+	}
+	count := defaultNodeRange
+	if len(args) == 2 {
+		var err error
+		if count, err = parseCount(args[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	cfg, err := loadBackendConfig(options.configPath)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := verifyAgainstNode(spec, cfg, count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdBackendCheck(args []string) {
+	names, flags, err := commandFlags(args, "network")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	network := "mainnet"
+	if v, ok := flags["network"]; ok {
+		if err := checkNetwork(v); err != nil {
+			outputFailure(err)
+			return
+		}
+		network = v
+	}
+	cfg, err := loadBackendConfig(options.configPath)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(names) == 0 {
+		names = cfg.configuredBackends()
+	}
+	outputJSON(checkBackends(cfg, names, network))
+}
+
+// LabelReport is the output of the label command: every label the store
+// holds for the wallet, by address.
+type LabelReport struct {
+	WalletID string            `json:"wallet_id"`
+	Labels   map[string]string `json:"labels"`
+}
+
+func cmdLabel(args []string) {
+	if len(args) != 1 && len(args) != 3 {
+		findCommand("label").usageError()
+		return
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if options.storePath == "" {
+		outputError("label needs a wallet state store (--store <file>)")
+		return
+	}
+	store, err := openConfiguredStore()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer store.Close()
+	id, err := store.registerWallet(spec)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) == 3 {
+		if err := store.setLabel(id, args[1], args[2]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	labels, err := store.labels(id)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(LabelReport{WalletID: id, Labels: labels})
+}
+
+// cmdEncryptSpec encrypts a wallet spec or bundle, as given, to the
+// comma-separated age recipients or, without them, to the run's passphrase.
+func cmdEncryptSpec(args []string) {
+	args, flags, err := commandFlags(args, "recipient")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) != 1 {
+		findCommand("encrypt-spec").usageError()
+		return
+	}
+	var data []byte
+	if ref, ok := strings.CutPrefix(args[0], walletRefPrefix); ok {
+		spec, err := loadRegisteredWallet(ref)
+		if err != nil {
+			outputFailure(err)
+			return
+		}
+		data, _ = json.Marshal(spec)
+	} else if data, err = readWalletSpecData(args[0]); err != nil {
+		outputFailure(err)
+		return
+	}
+	spec, err := parseWalletSpec(data)
+	if err == nil {
+		err = spec.validate()
+	}
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+
+	var recipients []string
+	var passphrase []byte
+	if list := flags["recipient"]; list != "" {
+		recipients = strings.Split(list, ",")
+	} else if passphrase, err = agePassphrase(); err != nil {
+		outputError("encrypt-spec needs --recipient, or a passphrase from --passphrase-env or --passphrase-fd")
+		return
+	}
+	out, err := encryptAge(data, recipients, passphrase)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputText(string(out))
+}
+
+func cmdRegisterWallet(args []string) {
+	args, switches := commandSwitches(args, "keychain")
+	if len(args) != 2 {
+		findCommand("register-wallet").usageError()
+		return
+	}
+	if err := checkRegistryName("wallet", args[0]); err != nil {
+		outputFailure(err)
+		return
+	}
+	if strings.HasPrefix(args[1], walletRefPrefix) {
+		outputError("register-wallet needs the wallet spec itself, not a reference")
+		return
+	}
+	spec, err := loadWalletSpec(args[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	store, err := openRegistry()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer store.Close()
+	w, err := store.registerTenantWallet(tenant(), args[0], spec, switches["keychain"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(RegistryReport{Tenant: tenant(), Wallets: []RegisteredWallet{*w}})
+}
+
+func cmdUnregisterWallet(args []string) {
+	if len(args) != 1 {
+		findCommand("unregister-wallet").usageError()
+		return
+	}
+	store, err := openRegistry()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer store.Close()
+	if err := store.unregisterWallet(tenant(), args[0]); err != nil {
+		outputFailure(err)
+		return
+	}
+	wallets, err := store.tenantWallets(tenant())
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(RegistryReport{Tenant: tenant(), Wallets: wallets})
+}
+
+func cmdListWallets(args []string) {
+	if len(args) != 0 {
+		findCommand("list-wallets").usageError()
+		return
+	}
+	store, err := openRegistry()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer store.Close()
+	wallets, err := store.tenantWallets(tenant())
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(RegistryReport{Tenant: tenant(), Wallets: wallets})
+}
+
+// WalletAddress is the output of wallet-address.
+type WalletAddress struct {
+	Network    string `json:"network"`
+	ScriptType string `json:"script_type"`
+	Change     bool   `json:"change"`
+	Index      uint32 `json:"index"`
+	Address    string `json:"address"`
+	Backend    string `json:"backend,omitempty"`
+}
+
+func cmdWalletAddress(args []string) {
+	if len(args) != 2 && len(args) != 3 {
+		findCommand("wallet-address").usageError()
+		return
+	}
+	index, err := parseIndex(args[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	change := false
+	if len(args) == 3 {
+		if change, err = parseBool("change", args[2]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	address, err := spec.deriveAddress(change, index)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(WalletAddress{
+		Network:    spec.Network,
+		ScriptType: spec.ScriptType,
+		Change:     change,
+		Index:      index,
+		Address:    address,
+		Backend:    curveBackendName(),
+	})
+}
+
+func cmdReport(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		findCommand("report").usageError()
+		return
+	}
+	if streaming() {
+		outputError("report is a single document and cannot be streamed with --format " + options.format)
+		return
+	}
+	count := 20
+	if len(args) == 2 {
+		var err error
+		if count, err = parseCount(args[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := walletAuditReport(spec, count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdDiffReport(args []string) {
+	if len(args) != 2 {
+		findCommand("diff-report").usageError()
+		return
+	}
+	if args[0] == "-" && args[1] == "-" {
+		outputError("both reports cannot be read from stdin")
+		return
+	}
+	old, err := loadAuditReport(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	new, err := loadAuditReport(args[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(diffReports(old, new))
+}
+
+func cmdExportBundle(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		findCommand("export-bundle").usageError()
+		return
+	}
+	count := 20
+	if len(args) == 2 {
+		var err error
+		if count, err = parseCount(args[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	bundle, err := exportWalletBundle(spec, count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(bundle)
+}
+
+func cmdVerifyVectors(args []string) {
+	args, switches := commandSwitches(args, "unsigned")
+	args, flags, err := commandFlags(args, "pubkey", "sig")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) != 1 || (flags["pubkey"] == "") == !switches["unsigned"] {
+		findCommand("verify-vectors").usageError()
+		return
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		outputError(fmt.Sprintf("failed to read vectors: %v", err))
+		return
+	}
+	var signature *MinisignInfo
+	modules := []SignedModule{}
+	if !switches["unsigned"] {
+		pk, err := parseMinisignPublicKey(flags["pubkey"])
+		if err != nil {
+			outputFailure(err)
+			return
+		}
+		sigPath := flags["sig"]
+		if sigPath == "" {
+			sigPath = args[0] + ".minisig"
+		}
+		sig, err := os.ReadFile(sigPath)
+		if os.IsNotExist(err) {
+			outputFailure(errorWithCode(errInvalidSignature, "%s is not signed (no %s); sign it with minisign -Sm, or pass --unsigned", args[0], sigPath))
+			return
+		}
+		if err != nil {
+			outputError(fmt.Sprintf("failed to read signature: %v", err))
+			return
+		}
+		if signature, err = verifyMinisign(pk, data, sig); err != nil {
+			outputFailure(err)
+			return
+		}
+		if modules, err = verifyFixtureModules(pk, args[0]); err != nil {
+			outputFailure(err)
+			return
+		}
+	} else {
+		diagnostic("warning: running %s without checking its signature", args[0])
+	}
+	report, err := verifyVectors(args[0], data)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report.Signature = signature
+	report.Modules = modules
+	outputJSON(report)
+}
+
+func cmdVerifyResult(args []string) {
+	args, flags, err := commandFlags(args, "pubkey")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) != 1 {
+		findCommand("verify-result").usageError()
+		return
+	}
+	report, err := verifyResult(args[0], flags["pubkey"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdVerifyAttestation(args []string) {
+	if len(args) != 2 && len(args) != 3 {
+		findCommand("verify-attestation").usageError()
+		return
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	sigPath := ""
+	if len(args) == 3 {
+		sigPath = args[2]
+	}
+	report, err := verifyAttestation(spec, args[1], sigPath)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdVerifyList(args []string) {
+	if len(args) != 2 {
+		findCommand("verify-list").usageError()
+		return
+	}
+	if args[0] == "-" && args[1] == "-" {
+		outputError("wallet spec and address list cannot both be read from stdin")
+		return
+	}
+	spec, err := loadWalletSpec(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := verifyAddressList(spec, args[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdCheckLookalikes(args []string) {
+	c := findCommand("check-lookalikes")
+	positional, flags, err := commandFlags(args, "prefix", "suffix")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 && len(positional) != 3 {
+		c.usageError()
+		return
+	}
+	if positional[0] == "-" && positional[1] == "-" {
+		outputError("wallet spec and address history cannot both be read from stdin")
+		return
+	}
+	count := defaultLookalikeCount
+	if len(positional) == 3 {
+		if count, err = parseCount(positional[2]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	prefix, suffix := defaultLookalikePrefix, defaultLookalikeSuffix
+	for name, n := range map[string]*int{"prefix": &prefix, "suffix": &suffix} {
+		if value, ok := flags[name]; ok {
+			if *n, err = strconv.Atoi(value); err != nil || *n < 0 {
+				outputFailure(fmt.Errorf("invalid --%s: %q", name, value))
+				return
+			}
+		}
+	}
+	spec, err := loadWalletSpec(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	text, err := readLookalikeInput(positional[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := checkLookalikes(spec, text, count, prefix, suffix)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdMigrateMap(args []string) {
+	positional, flags, err := commandFlags(args, "range", "gap")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 {
+		findCommand("migrate-map").usageError()
+		return
+	}
+	if positional[0] == "-" && positional[1] == "-" {
+		outputError("the two wallet specs cannot both be read from stdin")
+		return
+	}
+	gap, err := gapLimitFlag(flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	var r *indexRange
+	if value, ok := flags["range"]; ok {
+		parsed, err := parseIndexRange(value)
+		if err != nil {
+			outputFailure(err)
+			return
+		}
+		r = &parsed
+	}
+	from, err := loadWalletSpec(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	to, err := loadWalletSpec(positional[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	ranges, err := migrationRanges(from, r, gap)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := migrationMap(from, to, ranges)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdFeeRate(args []string) {
+	positional, flags, err := commandFlags(args, "target", "mode", "network")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 0 {
+		findCommand("feerate").usageError()
+		return
+	}
+	target, mode, err := feeEstimateFlags(flags, "target", "mode")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	network := "mainnet"
+	if v, ok := flags["network"]; ok {
+		if err := checkNetwork(v); err != nil {
+			outputFailure(err)
+			return
+		}
+		network = v
+	}
+	backend, err := openConfiguredBackend(network)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer backend.Close()
+
+	estimate, _, err := estimateFeeRate(backend, network, target, mode)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(estimate)
+}
+
+func cmdDustReport(args []string) {
+	q, flags, err := parseChainQueryFlags(findCommand("dust-report"), args, "feerates")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer q.Close()
+	list := defaultDustFeeRates
+	if v, ok := flags["feerates"]; ok {
+		list = v
+	}
+	rates, err := parseFeeRates(list)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+
+	report, err := dustReport(q, rates)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdSweepPSBT(args []string) {
+	args, switches := commandSwitches(args, "include-uneconomic")
+	positional, flags, err := commandFlags(args, "feerate", "fee-target", "fee-mode", "amount", "min-confirmations", "out", "range", "gap")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 {
+		findCommand("sweep-psbt").usageError()
+		return
+	}
+	if positional[0] == "-" && positional[1] == "-" {
+		outputError("the two wallet specs cannot both be read from stdin")
+		return
+	}
+	req := &sweepRequest{minConfirmations: 1, includeUneconomic: switches["include-uneconomic"]}
+	if req.fee, err = parseFeeFlags(flags); err != nil {
+		outputFailure(err)
+		return
+	}
+	if v, ok := flags["amount"]; ok {
+		if req.amount, err = parseAmount(v); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	if v, ok := flags["min-confirmations"]; ok {
+		if req.minConfirmations, err = strconv.ParseInt(v, 10, 64); err != nil || req.minConfirmations < 0 {
+			outputFailure(errorWithCode(errInvalidCount, "invalid confirmation depth: %q", v))
+			return
+		}
+	}
+	if req.gap, err = gapLimitFlag(flags); err != nil {
+		outputFailure(err)
+		return
+	}
+	if v, ok := flags["range"]; ok {
+		r, err := parseIndexRange(v)
+		if err != nil {
+			outputFailure(err)
+			return
+		}
+		req.r = &r
+	}
+	from, err := loadWalletSpec(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	to, err := loadWalletSpec(positional[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	backend, err := openConfiguredBackend(from.Network)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer backend.Close()
+	store, err := openConfiguredStore()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if store != nil {
+		defer store.Close()
+	}
+
+	report, raw, err := buildSweep(from, to, backend, store, req)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if out := flags["out"]; out != "" {
+		if err := os.WriteFile(out, raw, 0o644); err != nil {
+			outputError(fmt.Sprintf("failed to write PSBT: %v", err))
+			return
+		}
+		report.File = out
+	}
+	outputJSON(report)
+}
+
+func cmdSimulateSpend(args []string) {
+	positional, flags, err := commandFlags(args, "to", "feerate", "fee-target", "fee-mode", "long-term-feerate", "min-confirmations", "range", "gap")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 {
+		findCommand("simulate-spend").usageError()
+		return
+	}
+	req := &spendRequest{to: flags["to"], longTermFeeRate: defaultLongTermFeeRate, minConfirmations: 1}
+	if req.amount, err = parseAmount(positional[1]); err != nil {
+		outputFailure(err)
+		return
+	}
+	if req.fee, err = parseFeeFlags(flags); err != nil {
+		outputFailure(err)
+		return
+	}
+	if v, ok := flags["long-term-feerate"]; ok {
+		if req.longTermFeeRate, err = parseFeeRate(v); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	if v, ok := flags["min-confirmations"]; ok {
+		if req.minConfirmations, err = strconv.ParseInt(v, 10, 64); err != nil || req.minConfirmations < 0 {
+			outputFailure(errorWithCode(errInvalidCount, "invalid confirmation depth: %q", v))
+			return
+		}
+	}
+	q, err := openChainQuery(positional[0], flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer q.Close()
+
+	report, err := simulateSpend(q, req)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdEstimateSize(args []string) {
+	args, switches := commandSwitches(args, "uncompressed")
+	positional, flags, err := commandFlags(args, "threshold", "keys", "output-type", "feerate")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 3 {
+		findCommand("estimate-size").usageError()
+		return
+	}
+	req := &sizeRequest{inputType: positional[0], outputType: positional[0], uncompressed: switches["uncompressed"]}
+	if req.inputs, err = parseCount(positional[1]); err != nil {
+		outputFailure(err)
+		return
+	}
+	if req.outputs, err = parseCount(positional[2]); err != nil {
+		outputFailure(err)
+		return
+	}
+	if v, ok := flags["output-type"]; ok {
+		req.outputType = v
+	}
+	_, hasThreshold := flags["threshold"]
+	_, hasKeys := flags["keys"]
+	if hasThreshold != hasKeys {
+		outputError("--threshold and --keys must be given together")
+		return
+	}
+	if hasKeys {
+		if req.keys, err = strconv.Atoi(flags["keys"]); err != nil {
+			outputFailure(errorWithCode(errInvalidCount, "invalid key count: %q", flags["keys"]))
+			return
+		}
+		if req.threshold, err = strconv.Atoi(flags["threshold"]); err != nil {
+			outputFailure(errorWithCode(errInvalidThreshold, "invalid threshold: %q", flags["threshold"]))
+			return
+		}
+	}
+	if v, ok := flags["feerate"]; ok {
+		if req.feeRate, err = parseFeeRate(v); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+
+	estimate, err := estimateSize(req)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(estimate)
+}
+
+func cmdSpendPaths(args []string) {
+	positional, flags, err := commandFlags(args, "feerate", "fee-target", "fee-mode")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 {
+		findCommand("spend-paths").usageError()
+		return
+	}
+	fee, err := parseFeeFlags(flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	spec, err := loadWalletSpec(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	// A fee rate on the command line needs no backend.
+	var backend ChainBackend
+	if fee.rate == 0 {
+		if backend, err = openConfiguredBackend(spec.Network); err != nil {
+			outputFailure(err)
+			return
+		}
+		defer backend.Close()
+	}
+	rate, estimate, err := fee.resolve(backend, spec.Network)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+
+	report, err := spendPaths(spec, rate)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report.FeeEstimate = estimate
+	outputJSON(report)
+}
+
+func cmdLintPSBT(args []string) {
+	if len(args) != 1 {
+		findCommand("lint-psbt").usageError()
+		return
+	}
+	p, err := readPSBT(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(lintPSBT(p))
+}
+
+func cmdCombinePSBT(args []string) {
+	positional, flags, err := commandFlags(args, "wallet", "out")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) == 0 {
+		findCommand("combine-psbt").usageError()
+		return
+	}
+	stdin := 0
+	for _, arg := range append(positional, flags["wallet"]) {
+		if arg == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		outputError("only one PSBT or the wallet spec can be read from stdin")
+		return
+	}
+	var spec *WalletSpec
+	if v, ok := flags["wallet"]; ok {
+		if spec, err = loadWalletSpec(v); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	copies := make([]*psbtPacket, len(positional))
+	for i, arg := range positional {
+		if copies[i], err = readPSBT(arg); err != nil {
+			outputFailure(fmt.Errorf("psbt %d: %v", i, err))
+			return
+		}
+	}
+	report, merged, err := combinePSBTs(copies, spec)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	raw, err := merged.serialize()
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report.PSBT = base64.StdEncoding.EncodeToString(raw)
+	if out := flags["out"]; out != "" {
+		if err := os.WriteFile(out, raw, 0o644); err != nil {
+			outputError(fmt.Sprintf("failed to write PSBT: %v", err))
+			return
+		}
+		report.File = out
+	}
+	outputJSON(report)
+}
+
+func cmdDecodeTx(args []string) {
+	args, switches := commandSwitches(args, "prevouts")
+	positional, flags, err := commandFlags(args, "wallet", "count", "network")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 {
+		findCommand("decode-tx").usageError()
+		return
+	}
+	tx, err := parseRawTx(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	req := &txDecodeRequest{network: "mainnet", count: defaultTxScanCount}
+	if v, ok := flags["count"]; ok {
+		if req.count, err = parseCount(v); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	if v, ok := flags["wallet"]; ok {
+		if req.spec, err = loadWalletSpec(v); err != nil {
+			outputFailure(err)
+			return
+		}
+		req.network = req.spec.Network
+	}
+	if v, ok := flags["network"]; ok {
+		if err := checkNetwork(v); err != nil {
+			outputFailure(err)
+			return
+		}
+		if req.spec != nil && v != req.spec.Network {
+			outputError(fmt.Sprintf("the wallet is on %s, not %s", req.spec.Network, v))
+			return
+		}
+		req.network = v
+	}
+	if req.spec != nil {
+		store, err := openConfiguredStore()
+		if err != nil {
+			outputFailure(err)
+			return
+		}
+		if store != nil {
+			defer store.Close()
+			if err := restoreLabels(store, req.spec); err != nil {
+				outputFailure(err)
+				return
+			}
+		}
+	}
+	if switches["prevouts"] {
+		if req.backend, err = openConfiguredBackend(req.network); err != nil {
+			outputFailure(err)
+			return
+		}
+		defer req.backend.Close()
+	}
+	report, err := decodeTx(tx, req)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdScanBlock(args []string) {
+	args, switches := commandSwitches(args, "prevouts")
+	positional, flags, err := commandFlags(args, "range", "gap")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 {
+		findCommand("scan-block").usageError()
+		return
+	}
+	q, err := openChainQuery(positional[0], flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	defer q.Close()
+	if q.store != nil {
+		if err := restoreLabels(q.store, q.spec); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	hash, height, err := resolveBlock(q.backend, positional[1])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	block, err := fetchBlock(q.backend, hash)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	ranges, err := blockScanRanges(q)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := scanBlock(q, block, ranges, switches["prevouts"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if height != nil {
+		// The coinbase checks the backend's hash for the height.
+		if report.Height != nil && *report.Height != *height {
+			outputError(fmt.Sprintf("%s returned block %s for height %d, which is at height %d", q.backend.Name(), hash, *height, *report.Height))
+			return
+		}
+		report.Height = height
+	}
+	outputJSON(report)
+}
+
+func cmdDecodeAddress(args []string) {
+	positional, flags, err := commandFlags(args, "network")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 {
+		findCommand("decode-address").usageError()
+		return
+	}
+	network, ok := flags["network"]
+	if ok {
+		if err := checkNetwork(network); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	outputJSON(decodeAddress(strings.TrimSpace(positional[0]), network))
+}
+
+func cmdVerifyAnchor(args []string) {
+	c := findCommand("verify-anchor")
+	positional, flags, err := commandFlags(args, "hash", "prefix")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 {
+		c.usageError()
+		return
+	}
+	tx, err := parseRawTx(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	expected, err := anchorPayload(positional[1], flags["hash"], flags["prefix"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := verifyAnchor(tx, expected)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdDiffEngines(args []string) {
+	if len(args) != 1 {
+		findCommand("diff-engines").usageError()
+		return
+	}
+	request, err := loadEngineRequest(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(diffEngines(request))
+}
+
+func cmdRun(args []string) {
+	positional, flags, err := commandFlags(args, "in", "out", "verify")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	var report *BatchReport
+	switch {
+	case len(positional) != 0:
+		findCommand("run").usageError()
+		return
+	case flags["verify"] != "":
+		if flags["out"] != "" {
+			findCommand("run").usageError()
+			return
+		}
+		report, err = verifyBatch(flags["verify"], flags["in"])
+	case flags["in"] != "" && flags["out"] != "":
+		report, err = runBatch(flags["in"], flags["out"])
+	default:
+		findCommand("run").usageError()
+		return
+	}
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdWatch(args []string) {
+	positional, flags, err := commandFlags(args, "results", "archive", "interval", "pprof")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 {
+		findCommand("watch").usageError()
+		return
+	}
+	if options.porcelain {
+		outputError("watch writes a document per request file and cannot run with --porcelain")
+		return
+	}
+	w, err := newWatcher(positional[0], flags)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if addr, ok := flags["pprof"]; ok {
+		if err := servePprof(addr); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	w.drainOnSignal()
+	w.reloadOnSignal()
+	outputFailure(w.run())
+}
+
+func cmdTUI(args []string) {
+	if len(args) != 0 {
+		findCommand("tui").usageError()
+		return
+	}
+	spec, err := runTUI(os.Stdin, os.Stderr)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(spec)
+}
+
+func cmdUI(args []string) {
+	if len(args) > 1 {
+		findCommand("ui").usageError()
+		return
+	}
+	addr := defaultUIAddress
+	if len(args) == 1 {
+		addr = args[0]
+	}
+	outputFailure(serveUI(addr))
+}
+
+func cmdFrostAddresses(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		findCommand("frost-addresses").usageError()
+		return
+	}
+	count := 10
+	if len(args) == 2 {
+		var err error
+		if count, err = parseCount(args[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	group, err := loadFrostGroup(args[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := frostAddresses(group, count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdLND(args []string) {
+	positional, flags, err := commandFlags(args, "account", "address")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 && len(positional) != 2 {
+		findCommand("lnd").usageError()
+		return
+	}
+	count := 10
+	if len(positional) == 2 {
+		if count, err = parseCount(positional[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	accounts, err := loadLNDAccounts(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := deriveLNDAddresses(accounts, count, flags["account"], flags["address"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdCLN(args []string) {
+	positional, flags, err := commandFlags(args, "address")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 && len(positional) != 2 {
+		findCommand("cln").usageError()
+		return
+	}
+	count := 10
+	if len(positional) == 2 {
+		if count, err = parseCount(positional[1]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	xpub, network, err := loadCLNRoot(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	report, err := deriveCLNAddresses(xpub, network, count, flags["address"])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdVerifyClose(args []string) {
+	positional, flags, err := commandFlags(args, "node")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 2 && len(positional) != 3 {
+		findCommand("verify-close").usageError()
+		return
+	}
+	count := defaultCloseSearch
+	if len(positional) == 3 {
+		if count, err = parseCount(positional[2]); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	node := flags["node"]
+	if node == "" {
+		node = "wallet"
+	}
+	report, err := verifyCloseAddress(node, positional[0], positional[1], count)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(report)
+}
+
+func cmdDecodeUR(args []string) {
+	if len(args) < 1 {
+		findCommand("decode-ur").usageError()
+		return
+	}
+	parts := args
+	if len(parts) == 1 && parts[0] == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			outputError("Failed to read UR frames: " + err.Error())
+			return
+		}
+		parts = strings.Fields(string(data))
+	}
+	urType, message, err := decodeUR(parts)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	result, err := decodeURPayload(urType, message)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	outputJSON(result)
+}
+
+func cmdEncodePSBTUR(args []string) {
+	positional, flags, err := commandFlags(args, "fragment-size", "parts", "type", "qr")
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(positional) != 1 {
+		findCommand("encode-psbt-ur").usageError()
+		return
+	}
+	fragmentSize, parts := defaultURFragmentSize, 0
+	for _, f := range []struct {
+		name string
+		n    *int
+	}{{"fragment-size", &fragmentSize}, {"parts", &parts}} {
+		if v, ok := flags[f.name]; ok {
+			if *f.n, err = strconv.Atoi(v); err != nil {
+				outputFailure(errorWithCode(errInvalidCount, "invalid --%s: %q", f.name, v))
+				return
+			}
+		}
+	}
+	urType := "crypto-psbt"
+	if v, ok := flags["type"]; ok {
+		urType = v
+	}
+	p, err := readPSBT(positional[0])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	enc, err := encodePSBTUR(p, urType, fragmentSize, parts)
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if dir := flags["qr"]; dir != "" {
+		if enc.Files, err = writeURQR(dir, enc.Parts); err != nil {
+			outputFailure(err)
+			return
+		}
+	}
+	outputJSON(enc)
+}
@@ -0,0 +1,12 @@
+package main
+
+import "crypto/subtle"
+
+// addressesMatch reports whether a derived address equals an expected one.
+// The comparison takes the same time wherever the two first differ, and
+// callers only ever report match or mismatch, never how much of an
+// expected value matched, so neither timing nor output leaks expected
+// addresses a caller did not already supply.
+func addressesMatch(derived, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(derived), []byte(expected)) == 1
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// completion generates shell completion from the command table, so the
+// completions cannot drift from the commands. Each command's usage line
+// gives its positional arguments and flags; script type, network, backend
+// and other closed sets complete to their valid values, which catches
+// mistakes like native-segwit for native_segwit before anything runs.
+
+// completionProgram is the command completions are registered for.
+const completionProgram = "verify-addresses"
+
+// completionShells are the shells completion can generate for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionRule lists the words that complete a "<command>:<slot>" key,
+// where the slot is a positional argument's number, "--<flag>" for a flag
+// value, or "--" for the flags themselves. Patterns may start with "*:"
+// to match any command.
+type completionRule struct {
+	pattern string
+	words   []string
+}
+
+// placeholderValues are the closed sets usage placeholders stand for.
+func placeholderValues(name string) []string {
+	switch name {
+	case "script_type":
+		return append(scriptTypeNames(false), scriptTypeNames(true)...)
+	case "network":
+		return networkNames
+	case "change":
+		return []string{"true", "false"}
+	case "backend":
+		return backendNames()
+	}
+	return nil
+}
+
+// completionRules derives the rules of every command, command rules
+// first, and the keys of flags that take a value.
+func completionRules() (rules []completionRule, valueFlags []string) {
+	var globals []string
+	for name, flag := range globalFlags {
+		globals = append(globals, "--"+name)
+		if flag.set != nil {
+			valueFlags = append(valueFlags, "*:--"+name)
+		}
+	}
+	sort.Strings(globals)
+	sort.Strings(valueFlags)
+
+	for _, cmd := range commands {
+		tokens := usageTokens(cmd.usage)[1:]
+		var flags []string
+		// takesValue holds the flags seen so far, and whether each takes a
+		// value, so a flag repeated in a later form of the usage skips its
+		// value word and a switch does not.
+		takesValue := map[string]bool{}
+		position := 0
+		for i := 0; i < len(tokens); i++ {
+			t := usageToken(tokens[i])
+			switch {
+			case t == "|" || t == cmd.name || t == "":
+			case strings.HasPrefix(t, "--"):
+				if valued, seen := takesValue[t]; seen {
+					if valued {
+						i++
+					}
+					continue
+				}
+				flags = append(flags, t)
+				next := ""
+				if i+1 < len(tokens) {
+					next = usageToken(tokens[i+1])
+				}
+				// A switch is followed by another flag, a bare "|" between
+				// usage forms, or nothing.
+				var values []string
+				switch {
+				case next == "" || next == "|" || strings.HasPrefix(next, "--"):
+					takesValue[t] = false
+					continue
+				case strings.HasPrefix(next, "<"):
+					values = placeholderValues(strings.Trim(next, "<>"))
+				case strings.Contains(next, "|"):
+					values = strings.Split(next, "|")
+				default:
+					takesValue[t] = false
+					continue
+				}
+				takesValue[t] = true
+				i++
+				valueFlags = append(valueFlags, cmd.name+":"+t)
+				if values == nil {
+					values = placeholderValues(t[2:])
+				}
+				if values != nil {
+					rules = append(rules, completionRule{cmd.name + ":" + t, values})
+				}
+			default:
+				var values []string
+				if strings.HasPrefix(t, "<") {
+					values = placeholderValues(strings.Split(strings.Trim(t, "<>"), "|")[0])
+				} else if strings.Contains(t, "|") {
+					values = strings.Split(t, "|")
+				} else {
+					values = placeholderValues(t)
+				}
+				if values != nil {
+					rules = append(rules, completionRule{cmd.name + ":" + strconv.Itoa(position), values})
+				}
+				position++
+			}
+		}
+		if len(flags) > 0 {
+			rules = append(rules, completionRule{cmd.name + ":--", append(flags, globals...)})
+		}
+	}
+	rules = append(rules,
+		completionRule{"*:--format", outputFormats},
+		completionRule{"*:--engine", engineNames()},
+		completionRule{"*:--", globals},
+	)
+	return rules, valueFlags
+}
+
+// usageTokens splits a usage line into words, separating alternatives
+// between flags ("--a <x>|--b <y>") into flags and "|" words.
+func usageTokens(usage string) []string {
+	var tokens []string
+	for _, t := range strings.Fields(usage) {
+		for i, part := range strings.Split(t, "|--") {
+			if i > 0 {
+				tokens = append(tokens, "|")
+				part = "--" + part
+			}
+			tokens = append(tokens, part)
+		}
+	}
+	return tokens
+}
+
+// usageToken strips the optional and repetition marks from a usage word.
+func usageToken(t string) string {
+	return strings.TrimSuffix(strings.Trim(t, "[]"), "...")
+}
+
+func completionScript(shell string) (string, error) {
+	rules, valueFlags := completionRules()
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	switch shell {
+	case "bash":
+		return bashCompletion(rules, valueFlags, names), nil
+	case "zsh":
+		return zshCompletion(rules, valueFlags, names), nil
+	case "fish":
+		return fishCompletion(rules, valueFlags, names), nil
+	}
+	return "", fmt.Errorf("unsupported shell: %s (want %s)", shell, strings.Join(completionShells, ", "))
+}
+
+// shCases renders the rules as the arms of a sh case statement, shared by
+// bash and zsh.
+func shCases(rules []completionRule, valueFlags []string) (words, values string) {
+	var b strings.Builder
+	for _, r := range rules {
+		fmt.Fprintf(&b, "\t%s) echo %q ;;\n", r.pattern, strings.Join(r.words, " "))
+	}
+	return b.String(), strings.Join(valueFlags, "|")
+}
+
+func bashCompletion(rules []completionRule, valueFlags, names []string) string {
+	words, values := shCases(rules, valueFlags)
+	return fmt.Sprintf(`# bash completion for %[1]s, generated by "%[1]s completion bash".
+# Load it with: source <(%[1]s completion bash)
+
+_verify_addresses_words() {
+	case "$1" in
+%[2]s	esac
+}
+
+_verify_addresses_takes_value() {
+	case "$1" in
+	%[3]s) return 0 ;;
+	esac
+	return 1
+}
+
+_verify_addresses() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmd="" n=0 skip="" i w list
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		w=${COMP_WORDS[i]}
+		if [[ -n $skip ]]; then
+			skip=""
+			continue
+		fi
+		case "$w" in
+		--*=*) ;;
+		--*) _verify_addresses_takes_value "${cmd:-_}:$w" && skip=1 ;;
+		*) if [[ -z $cmd ]]; then cmd=$w; else ((n++)); fi ;;
+		esac
+	done
+	if [[ -n $skip ]]; then
+		list=$(_verify_addresses_words "${cmd:-_}:$prev")
+	elif [[ $cur == -* ]]; then
+		list=$(_verify_addresses_words "${cmd:-_}:--")
+	elif [[ -z $cmd ]]; then
+		list=%[4]q
+	else
+		list=$(_verify_addresses_words "$cmd:$n")
+	fi
+	if [[ -n $list ]]; then
+		COMPREPLY=($(compgen -W "$list" -- "$cur"))
+	fi
+}
+
+complete -o default -F _verify_addresses %[1]s
+`, completionProgram, words, values, strings.Join(names, " "))
+}
+
+func zshCompletion(rules []completionRule, valueFlags, names []string) string {
+	words, values := shCases(rules, valueFlags)
+	return fmt.Sprintf(`#compdef %[1]s
+# zsh completion for %[1]s, generated by "%[1]s completion zsh".
+# Save it as _%[1]s in a directory on $fpath, or load it with:
+#   source <(%[1]s completion zsh)
+
+_verify_addresses_words() {
+	case "$1" in
+%[2]s	esac
+}
+
+_verify_addresses_takes_value() {
+	case "$1" in
+	%[3]s) return 0 ;;
+	esac
+	return 1
+}
+
+_verify_addresses() {
+	local cur=${words[CURRENT]} prev=${words[CURRENT-1]}
+	local cmd="" n=0 skip="" i w list
+	for ((i = 2; i < CURRENT; i++)); do
+		w=${words[i]}
+		if [[ -n $skip ]]; then
+			skip=""
+			continue
+		fi
+		case "$w" in
+		--*=*) ;;
+		--*) _verify_addresses_takes_value "${cmd:-_}:$w" && skip=1 ;;
+		*) if [[ -z $cmd ]]; then cmd=$w; else ((n++)); fi ;;
+		esac
+	done
+	if [[ -n $skip ]]; then
+		list=$(_verify_addresses_words "${cmd:-_}:$prev")
+	elif [[ $cur == -* ]]; then
+		list=$(_verify_addresses_words "${cmd:-_}:--")
+	elif [[ -z $cmd ]]; then
+		list=%[4]q
+	else
+		list=$(_verify_addresses_words "$cmd:$n")
+	fi
+	if [[ -n $list ]]; then
+		compadd -- ${=list}
+	else
+		_files
+	fi
+}
+
+if [[ $funcstack[1] == _%[1]s ]]; then
+	_verify_addresses "$@"
+else
+	compdef _verify_addresses %[1]s
+fi
+`, completionProgram, words, values, strings.Join(names, " "))
+}
+
+func fishCompletion(rules []completionRule, valueFlags, names []string) string {
+	var words strings.Builder
+	for _, r := range rules {
+		fmt.Fprintf(&words, "\t\tcase '%s'\n\t\t\techo %s\n", r.pattern, strings.Join(r.words, " "))
+	}
+	var values []string
+	for _, v := range valueFlags {
+		values = append(values, "'"+v+"'")
+	}
+	return fmt.Sprintf(`# fish completion for %[1]s, generated by "%[1]s completion fish".
+# Save it as ~/.config/fish/completions/%[1]s.fish, or load it with:
+#   %[1]s completion fish | source
+
+function __verify_addresses_words
+	switch $argv[1]
+%[2]s	end
+end
+
+function __verify_addresses_complete
+	set -l tokens (commandline -opc)
+	set -e tokens[1]
+	set -l cur (commandline -ct)
+	set -l cmd _
+	set -l n 0
+	set -l skip 0
+	for w in $tokens
+		if test $skip -eq 1
+			set skip 0
+			continue
+		end
+		switch $w
+			case '--*=*'
+			case '--*'
+				switch "$cmd:$w"
+					case %[3]s
+						set skip 1
+				end
+			case '*'
+				if test $cmd = _
+					set cmd $w
+				else
+					set n (math $n + 1)
+				end
+		end
+	end
+	set -l list
+	if test $skip -eq 1
+		set list (string split ' ' -- (__verify_addresses_words "$cmd:$tokens[-1]"))
+	else if string match -q -- '-*' "$cur"
+		set list (string split ' ' -- (__verify_addresses_words "$cmd:--"))
+	else if test $cmd = _
+		set list %[4]s
+	else
+		set list (string split ' ' -- (__verify_addresses_words "$cmd:$n"))
+	end
+	if test -n "$list"
+		printf '%%s\n' $list
+	else
+		__fish_complete_path "$cur"
+	end
+end
+
+complete -c %[1]s -f -a '(__verify_addresses_complete)'
+`, completionProgram, words.String(), strings.Join(values, " "), strings.Join(names, " "))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// curveBackend does the EC math of public child derivation. btcec is
+// always available; builds with -tags libsecp256k1 switch to the C library
+// (see curve_libsecp256k1.go).
+type curveBackend struct {
+	name string
+	// tweakAdd returns the compressed key pub + tweak*G, failing if tweak
+	// is not a valid scalar or the result is the point at infinity.
+	tweakAdd func(pub, tweak []byte) ([]byte, error)
+}
+
+var btcecBackend = &curveBackend{name: "btcec"}
+
+// curveBackends lists the backends built in, btcec first.
+var curveBackends = []*curveBackend{btcecBackend}
+
+// activeCurve is the backend derivations use.
+var activeCurve = btcecBackend
+
+// derivePublicPath derives the public key at path below ext with the
+// active curve backend.
+func derivePublicPath(ext *hdkeychain.ExtendedKey, path []uint32) (*btcec.PublicKey, error) {
+	if activeCurve.tweakAdd == nil {
+		var err error
+		for _, i := range path {
+			if ext, err = ext.Derive(i); err != nil {
+				return nil, fmt.Errorf("failed to derive child %d: %v", i, err)
+			}
+		}
+		return ext.ECPubKey()
+	}
+
+	parent, err := ext.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	pub, chainCode := parent.SerializeCompressed(), ext.ChainCode()
+	for _, i := range path {
+		if i >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("cannot derive hardened child %d from a public key", i)
+		}
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(pub)
+		binary.Write(mac, binary.BigEndian, i)
+		sum := mac.Sum(nil)
+		if pub, err = activeCurve.tweakAdd(pub, sum[:32]); err != nil {
+			return nil, fmt.Errorf("failed to derive child %d: %v", i, err)
+		}
+		chainCode = sum[32:]
+	}
+	return btcec.ParsePubKey(pub)
+}
+
+// curveBackendName reports the backend in verbose output: the curve
+// backend under the btcsuite engine, or the name of any other engine,
+// which brings its own curve arithmetic.
+func curveBackendName() string {
+	if !options.verbose {
+		return ""
+	}
+	if e := selectedEngine(); e != addressEngines[0] {
+		return e.name
+	}
+	return activeCurve.name
+}
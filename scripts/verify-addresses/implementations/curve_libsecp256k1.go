@@ -0,0 +1,35 @@
+//go:build libsecp256k1
+
+package main
+
+/*
+#cgo LDFLAGS: -lsecp256k1
+#include <secp256k1.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+var secp256k1Context = C.secp256k1_context_create(C.SECP256K1_CONTEXT_VERIFY)
+
+func init() {
+	activeCurve = &curveBackend{name: "libsecp256k1", tweakAdd: secp256k1TweakAdd}
+	curveBackends = append(curveBackends, activeCurve)
+}
+
+func secp256k1TweakAdd(pub, tweak []byte) ([]byte, error) {
+	var key C.secp256k1_pubkey
+	if C.secp256k1_ec_pubkey_parse(secp256k1Context, &key, (*C.uchar)(unsafe.Pointer(&pub[0])), C.size_t(len(pub))) != 1 {
+		return nil, fmt.Errorf("libsecp256k1: invalid public key")
+	}
+	if C.secp256k1_ec_pubkey_tweak_add(secp256k1Context, &key, (*C.uchar)(unsafe.Pointer(&tweak[0]))) != 1 {
+		return nil, fmt.Errorf("libsecp256k1: tweak out of range or result at infinity")
+	}
+	out := make([]byte, 33)
+	outLen := C.size_t(len(out))
+	C.secp256k1_ec_pubkey_serialize(secp256k1Context, (*C.uchar)(unsafe.Pointer(&out[0])), &outLen, &key, C.SECP256K1_EC_COMPRESSED)
+	return out, nil
+}
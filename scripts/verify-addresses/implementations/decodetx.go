@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// decode-tx shows what a transaction does, in the terms a signer checks:
+// each output's amount and address and, given the wallet spec, whether
+// the address is the wallet's, on which chain and at which index, with its
+// label. An input names only the output it spends. Its address is read from
+// the script its witness or scriptSig reveals, or with --prevouts from the
+// previous transaction, fetched through the backend, which also gives its
+// value and so the fee.
+
+// defaultTxScanCount is how many addresses of each chain are derived to
+// recognize the wallet's inputs and outputs.
+const defaultTxScanCount = 200
+
+// TxDecodeReport is a decoded transaction.
+type TxDecodeReport struct {
+	TxID     string `json:"txid"`
+	WTxID    string `json:"wtxid"`
+	Network  string `json:"network"`
+	Version  int32  `json:"version"`
+	LockTime uint32 `json:"locktime"`
+	Size     int    `json:"size"`
+	Weight   int    `json:"weight"`
+	VSize    int    `json:"vsize"`
+	// RBF is set when an input's sequence signals replaceability
+	// (BIP-125).
+	RBF         bool            `json:"rbf"`
+	Inputs      []DecodedInput  `json:"inputs"`
+	Outputs     []DecodedOutput `json:"outputs"`
+	OutputTotal int64           `json:"output_total"`
+	// InputTotal, Fee and FeeRate are set when every input's value is
+	// known.
+	InputTotal *int64  `json:"input_total,omitempty"`
+	Fee        *int64  `json:"fee,omitempty"`
+	FeeRate    float64 `json:"fee_rate,omitempty"`
+	// Wallet is what the transaction does to the wallet, given its spec.
+	Wallet *TxWalletEffect `json:"wallet,omitempty"`
+	// Summary is the transaction in a line per fact, as a signer reads it.
+	Summary []string `json:"summary"`
+}
+
+// DecodedInput is one input and what is known of the output it spends.
+type DecodedInput struct {
+	Vin      int    `json:"vin"`
+	Outpoint string `json:"outpoint"`
+	Sequence uint32 `json:"sequence"`
+	Signed   bool   `json:"signed"`
+	// Type and Address are of the output spent. Inferred is set when they
+	// were read from the witness or scriptSig instead of the previous
+	// transaction; a taproot key-path spend reveals neither.
+	Type     string `json:"type,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Inferred bool   `json:"inferred,omitempty"`
+	Value    *int64 `json:"value,omitempty"`
+	// Wallet places the output spent in the wallet; it is nil for one
+	// that is not the wallet's.
+	Wallet *TxOwner `json:"wallet,omitempty"`
+}
+
+// DecodedOutput is one output.
+type DecodedOutput struct {
+	Vout    int    `json:"vout"`
+	Value   int64  `json:"value"`
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+	Script  string `json:"script"`
+	// Data is the payload of an OP_RETURN output.
+	Data   string   `json:"data,omitempty"`
+	Wallet *TxOwner `json:"wallet,omitempty"`
+}
+
+// TxOwner is where a wallet address sits.
+type TxOwner struct {
+	Change bool   `json:"change"`
+	Index  uint32 `json:"index"`
+	Label  string `json:"label,omitempty"`
+}
+
+// TxWalletEffect sums a transaction's inputs and outputs for the wallet.
+type TxWalletEffect struct {
+	// Searched is how many addresses of each chain were compared.
+	Searched   int `json:"searched"`
+	OwnInputs  int `json:"own_inputs"`
+	OwnOutputs int `json:"own_outputs"`
+	// Spent is the value of the wallet's inputs, set when each is known.
+	Spent *int64 `json:"spent,omitempty"`
+	// Received is paid to the wallet's addresses, and Sent to others.
+	Received int64 `json:"received"`
+	Sent     int64 `json:"sent"`
+}
+
+// txDecodeRequest is a parsed decode-tx command line.
+type txDecodeRequest struct {
+	network string
+	spec    *WalletSpec
+	count   int
+	// backend fetches the previous transactions; nil leaves input values
+	// unknown.
+	backend ChainBackend
+}
+
+// decodeTx decodes tx and annotates it for req's wallet, if any.
+func decodeTx(tx *wire.MsgTx, req *txDecodeRequest) (*TxDecodeReport, error) {
+	var owners map[string]TxOwner
+	if req.spec != nil {
+		var err error
+		if owners, err = walletScripts(req.spec, req.count); err != nil {
+			return nil, err
+		}
+	}
+	net := getNetwork(req.network)
+	weight := tx.SerializeSizeStripped()*3 + tx.SerializeSize()
+	r := &TxDecodeReport{
+		TxID:     tx.TxHash().String(),
+		WTxID:    tx.WitnessHash().String(),
+		Network:  req.network,
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Size:     tx.SerializeSize(),
+		Weight:   weight,
+		VSize:    vsize(weight),
+		Inputs:   []DecodedInput{},
+		Outputs:  []DecodedOutput{},
+	}
+	if owners != nil {
+		r.Wallet = &TxWalletEffect{Searched: req.count}
+	}
+
+	prevTxs := map[chainhash.Hash]*wire.MsgTx{}
+	inputTotal, valuesKnown := int64(0), true
+	spent, spentKnown := int64(0), true
+	for i, in := range tx.TxIn {
+		d := DecodedInput{
+			Vin:      i,
+			Outpoint: in.PreviousOutPoint.String(),
+			Sequence: in.Sequence,
+			Signed:   len(in.SignatureScript) > 0 || len(in.Witness) > 0,
+		}
+		r.RBF = r.RBF || in.Sequence < wire.MaxTxInSequenceNum-1
+		var script []byte
+		if req.backend != nil {
+			prev, ok := prevTxs[in.PreviousOutPoint.Hash]
+			if !ok {
+				var err error
+				if prev, err = fetchTransaction(req.backend, in.PreviousOutPoint.Hash.String()); err != nil {
+					return nil, fmt.Errorf("input %d: %v", i, err)
+				}
+				prevTxs[in.PreviousOutPoint.Hash] = prev
+			}
+			if int(in.PreviousOutPoint.Index) >= len(prev.TxOut) {
+				return nil, fmt.Errorf("input %d spends output %d of %s, which has %d", i, in.PreviousOutPoint.Index, in.PreviousOutPoint.Hash, len(prev.TxOut))
+			}
+			out := prev.TxOut[in.PreviousOutPoint.Index]
+			script = out.PkScript
+			value := out.Value
+			d.Value = &value
+			inputTotal += value
+		} else {
+			valuesKnown = false
+			script = spentScript(in)
+			d.Inferred = script != nil
+		}
+		if script != nil {
+			d.Type, d.Address = describeScript(script, net)
+		} else {
+			// Whether the input is the wallet's is not known either.
+			spentKnown = false
+		}
+		if owner, ok := owners[string(script)]; ok && script != nil {
+			d.Wallet = &owner
+			r.Wallet.OwnInputs++
+			if d.Value != nil {
+				spent += *d.Value
+			} else {
+				spentKnown = false
+			}
+		}
+		r.Inputs = append(r.Inputs, d)
+	}
+
+	for j, out := range tx.TxOut {
+		d := DecodedOutput{Vout: j, Value: out.Value, Script: hex.EncodeToString(out.PkScript)}
+		d.Type, d.Address = describeScript(out.PkScript, net)
+		if txscript.GetScriptClass(out.PkScript) == txscript.NullDataTy {
+			if pushes, err := txscript.PushedData(out.PkScript); err == nil {
+				d.Data = hex.EncodeToString(bytes.Join(pushes, nil))
+			}
+		}
+		r.OutputTotal += out.Value
+		if owner, ok := owners[string(out.PkScript)]; ok {
+			d.Wallet = &owner
+			r.Wallet.OwnOutputs++
+			r.Wallet.Received += out.Value
+		} else if r.Wallet != nil {
+			r.Wallet.Sent += out.Value
+		}
+		r.Outputs = append(r.Outputs, d)
+	}
+
+	if valuesKnown {
+		fee := inputTotal - r.OutputTotal
+		r.InputTotal, r.Fee = &inputTotal, &fee
+		r.FeeRate = float64(fee) * 4 / float64(weight)
+	}
+	if r.Wallet != nil && spentKnown {
+		r.Wallet.Spent = &spent
+	}
+	r.Summary = summarizeTx(r)
+	return r, nil
+}
+
+// walletScripts maps the output scripts of spec's first count addresses
+// on each chain to where they sit.
+func walletScripts(spec *WalletSpec, count int) (map[string]TxOwner, error) {
+	r := &indexRange{Start: 0, End: uint32(count - 1)}
+	return rangeScripts(spec, map[bool]*indexRange{false: r, true: r})
+}
+
+// rangeScripts maps the output scripts of the addresses in each chain's
+// range to where they sit. A chain without a range has none.
+func rangeScripts(spec *WalletSpec, ranges map[bool]*indexRange) (map[string]TxOwner, error) {
+	owners := map[string]TxOwner{}
+	for _, change := range []bool{false, true} {
+		r := ranges[change]
+		if r == nil {
+			continue
+		}
+		for i := uint64(r.Start); i <= uint64(r.End); i++ {
+			address, err := spec.deriveAddress(change, uint32(i))
+			if err != nil {
+				return nil, err
+			}
+			script, err := addressScript(address, spec.Network)
+			if err != nil {
+				return nil, err
+			}
+			owners[string(script)] = TxOwner{Change: change, Index: uint32(i), Label: spec.Labels[address]}
+		}
+	}
+	return owners, nil
+}
+
+// describeScript returns a script's class and its address, if it has one.
+// P2PK and bare multisig scripts have no address of their own.
+func describeScript(script []byte, net *chaincfg.Params) (string, string) {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(script, net)
+	if err != nil || len(addrs) != 1 || class == txscript.PubKeyTy || class == txscript.MultiSigTy {
+		return class.String(), ""
+	}
+	return class.String(), addrs[0].EncodeAddress()
+}
+
+// spentScript infers the output script an input spends from the script its
+// witness or scriptSig reveals, or returns nil. A P2PK or taproot key-path
+// spend carries only signatures.
+func spentScript(in *wire.TxIn) []byte {
+	pushes, err := txscript.PushedData(in.SignatureScript)
+	if err != nil {
+		return nil
+	}
+	p2sh := func(redeem []byte) []byte {
+		return append(append([]byte{txscript.OP_HASH160, txscript.OP_DATA_20}, btcutil.Hash160(redeem)...), txscript.OP_EQUAL)
+	}
+	witness := in.Witness
+	if len(witness) == 0 {
+		switch {
+		case len(pushes) == 2 && isPubKey(pushes[1]):
+			return append(append([]byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}, btcutil.Hash160(pushes[1])...), txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+		case len(pushes) >= 2 && txscript.GetScriptClass(pushes[len(pushes)-1]) != txscript.NonStandardTy:
+			return p2sh(pushes[len(pushes)-1])
+		}
+		return nil
+	}
+
+	// A scriptSig alongside a witness is the push of a nested program.
+	if len(pushes) == 1 && txscript.IsWitnessProgram(pushes[0]) {
+		return p2sh(pushes[0])
+	}
+	if len(in.SignatureScript) != 0 {
+		return nil
+	}
+	if len(witness) >= 2 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == txscript.TaprootAnnexTag {
+		witness = witness[:len(witness)-1]
+	}
+	last := witness[len(witness)-1]
+	switch {
+	case len(witness) == 2 && isPubKey(last):
+		return append([]byte{txscript.OP_0, txscript.OP_DATA_20}, btcutil.Hash160(last)...)
+	case len(witness) >= 2:
+		if cb, err := txscript.ParseControlBlock(last); err == nil {
+			root := cb.RootHash(witness[len(witness)-2])
+			key := txscript.ComputeTaprootOutputKey(cb.InternalKey, root)
+			return append([]byte{txscript.OP_1, txscript.OP_DATA_32}, schnorr.SerializePubKey(key)...)
+		}
+		h := sha256.Sum256(last)
+		return append([]byte{txscript.OP_0, txscript.OP_DATA_32}, h[:]...)
+	}
+	return nil
+}
+
+// isPubKey reports whether b is shaped like a serialized public key.
+func isPubKey(b []byte) bool {
+	switch len(b) {
+	case 33:
+		return b[0] == 0x02 || b[0] == 0x03
+	case 65:
+		return b[0] == 0x04
+	}
+	return false
+}
+
+// summarizeTx writes the report as the lines a signer reads.
+func summarizeTx(r *TxDecodeReport) []string {
+	var lines []string
+	ours := ""
+	if r.Wallet != nil {
+		ours = fmt.Sprintf(", %d known to be the wallet's", r.Wallet.OwnInputs)
+	}
+	inputs := "inputs"
+	if len(r.Inputs) == 1 {
+		inputs = "input"
+	}
+	lines = append(lines, fmt.Sprintf("spends %d %s%s, %d vB", len(r.Inputs), inputs, ours, r.VSize))
+	for _, o := range r.Outputs {
+		to := o.Address
+		if to == "" {
+			to = "a " + o.Type + " script"
+		}
+		switch {
+		case o.Data != "":
+			lines = append(lines, fmt.Sprintf("output %d: %s carrying %d bytes of OP_RETURN data", o.Vout, formatBTC(o.Value), len(o.Data)/2))
+		case o.Wallet != nil:
+			lines = append(lines, fmt.Sprintf("output %d: %s to %s, the wallet's %s", o.Vout, formatBTC(o.Value), to, describeOwner(o.Wallet)))
+		case r.Wallet != nil:
+			lines = append(lines, fmt.Sprintf("output %d: %s to %s, not the wallet's", o.Vout, formatBTC(o.Value), to))
+		default:
+			lines = append(lines, fmt.Sprintf("output %d: %s to %s", o.Vout, formatBTC(o.Value), to))
+		}
+	}
+	if r.Fee != nil {
+		lines = append(lines, fmt.Sprintf("fee: %d sat, %.1f sat/vB", *r.Fee, r.FeeRate))
+	} else {
+		lines = append(lines, "fee: unknown without the values of the inputs, which --prevouts fetches")
+	}
+	if r.Wallet != nil && r.Wallet.Spent != nil {
+		lines = append(lines, fmt.Sprintf("the wallet's balance changes by %s", formatBTC(r.Wallet.Received-*r.Wallet.Spent)))
+	}
+	if r.RBF {
+		lines = append(lines, "signals replaceability (BIP-125)")
+	}
+	// Final sequences on every input disable the locktime.
+	enforced := false
+	for _, in := range r.Inputs {
+		enforced = enforced || in.Sequence != wire.MaxTxInSequenceNum
+	}
+	switch {
+	case r.LockTime == 0 || !enforced:
+	case r.LockTime < txscript.LockTimeThreshold:
+		lines = append(lines, fmt.Sprintf("cannot be mined before block %d", r.LockTime))
+	default:
+		lines = append(lines, fmt.Sprintf("cannot be mined before %s", time.Unix(int64(r.LockTime), 0).UTC().Format(time.RFC3339)))
+	}
+	return lines
+}
+
+// describeOwner names a wallet address's place, with its label.
+func describeOwner(o *TxOwner) string {
+	chain := "receive"
+	if o.Change {
+		chain = "change"
+	}
+	s := fmt.Sprintf("%s address %d", chain, o.Index)
+	if o.Label != "" {
+		s += fmt.Sprintf(" (%q)", o.Label)
+	}
+	return s
+}
+
+// formatBTC writes an amount in satoshis as bitcoin.
+func formatBTC(sats int64) string {
+	sign := ""
+	if sats < 0 {
+		sign, sats = "-", -sats
+	}
+	return fmt.Sprintf("%s%d.%08d BTC", sign, sats/btcutil.SatoshiPerBitcoin, sats%btcutil.SatoshiPerBitcoin)
+}
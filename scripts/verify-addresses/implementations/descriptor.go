@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseDescriptor parses a BIP-380 output descriptor into a wallet spec.
+// Keys must use the "/0/*", "/1/*" or "/<0;1>/*" chain layout.
+func parseDescriptor(desc string) (*WalletSpec, error) {
+	desc = strings.TrimSpace(desc)
+	if i := strings.LastIndex(desc, "#"); i >= 0 {
+		expected, err := descriptorChecksum(desc[:i])
+		if err != nil {
+			return nil, err
+		}
+		if desc[i+1:] != expected {
+			return nil, fmt.Errorf("descriptor checksum mismatch: got %s, expected %s", desc[i+1:], expected)
+		}
+		desc = desc[:i]
+	}
+
+	if isTaprootPolicyDescriptor(desc) {
+		return parseTaprootPolicy(desc)
+	}
+
+	for _, t := range descriptorScriptTypes() {
+		suffix := t.descriptorSuffix()
+		if t.descriptor == "" || !strings.HasPrefix(desc, t.descriptor) || !strings.HasSuffix(desc, suffix) {
+			continue
+		}
+		inner := desc[len(t.descriptor) : len(desc)-len(suffix)]
+		spec := &WalletSpec{ScriptType: t.name}
+
+		args := []string{inner}
+		if spec.isMultisig() {
+			args = strings.Split(inner, ",")
+			threshold, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid multisig threshold: %q", args[0])
+			}
+			spec.Threshold = threshold
+			args = args[1:]
+		}
+
+		for _, arg := range args {
+			key, err := parseDescriptorKey(arg)
+			if err != nil {
+				return nil, err
+			}
+			spec.Keys = append(spec.Keys, key)
+		}
+		spec.Network = networkFromXpub(spec.Keys[0].Xpub)
+		return spec, nil
+	}
+
+	return nil, fmt.Errorf("unsupported descriptor: %s", desc)
+}
+
+// parseDescriptorKey parses a "[fingerprint/path]xpub/<chain>/*" key expression.
+func parseDescriptorKey(expr string) (WalletKey, error) {
+	key, derivation, err := parseKeyOrigin(expr)
+	if err != nil {
+		return key, err
+	}
+	if derivation == "" {
+		return key, fmt.Errorf("key %s has no wildcard derivation", key.Xpub)
+	}
+	switch derivation {
+	case "0/*", "1/*", "<0;1>/*":
+	default:
+		return key, fmt.Errorf("unsupported key derivation /%s (expected /0/*, /1/* or /<0;1>/*)", derivation)
+	}
+	return key, nil
+}
+
+// parseKeyOrigin splits a key expression into its origin and xpub, and
+// returns the derivation steps after the xpub ("" if there are none).
+func parseKeyOrigin(expr string) (WalletKey, string, error) {
+	var key WalletKey
+
+	if strings.HasPrefix(expr, "[") {
+		end := strings.Index(expr, "]")
+		if end < 0 {
+			return key, "", fmt.Errorf("unterminated key origin: %s", expr)
+		}
+		origin := strings.SplitN(expr[1:end], "/", 2)
+		if len(origin[0]) != 8 {
+			return key, "", fmt.Errorf("invalid key origin fingerprint: %q", origin[0])
+		}
+		key.Fingerprint = strings.ToLower(origin[0])
+		key.Path = "m"
+		if len(origin) == 2 {
+			key.Path += "/" + strings.ReplaceAll(origin[1], "h", "'")
+		}
+		expr = expr[end+1:]
+	}
+
+	parts := strings.SplitN(expr, "/", 2)
+	key.Xpub = parts[0]
+	if key.Xpub == "" {
+		return key, "", fmt.Errorf("key expression has no extended key: %q", expr)
+	}
+	if len(parts) != 2 {
+		return key, "", nil
+	}
+	return key, parts[1], nil
+}
+
+// walletDescriptor renders the receive (or change) descriptor of a wallet,
+// including its checksum.
+func walletDescriptor(spec *WalletSpec, change bool) (string, error) {
+	chain := "0"
+	if change {
+		chain = "1"
+	}
+
+	if spec.Taproot != nil {
+		return taprootPolicyDescriptor(spec, chain)
+	}
+	if spec.Uncompressed {
+		return "", fmt.Errorf("no descriptor form for wallets with uncompressed keys")
+	}
+
+	keys := make([]string, len(spec.Keys))
+	for i, k := range spec.Keys {
+		keys[i] = descriptorKey(k, spec.Network, "/"+chain+"/*")
+	}
+
+	t, ok := scriptTypes[spec.ScriptType]
+	if !ok || t.descriptor == "" {
+		return "", fmt.Errorf("no descriptor form for script type: %s", spec.ScriptType)
+	}
+	inner := keys[0]
+	if t.multisig {
+		inner = strconv.Itoa(spec.Threshold) + "," + strings.Join(keys, ",")
+	}
+	return withChecksum(t.descriptor + inner + t.descriptorSuffix())
+}
+
+// descriptorKey renders a key expression with its origin, followed by the
+// given derivation suffix.
+func descriptorKey(k WalletKey, network, suffix string) string {
+	expr := convertToStandardXpub(k.Xpub, network) + suffix
+	if k.Fingerprint != "" {
+		origin := k.Fingerprint
+		if path := strings.TrimPrefix(strings.TrimPrefix(k.Path, "m"), "/"); path != "" {
+			origin += "/" + strings.ReplaceAll(path, "'", "h")
+		}
+		expr = "[" + origin + "]" + expr
+	}
+	return expr
+}
+
+// withChecksum appends the descriptor checksum.
+func withChecksum(desc string) (string, error) {
+	checksum, err := descriptorChecksum(desc)
+	if err != nil {
+		return "", err
+	}
+	return desc + "#" + checksum, nil
+}
+
+const (
+	descriptorInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+func descriptorPolymod(c uint64, val uint64) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ val
+	if c0&1 != 0 {
+		c ^= 0xf5dee51989
+	}
+	if c0&2 != 0 {
+		c ^= 0xa9fdca3312
+	}
+	if c0&4 != 0 {
+		c ^= 0x1bab10e32d
+	}
+	if c0&8 != 0 {
+		c ^= 0x3706b1677a
+	}
+	if c0&16 != 0 {
+		c ^= 0x644d626ffd
+	}
+	return c
+}
+
+// descriptorChecksum computes the BIP-380 checksum of a descriptor.
+func descriptorChecksum(desc string) (string, error) {
+	c := uint64(1)
+	cls, clsCount := uint64(0), 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(descriptorInputCharset, ch)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid descriptor character: %q", ch)
+		}
+		c = descriptorPolymod(c, uint64(pos&31))
+		cls = cls*3 + uint64(pos>>5)
+		clsCount++
+		if clsCount == 3 {
+			c = descriptorPolymod(c, cls)
+			cls, clsCount = 0, 0
+		}
+	}
+	if clsCount > 0 {
+		c = descriptorPolymod(c, cls)
+	}
+	for i := 0; i < 8; i++ {
+		c = descriptorPolymod(c, 0)
+	}
+	c ^= 1
+
+	checksum := make([]byte, 8)
+	for i := range checksum {
+		checksum[i] = descriptorChecksumCharset[(c>>(5*(7-i)))&31]
+	}
+	return string(checksum), nil
+}
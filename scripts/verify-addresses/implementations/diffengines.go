@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// EngineRequest is one derivation for diff-engines to run through every
+// engine: the arguments of single (one key) or multi.
+type EngineRequest struct {
+	Xpubs        []string `json:"xpubs"`
+	Threshold    int      `json:"threshold,omitempty"`
+	ScriptType   string   `json:"script_type"`
+	Network      string   `json:"network"`
+	Change       bool     `json:"change"`
+	Index        uint32   `json:"index"`
+	Count        int      `json:"count,omitempty"`
+	Uncompressed bool     `json:"uncompressed,omitempty"`
+	// Xpub may be given instead of Xpubs for single-sig requests.
+	Xpub string `json:"xpub,omitempty"`
+}
+
+// EngineDiff compares every intermediate child key and address of a
+// request across engines.
+type EngineDiff struct {
+	Request EngineRequest `json:"request"`
+	Engines []string      `json:"engines"`
+	Fields  []EngineField `json:"fields"`
+	// Agree is set when every field matched in every engine.
+	Agree bool `json:"agree"`
+}
+
+// EngineField is one compared value, by engine. Failed derivations show
+// as "error: ...".
+type EngineField struct {
+	Name   string            `json:"name"`
+	Values map[string]string `json:"values"`
+	Match  bool              `json:"match"`
+}
+
+// maxDiffCount bounds the addresses one diff-engines request may cover.
+const maxDiffCount = 1000
+
+func loadEngineRequest(arg string) (*EngineRequest, error) {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if os.IsNotExist(err) {
+			data, err = []byte(arg), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read engine request: %v", err)
+	}
+
+	var r EngineRequest
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse engine request: %v", err)
+	}
+	if r.Xpub != "" {
+		r.Xpubs = append([]string{r.Xpub}, r.Xpubs...)
+		r.Xpub = ""
+	}
+	if r.Count == 0 {
+		r.Count = 1
+	}
+	return &r, r.validate()
+}
+
+func (r *EngineRequest) validate() error {
+	if err := checkNetwork(r.Network); err != nil {
+		return err
+	}
+	st, err := lookupScriptType(r.ScriptType)
+	if err != nil {
+		return err
+	}
+	if err := checkNetworkScriptType(r.Network, r.ScriptType); err != nil {
+		return err
+	}
+	if len(r.Xpubs) == 0 {
+		return fmt.Errorf("engine request has no keys")
+	}
+	if st.multisig {
+		if err := checkThreshold(r.Threshold, len(r.Xpubs)); err != nil {
+			return err
+		}
+	} else if len(r.Xpubs) != 1 {
+		return fmt.Errorf("script type %s takes exactly one key, got %d", r.ScriptType, len(r.Xpubs))
+	}
+	if r.Uncompressed && st.uncompressedAddress == nil {
+		return errorWithCode(errUncompressedKey, "script type %s cannot use uncompressed keys", r.ScriptType)
+	}
+	if r.Count < 1 || r.Count > maxDiffCount || uint64(r.Index)+uint64(r.Count) > 1<<31 {
+		return errorWithCode(errInvalidCount, "invalid count %d from index %d", r.Count, r.Index)
+	}
+	return nil
+}
+
+// engineVariant is an engine as diff-engines runs it: the btcsuite engine
+// once per curve backend built in, other engines once.
+type engineVariant struct {
+	name   string
+	engine *addressEngine
+	curve  *curveBackend
+}
+
+func engineVariants() []engineVariant {
+	var variants []engineVariant
+	for _, e := range addressEngines {
+		if e != addressEngines[0] || len(curveBackends) == 1 {
+			variants = append(variants, engineVariant{name: e.name, engine: e})
+			continue
+		}
+		for _, c := range curveBackends {
+			variants = append(variants, engineVariant{name: e.name + "/" + c.name, engine: e, curve: c})
+		}
+	}
+	return variants
+}
+
+// diffEngines runs the request through every engine variant.
+func diffEngines(r *EngineRequest) *EngineDiff {
+	// Disagreements are the output here, not a failure, and each engine
+	// must run on its own.
+	savedCurve, savedParanoid := activeCurve, options.paranoid
+	defer func() { activeCurve, options.paranoid = savedCurve, savedParanoid }()
+	options.paranoid = false
+
+	st := scriptTypes[r.ScriptType]
+	diff := &EngineDiff{Request: *r, Fields: []EngineField{}, Agree: true}
+	fields := map[string]*EngineField{}
+	var order []string
+	record := func(name, engine, value string, err error) {
+		if err != nil {
+			value = "error: " + err.Error()
+		}
+		f, ok := fields[name]
+		if !ok {
+			f = &EngineField{Name: name, Values: map[string]string{}}
+			fields[name] = f
+			order = append(order, name)
+		}
+		f.Values[engine] = value
+	}
+
+	for _, v := range engineVariants() {
+		s := startSpan("cross-check "+v.name, spanInternal, spanAttr{"engine", v.name})
+		diff.Engines = append(diff.Engines, v.name)
+		activeCurve = savedCurve
+		if v.curve != nil {
+			activeCurve = v.curve
+		}
+		for n := 0; n < r.Count; n++ {
+			index := r.Index + uint32(n)
+			at := chainIndex(r.Change, index)
+			for k, xpub := range r.Xpubs {
+				key, err := v.engine.childKey(xpub, index, r.Change, r.Network)
+				record(fmt.Sprintf("%s key[%d]", at, k), v.name, hex.EncodeToString(key), err)
+			}
+			var address string
+			var err error
+			switch {
+			case st.multisig:
+				address, err = v.engine.multisig(r.Xpubs, r.Threshold, index, st, r.Change, r.Network)
+			case r.Uncompressed:
+				address, err = v.engine.uncompressed(r.Xpubs[0], index, st, r.Change, r.Network)
+			default:
+				address, err = v.engine.singleSig(r.Xpubs[0], index, st, r.Change, r.Network)
+			}
+			record(at+" address", v.name, address, err)
+		}
+		s.end(nil)
+	}
+
+	for _, name := range order {
+		f := fields[name]
+		f.Match = true
+		for _, e := range diff.Engines {
+			if f.Values[e] != f.Values[diff.Engines[0]] || strings.HasPrefix(f.Values[e], "error: ") {
+				f.Match = false
+			}
+		}
+		diff.Agree = diff.Agree && f.Match
+		diff.Fields = append(diff.Fields, *f)
+	}
+	return diff
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An output is worth its value less the fee to spend it, which grows with
+// the fee rate. dust-report prices every unspent output of a wallet as an
+// input at each of --feerates: "uneconomic" when spending it costs its whole
+// value, "marginal" when it costs more than a tenth, else "economic". It
+// then suggests consolidating, at the lowest rate, the outputs that are
+// economic there but not at the highest: merged into one output of the
+// wallet while fees are low, they cost one input at high fees instead of
+// many. The saving is an estimate, since nobody knows the rate of the next
+// spend.
+
+// defaultDustFeeRates are the rates priced without --feerates, in sat/vB.
+const defaultDustFeeRates = "1,5,20,100"
+
+// marginalShare makes an output marginal once spending it costs more than
+// its value divided by marginalShare.
+const marginalShare = 10
+
+// DustReport prices a wallet's unspent outputs at several fee rates.
+type DustReport struct {
+	Network    string `json:"network"`
+	Backend    string `json:"backend"`
+	ScriptType string `json:"script_type"`
+	// InputWeight is the estimated weight of one input spending an output
+	// of the wallet.
+	InputWeight int            `json:"input_weight"`
+	FeeRates    []DustFeeRate  `json:"fee_rates"`
+	UTXOs       []DustUTXO     `json:"utxos"`
+	Total       int64          `json:"total"`
+	Consolidate *Consolidation `json:"consolidate,omitempty"`
+}
+
+// DustFeeRate sums up the outputs at one fee rate.
+type DustFeeRate struct {
+	FeeRate float64 `json:"fee_rate"`
+	// InputFee is the fee for spending one output at this rate.
+	InputFee   int64 `json:"input_fee"`
+	Uneconomic int   `json:"uneconomic"`
+	Marginal   int   `json:"marginal"`
+	Economic   int   `json:"economic"`
+	// UneconomicValue is what the uneconomic outputs hold.
+	UneconomicValue int64 `json:"uneconomic_value"`
+	// Spendable is what the wallet would keep spending every economic and
+	// marginal output separately at this rate.
+	Spendable int64 `json:"spendable"`
+}
+
+// DustUTXO is one unspent output and its class at each fee rate, in the
+// order of FeeRates.
+type DustUTXO struct {
+	Outpoint string   `json:"outpoint"`
+	Address  string   `json:"address"`
+	Value    int64    `json:"value"`
+	Change   bool     `json:"change"`
+	Index    uint32   `json:"index"`
+	Classes  []string `json:"classes"`
+}
+
+// Consolidation is the suggested merge of small outputs at the lowest fee
+// rate, priced against spending them separately at the highest.
+type Consolidation struct {
+	FeeRate       float64  `json:"fee_rate"`
+	FutureFeeRate float64  `json:"future_fee_rate"`
+	Outpoints     []string `json:"outpoints"`
+	Value         int64    `json:"value"`
+	VSize         int      `json:"vsize"`
+	Fee           int64    `json:"fee"`
+	// SeparateCost is the fee for spending the outputs as separate inputs
+	// at the future rate; MergedCost spends the one merged output.
+	SeparateCost int64 `json:"separate_cost"`
+	MergedCost   int64 `json:"merged_cost"`
+	// Saving is SeparateCost less Fee and MergedCost; consolidating pays
+	// only if it is positive.
+	Saving     int64 `json:"saving"`
+	Worthwhile bool  `json:"worthwhile"`
+}
+
+// dustReport prices the unspent outputs of q's wallet at rates, sorted
+// ascending, in sat/kvB.
+func dustReport(q *chainQuery, rates []int64) (*DustReport, error) {
+	size, err := walletInputSize(q.spec)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := listWalletUTXOs(q.spec, q.backend, q.store, q.r, q.gap, false)
+	if err != nil {
+		return nil, err
+	}
+	report := &DustReport{
+		Network:     q.spec.Network,
+		Backend:     q.backend.Name(),
+		ScriptType:  q.spec.ScriptType,
+		InputWeight: size.weight(),
+		UTXOs:       []DustUTXO{},
+		Total:       utxos.Total,
+	}
+	for _, rate := range rates {
+		report.FeeRates = append(report.FeeRates, DustFeeRate{FeeRate: float64(rate) / 1000, InputFee: feeForWeight(rate, size.weight())})
+	}
+
+	low, high := 0, len(rates)-1
+	var candidates []WalletUTXO
+	for _, u := range utxos.UTXOs {
+		d := DustUTXO{Outpoint: u.Outpoint, Address: u.Address, Value: u.Value, Change: u.Change, Index: u.Index}
+		for i := range report.FeeRates {
+			r := &report.FeeRates[i]
+			class := dustClass(u.Value, r.InputFee)
+			switch class {
+			case "uneconomic":
+				r.Uneconomic++
+				r.UneconomicValue += u.Value
+			case "marginal":
+				r.Marginal++
+				r.Spendable += u.Value - r.InputFee
+			default:
+				r.Economic++
+				r.Spendable += u.Value - r.InputFee
+			}
+			d.Classes = append(d.Classes, class)
+		}
+		if len(rates) > 1 && d.Classes[low] == "economic" && d.Classes[high] != "economic" {
+			candidates = append(candidates, u)
+		}
+		report.UTXOs = append(report.UTXOs, d)
+	}
+
+	if len(candidates) > 1 {
+		if report.Consolidate, err = consolidation(q.spec, size, candidates, rates[low], rates[high]); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// dustClass classifies an output of value that costs fee to spend.
+func dustClass(value, fee int64) string {
+	switch {
+	case value <= fee:
+		return "uneconomic"
+	case fee*marginalShare > value:
+		return "marginal"
+	}
+	return "economic"
+}
+
+// consolidation prices merging candidates into one output of the wallet
+// at rate, against spending them at future.
+func consolidation(spec *WalletSpec, size inputSize, candidates []WalletUTXO, rate, future int64) (*Consolidation, error) {
+	// Every address of a wallet has the same script size.
+	address, err := spec.deriveAddress(true, 0)
+	if err != nil {
+		return nil, err
+	}
+	script, err := addressScript(address, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	weight := txWeight(repeatInputSize(size, len(candidates)), [][]byte{script})
+	c := &Consolidation{
+		FeeRate:       float64(rate) / 1000,
+		FutureFeeRate: float64(future) / 1000,
+		VSize:         vsize(weight),
+		Fee:           feeForWeight(rate, weight),
+		MergedCost:    feeForWeight(future, size.weight()),
+	}
+	for _, u := range candidates {
+		c.Outpoints = append(c.Outpoints, u.Outpoint)
+		c.Value += u.Value
+		c.SeparateCost += feeForWeight(future, size.weight())
+	}
+	c.Saving = c.SeparateCost - c.Fee - c.MergedCost
+	c.Worthwhile = c.Saving > 0
+	return c, nil
+}
+
+// parseFeeRates parses a comma-separated list of fee rates in sat/vB and
+// returns them in sat/kvB, ascending and without repeats.
+func parseFeeRates(s string) ([]int64, error) {
+	seen := map[int64]bool{}
+	var rates []int64
+	for _, field := range strings.Split(s, ",") {
+		rate, err := parseFeeRate(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if !seen[rate] {
+			seen[rate] = true
+			rates = append(rates, rate)
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no fee rates given")
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i] < rates[j] })
+	return rates, nil
+}
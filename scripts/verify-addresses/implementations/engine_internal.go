@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// The internal engine: derivation with the in-package BIP-32 code in
+// bip32.go, and script building and address encoding written here from
+// the BIPs, with no btcsuite code at all. Selected with --engine internal,
+// and run alongside btcsuite under --paranoid.
+
+// internalNetwork holds the address encoding parameters of a network.
+type internalNetwork struct {
+	pubKeyHashVersion byte
+	scriptHashVersion byte
+	hrp               string
+}
+
+var internalNetworks = map[string]internalNetwork{
+	"mainnet": {pubKeyHashVersion: 0x00, scriptHashVersion: 0x05, hrp: "bc"},
+	"testnet": {pubKeyHashVersion: 0x6f, scriptHashVersion: 0xc4, hrp: "tb"},
+	// Unconfidential Liquid addresses.
+	"liquid":        {pubKeyHashVersion: 57, scriptHashVersion: 39, hrp: "ex"},
+	"liquidtestnet": {pubKeyHashVersion: 36, scriptHashVersion: 19, hrp: "tex"},
+}
+
+// internalScripts encodes the output of each single-sig and multisig
+// script type from its keys.
+var internalScripts = map[string]func(keys []ecPoint, threshold int, net internalNetwork) (string, error){
+	"legacy": func(keys []ecPoint, _ int, net internalNetwork) (string, error) {
+		return base58CheckEncode(net.pubKeyHashVersion, hash160(keys[0].compressed())), nil
+	},
+	"nested_segwit": func(keys []ecPoint, _ int, net internalNetwork) (string, error) {
+		redeemScript := append([]byte{0x00, 0x14}, hash160(keys[0].compressed())...)
+		return base58CheckEncode(net.scriptHashVersion, hash160(redeemScript)), nil
+	},
+	"native_segwit": func(keys []ecPoint, _ int, net internalNetwork) (string, error) {
+		return segwitEncode(net.hrp, 0, hash160(keys[0].compressed()))
+	},
+	"taproot": func(keys []ecPoint, _ int, net internalNetwork) (string, error) {
+		outputKey, err := taprootOutputKey(keys[0])
+		if err != nil {
+			return "", err
+		}
+		return segwitEncode(net.hrp, 1, outputKey)
+	},
+	"p2pk": func(keys []ecPoint, _ int, _ internalNetwork) (string, error) {
+		return hex.EncodeToString(internalPayToPubKey(keys[0].compressed())), nil
+	},
+	"p2pk_uncompressed": func(keys []ecPoint, _ int, _ internalNetwork) (string, error) {
+		return hex.EncodeToString(internalPayToPubKey(keys[0].uncompressed())), nil
+	},
+	"p2sh": func(keys []ecPoint, threshold int, net internalNetwork) (string, error) {
+		return base58CheckEncode(net.scriptHashVersion, hash160(internalSortedMulti(keys, threshold))), nil
+	},
+	"p2wsh": func(keys []ecPoint, threshold int, net internalNetwork) (string, error) {
+		witnessHash := sha256.Sum256(internalSortedMulti(keys, threshold))
+		return segwitEncode(net.hrp, 0, witnessHash[:])
+	},
+	"p2sh_p2wsh": func(keys []ecPoint, threshold int, net internalNetwork) (string, error) {
+		witnessHash := sha256.Sum256(internalSortedMulti(keys, threshold))
+		redeemScript := append([]byte{0x00, 0x20}, witnessHash[:]...)
+		return base58CheckEncode(net.scriptHashVersion, hash160(redeemScript)), nil
+	},
+	"bare_multisig": func(keys []ecPoint, threshold int, _ internalNetwork) (string, error) {
+		if len(keys) > maxBareMultisigKeys {
+			return "", fmt.Errorf("bare multisig is limited to %d keys, got %d", maxBareMultisigKeys, len(keys))
+		}
+		return hex.EncodeToString(internalSortedMulti(keys, threshold)), nil
+	},
+}
+
+// internalUncompressedScripts encodes the script types that accept an
+// uncompressed key.
+var internalUncompressedScripts = map[string]func(key ecPoint, net internalNetwork) string{
+	"legacy": func(key ecPoint, net internalNetwork) string {
+		return base58CheckEncode(net.pubKeyHashVersion, hash160(key.uncompressed()))
+	},
+	"p2pk": func(key ecPoint, _ internalNetwork) string {
+		return hex.EncodeToString(internalPayToPubKey(key.uncompressed()))
+	},
+	"p2pk_uncompressed": func(key ecPoint, _ internalNetwork) string {
+		return hex.EncodeToString(internalPayToPubKey(key.uncompressed()))
+	},
+}
+
+func internalSingleSig(xpub string, index uint32, st *scriptType, change bool, network string) (string, error) {
+	encode, ok := internalScripts[st.name]
+	if !ok {
+		return "", fmt.Errorf("the internal engine does not support script type %s", st.name)
+	}
+	key, err := internalCosignerKey(xpub, index, change)
+	if err != nil {
+		return "", err
+	}
+	return encode([]ecPoint{key}, 1, internalNetworks[network])
+}
+
+func internalUncompressed(xpub string, index uint32, st *scriptType, change bool, network string) (string, error) {
+	encode, ok := internalUncompressedScripts[st.name]
+	if !ok {
+		return "", fmt.Errorf("the internal engine does not support uncompressed %s", st.name)
+	}
+	key, err := internalChildKey(xpub, "", index, change)
+	if err != nil {
+		return "", err
+	}
+	return encode(key, internalNetworks[network]), nil
+}
+
+func internalMultisig(xpubs []string, threshold int, index uint32, st *scriptType, change bool, network string) (string, error) {
+	encode, ok := internalScripts[st.name]
+	if !ok {
+		return "", fmt.Errorf("the internal engine does not support script type %s", st.name)
+	}
+	keys := make([]ecPoint, len(xpubs))
+	for i, xpub := range xpubs {
+		var err error
+		if keys[i], err = internalCosignerKey(xpub, index, change); err != nil {
+			return "", err
+		}
+	}
+	return encode(keys, threshold, internalNetworks[network])
+}
+
+// internalCosignerKey derives a cosigner's (or single-sig key's) child
+// key, below its own path suffix if the key expression carries one.
+func internalCosignerKey(expr string, index uint32, change bool) (ecPoint, error) {
+	origin, suffix, err := parseKeyOrigin(expr)
+	if err != nil {
+		return ecPoint{}, err
+	}
+	return internalChildKey(origin.Xpub, suffix, index, change)
+}
+
+// internalChildKey derives the key at <xpub>/<change>/<index>, or below
+// the path suffix if one is given.
+func internalChildKey(xpub, suffix string, index uint32, change bool) (ecPoint, error) {
+	parent, err := parseBip32PubKey(xpub)
+	if err != nil {
+		return ecPoint{}, errorWithCode(errInvalidKey, "failed to parse xpub: %v", err)
+	}
+	path := []uint32{0, index}
+	if change {
+		path[0] = 1
+	}
+	if suffix != "" {
+		if path, err = suffixPath(suffix, index, change); err != nil {
+			return ecPoint{}, err
+		}
+	}
+	return parent.derivePoint(path)
+}
+
+func (p ecPoint) uncompressed() []byte {
+	out := make([]byte, 65)
+	out[0] = 4
+	p.x.FillBytes(out[1:33])
+	p.y.FillBytes(out[33:])
+	return out
+}
+
+func hash160(b []byte) []byte {
+	sha := sha256.Sum256(b)
+	h := ripemd160.New()
+	h.Write(sha[:])
+	return h.Sum(nil)
+}
+
+// internalPayToPubKey builds <pubkey> OP_CHECKSIG.
+func internalPayToPubKey(pubKey []byte) []byte {
+	return append(append([]byte{byte(len(pubKey))}, pubKey...), 0xac)
+}
+
+// internalSortedMulti builds OP_m <keys sorted per BIP-67> OP_n
+// OP_CHECKMULTISIG.
+func internalSortedMulti(keys []ecPoint, threshold int) []byte {
+	serialized := make([][]byte, len(keys))
+	for i, k := range keys {
+		serialized[i] = k.compressed()
+	}
+	sort.Slice(serialized, func(i, j int) bool { return bytes.Compare(serialized[i], serialized[j]) < 0 })
+
+	script := pushSmallInt(nil, threshold)
+	for _, k := range serialized {
+		script = append(append(script, byte(len(k))), k...)
+	}
+	script = pushSmallInt(script, len(keys))
+	return append(script, 0xae)
+}
+
+// pushSmallInt pushes n as OP_1..OP_16, or above that as a one-byte
+// minimal script number.
+func pushSmallInt(script []byte, n int) []byte {
+	if n >= 1 && n <= 16 {
+		return append(script, byte(0x50+n))
+	}
+	return append(script, 1, byte(n))
+}
+
+// taprootOutputKey tweaks an internal key with an empty script tree
+// (BIP-86) and returns the x-only output key.
+func taprootOutputKey(internal ecPoint) ([]byte, error) {
+	if internal.y.Bit(0) == 1 {
+		internal = ecPoint{internal.x, new(big.Int).Sub(secp256k1P, internal.y)}
+	}
+	x := internal.compressed()[1:]
+	t := new(big.Int).SetBytes(taggedHash("TapTweak", x))
+	if t.Cmp(secp256k1N) >= 0 {
+		return nil, fmt.Errorf("taproot tweak out of range")
+	}
+	output := ecAdd(internal, ecScalarBaseMult(t))
+	if output.infinity() {
+		return nil, fmt.Errorf("taproot output key is the point at infinity")
+	}
+	return output.compressed()[1:], nil
+}
+
+// taggedHash is the BIP-340 tagged hash.
+func taggedHash(tag string, msg []byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func base58CheckEncode(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	data = append(data, second[:4]...)
+
+	n := new(big.Int).SetBytes(data)
+	var out []byte
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, big.NewInt(58), mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// segwitEncode encodes a witness program as bech32 (version 0) or bech32m
+// (version 1 and up), per BIP-173 and BIP-350.
+func segwitEncode(hrp string, version byte, program []byte) (string, error) {
+	if len(program) < 2 || len(program) > 40 {
+		return "", fmt.Errorf("invalid witness program length %d", len(program))
+	}
+	data := append([]byte{version}, regroupBits(program)...)
+	constant := uint32(1)
+	if version > 0 {
+		constant = 0x2bc830a3
+	}
+
+	values := make([]byte, 0, len(hrp)*2+1+len(data)+6)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	values = append(values, data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ constant
+
+	out := []byte(hrp + "1")
+	for _, d := range data {
+		out = append(out, bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		out = append(out, bech32Charset[(polymod>>(5*(5-i)))&31])
+	}
+	return string(out), nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// regroupBits converts 8-bit bytes to padded 5-bit groups.
+func regroupBits(data []byte) []byte {
+	var out []byte
+	acc, bits := uint32(0), 0
+	for _, b := range data {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out = append(out, byte(acc>>bits)&31)
+		}
+		acc &= 1<<bits - 1
+	}
+	if bits > 0 {
+		out = append(out, byte(acc<<(5-bits))&31)
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes reported next to the message, for callers that branch on the
+// kind of failure rather than parse its text.
+const (
+	// errUncompressedKey: an uncompressed key was used with a script type
+	// that cannot spend to one (segwit) or has no defined form for one.
+	errUncompressedKey = "uncompressed_key"
+
+	// errInternal: the verifier hit a bug; the details are on stderr.
+	errInternal = "internal_error"
+
+	// errInvalidIndex: a derivation index is not a decimal integer in the
+	// non-hardened range 0 to 2^31-1.
+	errInvalidIndex = "invalid_index"
+
+	// errInvalidCount: an address count or range is not a positive integer.
+	errInvalidCount = "invalid_count"
+
+	// errInvalidThreshold: a multisig threshold is not between 1 and the
+	// number of keys.
+	errInvalidThreshold = "invalid_threshold"
+
+	// errInvalidBoolean: a boolean argument is not "true" or "false".
+	errInvalidBoolean = "invalid_boolean"
+
+	// errInvalidNetwork: the network is not "mainnet", "testnet", "liquid"
+	// or "liquidtestnet", or cannot use the requested script type.
+	errInvalidNetwork = "invalid_network"
+
+	// errInvalidKey: an extended public key could not be parsed.
+	errInvalidKey = "invalid_key"
+
+	// errInvalidDerivation: a key's path suffix does not end in its one
+	// wildcard, or a change address was asked of a key whose suffix fixes
+	// its path.
+	errInvalidDerivation = "invalid_derivation"
+
+	// errInvalidMnemonic: a BIP-39 mnemonic's checksum does not match its
+	// words.
+	errInvalidMnemonic = "invalid_mnemonic"
+
+	// errEngineMismatch: under --paranoid, btcsuite and the in-package
+	// BIP-32 engine derived different keys. No result is trusted.
+	errEngineMismatch = "engine_mismatch"
+
+	// errOffline: the command needs a chain backend, and network access is
+	// disabled by --offline or an offline build.
+	errOffline = "offline"
+
+	// errOnline: the command handles a master private key and refuses to
+	// run unless network access is disabled.
+	errOnline = "online"
+
+	// errHashMismatch: a batch results file does not match its recorded
+	// hash, or does not answer the given request file.
+	errHashMismatch = "hash_mismatch"
+
+	// errBackendUnavailable: the chain backend kept failing, and its retry
+	// budget is spent or its circuit breaker is open.
+	errBackendUnavailable = "backend_unavailable"
+
+	// errCertificateMismatch: the Electrum server's TLS certificate does
+	// not match the pin in the config.
+	errCertificateMismatch = "certificate_mismatch"
+
+	// errBackendDisagreement: the backends of the consensus backend gave
+	// different answers, and no answer has the quorum.
+	errBackendDisagreement = "backend_disagreement"
+
+	// errShuttingDown: watch is draining after SIGTERM and did not run the
+	// request. It can be retried against another or a restarted verifier.
+	errShuttingDown = "shutting_down"
+
+	// errUnknownWallet: a "wallet:" reference names no wallet registered
+	// for the tenant.
+	errUnknownWallet = "unknown_wallet"
+
+	// errDecryptionFailed: an encrypted wallet spec could not be decrypted
+	// with the --identity or passphrase given, or none was given.
+	errDecryptionFailed = "decryption_failed"
+
+	// errInvalidSignature: a signed file's minisign signature is missing,
+	// malformed, from another key or does not match the file, or a PSBT
+	// signature does not verify for the key and input it claims.
+	errInvalidSignature = "invalid_signature"
+)
+
+// codedError is an error carrying one of the error codes above.
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+func errorWithCode(code, format string, args ...interface{}) error {
+	return &codedError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the code of err, or "" if it has none.
+func errorCode(err error) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// estimate-size computes the size of a transaction from its shape alone:
+// how many inputs of a script type, m-of-n for multisig, and how many
+// outputs of another. It uses the same worst-case input sizes as
+// sweep-psbt and simulate-spend, so a fee budgeted from it covers the
+// transaction those commands would build.
+
+// maxMultisigKeys is the most keys OP_CHECKMULTISIG takes.
+const maxMultisigKeys = 20
+
+// maxRedeemScriptSize is the largest P2SH redeem script consensus allows,
+// which holds 15 compressed keys.
+const maxRedeemScriptSize = 520
+
+// maxStandardTxWeight is the heaviest transaction Bitcoin Core relays.
+const maxStandardTxWeight = 400_000
+
+// SizeEstimate is the estimated size of a transaction of a given shape.
+type SizeEstimate struct {
+	InputType  string `json:"input_type"`
+	Threshold  int    `json:"threshold,omitempty"`
+	Keys       int    `json:"keys,omitempty"`
+	Inputs     int    `json:"inputs"`
+	OutputType string `json:"output_type"`
+	Outputs    int    `json:"outputs"`
+	// InputWeight is the weight of one input, and InputWitness the witness
+	// bytes it includes.
+	InputWeight  int  `json:"input_weight"`
+	InputWitness int  `json:"input_witness"`
+	OutputSize   int  `json:"output_size"`
+	Segwit       bool `json:"segwit"`
+	Weight       int  `json:"weight"`
+	VSize        int  `json:"vsize"`
+	// Standard is false above the weight Core relays; such a transaction
+	// has to be split.
+	Standard bool `json:"standard"`
+	// FeeRate and Fee are set when a fee rate is given.
+	FeeRate float64 `json:"fee_rate,omitempty"`
+	Fee     int64   `json:"fee,omitempty"`
+}
+
+// sizeRequest is a parsed estimate-size command line.
+type sizeRequest struct {
+	inputType    string
+	inputs       int
+	outputType   string
+	outputs      int
+	threshold    int
+	keys         int
+	uncompressed bool
+	feeRate      int64 // sat/kvB; 0 for no fee
+}
+
+// estimateSize estimates the size of the transaction req describes.
+func estimateSize(req *sizeRequest) (*SizeEstimate, error) {
+	in, err := lookupScriptType(req.inputType)
+	if err != nil {
+		return nil, err
+	}
+	if in.multisig && req.keys == 0 {
+		return nil, fmt.Errorf("%s is multisig; give its policy with --threshold and --keys", req.inputType)
+	}
+	if in.multisig {
+		if err := checkMultisigShape(req.inputType, req.threshold, req.keys); err != nil {
+			return nil, err
+		}
+	} else if req.threshold != 0 || req.keys != 0 {
+		return nil, fmt.Errorf("%s is single-sig; --threshold and --keys are for multisig types", req.inputType)
+	}
+	if req.uncompressed && req.inputType != "legacy" {
+		return nil, errorWithCode(errUncompressedKey, "uncompressed keys are only estimated for legacy inputs")
+	}
+	size, err := scriptInputSize(req.inputType, req.threshold, req.keys, req.uncompressed, ecdsaSignatureSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Outputs of a multisig type are taken to have the inputs' policy, or
+	// 1-of-1 when the inputs are single-sig.
+	m, n := req.threshold, req.keys
+	out, err := lookupScriptType(req.outputType)
+	if err != nil {
+		return nil, err
+	}
+	if out.multisig {
+		if n == 0 {
+			m, n = 1, 1
+		}
+		if err := checkMultisigShape(req.outputType, m, n); err != nil {
+			return nil, err
+		}
+	}
+	script, err := placeholderScript(req.outputType, m, n)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := repeatInputSize(size, req.inputs)
+	outputs := make([][]byte, req.outputs)
+	for i := range outputs {
+		outputs[i] = script
+	}
+	weight := txWeight(inputs, outputs)
+	e := &SizeEstimate{
+		InputType:    req.inputType,
+		Threshold:    req.threshold,
+		Keys:         req.keys,
+		Inputs:       req.inputs,
+		OutputType:   req.outputType,
+		Outputs:      req.outputs,
+		InputWeight:  size.weight(),
+		InputWitness: size.witness,
+		OutputSize:   outputSize(script),
+		Segwit:       size.witness > 0,
+		Weight:       weight,
+		VSize:        vsize(weight),
+		Standard:     weight <= maxStandardTxWeight,
+	}
+	if req.feeRate != 0 {
+		e.FeeRate = float64(req.feeRate) / 1000
+		e.Fee = feeForWeight(req.feeRate, weight)
+	}
+	return e, nil
+}
+
+// checkMultisigShape checks an m-of-n policy against the limits of
+// scriptType.
+func checkMultisigShape(scriptType string, m, n int) error {
+	if n < 1 || n > maxMultisigKeys {
+		return errorWithCode(errInvalidCount, "invalid key count %d (want 1 to %d)", n, maxMultisigKeys)
+	}
+	if err := checkThreshold(m, n); err != nil {
+		return err
+	}
+	switch scriptType {
+	case "bare_multisig":
+		if n > maxBareMultisigKeys {
+			return fmt.Errorf("bare multisig is limited to %d keys, got %d", maxBareMultisigKeys, n)
+		}
+	case "p2sh":
+		if multisigScriptSize(m, n) > maxRedeemScriptSize {
+			return fmt.Errorf("a %d-key redeem script exceeds the %d-byte P2SH limit", n, maxRedeemScriptSize)
+		}
+	}
+	return nil
+}
+
+// placeholderScript is an output script of scriptType with zeroed hashes
+// and keys: the size of a real one, for estimates.
+func placeholderScript(scriptType string, m, n int) ([]byte, error) {
+	return buildScript(func(b *txscript.ScriptBuilder) {
+		switch scriptType {
+		case "legacy":
+			b.AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).AddData(make([]byte, 20)).AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG)
+		case "nested_segwit", "p2sh", "p2sh_p2wsh":
+			b.AddOp(txscript.OP_HASH160).AddData(make([]byte, 20)).AddOp(txscript.OP_EQUAL)
+		case "native_segwit":
+			b.AddOp(txscript.OP_0).AddData(make([]byte, 20))
+		case "p2wsh":
+			b.AddOp(txscript.OP_0).AddData(make([]byte, 32))
+		case "taproot":
+			b.AddOp(txscript.OP_1).AddData(make([]byte, 32))
+		case "p2pk":
+			b.AddData(make([]byte, 33)).AddOp(txscript.OP_CHECKSIG)
+		case "p2pk_uncompressed":
+			b.AddData(make([]byte, 65)).AddOp(txscript.OP_CHECKSIG)
+		case "bare_multisig":
+			b.AddInt64(int64(m))
+			for i := 0; i < n; i++ {
+				b.AddData(make([]byte, 33))
+			}
+			b.AddInt64(int64(n)).AddOp(txscript.OP_CHECKMULTISIG)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Fee rates can come from the configured backend instead of the command
+// line: Core's estimatesmartfee, Electrum's blockchain.estimatefee, or an
+// Esplora server's /fee-estimates, which mempool.space also serves. The
+// conservative and economical modes are Core's: conservative also weighs
+// longer history and so reacts less to a brief drop in fees. Other
+// backends have one estimate and ignore the mode. A consensus backend takes
+// the highest of its members' estimates.
+
+const (
+	defaultFeeTarget = 6
+	defaultFeeMode   = "conservative"
+
+	// maxFeeTarget is the longest confirmation target Core estimates for.
+	maxFeeTarget = 1008
+
+	// minRelayFeeRate is Bitcoin Core's default minimum relay fee, in
+	// sat/kvB; estimates below it are raised to it.
+	minRelayFeeRate = 1000
+)
+
+var feeModes = []string{"conservative", "economical"}
+
+// FeeRateEstimate is a fee rate estimated by the configured backend.
+type FeeRateEstimate struct {
+	Network string `json:"network"`
+	Backend string `json:"backend"`
+	Target  int    `json:"target"`
+	Mode    string `json:"mode"`
+	// FeeRate is in sat/vB.
+	FeeRate float64 `json:"fee_rate"`
+	// Floored is set when the backend's estimate was below the minimum
+	// relay fee and was raised to it.
+	Floored bool `json:"floored,omitempty"`
+}
+
+// estimateFeeRate asks backend for the fee rate to confirm within target
+// blocks, and returns it both as a report and in sat/kvB.
+func estimateFeeRate(backend ChainBackend, network string, target int, mode string) (*FeeRateEstimate, int64, error) {
+	rate, err := backendFeeRate(backend, target, mode)
+	if errors.Is(err, errNoFeeEstimates) {
+		return nil, 0, fmt.Errorf("%s cannot estimate fees; give the fee rate instead", backend.Name())
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if rate > maxFeeRate {
+		return nil, 0, fmt.Errorf("%s estimated %.3f sat/vB, above the %d sat/vB limit", backend.Name(), float64(rate)/1000, maxFeeRate/1000)
+	}
+	estimate := &FeeRateEstimate{Network: network, Backend: backend.Name(), Target: target, Mode: mode}
+	if rate < minRelayFeeRate {
+		rate = minRelayFeeRate
+		estimate.Floored = true
+	}
+	estimate.FeeRate = float64(rate) / 1000
+	return estimate, rate, nil
+}
+
+// feeChoice is a fee rate given on the command line, or the confirmation
+// target and mode to estimate one for.
+type feeChoice struct {
+	rate   int64 // sat/kvB; 0 estimates it
+	target int
+	mode   string
+}
+
+// parseFeeFlags handles "--feerate <sat/vB> | --fee-target <blocks>
+// [--fee-mode conservative|economical]".
+func parseFeeFlags(flags map[string]string) (feeChoice, error) {
+	var c feeChoice
+	v, ok := flags["feerate"]
+	if !ok {
+		var err error
+		c.target, c.mode, err = feeEstimateFlags(flags, "fee-target", "fee-mode")
+		return c, err
+	}
+	_, target := flags["fee-target"]
+	_, mode := flags["fee-mode"]
+	if target || mode {
+		return c, fmt.Errorf("--feerate cannot be combined with --fee-target or --fee-mode")
+	}
+	rate, err := parseFeeRate(v)
+	c.rate = rate
+	return c, err
+}
+
+// resolve returns the fee rate chosen, in sat/kvB, estimating it through
+// backend if it was not given, in which case the estimate is returned too.
+func (c feeChoice) resolve(backend ChainBackend, network string) (int64, *FeeRateEstimate, error) {
+	if c.rate != 0 {
+		return c.rate, nil, nil
+	}
+	estimate, rate, err := estimateFeeRate(backend, network, c.target, c.mode)
+	return rate, estimate, err
+}
+
+// feeEstimateFlags parses the confirmation target and mode flags, which
+// default to 6 blocks, conservative.
+func feeEstimateFlags(flags map[string]string, targetFlag, modeFlag string) (int, string, error) {
+	target, mode := defaultFeeTarget, defaultFeeMode
+	if v, ok := flags[targetFlag]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFeeTarget {
+			return 0, "", errorWithCode(errInvalidCount, "invalid confirmation target: %q (want 1 to %d blocks)", v, maxFeeTarget)
+		}
+		target = n
+	}
+	if v, ok := flags[modeFlag]; ok {
+		mode = ""
+		for _, m := range feeModes {
+			if v == m {
+				mode = m
+			}
+		}
+		if mode == "" {
+			return 0, "", fmt.Errorf("invalid fee estimate mode: %q (want conservative or economical)", v)
+		}
+	}
+	return target, mode, nil
+}
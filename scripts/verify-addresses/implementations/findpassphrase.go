@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// A forgotten BIP-39 passphrase is usually one of a handful the owner
+// remembers half-right. find-passphrase reads the mnemonic through
+// --key-env or --key-fd and tries each line of a candidates file as the
+// passphrase, deriving the address at --path until one reproduces the
+// known address. It reports the matching line, and prints the passphrase
+// itself only with --reveal. Every line is a candidate exactly as written,
+// spaces included; an empty line is the empty passphrase. It refuses to run
+// unless offline.
+
+// FindPassphraseReport is the outcome of trying a candidates file.
+type FindPassphraseReport struct {
+	Address    string `json:"address"`
+	Path       string `json:"path"`
+	ScriptType string `json:"script_type"`
+	Network    string `json:"network"`
+	Candidates int    `json:"candidates"`
+	Tried      int    `json:"tried"`
+	// Skipped lists the lines not tried: BIP-39 passphrases are
+	// normalized to NFKD, which the verifier does not implement, so
+	// non-ASCII candidates cannot be tried faithfully.
+	Skipped []int `json:"skipped,omitempty"`
+	Matched bool  `json:"matched"`
+	// Line is the line of the candidates file that matched, from 1.
+	Line int `json:"line,omitempty"`
+	// Passphrase is the matching candidate, under --reveal.
+	Passphrase  *string `json:"passphrase,omitempty"`
+	Fingerprint string  `json:"fingerprint,omitempty"`
+}
+
+// passphraseSearch is a parsed find-passphrase command line.
+type passphraseSearch struct {
+	address    string
+	path       []uint32
+	scriptType *scriptType
+	network    string
+	reveal     bool
+}
+
+// findPassphrase tries each candidate as the passphrase of mnemonic until
+// one derives search's address.
+func findPassphrase(mnemonic string, candidates []string, search *passphraseSearch) (*FindPassphraseReport, error) {
+	words, err := normalizeMnemonic(mnemonic)
+	if err != nil {
+		return nil, errorWithCode(errInvalidMnemonic, "%v", err)
+	}
+	entropy, err := mnemonicToEntropy(words)
+	if err != nil {
+		return nil, err
+	}
+	clear(entropy)
+	sentence := strings.Join(words, " ")
+
+	report := &FindPassphraseReport{
+		Address:    search.address,
+		Path:       formatPath(search.path),
+		ScriptType: search.scriptType.name,
+		Network:    search.network,
+		Candidates: len(candidates),
+	}
+	net := getNetwork(search.network)
+	for i, candidate := range candidates {
+		if !isASCII(candidate) {
+			report.Skipped = append(report.Skipped, i+1)
+			continue
+		}
+		report.Tried++
+		seed := mnemonicSeed(sentence, candidate)
+		master, err := hdkeychain.NewMaster(seed, net)
+		clear(seed)
+		if err != nil {
+			return nil, err
+		}
+		address, fingerprint, err := passphraseAddress(master, search)
+		master.Zero()
+		if err != nil {
+			return nil, err
+		}
+		if addressesMatch(address, search.address) {
+			report.Matched = true
+			report.Line = i + 1
+			report.Fingerprint = fingerprint
+			if search.reveal {
+				report.Passphrase = &candidate
+			}
+			break
+		}
+	}
+	if len(report.Skipped) > 0 {
+		diagnostic("warning: skipped %d candidates with non-ASCII characters", len(report.Skipped))
+	}
+	return report, nil
+}
+
+// passphraseAddress derives the address and master fingerprint of one
+// candidate's master key.
+func passphraseAddress(master *hdkeychain.ExtendedKey, search *passphraseSearch) (string, string, error) {
+	pub, err := master.ECPubKey()
+	if err != nil {
+		return "", "", err
+	}
+	key, err := deriveKeyPath(master, search.path)
+	if err != nil {
+		return "", "", err
+	}
+	defer key.Zero()
+	child, err := key.ECPubKey()
+	if err != nil {
+		return "", "", err
+	}
+	address, err := search.scriptType.address([]*btcec.PublicKey{child}, 0, getNetwork(search.network))
+	if err != nil {
+		return "", "", err
+	}
+	return address, hex.EncodeToString(btcutil.Hash160(pub.SerializeCompressed())[:4]), nil
+}
+
+// isASCII reports whether s is plain ASCII.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCandidates splits a candidates file into lines, keeping each line
+// as written apart from its line ending.
+func parseCandidates(data string) ([]string, error) {
+	if data == "" {
+		return nil, fmt.Errorf("the candidates file is empty")
+	}
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines, nil
+}
+
+// searchScriptType returns the script type of --type, or else the one the
+// path's purpose implies.
+func searchScriptType(name string, path []uint32) (*scriptType, error) {
+	if name == "" {
+		if len(path) == 0 || path[0] < hdkeychain.HardenedKeyStart || purposeScriptTypes[path[0]-hdkeychain.HardenedKeyStart] == "" {
+			return nil, fmt.Errorf("the path does not start with a BIP-44/49/84/86 purpose; give --type")
+		}
+		name = purposeScriptTypes[path[0]-hdkeychain.HardenedKeyStart]
+	}
+	t, err := lookupScriptType(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.multisig {
+		return nil, fmt.Errorf("find-passphrase derives single-sig addresses; unsupported script type: %s", name)
+	}
+	return t, nil
+}
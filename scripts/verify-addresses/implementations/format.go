@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// outputFormats are the encodings --format selects between.
+//
+//	json      one JSON document per run (the default)
+//	ndjson    batch and scan commands stream each record on its own line
+//	          as it is computed, then the report itself as the last line
+//	proto     one varint length-prefixed protobuf Response per document,
+//	          in the schema printed by proto-schema
+//	cbor      one CBOR data item per document, with the same structure as
+//	          the JSON output
+//	html      a standalone HTML page of the report command's audit report
+//	markdown  GitHub-flavoured Markdown tables, for wikis and tickets
+var outputFormats = []string{"json", "ndjson", "proto", "cbor", "html", "markdown"}
+
+// stdout receives every result document. run points it at a buffer for
+// each request of a batch.
+var stdout io.Writer = os.Stdout
+
+// streamLine is one line of ndjson output: a record of the given type, or
+// the final "result".
+type streamLine struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// streaming reports whether records are written as they are computed. The
+// reports of streaming runs leave those records out, so memory stays flat
+// however large the range.
+func streaming() bool {
+	return options.format == "ndjson"
+}
+
+// streamRecord writes one record of a batch result. It must only be called
+// when streaming.
+func streamRecord(recordType string, v interface{}) {
+	json.NewEncoder(stdout).Encode(streamLine{Type: recordType, Data: v})
+}
+
+// writeCBOR writes v as CBOR, going through its JSON form so field names
+// and omitted fields are exactly those of the JSON output.
+func writeCBOR(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	out, err := encodeCBOR(nil, doc)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+func isOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// xoshiro256 is the xoshiro256** PRNG used by the UR fountain code
+// (BCR-2020-005) to choose which fragments a mixed part combines.
+type xoshiro256 struct {
+	s [4]uint64
+}
+
+func newXoshiro256(seed []byte) *xoshiro256 {
+	digest := sha256.Sum256(seed)
+	x := &xoshiro256{}
+	for i := range x.s {
+		x.s[i] = binary.BigEndian.Uint64(digest[i*8:])
+	}
+	return x
+}
+
+func (x *xoshiro256) next() uint64 {
+	s := &x.s
+	result := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return result
+}
+
+func (x *xoshiro256) nextDouble() float64 {
+	return float64(x.next()) / 18446744073709551616.0
+}
+
+// nextInt returns an integer in [low, high].
+func (x *xoshiro256) nextInt(low, high int) int {
+	return int(x.nextDouble()*float64(high-low+1)) + low
+}
+
+// randomSampler is Walker's alias method over a discrete distribution.
+type randomSampler struct {
+	probs   []float64
+	aliases []int
+}
+
+func newRandomSampler(weights []float64) *randomSampler {
+	n := len(weights)
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	p := make([]float64, n)
+	for i, w := range weights {
+		p[i] = w * float64(n) / sum
+	}
+
+	var small, large []int
+	for j := n - 1; j >= 0; j-- {
+		if p[j] < 1 {
+			small = append(small, j)
+		} else {
+			large = append(large, j)
+		}
+	}
+
+	rs := &randomSampler{probs: make([]float64, n), aliases: make([]int, n)}
+	for len(small) > 0 && len(large) > 0 {
+		a := small[len(small)-1]
+		small = small[:len(small)-1]
+		g := large[len(large)-1]
+		large = large[:len(large)-1]
+		rs.probs[a] = p[a]
+		rs.aliases[a] = g
+		p[g] += p[a] - 1
+		if p[g] < 1 {
+			small = append(small, g)
+		} else {
+			large = append(large, g)
+		}
+	}
+	for _, g := range large {
+		rs.probs[g] = 1
+	}
+	for _, a := range small {
+		rs.probs[a] = 1
+	}
+	return rs
+}
+
+func (rs *randomSampler) next(rng *xoshiro256) int {
+	r1, r2 := rng.nextDouble(), rng.nextDouble()
+	i := int(float64(len(rs.probs)) * r1)
+	if r2 < rs.probs[i] {
+		return i
+	}
+	return rs.aliases[i]
+}
+
+// chooseFragments returns the fragment indexes XOR-ed into part seqNum.
+// Parts 1..seqLen are the plain fragments in order.
+func chooseFragments(seqNum, seqLen uint64, checksum uint32) []int {
+	if seqNum <= seqLen {
+		return []int{int(seqNum - 1)}
+	}
+
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint32(seed, uint32(seqNum))
+	binary.BigEndian.PutUint32(seed[4:], checksum)
+	rng := newXoshiro256(seed)
+
+	weights := make([]float64, seqLen)
+	for i := range weights {
+		weights[i] = 1 / float64(i+1)
+	}
+	degree := newRandomSampler(weights).next(rng) + 1
+
+	remaining := make([]int, seqLen)
+	for i := range remaining {
+		remaining[i] = i
+	}
+	shuffled := make([]int, 0, seqLen)
+	for len(remaining) > 0 {
+		i := rng.nextInt(0, len(remaining)-1)
+		shuffled = append(shuffled, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return shuffled[:degree]
+}
+
+// fountainPart is a received part reduced to the fragments it still mixes.
+type fountainPart struct {
+	indexes map[int]bool
+	data    []byte
+}
+
+func newFountainPart(indexes []int, data []byte) *fountainPart {
+	p := &fountainPart{indexes: make(map[int]bool, len(indexes)), data: append([]byte(nil), data...)}
+	for _, i := range indexes {
+		p.indexes[i] = true
+	}
+	return p
+}
+
+// reduce XORs a known simple fragment out of a mixed part.
+func (p *fountainPart) reduce(index int, fragment []byte) {
+	if !p.indexes[index] {
+		return
+	}
+	delete(p.indexes, index)
+	for i := range p.data[:len(fragment)] {
+		p.data[i] ^= fragment[i]
+	}
+}
+
+func (p *fountainPart) single() (int, bool) {
+	if len(p.indexes) != 1 {
+		return 0, false
+	}
+	for i := range p.indexes {
+		return i, true
+	}
+	return 0, false
+}
+
+// fountainDecoder recovers all plain fragments from any sufficient mix of
+// plain and mixed parts.
+type fountainDecoder struct {
+	simple map[int][]byte
+	mixed  []*fountainPart
+}
+
+func newFountainDecoder() *fountainDecoder {
+	return &fountainDecoder{simple: make(map[int][]byte)}
+}
+
+func (d *fountainDecoder) add(f *urFragment) {
+	part := newFountainPart(chooseFragments(f.SeqNum, f.SeqLen, f.Checksum), f.Data)
+	for i, fragment := range d.simple {
+		part.reduce(i, fragment)
+	}
+
+	queue := []*fountainPart{part}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		index, ok := p.single()
+		if !ok {
+			if len(p.indexes) > 0 {
+				d.mixed = append(d.mixed, p)
+			}
+			continue
+		}
+		if _, known := d.simple[index]; known {
+			continue
+		}
+		d.simple[index] = p.data
+
+		// A new simple fragment may reduce stored mixed parts to simple ones.
+		var stillMixed []*fountainPart
+		for _, m := range d.mixed {
+			m.reduce(index, p.data)
+			if _, single := m.single(); single {
+				queue = append(queue, m)
+			} else if len(m.indexes) > 0 {
+				stillMixed = append(stillMixed, m)
+			}
+		}
+		d.mixed = stillMixed
+	}
+}
+
+// message joins the fragments once all seqLen of them are known.
+func (d *fountainDecoder) message(seqLen uint64) []byte {
+	if uint64(len(d.simple)) < seqLen {
+		return nil
+	}
+	var message []byte
+	for i := 0; i < int(seqLen); i++ {
+		message = append(message, d.simple[i]...)
+	}
+	return message
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// FrostGroup is the public output of a FROST distributed key generation:
+// the group key every signer agreed on and, optionally, each participant's
+// public verification share. It is experimental; there is no standard
+// export format for FROST groups yet.
+type FrostGroup struct {
+	Network        string `json:"network"`
+	GroupPublicKey string `json:"group_public_key"`
+	// ChainCode makes the group key an account-level extended key, so
+	// addresses are derived at /<chain>/<index> as under BIP-86. Without
+	// one, the group key is the single taproot internal key.
+	ChainCode          string            `json:"chain_code,omitempty"`
+	Threshold          int               `json:"threshold,omitempty"`
+	VerificationShares []FrostShare      `json:"verification_shares,omitempty"`
+	Expected           []ExpectedAddress `json:"expected,omitempty"`
+
+	groupKey *btcec.PublicKey
+	xpub     *hdkeychain.ExtendedKey
+}
+
+// FrostShare is one participant's public verification share.
+type FrostShare struct {
+	Identifier uint32 `json:"identifier"`
+	PublicKey  string `json:"public_key"`
+}
+
+// FrostReport is what frost-addresses found about a group.
+type FrostReport struct {
+	Experimental   bool   `json:"experimental"`
+	Network        string `json:"network"`
+	GroupPublicKey string `json:"group_public_key"`
+	// Address is the BIP-86 key-path address of the group key itself.
+	Address   string           `json:"address"`
+	Threshold int              `json:"threshold,omitempty"`
+	Receive   []DerivedAddress `json:"receive,omitempty"`
+	Change    []DerivedAddress `json:"change,omitempty"`
+	Checks    []AddressCheck   `json:"checks,omitempty"`
+	// SharesConsistent is set when the verification shares lie on a single
+	// polynomial of degree threshold-1 whose constant term is the group key.
+	SharesConsistent *bool  `json:"shares_consistent,omitempty"`
+	ShareError       string `json:"share_error,omitempty"`
+	Verified         bool   `json:"verified"`
+}
+
+func loadFrostGroup(arg string) (*FrostGroup, error) {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if os.IsNotExist(err) {
+			data, err = []byte(arg), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FROST group: %v", err)
+	}
+
+	var g FrostGroup
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("failed to parse FROST group: %v", err)
+	}
+	if g.Network != "mainnet" && g.Network != "testnet" {
+		return nil, fmt.Errorf("unsupported network: %q", g.Network)
+	}
+	if g.groupKey, err = parseFrostKey(g.GroupPublicKey); err != nil {
+		return nil, fmt.Errorf("invalid group public key: %v", err)
+	}
+	if g.ChainCode != "" {
+		chainCode, err := hex.DecodeString(g.ChainCode)
+		if err != nil || len(chainCode) != 32 {
+			return nil, fmt.Errorf("invalid chain code: want 32 bytes of hex")
+		}
+		net := getNetwork(g.Network)
+		g.xpub = hdkeychain.NewExtendedKey(net.HDPublicKeyID[:], g.groupKey.SerializeCompressed(), chainCode, []byte{0, 0, 0, 0}, 0, 0, false)
+	} else if len(g.Expected) > 0 {
+		return nil, fmt.Errorf("expected addresses need a chain code to derive")
+	}
+	if len(g.VerificationShares) > 0 && (g.Threshold < 1 || g.Threshold > len(g.VerificationShares)) {
+		return nil, fmt.Errorf("threshold %d is invalid for %d verification shares", g.Threshold, len(g.VerificationShares))
+	}
+	return &g, nil
+}
+
+// parseFrostKey accepts a compressed or x-only (even Y) public key.
+func parseFrostKey(s string) (*btcec.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 32 {
+		return schnorr.ParsePubKey(b)
+	}
+	if len(b) != 33 {
+		return nil, fmt.Errorf("want a 33-byte compressed or 32-byte x-only key, got %d bytes", len(b))
+	}
+	return btcec.ParsePubKey(b)
+}
+
+// frostAddress is the BIP-86 key-path address of an internal key.
+func frostAddress(key *btcec.PublicKey, network string) (string, error) {
+	outputKey := txscript.ComputeTaprootKeyNoScript(key)
+	addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), getNetwork(network))
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+func (g *FrostGroup) deriveAddress(change bool, index uint32) (string, error) {
+	key, err := deriveChainIndex(g.xpub, index, change)
+	if err != nil {
+		return "", err
+	}
+	return frostAddress(key, g.Network)
+}
+
+// checkShares interpolates the group key from the first threshold shares
+// and checks that every other share is the same polynomial evaluated at its
+// identifier, which holds only if the DKG gave everyone consistent shares.
+func (g *FrostGroup) checkShares() error {
+	shares := make(map[uint32]*btcec.PublicKey, len(g.VerificationShares))
+	ids := make([]uint32, 0, len(g.VerificationShares))
+	for _, s := range g.VerificationShares {
+		if s.Identifier == 0 {
+			return fmt.Errorf("participant identifier must not be 0")
+		}
+		if _, dup := shares[s.Identifier]; dup {
+			return fmt.Errorf("duplicate participant identifier %d", s.Identifier)
+		}
+		key, err := parseFrostKey(s.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid verification share %d: %v", s.Identifier, err)
+		}
+		shares[s.Identifier] = key
+		ids = append(ids, s.Identifier)
+	}
+
+	basis := ids[:g.Threshold]
+	group := interpolateShares(basis, shares, 0)
+	if !group.IsEqual(g.groupKey) && !xOnlyEqual(group, g.groupKey, len(g.GroupPublicKey) == 64) {
+		return fmt.Errorf("shares %v interpolate to %x, not the group key", basis, group.SerializeCompressed())
+	}
+	for _, id := range ids[g.Threshold:] {
+		if !interpolateShares(basis, shares, id).IsEqual(shares[id]) {
+			return fmt.Errorf("verification share %d is not on the group polynomial", id)
+		}
+	}
+	return nil
+}
+
+// xOnlyEqual compares keys by X coordinate when the group key was given
+// x-only, since the DKG's group key may have odd Y.
+func xOnlyEqual(a, b *btcec.PublicKey, xOnly bool) bool {
+	return xOnly && a.X().Cmp(b.X()) == 0
+}
+
+// interpolateShares evaluates, in the exponent, the polynomial through the
+// basis shares at x using Lagrange coefficients.
+func interpolateShares(basis []uint32, shares map[uint32]*btcec.PublicKey, x uint32) *btcec.PublicKey {
+	var xs btcec.ModNScalar
+	xs.SetInt(x)
+
+	var sum btcec.JacobianPoint
+	for _, i := range basis {
+		var num, den, xi btcec.ModNScalar
+		num.SetInt(1)
+		den.SetInt(1)
+		xi.SetInt(i)
+		for _, j := range basis {
+			if j == i {
+				continue
+			}
+			var xj, diff btcec.ModNScalar
+			xj.SetInt(j)
+			// num *= (x - j), den *= (i - j)
+			diff.NegateVal(&xj).Add(&xs)
+			num.Mul(&diff)
+			diff.NegateVal(&xj).Add(&xi)
+			den.Mul(&diff)
+		}
+		num.Mul(den.InverseNonConst())
+
+		var point, term btcec.JacobianPoint
+		shares[i].AsJacobian(&point)
+		btcec.ScalarMultNonConst(&num, &point, &term)
+		btcec.AddNonConst(&sum, &term, &sum)
+	}
+	sum.ToAffine()
+	return btcec.NewPublicKey(&sum.X, &sum.Y)
+}
+
+// frostAddresses derives the group's addresses and checks the DKG output
+// and any expected addresses against them.
+func frostAddresses(g *FrostGroup, count int) (*FrostReport, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("invalid count: %d", count)
+	}
+	if err := requireBtcsuiteEngine("FROST groups"); err != nil {
+		return nil, err
+	}
+	address, err := frostAddress(g.groupKey, g.Network)
+	if err != nil {
+		return nil, err
+	}
+	report := &FrostReport{
+		Experimental:   true,
+		Network:        g.Network,
+		GroupPublicKey: hex.EncodeToString(g.groupKey.SerializeCompressed()),
+		Address:        address,
+		Threshold:      g.Threshold,
+		Verified:       true,
+	}
+
+	if len(g.VerificationShares) > 0 {
+		err := g.checkShares()
+		consistent := err == nil
+		report.SharesConsistent = &consistent
+		if err != nil {
+			report.ShareError = err.Error()
+			report.Verified = false
+		}
+	}
+
+	if g.xpub == nil {
+		return report, nil
+	}
+	report.Receive = []DerivedAddress{}
+	report.Change = []DerivedAddress{}
+	for i := 0; i < count; i++ {
+		for _, change := range []bool{false, true} {
+			address, err := g.deriveAddress(change, uint32(i))
+			if err != nil {
+				return nil, err
+			}
+			derived := DerivedAddress{Index: uint32(i), Address: address}
+			if change {
+				report.Change = append(report.Change, derived)
+			} else {
+				report.Receive = append(report.Receive, derived)
+			}
+		}
+	}
+	for _, expected := range g.Expected {
+		derived, err := g.deriveAddress(expected.Change, expected.Index)
+		if err != nil {
+			return nil, err
+		}
+		match := derived == expected.Address
+		report.Checks = append(report.Checks, AddressCheck{
+			Change:   expected.Change,
+			Index:    expected.Index,
+			Expected: expected.Address,
+			Derived:  derived,
+			Match:    match,
+			Hint:     mismatchHint(match, expected.Address),
+		})
+		report.Verified = report.Verified && match
+	}
+	return report, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// globalOptions holds flags that apply to every command. They may appear
+// anywhere on the command line, before or after the command name.
+type globalOptions struct {
+	configPath string
+	storePath  string
+	verbose    bool
+	format     string
+	// porcelain guarantees stdout holds exactly one result document;
+	// everything else goes to stderr.
+	porcelain bool
+	// paranoid cross-checks every derivation against the in-package
+	// BIP-32 engine.
+	paranoid bool
+	// engine names the address engine that derives results.
+	engine string
+	// offline fails every command that needs a chain backend.
+	offline bool
+	// proxy is a socks5:// URL every backend connection goes through.
+	proxy string
+	// cache reads and writes wallet addresses in the store's derivation
+	// cache.
+	cache bool
+	// trace is the OTLP/HTTP collector spans are exported to.
+	trace string
+	// auditLog is the file every batch request is appended to.
+	auditLog string
+	// tenant scopes the wallet registry.
+	tenant string
+	// identity is the age identity file encrypted specs are decrypted
+	// with; passphraseEnv and passphraseFD say where the passphrase of
+	// passphrase-encrypted specs is read from.
+	identity      string
+	passphraseEnv string
+	passphraseFD  string
+	// signKey is the ed25519 key file result documents are signed with.
+	signKey string
+}
+
+var options globalOptions
+
+// globalFlags maps each global flag to its handler: set for flags taking a
+// value, boolean for switches.
+var globalFlags = map[string]struct {
+	set     func(value string)
+	boolean func()
+}{
+	"config":    {set: func(v string) { options.configPath = v }},
+	"store":     {set: func(v string) { options.storePath = v }},
+	"verbose":   {boolean: func() { options.verbose = true }},
+	"format":    {set: func(v string) { options.format = v }},
+	"porcelain": {boolean: func() { options.porcelain = true }},
+	"quiet":     {boolean: func() { options.porcelain = true }},
+	"paranoid":  {boolean: func() { options.paranoid = true }},
+	"engine":    {set: func(v string) { options.engine = v }},
+	"offline":   {boolean: func() { options.offline = true }},
+	"proxy":     {set: func(v string) { options.proxy = v }},
+	"cache":     {boolean: func() { options.cache = true }},
+	"trace":     {set: func(v string) { options.trace = v }},
+	"audit-log": {set: func(v string) { options.auditLog = v }},
+	"tenant":    {set: func(v string) { options.tenant = v }},
+	"identity":  {set: func(v string) { options.identity = v }},
+	"sign-key":  {set: func(v string) { options.signKey = v }},
+
+	"passphrase-env": {set: func(v string) { options.passphraseEnv = v }},
+	"passphrase-fd":  {set: func(v string) { options.passphraseFD = v }},
+}
+
+// extractGlobalFlags removes global flags ("--name value" or "--name=value")
+// from args and applies them to options.
+func extractGlobalFlags(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		flag, ok := globalFlags[name]
+		if !ok {
+			rest = append(rest, arg)
+			continue
+		}
+		if flag.boolean != nil {
+			if hasValue {
+				return nil, fmt.Errorf("flag --%s takes no value", name)
+			}
+			flag.boolean()
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		flag.set(value)
+	}
+	if options.format == "" {
+		options.format = "json"
+	}
+	if !isOutputFormat(options.format) {
+		return nil, fmt.Errorf("unknown output format: %s (want %s)", options.format, strings.Join(outputFormats, ", "))
+	}
+	if options.engine == "" {
+		options.engine = addressEngines[0].name
+	}
+	if _, err := findEngine(options.engine); err != nil {
+		return nil, err
+	}
+	if options.proxy != "" {
+		if _, err := parseProxy(options.proxy); err != nil {
+			return nil, err
+		}
+	}
+	if options.cache && options.storePath == "" {
+		return nil, fmt.Errorf("--cache keeps the derivation cache in the wallet store and needs --store")
+	}
+	if options.trace != "" {
+		if err := checkTraceEndpoint(options.trace); err != nil {
+			return nil, err
+		}
+	}
+	if options.tenant != "" {
+		if err := checkRegistryName("tenant", options.tenant); err != nil {
+			return nil, err
+		}
+	}
+	if options.signKey != "" {
+		if options.format == "proto" || options.format == "html" || options.format == "markdown" {
+			return nil, fmt.Errorf("--sign-key embeds the signature in the result and cannot sign --format %s", options.format)
+		}
+		key, err := loadSigningKey(options.signKey)
+		if err != nil {
+			return nil, err
+		}
+		signingKey = key
+	}
+	if options.porcelain && streaming() {
+		return nil, fmt.Errorf("--porcelain writes a single document and cannot stream --format %s", options.format)
+	}
+	return rest, nil
+}
@@ -5,121 +5,406 @@
 //
 // Usage:
 //
-//	go run go-verify.go single <xpub> <index> <script_type> <change> <network>
-//	go run go-verify.go multi <xpubs_json> <threshold> <index> <script_type> <change> <network>
-//	go run go-verify.go check
+//	go run . derive --xpub <xpub>|--key-env <name>|--key-fd <n> --index <n> --type <script_type> [--network <network>] [--change] [--uncompressed]
+//	go run . derive --multisig <xpubs_json> --threshold <m> --index <n> --type <script_type> [--network <network>] [--change]
+//	go run . single <xpub> <index> <script_type> <change> <network> [--uncompressed]
+//	go run . multi <xpubs_json> <threshold> <index> <script_type> <change> <network>
+//	go run . derive-hardened --key-env <name>|--key-fd <n> [count]
+//	go run . bip85 --key-env <name>|--key-fd <n> --app bip39|xprv|wif|hex --index <n> [--words 12|18|24] [--bytes <n>] [--type <script_type>] [--account <n>] [--reveal]
+//	go run . mnemonic --key-env <name>|--key-fd <n> [--bip39-passphrase-env <name>|--bip39-passphrase-fd <n>] [--type <script_type>] [--path <path>] [--network <network>] [count]
+//	go run . seed-xor --key-env <name>|--key-fd <n> [--bip39-passphrase-env <name>|--bip39-passphrase-fd <n>] [--type <script_type>] [--path <path>] [--network <network>] [count]
+//	go run . find-passphrase --key-env <name>|--key-fd <n> --candidates <file> --address <address> --path <path> [--type <script_type>] [--network <network>] [--reveal]
+//	go run . slip39 --key-env <name>|--key-fd <n> [--slip39-passphrase-env <name>|--slip39-passphrase-fd <n>] [--type <script_type>] [--path <path>] [--network <network>] [count]
+//	go run . verify-wallet <wallet_spec> [count]
+//	go run . export-bundle <wallet_spec> [count]
+//	go run . report <wallet_spec> [count]
+//	go run . diff-report <old_report> <new_report>
+//	go run . encrypt-spec <wallet_spec> [--recipient <recipients>]
+//	go run . verify-attestation <wallet_spec> <attestation_file> [signature_file]
+//	go run . next-address <wallet_spec> [--gap <n>]
+//	go run . balance <wallet_spec> [--range <start-end>] [--gap <n>]
+//	go run . utxos <wallet_spec> [--range <start-end>] [--gap <n>]
+//	go run . dust-report <wallet_spec> [--feerates <sat/vB,...>] [--range <start-end>] [--gap <n>]
+//	go run . monitor <wallet_spec> [--range <start-end>] [--gap <n>] [--interval <seconds>] [--events <file>] [--state <file>] [--confirmations <n>] [--thresholds <n,...>] [--once]
+//	go run . provision-core <wallet_spec> [wallet_name] [--range <n>]
+//	go run . verify-node <wallet_spec> [count]
+//	go run . backend-check [<backend>...] [--network <name>]
+//	go run . label <wallet_spec> [<address> <label>]
+//	go run . register-wallet <name> <wallet_spec> [--keychain]
+//	go run . unregister-wallet <name|wallet_id>
+//	go run . list-wallets
+//	go run . wallet-address <wallet_spec> <index> [change]
+//	go run . verify-list <wallet_spec> <list_file|->
+//	go run . migrate-map <old_wallet_spec> <new_wallet_spec> [--range <start-end>] [--gap <n>]
+//	go run . feerate [--target <blocks>] [--mode conservative|economical] [--network <network>]
+//	go run . sweep-psbt <old_wallet_spec> <new_wallet_spec> [--feerate <sat/vB> | --fee-target <blocks> [--fee-mode conservative|economical]] [--amount <sats>] [--min-confirmations <n>] [--include-uneconomic] [--out <file>] [--range <start-end>] [--gap <n>]
+//	go run . simulate-spend <wallet_spec> <sats> [--to <address>] [--feerate <sat/vB> | --fee-target <blocks> [--fee-mode conservative|economical]] [--long-term-feerate <sat/vB>] [--min-confirmations <n>] [--range <start-end>] [--gap <n>]
+//	go run . estimate-size <script_type> <inputs> <outputs> [--threshold <m> --keys <n>] [--output-type <script_type>] [--uncompressed] [--feerate <sat/vB>]
+//	go run . spend-paths <wallet_spec> [--feerate <sat/vB> | --fee-target <blocks> [--fee-mode conservative|economical]]
+//	go run . lint-psbt <psbt_file|psbt|->
+//	go run . combine-psbt <psbt_file|psbt|->... [--wallet <wallet_spec>] [--out <file>]
+//	go run . decode-tx <tx_hex|tx_file> [--wallet <wallet_spec>] [--count <n>] [--network <network>] [--prevouts]
+//	go run . scan-block <wallet_spec> <height|hash> [--range <start-end>] [--gap <n>] [--prevouts]
+//	go run . decode-address <address> [--network <network>]
+//	go run . check-lookalikes <wallet_spec> <history_file|-> [count] [--prefix <n>] [--suffix <n>]
+//	go run . verify-anchor <tx_hex|tx_file> <payload_hex|document> [--hash sha256|sha256d] [--prefix <hex>]
+//	go run . frost-addresses <group_file> [count]
+//	go run . lnd <accounts_json> [count] [--account <name>] [--address <address>]
+//	go run . cln <xpub|descriptors> [count] [--address <address>]
+//	go run . verify-close <wallet> <delivery_address|script_hex> [count] [--node wallet|lnd|cln]
+//	go run . verify-vectors <vectors.json> --pubkey <key|file> [--sig <file>] [--unsigned]
+//	go run . verify-result <result_file|-> [--pubkey <key|file|fingerprint>]
+//	go run . diff-engines <request.json>
+//	go run . run --in <requests.json> --out <results.json>
+//	go run . run --verify <results.json> [--in <requests.json>]
+//	go run . watch <dir|fifo|pipe> [--results <dir>] [--archive <dir>] [--interval <seconds>] [--pprof <host:port>]
+//	go run . ui [<host:port>]
+//	go run . tui
+//	go run . decode-ur <ur> [<ur>...] | -
+//	go run . encode-psbt-ur <psbt_file|psbt|-> [--fragment-size <bytes>] [--parts <n>] [--type crypto-psbt|psbt] [--qr <dir>]
+//	go run . proto-schema
+//	go run . completion bash|zsh|fish
+//	go run . check [--deep]
+//
+// Global flags may appear anywhere on the command line:
+//
+//	--config <file>   chain backend config (default $VERIFY_ADDRESSES_CONFIG)
+//	--store <file>    SQLite wallet state store (builds with -tags sqlite)
+//	--verbose         include per-address detail in reports
+//	--porcelain       stdout holds exactly one result document (alias --quiet)
+//	--engine <name>   btcsuite (default) or internal, a from-scratch BIP-32
+//	                  and address engine sharing no code with btcsuite
+//	--paranoid        derive every address with every engine (and keys of
+//	                  taproot policies and FROST groups with the internal
+//	                  BIP-32 code); disagreement fails with exit status 3
+//	                  (builds with -tags libsecp256k1 derive with the C
+//	                  library and also cross-check against btcec)
+//	--offline         fail any command that needs a chain backend (builds
+//	                  with -tags offline are always offline)
+//	--cache           with --store, cache derived wallet addresses and skip
+//	                  re-deriving them (never under --paranoid)
+//	--proxy <url>     socks5://[user:pass@]host:port proxy (such as Tor)
+//	                  for every backend connection; needed for .onion hosts
+//	--trace <url>     export OpenTelemetry spans of derivation, backend
+//	                  calls and cross-checks to an OTLP/HTTP collector
+//	--audit-log <file>
+//	                  append every request run and watch execute to a
+//	                  hash-chained JSON lines log, private keys redacted
+//	--tenant <name>   tenant whose registered wallets "wallet:<name>"
+//	                  references resolve to (default "default")
+//	--identity <file> age identity file that encrypted wallet specs are
+//	                  decrypted with
+//	--passphrase-env <name>, --passphrase-fd <n>
+//	                  read the passphrase of passphrase-encrypted wallet
+//	                  specs from an environment variable or file descriptor
+//	--sign-key <file> sign every result document with an unencrypted
+//	                  ed25519 key (OpenSSH or PKCS#8 PEM); not with
+//	                  --format proto
+//	--format <name>   json (default), ndjson (streaming batch records),
+//	                  proto (length-prefixed protobuf; see proto-schema),
+//	                  cbor, html (a standalone page of report's output) or
+//	                  markdown (tables for a wiki or ticket)
+//
+// A wallet spec or bundle may be encrypted in the age format, to X25519
+// recipients or to a passphrase; encrypt-spec writes one, armored, with
+// the comma-separated --recipient list or the run's passphrase.
+//
+// single and multi are the positional forms of derive, kept as aliases:
+// their arguments are derive's flag values in usage order, and --network
+// is required. They are deprecated: each result carries a "deprecation"
+// object naming derive and, when the arguments parsed, the equivalent
+// derive arguments, and a warning is written to stderr.
+//
+// derive --key-env <name> and --key-fd <n> take the key from an environment
+// variable or an inherited file descriptor instead of argv, where process
+// listings show it. The key may be an extended private key of any SLIP-132
+// version; only its public key is used, and the variable is unset once
+// read.
+//
+// verify-vectors runs the conformance fixtures (output/fixtures/vectors.json)
+// against this binary, after checking their minisign signature (by default
+// <vectors.json>.minisig) against --pubkey. An unsigned or tampered file is
+// refused with invalid_signature unless --unsigned is given.
+//
+// A result signed with --sign-key carries a "result_signature" object: the
+// algorithm, the public key in authorized_keys form, its SHA256 fingerprint
+// and an ed25519 signature over the canonical JSON of the rest of the
+// document (keys sorted, no whitespace). ndjson records before the final
+// result are not signed. verify-result checks the signature and, given
+// --pubkey, that it is the operator's key.
+//
+// Multi-part URs may be given as raw scanned frames in any order, including
+// fountain-coded parts and duplicates; "-" reads whitespace-separated frames
+// from stdin. encode-psbt-ur goes the other way, splitting a PSBT into the
+// parts of an animated QR code for an air-gapped signer, with fountain-coded
+// parts after the plain ones when --parts asks for more.
+//
+// A wallet spec is a file (or inline text, or "-" for stdin) holding a
+// native JSON spec, an output descriptor, a Specter Desktop wallet export, a
+// BlueWallet multisig setup file, UR-encoded
+// crypto-output/crypto-account/crypto-hdkey parts, or a bundle written by
+// export-bundle (whose archived addresses are then all re-checked).
+// xpub arguments may also be given as ur:crypto-hdkey strings. multi
+// cosigners may carry their own non-hardened path suffix ("xpub.../2/*",
+// ending in one * standing for the index) in place of the <change>/<index>
+// layout; such a wallet has no change chain, and asking for one fails with
+// invalid_derivation.
+// --uncompressed (or "uncompressed" in a JSON spec) serializes child keys
+// uncompressed, for pre-compressed-key wallets; only the legacy and p2pk
+// script types accept it, and segwit types fail with code
+// "uncompressed_key". Malformed indices, counts, thresholds, booleans,
+// networks and keys are rejected up front with codes such as
+// "invalid_index" and "invalid_threshold".
+// tr() descriptors may use BIP-390 musig() keys, aggregated per participant
+// or derived from the aggregate, and a script tree of pk(), multi_a() and
+// sortedmulti_a() leaves.
+//
+// next-address gap-scans the receive chain through the backend (default gap
+// limit 20) and returns the lowest never-used address with its index and
+// derivation path. balance sums confirmed and unconfirmed funds across both
+// chains, over --range or, without one, up to the last used index of each;
+// utxos lists the unspent outputs over the same addresses, and dust-report
+// prices each as an input at several fee rates and suggests which to
+// consolidate while fees are low. provision-core turns a verified spec into
+// a watch-only descriptor wallet on the configured Core node, rescanning
+// from the spec's birth height.
+// verify-node has that node's deriveaddresses expand the spec's descriptors
+// and diffs the result against local derivation. backend-check probes the
+// configured backends once each, without retries, for their version, tip
+// height, pruning and round-trip latency.
+//
+// With --store, per-wallet state persists between runs: verify-wallet
+// records the addresses it derives and shows labels set with label, and
+// scans record used indices and checkpoints so they can resume.
+//
+// verify-list re-derives a CSV or JSON list of (change, index,
+// expected_address) rows and reports the rows that do not match.
+// migrate-map pairs each used address of an old wallet with the new
+// wallet's address at the same chain and index, to plan a migration between
+// script types, and sweep-psbt builds the unsigned PSBT moving the old
+// wallet's unspent outputs to the new one for its signers, at a fee rate
+// given or estimated by the backend, as feerate reports it. simulate-spend
+// runs Core's branch-and-bound and knapsack coin selection for a payment
+// and reports what each would spend and reveal, without building a
+// transaction. estimate-size computes the weight and vsize of a transaction
+// from its inputs' script type and policy and its outputs, with the same
+// worst-case input sizes, and spend-paths compares what spending a taproot
+// policy wallet's outputs by its key path and by each script leaf costs.
+// lint-psbt checks any PSBT for missing or inconsistent UTXOs, scripts and
+// key origins, absurd fees, non-standard outputs and unenforced timelocks.
+// combine-psbt merges cosigners' signed copies of a PSBT, verifying every
+// signature against the output spent and, with the wallet spec, the key the
+// wallet derives there. decode-tx decodes a raw transaction and marks each
+// input and output that is the wallet's with its chain, index and label.
+// scan-block fetches a block and lists its transactions paying or spending
+// the addresses monitor watches, to backfill history or check the monitor.
+// decode-address decodes an address, and for a segwit address that fails
+// its checksum names the characters most likely mistyped, as the address
+// checks do for expected addresses. check-lookalikes flags the addresses of a history export that are not the
+// wallet's but share their first and last characters with one of its
+// addresses, as address poisoning arranges. verify-anchor checks that a raw transaction's OP_RETURN output carries an
+// expected payload, given as hex or as the sha256/sha256d digest of an
+// anchored document. frost-addresses (experimental) checks a FROST group's
+// verification shares and derives its taproot addresses.
+//
+// Commands that need chain data read it through the backend named in the
+// config file ("core", "electrum" or "esplora"); without a config, Bitcoin
+// Core is used with the BITCOIN_RPC_URL/USER/PASS environment variables.
+//
+// check reports the binary's capabilities (commands, script types,
+// networks, wallet formats, chain backends, output formats and feature
+// flags) so callers can negotiate features with older or newer verifiers. check --deep also runs
+// the official BIP-32/49/84/86/67/327 and BIP-173/350 test vectors against
+// this binary and reports every vector; the binary is only reported
+// available when all of them pass.
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
-	"sort"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/base58"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/txscript"
-	"golang.org/x/crypto/ripemd160"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
 type Result struct {
 	Address   string `json:"address,omitempty"`
 	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
 	Available bool   `json:"available,omitempty"`
 	Version   string `json:"version,omitempty"`
 	Name      string `json:"name,omitempty"`
+
+	Capabilities *Capabilities    `json:"capabilities,omitempty"`
+	SelfCheck    *SelfCheckReport `json:"self_check,omitempty"`
+	// Backend names the curve backend that derived Address (--verbose).
+	Backend string           `json:"backend,omitempty"`
+	Build   *BuildProvenance `json:"build,omitempty"`
+	// Deprecation is set on results of a deprecated command.
+	Deprecation *Deprecation `json:"deprecation,omitempty"`
+}
+
+// Deprecation names the replacement of a deprecated command. Args, when
+// the request was understood, is the replacement invocation that gives the
+// same result, for migrating the calling script.
+type Deprecation struct {
+	Command     string   `json:"command"`
+	Replacement string   `json:"replacement"`
+	Args        []string `json:"args,omitempty"`
 }
 
 func main() {
-	if len(os.Args) < 2 {
+	args, err := extractGlobalFlags(os.Args[1:])
+	if err != nil {
+		outputFailure(err)
+		return
+	}
+	if len(args) < 1 {
 		outputError("Usage: go-verify.go <command> <args>")
 		return
 	}
 
-	command := os.Args[1]
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		outputError("Unknown command: " + args[0])
+		return
+	}
+	if options.porcelain {
+		defer recoverPorcelain()
+	}
+	// watch traces each request file on its own, continuing the file's
+	// traceparent, and ui each derivation; other commands continue
+	// $TRACEPARENT.
+	var root *span
+	if cmd.name != "watch" && cmd.name != "ui" {
+		root = startRemoteSpan(cmd.name, os.Getenv("TRACEPARENT"), spanAttr{"command", cmd.name}, spanAttr{"engine", options.engine})
+	}
+	cmd.run(args[1:])
+	closeDerivationCache()
+	root.end(nil)
+	flushTraces()
+	if options.porcelain && !resultWritten {
+		outputError("command produced no result")
+	}
+	if engineMismatch {
+		os.Exit(3)
+	}
+}
 
-	switch command {
-	case "check":
-		outputJSON(Result{
-			Available: true,
-			Version:   "0.24.2",
-			Name:      "btcd/btcutil",
-		})
+// resultWritten records that the run's result document is on stdout.
+var resultWritten bool
 
-	case "single":
-		if len(os.Args) != 7 {
-			outputError("Usage: single <xpub> <index> <script_type> <change> <network>")
-			return
+// recoverPorcelain turns a panic into an error result, so --porcelain
+// callers still get exactly one document; the trace goes to stderr.
+func recoverPorcelain() {
+	if r := recover(); r != nil {
+		diagnostic("panic: %v\n%s", r, debug.Stack())
+		if !resultWritten {
+			outputJSON(Result{Error: fmt.Sprintf("internal error: %v", r), Code: errInternal})
 		}
-		xpub := os.Args[2]
-		index, _ := strconv.Atoi(os.Args[3])
-		scriptType := os.Args[4]
-		change := os.Args[5] == "true"
-		network := os.Args[6]
+		os.Exit(2)
+	}
+}
 
-		address, err := deriveSingleSig(xpub, uint32(index), scriptType, change, network)
-		if err != nil {
-			outputError(err.Error())
-			return
-		}
-		outputJSON(Result{Address: address})
+// diagnostic writes a message for humans to stderr, never stdout, so it
+// cannot corrupt a result document.
+func diagnostic(format string, args ...interface{}) {
+	if requestID != "" {
+		format = "[" + requestID + "] " + format
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
 
-	case "multi":
-		if len(os.Args) != 8 {
-			outputError("Usage: multi <xpubs_json> <threshold> <index> <script_type> <change> <network>")
-			return
-		}
-		var xpubs []string
-		if err := json.Unmarshal([]byte(os.Args[2]), &xpubs); err != nil {
-			outputError("Failed to parse xpubs: " + err.Error())
+// outputText writes a result that is plain text rather than a document.
+func outputText(s string) {
+	resultWritten = true
+	fmt.Fprint(stdout, s)
+}
+
+func outputJSON(v interface{}) {
+	if options.porcelain {
+		// Only the first document counts; anything after it would make
+		// stdout unparseable.
+		if resultWritten {
+			if data, err := json.Marshal(v); err == nil {
+				diagnostic("extra result suppressed by --porcelain: %s", data)
+			}
 			return
 		}
-		threshold, _ := strconv.Atoi(os.Args[3])
-		index, _ := strconv.Atoi(os.Args[4])
-		scriptType := os.Args[5]
-		change := os.Args[6] == "true"
-		network := os.Args[7]
-
-		address, err := deriveMultisig(xpubs, threshold, uint32(index), scriptType, change, network)
+		resultWritten = true
+	}
+	if signingKey != nil {
+		signed, err := signResult(v)
 		if err != nil {
-			outputError(err.Error())
-			return
+			signed = Result{Error: fmt.Sprintf("failed to sign result: %v", err)}
 		}
-		outputJSON(Result{Address: address})
-
-	default:
-		outputError("Unknown command: " + command)
+		v = signed
 	}
-}
-
-func outputJSON(r Result) {
-	json.NewEncoder(os.Stdout).Encode(r)
+	switch options.format {
+	case "proto":
+		if err := writeProto(v); err != nil {
+			writeProto(Result{Error: err.Error()})
+		}
+		return
+	case "cbor":
+		if err := writeCBOR(v); err != nil {
+			writeCBOR(Result{Error: err.Error()})
+		}
+		return
+	case "html":
+		if err := writeHTML(v); err != nil {
+			writeHTML(Result{Error: err.Error()})
+		}
+		return
+	case "markdown":
+		if err := writeMarkdown(v); err != nil {
+			writeMarkdown(Result{Error: err.Error()})
+		}
+		return
+	}
+	if streaming() {
+		streamRecord("result", v)
+		return
+	}
+	json.NewEncoder(stdout).Encode(v)
 }
 
 func outputError(msg string) {
+	tracer.current.fail(msg)
 	outputJSON(Result{Error: msg})
 }
 
+// outputFailure reports err along with its error code, if it has one.
+func outputFailure(err error) {
+	tracer.current.fail(err.Error())
+	outputJSON(Result{Error: err.Error(), Code: errorCode(err)})
+}
+
 func getNetwork(network string) *chaincfg.Params {
-	if network == "mainnet" {
+	switch network {
+	case "mainnet":
 		return &chaincfg.MainNetParams
+	case "liquid":
+		return &liquidParams
+	case "liquidtestnet":
+		return &liquidTestnetParams
 	}
 	return &chaincfg.TestNet3Params
 }
 
 // convertToStandardXpub converts zpub/ypub etc to xpub/tpub format
 func convertToStandardXpub(xpub string, network string) string {
-	prefix := xpub[:4]
-
 	// Already standard format
-	if prefix == "xpub" || prefix == "tpub" {
+	if strings.HasPrefix(xpub, "xpub") || strings.HasPrefix(xpub, "tpub") {
 		return xpub
 	}
 
@@ -129,210 +414,231 @@ func convertToStandardXpub(xpub string, network string) string {
 		return xpub // Invalid, return as-is
 	}
 
-	// Replace version bytes
-	var newVersion []byte
-	if network == "mainnet" {
-		newVersion = []byte{0x04, 0x88, 0xB2, 0x1E} // xpub
-	} else {
-		newVersion = []byte{0x04, 0x35, 0x87, 0xCF} // tpub
-	}
+	// Replace version bytes with the network's xpub or tpub version
+	newVersion := getNetwork(network).HDPublicKeyID
 
-	// Create new key with standard version
-	newKey := append(newVersion, decoded[4:]...)
+	// Create new key with standard version and recompute the checksum
+	newKey := append(newVersion[:], decoded[4:78]...)
+	checksum := chainhash.DoubleHashB(newKey)[:4]
 
-	return base58.CheckEncode(newKey[:len(newKey)-4], 0)
+	return base58.Encode(append(newKey, checksum...))
 }
 
 func deriveSingleSig(xpub string, index uint32, scriptType string, change bool, network string) (string, error) {
-	net := getNetwork(network)
-
-	// Convert to standard format
-	standardXpub := convertToStandardXpub(xpub, network)
-
-	// Parse extended key
-	extKey, err := hdkeychain.NewKeyFromString(standardXpub)
+	st, err := lookupScriptType(scriptType)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse xpub: %v", err)
+		return "", err
 	}
-
-	// Derive: change / index
-	changeIdx := uint32(0)
-	if change {
-		changeIdx = 1
+	if st.multisig {
+		return "", fmt.Errorf("script type %s is multisig", scriptType)
+	}
+	if err := checkNetworkScriptType(network, scriptType); err != nil {
+		return "", err
 	}
+	return runEngines(func(e *addressEngine) (string, error) {
+		return e.singleSig(xpub, index, st, change, network)
+	})
+}
 
-	childKey, err := extKey.Derive(changeIdx)
+// deriveUncompressed derives a single-sig address with the child key
+// serialized uncompressed, for wallets that predate compressed keys. Only
+// legacy script types allow it.
+func deriveUncompressed(xpub string, index uint32, scriptType string, change bool, network string) (string, error) {
+	st, err := lookupScriptType(scriptType)
 	if err != nil {
-		return "", fmt.Errorf("failed to derive change: %v", err)
+		return "", err
+	}
+	if st.uncompressedAddress == nil {
+		return "", errorWithCode(errUncompressedKey, "script type %s cannot use uncompressed keys", scriptType)
 	}
+	return runEngines(func(e *addressEngine) (string, error) {
+		return e.uncompressed(xpub, index, st, change, network)
+	})
+}
 
-	derivedKey, err := childKey.Derive(index)
-	if err != nil {
-		return "", fmt.Errorf("failed to derive index: %v", err)
+func deriveMultisig(xpubs []string, threshold int, index uint32, scriptType string, change bool, network string) (string, error) {
+	st, err := lookupScriptType(scriptType)
+	if err != nil || !st.multisig {
+		return "", fmt.Errorf("unknown multisig script type: %s", scriptType)
 	}
+	if err := checkThreshold(threshold, len(xpubs)); err != nil {
+		return "", err
+	}
+	return runEngines(func(e *addressEngine) (string, error) {
+		return e.multisig(xpubs, threshold, index, st, change, network)
+	})
+}
 
-	pubKey, err := derivedKey.ECPubKey()
+func btcsuiteSingleSig(xpub string, index uint32, st *scriptType, change bool, network string) (string, error) {
+	pubKey, err := btcsuiteCosignerKey(xpub, index, change, network)
 	if err != nil {
-		return "", fmt.Errorf("failed to get public key: %v", err)
+		return "", err
 	}
+	return st.address([]*btcec.PublicKey{pubKey}, 1, getNetwork(network))
+}
 
-	pubKeyBytes := pubKey.SerializeCompressed()
-
-	switch scriptType {
-	case "legacy":
-		// P2PKH
-		pubKeyHash := btcutil.Hash160(pubKeyBytes)
-		addr, err := btcutil.NewAddressPubKeyHash(pubKeyHash, net)
-		if err != nil {
-			return "", err
-		}
-		return addr.EncodeAddress(), nil
+func btcsuiteUncompressed(xpub string, index uint32, st *scriptType, change bool, network string) (string, error) {
+	pubKey, err := deriveChildKey(xpub, index, change, network)
+	if err != nil {
+		return "", err
+	}
+	return st.uncompressedAddress(pubKey, getNetwork(network))
+}
 
-	case "nested_segwit":
-		// P2SH-P2WPKH
-		pubKeyHash := btcutil.Hash160(pubKeyBytes)
-		witAddr, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, net)
-		if err != nil {
-			return "", err
-		}
-		// Wrap in P2SH
-		script, err := txscript.PayToAddrScript(witAddr)
-		if err != nil {
-			return "", err
-		}
-		scriptHash := btcutil.Hash160(script)
-		addr, err := btcutil.NewAddressScriptHashFromHash(scriptHash, net)
+func btcsuiteMultisig(xpubs []string, threshold int, index uint32, st *scriptType, change bool, network string) (string, error) {
+	var pubKeys []*btcec.PublicKey
+	for _, xpub := range xpubs {
+		pubKey, err := btcsuiteCosignerKey(xpub, index, change, network)
 		if err != nil {
 			return "", err
 		}
-		return addr.EncodeAddress(), nil
+		pubKeys = append(pubKeys, pubKey)
+	}
+	return st.address(pubKeys, threshold, getNetwork(network))
+}
 
-	case "native_segwit":
-		// P2WPKH
-		pubKeyHash := btcutil.Hash160(pubKeyBytes)
-		addr, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, net)
-		if err != nil {
-			return "", err
-		}
-		return addr.EncodeAddress(), nil
+// btcsuiteCosignerKey derives a cosigner's (or single-sig key's) child
+// key, below its own path suffix if the key expression carries one.
+func btcsuiteCosignerKey(expr string, index uint32, change bool, network string) (*btcec.PublicKey, error) {
+	key, suffix, err := parseKeyOrigin(expr)
+	if err != nil {
+		return nil, err
+	}
+	if suffix == "" {
+		return deriveChildKey(key.Xpub, index, change, network)
+	}
+	return deriveSuffixKey(key.Xpub, suffix, index, change, network)
+}
 
-	case "taproot":
-		// P2TR - use x-only pubkey
-		// btcd's Taproot support
-		xOnlyPubKey := pubKeyBytes[1:33] // Remove prefix byte
-		addr, err := btcutil.NewAddressTaproot(xOnlyPubKey, net)
+// deriveChildKey derives the public key at <xpub>/<change>/<index>.
+func deriveChildKey(xpub string, index uint32, change bool, network string) (*btcec.PublicKey, error) {
+	if !options.paranoid {
+		chainKey, err := cachedChainKey(xpub, change, network)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return addr.EncodeAddress(), nil
-
-	default:
-		return "", fmt.Errorf("unknown script type: %s", scriptType)
+		return derivePublicPath(chainKey, []uint32{index})
 	}
-}
 
-func deriveMultisig(xpubs []string, threshold int, index uint32, scriptType string, change bool, network string) (string, error) {
-	net := getNetwork(network)
+	// Convert to standard format
+	standardXpub := convertToStandardXpub(xpub, network)
 
-	changeIdx := uint32(0)
-	if change {
-		changeIdx = 1
+	// Parse extended key
+	extKey, err := hdkeychain.NewKeyFromString(standardXpub)
+	if err != nil {
+		return nil, errorWithCode(errInvalidKey, "failed to parse xpub: %v", err)
 	}
 
-	// Derive public keys from each xpub
-	var pubKeys []*btcec.PublicKey
-	for _, xpub := range xpubs {
-		standardXpub := convertToStandardXpub(xpub, network)
-		extKey, err := hdkeychain.NewKeyFromString(standardXpub)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse xpub: %v", err)
-		}
-
-		childKey, err := extKey.Derive(changeIdx)
-		if err != nil {
-			return "", fmt.Errorf("failed to derive change: %v", err)
-		}
+	return deriveChainIndex(extKey, index, change)
+}
 
-		derivedKey, err := childKey.Derive(index)
-		if err != nil {
-			return "", fmt.Errorf("failed to derive index: %v", err)
-		}
+// chainKeyCacheSize bounds chainKeys; a batch over more account keys than
+// this starts the cache over.
+const chainKeyCacheSize = 1024
 
-		pubKey, err := derivedKey.ECPubKey()
-		if err != nil {
-			return "", fmt.Errorf("failed to get public key: %v", err)
-		}
+type chainKeyID struct {
+	xpub    string
+	change  bool
+	network string
+}
 
-		pubKeys = append(pubKeys, pubKey)
+// chainKeys holds the parsed <xpub>/<change> key of each chain derived
+// from, so a range parses its xpub and derives the chain step once rather
+// than per address. --paranoid bypasses it to cross-check whole paths.
+var chainKeys = struct {
+	sync.Mutex
+	keys map[chainKeyID]*hdkeychain.ExtendedKey
+}{keys: map[chainKeyID]*hdkeychain.ExtendedKey{}}
+
+func cachedChainKey(xpub string, change bool, network string) (*hdkeychain.ExtendedKey, error) {
+	id := chainKeyID{xpub, change, network}
+	chainKeys.Lock()
+	defer chainKeys.Unlock()
+	if key, ok := chainKeys.keys[id]; ok {
+		return key, nil
 	}
 
-	// Sort public keys (BIP-67)
-	sort.Slice(pubKeys, func(i, j int) bool {
-		return bytes.Compare(
-			pubKeys[i].SerializeCompressed(),
-			pubKeys[j].SerializeCompressed(),
-		) < 0
-	})
+	extKey, err := hdkeychain.NewKeyFromString(convertToStandardXpub(xpub, network))
+	if err != nil {
+		return nil, errorWithCode(errInvalidKey, "failed to parse xpub: %v", err)
+	}
+	chain := uint32(0)
+	if change {
+		chain = 1
+	}
+	key, err := extKey.Derive(chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive child %d: %v", chain, err)
+	}
+	if len(chainKeys.keys) >= chainKeyCacheSize {
+		chainKeys.keys = map[chainKeyID]*hdkeychain.ExtendedKey{}
+	}
+	chainKeys.keys[id] = key
+	return key, nil
+}
 
-	// Build multisig script
-	builder := txscript.NewScriptBuilder()
-	builder.AddInt64(int64(threshold))
-	for _, pk := range pubKeys {
-		builder.AddData(pk.SerializeCompressed())
+// deriveSuffixKey derives the public key at <xpub>/<suffix>, where suffix
+// is a non-hardened path such as "2/*" and "*" stands for index.
+func deriveSuffixKey(xpub, suffix string, index uint32, change bool, network string) (*btcec.PublicKey, error) {
+	extKey, err := hdkeychain.NewKeyFromString(convertToStandardXpub(xpub, network))
+	if err != nil {
+		return nil, errorWithCode(errInvalidKey, "failed to parse xpub: %v", err)
 	}
-	builder.AddInt64(int64(len(pubKeys)))
-	builder.AddOp(txscript.OP_CHECKMULTISIG)
 
-	redeemScript, err := builder.Script()
+	path, err := suffixPath(suffix, index, change)
 	if err != nil {
-		return "", fmt.Errorf("failed to build redeem script: %v", err)
+		return nil, err
+	}
+	pubKey, err := derivePublicPath(extKey, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive %s: %v", suffix, err)
+	}
+	if err := crossCheckDerivation(extKey, path, pubKey); err != nil {
+		return nil, err
 	}
+	return pubKey, nil
+}
 
-	switch scriptType {
-	case "p2sh":
-		// P2SH
-		scriptHash := btcutil.Hash160(redeemScript)
-		addr, err := btcutil.NewAddressScriptHashFromHash(scriptHash, net)
+// suffixPath resolves a path suffix such as "2/*" to child numbers, with
+// "*" standing for index. The suffix takes the place of <change>/<index>,
+// so it must end in the one wildcard, and it has no change chain.
+func suffixPath(suffix string, index uint32, change bool) ([]uint32, error) {
+	if change {
+		return nil, errorWithCode(errInvalidDerivation, "a key with path suffix /%s has no change chain", suffix)
+	}
+	steps := strings.Split(suffix, "/")
+	if steps[len(steps)-1] != "*" || strings.Count(suffix, "*") != 1 {
+		return nil, errorWithCode(errInvalidDerivation, "invalid path suffix %q: want non-hardened steps ending in one *", suffix)
+	}
+	path := make([]uint32, len(steps))
+	for i, step := range steps[:len(steps)-1] {
+		n, err := strconv.ParseUint(step, 10, 31)
 		if err != nil {
-			return "", err
+			return nil, errorWithCode(errInvalidDerivation, "invalid path suffix %q: want non-hardened steps ending in one *", suffix)
 		}
-		return addr.EncodeAddress(), nil
+		path[i] = uint32(n)
+	}
+	path[len(path)-1] = index
+	return path, nil
+}
 
-	case "p2wsh":
-		// P2WSH
-		witnessHash := sha256.Sum256(redeemScript)
-		addr, err := btcutil.NewAddressWitnessScriptHash(witnessHash[:], net)
-		if err != nil {
-			return "", err
-		}
-		return addr.EncodeAddress(), nil
-
-	case "p2sh_p2wsh":
-		// P2SH-P2WSH
-		// First create P2WSH
-		witnessHash := sha256.Sum256(redeemScript)
-
-		// Create witness program: OP_0 <32-byte-hash>
-		witnessProgram := make([]byte, 34)
-		witnessProgram[0] = 0x00
-		witnessProgram[1] = 0x20
-		copy(witnessProgram[2:], witnessHash[:])
-
-		// Hash160 of witness program
-		h := sha256.Sum256(witnessProgram)
-		ripemd := ripemd160.New()
-		ripemd.Write(h[:])
-		scriptHash := ripemd.Sum(nil)
-
-		addr, err := btcutil.NewAddressScriptHashFromHash(scriptHash, net)
-		if err != nil {
-			return "", err
-		}
-		return addr.EncodeAddress(), nil
+// deriveChainIndex derives the public key at change/index below an
+// account-level extended key.
+func deriveChainIndex(extKey *hdkeychain.ExtendedKey, index uint32, change bool) (*btcec.PublicKey, error) {
+	changeIdx := uint32(0)
+	if change {
+		changeIdx = 1
+	}
 
-	default:
-		return "", fmt.Errorf("unknown multisig script type: %s", scriptType)
+	path := []uint32{changeIdx, index}
+	pubKey, err := derivePublicPath(extKey, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := crossCheckDerivation(extKey, path, pubKey); err != nil {
+		return nil, err
 	}
+	return pubKey, nil
 }
 
 // Helper to convert hex string to bytes (for debugging)
@@ -1,18 +1,17 @@
 /**
  * Go (btcd/btcutil) Implementation Wrapper
  *
- * Calls the Go script for address derivation using btcd/btcutil libraries.
+ * Calls the Go verifier package for address derivation using btcd/btcutil libraries.
  * This provides a completely independent implementation used by Lightning Network.
  */
 
 import { spawn } from 'child_process';
 import { fileURLToPath } from 'url';
-import { dirname, join } from 'path';
+import { dirname } from 'path';
 import type { AddressDeriver, ScriptType, MultisigScriptType, Network } from '../types.js';
 
 const __filename = fileURLToPath(import.meta.url);
 const __dirname = dirname(__filename);
-const GO_SCRIPT = join(__dirname, 'go-verify.go');
 
 interface GoResult {
   address?: string;
@@ -24,7 +23,8 @@ interface GoResult {
 
 async function runGo(args: string[]): Promise<GoResult> {
   return new Promise((resolve, reject) => {
-    const proc = spawn('go', ['run', GO_SCRIPT, ...args], {
+    const proc = spawn('go', ['run', '.', '--porcelain', ...args], {
+      cwd: __dirname,
       stdio: ['pipe', 'pipe', 'pipe'],
       env: {
         ...process.env,
@@ -80,12 +80,12 @@ export const goImpl: AddressDeriver = {
     network: Network
   ): Promise<string> {
     const result = await runGo([
-      'single',
-      xpub,
-      String(index),
-      scriptType,
-      String(change),
-      network,
+      'derive',
+      '--xpub', xpub,
+      '--index', String(index),
+      '--type', scriptType,
+      `--change=${change}`,
+      '--network', network,
     ]);
 
     if (!result.address) {
@@ -104,13 +104,13 @@ export const goImpl: AddressDeriver = {
     network: Network
   ): Promise<string> {
     const result = await runGo([
-      'multi',
-      JSON.stringify(xpubs),
-      String(threshold),
-      String(index),
-      scriptType,
-      String(change),
-      network,
+      'derive',
+      '--multisig', JSON.stringify(xpubs),
+      '--threshold', String(threshold),
+      '--index', String(index),
+      '--type', scriptType,
+      `--change=${change}`,
+      '--network', network,
     ]);
 
     if (!result.address) {
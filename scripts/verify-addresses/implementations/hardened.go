@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// Some wallets derive receive addresses through hardened steps below the
+// account key (".../0h/*h"), which no extended public key can follow.
+// derive-hardened reads a descriptor holding extended private keys, as
+// `bitcoin-cli listdescriptors true` prints it, through --key-env or
+// --key-fd, and derives its addresses with private child derivation. Only
+// public keys and addresses leave it, and results derived through a
+// hardened step say so, since a watch-only copy of the wallet cannot
+// reproduce them.
+
+// defaultHardenedCount is how many addresses derive-hardened derives
+// without a count.
+const defaultHardenedCount = 10
+
+// hardenedWarning marks results that need the private keys to reproduce.
+const hardenedWarning = "derived with private keys through hardened steps; these addresses cannot be derived or verified from the extended public keys alone"
+
+// HardenedReport lists the addresses of a private descriptor.
+type HardenedReport struct {
+	ScriptType string        `json:"script_type"`
+	Network    string        `json:"network"`
+	Threshold  int           `json:"threshold,omitempty"`
+	Keys       []HardenedKey `json:"keys"`
+	// PrivateDerivation is set when any key is derived through a hardened
+	// step, so the addresses need the private keys to reproduce.
+	PrivateDerivation bool              `json:"private_derivation"`
+	Warning           string            `json:"warning,omitempty"`
+	Addresses         []HardenedAddress `json:"addresses"`
+}
+
+// HardenedKey is one key of the descriptor in public form, with the
+// derivation applied below it.
+type HardenedKey struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Xpub        string `json:"xpub"`
+	Derivation  string `json:"derivation"`
+	Hardened    bool   `json:"hardened"`
+}
+
+// HardenedAddress is the address at one wildcard index, with the full path
+// of each key.
+type HardenedAddress struct {
+	Index   uint32   `json:"index"`
+	Paths   []string `json:"paths"`
+	Address string   `json:"address"`
+}
+
+// hardenedKey is a parsed key expression: the extended key and the child
+// numbers below it, the last of which replaces the wildcard.
+type hardenedKey struct {
+	info     HardenedKey
+	key      *hdkeychain.ExtendedKey
+	steps    []uint32
+	wildcard uint32
+}
+
+// deriveHardened derives the first count addresses of desc, a descriptor
+// whose keys may be extended private keys.
+func deriveHardened(desc string, count int) (*HardenedReport, error) {
+	desc = strings.TrimSpace(desc)
+	if i := strings.LastIndex(desc, "#"); i >= 0 {
+		expected, err := descriptorChecksum(desc[:i])
+		if err != nil {
+			return nil, err
+		}
+		if desc[i+1:] != expected {
+			return nil, fmt.Errorf("descriptor checksum mismatch: got %s, expected %s", desc[i+1:], expected)
+		}
+		desc = desc[:i]
+	}
+	if isTaprootPolicyDescriptor(desc) {
+		return nil, fmt.Errorf("taproot script trees are not supported by derive-hardened")
+	}
+
+	for _, t := range descriptorScriptTypes() {
+		suffix := t.descriptorSuffix()
+		if t.descriptor == "" || !strings.HasPrefix(desc, t.descriptor) || !strings.HasSuffix(desc, suffix) {
+			continue
+		}
+		args := []string{desc[len(t.descriptor) : len(desc)-len(suffix)]}
+		report := &HardenedReport{ScriptType: t.name, Addresses: []HardenedAddress{}}
+		if t.multisig {
+			args = strings.Split(args[0], ",")
+			threshold, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid multisig threshold: %q", args[0])
+			}
+			if err := checkThreshold(threshold, len(args)-1); err != nil {
+				return nil, err
+			}
+			report.Threshold = threshold
+			args = args[1:]
+		}
+
+		keys := make([]*hardenedKey, 0, len(args))
+		defer func() {
+			for _, k := range keys {
+				k.key.Zero()
+			}
+		}()
+		for _, arg := range args {
+			k, err := parseHardenedKey(arg)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+			report.Keys = append(report.Keys, k.info)
+			report.PrivateDerivation = report.PrivateDerivation || k.info.Hardened
+		}
+		report.Network = networkFromXpub(report.Keys[0].Xpub)
+		if report.PrivateDerivation {
+			report.Warning = hardenedWarning
+		}
+
+		net := getNetwork(report.Network)
+		for i := 0; i < count; i++ {
+			index := uint32(i)
+			entry := HardenedAddress{Index: index}
+			pubs := make([]*btcec.PublicKey, len(keys))
+			for j, k := range keys {
+				pub, err := k.derive(index)
+				if err != nil {
+					return nil, err
+				}
+				pubs[j] = pub
+				entry.Paths = append(entry.Paths, k.path(index))
+			}
+			address, err := t.address(pubs, report.Threshold, net)
+			if err != nil {
+				return nil, err
+			}
+			entry.Address = address
+			report.Addresses = append(report.Addresses, entry)
+		}
+		return report, nil
+	}
+
+	return nil, fmt.Errorf("unsupported descriptor: %s", desc)
+}
+
+// parseHardenedKey parses a "[fingerprint/path]key/<steps>/*" expression,
+// whose steps and wildcard may be hardened ("h" or "'"). Hardened steps
+// need an extended private key.
+func parseHardenedKey(expr string) (*hardenedKey, error) {
+	origin, derivation, err := parseKeyOrigin(expr)
+	if err != nil {
+		return nil, err
+	}
+	xpub, err := neuterKey(origin.Xpub)
+	if err != nil {
+		return nil, err
+	}
+	k := &hardenedKey{info: HardenedKey{Fingerprint: origin.Fingerprint, Path: origin.Path, Xpub: xpub, Derivation: derivation}}
+	if derivation == "" {
+		return nil, fmt.Errorf("key %s has no wildcard derivation", xpub)
+	}
+	steps := strings.Split(derivation, "/")
+	for i, step := range steps {
+		n, hardened := strings.CutSuffix(step, "h")
+		if !hardened {
+			n, hardened = strings.CutSuffix(step, "'")
+		}
+		var child uint64
+		if last := i == len(steps)-1; last != (n == "*") {
+			return nil, fmt.Errorf("unsupported key derivation /%s (expected steps such as 0 or 0h, ending in * or *h)", derivation)
+		} else if !last {
+			child, err = strconv.ParseUint(n, 10, 32)
+			if err != nil || child >= hdkeychain.HardenedKeyStart {
+				return nil, fmt.Errorf("invalid derivation step %q in /%s", step, derivation)
+			}
+		}
+		if hardened {
+			child += hdkeychain.HardenedKeyStart
+			k.info.Hardened = true
+		}
+		k.steps = append(k.steps, uint32(child))
+	}
+	k.wildcard, k.steps = k.steps[len(k.steps)-1], k.steps[:len(k.steps)-1]
+
+	if k.key, err = hdkeychain.NewKeyFromString(origin.Xpub); err != nil {
+		return nil, errorWithCode(errInvalidKey, "invalid extended key: %v", err)
+	}
+	if k.info.Hardened && !k.key.IsPrivate() {
+		k.key.Zero()
+		return nil, errorWithCode(errInvalidKey, "key derivation /%s has hardened steps, which need the extended private key of %s", derivation, xpub)
+	}
+	return k, nil
+}
+
+// children returns the child numbers from the key to the wildcard index.
+func (k *hardenedKey) children(index uint32) []uint32 {
+	return append(append([]uint32(nil), k.steps...), k.wildcard+index)
+}
+
+// derive returns the public key at a wildcard index. Intermediate private
+// keys are zeroed as soon as the next one is derived.
+func (k *hardenedKey) derive(index uint32) (*btcec.PublicKey, error) {
+	ext := k.key
+	for _, i := range k.children(index) {
+		child, err := ext.Derive(i)
+		if ext != k.key {
+			ext.Zero()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive child %d: %v", i, err)
+		}
+		ext = child
+	}
+	defer ext.Zero()
+	return ext.ECPubKey()
+}
+
+// path renders the full path of the key at a wildcard index, from the key
+// origin when the descriptor gives one.
+func (k *hardenedKey) path(index uint32) string {
+	var b strings.Builder
+	b.WriteString(k.info.Path)
+	if b.Len() == 0 {
+		b.WriteString("m")
+	}
+	for _, i := range k.children(index) {
+		if i >= hdkeychain.HardenedKeyStart {
+			fmt.Fprintf(&b, "/%d'", i-hdkeychain.HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&b, "/%d", i)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// headerChain is the chain of block headers the Neutrino backend follows:
+// of the chains its peers offer, the one with the most work. Headers are
+// checked as a full node checks them (proof of work, difficulty,
+// timestamps, versions), except that up to the network's last checkpoint
+// only proof of work and the checkpoints themselves are checked, as btcd
+// does while syncing past checkpoints.
+type headerChain struct {
+	params *chaincfg.Params
+	// raw holds 80 serialized bytes per header, genesis first.
+	raw    []byte
+	hashes []chainhash.Hash
+	// path is the file the headers after genesis are kept in, or "".
+	path string
+
+	checkpoints    map[int32]chainhash.Hash
+	lastCheckpoint int32
+	timeSource     blockchain.MedianTimeSource
+}
+
+const headerSize = wire.MaxBlockHeaderPayload
+
+// loadHeaderChain opens the header chain kept at path, or starts one from
+// genesis when there is none. Headers that no longer link or miss a
+// checkpoint are dropped from the file, to be synced again.
+func loadHeaderChain(params *chaincfg.Params, path string) (*headerChain, error) {
+	c := &headerChain{
+		params:      params,
+		path:        path,
+		checkpoints: map[int32]chainhash.Hash{},
+		timeSource:  blockchain.NewMedianTime(),
+	}
+	for _, cp := range params.Checkpoints {
+		c.checkpoints[cp.Height] = *cp.Hash
+		c.lastCheckpoint = cp.Height
+	}
+	var genesis bytes.Buffer
+	if err := params.GenesisBlock.Header.Serialize(&genesis); err != nil {
+		return nil, err
+	}
+	c.raw = genesis.Bytes()
+	c.hashes = []chainhash.Hash{*params.GenesisHash}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	valid := 0
+	for ; valid+headerSize <= len(data); valid += headerSize {
+		var header wire.BlockHeader
+		if err := header.Deserialize(bytes.NewReader(data[valid : valid+headerSize])); err != nil {
+			break
+		}
+		hash := header.BlockHash()
+		height := int32(len(c.hashes))
+		if header.PrevBlock != c.hashes[height-1] {
+			break
+		}
+		if cp, ok := c.checkpoints[height]; ok && cp != hash {
+			break
+		}
+		c.raw = append(c.raw, data[valid:valid+headerSize]...)
+		c.hashes = append(c.hashes, hash)
+	}
+	if valid != len(data) {
+		if err := os.Truncate(path, int64(valid)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// tip is the height of the best header.
+func (c *headerChain) tip() int32 { return int32(len(c.hashes)) - 1 }
+
+// find returns the height of the header with the given hash, or -1. It
+// searches from the tip, where the headers peers connect to are.
+func (c *headerChain) find(hash chainhash.Hash) int32 {
+	for h := c.tip(); h >= 0; h-- {
+		if c.hashes[h] == hash {
+			return h
+		}
+	}
+	return -1
+}
+
+// locator lists hashes from height back to genesis, every one for the
+// first ten and then exponentially further apart, for getheaders.
+func (c *headerChain) locator(height int32) []*chainhash.Hash {
+	var hashes []*chainhash.Hash
+	step := int32(1)
+	for h := height; h > 0; h -= step {
+		hashes = append(hashes, &c.hashes[h])
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	return append(hashes, &c.hashes[0])
+}
+
+// connect adds headers a peer sent, which must follow a header of the
+// chain. If they fork it, they replace the headers after the fork only
+// when they have more work. It returns the height of the last header the
+// chain and the headers still share, and whether the chain changed.
+func (c *headerChain) connect(headers []*wire.BlockHeader) (int32, bool, error) {
+	fork := c.find(headers[0].PrevBlock)
+	if fork < 0 {
+		return 0, false, fmt.Errorf("headers do not connect to the chain")
+	}
+	hashes := make([]chainhash.Hash, len(headers))
+	for i, header := range headers {
+		hashes[i] = header.BlockHash()
+	}
+	for len(headers) > 0 && fork < c.tip() && hashes[0] == c.hashes[fork+1] {
+		fork++
+		headers, hashes = headers[1:], hashes[1:]
+	}
+	if len(headers) == 0 {
+		return fork, false, nil
+	}
+
+	view := &chainView{chain: c, fork: fork}
+	for i, header := range headers {
+		if i > 0 && header.PrevBlock != hashes[i-1] {
+			return 0, false, fmt.Errorf("headers do not form a chain")
+		}
+		height := fork + 1 + int32(i)
+		flags := blockchain.BFNone
+		if height <= c.lastCheckpoint {
+			flags = blockchain.BFFastAdd
+		}
+		if err := blockchain.CheckBlockHeaderSanity(header, c.params.PowLimit, c.timeSource, flags); err != nil {
+			return 0, false, fmt.Errorf("invalid header %s at height %d: %v", hashes[i], height, err)
+		}
+		if err := blockchain.CheckBlockHeaderContext(header, view.node(height-1), flags, view, false); err != nil {
+			return 0, false, fmt.Errorf("invalid header %s at height %d: %v", hashes[i], height, err)
+		}
+		view.branch = append(view.branch, header)
+	}
+
+	if fork < c.tip() {
+		ours, theirs := new(big.Int), new(big.Int)
+		for h := fork + 1; h <= c.tip(); h++ {
+			ours.Add(ours, blockchain.CalcWork(c.bits(h)))
+		}
+		for _, header := range headers {
+			theirs.Add(theirs, blockchain.CalcWork(header.Bits))
+		}
+		if theirs.Cmp(ours) <= 0 {
+			return fork, false, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, header := range headers {
+		if err := header.Serialize(&buf); err != nil {
+			return 0, false, err
+		}
+	}
+	rewound := fork < c.tip()
+	c.raw = append(c.raw[:headerSize*int(fork+1)], buf.Bytes()...)
+	c.hashes = append(c.hashes[:fork+1], hashes...)
+	return fork, true, c.save(fork, rewound, buf.Bytes())
+}
+
+// save writes the headers after fork to the chain's file.
+func (c *headerChain) save(fork int32, rewound bool, headers []byte) error {
+	if c.path == "" {
+		return nil
+	}
+	if rewound {
+		if err := os.Truncate(c.path, int64(headerSize)*int64(fork)); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(headers); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// bits and timestamp read the fields straight from the serialized header.
+func (c *headerChain) bits(height int32) uint32 {
+	return binary.LittleEndian.Uint32(c.raw[headerSize*int(height)+72:])
+}
+
+func (c *headerChain) timestamp(height int32) int64 {
+	return int64(binary.LittleEndian.Uint32(c.raw[headerSize*int(height)+68:]))
+}
+
+// chainView is the chain up to fork followed by a branch being checked,
+// as the blockchain package's contextual checks see it.
+type chainView struct {
+	chain  *headerChain
+	fork   int32
+	branch []*wire.BlockHeader
+}
+
+func (v *chainView) node(height int32) blockchain.HeaderCtx {
+	if height < 0 || height > v.fork+int32(len(v.branch)) {
+		return nil
+	}
+	return &headerNode{view: v, height: height}
+}
+
+func (v *chainView) ChainParams() *chaincfg.Params { return v.chain.params }
+
+func (v *chainView) BlocksPerRetarget() int32 {
+	return int32(v.chain.params.TargetTimespan / v.chain.params.TargetTimePerBlock)
+}
+
+func (v *chainView) MinRetargetTimespan() int64 {
+	return int64(v.chain.params.TargetTimespan/time.Second) / v.chain.params.RetargetAdjustmentFactor
+}
+
+func (v *chainView) MaxRetargetTimespan() int64 {
+	return int64(v.chain.params.TargetTimespan/time.Second) * v.chain.params.RetargetAdjustmentFactor
+}
+
+func (v *chainView) VerifyCheckpoint(height int32, hash *chainhash.Hash) bool {
+	cp, ok := v.chain.checkpoints[height]
+	return !ok || cp == *hash
+}
+
+// FindPreviousCheckpoint gives the newest checkpoint the chain has
+// reached, which no branch may fork before.
+func (v *chainView) FindPreviousCheckpoint() (blockchain.HeaderCtx, error) {
+	cps := v.chain.params.Checkpoints
+	for i := len(cps) - 1; i >= 0; i-- {
+		if cps[i].Height <= v.chain.tip() {
+			return v.node(cps[i].Height), nil
+		}
+	}
+	return nil, nil
+}
+
+// headerNode is one header of a chainView.
+type headerNode struct {
+	view   *chainView
+	height int32
+}
+
+func (n *headerNode) Height() int32 { return n.height }
+
+func (n *headerNode) Bits() uint32 {
+	if n.height > n.view.fork {
+		return n.view.branch[n.height-n.view.fork-1].Bits
+	}
+	return n.view.chain.bits(n.height)
+}
+
+func (n *headerNode) Timestamp() int64 {
+	if n.height > n.view.fork {
+		return n.view.branch[n.height-n.view.fork-1].Timestamp.Unix()
+	}
+	return n.view.chain.timestamp(n.height)
+}
+
+func (n *headerNode) Parent() blockchain.HeaderCtx { return n.view.node(n.height - 1) }
+
+func (n *headerNode) RelativeAncestorCtx(distance int32) blockchain.HeaderCtx {
+	return n.view.node(n.height - distance)
+}
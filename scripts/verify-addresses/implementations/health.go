@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// latencySamples is how many tip height round trips backend-check times.
+const latencySamples = 3
+
+// BackendCheckReport is the health of each backend backend-check probed.
+type BackendCheckReport struct {
+	Network  string          `json:"network"`
+	Backends []BackendHealth `json:"backends"`
+	// OK is set when every probed backend answered.
+	OK bool `json:"ok"`
+}
+
+// BackendHealth is what one backend reported about itself. Version and
+// Pruned are left out where the backend's protocol does not say.
+type BackendHealth struct {
+	Backend   string `json:"backend"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Version   string `json:"version,omitempty"`
+	TipHeight int64  `json:"tip_height,omitempty"`
+	Pruned    *bool  `json:"pruned,omitempty"`
+	// ConnectMS is how long opening the backend took; LatencyMS is the
+	// median of latencySamples tip height round trips.
+	ConnectMS float64 `json:"connect_ms"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// backendProber is implemented by backends that can report their server
+// version and whether it is pruned.
+type backendProber interface {
+	probe() (version string, pruned *bool, err error)
+}
+
+// configuredBackends lists the selected backend, then every other backend
+// whose config section names a server.
+func (c *BackendConfig) configuredBackends() []string {
+	names := []string{c.Backend}
+	for _, b := range []struct {
+		name string
+		set  bool
+	}{
+		{"electrum", c.Electrum.Server != ""},
+		{"esplora", c.Esplora.URL != ""},
+		{"neutrino", len(c.Neutrino.Peers) > 0},
+	} {
+		if b.set && b.name != c.Backend {
+			names = append(names, b.name)
+		}
+	}
+	return names
+}
+
+// checkBackends probes each named backend once, without retries, so the
+// report shows the setup as it is.
+func checkBackends(cfg *BackendConfig, names []string, network string) *BackendCheckReport {
+	report := &BackendCheckReport{Network: network, Backends: []BackendHealth{}, OK: true}
+	for _, name := range names {
+		h := probeBackend(cfg, name, network)
+		report.OK = report.OK && h.OK
+		report.Backends = append(report.Backends, h)
+	}
+	return report
+}
+
+func probeBackend(cfg *BackendConfig, name, network string) BackendHealth {
+	h := BackendHealth{Backend: name}
+	fail := func(err error) BackendHealth {
+		h.Error, h.Code = err.Error(), errorCode(err)
+		return h
+	}
+
+	selected := *cfg
+	selected.Backend = name
+	factory, err := backendFactory(&selected)
+	if err != nil {
+		return fail(err)
+	}
+	start := time.Now()
+	backend, err := factory(&selected, network)
+	if err != nil {
+		return fail(err)
+	}
+	defer backend.Close()
+	h.ConnectMS = milliseconds(time.Since(start))
+
+	if p, ok := backend.(backendProber); ok {
+		if h.Version, h.Pruned, err = p.probe(); err != nil {
+			return fail(err)
+		}
+	}
+	samples := make([]time.Duration, latencySamples)
+	for i := range samples {
+		start := time.Now()
+		if h.TipHeight, err = backend.TipHeight(); err != nil {
+			return fail(err)
+		}
+		samples[i] = time.Since(start)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	h.LatencyMS = milliseconds(samples[len(samples)/2])
+	h.OK = true
+	return h
+}
+
+// milliseconds renders a duration in milliseconds to 0.01 ms.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()/10) / 100
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+)
+
+// --format html renders the audit report as one standalone page, for
+// sending to clients who will not read JSON: the summary, the keys, the
+// address tables with a QR code per address, and the verification status.
+// Everything is inline, the web UI's stylesheet included, so the file
+// opens anywhere and loads nothing from elsewhere.
+
+//go:embed report.html
+var reportTemplateText string
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+	"qr": func(address string) template.HTML {
+		q, err := encodeQR([]byte(address))
+		if err != nil {
+			return ""
+		}
+		// The SVG is drawn by encodeQR from module coordinates alone.
+		return template.HTML(`<svg class="qr"` + q.svg()[len("<svg"):])
+	},
+}).Parse(reportTemplateText))
+
+// reportPage is what the report template renders: a report, or the error
+// that stopped one.
+type reportPage struct {
+	CSS    template.CSS
+	Report *AuditReport
+	Error  string
+}
+
+// writeHTML writes v as an HTML page. Only the audit report and error
+// results have one.
+func writeHTML(v interface{}) error {
+	css, err := uiFiles.ReadFile("ui/ui.css")
+	if err != nil {
+		return err
+	}
+	page := reportPage{CSS: template.CSS(css)}
+	switch doc := v.(type) {
+	case *AuditReport:
+		page.Report = doc
+	case Result:
+		if doc.Error == "" {
+			return fmt.Errorf("--format html renders the report command's output")
+		}
+		page.Error = doc.Error
+	default:
+		return fmt.Errorf("--format html renders the report command's output")
+	}
+	// Rendered in full first, so a failure cannot leave half a page.
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, page); err != nil {
+		return err
+	}
+	_, err = stdout.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// register-wallet --keychain keeps a wallet's spec, and so its xpubs, in
+// the OS keychain rather than in the store: the macOS Keychain, the Secret
+// Service (GNOME Keyring, KWallet) through secret-tool, or on Windows a
+// DPAPI blob only the registering user can decrypt. The store keeps a
+// keyless summary for list-wallets. The keychain is unlocked, prompting
+// if the OS asks to, the first time a wallet is read, and the spec is then
+// kept in memory for the rest of the process, so a watch or ui session
+// unlocks once.
+
+// keychainService is the service keychain items are filed under.
+const keychainService = "verify-addresses"
+
+// keychain is an OS secret store. Items are keyed by account. store may
+// return a reference the store row keeps and load is given back; DPAPI,
+// which encrypts rather than stores, returns the ciphertext.
+type keychain struct {
+	name   string
+	store  func(account string, secret []byte) (ref string, err error)
+	load   func(account, ref string) ([]byte, error)
+	remove func(account string) error
+}
+
+// osKeychain is the keychain of the platform, set by keychain_*.go; nil
+// where there is none.
+var osKeychain *keychain
+
+// keychainEntry is what the store row holds, as {"keychain": ...}, for a
+// wallet whose spec is in the keychain.
+type keychainEntry struct {
+	Backend    string `json:"backend"`
+	Ref        string `json:"ref,omitempty"`
+	Network    string `json:"network"`
+	ScriptType string `json:"script_type"`
+	Threshold  int    `json:"threshold,omitempty"`
+	Keys       int    `json:"keys"`
+}
+
+// keychainRow is the store row form of a keychain-backed wallet.
+type keychainRow struct {
+	Keychain *keychainEntry `json:"keychain"`
+}
+
+var keychainCache = struct {
+	sync.Mutex
+	specs map[string][]byte
+}{specs: map[string][]byte{}}
+
+func keychainAccount(tenant, name string) string {
+	return tenant + "/" + name
+}
+
+// storeInKeychain puts the wallet's spec in the OS keychain and returns the
+// row the store keeps instead.
+func storeInKeychain(tenant, name string, spec *WalletSpec, data []byte) (string, error) {
+	if osKeychain == nil {
+		return "", fmt.Errorf("there is no OS keychain on this platform")
+	}
+	account := keychainAccount(tenant, name)
+	ref, err := osKeychain.store(account, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to store wallet in the %s: %v", osKeychain.name, err)
+	}
+	keychainCache.Lock()
+	keychainCache.specs[account] = data
+	keychainCache.Unlock()
+	row, err := json.Marshal(keychainRow{&keychainEntry{
+		Backend:    osKeychain.name,
+		Ref:        ref,
+		Network:    spec.Network,
+		ScriptType: spec.ScriptType,
+		Threshold:  spec.Threshold,
+		Keys:       len(spec.Keys),
+	}})
+	return string(row), err
+}
+
+// loadFromKeychain reads a wallet's spec from the keychain, once per
+// process.
+func loadFromKeychain(tenant, name string, entry *keychainEntry) ([]byte, error) {
+	account := keychainAccount(tenant, name)
+	keychainCache.Lock()
+	defer keychainCache.Unlock()
+	if data, ok := keychainCache.specs[account]; ok {
+		return data, nil
+	}
+	if osKeychain == nil || osKeychain.name != entry.Backend {
+		return nil, fmt.Errorf("wallet %s is in the %s, which is not available here", name, entry.Backend)
+	}
+	data, err := osKeychain.load(account, entry.Ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet %s from the %s: %v", name, entry.Backend, err)
+	}
+	keychainCache.specs[account] = data
+	return data, nil
+}
+
+// removeFromKeychain deletes a wallet's keychain item, if it has one.
+func removeFromKeychain(tenant, name string, entry *keychainEntry) error {
+	account := keychainAccount(tenant, name)
+	keychainCache.Lock()
+	delete(keychainCache.specs, account)
+	keychainCache.Unlock()
+	if osKeychain == nil || osKeychain.name != entry.Backend {
+		return fmt.Errorf("wallet %s is in the %s, which is not available here", name, entry.Backend)
+	}
+	if err := osKeychain.remove(account); err != nil {
+		return fmt.Errorf("failed to remove wallet %s from the %s: %v", name, entry.Backend, err)
+	}
+	return nil
+}
+
+// summary is a keyless stand-in for the spec, for describing the wallet.
+func (e *keychainEntry) summary() *WalletSpec {
+	return &WalletSpec{Network: e.Network, ScriptType: e.ScriptType, Threshold: e.Threshold, Keys: make([]WalletKey, e.Keys)}
+}
+
+// runKeychainTool runs a keychain command line tool with input on stdin,
+// so secrets never appear in its arguments, and returns its output.
+func runKeychainTool(input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return out, nil
+}
+
+// Keychain tools store text, so specs are kept base64-encoded.
+func encodeKeychainSecret(secret []byte) string {
+	return base64.StdEncoding.EncodeToString(secret)
+}
+
+func decodeKeychainSecret(out []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("keychain item is not a wallet spec")
+	}
+	return data, nil
+}
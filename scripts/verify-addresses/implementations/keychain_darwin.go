@@ -0,0 +1,31 @@
+//go:build darwin
+
+package main
+
+import "fmt"
+
+// The macOS Keychain, through security(1). Items are added from its
+// interactive mode on stdin so the spec is not in its arguments.
+
+func init() {
+	osKeychain = &keychain{
+		name: "macos-keychain",
+		store: func(account string, secret []byte) (string, error) {
+			command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+				keychainService, account, encodeKeychainSecret(secret))
+			_, err := runKeychainTool([]byte(command), "security", "-i")
+			return "", err
+		},
+		load: func(account, _ string) ([]byte, error) {
+			out, err := runKeychainTool(nil, "security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+			if err != nil {
+				return nil, err
+			}
+			return decodeKeychainSecret(out)
+		},
+		remove: func(account string) error {
+			_, err := runKeychainTool(nil, "security", "delete-generic-password", "-s", keychainService, "-a", account)
+			return err
+		},
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux || freebsd || openbsd || netbsd
+
+package main
+
+// The freedesktop Secret Service (GNOME Keyring, KWallet), through
+// secret-tool(1) from libsecret, which reads the secret from stdin.
+
+func init() {
+	osKeychain = &keychain{
+		name: "secret-service",
+		store: func(account string, secret []byte) (string, error) {
+			_, err := runKeychainTool([]byte(encodeKeychainSecret(secret)), "secret-tool", "store",
+				"--label="+keychainService+" wallet "+account, "service", keychainService, "account", account)
+			return "", err
+		},
+		load: func(account, _ string) ([]byte, error) {
+			out, err := runKeychainTool(nil, "secret-tool", "lookup", "service", keychainService, "account", account)
+			if err != nil {
+				return nil, err
+			}
+			return decodeKeychainSecret(out)
+		},
+		remove: func(account string) error {
+			_, err := runKeychainTool(nil, "secret-tool", "clear", "service", keychainService, "account", account)
+			return err
+		},
+	}
+}
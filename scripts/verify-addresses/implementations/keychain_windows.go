@@ -0,0 +1,75 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Windows DPAPI: CryptProtectData encrypts the spec to the current user,
+// and the ciphertext is what the store row keeps. Nothing is filed in a
+// credential store, so removal has nothing to delete.
+
+var (
+	crypt32            = syscall.NewLazyDLL("crypt32.dll")
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	cryptProtectData   = crypt32.NewProc("CryptProtectData")
+	cryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	localFree          = kernel32.NewProc("LocalFree")
+)
+
+// dataBlob is a DATA_BLOB.
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+// cryptProtectUIForbidden fails rather than prompting.
+const cryptProtectUIForbidden = 0x1
+
+func newDataBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+// dpapi runs CryptProtectData or CryptUnprotectData over in.
+func dpapi(proc *syscall.LazyProc, in []byte, entropy []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := proc.Call(
+		uintptr(unsafe.Pointer(newDataBlob(in))), 0,
+		uintptr(unsafe.Pointer(newDataBlob(entropy))), 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("%s: %v", proc.Name, err)
+	}
+	defer localFree.Call(uintptr(unsafe.Pointer(out.data)))
+	return append([]byte{}, unsafe.Slice(out.data, out.size)...), nil
+}
+
+func init() {
+	osKeychain = &keychain{
+		name: "dpapi",
+		store: func(account string, secret []byte) (string, error) {
+			// The account is the entropy, so a blob cannot be moved to
+			// another wallet's row.
+			blob, err := dpapi(cryptProtectData, secret, []byte(account))
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(blob), nil
+		},
+		load: func(account, ref string) ([]byte, error) {
+			blob, err := base64.StdEncoding.DecodeString(ref)
+			if err != nil {
+				return nil, fmt.Errorf("corrupt DPAPI blob")
+			}
+			return dpapi(cryptUnprotectData, blob, []byte(account))
+		},
+		remove: func(string) error { return nil },
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Liquid (Elements) keeps Bitcoin's BIP-32 serialization, so xpub and tpub
+// keys derive as usual, but has its own address versions and bech32 HRPs.
+// Only unconfidential addresses are derived: confidential addresses embed
+// a blinding key and use blech32, which is not implemented here.
+var (
+	liquidParams        = liquidNet(chaincfg.MainNetParams, "liquidv1", 0x6c697164, 57, 39, "ex")
+	liquidTestnetParams = liquidNet(chaincfg.TestNet3Params, "liquidtestnet", 0x6c717464, 36, 19, "tex")
+)
+
+// liquidNet derives Liquid parameters from the Bitcoin network whose
+// extended key versions it shares. net only keys chaincfg's registry (for
+// address decoding); it is not the Elements P2P magic, which the verifier
+// never needs.
+func liquidNet(base chaincfg.Params, name string, net wire.BitcoinNet, pubKeyHashID, scriptHashID byte, hrp string) chaincfg.Params {
+	p := base
+	p.Name = name
+	p.Net = net
+	p.PubKeyHashAddrID = pubKeyHashID
+	p.ScriptHashAddrID = scriptHashID
+	p.Bech32HRPSegwit = hrp
+	return p
+}
+
+func init() {
+	for _, p := range []*chaincfg.Params{&liquidParams, &liquidTestnetParams} {
+		if err := chaincfg.Register(p); err != nil {
+			panic("failed to register " + p.Name + ": " + err.Error())
+		}
+	}
+}
+
+// isLiquid reports whether network is one of the Liquid networks.
+func isLiquid(network string) bool {
+	return network == "liquid" || network == "liquidtestnet"
+}
+
+// checkNetworkScriptType rejects script types a network cannot use. Liquid
+// taproot commits to Elements-specific tagged hashes, so BIP-86 and script
+// tree outputs would derive the wrong addresses there.
+func checkNetworkScriptType(network, scriptType string) error {
+	if isLiquid(network) && scriptType == "taproot" {
+		return errorWithCode(errInvalidNetwork, "script type taproot is not supported on %s", network)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LNDAccounts is the output of `lncli wallet accounts list`: one entry per
+// account and address type, each with its account-level extended key.
+type LNDAccounts struct {
+	Accounts []LNDAccount `json:"accounts"`
+}
+
+// LNDAccount is one account of an LND on-chain wallet.
+type LNDAccount struct {
+	Name              string `json:"name"`
+	AddressType       string `json:"address_type"`
+	ExtendedPublicKey string `json:"extended_public_key"`
+	DerivationPath    string `json:"derivation_path"`
+}
+
+// lndAddressTypes maps LND's address types to the script types of their
+// receive and change addresses. The default account's BIP-49 key scope is
+// "hybrid": nested segwit receive addresses, native segwit change.
+var lndAddressTypes = map[string]struct{ receive, change string }{
+	"WITNESS_PUBKEY_HASH":               {"native_segwit", "native_segwit"},
+	"NESTED_WITNESS_PUBKEY_HASH":        {"nested_segwit", "nested_segwit"},
+	"HYBRID_NESTED_WITNESS_PUBKEY_HASH": {"nested_segwit", "native_segwit"},
+	"TAPROOT_PUBKEY":                    {"taproot", "taproot"},
+}
+
+// LNDReport lists the addresses of each account, and where an address
+// shown by lncli sits among them.
+type LNDReport struct {
+	Network  string             `json:"network"`
+	Accounts []LNDAccountReport `json:"accounts"`
+	Address  string             `json:"address,omitempty"`
+	// Match locates Address; it is nil if no derived address matched.
+	Match *LNDMatch `json:"match,omitempty"`
+	// Verified is set when the given address was found, or when no
+	// address was given.
+	Verified bool `json:"verified"`
+}
+
+// LNDAccountReport is what lnd derived for one account.
+type LNDAccountReport struct {
+	Name              string           `json:"name"`
+	AddressType       string           `json:"address_type"`
+	DerivationPath    string           `json:"derivation_path"`
+	ExtendedPublicKey string           `json:"extended_public_key"`
+	ReceiveScriptType string           `json:"receive_script_type"`
+	ChangeScriptType  string           `json:"change_script_type"`
+	Receive           []DerivedAddress `json:"receive"`
+	Change            []DerivedAddress `json:"change"`
+}
+
+// LNDMatch is the account, chain and index an address was derived at.
+type LNDMatch struct {
+	Account     string `json:"account"`
+	AddressType string `json:"address_type"`
+	Change      bool   `json:"change"`
+	Index       uint32 `json:"index"`
+	Path        string `json:"path"`
+}
+
+func loadLNDAccounts(arg string) (*LNDAccounts, error) {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if os.IsNotExist(err) {
+			data, err = []byte(arg), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LND accounts: %v", err)
+	}
+
+	var a LNDAccounts
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to parse LND accounts: %v", err)
+	}
+	if len(a.Accounts) == 0 {
+		return nil, fmt.Errorf("no LND accounts found")
+	}
+	return &a, nil
+}
+
+// lndNetwork reads the network from the coin type of an account path
+// (m/<purpose>'/<coin>'/<account>').
+func lndNetwork(path string) (string, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 4 || parts[0] != "m" {
+		return "", fmt.Errorf("unexpected LND account path %q", path)
+	}
+	switch strings.TrimRight(parts[2], "'h") {
+	case "0":
+		return "mainnet", nil
+	case "1":
+		return "testnet", nil
+	}
+	return "", errorWithCode(errInvalidNetwork, "unsupported coin type in LND account path %q", path)
+}
+
+// deriveLNDAddresses derives count receive and change addresses of every
+// account (or only the named one), and looks for address among them.
+func deriveLNDAddresses(accounts *LNDAccounts, count int, name, address string) (*LNDReport, error) {
+	report := &LNDReport{Accounts: []LNDAccountReport{}, Address: address}
+	for _, a := range accounts.Accounts {
+		if name != "" && a.Name != name {
+			continue
+		}
+		types, ok := lndAddressTypes[a.AddressType]
+		if !ok {
+			return nil, fmt.Errorf("unsupported LND address type %q in account %s", a.AddressType, a.Name)
+		}
+		network, err := lndNetwork(a.DerivationPath)
+		if err != nil {
+			return nil, err
+		}
+		if report.Network == "" {
+			report.Network = network
+		} else if network != report.Network {
+			return nil, fmt.Errorf("LND accounts mix %s and %s", report.Network, network)
+		}
+
+		account := LNDAccountReport{
+			Name:              a.Name,
+			AddressType:       a.AddressType,
+			DerivationPath:    a.DerivationPath,
+			ExtendedPublicKey: a.ExtendedPublicKey,
+			ReceiveScriptType: types.receive,
+			ChangeScriptType:  types.change,
+			Receive:           []DerivedAddress{},
+			Change:            []DerivedAddress{},
+		}
+		for i := 0; i < count; i++ {
+			for _, change := range []bool{false, true} {
+				scriptType := types.receive
+				if change {
+					scriptType = types.change
+				}
+				derived, err := deriveSingleSig(a.ExtendedPublicKey, uint32(i), scriptType, change, network)
+				if err != nil {
+					return nil, fmt.Errorf("account %s (%s): %w", a.Name, a.AddressType, err)
+				}
+				if change {
+					account.Change = append(account.Change, DerivedAddress{Index: uint32(i), Address: derived})
+				} else {
+					account.Receive = append(account.Receive, DerivedAddress{Index: uint32(i), Address: derived})
+				}
+				if address != "" && addressesMatch(derived, address) {
+					report.Match = &LNDMatch{
+						Account:     a.Name,
+						AddressType: a.AddressType,
+						Change:      change,
+						Index:       uint32(i),
+						Path:        fmt.Sprintf("%s/%s", a.DerivationPath, chainIndex(change, uint32(i))),
+					}
+				}
+			}
+		}
+		report.Accounts = append(report.Accounts, account)
+	}
+	if len(report.Accounts) == 0 {
+		return nil, fmt.Errorf("no LND account named %q", name)
+	}
+	report.Verified = address == "" || report.Match != nil
+	return report, nil
+}
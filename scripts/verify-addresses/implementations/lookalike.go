@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// Address poisoning sends dust from an address made to share the first
+// and last characters of one of the wallet's own, hoping it is later
+// copied from the history instead. check-lookalikes reads any text holding
+// addresses (a plain list, a CSV or JSON history export) and flags every
+// address that is not derived from the wallet but shares a prefix and a
+// suffix with one that is. The fixed lead of each address ("bc1q", "1",
+// "3") is not counted, since every address of a type shares it.
+
+// Default lookalike thresholds: the characters an address must share at
+// each end, past its fixed lead, with a wallet address to be flagged.
+const (
+	defaultLookalikePrefix = 3
+	defaultLookalikeSuffix = 3
+	defaultLookalikeCount  = 200
+)
+
+// LookalikeReport lists the addresses of a history that imitate the
+// wallet's.
+type LookalikeReport struct {
+	Network string `json:"network"`
+	// Derived counts the wallet addresses compared against, on each of
+	// the receive and change chains.
+	Derived    int         `json:"derived"`
+	Addresses  int         `json:"addresses"`
+	Own        int         `json:"own"`
+	Foreign    int         `json:"foreign"`
+	Prefix     int         `json:"prefix"`
+	Suffix     int         `json:"suffix"`
+	Lookalikes []Lookalike `json:"lookalikes"`
+	Clean      bool        `json:"clean"`
+}
+
+// Lookalike is a foreign address that resembles a wallet address.
+type Lookalike struct {
+	Address   string `json:"address"`
+	Resembles string `json:"resembles"`
+	Change    bool   `json:"change"`
+	Index     uint32 `json:"index"`
+	// Prefix and Suffix are the characters the two share at each end,
+	// past the fixed lead.
+	Prefix int `json:"prefix"`
+	Suffix int `json:"suffix"`
+}
+
+// walletAddress is a derived address with its position in the wallet.
+type walletAddress struct {
+	address string
+	change  bool
+	index   uint32
+}
+
+// checkLookalikes compares every address found in text with the first
+// count receive and change addresses of spec.
+func checkLookalikes(spec *WalletSpec, text string, count, prefix, suffix int) (*LookalikeReport, error) {
+	net := getNetwork(spec.Network)
+	found := extractAddresses(text, net)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no %s addresses were found in the input", spec.Network)
+	}
+
+	own := make(map[string]bool, 2*count)
+	wallet := make([]walletAddress, 0, 2*count)
+	for _, change := range []bool{false, true} {
+		for i := 0; i < count; i++ {
+			address, err := spec.deriveAddress(change, uint32(i))
+			if err != nil {
+				return nil, err
+			}
+			own[address] = true
+			wallet = append(wallet, walletAddress{address: address, change: change, index: uint32(i)})
+		}
+	}
+
+	report := &LookalikeReport{
+		Network:    spec.Network,
+		Derived:    count,
+		Addresses:  len(found),
+		Prefix:     prefix,
+		Suffix:     suffix,
+		Lookalikes: []Lookalike{},
+	}
+	for _, address := range found {
+		if own[address] {
+			report.Own++
+			continue
+		}
+		report.Foreign++
+		for _, w := range wallet {
+			p, s := sharedEnds(address, w.address, net)
+			if p >= prefix && s >= suffix {
+				report.Lookalikes = append(report.Lookalikes, Lookalike{Address: address, Resembles: w.address, Change: w.change, Index: w.index, Prefix: p, Suffix: s})
+			}
+		}
+	}
+	report.Clean = len(report.Lookalikes) == 0
+	return report, nil
+}
+
+// extractAddresses returns the distinct addresses for net among the words
+// of text, in the order they first appear.
+func extractAddresses(text string, net *chaincfg.Params) []string {
+	seen := map[string]bool{}
+	var addresses []string
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	})
+	for _, w := range words {
+		addr, err := btcutil.DecodeAddress(w, net)
+		if err != nil || !addr.IsForNet(net) {
+			continue
+		}
+		address := addr.EncodeAddress()
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// addressLead returns the length of the part of an address fixed by its
+// type: the human-readable part, separator and witness version of a
+// segwit address, the version character of a base58 one. Addresses are
+// in their canonical, lowercase bech32 encoding.
+func addressLead(address string, net *chaincfg.Params) int {
+	if hrp := net.Bech32HRPSegwit + "1"; strings.HasPrefix(address, hrp) {
+		return len(hrp) + 1
+	}
+	return 1
+}
+
+// sharedEnds returns how many characters a and b share at their start,
+// past their lead, and at their end. Addresses of different types share
+// nothing.
+func sharedEnds(a, b string, net *chaincfg.Params) (int, int) {
+	lead := addressLead(a, net)
+	if lead != addressLead(b, net) || a[:lead] != b[:lead] {
+		return 0, 0
+	}
+	a, b = a[lead:], b[lead:]
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// readLookalikeInput reads check-lookalikes' input; "-" reads stdin.
+func readLookalikeInput(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read address history: %v", err)
+	}
+	return string(data), nil
+}
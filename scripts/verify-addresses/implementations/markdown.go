@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// --format markdown renders any result document as GitHub-flavoured
+// Markdown, for pasting into a wiki page or a ticket. It goes through the
+// document's JSON form, as --format cbor does: the scalar fields become a
+// field/value table in declaration order, and each list of objects (the
+// addresses of a report, its checks or cosigners) a table of its own under
+// a heading named after the field.
+
+// mdMember is one member of a JSON object, which is kept in document order.
+type mdMember struct {
+	key   string
+	value interface{}
+}
+
+type mdObject []mdMember
+
+// writeMarkdown writes v as Markdown.
+func writeMarkdown(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	doc, err := decodeOrdered(dec)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	switch doc := doc.(type) {
+	case mdObject:
+		renderMarkdownObject(&b, doc, 2)
+	case []interface{}:
+		renderMarkdownList(&b, "Results", doc, 2)
+	default:
+		b.WriteString(markdownCell(doc) + "\n")
+	}
+	_, err = fmt.Fprint(stdout, strings.TrimRight(b.String(), "\n")+"\n")
+	return err
+}
+
+// decodeOrdered decodes the next JSON value, keeping object members in
+// order.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := mdObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, mdMember{key: key.(string), value: value})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+// renderMarkdownObject writes an object's scalar members as a table, then
+// each nested object and list of objects under a heading of the given
+// level.
+func renderMarkdownObject(b *strings.Builder, obj mdObject, level int) {
+	var fields mdObject
+	var sections []mdMember
+	for _, m := range obj {
+		switch value := m.value.(type) {
+		case mdObject:
+			sections = append(sections, m)
+		case []interface{}:
+			if len(value) > 0 && isObjectList(value) {
+				sections = append(sections, m)
+			} else {
+				fields = append(fields, m)
+			}
+		default:
+			fields = append(fields, m)
+		}
+	}
+	if len(fields) > 0 {
+		b.WriteString("| Field | Value |\n| --- | --- |\n")
+		for _, m := range fields {
+			fmt.Fprintf(b, "| %s | %s |\n", markdownTitle(m.key), markdownCell(m.value))
+		}
+		b.WriteString("\n")
+	}
+	for _, m := range sections {
+		if list, ok := m.value.([]interface{}); ok {
+			renderMarkdownList(b, markdownTitle(m.key), list, level)
+			continue
+		}
+		fmt.Fprintf(b, "%s %s\n\n", strings.Repeat("#", level), markdownTitle(m.key))
+		renderMarkdownObject(b, m.value.(mdObject), min(level+1, 6))
+	}
+}
+
+// renderMarkdownList writes a list of objects as one table, with a column
+// for every member any of them has.
+func renderMarkdownList(b *strings.Builder, title string, list []interface{}, level int) {
+	fmt.Fprintf(b, "%s %s\n\n", strings.Repeat("#", level), title)
+	if !isObjectList(list) {
+		for _, item := range list {
+			fmt.Fprintf(b, "- %s\n", markdownCell(item))
+		}
+		b.WriteString("\n")
+		return
+	}
+	var columns []string
+	seen := map[string]bool{}
+	for _, item := range list {
+		for _, m := range item.(mdObject) {
+			if !seen[m.key] {
+				seen[m.key] = true
+				columns = append(columns, m.key)
+			}
+		}
+	}
+	titles := make([]string, len(columns))
+	for i, c := range columns {
+		titles[i] = markdownTitle(c)
+	}
+	b.WriteString("| " + strings.Join(titles, " | ") + " |\n")
+	b.WriteString(strings.Repeat("| --- ", len(columns)) + "|\n")
+	for _, item := range list {
+		cells := make([]string, len(columns))
+		for _, m := range item.(mdObject) {
+			for i, c := range columns {
+				if c == m.key {
+					cells[i] = markdownCell(m.value)
+				}
+			}
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	b.WriteString("\n")
+}
+
+func isObjectList(list []interface{}) bool {
+	for _, item := range list {
+		if _, ok := item.(mdObject); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// markdownTitle turns a JSON member name into a heading: "script_type"
+// becomes "Script type".
+func markdownTitle(key string) string {
+	title := []rune(strings.ReplaceAll(key, "_", " "))
+	if len(title) > 0 {
+		title[0] = unicode.ToUpper(title[0])
+	}
+	return string(title)
+}
+
+// markdownCell renders a value for a table cell. Addresses, keys and
+// descriptors go in code spans, so their underscores and asterisks are not
+// read as emphasis; anything nested is shown as compact JSON.
+func markdownCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case json.Number:
+		return v.String()
+	case string:
+		if v == "" {
+			return ""
+		}
+		if strings.IndexFunc(v, unicode.IsSpace) < 0 {
+			return markdownCode(v)
+		}
+		return markdownEscape(v)
+	case []interface{}:
+		if len(v) == 0 {
+			return ""
+		}
+		if !isObjectList(v) {
+			cells := make([]string, len(v))
+			for i, item := range v {
+				cells[i] = markdownCell(item)
+			}
+			return strings.Join(cells, ", ")
+		}
+	}
+	return markdownCode(compactOrdered(v))
+}
+
+// markdownCode wraps s in a code span, with a delimiter longer than any
+// run of backticks in it.
+func markdownCode(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if longest > 0 {
+		s = " " + s + " "
+	}
+	return fence + strings.ReplaceAll(s, "|", "\\|") + fence
+}
+
+// markdownEscape escapes prose for a table cell.
+func markdownEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', '`', '*', '_', '[', ']', '<', '>', '|', '#':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString("<br>")
+		case '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// compactOrdered renders a decoded value back as compact JSON, members in
+// order.
+func compactOrdered(v interface{}) string {
+	switch v := v.(type) {
+	case mdObject:
+		parts := make([]string, len(v))
+		for i, m := range v {
+			key, _ := json.Marshal(m.key)
+			parts[i] = string(key) + ":" + compactOrdered(m.value)
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = compactOrdered(item)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case json.Number:
+		return v.String()
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,106 @@
+package main
+
+// Moving funds from an old wallet to a new one of another script type is
+// planned address by address. migrate-map pairs each used index of the old
+// wallet with the new wallet's address at the same chain and index, which
+// documents where each balance goes and lets the sweep be checked against
+// it afterwards. With --range it derives offline; without, it scans the old
+// wallet through the configured backend for its used range, as balance
+// does.
+
+// MigrationReport pairs an old wallet's addresses with a new wallet's.
+type MigrationReport struct {
+	Network string          `json:"network"`
+	From    MigrationWallet `json:"from"`
+	To      MigrationWallet `json:"to"`
+	// Receive and Change are the index ranges paired on each chain; a
+	// chain of the old wallet with no used address has none.
+	Receive *indexRange     `json:"receive,omitempty"`
+	Change  *indexRange     `json:"change,omitempty"`
+	Pairs   []MigrationPair `json:"pairs"`
+}
+
+// MigrationWallet is one side of a migration.
+type MigrationWallet struct {
+	Name       string `json:"name,omitempty"`
+	ScriptType string `json:"script_type"`
+	Descriptor string `json:"descriptor,omitempty"`
+}
+
+// MigrationPair is the old and new address at one chain and index.
+type MigrationPair struct {
+	Change bool   `json:"change"`
+	Index  uint32 `json:"index"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// migrationWallet describes one side of a migration.
+func migrationWallet(spec *WalletSpec) MigrationWallet {
+	w := MigrationWallet{Name: spec.Name, ScriptType: spec.ScriptType}
+	if descriptor, err := walletDescriptor(spec, false); err == nil {
+		w.Descriptor = descriptor
+	}
+	return w
+}
+
+// migrationMap pairs the addresses of from and to over ranges.
+func migrationMap(from, to *WalletSpec, ranges map[bool]*indexRange) (*MigrationReport, error) {
+	if from.Network != to.Network {
+		return nil, errorWithCode(errInvalidNetwork, "the wallets are on different networks: %s and %s", from.Network, to.Network)
+	}
+	report := &MigrationReport{
+		Network: from.Network,
+		From:    migrationWallet(from),
+		To:      migrationWallet(to),
+		Receive: ranges[false],
+		Change:  ranges[true],
+		Pairs:   []MigrationPair{},
+	}
+	for _, change := range []bool{false, true} {
+		r := ranges[change]
+		if r == nil {
+			continue
+		}
+		for i := uint64(r.Start); i <= uint64(r.End); i++ {
+			index := uint32(i)
+			old, err := from.deriveAddress(change, index)
+			if err != nil {
+				return nil, err
+			}
+			next, err := to.deriveAddress(change, index)
+			if err != nil {
+				return nil, err
+			}
+			pair := MigrationPair{Change: change, Index: index, Old: old, New: next}
+			if streaming() {
+				streamRecord("pair", pair)
+			} else {
+				report.Pairs = append(report.Pairs, pair)
+			}
+		}
+	}
+	return report, nil
+}
+
+// migrationRanges returns the old wallet's used range on each chain: r on
+// both when given, else what a gap scan through the configured backend
+// finds.
+func migrationRanges(from *WalletSpec, r *indexRange, gap int) (map[bool]*indexRange, error) {
+	if r != nil {
+		return map[bool]*indexRange{false: r, true: r}, nil
+	}
+	backend, err := openConfiguredBackend(from.Network)
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+	store, err := openConfiguredStore()
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		defer store.Close()
+	}
+	return chainRanges(from, backend, store, nil, gap)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// Restoring a seed backup is only proven by deriving the wallet from it.
+// mnemonic reads a BIP-39 mnemonic through --key-env or --key-fd, and an
+// optional BIP-39 passphrase through --bip39-passphrase-env or
+// --bip39-passphrase-fd (the global --passphrase-env decrypts specs), and
+// reports the master fingerprint, the account xpub and the first receive
+// addresses, to compare with the wallet the backup belongs to. Like bip85
+// it handles a master secret, so it refuses to run unless offline.
+
+// defaultMnemonicCount is how many addresses mnemonic derives without a
+// count.
+const defaultMnemonicCount = 10
+
+// MnemonicReport is the wallet a mnemonic restores.
+type MnemonicReport struct {
+	Words       int    `json:"words"`
+	Passphrase  bool   `json:"passphrase"`
+	Network     string `json:"network"`
+	ScriptType  string `json:"script_type"`
+	Fingerprint string `json:"fingerprint"`
+	AccountPath string `json:"account_path"`
+	AccountXpub string `json:"account_xpub"`
+	Descriptor  string `json:"descriptor"`
+	// Addresses are the first receive addresses of the account.
+	Addresses []MnemonicAddress `json:"addresses"`
+}
+
+// MnemonicAddress is one receive address with its full path.
+type MnemonicAddress struct {
+	Index   uint32 `json:"index"`
+	Path    string `json:"path"`
+	Address string `json:"address"`
+}
+
+// mnemonicRequest is a parsed mnemonic command line.
+type mnemonicRequest struct {
+	scriptType string
+	network    string
+	path       []uint32
+	count      int
+}
+
+// accountPath returns the BIP-44 style account path of a single-sig
+// script type: m/<purpose>'/<coin>'/<account>'.
+func accountPath(scriptType, network string, account uint32) ([]uint32, error) {
+	purpose, ok := purposeOfScriptType(scriptType)
+	if !ok {
+		return nil, fmt.Errorf("no BIP-44 style account for script type %q (want legacy, nested_segwit, native_segwit or taproot, or give --path)", scriptType)
+	}
+	coin := uint32(1)
+	if network == "mainnet" {
+		coin = 0
+	}
+	return []uint32{purpose + hdkeychain.HardenedKeyStart, coin + hdkeychain.HardenedKeyStart, account + hdkeychain.HardenedKeyStart}, nil
+}
+
+// restoreMnemonic derives the wallet req describes from a mnemonic and
+// passphrase.
+func restoreMnemonic(mnemonic, passphrase string, req *mnemonicRequest) (*MnemonicReport, error) {
+	words, err := normalizeMnemonic(mnemonic)
+	if err != nil {
+		return nil, errorWithCode(errInvalidMnemonic, "%v", err)
+	}
+	entropy, err := mnemonicToEntropy(words)
+	if err != nil {
+		return nil, err
+	}
+	clear(entropy)
+
+	wallet, err := restoreBIP39(words, passphrase, req)
+	if err != nil {
+		return nil, err
+	}
+	return &MnemonicReport{
+		Words:       len(words),
+		Passphrase:  passphrase != "",
+		Network:     wallet.Network,
+		ScriptType:  wallet.ScriptType,
+		Fingerprint: wallet.Fingerprint,
+		AccountPath: wallet.AccountPath,
+		AccountXpub: wallet.AccountXpub,
+		Descriptor:  wallet.Descriptor,
+		Addresses:   wallet.Addresses,
+	}, nil
+}
+
+// restoreBIP39 derives the wallet req describes from a checked, normalized
+// mnemonic and passphrase.
+func restoreBIP39(words []string, passphrase string, req *mnemonicRequest) (*RestoredWallet, error) {
+	if !isASCII(passphrase) {
+		// BIP-39 seeds are computed over the NFKD form, which the
+		// verifier does not implement; guessing would give a wallet the
+		// backup does not restore.
+		return nil, fmt.Errorf("passphrases with non-ASCII characters are not supported (BIP-39 needs their NFKD form)")
+	}
+	seed := mnemonicSeed(strings.Join(words, " "), passphrase)
+	defer clear(seed)
+	return restoreSeed(seed, req)
+}
+
+// RestoredWallet is the account a BIP-32 seed restores.
+type RestoredWallet struct {
+	Network     string            `json:"network"`
+	ScriptType  string            `json:"script_type"`
+	Fingerprint string            `json:"fingerprint"`
+	AccountPath string            `json:"account_path"`
+	AccountXpub string            `json:"account_xpub"`
+	Descriptor  string            `json:"descriptor"`
+	Addresses   []MnemonicAddress `json:"addresses"`
+}
+
+// restoreSeed derives the account req describes from a BIP-32 seed, and
+// its first receive addresses.
+func restoreSeed(seed []byte, req *mnemonicRequest) (*RestoredWallet, error) {
+	master, err := hdkeychain.NewMaster(seed, getNetwork(req.network))
+	if err != nil {
+		return nil, err
+	}
+	defer master.Zero()
+	pub, err := master.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	account, err := deriveKeyPath(master, req.path)
+	if err != nil {
+		return nil, err
+	}
+	defer account.Zero()
+	xpub, err := account.Neuter()
+	if err != nil {
+		return nil, err
+	}
+
+	wallet := &RestoredWallet{
+		Network:     req.network,
+		ScriptType:  req.scriptType,
+		Fingerprint: hex.EncodeToString(btcutil.Hash160(pub.SerializeCompressed())[:4]),
+		AccountPath: formatPath(req.path),
+		AccountXpub: xpub.String(),
+		Addresses:   []MnemonicAddress{},
+	}
+	spec := &WalletSpec{
+		Network:    req.network,
+		ScriptType: req.scriptType,
+		Keys:       []WalletKey{{Fingerprint: wallet.Fingerprint, Path: wallet.AccountPath, Xpub: wallet.AccountXpub}},
+	}
+	if wallet.Descriptor, err = walletDescriptor(spec, false); err != nil {
+		return nil, err
+	}
+	for i := 0; i < req.count; i++ {
+		index := uint32(i)
+		address, err := spec.deriveAddress(false, index)
+		if err != nil {
+			return nil, err
+		}
+		wallet.Addresses = append(wallet.Addresses, MnemonicAddress{Index: index, Path: wallet.AccountPath + "/" + chainIndex(false, index), Address: address})
+	}
+	return wallet, nil
+}
@@ -0,0 +1,1000 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// monitor watches the addresses of a wallet on the configured backend and
+// writes an event, one JSON object per line, whenever one of them receives
+// an output, an output confirms, or an output is spent: enough for simple
+// deposit detection without an indexer. The backend is polled; anything
+// that can tell monitor to look again sooner wakes the loop early.
+//
+// With the core section's zmq_rawtx and zmq_rawblock set, monitor also
+// subscribes to the node's ZMQ notifications and matches each transaction
+// and block against the watched scripts as it arrives, so events come in
+// real time and polling is only a fallback. A lost connection or a gap in
+// Core's sequence numbers makes monitor poll straight away, since
+// notifications may have been missed.
+//
+// An unconfirmed receive can also leave the mempool without confirming.
+// When its transaction drops out of the address history, monitor reports
+// it as "replaced" if it can name the replacement, and otherwise as
+// "evicted", instead of as a spend. The ZMQ rawtx stream shows the inputs
+// of unconfirmed transactions, so a conflicting transaction is caught the
+// moment it arrives; a poll can only guess at a replacement from a new
+// output of the same value to the same address, which is what a fee bump
+// looks like.
+//
+// Risk rules treat replaceable and fee-bumped deposits differently, so
+// events of unconfirmed outputs say whether their transaction signals
+// replaceability (BIP 125), as far as the ZMQ stream or the backend's
+// transaction lookup shows, and a "cpfp" event reports the first child
+// seen spending an output of an unconfirmed transaction paying the wallet.
+// A poll only sees children that spend the wallet's own outputs; the
+// stream sees children of any output.
+//
+// Without --range, monitor watches each chain up to its last used index
+// plus the gap limit, and moves that window on as addresses get used, so
+// deposits to the next fresh address are seen. With --state, what monitor
+// has seen is saved after every poll, and a restarted monitor reports what
+// happened while it was down; without it, the first poll only takes a
+// baseline.
+//
+// With --confirmations <n>, each output is tracked until it is n blocks
+// deep: a "confirmations" event comes at each --thresholds depth on the
+// way, and a final "settled" event at n, so downstream accounting can key
+// off the one stream.
+//
+// Webhooks from the config's "webhooks" list are sinks of the event stream:
+// each is posted the events it subscribes to, and, if it sets a
+// confirmation depth, a "confirmations" event when an output reaches it.
+
+// defaultMonitorInterval is how often monitor polls the backend.
+const defaultMonitorInterval = 30 * time.Second
+
+// defaultStreamInterval is how often monitor polls when ZMQ notifications
+// bring the events.
+const defaultStreamInterval = 10 * time.Minute
+
+// Monitor event types.
+const (
+	eventReceived  = "received"
+	eventConfirmed = "confirmed"
+	eventSpent     = "spent"
+	eventReplaced  = "replaced"
+	eventEvicted   = "evicted"
+	eventCPFP      = "cpfp"
+	eventSettled   = "settled"
+)
+
+// MonitorEvent is one line of monitor output. Height is 0 and
+// Confirmations 0 while the transaction is in the mempool.
+type MonitorEvent struct {
+	Type          string `json:"type"`
+	Time          string `json:"time"`
+	WalletID      string `json:"wallet_id"`
+	Change        bool   `json:"change"`
+	Index         uint32 `json:"index"`
+	Address       string `json:"address"`
+	TxID          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Value         int64  `json:"value"`
+	Height        int64  `json:"height,omitempty"`
+	Confirmations int64  `json:"confirmations"`
+	// SpentBy is the spending transaction of a spent event, when the
+	// address's history shows which one it was.
+	SpentBy string `json:"spent_by,omitempty"`
+	// ReplacedBy is the transaction that took the place of a replaced
+	// event's.
+	ReplacedBy string `json:"replaced_by,omitempty"`
+	// RBF tells, while the transaction is unconfirmed, whether it signals
+	// replaceability; it is left out when that is not known.
+	RBF *bool `json:"rbf,omitempty"`
+	// Child is the transaction of a cpfp event spending the unconfirmed
+	// one, when known.
+	Child string `json:"child,omitempty"`
+	// Depth is the confirmation depth a confirmations or settled event
+	// was sent for.
+	Depth int64 `json:"depth,omitempty"`
+}
+
+// MonitorReport is what monitor writes when it stops.
+type MonitorReport struct {
+	WalletID  string `json:"wallet_id"`
+	Polls     int    `json:"polls"`
+	Events    int    `json:"events"`
+	Addresses int    `json:"addresses"`
+	Outputs   int    `json:"unspent_outputs"`
+	TipHeight int64  `json:"tip_height"`
+	// Unconfirmed counts the unspent outputs still in the mempool.
+	Unconfirmed int `json:"unconfirmed_outputs"`
+}
+
+// monitorState is what monitor has seen, as saved to --state.
+type monitorState struct {
+	WalletID string `json:"wallet_id"`
+	// Outputs are the unspent outputs of watched addresses, by outpoint.
+	Outputs map[string]monitoredOutput `json:"outputs"`
+	// History holds the transactions seen on each address.
+	History map[string][]string `json:"history"`
+	// LastUsed is the highest used index of each chain, -1 for none.
+	LastUsed [2]int64 `json:"last_used"`
+	// TipHeight is the chain tip at the last poll.
+	TipHeight int64 `json:"tip_height,omitempty"`
+	// Pending holds the spent outpoints of the unconfirmed transactions
+	// paying watched addresses, as far as the ZMQ stream has shown them.
+	Pending map[string][]string `json:"pending,omitempty"`
+	// Children maps unconfirmed transactions paying watched addresses to
+	// the first child seen spending them.
+	Children map[string]string `json:"children,omitempty"`
+}
+
+type monitoredOutput struct {
+	Change  bool   `json:"change"`
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+	TxID    string `json:"txid"`
+	Vout    uint32 `json:"vout"`
+	Value   int64  `json:"value"`
+	Height  int64  `json:"height"`
+	RBF     *bool  `json:"rbf,omitempty"`
+}
+
+// watchedAddress is an address monitor looks up.
+type watchedAddress struct {
+	change  bool
+	index   uint32
+	address string
+}
+
+type addressMonitor struct {
+	spec      *WalletSpec
+	backend   ChainBackend
+	walletID  string
+	r         *indexRange
+	gap       int
+	interval  time.Duration
+	statePath string
+	state     *monitorState
+	// baseline is set until the first poll without saved state, whose
+	// outputs are recorded without events.
+	baseline bool
+	watched  []watchedAddress
+	// scripts maps the hex scriptPubKey of each watched address to its
+	// index in watched.
+	scripts map[string]int
+	events  io.Writer
+	// sinks receive every event after it is written.
+	sinks []func(MonitorEvent)
+	// depths are the confirmation depths sinks are told of, beyond the
+	// stream's first confirmation.
+	depths []int64
+	// thresholds are the depths with a confirmations event in the stream,
+	// and settle the depth of the settled event, 0 for none.
+	thresholds []int64
+	settle     int64
+	// wake makes the loop poll before the interval is up.
+	wake chan struct{}
+	// zmq maps Core notification topics to their endpoints, and stream
+	// carries their messages to the loop.
+	zmq    map[string]string
+	stream chan zmqMessage
+	report MonitorReport
+}
+
+func newAddressMonitor(q *chainQuery, flags map[string]string) (*addressMonitor, error) {
+	id, err := walletID(q.spec)
+	if err != nil {
+		return nil, err
+	}
+	m := &addressMonitor{
+		spec:      q.spec,
+		backend:   q.backend,
+		walletID:  id,
+		r:         q.r,
+		gap:       q.gap,
+		interval:  defaultMonitorInterval,
+		statePath: flags["state"],
+		scripts:   map[string]int{},
+		events:    stdout,
+		wake:      make(chan struct{}, 1),
+		zmq:       map[string]string{},
+		report:    MonitorReport{WalletID: id},
+	}
+	cfg, err := loadBackendConfig(options.configPath)
+	if err != nil {
+		return nil, err
+	}
+	for topic, endpoint := range map[string]string{"rawtx": cfg.Core.ZMQRawTx, "rawblock": cfg.Core.ZMQRawBlock} {
+		if endpoint != "" {
+			m.zmq[topic] = endpoint
+		}
+	}
+	if len(m.zmq) > 0 {
+		// scantxoutset sees confirmed outputs only, so polls would undo
+		// what mempool notifications report.
+		if cfg.Backend == "core" && cfg.Core.Wallet == "" {
+			return nil, fmt.Errorf("ZMQ notifications need the Core backend's watch-only wallet (set core.wallet, see provision-core)")
+		}
+		m.interval = defaultStreamInterval
+	}
+	if v, ok := flags["interval"]; ok {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			return nil, errorWithCode(errInvalidCount, "invalid poll interval: %q", v)
+		}
+		m.interval = time.Duration(seconds) * time.Second
+	}
+	if v, ok := flags["confirmations"]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return nil, errorWithCode(errInvalidCount, "invalid confirmation depth: %q", v)
+		}
+		m.settle = n
+	}
+	if v, ok := flags["thresholds"]; ok {
+		for _, field := range strings.Split(v, ",") {
+			depth, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil || depth < 1 || m.settle > 0 && depth >= m.settle {
+				return nil, errorWithCode(errInvalidCount, "invalid confirmation threshold: %q", field)
+			}
+			m.thresholds = append(m.thresholds, depth)
+		}
+		m.depths = append(m.depths, m.thresholds...)
+	}
+	if path, ok := flags["events"]; ok {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event log: %v", err)
+		}
+		m.events = f
+	}
+	if err := openWebhooks(m, cfg); err != nil {
+		return nil, err
+	}
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+	if err := m.extend(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loadState reads --state, or starts from a gap scan of each chain.
+func (m *addressMonitor) loadState() error {
+	if m.statePath != "" {
+		data, err := os.ReadFile(m.statePath)
+		if err == nil {
+			var state monitorState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return fmt.Errorf("failed to parse monitor state %s: %v", m.statePath, err)
+			}
+			if state.WalletID != m.walletID {
+				return fmt.Errorf("monitor state %s is of wallet %s, not %s", m.statePath, state.WalletID, m.walletID)
+			}
+			if state.Pending == nil {
+				state.Pending = map[string][]string{}
+			}
+			if state.Children == nil {
+				state.Children = map[string]string{}
+			}
+			m.state = &state
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read monitor state: %v", err)
+		}
+	}
+	m.baseline = true
+	m.state = &monitorState{WalletID: m.walletID, Outputs: map[string]monitoredOutput{}, History: map[string][]string{}, Pending: map[string][]string{}, Children: map[string]string{}, LastUsed: [2]int64{-1, -1}}
+	if m.r != nil {
+		return nil
+	}
+	for i, change := range []bool{false, true} {
+		scan, err := scanChain(m.spec, m.backend, nil, change, m.gap)
+		if err != nil {
+			return err
+		}
+		m.state.LastUsed[i] = scan.LastUsed
+	}
+	return nil
+}
+
+// extend derives the addresses the window now covers.
+func (m *addressMonitor) extend() error {
+	for i, change := range []bool{false, true} {
+		start, end := int64(0), m.state.LastUsed[i]+int64(m.gap)
+		if m.r != nil {
+			start, end = int64(m.r.Start), int64(m.r.End)
+		}
+		var have int64 = -1
+		for _, w := range m.watched {
+			if w.change == change {
+				have = max(have, int64(w.index))
+			}
+		}
+		for index := max(start, have+1); index <= end; index++ {
+			address, err := m.spec.deriveAddress(change, uint32(index))
+			if err != nil {
+				return err
+			}
+			script, err := addressScript(address, m.spec.Network)
+			if err != nil {
+				return err
+			}
+			m.scripts[hex.EncodeToString(script)] = len(m.watched)
+			m.watched = append(m.watched, watchedAddress{change: change, index: uint32(index), address: address})
+		}
+	}
+	m.report.Addresses = len(m.watched)
+	return nil
+}
+
+// run polls until SIGTERM or SIGINT, or once.
+func (m *addressMonitor) run(once bool) error {
+	stop := make(chan os.Signal, 1)
+	if !once {
+		signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+		defer signal.Stop(stop)
+		diagnostic("monitoring %d addresses of wallet %s every %s", len(m.watched), m.walletID, m.interval)
+	}
+	if err := m.poll(); err != nil {
+		if once {
+			return err
+		}
+		diagnostic("poll failed: %v", err)
+	}
+	if once {
+		return nil
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if len(m.zmq) > 0 {
+		m.stream = make(chan zmqMessage)
+		for topic, endpoint := range m.zmq {
+			go m.subscribe(endpoint, topic, done)
+		}
+	}
+	timer := time.NewTimer(m.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case msg := <-m.stream:
+			if err := m.applyStream(msg); err != nil {
+				diagnostic("%s notification: %v", msg.topic, err)
+			}
+			continue
+		case <-m.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		if err := m.poll(); err != nil {
+			// A backend that is down for a while is not the end of
+			// monitoring; the next poll tries again.
+			diagnostic("poll failed: %v", err)
+		}
+		timer.Reset(m.interval)
+	}
+}
+
+// requestPoll wakes the loop for a poll, unless one is already due.
+func (m *addressMonitor) requestPoll() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe feeds one topic's notifications to the loop until done,
+// reconnecting when the connection drops.
+func (m *addressMonitor) subscribe(endpoint, topic string, done chan struct{}) {
+	backoff := time.Second
+	for connected := false; ; {
+		sub, err := dialZMQ(endpoint, topic)
+		if err != nil {
+			diagnostic("ZMQ %s: %v; retrying in %s", topic, err, backoff)
+			select {
+			case <-done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, time.Minute)
+			continue
+		}
+		if connected {
+			m.requestPoll()
+		}
+		connected, backoff = true, time.Second
+		var next uint32
+		for first := true; ; first = false {
+			msg, err := sub.Receive()
+			if err != nil {
+				diagnostic("ZMQ %s: %v; reconnecting", topic, err)
+				break
+			}
+			if msg.topic != topic {
+				continue
+			}
+			// Sequence numbers wrap, and so does the gap.
+			missed := msg.seq - next
+			if first {
+				missed = 0
+			}
+			next = msg.seq + 1
+			select {
+			case m.stream <- msg:
+			case <-done:
+				sub.Close()
+				return
+			}
+			if missed > 0 {
+				diagnostic("ZMQ %s: missed %d notifications", topic, missed)
+				m.requestPoll()
+			}
+		}
+		sub.Close()
+	}
+}
+
+// poll looks up every watched address and emits the events since the last
+// poll.
+func (m *addressMonitor) poll() error {
+	tip, err := m.backend.TipHeight()
+	if err != nil {
+		return err
+	}
+	addresses := make([]string, len(m.watched))
+	for i, w := range m.watched {
+		addresses[i] = w.address
+	}
+	histories, err := lookupHistories(m.backend, addresses)
+	if err != nil {
+		return err
+	}
+	utxoSets, err := lookupUTXOs(m.backend, addresses)
+	if err != nil {
+		return err
+	}
+
+	event := func(eventType string, o monitoredOutput) MonitorEvent {
+		return m.event(eventType, o, tip)
+	}
+	var events, deep []MonitorEvent
+	current := map[string]monitoredOutput{}
+	newTxs := map[string][]string{}
+	live := map[string]bool{}
+	rbf := map[string]*bool{}
+	for i, w := range m.watched {
+		seen := map[string]bool{}
+		for _, txid := range m.state.History[w.address] {
+			seen[txid] = true
+		}
+		for _, ref := range histories[i] {
+			live[ref.TxID] = true
+			if !seen[ref.TxID] {
+				seen[ref.TxID] = true
+				newTxs[w.address] = append(newTxs[w.address], ref.TxID)
+				m.state.History[w.address] = append(m.state.History[w.address], ref.TxID)
+			}
+		}
+		if len(histories[i]) > 0 {
+			chain := 0
+			if w.change {
+				chain = 1
+			}
+			m.state.LastUsed[chain] = max(m.state.LastUsed[chain], int64(w.index))
+		}
+		for _, u := range utxoSets[i] {
+			o := monitoredOutput{Change: w.change, Index: w.index, Address: w.address, TxID: u.TxID, Vout: u.Vout, Value: u.Value, Height: u.Height}
+			key := outpointKey(u.TxID, u.Vout)
+			previous, known := m.state.Outputs[key]
+			if o.RBF = previous.RBF; o.Height == 0 && o.RBF == nil {
+				o.RBF = m.signalsRBF(u.TxID, rbf)
+			}
+			current[key] = o
+			switch {
+			case !known:
+				events = append(events, event(eventReceived, o))
+			case previous.Height == 0 && o.Height > 0:
+				events = append(events, event(eventConfirmed, o))
+			}
+			deep = append(deep, m.depthsReached(event(eventConfirmations, o), previous, known)...)
+		}
+	}
+	for key, o := range m.state.Outputs {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if o.Height == 0 && !live[o.TxID] {
+			events = append(events, m.dropped(o, tip, m.replacement(o, current)))
+			continue
+		}
+		e := event(eventSpent, o)
+		e.Height, e.Confirmations = 0, 0
+		var spenders []string
+		for _, txid := range newTxs[o.Address] {
+			if txid != o.TxID {
+				spenders = append(spenders, txid)
+			}
+		}
+		if len(spenders) == 1 {
+			e.SpentBy = spenders[0]
+		}
+		if _, seen := m.state.Children[o.TxID]; o.Height == 0 && !seen {
+			c := event(eventCPFP, o)
+			c.Child = e.SpentBy
+			m.state.Children[o.TxID] = e.SpentBy
+			events = append(events, c)
+		}
+		events = append(events, e)
+	}
+	m.state.Outputs = current
+	m.prunePending()
+
+	if m.baseline {
+		m.baseline = false
+		events, deep = nil, nil
+		diagnostic("baseline: %d unspent outputs on %d addresses at height %d", len(current), len(m.watched), tip)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return eventOrder(events[i]) < eventOrder(events[j]) })
+	for _, e := range events {
+		if err := m.emit(e); err != nil {
+			return err
+		}
+	}
+	if err := m.emitDepths(deep); err != nil {
+		return err
+	}
+	m.state.TipHeight = tip
+	m.report.Polls++
+	m.countOutputs()
+	m.report.TipHeight = tip
+	if err := m.saveState(); err != nil {
+		return err
+	}
+	return m.extend()
+}
+
+// event describes an output at a chain tip.
+func (m *addressMonitor) event(eventType string, o monitoredOutput, tip int64) MonitorEvent {
+	e := MonitorEvent{
+		Type: eventType, Time: time.Now().UTC().Format(time.RFC3339), WalletID: m.walletID,
+		Change: o.Change, Index: o.Index, Address: o.Address,
+		TxID: o.TxID, Vout: o.Vout, Value: o.Value, Height: o.Height,
+	}
+	if o.Height > 0 {
+		e.Confirmations = tip - o.Height + 1
+	} else {
+		e.RBF = o.RBF
+	}
+	return e
+}
+
+// applyStream matches a ZMQ notification against the watched scripts and
+// emits its events, as the next poll would have.
+func (m *addressMonitor) applyStream(msg zmqMessage) error {
+	if m.baseline {
+		return nil
+	}
+	var events, deep []MonitorEvent
+	switch msg.topic {
+	case "rawtx":
+		var tx wire.MsgTx
+		if err := tx.Deserialize(bytes.NewReader(msg.body)); err != nil {
+			return fmt.Errorf("undecodable transaction: %v", err)
+		}
+		events = m.applyTx(&tx, 0, m.state.TipHeight)
+	case "rawblock":
+		var block wire.MsgBlock
+		if err := block.Deserialize(bytes.NewReader(msg.body)); err != nil {
+			return fmt.Errorf("undecodable block: %v", err)
+		}
+		height, err := m.blockHeight(&block)
+		if err != nil {
+			return err
+		}
+		tip := max(height, m.state.TipHeight)
+		for _, tx := range block.Transactions {
+			events = append(events, m.applyTx(tx, height, tip)...)
+		}
+		for _, o := range m.state.Outputs {
+			deep = append(deep, m.depthsReached(m.event(eventConfirmations, o, tip), o, true)...)
+		}
+		m.state.TipHeight = tip
+		m.report.TipHeight = tip
+	}
+	sort.SliceStable(events, func(i, j int) bool { return eventOrder(events[i]) < eventOrder(events[j]) })
+	for _, e := range events {
+		if err := m.emit(e); err != nil {
+			return err
+		}
+	}
+	if err := m.emitDepths(deep); err != nil {
+		return err
+	}
+	if len(events) == 0 && len(deep) == 0 && msg.topic == "rawtx" {
+		return nil
+	}
+	m.prunePending()
+	m.countOutputs()
+	if err := m.saveState(); err != nil {
+		return err
+	}
+	return m.extend()
+}
+
+// applyTx records what a transaction, in the mempool at height 0 or mined
+// at height, does to the watched outputs, and returns the events.
+func (m *addressMonitor) applyTx(tx *wire.MsgTx, height, tip int64) []MonitorEvent {
+	txid := tx.TxHash().String()
+	var events []MonitorEvent
+	spends := map[string]bool{}
+	for _, in := range tx.TxIn {
+		spends[outpointKey(in.PreviousOutPoint.Hash.String(), in.PreviousOutPoint.Index)] = true
+	}
+	for pending, inputs := range m.state.Pending {
+		if pending == txid {
+			continue
+		}
+		for _, input := range inputs {
+			if spends[input] {
+				events = append(events, m.replaced(pending, txid, tip)...)
+				break
+			}
+		}
+	}
+	record := func(address string) {
+		for _, seen := range m.state.History[address] {
+			if seen == txid {
+				return
+			}
+		}
+		m.state.History[address] = append(m.state.History[address], txid)
+	}
+	if height == 0 {
+		for _, in := range tx.TxIn {
+			events = append(events, m.child(in.PreviousOutPoint.Hash.String(), txid, tip)...)
+		}
+	}
+	for _, in := range tx.TxIn {
+		key := outpointKey(in.PreviousOutPoint.Hash.String(), in.PreviousOutPoint.Index)
+		o, ok := m.state.Outputs[key]
+		if !ok {
+			continue
+		}
+		delete(m.state.Outputs, key)
+		record(o.Address)
+		e := m.event(eventSpent, o, tip)
+		e.Height, e.Confirmations, e.SpentBy = 0, 0, txid
+		events = append(events, e)
+	}
+	paid := false
+	for vout, out := range tx.TxOut {
+		i, ok := m.scripts[hex.EncodeToString(out.PkScript)]
+		if !ok {
+			continue
+		}
+		w := m.watched[i]
+		paid = true
+		record(w.address)
+		chain := 0
+		if w.change {
+			chain = 1
+		}
+		m.state.LastUsed[chain] = max(m.state.LastUsed[chain], int64(w.index))
+		key := outpointKey(txid, uint32(vout))
+		o, known := m.state.Outputs[key]
+		switch {
+		case !known:
+			o = monitoredOutput{Change: w.change, Index: w.index, Address: w.address, TxID: txid, Vout: uint32(vout), Value: out.Value, Height: height}
+			if height == 0 {
+				signals := replaceable(tx)
+				o.RBF = &signals
+			}
+			events = append(events, m.event(eventReceived, o, tip))
+		case o.Height == 0 && height > 0:
+			o.Height = height
+			events = append(events, m.event(eventConfirmed, o, tip))
+		}
+		m.state.Outputs[key] = o
+	}
+	if height > 0 {
+		delete(m.state.Pending, txid)
+	} else if paid {
+		inputs := make([]string, 0, len(spends))
+		for input := range spends {
+			inputs = append(inputs, input)
+		}
+		sort.Strings(inputs)
+		m.state.Pending[txid] = inputs
+	}
+	return events
+}
+
+// child returns the cpfp events of an unconfirmed parent transaction paying
+// watched addresses, the first time a child spending it is seen.
+func (m *addressMonitor) child(parent, child string, tip int64) []MonitorEvent {
+	if _, seen := m.state.Children[parent]; seen {
+		return nil
+	}
+	var events []MonitorEvent
+	for _, o := range m.state.Outputs {
+		if o.TxID == parent && o.Height == 0 {
+			e := m.event(eventCPFP, o, tip)
+			e.Child = child
+			events = append(events, e)
+		}
+	}
+	if len(events) > 0 {
+		m.state.Children[parent] = child
+	}
+	return events
+}
+
+// signalsRBF looks up whether an unconfirmed transaction signals
+// replaceability, caching the answer for the poll. It returns nil when the
+// backend cannot tell.
+func (m *addressMonitor) signalsRBF(txid string, cache map[string]*bool) *bool {
+	if signals, ok := cache[txid]; ok {
+		return signals
+	}
+	var signals *bool
+	tx, err := fetchTransaction(m.backend, txid)
+	switch {
+	case err == nil:
+		s := replaceable(tx)
+		signals = &s
+	case !errors.Is(err, errNoTransactions):
+		diagnostic("cannot tell whether %s signals RBF: %v", txid, err)
+	}
+	cache[txid] = signals
+	return signals
+}
+
+// replaceable reports whether a transaction signals BIP 125
+// replaceability: an input sequence number below 0xfffffffe.
+func replaceable(tx *wire.MsgTx) bool {
+	for _, in := range tx.TxIn {
+		if in.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// replaced drops the outputs of an unconfirmed transaction that a
+// conflicting one has replaced, and returns their events.
+func (m *addressMonitor) replaced(txid, by string, tip int64) []MonitorEvent {
+	var events []MonitorEvent
+	for key, o := range m.state.Outputs {
+		if o.TxID == txid && o.Height == 0 {
+			delete(m.state.Outputs, key)
+			events = append(events, m.dropped(o, tip, by))
+		}
+	}
+	delete(m.state.Pending, txid)
+	return events
+}
+
+// dropped returns the event of an unconfirmed output whose transaction
+// left the mempool, replaced by another or evicted. The transaction is
+// forgotten, so it is received again should it come back.
+func (m *addressMonitor) dropped(o monitoredOutput, tip int64, replacedBy string) MonitorEvent {
+	e := m.event(eventEvicted, o, tip)
+	if replacedBy != "" {
+		e.Type, e.ReplacedBy = eventReplaced, replacedBy
+	}
+	history := m.state.History[o.Address][:0]
+	for _, txid := range m.state.History[o.Address] {
+		if txid != o.TxID {
+			history = append(history, txid)
+		}
+	}
+	m.state.History[o.Address] = history
+	delete(m.state.Pending, o.TxID)
+	return e
+}
+
+// replacement guesses which transaction replaced a vanished unconfirmed
+// output: one newly paying the same value to the same address.
+func (m *addressMonitor) replacement(o monitoredOutput, current map[string]monitoredOutput) string {
+	var candidates []string
+	for key, c := range current {
+		if _, known := m.state.Outputs[key]; !known && c.Address == o.Address && c.Value == o.Value && c.TxID != o.TxID {
+			candidates = append(candidates, c.TxID)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return ""
+}
+
+// prunePending forgets the inputs and children of transactions that no
+// longer have unconfirmed outputs.
+func (m *addressMonitor) prunePending() {
+	unconfirmed := map[string]bool{}
+	for _, o := range m.state.Outputs {
+		if o.Height == 0 {
+			unconfirmed[o.TxID] = true
+		}
+	}
+	for txid := range m.state.Pending {
+		if !unconfirmed[txid] {
+			delete(m.state.Pending, txid)
+		}
+	}
+	for txid := range m.state.Children {
+		if !unconfirmed[txid] {
+			delete(m.state.Children, txid)
+		}
+	}
+}
+
+func (m *addressMonitor) countOutputs() {
+	m.report.Outputs, m.report.Unconfirmed = len(m.state.Outputs), 0
+	for _, o := range m.state.Outputs {
+		if o.Height == 0 {
+			m.report.Unconfirmed++
+		}
+	}
+}
+
+// blockHeight reads a block's height from its coinbase, or takes the
+// backend's tip when the coinbase does not give it.
+func (m *addressMonitor) blockHeight(block *wire.MsgBlock) (int64, error) {
+	if height, ok := coinbaseHeight(block); ok {
+		return height, nil
+	}
+	return m.backend.TipHeight()
+}
+
+// coinbaseHeight reads a block's height from the first push of its
+// coinbase (BIP 34), if it has one.
+func coinbaseHeight(block *wire.MsgBlock) (int64, bool) {
+	if len(block.Transactions) > 0 && len(block.Transactions[0].TxIn) > 0 {
+		script := block.Transactions[0].TxIn[0].SignatureScript
+		switch {
+		case len(script) > 0 && script[0] >= txscript.OP_1 && script[0] <= txscript.OP_16:
+			return int64(script[0] - txscript.OP_1 + 1), true
+		case len(script) > 1 && script[0] >= 1 && script[0] <= 8 && len(script) > int(script[0]):
+			var height int64
+			for i := int(script[0]); i >= 1; i-- {
+				height = height<<8 | int64(script[i])
+			}
+			if height > 0 {
+				return height, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// depthsReached returns a confirmations event, from e, for each depth the
+// output has reached since the last poll, and its settled event once it
+// reaches --confirmations. A depth passed while monitor was down is still
+// reported, once.
+func (m *addressMonitor) depthsReached(e MonitorEvent, previous monitoredOutput, known bool) []MonitorEvent {
+	if e.Height == 0 {
+		return nil
+	}
+	var before int64
+	if known && previous.Height == e.Height && m.state.TipHeight > 0 {
+		before = m.state.TipHeight - previous.Height + 1
+	}
+	var reached []MonitorEvent
+	seen := map[int64]bool{}
+	for _, depth := range m.depths {
+		if before < depth && depth <= e.Confirmations && !seen[depth] {
+			seen[depth] = true
+			r := e
+			r.Depth = depth
+			reached = append(reached, r)
+		}
+	}
+	sort.Slice(reached, func(i, j int) bool { return reached[i].Depth < reached[j].Depth })
+	if m.settle > 0 && before < m.settle && m.settle <= e.Confirmations {
+		r := e
+		r.Type, r.Depth = eventSettled, m.settle
+		reached = append(reached, r)
+	}
+	return reached
+}
+
+// emitDepths writes the confirmations events of stream thresholds and the
+// settled events, and only hands the rest to the sinks.
+func (m *addressMonitor) emitDepths(deep []MonitorEvent) error {
+	for _, e := range deep {
+		streamed := e.Type == eventSettled
+		for _, depth := range m.thresholds {
+			streamed = streamed || e.Depth == depth
+		}
+		if !streamed {
+			m.notify(e)
+			continue
+		}
+		if err := m.emit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventOrder puts replacements and evictions first in a poll, and spends
+// after the receives and confirmations.
+func eventOrder(e MonitorEvent) int {
+	switch e.Type {
+	case eventReplaced, eventEvicted:
+		return -1
+	case eventSpent:
+		return 1
+	}
+	return 0
+}
+
+// emit writes one event and hands it to the sinks.
+func (m *addressMonitor) emit(e MonitorEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := m.events.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write event: %v", err)
+	}
+	m.report.Events++
+	m.notify(e)
+	return nil
+}
+
+// notify hands an event to the sinks.
+func (m *addressMonitor) notify(e MonitorEvent) {
+	for _, sink := range m.sinks {
+		sink(e)
+	}
+}
+
+func (m *addressMonitor) saveState() error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.statePath, data); err != nil {
+		return fmt.Errorf("failed to save monitor state: %v", err)
+	}
+	return nil
+}
+
+func (m *addressMonitor) Close() error {
+	if c, ok := m.events.(io.Closer); ok && m.events != stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+func outpointKey(txid string, vout uint32) string {
+	return txid + ":" + strconv.FormatUint(uint64(vout), 10)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// verify-node uses the configured Bitcoin Core node as one more
+// independent engine: Core's deriveaddresses expands the wallet's
+// descriptors, and every address is compared with the local derivation.
+
+// defaultNodeRange is how many addresses of each chain verify-node
+// compares by default.
+const defaultNodeRange = 100
+
+// NodeReport is the comparison of local derivation with the node's.
+type NodeReport struct {
+	Chain      string         `json:"chain"`
+	Count      int            `json:"count"`
+	Chains     []NodeChain    `json:"chains"`
+	Mismatches []NodeMismatch `json:"mismatches"`
+	// Verified is set when the node derived every address the same way.
+	Verified bool `json:"verified"`
+}
+
+// NodeChain is the descriptor sent to the node for one chain.
+type NodeChain struct {
+	Change     bool   `json:"change"`
+	Descriptor string `json:"descriptor"`
+	Compared   int    `json:"compared"`
+}
+
+// NodeMismatch is an index where the node and local derivation differ.
+// Node addresses are in the node's own encoding (bcrt1 on regtest).
+type NodeMismatch struct {
+	Change bool   `json:"change"`
+	Index  uint32 `json:"index"`
+	Path   string `json:"path"`
+	Local  string `json:"local"`
+	Node   string `json:"node"`
+}
+
+// verifyAgainstNode derives the first count addresses of each chain both
+// locally and with the node's deriveaddresses, and diffs them.
+// Addresses are compared by scriptPubKey, as a regtest node encodes them
+// for its own chain.
+func verifyAgainstNode(spec *WalletSpec, cfg *BackendConfig, count int) (*NodeReport, error) {
+	core, err := newCoreBackend(cfg, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	defer core.Close()
+
+	report := &NodeReport{Chain: core.chain.Name, Count: count, Chains: []NodeChain{}, Mismatches: []NodeMismatch{}}
+	for _, change := range []bool{false, true} {
+		desc, err := walletDescriptor(spec, change)
+		if err != nil {
+			return nil, err
+		}
+		var derived []string
+		if err := core.call("", "deriveaddresses", []interface{}{desc, []int{0, count - 1}}, &derived); err != nil {
+			return nil, fmt.Errorf("node failed to derive %s: %v", desc, err)
+		}
+		if len(derived) != count {
+			return nil, fmt.Errorf("node derived %d addresses for %d indices", len(derived), count)
+		}
+
+		for i, nodeAddress := range derived {
+			index := uint32(i)
+			local, err := spec.deriveAddress(change, index)
+			if err != nil {
+				return nil, err
+			}
+			localScript, err := addressScript(local, spec.Network)
+			if err != nil {
+				return nil, err
+			}
+			nodeScript := ""
+			if addr, err := btcutil.DecodeAddress(nodeAddress, core.chain); err == nil {
+				if script, err := txscript.PayToAddrScript(addr); err == nil {
+					nodeScript = hex.EncodeToString(script)
+				}
+			}
+			if !addressesMatch(hex.EncodeToString(localScript), nodeScript) {
+				report.Mismatches = append(report.Mismatches, NodeMismatch{
+					Change: change,
+					Index:  index,
+					Path:   chainIndex(change, index),
+					Local:  local,
+					Node:   nodeAddress,
+				})
+			}
+		}
+		report.Chains = append(report.Chains, NodeChain{Change: change, Descriptor: desc, Compared: count})
+	}
+	report.Verified = len(report.Mismatches) == 0
+	return report, nil
+}
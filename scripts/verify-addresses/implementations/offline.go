@@ -0,0 +1,33 @@
+package main
+
+// offlineBuild is set by builds with -tags offline, which refuse network
+// access whatever the flags and config say.
+var offlineBuild bool
+
+// offlineMode reports whether network access is disabled, by --offline or
+// by an offline build.
+func offlineMode() bool {
+	return options.offline || offlineBuild
+}
+
+// requireOnline fails with errOffline when network access is disabled. It
+// guards every path that opens a connection, before any is made.
+func requireOnline(what string) error {
+	if !offlineMode() {
+		return nil
+	}
+	if offlineBuild {
+		return errorWithCode(errOffline, "%s needs network access, which this offline build does not allow", what)
+	}
+	return errorWithCode(errOffline, "%s needs network access, which --offline disables", what)
+}
+
+// requireOffline fails with errOnline unless network access is disabled.
+// Commands that turn a master private key into secrets guard themselves
+// with it, so they only run on an air-gapped machine.
+func requireOffline(what string) error {
+	if offlineMode() {
+		return nil
+	}
+	return errorWithCode(errOnline, "%s handles a master private key and runs only with --offline or in an offline build", what)
+}
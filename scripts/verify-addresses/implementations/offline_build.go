@@ -0,0 +1,7 @@
+//go:build offline
+
+package main
+
+func init() {
+	offlineBuild = true
+}
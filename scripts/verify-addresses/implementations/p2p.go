@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// A minimal Bitcoin P2P client, enough for the Neutrino backend to ask
+// peers for headers, filters and blocks. It relays nothing and answers
+// only pings; anything else peers send unprompted (inv, addr, feefilter)
+// is read and dropped.
+
+// p2pTimeout bounds the wait for each message a peer is asked for.
+const p2pTimeout = 60 * time.Second
+
+// p2pPeer is a connection to one node, after the version handshake.
+type p2pPeer struct {
+	addr     string
+	conn     net.Conn
+	net      wire.BitcoinNet
+	pver     uint32
+	services wire.ServiceFlag
+	// userAgent and height are what the peer announced in its version.
+	userAgent string
+	height    int32
+}
+
+// peerAddress gives addr the network's default port when it has none.
+func peerAddress(addr string, params *chaincfg.Params) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, params.DefaultPort)
+}
+
+// dialPeer connects to a node and runs the version handshake. The peer
+// must serve compact block filters and witness blocks.
+func dialPeer(addr string, params *chaincfg.Params) (*p2pPeer, error) {
+	addr = peerAddress(addr, params)
+	conn, err := dialBackend(context.Background(), addr, 30*time.Second)
+	if err != nil {
+		return nil, transient(fmt.Errorf("failed to connect to peer %s: %v", addr, err))
+	}
+	p := &p2pPeer{addr: addr, conn: conn, net: params.Net, pver: wire.ProtocolVersion}
+	if err := p.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if want := wire.SFNodeCF | wire.SFNodeWitness; p.services&want != want {
+		conn.Close()
+		return nil, fmt.Errorf("peer %s (%s) does not serve compact block filters and witness blocks", addr, p.userAgent)
+	}
+	return p, nil
+}
+
+func (p *p2pPeer) handshake() error {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	you := wire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+	if tcp, ok := p.conn.RemoteAddr().(*net.TCPAddr); ok && options.proxy == "" {
+		you = wire.NewNetAddress(tcp, 0)
+	} else if _, port, err := net.SplitHostPort(p.addr); err == nil {
+		n, _ := strconv.ParseUint(port, 10, 16)
+		you.Port = uint16(n)
+	}
+	version := wire.NewMsgVersion(wire.NewNetAddressIPPort(net.IPv4zero, 0, 0), you, binary.LittleEndian.Uint64(nonce[:]), 0)
+	version.Services = 0
+	version.UserAgent = "/verify-addresses/"
+	version.DisableRelayTx = true
+	if err := p.send(version); err != nil {
+		return err
+	}
+
+	var gotVersion, gotVerAck bool
+	for !gotVersion || !gotVerAck {
+		msg, err := p.receive()
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case *wire.MsgVersion:
+			if gotVersion {
+				return fmt.Errorf("peer %s sent a second version message", p.addr)
+			}
+			gotVersion = true
+			if m.ProtocolVersion < int32(wire.BIP0111Version) {
+				return fmt.Errorf("peer %s speaks protocol version %d, too old for compact block filters", p.addr, m.ProtocolVersion)
+			}
+			if uint32(m.ProtocolVersion) < p.pver {
+				p.pver = uint32(m.ProtocolVersion)
+			}
+			p.services, p.userAgent, p.height = m.Services, m.UserAgent, m.LastBlock
+			if err := p.send(wire.NewMsgVerAck()); err != nil {
+				return err
+			}
+		case *wire.MsgVerAck:
+			gotVerAck = true
+		}
+	}
+	return nil
+}
+
+func (p *p2pPeer) send(msg wire.Message) error {
+	p.conn.SetWriteDeadline(time.Now().Add(p2pTimeout))
+	if _, err := wire.WriteMessageWithEncodingN(p.conn, msg, p.pver, p.net, wire.WitnessEncoding); err != nil {
+		return transient(fmt.Errorf("peer %s: %v", p.addr, err))
+	}
+	return nil
+}
+
+// receive reads the next message the build's wire package knows, skipping
+// unknown commands.
+func (p *p2pPeer) receive() (wire.Message, error) {
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(p2pTimeout))
+		_, msg, _, err := wire.ReadMessageWithEncodingN(p.conn, p.pver, p.net, wire.WitnessEncoding)
+		if errors.Is(err, wire.ErrUnknownMessage) {
+			continue
+		}
+		if err != nil {
+			return nil, transient(fmt.Errorf("peer %s: %v", p.addr, err))
+		}
+		if ping, ok := msg.(*wire.MsgPing); ok {
+			if err := p.send(wire.NewMsgPong(ping.Nonce)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// request sends msg, then passes each message read to handle until it
+// reports the response complete. handle ignores messages that are not
+// part of the response by returning false.
+func (p *p2pPeer) request(msg wire.Message, handle func(wire.Message) (bool, error)) error {
+	if err := p.send(msg); err != nil {
+		return err
+	}
+	for {
+		reply, err := p.receive()
+		if err != nil {
+			return err
+		}
+		if _, ok := reply.(*wire.MsgNotFound); ok {
+			return fmt.Errorf("peer %s does not have the %s asked for", p.addr, msg.Command())
+		}
+		done, err := handle(reply)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func (p *p2pPeer) Close() error { return p.conn.Close() }
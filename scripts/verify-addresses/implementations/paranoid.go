@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// engineMismatch records that --paranoid caught the two derivation engines
+// disagreeing, so the run exits non-zero whatever the command printed.
+var engineMismatch bool
+
+// crossCheckDerivation re-derives the key at path below ext with the
+// in-package engine, and with btcec when another curve backend is active,
+// and fails unless each equals got. It does nothing unless --paranoid is
+// set.
+func crossCheckDerivation(ext *hdkeychain.ExtendedKey, path []uint32, got *btcec.PublicKey) error {
+	if !options.paranoid {
+		return nil
+	}
+	if activeCurve != btcecBackend {
+		if err := crossCheckBtcec(ext, path, got); err != nil {
+			return err
+		}
+	}
+	serialized := ext.String()
+	parent, err := parseBip32PubKey(serialized)
+	if err != nil {
+		return engineDisagreement("internal engine cannot parse %s: %v", serialized, err)
+	}
+	want, err := parent.derivePath(path)
+	if err != nil {
+		return engineDisagreement("internal engine cannot derive %s%s: %v", serialized, formatChildPath(path), err)
+	}
+	if !bytes.Equal(want, got.SerializeCompressed()) {
+		return engineDisagreement("engines disagree at %s%s: %s %x, internal %x",
+			serialized, formatChildPath(path), activeCurve.name, got.SerializeCompressed(), want)
+	}
+	return nil
+}
+
+// crossCheckBtcec compares got against btcec's own derivation.
+func crossCheckBtcec(parent *hdkeychain.ExtendedKey, path []uint32, got *btcec.PublicKey) error {
+	ext := parent
+	var err error
+	for _, i := range path {
+		if ext, err = ext.Derive(i); err != nil {
+			return engineDisagreement("btcec cannot derive child %d: %v", i, err)
+		}
+	}
+	want, err := ext.ECPubKey()
+	if err != nil {
+		return err
+	}
+	if !want.IsEqual(got) {
+		return engineDisagreement("curve backends disagree at %s%s: %s %x, btcec %x",
+			parent.String(), formatChildPath(path), activeCurve.name, got.SerializeCompressed(), want.SerializeCompressed())
+	}
+	return nil
+}
+
+func engineDisagreement(format string, args ...interface{}) error {
+	engineMismatch = true
+	err := errorWithCode(errEngineMismatch, format, args...)
+	diagnostic("PARANOID CHECK FAILED: %v", err)
+	return err
+}
+
+// formatChildPath renders path as "/0/5".
+func formatChildPath(path []uint32) string {
+	var s string
+	for _, i := range path {
+		s += fmt.Sprintf("/%d", i)
+	}
+	return s
+}
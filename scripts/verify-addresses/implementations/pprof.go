@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// servePprof serves the net/http/pprof handlers under /debug/pprof/ on
+// addr, for profiling a long-running watch. Profiles expose the process's
+// memory, wallet keys included, so only loopback addresses are accepted.
+func servePprof(addr string) error {
+	ln, err := listenLoopback("--pprof", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go http.Serve(ln, mux)
+	diagnostic("serving pprof on http://%s/debug/pprof/", ln.Addr())
+	return nil
+}
+
+// listenLoopback listens on addr, which must be a loopback address. The
+// verifier's listeners have no authentication, so they are never reachable
+// from another machine.
+func listenLoopback(flag, addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s address %q (want host:port)", flag, addr)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s must listen on a loopback address, not %s", flag, host)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for %s: %v", flag, err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// protoResponses lists every document a command can output. With
+// --format proto each one is written as a Response message, whose oneof
+// field number is the document's position here plus one.
+//
+// Field numbers inside a message follow the declaration order of the Go
+// struct's JSON fields, and Response numbers follow this list, so both must
+// only ever be appended to. proto-schema prints the matching .proto file.
+var protoResponses = []interface{}{
+	Result{},
+	WalletReport{},
+	WalletBundle{},
+	AttestationReport{},
+	NextAddress{},
+	BalanceReport{},
+	UTXOReport{},
+	ProvisionReport{},
+	LabelReport{},
+	ListReport{},
+	AnchorReport{},
+	FrostReport{},
+	URDecodeResult{},
+	EngineDiff{},
+	BatchReport{},
+	LNDReport{},
+	CLNReport{},
+	CloseReport{},
+	NodeReport{},
+	BackendCheckReport{},
+	VectorsReport{},
+	ResultVerification{},
+	AuditReport{},
+	ReportDiff{},
+	MonitorReport{},
+	HardenedReport{},
+	Bip85Report{},
+	MnemonicReport{},
+	SLIP39Report{},
+	SeedXORReport{},
+	FindPassphraseReport{},
+	LookalikeReport{},
+	AddressDecode{},
+	MigrationReport{},
+	SweepReport{},
+	DustReport{},
+	FeeRateEstimate{},
+	SpendSimulation{},
+	SizeEstimate{},
+	SpendPathReport{},
+	PSBTLintReport{},
+	CombineReport{},
+	PSBTUREncoding{},
+	TxDecodeReport{},
+	BlockScanReport{},
+}
+
+// protoField is one JSON-visible struct field and its protobuf number.
+type protoField struct {
+	name   string
+	number int
+	index  int
+}
+
+// protoFields returns the fields of a struct type that are encoded, in
+// field number order.
+func protoFields(t reflect.Type) []protoField {
+	var fields []protoField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, protoField{name: name, number: len(fields) + 1, index: i})
+	}
+	return fields
+}
+
+// writeProto writes v as a varint length-prefixed Response message.
+func writeProto(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for i, r := range protoResponses {
+		if reflect.TypeOf(r) != rv.Type() {
+			continue
+		}
+		msg := appendProtoMessage(nil, rv)
+		response := appendProtoBytes(nil, i+1, msg)
+		out := binary.AppendUvarint(nil, uint64(len(response)))
+		_, err := stdout.Write(append(out, response...))
+		return err
+	}
+	return fmt.Errorf("no protobuf encoding for %s", rv.Type())
+}
+
+func appendProtoTag(b []byte, number int, wireType uint64) []byte {
+	return binary.AppendUvarint(b, uint64(number)<<3|wireType)
+}
+
+func appendProtoBytes(b []byte, number int, data []byte) []byte {
+	b = appendProtoTag(b, number, 2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendProtoMessage encodes a struct's fields, leaving out zero values as
+// proto3 does.
+func appendProtoMessage(b []byte, v reflect.Value) []byte {
+	for _, f := range protoFields(v.Type()) {
+		b = appendProtoValue(b, f.number, v.Field(f.index), false)
+	}
+	return b
+}
+
+// appendProtoValue encodes one field value. present forces a zero scalar to
+// be written, for optional and repeated fields.
+func appendProtoValue(b []byte, number int, v reflect.Value, present bool) []byte {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b
+		}
+		return appendProtoValue(b, number, v.Elem(), true)
+	case reflect.Struct:
+		return appendProtoBytes(b, number, appendProtoMessage(nil, v))
+	case reflect.String:
+		if v.Len() > 0 || present {
+			b = appendProtoBytes(b, number, []byte(v.String()))
+		}
+	case reflect.Bool:
+		if v.Bool() || present {
+			b = appendProtoTag(b, number, 0)
+			if v.Bool() {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if v.Int() != 0 || present {
+			b = appendProtoTag(b, number, 0)
+			b = binary.AppendUvarint(b, uint64(v.Int()))
+		}
+	case reflect.Uint8, reflect.Uint32, reflect.Uint64:
+		if v.Uint() != 0 || present {
+			b = appendProtoTag(b, number, 0)
+			b = binary.AppendUvarint(b, v.Uint())
+		}
+	case reflect.Float64:
+		if v.Float() != 0 || present {
+			b = appendProtoTag(b, number, 1)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() > 0 {
+				b = appendProtoBytes(b, number, v.Bytes())
+			}
+			return b
+		}
+		for i := 0; i < v.Len(); i++ {
+			b = appendProtoValue(b, number, v.Index(i), true)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			var entry []byte
+			entry = appendProtoValue(entry, 1, k, true)
+			entry = appendProtoValue(entry, 2, v.MapIndex(k), true)
+			b = appendProtoBytes(b, number, entry)
+		}
+	}
+	return b
+}
+
+// protoSchema renders the .proto file describing every Response message.
+func protoSchema() string {
+	var s strings.Builder
+	s.WriteString("// Generated by verify-addresses proto-schema. Each --format proto\n")
+	s.WriteString("// document is one varint length-prefixed Response.\n")
+	s.WriteString("syntax = \"proto3\";\n\npackage verifyaddresses;\n\n")
+	s.WriteString("message Response {\n  oneof document {\n")
+	var pending []reflect.Type
+	for i, r := range protoResponses {
+		t := reflect.TypeOf(r)
+		fmt.Fprintf(&s, "    %s %s = %d;\n", protoMessageName(t), protoSnakeCase(t.Name()), i+1)
+		pending = append(pending, t)
+	}
+	s.WriteString("  }\n}\n")
+
+	written := map[reflect.Type]bool{}
+	for len(pending) > 0 {
+		t := pending[0]
+		pending = pending[1:]
+		if written[t] {
+			continue
+		}
+		written[t] = true
+		fmt.Fprintf(&s, "\nmessage %s {\n", protoMessageName(t))
+		for _, f := range protoFields(t) {
+			fieldType := t.Field(f.index).Type
+			decl := protoFieldType(fieldType)
+			for _, nested := range protoNestedTypes(fieldType) {
+				pending = append(pending, nested)
+			}
+			fmt.Fprintf(&s, "  %s %s = %d;\n", decl, f.name, f.number)
+		}
+		s.WriteString("}\n")
+	}
+	return s.String()
+}
+
+func protoFieldType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			return protoFieldType(t.Elem())
+		}
+		return "optional " + protoFieldType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return "repeated " + protoFieldType(t.Elem())
+	case reflect.Map:
+		return "map<" + protoFieldType(t.Key()) + ", " + protoFieldType(t.Elem()) + ">"
+	case reflect.Struct:
+		return protoMessageName(t)
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "int64"
+	case reflect.Uint32:
+		return "uint32"
+	case reflect.Uint8, reflect.Uint64:
+		return "uint64"
+	case reflect.Float64:
+		return "double"
+	}
+	panic("no protobuf type for " + t.String())
+}
+
+// protoNestedTypes returns the message types a field refers to.
+func protoNestedTypes(t reflect.Type) []reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		return []reflect.Type{t}
+	}
+	return nil
+}
+
+func protoMessageName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+func protoSnakeCase(name string) string {
+	var s strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Break before an upper-case letter that starts a word, so
+			// "URDecodeResult" becomes "ur_decode_result".
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				s.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		s.WriteRune(r)
+	}
+	return s.String()
+}
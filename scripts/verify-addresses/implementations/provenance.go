@@ -0,0 +1,65 @@
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// buildTime is stamped at build time, since the Go toolchain does not
+// record it:
+//
+//	go build -ldflags "-X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var buildTime string
+
+// BuildProvenance identifies exactly which verifier binary produced a
+// result, for audit logs.
+type BuildProvenance struct {
+	GoVersion string `json:"go_version"`
+	// Commit, CommitTime and Modified come from the VCS stamp, which is
+	// only present in binaries built with go build inside the repository.
+	Commit     string        `json:"commit,omitempty"`
+	CommitTime string        `json:"commit_time,omitempty"`
+	Modified   bool          `json:"modified,omitempty"`
+	BuildTime  string        `json:"build_time,omitempty"`
+	Tags       string        `json:"tags,omitempty"`
+	Modules    []BuildModule `json:"modules"`
+}
+
+// BuildModule is a dependency as resolved into the binary.
+type BuildModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+// buildProvenance reads the binary's embedded build information. Only the
+// btcsuite modules, which do the derivation, are listed.
+func buildProvenance() *BuildProvenance {
+	p := &BuildProvenance{BuildTime: buildTime, Modules: []BuildModule{}}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return p
+	}
+	p.GoVersion = info.GoVersion
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			p.Commit = s.Value
+		case "vcs.time":
+			p.CommitTime = s.Value
+		case "vcs.modified":
+			p.Modified = s.Value == "true"
+		case "-tags":
+			p.Tags = s.Value
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if strings.HasPrefix(dep.Path, "github.com/btcsuite/") {
+			p.Modules = append(p.Modules, BuildModule{Path: dep.Path, Version: dep.Version, Sum: dep.Sum})
+		}
+	}
+	return p
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultProvisionRange is how many addresses of each chain Core tracks
+// after provisioning. Core extends the range as addresses get used.
+const defaultProvisionRange = 1000
+
+// ProvisionReport describes a watch-only wallet created on a Core node.
+type ProvisionReport struct {
+	Wallet      string               `json:"wallet"`
+	Chain       string               `json:"chain"`
+	Descriptors []ImportedDescriptor `json:"descriptors"`
+	// RescanFrom is the block the import rescanned from; 0 means the whole
+	// chain because the spec carries no birth height.
+	RescanFrom int64 `json:"rescan_from"`
+	// Verified is set once the node reports the wallet's first receive
+	// address as its own.
+	Verified bool   `json:"verified"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// ImportedDescriptor is one importdescriptors request and its outcome.
+type ImportedDescriptor struct {
+	Descriptor string   `json:"descriptor"`
+	Internal   bool     `json:"internal"`
+	Range      [2]int   `json:"range"`
+	Success    bool     `json:"success"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+var coreWalletNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// provisionCoreWallet creates a blank watch-only descriptor wallet on the
+// node, imports the wallet's receive and change descriptors from its birth
+// height, and waits for the resulting rescan.
+func provisionCoreWallet(spec *WalletSpec, cfg *BackendConfig, name string, count int) (*ProvisionReport, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("invalid range: %d", count)
+	}
+	// Only a spec whose own claims check out is worth watching.
+	verified, err := verifyWallet(spec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !verified.Verified {
+		return nil, fmt.Errorf("refusing to provision: wallet spec addresses do not match derivation")
+	}
+
+	if name == "" {
+		id, err := walletID(spec)
+		if err != nil {
+			return nil, err
+		}
+		name = "verify-" + id[:8]
+		if spec.Name != "" {
+			name = coreWalletNameChars.ReplaceAllString(spec.Name, "-") + "-" + id[:8]
+		}
+	}
+
+	core, err := newCoreBackend(cfg, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	// The import blocks until its rescan finishes, which can take hours.
+	core.client.Timeout = 0
+
+	report := &ProvisionReport{Wallet: name, Chain: core.chain.Name}
+
+	// createwallet name disable_private_keys blank passphrase avoid_reuse descriptors
+	if err := core.call("", "createwallet", []interface{}{name, true, true, "", false, true}, nil); err != nil {
+		return nil, fmt.Errorf("failed to create wallet %s: %v", name, err)
+	}
+
+	// Core takes a block time, not a height. It rescans from a little
+	// before the timestamp to allow for block time skew.
+	var timestamp interface{} = 0
+	if spec.BlockHeight > 0 {
+		tip, err := core.TipHeight()
+		if err != nil {
+			return nil, err
+		}
+		if spec.BlockHeight > tip {
+			timestamp = "now"
+			report.RescanFrom = tip
+		} else {
+			var hash string
+			if err := core.call("", "getblockhash", []interface{}{spec.BlockHeight}, &hash); err != nil {
+				return nil, err
+			}
+			var header struct {
+				Time int64 `json:"time"`
+			}
+			if err := core.call("", "getblockheader", []interface{}{hash}, &header); err != nil {
+				return nil, err
+			}
+			timestamp = header.Time
+			report.RescanFrom = spec.BlockHeight
+		}
+	}
+
+	var requests []map[string]interface{}
+	for _, internal := range []bool{false, true} {
+		desc, err := walletDescriptor(spec, internal)
+		if err != nil {
+			return nil, err
+		}
+		report.Descriptors = append(report.Descriptors, ImportedDescriptor{
+			Descriptor: desc,
+			Internal:   internal,
+			Range:      [2]int{0, count - 1},
+		})
+		requests = append(requests, map[string]interface{}{
+			"desc":      desc,
+			"active":    true,
+			"internal":  internal,
+			"range":     []int{0, count - 1},
+			"timestamp": timestamp,
+		})
+	}
+
+	var results []struct {
+		Success  bool     `json:"success"`
+		Warnings []string `json:"warnings"`
+		Error    *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := core.call(name, "importdescriptors", []interface{}{requests}, &results); err != nil {
+		return nil, fmt.Errorf("failed to import descriptors: %v", err)
+	}
+	if len(results) != len(report.Descriptors) {
+		return nil, fmt.Errorf("importdescriptors returned %d results for %d descriptors", len(results), len(report.Descriptors))
+	}
+	allImported := true
+	for i, r := range results {
+		d := &report.Descriptors[i]
+		d.Success = r.Success
+		d.Warnings = r.Warnings
+		if r.Error != nil {
+			d.Error = r.Error.Message
+		}
+		allImported = allImported && r.Success
+	}
+	if !allImported {
+		return report, nil
+	}
+
+	first, err := spec.deriveAddress(false, 0)
+	if err != nil {
+		return nil, err
+	}
+	nodeAddr, err := core.nodeAddress(first)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		IsMine bool `json:"ismine"`
+	}
+	if err := core.call(name, "getaddressinfo", []interface{}{nodeAddr}, &info); err != nil {
+		return nil, err
+	}
+	report.Verified = info.IsMine
+	report.Hint = fmt.Sprintf("set \"core\": {\"wallet\": %q} in the backend config to use this wallet for address history", name)
+	return report, nil
+}
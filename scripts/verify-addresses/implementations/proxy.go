@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --proxy, every backend connection goes through a SOCKS5 proxy such
+// as Tor, and host names are resolved by the proxy, so neither the lookups
+// nor DNS reveal the wallet's addresses to clearnet observers. Onion
+// services can only be reached this way.
+
+// socksProxy is a parsed --proxy URL.
+type socksProxy struct {
+	addr     string
+	user     string
+	password string
+}
+
+// parseProxy accepts socks5://[user:pass@]host:port; socks5h is the same,
+// since names are always resolved by the proxy. Tor isolates streams with
+// different credentials onto separate circuits.
+func parseProxy(raw string) (*socksProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid proxy %q (want socks5://host:port)", raw)
+	}
+	p := &socksProxy{addr: u.Host}
+	if u.User != nil {
+		p.user = u.User.Username()
+		p.password, _ = u.User.Password()
+		if len(p.user) > 255 || len(p.password) > 255 {
+			return nil, fmt.Errorf("proxy credentials are too long")
+		}
+	}
+	return p, nil
+}
+
+// checkRoute fails for an onion host without a proxy. Backends call it
+// when opened, so the misconfiguration is not retried as a network error.
+func checkRoute(host string) error {
+	if options.proxy == "" && strings.HasSuffix(strings.ToLower(host), ".onion") {
+		return fmt.Errorf("%s is an onion service; set --proxy socks5://127.0.0.1:9050 to reach it over Tor", host)
+	}
+	return nil
+}
+
+// checkRouteURL is checkRoute for the host of a URL.
+func checkRouteURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", raw, err)
+	}
+	return checkRoute(u.Hostname())
+}
+
+// dialBackend connects to a backend server at host:port, through the proxy
+// when one is set.
+func dialBackend(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRoute(host); err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if options.proxy == "" {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	p, err := parseProxy(options.proxy)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy %s: %v", p.addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := p.connect(conn, addr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", p.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socksReplies are the RFC 1928 reply codes.
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// connect runs the SOCKS5 handshake and CONNECT request on conn.
+func (p *socksProxy) connect(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in %s", addr)
+	}
+
+	method := byte(0x00)
+	if p.user != "" {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return fmt.Errorf("proxy refused the authentication method")
+	}
+	if method == 0x02 {
+		auth := append([]byte{1, byte(len(p.user))}, p.user...)
+		auth = append(append(auth, byte(len(p.password))), p.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("proxy rejected the credentials")
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		msg, ok := socksReplies[head[1]]
+		if !ok {
+			msg = fmt.Sprintf("error %d", head[1])
+		}
+		return fmt.Errorf("connecting to %s failed: %s", addr, msg)
+	}
+	// Skip the bound address and port.
+	var skip int
+	switch head[3] {
+	case 1:
+		skip = 4 + 2
+	case 4:
+		skip = 16 + 2
+	case 3:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		skip = int(reply[0]) + 2
+	default:
+		return fmt.Errorf("invalid proxy reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// newHTTPClient returns a client whose connections go through
+// dialBackend. With --proxy it ignores the HTTP(S)_PROXY environment,
+// which would otherwise route around the SOCKS proxy.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.proxy != "" {
+		transport.Proxy = nil
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialBackend(ctx, addr, 30*time.Second)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
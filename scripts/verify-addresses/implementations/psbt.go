@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// A PSBT (BIP-174) is an unsigned transaction with, for each input and
+// output, the key-value records a signer needs: the output being spent,
+// the scripts it commits to and the BIP-32 origin of every key. The
+// verifier reads and writes version 0 PSBTs itself; btcutil's psbt package
+// is not among its dependencies, and the format is small.
+
+const psbtMagic = "psbt\xff"
+
+// PSBT record key types (BIP-174, BIP-371).
+const (
+	psbtGlobalUnsignedTx = 0x00
+	psbtGlobalXpub       = 0x01
+	psbtGlobalVersion    = 0xfb
+
+	psbtInNonWitnessUTXO     = 0x00
+	psbtInWitnessUTXO        = 0x01
+	psbtInPartialSig         = 0x02
+	psbtInSighashType        = 0x03
+	psbtInRedeemScript       = 0x04
+	psbtInWitnessScript      = 0x05
+	psbtInBIP32Derivation    = 0x06
+	psbtInFinalScriptSig     = 0x07
+	psbtInFinalScriptWitness = 0x08
+	psbtInTapKeySig          = 0x13
+	psbtInTapScriptSig       = 0x14
+	psbtInTapBIP32Derivation = 0x16
+	psbtInTapInternalKey     = 0x17
+
+	psbtOutRedeemScript       = 0x00
+	psbtOutWitnessScript      = 0x01
+	psbtOutBIP32Derivation    = 0x02
+	psbtOutTapInternalKey     = 0x05
+	psbtOutTapBIP32Derivation = 0x07
+)
+
+// psbtRecord is one key-value pair; the key starts with its type.
+type psbtRecord struct {
+	key   []byte
+	value []byte
+}
+
+// psbtMap is the records of the global section, an input or an output, in
+// the order they are written.
+type psbtMap []psbtRecord
+
+func (m *psbtMap) add(keyType byte, keyData, value []byte) {
+	*m = append(*m, psbtRecord{key: append([]byte{keyType}, keyData...), value: value})
+}
+
+// psbtPacket is a PSBT: the unsigned transaction and a map for each of its
+// inputs and outputs.
+type psbtPacket struct {
+	tx      *wire.MsgTx
+	global  psbtMap
+	inputs  []psbtMap
+	outputs []psbtMap
+}
+
+// serialize encodes the packet. The unsigned transaction must have empty
+// scriptSigs and witnesses.
+func (p *psbtPacket) serialize() ([]byte, error) {
+	if len(p.inputs) != len(p.tx.TxIn) || len(p.outputs) != len(p.tx.TxOut) {
+		return nil, fmt.Errorf("PSBT has %d input and %d output maps for a transaction with %d inputs and %d outputs",
+			len(p.inputs), len(p.outputs), len(p.tx.TxIn), len(p.tx.TxOut))
+	}
+	var unsigned bytes.Buffer
+	if err := p.tx.SerializeNoWitness(&unsigned); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(psbtMagic)
+	global := psbtMap{{key: []byte{psbtGlobalUnsignedTx}, value: unsigned.Bytes()}}
+	writePSBTMap(&b, append(global, p.global...))
+	for _, m := range p.inputs {
+		writePSBTMap(&b, m)
+	}
+	for _, m := range p.outputs {
+		writePSBTMap(&b, m)
+	}
+	return b.Bytes(), nil
+}
+
+// maxPSBTRecordSize bounds a key or value read, the size of a block: no
+// transaction or script in a PSBT can be larger.
+const maxPSBTRecordSize = wire.MaxBlockPayload
+
+// parsePSBT decodes a binary version 0 PSBT.
+func parsePSBT(b []byte) (*psbtPacket, error) {
+	if !bytes.HasPrefix(b, []byte(psbtMagic)) {
+		return nil, fmt.Errorf("not a PSBT: missing magic bytes")
+	}
+	r := bytes.NewReader(b[len(psbtMagic):])
+	global, err := readPSBTMap(r)
+	if err != nil {
+		return nil, fmt.Errorf("PSBT global map: %v", err)
+	}
+	p := &psbtPacket{}
+	for _, rec := range global {
+		switch {
+		case len(rec.key) == 1 && rec.key[0] == psbtGlobalUnsignedTx:
+			p.tx = &wire.MsgTx{}
+			if err := p.tx.DeserializeNoWitness(bytes.NewReader(rec.value)); err != nil {
+				return nil, fmt.Errorf("PSBT unsigned transaction: %v", err)
+			}
+		case len(rec.key) == 1 && rec.key[0] == psbtGlobalVersion:
+			if len(rec.value) != 4 || binary.LittleEndian.Uint32(rec.value) != 0 {
+				return nil, fmt.Errorf("only version 0 PSBTs are supported")
+			}
+			p.global = append(p.global, rec)
+		default:
+			p.global = append(p.global, rec)
+		}
+	}
+	if p.tx == nil {
+		return nil, fmt.Errorf("PSBT has no unsigned transaction")
+	}
+	for range p.tx.TxIn {
+		m, err := readPSBTMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("PSBT input %d: %v", len(p.inputs), err)
+		}
+		p.inputs = append(p.inputs, m)
+	}
+	for range p.tx.TxOut {
+		m, err := readPSBTMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("PSBT output %d: %v", len(p.outputs), err)
+		}
+		p.outputs = append(p.outputs, m)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("PSBT has %d bytes after its last map", r.Len())
+	}
+	return p, nil
+}
+
+// readPSBTMap reads records up to the separator ending a map. Keys must be
+// unique within a map.
+func readPSBTMap(r *bytes.Reader) (psbtMap, error) {
+	var m psbtMap
+	seen := map[string]bool{}
+	for {
+		key, err := wire.ReadVarBytes(r, 0, maxPSBTRecordSize, "key")
+		if err != nil {
+			return nil, fmt.Errorf("truncated record: %v", err)
+		}
+		if len(key) == 0 {
+			return m, nil
+		}
+		value, err := wire.ReadVarBytes(r, 0, maxPSBTRecordSize, "value")
+		if err != nil {
+			return nil, fmt.Errorf("truncated record: %v", err)
+		}
+		if seen[string(key)] {
+			return nil, fmt.Errorf("duplicate key %x", key)
+		}
+		seen[string(key)] = true
+		m = append(m, psbtRecord{key: key, value: value})
+	}
+}
+
+// get returns the value of the record of keyType that has no key data.
+func (m psbtMap) get(keyType byte) ([]byte, bool) {
+	for _, r := range m {
+		if len(r.key) == 1 && r.key[0] == keyType {
+			return r.value, true
+		}
+	}
+	return nil, false
+}
+
+// all returns the records of keyType, which are keyed by their key data.
+func (m psbtMap) all(keyType byte) []psbtRecord {
+	var records []psbtRecord
+	for _, r := range m {
+		if r.key[0] == keyType {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// readPSBT reads a PSBT given as a file, "-" for stdin, or the argument
+// itself, in binary, base64 or hex.
+func readPSBT(arg string) (*psbtPacket, error) {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if os.IsNotExist(err) {
+			data, err = []byte(arg), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PSBT: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte(psbtMagic)) {
+		return parsePSBT(data)
+	}
+	// Hex digits are base64 characters too, so the magic decides.
+	text := string(bytes.Join(bytes.Fields(data), nil))
+	for _, decode := range []func(string) ([]byte, error){base64.StdEncoding.DecodeString, hex.DecodeString} {
+		if b, err := decode(text); err == nil && bytes.HasPrefix(b, []byte(psbtMagic)) {
+			return parsePSBT(b)
+		}
+	}
+	return nil, fmt.Errorf("PSBT is neither binary, base64 nor hex")
+}
+
+// writePSBTMap writes a map's records and the separator that ends it.
+func writePSBTMap(b *bytes.Buffer, m psbtMap) {
+	for _, r := range m {
+		wire.WriteVarBytes(b, 0, r.key)
+		wire.WriteVarBytes(b, 0, r.value)
+	}
+	b.WriteByte(0x00)
+}
+
+// witnessUTXO encodes an output as PSBT_IN_WITNESS_UTXO: value and script.
+func witnessUTXO(value int64, script []byte) []byte {
+	var b bytes.Buffer
+	wire.WriteTxOut(&b, 0, 0, wire.NewTxOut(value, script))
+	return b.Bytes()
+}
+
+// keyOrigin is where an account key sits below its master key.
+type keyOrigin struct {
+	fingerprint []byte
+	path        []uint32
+	// known is false when the wallet spec gives no origin: the account key
+	// then stands in as the master, with its own fingerprint and an empty
+	// path, which is what a signer holding only that key expects.
+	known bool
+}
+
+// derivation encodes the origin of the key at steps below the account
+// key: the fingerprint, then each child number little-endian.
+func (o keyOrigin) derivation(steps []uint32) []byte {
+	b := append([]byte(nil), o.fingerprint...)
+	for _, step := range append(append([]uint32(nil), o.path...), steps...) {
+		b = binary.LittleEndian.AppendUint32(b, step)
+	}
+	return b
+}
+
+// spendKey is one key of a wallet output with its origin.
+type spendKey struct {
+	pub        *btcec.PublicKey
+	derivation []byte
+}
+
+// spendInfo is what a signer needs to spend, or to recognize, one wallet
+// output: its keys and the scripts it commits to.
+type spendInfo struct {
+	address       string
+	keys          []spendKey
+	redeemScript  []byte
+	witnessScript []byte
+	taproot       bool
+	uncompressed  bool
+}
+
+// walletSpendInfo derives the keys and scripts of a wallet's output at
+// change/index. Taproot policies are not supported: their script paths
+// need leaf records this writer does not produce.
+func walletSpendInfo(spec *WalletSpec, change bool, index uint32) (*spendInfo, error) {
+	if spec.Taproot != nil {
+		return nil, fmt.Errorf("PSBTs for taproot policy wallets are not supported")
+	}
+	st, err := lookupScriptType(spec.ScriptType)
+	if err != nil {
+		return nil, err
+	}
+	info := &spendInfo{taproot: spec.ScriptType == "taproot", uncompressed: spec.Uncompressed}
+	pubs := make([]*btcec.PublicKey, 0, len(spec.Keys))
+	for _, k := range spec.Keys {
+		key, suffix, err := parseKeyOrigin(k.Xpub)
+		if err != nil {
+			return nil, err
+		}
+		origin, err := accountKeyOrigin(k, spec.Network)
+		if err != nil {
+			return nil, err
+		}
+		chain := uint32(0)
+		if change {
+			chain = 1
+		}
+		steps := []uint32{chain, index}
+		if suffix != "" {
+			if steps, err = suffixPath(suffix, index, change); err != nil {
+				return nil, err
+			}
+		}
+		pub, err := btcsuiteCosignerKey(k.Xpub, index, change, spec.Network)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive %s: %v", key.Xpub, err)
+		}
+		pubs = append(pubs, pub)
+		info.keys = append(info.keys, spendKey{pub: pub, derivation: origin.derivation(steps)})
+	}
+
+	net := getNetwork(spec.Network)
+	if spec.Uncompressed {
+		info.address, err = st.uncompressedAddress(pubs[0], net)
+	} else {
+		info.address, err = st.address(pubs, spec.Threshold, net)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch spec.ScriptType {
+	case "nested_segwit":
+		info.redeemScript = append([]byte{0x00, 0x14}, btcutil.Hash160(pubs[0].SerializeCompressed())...)
+	case "p2sh", "p2wsh", "p2sh_p2wsh":
+		script, err := sortedMultisigScript(pubs, spec.Threshold)
+		if err != nil {
+			return nil, err
+		}
+		switch spec.ScriptType {
+		case "p2sh":
+			info.redeemScript = script
+		case "p2wsh":
+			info.witnessScript = script
+		default:
+			program := sha256.Sum256(script)
+			info.redeemScript = append([]byte{0x00, 0x20}, program[:]...)
+			info.witnessScript = script
+		}
+	}
+	return info, nil
+}
+
+// serializedKey is the key as it appears in the output's script.
+func (s *spendInfo) serializedKey(k spendKey) []byte {
+	switch {
+	case s.taproot:
+		return schnorr.SerializePubKey(k.pub)
+	case s.uncompressed:
+		return k.pub.SerializeUncompressed()
+	}
+	return k.pub.SerializeCompressed()
+}
+
+// addInput adds the script and derivation records of an input spending
+// the output.
+func (s *spendInfo) addInput(m *psbtMap) {
+	s.add(m, psbtInRedeemScript, psbtInWitnessScript, psbtInBIP32Derivation, psbtInTapInternalKey, psbtInTapBIP32Derivation)
+}
+
+// addOutput adds the records that let a signer recognize the output as
+// its own change.
+func (s *spendInfo) addOutput(m *psbtMap) {
+	s.add(m, psbtOutRedeemScript, psbtOutWitnessScript, psbtOutBIP32Derivation, psbtOutTapInternalKey, psbtOutTapBIP32Derivation)
+}
+
+func (s *spendInfo) add(m *psbtMap, redeem, witness, bip32, tapInternal, tapBIP32 byte) {
+	if s.redeemScript != nil {
+		m.add(redeem, nil, s.redeemScript)
+	}
+	if s.witnessScript != nil {
+		m.add(witness, nil, s.witnessScript)
+	}
+	for _, k := range s.keys {
+		if s.taproot {
+			// A key-path spend: no leaf hashes precede the origin.
+			m.add(tapBIP32, s.serializedKey(k), append([]byte{0x00}, k.derivation...))
+			continue
+		}
+		m.add(bip32, s.serializedKey(k), k.derivation)
+	}
+	if s.taproot {
+		m.add(tapInternal, nil, s.serializedKey(s.keys[0]))
+	}
+}
+
+// accountKeyOrigin returns the origin of a wallet key, from its key
+// expression or else the spec's fingerprint and path.
+func accountKeyOrigin(k WalletKey, network string) (keyOrigin, error) {
+	key, _, err := parseKeyOrigin(k.Xpub)
+	if err != nil {
+		return keyOrigin{}, err
+	}
+	fingerprint, path := key.Fingerprint, key.Path
+	if fingerprint == "" {
+		fingerprint, path = k.Fingerprint, k.Path
+	}
+	if fingerprint != "" {
+		fp, err := parseFingerprint(fingerprint)
+		if err != nil {
+			return keyOrigin{}, err
+		}
+		if path == "" {
+			path = "m"
+		}
+		steps, err := parseKeyPath(path)
+		if err != nil {
+			return keyOrigin{}, err
+		}
+		return keyOrigin{fingerprint: fp, path: steps, known: true}, nil
+	}
+
+	extKey, err := hdkeychain.NewKeyFromString(convertToStandardXpub(key.Xpub, network))
+	if err != nil {
+		return keyOrigin{}, errorWithCode(errInvalidKey, "failed to parse xpub: %v", err)
+	}
+	pub, err := extKey.ECPubKey()
+	if err != nil {
+		return keyOrigin{}, err
+	}
+	return keyOrigin{fingerprint: btcutil.Hash160(pub.SerializeCompressed())[:4]}, nil
+}
+
+// parseFingerprint decodes an 8-hex-digit master key fingerprint.
+func parseFingerprint(s string) ([]byte, error) {
+	fp, err := hex.DecodeString(s)
+	if err != nil || len(fp) != 4 {
+		return nil, fmt.Errorf("invalid key fingerprint: %q", s)
+	}
+	return fp, nil
+}
+
+// psbtGlobalXpubs returns a PSBT_GLOBAL_XPUB record for each wallet key
+// with a known origin, which multisig signers use to check the cosigners.
+func psbtGlobalXpubs(spec *WalletSpec) (psbtMap, error) {
+	var m psbtMap
+	for _, k := range spec.Keys {
+		origin, err := accountKeyOrigin(k, spec.Network)
+		if err != nil {
+			return nil, err
+		}
+		if !origin.known {
+			continue
+		}
+		key, _, err := parseKeyOrigin(k.Xpub)
+		if err != nil {
+			return nil, err
+		}
+		serialized := base58.Decode(convertToStandardXpub(key.Xpub, spec.Network))
+		if len(serialized) != 82 {
+			return nil, errorWithCode(errInvalidKey, "invalid extended key: %s", key.Xpub)
+		}
+		m.add(psbtGlobalXpub, serialized[:78], origin.derivation(nil))
+	}
+	return m, nil
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Each cosigner of a multisig spend signs their own copy of the PSBT, and
+// the copies are merged into one that carries every signature: the BIP-174
+// combiner. A bad signature, from a confused signer or a tampered copy,
+// would otherwise only show when the transaction is finalized, after every
+// cosigner has signed. combine-psbt verifies each signature as it merges
+// it, against the output being spent and the key's place in its script,
+// and with a wallet spec against the key the wallet derives there, so the
+// copy it came from can be signed again.
+
+// psbtInTapLeafScript is PSBT_IN_TAP_LEAF_SCRIPT: a control block keying
+// a leaf script and its leaf version.
+const psbtInTapLeafScript = 0x15
+
+// CombineReport is the PSBT merged from several signed copies.
+type CombineReport struct {
+	TxID string `json:"txid"`
+	// PSBTs is the number of copies merged.
+	PSBTs  int             `json:"psbts"`
+	Inputs []CombinedInput `json:"inputs"`
+	// Complete is set when every input has the signatures it needs.
+	Complete bool `json:"complete"`
+	// PSBT is the merged PSBT, base64-encoded.
+	PSBT string `json:"psbt"`
+	// File is where --out wrote the PSBT in binary.
+	File string `json:"file,omitempty"`
+}
+
+// CombinedInput is the signatures merged for one input.
+type CombinedInput struct {
+	Index      int                 `json:"index"`
+	Signatures []CombinedSignature `json:"signatures"`
+	// Required is the signatures the input needs: the threshold of a
+	// multisig script, else one. A taproot input needs one key-path
+	// signature or every signature of one leaf.
+	Required int  `json:"required"`
+	Complete bool `json:"complete"`
+	// Finalized is set when a copy carried the input's final scriptSig or
+	// witness, whose signatures are not checked.
+	Finalized bool `json:"finalized,omitempty"`
+}
+
+// CombinedSignature is one verified signature.
+type CombinedSignature struct {
+	// Key is the key the signature verifies against: the output key of a
+	// taproot key-path spend.
+	Key string `json:"key"`
+	// LeafHash is the leaf a taproot script-path signature is for.
+	LeafHash    string `json:"leaf_hash,omitempty"`
+	Sighash     uint32 `json:"sighash"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Path        string `json:"path,omitempty"`
+	// PSBT is the first copy carrying the signature, counted from 0 in
+	// the order given.
+	PSBT int `json:"psbt"`
+}
+
+// psbtCombiner merges copies of a PSBT and verifies their signatures.
+type psbtCombiner struct {
+	merged    *psbtPacket
+	utxos     []*wire.TxOut
+	fetcher   *txscript.MultiPrevOutFetcher
+	sigHashes *txscript.TxSigHashes
+	spec      *WalletSpec
+	spends    map[[2]uint32]*spendInfo
+}
+
+// combinePSBTs merges copies of one PSBT, checking every signature each
+// carries; with spec, signing keys must be the wallet's.
+func combinePSBTs(copies []*psbtPacket, spec *WalletSpec) (*CombineReport, *psbtPacket, error) {
+	txid := copies[0].tx.TxHash()
+	merged := &psbtPacket{
+		tx:      copies[0].tx,
+		inputs:  make([]psbtMap, len(copies[0].inputs)),
+		outputs: make([]psbtMap, len(copies[0].outputs)),
+	}
+	for k, p := range copies {
+		if h := p.tx.TxHash(); h != txid {
+			return nil, nil, fmt.Errorf("psbt %d is for transaction %s, not %s", k, h, txid)
+		}
+		var err error
+		if merged.global, err = mergePSBTMap(merged.global, p.global, false); err != nil {
+			return nil, nil, fmt.Errorf("psbt %d global map: %v", k, err)
+		}
+		for i := range p.inputs {
+			if merged.inputs[i], err = mergePSBTMap(merged.inputs[i], p.inputs[i], true); err != nil {
+				return nil, nil, fmt.Errorf("psbt %d input %d: %v", k, i, err)
+			}
+		}
+		for j := range p.outputs {
+			if merged.outputs[j], err = mergePSBTMap(merged.outputs[j], p.outputs[j], false); err != nil {
+				return nil, nil, fmt.Errorf("psbt %d output %d: %v", k, j, err)
+			}
+		}
+	}
+
+	c := &psbtCombiner{
+		merged:  merged,
+		utxos:   make([]*wire.TxOut, len(merged.tx.TxIn)),
+		fetcher: txscript.NewMultiPrevOutFetcher(nil),
+		spec:    spec,
+		spends:  map[[2]uint32]*spendInfo{},
+	}
+	for i, in := range merged.tx.TxIn {
+		utxo, err := psbtInputUTXO(merged.inputs[i], in)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		c.utxos[i] = utxo
+		// The midstate needs an output for every input; segwit v0
+		// signatures commit only to their own, so a blank one stands in.
+		if utxo == nil {
+			utxo = &wire.TxOut{}
+		}
+		c.fetcher.AddPrevOut(in.PreviousOutPoint, utxo)
+	}
+	c.sigHashes = txscript.NewTxSigHashes(merged.tx, c.fetcher)
+
+	report := &CombineReport{TxID: txid.String(), PSBTs: len(copies), Inputs: make([]CombinedInput, len(merged.inputs)), Complete: true}
+	for i := range report.Inputs {
+		report.Inputs[i] = CombinedInput{Index: i, Signatures: []CombinedSignature{}}
+	}
+	// A signature is counted once per input, whichever copies carry it.
+	type signer struct {
+		input         int
+		key, leafHash string
+	}
+	seen := map[signer]bool{}
+	for k, p := range copies {
+		for i, m := range p.inputs {
+			for _, rec := range m {
+				switch rec.key[0] {
+				case psbtInPartialSig, psbtInTapKeySig, psbtInTapScriptSig:
+				default:
+					continue
+				}
+				sig, err := c.verify(i, rec)
+				if err != nil {
+					return nil, nil, errorWithCode(errInvalidSignature, "psbt %d input %d: %v", k, i, err)
+				}
+				sig.PSBT = k
+				if id := (signer{i, sig.Key, sig.LeafHash}); !seen[id] {
+					seen[id] = true
+					report.Inputs[i].Signatures = append(report.Inputs[i].Signatures, *sig)
+				}
+			}
+		}
+	}
+	for i := range report.Inputs {
+		c.complete(&report.Inputs[i])
+		report.Complete = report.Complete && report.Inputs[i].Complete
+	}
+	return report, merged, nil
+}
+
+// mergePSBTMap adds src's records to dst. A key both have must have the
+// same value, except a signature: a signer may sign twice, and both
+// signatures are checked.
+func mergePSBTMap(dst, src psbtMap, input bool) (psbtMap, error) {
+	for _, rec := range src {
+		found := false
+		for _, d := range dst {
+			if !bytes.Equal(d.key, rec.key) {
+				continue
+			}
+			found = true
+			signature := input && (rec.key[0] == psbtInPartialSig || rec.key[0] == psbtInTapKeySig || rec.key[0] == psbtInTapScriptSig)
+			if !signature && !bytes.Equal(d.value, rec.value) {
+				return nil, fmt.Errorf("record %x differs from an earlier copy's", rec.key)
+			}
+		}
+		if !found {
+			dst = append(dst, rec)
+		}
+	}
+	return dst, nil
+}
+
+// psbtInputUTXO returns the output an input spends, from its non-witness
+// or else witness UTXO record, or nil if it has neither.
+func psbtInputUTXO(m psbtMap, in *wire.TxIn) (*wire.TxOut, error) {
+	if raw, ok := m.get(psbtInNonWitnessUTXO); ok {
+		var prev wire.MsgTx
+		if err := prev.Deserialize(bytes.NewReader(raw)); err != nil {
+			return nil, fmt.Errorf("the previous transaction does not decode: %v", err)
+		}
+		if prev.TxHash() != in.PreviousOutPoint.Hash || int(in.PreviousOutPoint.Index) >= len(prev.TxOut) {
+			return nil, fmt.Errorf("the previous transaction does not have the output %s spent", in.PreviousOutPoint)
+		}
+		return prev.TxOut[in.PreviousOutPoint.Index], nil
+	}
+	if raw, ok := m.get(psbtInWitnessUTXO); ok {
+		var out wire.TxOut
+		if err := wire.ReadTxOut(bytes.NewReader(raw), 0, 0, &out); err != nil {
+			return nil, fmt.Errorf("the witness UTXO does not decode: %v", err)
+		}
+		return &out, nil
+	}
+	return nil, nil
+}
+
+// verify checks one signature record of input i against the merged
+// input's UTXO and scripts.
+func (c *psbtCombiner) verify(i int, rec psbtRecord) (*CombinedSignature, error) {
+	m := c.merged.inputs[i]
+	utxo := c.utxos[i]
+	if utxo == nil {
+		return nil, fmt.Errorf("the input is signed but no copy carries the output it spends")
+	}
+	taproot := txscript.GetScriptClass(utxo.PkScript) == txscript.WitnessV1TaprootTy
+	if rec.key[0] == psbtInPartialSig {
+		if taproot {
+			return nil, fmt.Errorf("a taproot input has a partial signature; it takes Schnorr signatures")
+		}
+		return c.verifyECDSA(i, rec)
+	}
+	if !taproot {
+		return nil, fmt.Errorf("a %s input has a taproot signature", txscript.GetScriptClass(utxo.PkScript))
+	}
+	for j, u := range c.utxos {
+		if u == nil {
+			return nil, fmt.Errorf("taproot signatures commit to every output spent, and no copy carries input %d's", j)
+		}
+	}
+
+	sigBytes := rec.value
+	hashType := txscript.SigHashDefault
+	switch {
+	case len(sigBytes) == 65 && sigBytes[64] != byte(txscript.SigHashDefault):
+		hashType = txscript.SigHashType(sigBytes[64])
+	case len(sigBytes) != 64:
+		return nil, fmt.Errorf("malformed Schnorr signature %x", sigBytes)
+	}
+	if err := checkSighashType(m, hashType); err != nil {
+		return nil, err
+	}
+	sig, err := schnorr.ParseSignature(sigBytes[:64])
+	if err != nil {
+		return nil, fmt.Errorf("malformed Schnorr signature: %v", err)
+	}
+
+	out := &CombinedSignature{Sighash: uint32(hashType)}
+	var keyBytes, hash, origin []byte
+	if rec.key[0] == psbtInTapKeySig {
+		if len(rec.key) != 1 {
+			return nil, fmt.Errorf("malformed key-path signature key %x", rec.key)
+		}
+		keyBytes = utxo.PkScript[2:34]
+		if hash, err = txscript.CalcTaprootSignatureHash(c.sigHashes, hashType, c.merged.tx, i, c.fetcher); err != nil {
+			return nil, err
+		}
+		// The output key is the tweaked internal key, whose origin the
+		// signer derived.
+		if internal, ok := m.get(psbtInTapInternalKey); ok {
+			origin = tapKeyOrigin(m, internal)
+		}
+	} else {
+		if len(rec.key) != 65 {
+			return nil, fmt.Errorf("malformed script-path signature key %x", rec.key)
+		}
+		keyBytes = rec.key[1:33]
+		leaf, ok := tapLeafByHash(m, rec.key[33:65])
+		if !ok {
+			return nil, fmt.Errorf("the signature by %x is for leaf %x, which no copy carries", keyBytes, rec.key[33:65])
+		}
+		if !bytes.Contains(leaf.Script, keyBytes) {
+			return nil, fmt.Errorf("key %x is not in the leaf it signs", keyBytes)
+		}
+		if hash, err = txscript.CalcTapscriptSignaturehash(c.sigHashes, hashType, c.merged.tx, i, c.fetcher, leaf); err != nil {
+			return nil, err
+		}
+		out.LeafHash = hex.EncodeToString(rec.key[33:65])
+		origin = tapKeyOrigin(m, keyBytes)
+	}
+	pub, err := schnorr.ParsePubKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %x: %v", keyBytes, err)
+	}
+	if !sig.Verify(hash, pub) {
+		return nil, fmt.Errorf("the signature by %x does not verify", keyBytes)
+	}
+	out.Key = hex.EncodeToString(keyBytes)
+	if rec.key[0] == psbtInTapKeySig {
+		if internal, ok := m.get(psbtInTapInternalKey); ok {
+			keyBytes = internal
+		}
+	}
+	return out, c.checkOrigin(i, out, keyBytes, origin)
+}
+
+// verifyECDSA checks a partial signature of a legacy or segwit v0 input.
+func (c *psbtCombiner) verifyECDSA(i int, rec psbtRecord) (*CombinedSignature, error) {
+	m := c.merged.inputs[i]
+	utxo := c.utxos[i]
+	keyBytes := rec.key[1:]
+	pub, err := btcec.ParsePubKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %x: %v", keyBytes, err)
+	}
+	if len(rec.value) < 2 {
+		return nil, fmt.Errorf("malformed signature by %x", keyBytes)
+	}
+	hashType := txscript.SigHashType(rec.value[len(rec.value)-1])
+	if err := checkSighashType(m, hashType); err != nil {
+		return nil, err
+	}
+	sig, err := ecdsa.ParseDERSignature(rec.value[:len(rec.value)-1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature by %x: %v", keyBytes, err)
+	}
+
+	// script is the one the signature commits to: the redeem script of
+	// a P2SH output, then the witness script of a P2WSH program.
+	redeem, _ := m.get(psbtInRedeemScript)
+	witnessScript, _ := m.get(psbtInWitnessScript)
+	script := utxo.PkScript
+	if txscript.GetScriptClass(script) == txscript.ScriptHashTy {
+		if !bytes.Equal(btcutil.Hash160(redeem), script[2:22]) {
+			return nil, fmt.Errorf("the input has no redeem script for the P2SH output it spends")
+		}
+		script = redeem
+	}
+	var hash []byte
+	switch txscript.GetScriptClass(script) {
+	case txscript.WitnessV0ScriptHashTy:
+		if h := sha256.Sum256(witnessScript); !bytes.Equal(h[:], script[2:]) {
+			return nil, fmt.Errorf("the input has no witness script for the P2WSH program it spends")
+		}
+		hash, err = txscript.CalcWitnessSigHash(witnessScript, c.sigHashes, hashType, c.merged.tx, i, utxo.Value)
+	case txscript.WitnessV0PubKeyHashTy:
+		hash, err = txscript.CalcWitnessSigHash(script, c.sigHashes, hashType, c.merged.tx, i, utxo.Value)
+	default:
+		hash, err = txscript.CalcSignatureHash(script, hashType, c.merged.tx, i)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !keyInScripts(keyBytes, utxo.PkScript, redeem, witnessScript) {
+		return nil, fmt.Errorf("key %x is not in the script the input spends", keyBytes)
+	}
+	if !sig.Verify(hash, pub) {
+		return nil, fmt.Errorf("the signature by %x does not verify", keyBytes)
+	}
+	out := &CombinedSignature{Key: hex.EncodeToString(keyBytes), Sighash: uint32(hashType)}
+	var origin []byte
+	for _, d := range m.all(psbtInBIP32Derivation) {
+		if bytes.Equal(d.key[1:], keyBytes) {
+			origin = d.value
+		}
+	}
+	return out, c.checkOrigin(i, out, keyBytes, origin)
+}
+
+// checkSighashType checks a signature's sighash type against the one the
+// input asks for, if any.
+func checkSighashType(m psbtMap, hashType txscript.SigHashType) error {
+	v, ok := m.get(psbtInSighashType)
+	if !ok || len(v) != 4 {
+		return nil
+	}
+	if want := txscript.SigHashType(binary.LittleEndian.Uint32(v)); want != hashType {
+		return fmt.Errorf("the signature has sighash type %#x, but the input asks for %#x", uint32(hashType), uint32(want))
+	}
+	return nil
+}
+
+// tapKeyOrigin returns the origin PSBT_IN_TAP_BIP32_DERIVATION gives an
+// x-only key, after the leaf hashes that precede it.
+func tapKeyOrigin(m psbtMap, key []byte) []byte {
+	for _, d := range m.all(psbtInTapBIP32Derivation) {
+		if !bytes.Equal(d.key[1:], key) {
+			continue
+		}
+		r := bytes.NewReader(d.value)
+		n, err := wire.ReadVarInt(r, 0)
+		if err != nil || n > uint64(r.Len())/32 {
+			return nil
+		}
+		return d.value[len(d.value)-r.Len()+int(n)*32:]
+	}
+	return nil
+}
+
+// tapLeafByHash returns the leaf script of input m with the given leaf
+// hash.
+func tapLeafByHash(m psbtMap, hash []byte) (txscript.TapLeaf, bool) {
+	for _, rec := range m.all(psbtInTapLeafScript) {
+		if len(rec.value) == 0 {
+			continue
+		}
+		script := rec.value[:len(rec.value)-1]
+		leaf := txscript.NewTapLeaf(txscript.TapscriptLeafVersion(rec.value[len(rec.value)-1]), script)
+		if h := leaf.TapHash(); bytes.Equal(h[:], hash) {
+			return leaf, true
+		}
+	}
+	return txscript.TapLeaf{}, false
+}
+
+// checkOrigin records a signing key's BIP-32 origin and, with a wallet
+// spec, checks that it is the key the wallet derives there and that the
+// input spends the wallet's output at that index.
+func (c *psbtCombiner) checkOrigin(i int, sig *CombinedSignature, key, origin []byte) error {
+	if len(origin) >= 4 && len(origin)%4 == 0 {
+		steps := make([]uint32, 0, len(origin)/4-1)
+		for b := origin[4:]; len(b) > 0; b = b[4:] {
+			steps = append(steps, binary.LittleEndian.Uint32(b))
+		}
+		sig.Fingerprint = hex.EncodeToString(origin[:4])
+		sig.Path = formatPath(steps)
+	}
+	if c.spec == nil {
+		return nil
+	}
+	if sig.Path == "" || len(origin) < 12 {
+		return fmt.Errorf("key %x has no BIP-32 origin to check against the wallet", key)
+	}
+	chain := binary.LittleEndian.Uint32(origin[len(origin)-8:])
+	index := binary.LittleEndian.Uint32(origin[len(origin)-4:])
+	at := [2]uint32{chain, index}
+	info, ok := c.spends[at]
+	if !ok {
+		var err error
+		if info, err = walletSpendInfo(c.spec, chain == 1, index); err != nil {
+			return err
+		}
+		c.spends[at] = info
+	}
+	addr, err := btcutil.DecodeAddress(info.address, getNetwork(c.spec.Network))
+	if err != nil {
+		return err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(script, c.utxos[i].PkScript) {
+		return fmt.Errorf("the input does not spend the wallet's address %s at %s", info.address, sig.Path)
+	}
+	for _, k := range info.keys {
+		if bytes.Equal(info.serializedKey(k), key) && bytes.Equal(k.derivation, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("key %x at %s is not one the wallet derives there", key, sig.Path)
+}
+
+// complete works out how many signatures input needs and whether it has
+// them.
+func (c *psbtCombiner) complete(in *CombinedInput) {
+	m := c.merged.inputs[in.Index]
+	_, finalScriptSig := m.get(psbtInFinalScriptSig)
+	_, finalWitness := m.get(psbtInFinalScriptWitness)
+	in.Finalized = finalScriptSig || finalWitness
+	in.Required = 1
+	utxo := c.utxos[in.Index]
+	if utxo == nil {
+		in.Complete = in.Finalized
+		return
+	}
+
+	if txscript.GetScriptClass(utxo.PkScript) == txscript.WitnessV1TaprootTy {
+		leafSigs := map[string]int{}
+		for _, s := range in.Signatures {
+			if s.LeafHash == "" {
+				in.Complete = true
+			}
+			leafSigs[s.LeafHash]++
+		}
+		for hash, n := range leafSigs {
+			b, _ := hex.DecodeString(hash)
+			if leaf, ok := tapLeafByHash(m, b); ok && hash != "" {
+				if need := tapLeafThreshold(leaf.Script); need > 0 && n >= need {
+					in.Complete = true
+				}
+			}
+		}
+		in.Complete = in.Complete || in.Finalized
+		return
+	}
+
+	script := utxo.PkScript
+	if redeem, ok := m.get(psbtInRedeemScript); ok {
+		script = redeem
+	}
+	if witnessScript, ok := m.get(psbtInWitnessScript); ok {
+		script = witnessScript
+	}
+	if n, ok := multisigThreshold(script); ok {
+		in.Required = n
+	}
+	in.Complete = in.Finalized || len(in.Signatures) >= in.Required
+}
+
+// tapLeafThreshold returns the signatures a pk() or multi_a() leaf
+// needs, or 0 for any other script.
+func tapLeafThreshold(script []byte) int {
+	var ops []byte
+	var last []byte
+	t := txscript.MakeScriptTokenizer(0, script)
+	for t.Next() {
+		ops = append(ops, t.Opcode())
+		if t.Opcode() != txscript.OP_NUMEQUAL {
+			last = t.Data()
+		}
+	}
+	if t.Err() != nil || len(ops) < 2 {
+		return 0
+	}
+	switch ops[len(ops)-1] {
+	case txscript.OP_CHECKSIG:
+		if len(ops) == 2 {
+			return 1
+		}
+	case txscript.OP_NUMEQUAL:
+		op := ops[len(ops)-2]
+		switch {
+		case op >= txscript.OP_1 && op <= txscript.OP_16:
+			return int(op-txscript.OP_1) + 1
+		case op >= txscript.OP_DATA_1 && op <= txscript.OP_DATA_2:
+			n := 0
+			for k := len(last) - 1; k >= 0; k-- {
+				n = n<<8 | int(last[k])
+			}
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// lint-psbt checks a PSBT without knowing the wallet it belongs to: that
+// every input carries the output it spends and the scripts and key origins
+// a signer needs, that the fee is sane, and that the outputs and timelocks
+// are what the network will relay and enforce. A finding is an error when
+// a signer should refuse the PSBT or the network would reject the
+// transaction, a warning when it is likely a mistake, and info when it is
+// unusual but may be deliberate.
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// maxLegacyOpReturnSize is the largest OP_RETURN script nodes before
+// Bitcoin Core 30 relay.
+const maxLegacyOpReturnSize = 83
+
+// PSBTLintReport lists what is wrong or unusual in a PSBT.
+type PSBTLintReport struct {
+	TxID     string `json:"txid"`
+	Version  int32  `json:"version"`
+	LockTime uint32 `json:"locktime"`
+	Inputs   int    `json:"inputs"`
+	Outputs  int    `json:"outputs"`
+	// InputTotal and Fee are known once every input carries the output it
+	// spends.
+	InputTotal  int64 `json:"input_total"`
+	OutputTotal int64 `json:"output_total"`
+	FeeKnown    bool  `json:"fee_known"`
+	Fee         int64 `json:"fee"`
+	// VSize is the estimated size once signed and FeeRate the fee over it,
+	// set when every input's spend size is known.
+	VSize    int           `json:"vsize,omitempty"`
+	FeeRate  float64       `json:"fee_rate,omitempty"`
+	Findings []PSBTFinding `json:"findings"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	// OK is set when there are no errors.
+	OK bool `json:"ok"`
+}
+
+// PSBTFinding is one problem found.
+type PSBTFinding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	// Scope is "global", "input" or "output", and Index the input or
+	// output.
+	Scope   string `json:"scope"`
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// psbtLinter collects the findings for one PSBT.
+type psbtLinter struct {
+	p      *psbtPacket
+	report *PSBTLintReport
+}
+
+func (l *psbtLinter) add(severity, code, scope string, index int, format string, args ...interface{}) {
+	l.report.Findings = append(l.report.Findings, PSBTFinding{Severity: severity, Code: code, Scope: scope, Index: index, Message: fmt.Sprintf(format, args...)})
+	switch severity {
+	case severityError:
+		l.report.Errors++
+	case severityWarning:
+		l.report.Warnings++
+	}
+}
+
+// lintPSBT checks p.
+func lintPSBT(p *psbtPacket) *PSBTLintReport {
+	tx := p.tx
+	l := &psbtLinter{p: p, report: &PSBTLintReport{
+		TxID:     tx.TxHash().String(),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Inputs:   len(tx.TxIn),
+		Outputs:  len(tx.TxOut),
+		Findings: []PSBTFinding{},
+	}}
+	r := l.report
+	if len(tx.TxIn) == 0 {
+		l.add(severityError, "no_inputs", "global", 0, "the transaction has no inputs")
+	}
+	if len(tx.TxOut) == 0 {
+		l.add(severityError, "no_outputs", "global", 0, "the transaction has no outputs")
+	}
+	if tx.Version < 1 || tx.Version > 3 {
+		l.add(severityWarning, "nonstandard_version", "global", 0, "transaction version %d is not relayed; versions 1 to 3 are", tx.Version)
+	}
+
+	feeKnown, sizeKnown := true, true
+	var sizes []inputSize
+	spent := map[wire.OutPoint]int{}
+	for i, in := range tx.TxIn {
+		if first, dup := spent[in.PreviousOutPoint]; dup {
+			l.add(severityError, "duplicate_input", "input", i, "spends %s, as input %d does", in.PreviousOutPoint, first)
+		} else {
+			spent[in.PreviousOutPoint] = i
+		}
+		if len(in.SignatureScript) != 0 {
+			l.add(severityError, "unsigned_tx_has_script_sig", "input", i, "the unsigned transaction carries a scriptSig; signatures belong in the input map")
+		}
+		utxo := l.inputUTXO(i, in)
+		if utxo == nil {
+			feeKnown, sizeKnown = false, false
+			continue
+		}
+		r.InputTotal += utxo.Value
+		size, ok := l.lintInput(i, utxo.PkScript)
+		if !ok {
+			sizeKnown = false
+			l.add(severityInfo, "size_unknown", "input", i, "the spend size of a %s output is not known, so neither is the fee rate", txscript.GetScriptClass(utxo.PkScript))
+		}
+		sizes = append(sizes, size)
+	}
+
+	var scripts [][]byte
+	for j, out := range tx.TxOut {
+		r.OutputTotal += out.Value
+		scripts = append(scripts, out.PkScript)
+		l.lintOutput(j, out)
+	}
+	l.lintTimelocks()
+
+	if feeKnown {
+		r.FeeKnown = true
+		r.Fee = r.InputTotal - r.OutputTotal
+		switch {
+		case r.Fee < 0:
+			l.add(severityError, "negative_fee", "global", 0, "the outputs spend %d sats more than the inputs hold", -r.Fee)
+		case r.Fee > r.OutputTotal:
+			l.add(severityWarning, "fee_exceeds_outputs", "global", 0, "the fee of %d sats is more than the %d sats the outputs receive", r.Fee, r.OutputTotal)
+		}
+		if r.Fee >= 0 && sizeKnown && len(sizes) > 0 {
+			r.VSize = vsize(txWeight(sizes, scripts))
+			r.FeeRate = float64(r.Fee) / float64(r.VSize)
+			rate := r.Fee * 1000 / int64(r.VSize)
+			switch {
+			case rate > maxFeeRate:
+				l.add(severityError, "absurd_fee", "global", 0, "the fee rate of %.1f sat/vB is above Bitcoin Core's %d sat/vB limit", r.FeeRate, maxFeeRate/1000)
+			case rate < minRelayFeeRate:
+				l.add(severityWarning, "low_fee_rate", "global", 0, "the fee rate of %.3f sat/vB is below the 1 sat/vB minimum relay fee", r.FeeRate)
+			}
+		}
+	}
+	r.OK = r.Errors == 0
+	return r
+}
+
+// inputUTXO returns the output input i spends, from its non-witness or
+// witness UTXO record, checking the two against each other and the
+// outpoint.
+func (l *psbtLinter) inputUTXO(i int, in *wire.TxIn) *wire.TxOut {
+	m := l.p.inputs[i]
+	var fromTx, witness *wire.TxOut
+	invalid := false
+	if raw, ok := m.get(psbtInNonWitnessUTXO); ok {
+		var prev wire.MsgTx
+		switch err := prev.Deserialize(bytes.NewReader(raw)); {
+		case err != nil:
+			invalid = true
+			l.add(severityError, "invalid_utxo", "input", i, "the previous transaction does not decode: %v", err)
+		case prev.TxHash() != in.PreviousOutPoint.Hash:
+			invalid = true
+			l.add(severityError, "utxo_mismatch", "input", i, "the previous transaction is %s, not the %s the input spends", prev.TxHash(), in.PreviousOutPoint.Hash)
+		case int(in.PreviousOutPoint.Index) >= len(prev.TxOut):
+			invalid = true
+			l.add(severityError, "utxo_mismatch", "input", i, "the previous transaction has no output %d", in.PreviousOutPoint.Index)
+		default:
+			fromTx = prev.TxOut[in.PreviousOutPoint.Index]
+		}
+	}
+	if raw, ok := m.get(psbtInWitnessUTXO); ok {
+		var out wire.TxOut
+		if err := wire.ReadTxOut(bytes.NewReader(raw), 0, 0, &out); err != nil {
+			invalid = true
+			l.add(severityError, "invalid_utxo", "input", i, "the witness UTXO does not decode: %v", err)
+		} else {
+			witness = &out
+		}
+	}
+	if fromTx != nil && witness != nil && (fromTx.Value != witness.Value || !bytes.Equal(fromTx.PkScript, witness.PkScript)) {
+		l.add(severityError, "utxo_mismatch", "input", i, "the witness UTXO differs from the output of the previous transaction")
+	}
+	switch {
+	case fromTx != nil:
+		return fromTx
+	case witness == nil:
+		if !invalid {
+			l.add(severityError, "missing_utxo", "input", i, "the input carries neither the output it spends nor its transaction")
+		}
+		return nil
+	}
+
+	// Without the previous transaction a signer takes the value on trust,
+	// which only segwit signatures commit to.
+	script := witness.PkScript
+	if redeem, ok := m.get(psbtInRedeemScript); ok && txscript.GetScriptClass(script) == txscript.ScriptHashTy {
+		script = redeem
+	}
+	switch {
+	case !txscript.IsWitnessProgram(script):
+		l.add(severityError, "missing_non_witness_utxo", "input", i, "a legacy input needs its previous transaction; the witness UTXO alone does not commit to its value")
+	case txscript.GetScriptClass(script) != txscript.WitnessV1TaprootTy:
+		l.add(severityWarning, "missing_non_witness_utxo", "input", i, "hardware signers want the previous transaction of a segwit v0 input too, so its value cannot be misstated")
+	}
+	return witness
+}
+
+// lintInput checks input i's scripts, key origins and sighash against the
+// output script it spends, and estimates the input's size once signed.
+func (l *psbtLinter) lintInput(i int, script []byte) (inputSize, bool) {
+	m := l.p.inputs[i]
+	finalScriptSig, hasFinalScriptSig := m.get(psbtInFinalScriptSig)
+	finalWitness, hasFinalWitness := m.get(psbtInFinalScriptWitness)
+	final := hasFinalScriptSig || hasFinalWitness
+	redeem, hasRedeem := m.get(psbtInRedeemScript)
+	witnessScript, hasWitnessScript := m.get(psbtInWitnessScript)
+
+	// inner is the script whose template the spend follows: the redeem
+	// script of a P2SH output.
+	class := txscript.GetScriptClass(script)
+	inner := script
+	switch {
+	case class == txscript.ScriptHashTy && !hasRedeem:
+		if !final {
+			l.add(severityError, "missing_redeem_script", "input", i, "the input spends a P2SH output but has no redeem script")
+		}
+	case class == txscript.ScriptHashTy:
+		if !bytes.Equal(btcutil.Hash160(redeem), script[2:22]) {
+			l.add(severityError, "redeem_script_mismatch", "input", i, "the redeem script does not hash to the P2SH output it spends")
+		} else {
+			inner = redeem
+		}
+	case hasRedeem:
+		l.add(severityError, "redeem_script_mismatch", "input", i, "the input has a redeem script but spends a %s output, not P2SH", class)
+	}
+	innerClass := txscript.GetScriptClass(inner)
+	switch {
+	case innerClass == txscript.WitnessV0ScriptHashTy && !hasWitnessScript:
+		if !final {
+			l.add(severityError, "missing_witness_script", "input", i, "the input spends a P2WSH output but has no witness script")
+		}
+	case innerClass == txscript.WitnessV0ScriptHashTy:
+		if h := sha256.Sum256(witnessScript); !bytes.Equal(h[:], inner[2:]) {
+			l.add(severityError, "witness_script_mismatch", "input", i, "the witness script does not hash to the P2WSH program it spends")
+		}
+	case hasWitnessScript:
+		l.add(severityError, "witness_script_mismatch", "input", i, "the input has a witness script but does not spend a P2WSH output")
+	}
+
+	derivations := m.all(psbtInBIP32Derivation)
+	if !final && len(derivations) == 0 && len(m.all(psbtInTapBIP32Derivation)) == 0 {
+		l.add(severityWarning, "missing_bip32_derivation", "input", i, "no key has a BIP-32 origin, so a hardware signer cannot tell which of its keys signs")
+	}
+	for _, d := range derivations {
+		if pub := d.key[1:]; !keyInScripts(pub, script, redeem, witnessScript) {
+			l.add(severityWarning, "derivation_not_in_script", "input", i, "the key %x has a BIP-32 origin but is not in the script spent", pub)
+		}
+	}
+	if v, ok := m.get(psbtInSighashType); ok && len(v) == 4 {
+		sighash := txscript.SigHashType(binary.LittleEndian.Uint32(v))
+		if sighash != txscript.SigHashAll && !(sighash == txscript.SigHashDefault && class == txscript.WitnessV1TaprootTy) {
+			l.add(severityWarning, "sighash_not_all", "input", i, "the input asks for sighash type %#x, which leaves parts of the transaction unsigned", uint32(sighash))
+		}
+	}
+
+	if final {
+		size := scriptSigInput(len(finalScriptSig))
+		size.witness = len(finalWitness)
+		return size, true
+	}
+	return psbtInputSize(class, script, redeem, witnessScript)
+}
+
+// keyInScripts reports whether a key, or its hash, is in any of scripts.
+func keyInScripts(pub []byte, scripts ...[]byte) bool {
+	hash := btcutil.Hash160(pub)
+	for _, s := range scripts {
+		if bytes.Contains(s, pub) || bytes.Contains(s, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// psbtInputSize estimates the size of an unsigned input once signed, from
+// the template of the script it spends. A taproot input is taken to spend
+// by its key path.
+func psbtInputSize(class txscript.ScriptClass, script, redeem, witnessScript []byte) (inputSize, bool) {
+	sig := 1 + ecdsaSignatureSize
+	switch class {
+	case txscript.PubKeyHashTy:
+		return scriptSigInput(sig + 1 + 33), true
+	case txscript.PubKeyTy:
+		return scriptSigInput(sig), true
+	case txscript.MultiSigTy:
+		if m, ok := multisigThreshold(script); ok {
+			return scriptSigInput(1 + m*sig), true
+		}
+	case txscript.WitnessV0PubKeyHashTy:
+		return witnessInput(0, ecdsaSignatureSize, 33), true
+	case txscript.WitnessV1TaprootTy:
+		return witnessInput(0, schnorrSignatureSize), true
+	case txscript.WitnessV0ScriptHashTy:
+		return witnessMultisigInput(0, witnessScript)
+	case txscript.ScriptHashTy:
+		switch txscript.GetScriptClass(redeem) {
+		case txscript.WitnessV0PubKeyHashTy:
+			return witnessInput(pushSize(len(redeem)), ecdsaSignatureSize, 33), true
+		case txscript.WitnessV0ScriptHashTy:
+			return witnessMultisigInput(pushSize(len(redeem)), witnessScript)
+		case txscript.MultiSigTy:
+			if m, ok := multisigThreshold(redeem); ok {
+				return scriptSigInput(1 + m*sig + pushSize(len(redeem))), true
+			}
+		}
+	}
+	return inputSize{}, false
+}
+
+// witnessMultisigInput is the size of an input spending a multisig witness
+// script, with a scriptSig of the given size.
+func witnessMultisigInput(scriptSig int, witnessScript []byte) (inputSize, bool) {
+	m, ok := multisigThreshold(witnessScript)
+	if !ok {
+		return inputSize{}, false
+	}
+	items := []int{0}
+	for i := 0; i < m; i++ {
+		items = append(items, ecdsaSignatureSize)
+	}
+	return witnessInput(scriptSig, append(items, len(witnessScript))...), true
+}
+
+// multisigThreshold returns the signatures a multisig script needs.
+func multisigThreshold(script []byte) (int, bool) {
+	if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+		return 0, false
+	}
+	_, m, err := txscript.CalcMultiSigStats(script)
+	return m, err == nil
+}
+
+// lintOutput checks output j's script and value, and the scripts its map
+// gives against it.
+func (l *psbtLinter) lintOutput(j int, out *wire.TxOut) {
+	script := out.PkScript
+	class := txscript.GetScriptClass(script)
+	switch {
+	case len(script) > 0 && script[0] == txscript.OP_RETURN:
+		if out.Value > 0 {
+			l.add(severityWarning, "op_return_value", "output", j, "the OP_RETURN output burns %d sats", out.Value)
+		}
+		if len(script) > maxLegacyOpReturnSize {
+			l.add(severityWarning, "large_op_return", "output", j, "the %d-byte OP_RETURN script is over the %d bytes nodes before Bitcoin Core 30 relay", len(script), maxLegacyOpReturnSize)
+		}
+	case class == txscript.NonStandardTy:
+		l.add(severityError, "nonstandard_output", "output", j, "the output script is not a standard template, so nodes will not relay the transaction")
+	case class == txscript.MultiSigTy && !isStandardBareMultisig(script):
+		l.add(severityError, "nonstandard_output", "output", j, "bare multisig outputs of more than %d keys are not relayed", maxBareMultisigKeys)
+	case out.Value < dustThreshold(script):
+		l.add(severityError, "dust_output", "output", j, "the output's %d sats are below the dust threshold of %d", out.Value, dustThreshold(script))
+	}
+
+	m := l.p.outputs[j]
+	redeem, hasRedeem := m.get(psbtOutRedeemScript)
+	witnessScript, hasWitnessScript := m.get(psbtOutWitnessScript)
+	program := script
+	if hasRedeem {
+		if class != txscript.ScriptHashTy || !bytes.Equal(btcutil.Hash160(redeem), script[2:22]) {
+			l.add(severityError, "redeem_script_mismatch", "output", j, "the redeem script does not hash to the output script")
+		} else {
+			program = redeem
+		}
+	}
+	if hasWitnessScript {
+		h := sha256.Sum256(witnessScript)
+		if txscript.GetScriptClass(program) != txscript.WitnessV0ScriptHashTy || !bytes.Equal(h[:], program[2:]) {
+			l.add(severityError, "witness_script_mismatch", "output", j, "the witness script does not hash to the output's P2WSH program")
+		}
+	}
+}
+
+// isStandardBareMultisig reports whether a bare multisig output has few
+// enough keys to be relayed.
+func isStandardBareMultisig(script []byte) bool {
+	n, _, err := txscript.CalcMultiSigStats(script)
+	return err == nil && n <= maxBareMultisigKeys
+}
+
+// lintTimelocks checks the locktime and sequences for locks that will not
+// be enforced or that delay confirmation.
+func (l *psbtLinter) lintTimelocks() {
+	tx := l.p.tx
+	final := len(tx.TxIn) > 0
+	sequences := map[uint32]bool{}
+	for i, in := range tx.TxIn {
+		final = final && in.Sequence == wire.MaxTxInSequenceNum
+		sequences[in.Sequence] = true
+		if in.Sequence&wire.SequenceLockTimeDisabled != 0 {
+			continue
+		}
+		if tx.Version < 2 {
+			l.add(severityWarning, "relative_locktime_ignored", "input", i, "the sequence %#x encodes a relative timelock, which version %d transactions do not enforce", in.Sequence, tx.Version)
+			continue
+		}
+		lock := in.Sequence & wire.SequenceLockTimeMask
+		if in.Sequence&wire.SequenceLockTimeIsSeconds != 0 {
+			l.add(severityInfo, "relative_locktime", "input", i, "the input cannot confirm until %d seconds after the output it spends", lock<<wire.SequenceLockTimeGranularity)
+		} else {
+			l.add(severityInfo, "relative_locktime", "input", i, "the input cannot confirm until %d blocks after the output it spends", lock)
+		}
+	}
+	if len(sequences) > 1 {
+		l.add(severityInfo, "mixed_sequences", "global", 0, "the inputs use %d different sequence numbers, which sets the transaction apart from those wallets build", len(sequences))
+	}
+	if tx.LockTime != 0 && final {
+		l.add(severityWarning, "locktime_disabled", "global", 0, "the locktime %d is not enforced: every input's sequence is final", tx.LockTime)
+	}
+	if tx.LockTime >= txscript.LockTimeThreshold {
+		l.add(severityInfo, "timestamp_locktime", "global", 0, "the locktime is a time, %s, not a block height", time.Unix(int64(tx.LockTime), 0).UTC().Format(time.RFC3339))
+	}
+}
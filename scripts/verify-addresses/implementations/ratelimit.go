@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimit caps how often a backend is called, for public servers that
+// ban clients sweeping large wallets. A batch lookup is one call. Burst
+// calls may be made back to back (default 1); PerSecond 0 leaves calls
+// unlimited.
+type RateLimit struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst,omitempty"`
+}
+
+func (l RateLimit) check() error {
+	if l.PerSecond < 0 || l.Burst < 0 {
+		return fmt.Errorf("invalid rate_limit: per_second and burst must not be negative")
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket shared by every connection to one backend
+// kind, so the limit holds across the requests of a long-running watch.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+var rateLimiters = map[string]*rateLimiter{}
+
+// limiterFor returns the limiter of the named backend, or nil when calls
+// are unlimited. A changed limit (after a config reload) applies at once.
+func limiterFor(name string, limit RateLimit) *rateLimiter {
+	if limit.PerSecond == 0 {
+		delete(rateLimiters, name)
+		return nil
+	}
+	if limit.Burst == 0 {
+		limit.Burst = 1
+	}
+	l, ok := rateLimiters[name]
+	if !ok {
+		l = &rateLimiter{tokens: float64(limit.Burst), last: time.Now()}
+		rateLimiters[name] = l
+	}
+	l.mu.Lock()
+	l.limit = limit
+	l.tokens = min(l.tokens, float64(limit.Burst))
+	l.mu.Unlock()
+	return l
+}
+
+// wait blocks until a call may be made.
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.limit.PerSecond, float64(l.limit.Burst))
+	l.last = now
+	if l.tokens < 1 {
+		time.Sleep(time.Duration((1 - l.tokens) / l.limit.PerSecond * float64(time.Second)))
+		l.tokens, l.last = 1, time.Now()
+	}
+	l.tokens--
+}
+
+type rateLimitedBackend struct {
+	inner   ChainBackend
+	limiter *rateLimiter
+}
+
+// rateLimitedBatchBackend is a rateLimitedBackend over a batchBackend.
+type rateLimitedBatchBackend struct {
+	*rateLimitedBackend
+}
+
+// rateLimitBackend wraps backend when its config sets a rate limit.
+func rateLimitBackend(backend ChainBackend, limit RateLimit) ChainBackend {
+	limiter := limiterFor(backend.Name(), limit)
+	if limiter == nil {
+		return backend
+	}
+	r := &rateLimitedBackend{inner: backend, limiter: limiter}
+	if _, ok := backend.(batchBackend); ok {
+		return rateLimitedBatchBackend{r}
+	}
+	return r
+}
+
+func (r *rateLimitedBackend) Name() string { return r.inner.Name() }
+
+func (r *rateLimitedBackend) Close() error { return r.inner.Close() }
+
+func (r *rateLimitedBackend) TipHeight() (int64, error) {
+	r.limiter.wait()
+	return r.inner.TipHeight()
+}
+
+func (r *rateLimitedBackend) AddressHistory(address string) ([]TxRef, error) {
+	r.limiter.wait()
+	return r.inner.AddressHistory(address)
+}
+
+func (r *rateLimitedBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	r.limiter.wait()
+	return r.inner.AddressUTXOs(address)
+}
+
+func (r *rateLimitedBackend) RawTransaction(txid string) ([]byte, error) {
+	r.limiter.wait()
+	return rawTransaction(r.inner, txid)
+}
+
+func (r *rateLimitedBackend) BlockHash(height int64) (string, error) {
+	r.limiter.wait()
+	return backendBlockHash(r.inner, height)
+}
+
+func (r *rateLimitedBackend) RawBlock(hash string) ([]byte, error) {
+	r.limiter.wait()
+	return rawBlock(r.inner, hash)
+}
+
+func (r *rateLimitedBackend) FeeRate(target int, mode string) (int64, error) {
+	r.limiter.wait()
+	return backendFeeRate(r.inner, target, mode)
+}
+
+func (r rateLimitedBatchBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	r.limiter.wait()
+	return r.inner.(batchBackend).AddressHistories(addresses)
+}
+
+func (r rateLimitedBatchBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	r.limiter.wait()
+	return r.inner.(batchBackend).AddressUTXOSets(addresses)
+}
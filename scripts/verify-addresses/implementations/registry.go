@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// The wallet registry keeps named wallet specs in the --store, per tenant.
+// A registered wallet is then given as "wallet:<name>" (or
+// "wallet:<wallet id>") wherever a wallet spec is expected, so request
+// files carry a name and an index rather than the wallet's xpubs. The
+// tenant is the --tenant of the run: a watch started with --tenant only
+// sees that tenant's wallets, and request files cannot choose another.
+
+// walletRefPrefix marks a wallet spec argument naming a registered wallet.
+const walletRefPrefix = "wallet:"
+
+// defaultTenant is the tenant of runs without --tenant.
+const defaultTenant = "default"
+
+// registryName is the form of tenant and wallet names.
+var registryName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// RegisteredWallet describes a wallet in the registry, without its keys.
+type RegisteredWallet struct {
+	Tenant     string `json:"tenant"`
+	Name       string `json:"name"`
+	WalletID   string `json:"wallet_id"`
+	Network    string `json:"network"`
+	ScriptType string `json:"script_type"`
+	Threshold  int    `json:"threshold,omitempty"`
+	Keys       int    `json:"keys"`
+	CreatedAt  string `json:"created_at"`
+	// Keychain names the OS keychain holding the wallet's spec, if any.
+	Keychain string `json:"keychain,omitempty"`
+}
+
+// RegistryReport is the output of register-wallet, unregister-wallet and
+// list-wallets.
+type RegistryReport struct {
+	Tenant  string             `json:"tenant"`
+	Wallets []RegisteredWallet `json:"wallets"`
+}
+
+// tenant returns the tenant of the run.
+func tenant() string {
+	if options.tenant == "" {
+		return defaultTenant
+	}
+	return options.tenant
+}
+
+func checkRegistryName(kind, name string) error {
+	if !registryName.MatchString(name) {
+		return fmt.Errorf("invalid %s name %q (want up to 64 letters, digits, '.', '_' or '-')", kind, name)
+	}
+	return nil
+}
+
+// openRegistry opens the store holding the registry.
+func openRegistry() (*walletStore, error) {
+	if options.storePath == "" {
+		return nil, fmt.Errorf("the wallet registry needs a wallet state store (--store <file>)")
+	}
+	return openConfiguredStore()
+}
+
+// loadRegisteredWallet resolves a "wallet:" reference in the run's tenant.
+func loadRegisteredWallet(ref string) (*WalletSpec, error) {
+	store, err := openRegistry()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	spec, _, err := store.registeredWallet(tenant(), ref)
+	return spec, err
+}
+
+// registerTenantWallet adds spec to the tenant's registry under name,
+// replacing any wallet registered under it before. With inKeychain the spec
+// goes to the OS keychain, and the store only keeps a summary; the
+// wallet's descriptor is then not kept in the store either.
+func (s *walletStore) registerTenantWallet(tenant, name string, spec *WalletSpec, inKeychain bool) (*RegisteredWallet, error) {
+	var previous *keychainEntry
+	var old string
+	switch err := s.db.QueryRow(`SELECT spec FROM registered_wallets WHERE tenant = ? AND name = ?`, tenant, name).Scan(&old); err {
+	case nil:
+		previous = keychainEntryOf(old)
+	case sql.ErrNoRows:
+	default:
+		return nil, fmt.Errorf("failed to read wallet registry: %v", err)
+	}
+
+	id, err := s.registerWallet(spec)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	row := string(data)
+	if inKeychain {
+		if row, err = storeInKeychain(tenant, name, spec, data); err != nil {
+			return nil, err
+		}
+		if _, err := s.db.Exec(`UPDATE wallets SET descriptor = '' WHERE id = ?`, id); err != nil {
+			return nil, fmt.Errorf("failed to register wallet: %v", err)
+		}
+	}
+	now := time.Now()
+	if _, err := s.db.Exec(
+		`INSERT INTO registered_wallets (tenant, name, wallet_id, spec, created_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(tenant, name) DO UPDATE SET
+			wallet_id = excluded.wallet_id, spec = excluded.spec, created_at = excluded.created_at`,
+		tenant, name, id, row, now.Unix(),
+	); err != nil {
+		return nil, fmt.Errorf("failed to register wallet: %v", err)
+	}
+	if previous != nil && !inKeychain {
+		if err := removeFromKeychain(tenant, name, previous); err != nil {
+			diagnostic("warning: %v", err)
+		}
+	}
+	w := describeRegistered(tenant, name, id, spec, now.Unix())
+	w.Keychain = keychainEntryOf(row).backend()
+	return &w, nil
+}
+
+// keychainEntryOf returns the keychain entry a registry row holds, or nil
+// for a row holding the spec itself.
+func keychainEntryOf(data string) *keychainEntry {
+	var row keychainRow
+	if json.Unmarshal([]byte(data), &row) != nil {
+		return nil
+	}
+	return row.Keychain
+}
+
+func (e *keychainEntry) backend() string {
+	if e == nil {
+		return ""
+	}
+	return e.Backend
+}
+
+// registryRow looks up a wallet of the tenant by name or wallet ID, and
+// returns its name and the spec column as stored.
+func (s *walletStore) registryRow(tenant, ref string) (name, data string, err error) {
+	err = s.db.QueryRow(
+		`SELECT name, spec FROM registered_wallets WHERE tenant = ? AND (name = ? OR wallet_id = ?) ORDER BY name = ? DESC LIMIT 1`,
+		tenant, ref, ref, ref,
+	).Scan(&name, &data)
+	if err == sql.ErrNoRows {
+		return "", "", errorWithCode(errUnknownWallet, "no wallet %q is registered for tenant %s", ref, tenant)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read wallet registry: %v", err)
+	}
+	return name, data, nil
+}
+
+// registeredWallet looks up a wallet of the tenant by name or wallet ID.
+func (s *walletStore) registeredWallet(tenant, ref string) (*WalletSpec, string, error) {
+	name, data, err := s.registryRow(tenant, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	if entry := keychainEntryOf(data); entry != nil {
+		secret, err := loadFromKeychain(tenant, name, entry)
+		if err != nil {
+			return nil, "", err
+		}
+		data = string(secret)
+	}
+	var spec WalletSpec
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		return nil, "", fmt.Errorf("wallet %s in the registry is corrupt: %v", name, err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, "", fmt.Errorf("wallet %s in the registry is invalid: %v", name, err)
+	}
+	return &spec, name, nil
+}
+
+// unregisterWallet removes a wallet from the tenant's registry, and from
+// the keychain if it is kept there. Its state in the store (addresses,
+// labels, checkpoints) is kept.
+func (s *walletStore) unregisterWallet(tenant, ref string) error {
+	name, data, err := s.registryRow(tenant, ref)
+	if err != nil {
+		return err
+	}
+	if entry := keychainEntryOf(data); entry != nil {
+		if err := removeFromKeychain(tenant, name, entry); err != nil {
+			return err
+		}
+	}
+	if _, err := s.db.Exec(`DELETE FROM registered_wallets WHERE tenant = ? AND name = ?`, tenant, name); err != nil {
+		return fmt.Errorf("failed to unregister wallet: %v", err)
+	}
+	return nil
+}
+
+// tenantWallets lists the tenant's wallets by name.
+func (s *walletStore) tenantWallets(tenant string) ([]RegisteredWallet, error) {
+	rows, err := s.db.Query(`SELECT name, wallet_id, spec, created_at FROM registered_wallets WHERE tenant = ? ORDER BY name`, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet registry: %v", err)
+	}
+	defer rows.Close()
+	wallets := []RegisteredWallet{}
+	for rows.Next() {
+		var name, id, data string
+		var created int64
+		if err := rows.Scan(&name, &id, &data, &created); err != nil {
+			return nil, err
+		}
+		// Wallets in the keychain are described from their summary, so
+		// listing them does not unlock it.
+		if entry := keychainEntryOf(data); entry != nil {
+			w := describeRegistered(tenant, name, id, entry.summary(), created)
+			w.Keychain = entry.Backend
+			wallets = append(wallets, w)
+			continue
+		}
+		var spec WalletSpec
+		if err := json.Unmarshal([]byte(data), &spec); err != nil {
+			return nil, fmt.Errorf("wallet %s in the registry is corrupt: %v", name, err)
+		}
+		wallets = append(wallets, describeRegistered(tenant, name, id, &spec, created))
+	}
+	return wallets, rows.Err()
+}
+
+func describeRegistered(tenant, name, id string, spec *WalletSpec, created int64) RegisteredWallet {
+	return RegisteredWallet{
+		Tenant:     tenant,
+		Name:       name,
+		WalletID:   id,
+		Network:    spec.Network,
+		ScriptType: spec.ScriptType,
+		Threshold:  spec.Threshold,
+		Keys:       len(spec.Keys),
+		CreatedAt:  time.Unix(created, 0).UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// AuditReport is the onboarding artifact of a wallet: everything a custody
+// reviewer checks against the signing devices and the coordinator, and
+// which binary produced it.
+type AuditReport struct {
+	Generated         string           `json:"generated"`
+	Name              string           `json:"name,omitempty"`
+	Network           string           `json:"network"`
+	ScriptType        string           `json:"script_type"`
+	Threshold         int              `json:"threshold,omitempty"`
+	TotalKeys         int              `json:"total_keys"`
+	Uncompressed      bool             `json:"uncompressed,omitempty"`
+	ReceiveDescriptor string           `json:"receive_descriptor,omitempty"`
+	ChangeDescriptor  string           `json:"change_descriptor,omitempty"`
+	Cosigners         []ReportCosigner `json:"cosigners"`
+	Receive           []DerivedAddress `json:"receive"`
+	Change            []DerivedAddress `json:"change"`
+	Checks            []AddressCheck   `json:"checks,omitempty"`
+	Verified          bool             `json:"verified"`
+	Engines           []ReportEngine   `json:"engines"`
+	CurveBackend      string           `json:"curve_backend"`
+	Build             *BuildProvenance `json:"build"`
+}
+
+// ReportCosigner is one key of the wallet. Fingerprint is the master key
+// fingerprint of its origin, as the spec gives it; KeyFingerprint is that
+// of the account key itself, which devices show when exporting it.
+type ReportCosigner struct {
+	Xpub           string `json:"xpub"`
+	KeyFingerprint string `json:"key_fingerprint"`
+	Fingerprint    string `json:"fingerprint,omitempty"`
+	Path           string `json:"path,omitempty"`
+	Device         string `json:"device,omitempty"`
+	Label          string `json:"label,omitempty"`
+}
+
+// ReportEngine is an address engine that derived the report's addresses.
+type ReportEngine struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func walletAuditReport(spec *WalletSpec, count int) (*AuditReport, error) {
+	wallet, err := verifyWallet(spec, count)
+	if err != nil {
+		return nil, err
+	}
+	report := &AuditReport{
+		Generated:         time.Now().UTC().Format(time.RFC3339),
+		Name:              spec.Name,
+		Network:           spec.Network,
+		ScriptType:        spec.ScriptType,
+		Threshold:         spec.Threshold,
+		TotalKeys:         len(spec.Keys),
+		Uncompressed:      spec.Uncompressed,
+		ReceiveDescriptor: wallet.Descriptor,
+		Cosigners:         []ReportCosigner{},
+		Receive:           wallet.Receive,
+		Change:            wallet.Change,
+		Checks:            wallet.Checks,
+		Verified:          wallet.Verified,
+		CurveBackend:      activeCurve.name,
+		Build:             buildProvenance(),
+	}
+	if wallet.Descriptor != "" {
+		if report.ChangeDescriptor, err = walletDescriptor(spec, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range spec.Keys {
+		fingerprint, err := keyFingerprint(k.Xpub)
+		if err != nil {
+			return nil, err
+		}
+		report.Cosigners = append(report.Cosigners, ReportCosigner{
+			Xpub:           k.Xpub,
+			KeyFingerprint: fingerprint,
+			Fingerprint:    k.Fingerprint,
+			Path:           k.Path,
+			Device:         k.Device,
+			Label:          k.Label,
+		})
+	}
+
+	// Under --paranoid every engine derived every address.
+	engines := []*addressEngine{selectedEngine()}
+	if options.paranoid {
+		engines = addressEngines
+	}
+	for _, e := range engines {
+		report.Engines = append(report.Engines, ReportEngine{Name: e.name, Version: engineVersion(e, report.Build)})
+	}
+	return report, nil
+}
+
+// keyFingerprint returns the BIP-32 fingerprint of an extended public key
+// of any SLIP-132 version.
+func keyFingerprint(xpub string) (string, error) {
+	b, err := base58CheckDecode(xpub)
+	if err != nil || len(b) != 78 {
+		return "", errorWithCode(errInvalidKey, "invalid extended public key: %s", xpub)
+	}
+	return hex.EncodeToString(btcutil.Hash160(b[45:78])[:4]), nil
+}
+
+// engineVersion names the code an engine runs: the btcsuite modules for
+// btcsuite, and this binary's own commit for the in-package engine.
+func engineVersion(e *addressEngine, build *BuildProvenance) string {
+	if e == addressEngines[0] {
+		var versions []string
+		for _, m := range build.Modules {
+			versions = append(versions, m.Path+"@"+m.Version)
+		}
+		return strings.Join(versions, " ")
+	}
+	if build.Commit == "" {
+		return "devel"
+	}
+	version := build.Commit
+	if build.Modified {
+		version += "+modified"
+	}
+	return version
+}
+
+// ReportDiff is the output of diff-report. Unchanged is set when the two
+// reports agree on everything compared; Drifted when they disagree on the
+// wallet itself (its policy, descriptors, cosigner keys or an address both
+// derived), rather than only on the engines that derived it.
+type ReportDiff struct {
+	Old       string         `json:"old"`
+	New       string         `json:"new"`
+	Compared  int            `json:"compared_addresses"`
+	Changes   []ReportChange `json:"changes"`
+	Drifted   bool           `json:"drifted"`
+	Unchanged bool           `json:"unchanged"`
+}
+
+// ReportChange is one difference between two reports. Category is one of
+// "wallet", "descriptor", "cosigner", "address", "coverage" (an address
+// only one report derived) or "engine".
+type ReportChange struct {
+	Category string `json:"category"`
+	Field    string `json:"field"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+}
+
+func loadAuditReport(path string) (*AuditReport, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %v", err)
+	}
+	var r AuditReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %v", path, err)
+	}
+	if r.Network == "" || r.ScriptType == "" {
+		return nil, fmt.Errorf("%s is not a report", path)
+	}
+	return &r, nil
+}
+
+// diffReports compares two audit reports of a wallet.
+func diffReports(old, new *AuditReport) *ReportDiff {
+	d := &ReportDiff{Old: old.Generated, New: new.Generated, Changes: []ReportChange{}}
+	change := func(category, field, o, n string) {
+		if o != n {
+			d.Changes = append(d.Changes, ReportChange{Category: category, Field: field, Old: o, New: n})
+		}
+	}
+
+	change("wallet", "network", old.Network, new.Network)
+	change("wallet", "script_type", old.ScriptType, new.ScriptType)
+	change("wallet", "threshold", strconv.Itoa(old.Threshold), strconv.Itoa(new.Threshold))
+	change("wallet", "total_keys", strconv.Itoa(old.TotalKeys), strconv.Itoa(new.TotalKeys))
+	change("wallet", "uncompressed", strconv.FormatBool(old.Uncompressed), strconv.FormatBool(new.Uncompressed))
+	change("wallet", "verified", strconv.FormatBool(old.Verified), strconv.FormatBool(new.Verified))
+	change("descriptor", "receive_descriptor", old.ReceiveDescriptor, new.ReceiveDescriptor)
+	change("descriptor", "change_descriptor", old.ChangeDescriptor, new.ChangeDescriptor)
+
+	for i := 0; i < max(len(old.Cosigners), len(new.Cosigners)); i++ {
+		var o, n ReportCosigner
+		if i < len(old.Cosigners) {
+			o = old.Cosigners[i]
+		}
+		if i < len(new.Cosigners) {
+			n = new.Cosigners[i]
+		}
+		field := fmt.Sprintf("cosigners[%d].", i)
+		change("cosigner", field+"xpub", o.Xpub, n.Xpub)
+		change("cosigner", field+"key_fingerprint", o.KeyFingerprint, n.KeyFingerprint)
+		change("cosigner", field+"fingerprint", o.Fingerprint, n.Fingerprint)
+		change("cosigner", field+"path", o.Path, n.Path)
+		change("cosigner", field+"device", o.Device, n.Device)
+		change("cosigner", field+"label", o.Label, n.Label)
+	}
+
+	for _, chain := range []struct {
+		name     string
+		old, new []DerivedAddress
+	}{{"receive", old.Receive, new.Receive}, {"change", old.Change, new.Change}} {
+		derived := map[uint32]string{}
+		for _, a := range chain.new {
+			derived[a.Index] = a.Address
+		}
+		for _, a := range chain.old {
+			field := fmt.Sprintf("%s[%d]", chain.name, a.Index)
+			n, ok := derived[a.Index]
+			if !ok {
+				change("coverage", field, a.Address, "")
+				continue
+			}
+			d.Compared++
+			change("address", field, a.Address, n)
+			delete(derived, a.Index)
+		}
+		for _, a := range chain.new {
+			if _, ok := derived[a.Index]; ok {
+				change("coverage", fmt.Sprintf("%s[%d]", chain.name, a.Index), "", a.Address)
+			}
+		}
+	}
+
+	engines := map[string]string{}
+	for _, e := range new.Engines {
+		engines[e.Name] = e.Version
+	}
+	for _, e := range old.Engines {
+		change("engine", "engines."+e.Name, e.Version, engines[e.Name])
+		delete(engines, e.Name)
+	}
+	for _, e := range new.Engines {
+		if _, ok := engines[e.Name]; ok {
+			change("engine", "engines."+e.Name, "", e.Version)
+		}
+	}
+	change("engine", "curve_backend", old.CurveBackend, new.CurveBackend)
+
+	for _, c := range d.Changes {
+		d.Drifted = d.Drifted || c.Category != "engine" && c.Category != "coverage"
+	}
+	d.Unchanged = len(d.Changes) == 0
+	return d
+}
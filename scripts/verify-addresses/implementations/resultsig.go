@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// With --sign-key every result document is signed with the operator's
+// ed25519 key before it is written, so whoever consumes it downstream can
+// check it was not edited and which verifier produced it. The signature is
+// embedded in the document as "result_signature" and covers the canonical
+// JSON of the rest of it: object keys sorted, no insignificant whitespace,
+// strings and numbers exactly as written. verify-result checks one.
+
+// resultSignatureField is the member a signed document carries its
+// signature in.
+const resultSignatureField = "result_signature"
+
+// ResultSignature is the signature embedded in a signed result. PublicKey
+// is in authorized_keys form and Fingerprint as ssh-keygen -l prints it.
+type ResultSignature struct {
+	Algorithm   string `json:"algorithm"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	Signature   string `json:"signature"`
+}
+
+// ResultVerification is the output of verify-result.
+type ResultVerification struct {
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	// KeyMatched is set when the document was signed by the --pubkey
+	// given; without one, only the document's integrity is checked.
+	KeyMatched bool `json:"key_matched"`
+	Verified   bool `json:"verified"`
+}
+
+// signingKey is the run's --sign-key, loaded as the flags are read.
+var signingKey ed25519.PrivateKey
+
+// loadSigningKey reads an unencrypted ed25519 private key, in OpenSSH
+// (ssh-keygen -t ed25519) or PKCS#8 PEM (openssl genpkey -algorithm
+// ed25519) form.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	key, err := ssh.ParseRawPrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, fmt.Errorf("signing key %s is encrypted; give an unencrypted key", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %v", path, err)
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ed25519.PrivateKey:
+		return *k, nil
+	}
+	return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+}
+
+// canonicalResult parses a JSON object and returns it with its canonical
+// encoding, leaving out any embedded signature.
+func canonicalResult(data []byte) (map[string]interface{}, []byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		return nil, nil, fmt.Errorf("a signed result must be a JSON object")
+	}
+	if dec.More() {
+		return nil, nil, fmt.Errorf("a signed result must be a single JSON document")
+	}
+	signature := doc[resultSignatureField]
+	delete(doc, resultSignatureField)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, nil, err
+	}
+	if signature != nil {
+		doc[resultSignatureField] = signature
+	}
+	return doc, bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// signResult returns v with the run's signature embedded.
+func signResult(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc, canonical, err := canonicalResult(data)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ssh.NewPublicKey(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	doc[resultSignatureField] = ResultSignature{
+		Algorithm:   "ed25519",
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		Signature:   base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, canonical)),
+	}
+	return doc, nil
+}
+
+// verifyResult checks the signature embedded in a result document and, if
+// trusted is given (an authorized_keys line or file, or a SHA256:
+// fingerprint), that it was made with that key.
+func verifyResult(arg, trusted string) (*ResultVerification, error) {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result: %v", err)
+	}
+	doc, canonical, err := canonicalResult(streamedResult(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := doc[resultSignatureField]
+	if !ok {
+		return nil, errorWithCode(errInvalidSignature, "the result is not signed")
+	}
+	encoded, _ := json.Marshal(raw)
+	var sig ResultSignature
+	if err := json.Unmarshal(encoded, &sig); err != nil || sig.Algorithm != "ed25519" {
+		return nil, errorWithCode(errInvalidSignature, "malformed result signature")
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(sig.PublicKey))
+	if err != nil || pub.Type() != ssh.KeyAlgoED25519 {
+		return nil, errorWithCode(errInvalidSignature, "malformed result signature public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return nil, errorWithCode(errInvalidSignature, "malformed result signature")
+	}
+	key := pub.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	if !ed25519.Verify(key, canonical, signature) {
+		return nil, errorWithCode(errInvalidSignature, "the signature does not match the result")
+	}
+
+	report := &ResultVerification{Fingerprint: ssh.FingerprintSHA256(pub), PublicKey: sig.PublicKey}
+	if sig.Fingerprint != report.Fingerprint {
+		return nil, errorWithCode(errInvalidSignature, "the result's fingerprint %s is not that of its key", sig.Fingerprint)
+	}
+	if trusted != "" {
+		want, err := trustedFingerprint(trusted)
+		if err != nil {
+			return nil, err
+		}
+		if want != report.Fingerprint {
+			return nil, errorWithCode(errInvalidSignature, "the result was signed by %s, not %s", report.Fingerprint, want)
+		}
+		report.KeyMatched = true
+	}
+	report.Verified = true
+	return report, nil
+}
+
+// streamedResult returns the result of --format ndjson output, its last
+// line, and any other document as it is.
+func streamedResult(data []byte) []byte {
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	var line streamLine
+	var result json.RawMessage
+	line.Data = &result
+	if json.Unmarshal(lines[len(lines)-1], &line) != nil || line.Type != "result" {
+		return data
+	}
+	return result
+}
+
+// trustedFingerprint returns the fingerprint of verify-result's --pubkey.
+func trustedFingerprint(arg string) (string, error) {
+	if strings.HasPrefix(arg, "SHA256:") {
+		return arg, nil
+	}
+	line := []byte(arg)
+	if data, err := os.ReadFile(arg); err == nil {
+		line = data
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read public key: %v", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+	return ssh.FingerprintSHA256(pub), nil
+}
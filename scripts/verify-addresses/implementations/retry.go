@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how failed calls to a backend are retried. Each
+// backend's config section has its own; unset fields take the defaults
+// below. Delays are Go durations ("500ms", "2m").
+type RetryPolicy struct {
+	// Attempts is how many times one call is tried (1 disables retries).
+	Attempts int `json:"attempts,omitempty"`
+	// InitialDelay is the backoff before the first retry, doubling up to
+	// MaxDelay. Each wait is jittered between half and all of it.
+	InitialDelay string `json:"initial_delay,omitempty"`
+	MaxDelay     string `json:"max_delay,omitempty"`
+	// Budget is how many retries the backend gets for the whole process,
+	// so a server that keeps failing cannot stretch a run indefinitely.
+	Budget int `json:"budget,omitempty"`
+	// After BreakerThreshold calls in a row fail, the circuit opens: calls
+	// fail at once for BreakerCooldown, then a single attempt is let
+	// through to probe the server.
+	BreakerThreshold int    `json:"breaker_threshold,omitempty"`
+	BreakerCooldown  string `json:"breaker_cooldown,omitempty"`
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:         5,
+	InitialDelay:     "1s",
+	MaxDelay:         "1m",
+	Budget:           100,
+	BreakerThreshold: 3,
+	BreakerCooldown:  "5m",
+}
+
+// transientError marks a failure worth retrying: the server could not be
+// reached, dropped the connection or was temporarily overloaded. Errors
+// the server returns for the request itself are not retried.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+
+func (e *transientError) Unwrap() error { return e.err }
+
+func transient(err error) error {
+	return &transientError{err: err}
+}
+
+func isTransient(err error) bool {
+	var t *transientError
+	return errors.As(err, &t)
+}
+
+// retryState is the retry budget and circuit of one backend. It outlives
+// the backend, so the requests of a run or watch share them.
+type retryState struct {
+	attempts  int
+	initial   time.Duration
+	max       time.Duration
+	budget    int
+	threshold int
+	cooldown  time.Duration
+	// failures counts calls in a row that failed after all attempts.
+	failures  int
+	openUntil time.Time
+}
+
+// retryStates holds the state of each backend kind opened so far.
+var retryStates = map[string]*retryState{}
+
+func newRetryState(policy RetryPolicy) (*retryState, error) {
+	s := &retryState{
+		attempts:  policy.Attempts,
+		budget:    policy.Budget,
+		threshold: policy.BreakerThreshold,
+	}
+	if s.attempts == 0 {
+		s.attempts = defaultRetryPolicy.Attempts
+	}
+	if s.budget == 0 {
+		s.budget = defaultRetryPolicy.Budget
+	}
+	if s.threshold == 0 {
+		s.threshold = defaultRetryPolicy.BreakerThreshold
+	}
+	if s.attempts < 1 || s.budget < 0 || s.threshold < 1 {
+		return nil, fmt.Errorf("attempts and breaker_threshold must be positive, budget not negative")
+	}
+	for _, d := range []struct {
+		field *time.Duration
+		name  string
+		value string
+		def   string
+	}{
+		{&s.initial, "initial_delay", policy.InitialDelay, defaultRetryPolicy.InitialDelay},
+		{&s.max, "max_delay", policy.MaxDelay, defaultRetryPolicy.MaxDelay},
+		{&s.cooldown, "breaker_cooldown", policy.BreakerCooldown, defaultRetryPolicy.BreakerCooldown},
+	} {
+		value := d.value
+		if value == "" {
+			value = d.def
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %s: %q", d.name, value)
+		}
+		*d.field = parsed
+	}
+	return s, nil
+}
+
+// retryingBackend retries the transient failures of another backend with
+// jittered exponential backoff. A failed connection is closed and the
+// backend reopened before the next attempt.
+type retryingBackend struct {
+	name  string
+	open  func() (ChainBackend, error)
+	inner ChainBackend
+	state *retryState
+}
+
+// retryingBatchBackend is a retryingBackend over a batchBackend, keeping
+// its batch lookups.
+type retryingBatchBackend struct {
+	*retryingBackend
+}
+
+// openRetrying opens a backend through open, retrying the connection
+// itself under the same policy as later calls.
+func openRetrying(name string, policy RetryPolicy, open func() (ChainBackend, error)) (ChainBackend, error) {
+	s, ok := retryStates[name]
+	if !ok {
+		var err error
+		if s, err = newRetryState(policy); err != nil {
+			return nil, fmt.Errorf("invalid %s retry policy: %v", name, err)
+		}
+		retryStates[name] = s
+	}
+	r := &retryingBackend{name: name, open: open, state: s}
+	if err := r.do("connection", func(ChainBackend) error { return nil }); err != nil {
+		return nil, err
+	}
+	if _, ok := r.inner.(batchBackend); ok {
+		return retryingBatchBackend{r}, nil
+	}
+	return r, nil
+}
+
+// do runs fn against the backend until it succeeds, fails for good, or
+// the attempts, budget or circuit run out.
+func (r *retryingBackend) do(what string, fn func(ChainBackend) error) error {
+	s := r.state
+	attempts := s.attempts
+	if s.failures >= s.threshold {
+		if wait := time.Until(s.openUntil); wait > 0 {
+			return errorWithCode(errBackendUnavailable, "%s backend circuit is open after repeated failures; not trying again for %s", r.name, wait.Round(time.Second))
+		}
+		// Half-open: one probe decides whether the circuit closes.
+		attempts = 1
+	}
+
+	delay := s.initial
+	for attempt := 1; ; attempt++ {
+		err := r.attempt(fn)
+		if err == nil {
+			s.failures = 0
+			return nil
+		}
+		if !isTransient(err) {
+			return err
+		}
+		if attempt == attempts || s.budget == 0 {
+			s.failures++
+			if s.failures >= s.threshold {
+				s.openUntil = time.Now().Add(s.cooldown)
+			}
+			if s.budget == 0 {
+				return errorWithCode(errBackendUnavailable, "%v (%s retry budget spent)", err, r.name)
+			}
+			if attempt > 1 {
+				return fmt.Errorf("%v (after %d attempts)", err, attempt)
+			}
+			return err
+		}
+		s.budget--
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		diagnostic("%s %s failed: %v; retrying in %s (attempt %d of %d)", r.name, what, err, wait.Round(time.Millisecond), attempt+1, attempts)
+		time.Sleep(wait)
+		delay = min(delay*2, s.max)
+	}
+}
+
+// attempt runs fn once, (re)opening the backend first if needed.
+func (r *retryingBackend) attempt(fn func(ChainBackend) error) error {
+	if r.inner == nil {
+		inner, err := r.open()
+		if err != nil {
+			return err
+		}
+		r.inner = inner
+	}
+	err := fn(r.inner)
+	if isTransient(err) {
+		r.inner.Close()
+		r.inner = nil
+	}
+	return err
+}
+
+func (r *retryingBackend) Name() string { return r.name }
+
+func (r *retryingBackend) Close() error {
+	if r.inner == nil {
+		return nil
+	}
+	err := r.inner.Close()
+	r.inner = nil
+	return err
+}
+
+func (r *retryingBackend) TipHeight() (height int64, err error) {
+	err = r.do("tip height lookup", func(b ChainBackend) (err error) {
+		height, err = b.TipHeight()
+		return err
+	})
+	return height, err
+}
+
+func (r *retryingBackend) AddressHistory(address string) (refs []TxRef, err error) {
+	err = r.do("history lookup", func(b ChainBackend) (err error) {
+		refs, err = b.AddressHistory(address)
+		return err
+	})
+	return refs, err
+}
+
+func (r *retryingBackend) AddressUTXOs(address string) (utxos []UTXO, err error) {
+	err = r.do("UTXO lookup", func(b ChainBackend) (err error) {
+		utxos, err = b.AddressUTXOs(address)
+		return err
+	})
+	return utxos, err
+}
+
+func (r *retryingBackend) RawTransaction(txid string) (raw []byte, err error) {
+	err = r.do("transaction lookup", func(b ChainBackend) (err error) {
+		raw, err = rawTransaction(b, txid)
+		return err
+	})
+	return raw, err
+}
+
+func (r *retryingBackend) BlockHash(height int64) (hash string, err error) {
+	err = r.do("block hash lookup", func(b ChainBackend) (err error) {
+		hash, err = backendBlockHash(b, height)
+		return err
+	})
+	return hash, err
+}
+
+func (r *retryingBackend) RawBlock(hash string) (raw []byte, err error) {
+	err = r.do("block lookup", func(b ChainBackend) (err error) {
+		raw, err = rawBlock(b, hash)
+		return err
+	})
+	return raw, err
+}
+
+func (r *retryingBackend) FeeRate(target int, mode string) (rate int64, err error) {
+	err = r.do("fee estimate", func(b ChainBackend) (err error) {
+		rate, err = backendFeeRate(b, target, mode)
+		return err
+	})
+	return rate, err
+}
+
+func (r retryingBatchBackend) AddressHistories(addresses []string) (histories [][]TxRef, err error) {
+	err = r.do("batch history lookup", func(b ChainBackend) (err error) {
+		histories, err = b.(batchBackend).AddressHistories(addresses)
+		return err
+	})
+	return histories, err
+}
+
+func (r retryingBatchBackend) AddressUTXOSets(addresses []string) (sets [][]UTXO, err error) {
+	err = r.do("batch UTXO lookup", func(b ChainBackend) (err error) {
+		sets, err = b.(batchBackend).AddressUTXOSets(addresses)
+		return err
+	})
+	return sets, err
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultGapLimit is the BIP-44 address gap limit: a chain is considered
+// exhausted after this many consecutive unused addresses.
+const defaultGapLimit = 20
+
+// scannedAddress is one address examined by a gap scan.
+type scannedAddress struct {
+	Index   uint32
+	Address string
+	Used    bool
+}
+
+// chainScan is the result of gap-scanning one chain of a wallet.
+type chainScan struct {
+	Change    bool
+	Addresses []scannedAddress
+	// LastUsed is the highest used index, or -1 if nothing was used.
+	LastUsed  int64
+	TipHeight int64
+}
+
+// firstUnused returns the lowest index on the chain with no history.
+func (c *chainScan) firstUnused() scannedAddress {
+	for _, a := range c.Addresses {
+		if !a.Used {
+			return a
+		}
+	}
+	// Unreachable with a positive gap limit: a scan always ends on unused
+	// addresses.
+	return scannedAddress{}
+}
+
+// scanChain derives addresses on one chain and looks each up through the
+// backend until gap consecutive addresses have no history. With a store,
+// indices already known to be used are not looked up again (usage never
+// goes away), and newly used ones and the scan position are saved. The
+// position is saved after every batch, so a scan that was interrupted
+// resumes past the indices it had already found unused.
+func scanChain(spec *WalletSpec, backend ChainBackend, store *walletStore, change bool, gap int) (*chainScan, error) {
+	if gap < 1 {
+		return nil, fmt.Errorf("invalid gap limit: %d", gap)
+	}
+	tip, err := backend.TipHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	var resumeAt uint32
+	known := map[uint32]bool{}
+	if store != nil {
+		if id, err = store.registerWallet(spec); err != nil {
+			return nil, err
+		}
+		if known, err = store.usedIndices(id, change); err != nil {
+			return nil, err
+		}
+		p, err := resumableProgress(store, id, progressGapScan, change, backend)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			resumeAt = p.NextIndex
+			diagnostic("resuming the gap scan of %s at index %d (checked up to height %d)", chainName(change), resumeAt, p.TipHeight)
+		}
+	}
+
+	scan := &chainScan{Change: change, LastUsed: -1, TipHeight: tip}
+	for unused := 0; unused < gap; {
+		// Look up only as far as the scan could still end, so a batch
+		// never reaches past where a one-by-one scan would stop.
+		start := uint32(len(scan.Addresses))
+		batch := make([]scannedAddress, gap-unused)
+		var lookup []string
+		var lookupAt []int
+		for i := range batch {
+			index := start + uint32(i)
+			address, err := spec.deriveAddress(change, index)
+			if err != nil {
+				return nil, err
+			}
+			batch[i] = scannedAddress{Index: index, Address: address, Used: known[index]}
+			if !known[index] && index >= resumeAt {
+				lookup = append(lookup, address)
+				lookupAt = append(lookupAt, i)
+			}
+		}
+		histories, err := lookupHistories(backend, lookup)
+		if err != nil {
+			return nil, err
+		}
+		for j, history := range histories {
+			a := &batch[lookupAt[j]]
+			a.Used = len(history) > 0
+			if a.Used && store != nil {
+				if err := store.markUsed(id, change, a.Index, a.Address); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for _, a := range batch {
+			scan.Addresses = append(scan.Addresses, a)
+			if a.Used {
+				scan.LastUsed = int64(a.Index)
+				unused = 0
+			} else {
+				unused++
+			}
+		}
+		if store != nil && unused < gap {
+			next := uint32(len(scan.Addresses))
+			if err := store.saveProgress(id, progressGapScan, change, scanProgress{Backend: backend.Name(), TipHeight: tip, NextIndex: next}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if store != nil {
+		derived := make([]DerivedAddress, len(scan.Addresses))
+		for i, a := range scan.Addresses {
+			derived[i] = DerivedAddress{Index: a.Index, Address: a.Address}
+		}
+		if err := store.recordAddresses(id, change, derived); err != nil {
+			return nil, err
+		}
+		next := uint32(len(scan.Addresses))
+		if err := store.saveCheckpoint(id, change, scanCheckpoint{NextIndex: next, TipHeight: tip}); err != nil {
+			return nil, err
+		}
+		if err := store.clearProgress(id, progressGapScan, change); err != nil {
+			return nil, err
+		}
+	}
+	return scan, nil
+}
+
+// resumableProgress returns the saved progress of an unfinished scan if it
+// may be resumed. Lookups made through another backend are not trusted, so
+// progress saved with a different backend is ignored and later overwritten.
+func resumableProgress(store *walletStore, id, kind string, change bool, backend ChainBackend) (*scanProgress, error) {
+	p, err := store.progress(id, kind, change)
+	if err != nil || p == nil {
+		return nil, err
+	}
+	if p.Backend != backend.Name() {
+		diagnostic("ignoring unfinished %s scan of %s made with the %s backend", kind, chainName(change), p.Backend)
+		return nil, nil
+	}
+	return p, nil
+}
+
+// chainName names a wallet chain in messages.
+func chainName(change bool) string {
+	if change {
+		return "the change chain"
+	}
+	return "the receive chain"
+}
+
+// NextAddress is the first never-used receive address of a wallet.
+type NextAddress struct {
+	Address string `json:"address"`
+	Index   uint32 `json:"index"`
+	// Path is the full derivation path for single-sig wallets; multisig
+	// wallets list one path per cosigner in Paths.
+	Path  string   `json:"path,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+	// LastUsedIndex is the highest used receive index found by the scan.
+	LastUsedIndex *uint32 `json:"last_used_index,omitempty"`
+	Backend       string  `json:"backend"`
+	TipHeight     int64   `json:"tip_height"`
+}
+
+func nextUnusedAddress(spec *WalletSpec, backend ChainBackend, store *walletStore, gap int) (*NextAddress, error) {
+	scan, err := scanChain(spec, backend, store, false, gap)
+	if err != nil {
+		return nil, err
+	}
+	next := scan.firstUnused()
+	result := &NextAddress{
+		Address:   next.Address,
+		Index:     next.Index,
+		Backend:   backend.Name(),
+		TipHeight: scan.TipHeight,
+	}
+	if scan.LastUsed >= 0 {
+		last := uint32(scan.LastUsed)
+		result.LastUsedIndex = &last
+	}
+
+	paths := make([]string, len(spec.Keys))
+	for i, k := range spec.Keys {
+		paths[i] = childPath(k.Path, false, next.Index)
+	}
+	if spec.isMultisig() {
+		result.Paths = paths
+	} else {
+		result.Path = paths[0]
+	}
+	return result, nil
+}
+
+// childPath appends the chain and index to an account path. Keys without
+// a known origin get a path relative to the account key.
+func childPath(accountPath string, change bool, index uint32) string {
+	chain := 0
+	if change {
+		chain = 1
+	}
+	if accountPath == "" {
+		accountPath = "m"
+	}
+	return fmt.Sprintf("%s/%d/%d", strings.TrimSuffix(accountPath, "/"), chain, index)
+}
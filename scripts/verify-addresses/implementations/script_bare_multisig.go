@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// maxBareMultisigKeys is the most keys a bare multisig output may have and
+// still be standard, and the most Bitcoin Core accepts in a top-level
+// multi() or sortedmulti() descriptor.
+const maxBareMultisigKeys = 3
+
+// Bare sortedmulti, the raw OP_CHECKMULTISIG scriptPubKey with no P2SH
+// wrapping. Like P2PK it has no address, so the scriptPubKey is reported in
+// hex.
+func init() {
+	registerScriptType(&scriptType{
+		name:       "bare_multisig",
+		multisig:   true,
+		descriptor: "sortedmulti(",
+		address: func(keys []*btcec.PublicKey, threshold int, _ *chaincfg.Params) (string, error) {
+			if len(keys) > maxBareMultisigKeys {
+				return "", fmt.Errorf("bare multisig is limited to %d keys, got %d", maxBareMultisigKeys, len(keys))
+			}
+			script, err := sortedMultisigScript(keys, threshold)
+			if err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(script), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{
+				"tpubDFH9dgzveyD8zTbPUFuLrGmCydNvxehyNdUXKJAQN8x4aZ4j6UZqGfnqFrD4NqyaTVGKbvEW54tsvPTK2UoSbCC1PJY8iCNiwTL3RWZEheQ",
+				"tpubDFPtPArj4GzBEFHohegg1Xatrc1Fi9oSox5LzuSRX91miwQxuUrEpBxpvDRsmZYJKYFhgdK3UStsjC8JKXfUbMinjFqiEM4uNwzVaCaHpys",
+				"tpubDEfobrrtptRTbKf4gysDhoabneABDTAcdj3Vbn4XwPsLE2pmqpizSPRG6zHsbAMuiSgWmWPsYCLHTKTPpyrGJ5rAoTpKoQNZcxodiPf2tSJ",
+			}, threshold: 2, network: "testnet", index: 0, address: "5221030b90ed2e86bad7f2a4fe9769bb417d7ba9caa1124807dbfb362dfbeeb65e7e0121037653e25afc48ec05d9083dd78e52bedc1679f053b75eb82d0dd5be95a87a15832103aa93b6a70ed21658af2fafcc7344ccc5bff0703cd872bd9d9f06825e02f2145c53ae"},
+		},
+	})
+}
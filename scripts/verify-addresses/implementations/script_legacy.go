@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// P2PKH (BIP-44)
+func init() {
+	registerScriptType(&scriptType{
+		name:       "legacy",
+		descriptor: "pkh(",
+		address: func(keys []*btcec.PublicKey, _ int, net *chaincfg.Params) (string, error) {
+			return pubKeyHashAddress(keys[0].SerializeCompressed(), net)
+		},
+		uncompressedAddress: func(key *btcec.PublicKey, net *chaincfg.Params) (string, error) {
+			return pubKeyHashAddress(key.SerializeUncompressed(), net)
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{"tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba"},
+				network: "testnet", index: 0, address: "mkpZhYtJu2r87Js3pDiWJDmPte2NRZ8bJV"},
+		},
+	})
+}
+
+func pubKeyHashAddress(pubKey []byte, net *chaincfg.Params) (string, error) {
+	addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey), net)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// P2WPKH (BIP-84)
+func init() {
+	registerScriptType(&scriptType{
+		name:       "native_segwit",
+		descriptor: "wpkh(",
+		address: func(keys []*btcec.PublicKey, _ int, net *chaincfg.Params) (string, error) {
+			pubKeyHash := btcutil.Hash160(keys[0].SerializeCompressed())
+			addr, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, net)
+			if err != nil {
+				return "", err
+			}
+			return addr.EncodeAddress(), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{"tpubDC8msFGeGuwnKG9Upg7DM2b4DaRqg3CUZa5g8v2SRQ6K4NSkxUgd7HsL2XVWbVm39yBA4LAxysQAm397zwQSQoQgewGiYZqrA9DsP4zbQ1M"},
+				network: "testnet", index: 0, address: "tb1q6rz28mcfaxtmd6v789l9rrlrusdprr9pqcpvkl"},
+		},
+	})
+}
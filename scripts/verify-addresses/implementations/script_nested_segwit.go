@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// P2SH-P2WPKH (BIP-49)
+func init() {
+	registerScriptType(&scriptType{
+		name:       "nested_segwit",
+		descriptor: "sh(wpkh(",
+		address: func(keys []*btcec.PublicKey, _ int, net *chaincfg.Params) (string, error) {
+			pubKeyHash := btcutil.Hash160(keys[0].SerializeCompressed())
+			witAddr, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, net)
+			if err != nil {
+				return "", err
+			}
+			// Wrap in P2SH
+			script, err := txscript.PayToAddrScript(witAddr)
+			if err != nil {
+				return "", err
+			}
+			addr, err := btcutil.NewAddressScriptHashFromHash(btcutil.Hash160(script), net)
+			if err != nil {
+				return "", err
+			}
+			return addr.EncodeAddress(), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{"tpubDD7tXK8KeQ3YY83yWq755fHY2JW8Ha8Q765tknUM5rSvjPcGWfUppDFMpQ1ScziKfW3ZNtZvAD7M3u7bSs7HofjTD3KP3YxPK7X6hwV8Rk2"},
+				network: "testnet", index: 0, address: "2Mww8dCYPUpKHofjgcXcBCEGmniw9CoaiD2"},
+		},
+	})
+}
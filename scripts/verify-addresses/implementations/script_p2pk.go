@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// P2PK, for auditing coins paid straight to a public key before P2PKH. The
+// output has no address form, so the "address" is the scriptPubKey in hex.
+// Early coins used uncompressed keys, which BIP-380 pk() cannot express, so
+// that variant has no descriptor form. Its keys are uncompressed already,
+// so asking for uncompressed keys changes nothing.
+func init() {
+	registerScriptType(&scriptType{
+		name:       "p2pk",
+		descriptor: "pk(",
+		address: func(keys []*btcec.PublicKey, _ int, _ *chaincfg.Params) (string, error) {
+			return payToPubKeyScript(keys[0].SerializeCompressed())
+		},
+		uncompressedAddress: func(key *btcec.PublicKey, _ *chaincfg.Params) (string, error) {
+			return payToPubKeyScript(key.SerializeUncompressed())
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{"tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba"},
+				network: "testnet", index: 0, address: "2102a7451395735369f2ecdfc829c0f774e88ef1303dfe5b2f04dbaab30a535dfdd6ac"},
+		},
+	})
+	registerScriptType(&scriptType{
+		name: "p2pk_uncompressed",
+		address: func(keys []*btcec.PublicKey, _ int, _ *chaincfg.Params) (string, error) {
+			return payToPubKeyScript(keys[0].SerializeUncompressed())
+		},
+		uncompressedAddress: func(key *btcec.PublicKey, _ *chaincfg.Params) (string, error) {
+			return payToPubKeyScript(key.SerializeUncompressed())
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{"tpubDC5FSnBiZDMmhiuCmWAYsLwgLYrrT9rAqvTySfuCCrgsWz8wxMXUS9Tb9iVMvcRbvFcAHGkMD5Kx8koh4GquNGNTfohfk7pgjhaPCdXpoba"},
+				network: "testnet", index: 0, address: "4104a7451395735369f2ecdfc829c0f774e88ef1303dfe5b2f04dbaab30a535dfdd6f7d69d431af1831a9f2396d889f61328c251bd13dd6bcba101db819d17c8f2f0ac"},
+		},
+	})
+}
+
+// payToPubKeyScript builds <pubkey> OP_CHECKSIG, hex-encoded.
+func payToPubKeyScript(pubKey []byte) (string, error) {
+	script, err := buildScript(func(b *txscript.ScriptBuilder) {
+		b.AddData(pubKey).AddOp(txscript.OP_CHECKSIG)
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(script), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// Legacy P2SH sortedmulti
+func init() {
+	registerScriptType(&scriptType{
+		name:       "p2sh",
+		multisig:   true,
+		descriptor: "sh(sortedmulti(",
+		address: func(keys []*btcec.PublicKey, threshold int, net *chaincfg.Params) (string, error) {
+			redeemScript, err := sortedMultisigScript(keys, threshold)
+			if err != nil {
+				return "", err
+			}
+			addr, err := btcutil.NewAddressScriptHashFromHash(btcutil.Hash160(redeemScript), net)
+			if err != nil {
+				return "", err
+			}
+			return addr.EncodeAddress(), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{
+				"tpubDFH9dgzveyD8zTbPUFuLrGmCydNvxehyNdUXKJAQN8x4aZ4j6UZqGfnqFrD4NqyaTVGKbvEW54tsvPTK2UoSbCC1PJY8iCNiwTL3RWZEheQ",
+				"tpubDFPtPArj4GzBEFHohegg1Xatrc1Fi9oSox5LzuSRX91miwQxuUrEpBxpvDRsmZYJKYFhgdK3UStsjC8JKXfUbMinjFqiEM4uNwzVaCaHpys",
+				"tpubDEfobrrtptRTbKf4gysDhoabneABDTAcdj3Vbn4XwPsLE2pmqpizSPRG6zHsbAMuiSgWmWPsYCLHTKTPpyrGJ5rAoTpKoQNZcxodiPf2tSJ",
+			}, threshold: 2, network: "testnet", index: 0, address: "2MxKrq8dcWJ3uLATzY9fFgZkVyxt38ApUpS"},
+		},
+	})
+}
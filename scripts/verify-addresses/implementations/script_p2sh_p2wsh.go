@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Nested segwit P2SH-P2WSH sortedmulti
+func init() {
+	registerScriptType(&scriptType{
+		name:       "p2sh_p2wsh",
+		multisig:   true,
+		descriptor: "sh(wsh(sortedmulti(",
+		address: func(keys []*btcec.PublicKey, threshold int, net *chaincfg.Params) (string, error) {
+			redeemScript, err := sortedMultisigScript(keys, threshold)
+			if err != nil {
+				return "", err
+			}
+			witnessHash := sha256.Sum256(redeemScript)
+
+			// Create witness program: OP_0 <32-byte-hash>
+			witnessProgram := make([]byte, 34)
+			witnessProgram[0] = 0x00
+			witnessProgram[1] = 0x20
+			copy(witnessProgram[2:], witnessHash[:])
+
+			// Hash160 of witness program
+			h := sha256.Sum256(witnessProgram)
+			ripemd := ripemd160.New()
+			ripemd.Write(h[:])
+
+			addr, err := btcutil.NewAddressScriptHashFromHash(ripemd.Sum(nil), net)
+			if err != nil {
+				return "", err
+			}
+			return addr.EncodeAddress(), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{
+				"tpubDFH9dgzveyD8zTbPUFuLrGmCydNvxehyNdUXKJAQN8x4aZ4j6UZqGfnqFrD4NqyaTVGKbvEW54tsvPTK2UoSbCC1PJY8iCNiwTL3RWZEheQ",
+				"tpubDFPtPArj4GzBEFHohegg1Xatrc1Fi9oSox5LzuSRX91miwQxuUrEpBxpvDRsmZYJKYFhgdK3UStsjC8JKXfUbMinjFqiEM4uNwzVaCaHpys",
+				"tpubDEfobrrtptRTbKf4gysDhoabneABDTAcdj3Vbn4XwPsLE2pmqpizSPRG6zHsbAMuiSgWmWPsYCLHTKTPpyrGJ5rAoTpKoQNZcxodiPf2tSJ",
+			}, threshold: 2, network: "testnet", index: 0, address: "2N1J3ys6Z1bc7n4GTsNJ3EAR9v9Qd3LmtJq"},
+		},
+	})
+}
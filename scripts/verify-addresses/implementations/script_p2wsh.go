@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/sha256"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// Native segwit P2WSH sortedmulti
+func init() {
+	registerScriptType(&scriptType{
+		name:       "p2wsh",
+		multisig:   true,
+		descriptor: "wsh(sortedmulti(",
+		address: func(keys []*btcec.PublicKey, threshold int, net *chaincfg.Params) (string, error) {
+			redeemScript, err := sortedMultisigScript(keys, threshold)
+			if err != nil {
+				return "", err
+			}
+			witnessHash := sha256.Sum256(redeemScript)
+			addr, err := btcutil.NewAddressWitnessScriptHash(witnessHash[:], net)
+			if err != nil {
+				return "", err
+			}
+			return addr.EncodeAddress(), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{
+				"tpubDFH9dgzveyD8zTbPUFuLrGmCydNvxehyNdUXKJAQN8x4aZ4j6UZqGfnqFrD4NqyaTVGKbvEW54tsvPTK2UoSbCC1PJY8iCNiwTL3RWZEheQ",
+				"tpubDFPtPArj4GzBEFHohegg1Xatrc1Fi9oSox5LzuSRX91miwQxuUrEpBxpvDRsmZYJKYFhgdK3UStsjC8JKXfUbMinjFqiEM4uNwzVaCaHpys",
+				"tpubDEfobrrtptRTbKf4gysDhoabneABDTAcdj3Vbn4XwPsLE2pmqpizSPRG6zHsbAMuiSgWmWPsYCLHTKTPpyrGJ5rAoTpKoQNZcxodiPf2tSJ",
+			}, threshold: 2, network: "testnet", index: 0, address: "tb1qmv9kucx4tjtyfwddc3698p2flxqvts89n8kllr0hvdv7qs4z476s70nuf5"},
+		},
+	})
+}
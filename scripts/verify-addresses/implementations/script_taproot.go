@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// P2TR key-path only output (BIP-86)
+func init() {
+	registerScriptType(&scriptType{
+		name:       "taproot",
+		descriptor: "tr(",
+		address: func(keys []*btcec.PublicKey, _ int, net *chaincfg.Params) (string, error) {
+			// Tweak the internal key with an empty script tree (BIP-341)
+			// and use the x-only output key
+			outputKey := txscript.ComputeTaprootKeyNoScript(keys[0])
+			addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), net)
+			if err != nil {
+				return "", err
+			}
+			return addr.EncodeAddress(), nil
+		},
+		vectors: []scriptTypeVector{
+			{xpubs: []string{"tpubDDfvzhdVV4unsoKt5aE6dcsNsfeWbTgmLZPi8LQDYU2xixrYemMfWJ3BaVneH3u7DBQePdTwhpybaKRU95pi6PMUtLPBJLVQRpzEnjfjZzX"},
+				network: "testnet", index: 0, address: "tb1p8wpt9v4frpf3tkn0srd97pksgsxc5hs52lafxwru9kgeephvs7rqlqt9zj"},
+		},
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// scriptType is one output script template. Each template lives in its own
+// script_*.go file and registers itself from init, so adding a type (a new
+// witness version, a covenant template) never touches the derivation paths.
+type scriptType struct {
+	name     string
+	multisig bool
+
+	// descriptor is the BIP-380 wrapper the keys go into, e.g.
+	// "wsh(sortedmulti(". Closing parentheses are implied.
+	descriptor string
+
+	// address encodes the output for already-derived child keys. Single-sig
+	// types always receive exactly one key and ignore threshold.
+	address func(keys []*btcec.PublicKey, threshold int, net *chaincfg.Params) (string, error)
+
+	// uncompressedAddress encodes the output for a key serialized
+	// uncompressed. Only pre-segwit single-sig templates set it: segwit
+	// outputs to uncompressed keys are non-standard or unspendable.
+	uncompressedAddress func(key *btcec.PublicKey, net *chaincfg.Params) (string, error)
+
+	// vectors are the template's own known-answer tests, run by go test
+	// (scripttype_test.go) and check --deep. Every template needs at least one.
+	vectors []scriptTypeVector
+}
+
+// scriptTypeVector is a known address for a template, taken from vectors
+// that Bitcoin Core and at least one other implementation agreed on.
+type scriptTypeVector struct {
+	xpubs     []string
+	threshold int
+	network   string
+	change    bool
+	index     uint32
+	address   string
+}
+
+var scriptTypes = map[string]*scriptType{}
+
+func registerScriptType(t *scriptType) {
+	if _, dup := scriptTypes[t.name]; dup {
+		panic("duplicate script type: " + t.name)
+	}
+	scriptTypes[t.name] = t
+}
+
+func lookupScriptType(name string) (*scriptType, error) {
+	t, ok := scriptTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown script type: %s", name)
+	}
+	return t, nil
+}
+
+// scriptTypeNames lists the registered single-sig or multisig types by name.
+func scriptTypeNames(multisig bool) []string {
+	var names []string
+	for name, t := range scriptTypes {
+		if t.multisig == multisig {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// descriptorScriptTypes returns every template ordered so that longer
+// descriptor wrappers are matched before the wrappers they start with.
+func descriptorScriptTypes() []*scriptType {
+	var types []*scriptType
+	for _, t := range scriptTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if len(types[i].descriptor) != len(types[j].descriptor) {
+			return len(types[i].descriptor) > len(types[j].descriptor)
+		}
+		return types[i].descriptor < types[j].descriptor
+	})
+	return types
+}
+
+// descriptorSuffix closes every parenthesis opened by the wrapper.
+func (t *scriptType) descriptorSuffix() string {
+	return strings.Repeat(")", strings.Count(t.descriptor, "("))
+}
+
+// scriptBuilders recycles script builders across derivations: a fresh
+// builder allocates a 500-byte buffer, one per address in a large range.
+var scriptBuilders = sync.Pool{New: func() interface{} { return txscript.NewScriptBuilder() }}
+
+// buildScript runs build on a pooled builder and returns a copy of the
+// script sized to fit.
+func buildScript(build func(b *txscript.ScriptBuilder)) ([]byte, error) {
+	b := scriptBuilders.Get().(*txscript.ScriptBuilder).Reset()
+	defer scriptBuilders.Put(b)
+	build(b)
+	script, err := b.Script()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), script...), nil
+}
+
+// sortedMultisigScript builds the BIP-67 sorted OP_CHECKMULTISIG script.
+func sortedMultisigScript(keys []*btcec.PublicKey, threshold int) ([]byte, error) {
+	serialized := make([][]byte, len(keys))
+	for i, pk := range keys {
+		serialized[i] = pk.SerializeCompressed()
+	}
+	sort.Slice(serialized, func(i, j int) bool { return bytes.Compare(serialized[i], serialized[j]) < 0 })
+
+	script, err := buildScript(func(b *txscript.ScriptBuilder) {
+		b.AddInt64(int64(threshold))
+		for _, pk := range serialized {
+			b.AddData(pk)
+		}
+		b.AddInt64(int64(len(serialized)))
+		b.AddOp(txscript.OP_CHECKMULTISIG)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build redeem script: %v", err)
+	}
+	return script, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestScriptTypeVectors runs every registered template's known-answer
+// vectors through both engines, so a template cannot be registered without
+// vectors or drift from them between check --deep runs.
+func TestScriptTypeVectors(t *testing.T) {
+	if len(scriptTypes) == 0 {
+		t.Fatal("no script types registered")
+	}
+	engines := []struct {
+		name     string
+		single   func(xpub string, index uint32, st *scriptType, change bool, network string) (string, error)
+		multisig func(xpubs []string, threshold int, index uint32, st *scriptType, change bool, network string) (string, error)
+	}{
+		{
+			name: "btcsuite",
+			single: func(xpub string, index uint32, st *scriptType, change bool, network string) (string, error) {
+				return deriveSingleSig(xpub, index, st.name, change, network)
+			},
+			multisig: func(xpubs []string, threshold int, index uint32, st *scriptType, change bool, network string) (string, error) {
+				return deriveMultisig(xpubs, threshold, index, st.name, change, network)
+			},
+		},
+		{name: "internal", single: internalSingleSig, multisig: internalMultisig},
+	}
+
+	for _, name := range append(scriptTypeNames(false), scriptTypeNames(true)...) {
+		st := scriptTypes[name]
+		t.Run(name, func(t *testing.T) {
+			if len(st.vectors) == 0 {
+				t.Fatalf("script type %s has no vectors", name)
+			}
+			for _, e := range engines {
+				for _, v := range st.vectors {
+					t.Run(fmt.Sprintf("%s/%s/%s", e.name, v.network, chainIndex(v.change, v.index)), func(t *testing.T) {
+						var got string
+						var err error
+						if st.multisig {
+							got, err = e.multisig(v.xpubs, v.threshold, v.index, st, v.change, v.network)
+						} else {
+							got, err = e.single(v.xpubs[0], v.index, st, v.change, v.network)
+						}
+						if err != nil {
+							t.Fatalf("derive: %v", err)
+						}
+						if got != v.address {
+							t.Errorf("got %s, want %s", got, v.address)
+						}
+					})
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Keys given on the command line show up in process listings, shell
+// history and the audit log's arguments. derive can instead read its key
+// from an environment variable (--key-env) or from a file descriptor the
+// caller opened for it (--key-fd). A private key read this way is turned
+// into its public key at once; nothing past secretKey sees it. The
+// exceptions are derive-hardened (see hardened.go), which needs private
+// keys to derive through hardened steps, and bip85, mnemonic, seed-xor,
+// slip39 and find-passphrase (see bip85.go, mnemonic.go, seedxor.go,
+// slip39.go and findpassphrase.go), which start from a master secret; they
+// read through secretInput.
+
+// maxSecretSize caps what is read from a secret's descriptor.
+const maxSecretSize = 4096
+
+// privateKeyVersions maps the version bytes of each extended private key
+// serialization, SLIP-132 variants included, to its public counterpart.
+var privateKeyVersions = map[uint32]uint32{
+	0x0488ade4: 0x0488b21e, // xprv, xpub
+	0x04358394: 0x043587cf, // tprv, tpub
+	0x049d7878: 0x049d7cb2, // yprv, ypub
+	0x04b2430c: 0x04b24746, // zprv, zpub
+	0x044a4e28: 0x044a5262, // uprv, upub
+	0x045f18bc: 0x045f1cf6, // vprv, vpub
+	0x0295b005: 0x0295b43f, // Yprv, Ypub
+	0x02aa7a99: 0x02aa7ed3, // Zprv, Zpub
+	0x024285b5: 0x024289ef, // Uprv, Upub
+	0x02575048: 0x02575483, // Vprv, Vpub
+}
+
+// secretKey reads the key named by derive's --key-env or --key-fd flag and
+// returns it as an extended public key.
+func secretKey(env, fd string) (string, error) {
+	if requestID != "" {
+		return "", fmt.Errorf("--key-env and --key-fd cannot be used in a batch")
+	}
+	secret, err := readSecret(env, fd)
+	if err != nil {
+		return "", errorWithCode(errInvalidKey, "%v", err)
+	}
+	defer clear(secret)
+	key := string(bytes.TrimSpace(secret))
+	if key == "" {
+		return "", errorWithCode(errInvalidKey, "no key was given")
+	}
+	if strings.HasPrefix(strings.ToLower(key), "ur:") {
+		return xpubFromInput(key)
+	}
+	return neuterKey(key)
+}
+
+// secretInput reads the secret named by a --key-env or --key-fd flag, for
+// the commands that need the private material itself (derive-hardened's
+// descriptor, bip85's master key, the words of mnemonic and
+// find-passphrase, seed-xor's parts, slip39's shares). The caller clears it
+// once parsed.
+func secretInput(env, fd string) ([]byte, error) {
+	if requestID != "" {
+		return nil, fmt.Errorf("--key-env and --key-fd cannot be used in a batch")
+	}
+	secret, err := readSecret(env, fd)
+	if err != nil {
+		return nil, errorWithCode(errInvalidKey, "%v", err)
+	}
+	if len(bytes.TrimSpace(secret)) == 0 {
+		clear(secret)
+		return nil, errorWithCode(errInvalidKey, "no secret was given")
+	}
+	return secret, nil
+}
+
+// readSecret reads a secret from the environment variable env or, without
+// one, from the file descriptor fd. The variable is unset once read, so
+// programs the verifier starts do not inherit it.
+func readSecret(env, fd string) ([]byte, error) {
+	if env != "" {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		os.Unsetenv(env)
+		return []byte(value), nil
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid file descriptor: %q", fd)
+	}
+	f := os.NewFile(uintptr(n), "secret-fd")
+	if f == nil {
+		return nil, fmt.Errorf("file descriptor %d is not open", n)
+	}
+	defer f.Close()
+	secret, err := io.ReadAll(io.LimitReader(f, maxSecretSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file descriptor %d: %v", n, err)
+	}
+	if len(secret) > maxSecretSize {
+		return nil, fmt.Errorf("file descriptor %d holds more than a secret", n)
+	}
+	return secret, nil
+}
+
+// neuterKey returns the extended public key of an extended private key,
+// keeping its SLIP-132 flavour, and returns public keys unchanged.
+func neuterKey(key string) (string, error) {
+	b, err := base58CheckDecode(key)
+	if err != nil || len(b) != 78 {
+		return "", errorWithCode(errInvalidKey, "invalid extended key")
+	}
+	defer clear(b)
+	public, ok := privateKeyVersions[binary.BigEndian.Uint32(b[:4])]
+	if !ok {
+		return key, nil
+	}
+	if b[45] != 0 {
+		return "", errorWithCode(errInvalidKey, "invalid extended private key")
+	}
+	priv, pub := btcec.PrivKeyFromBytes(b[46:78])
+	defer priv.Zero()
+	out := make([]byte, 78)
+	binary.BigEndian.PutUint32(out, public)
+	copy(out[4:45], b[4:45])
+	copy(out[45:], pub.SerializeCompressed())
+	return base58CheckEncode(out[0], out[1:]), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import "strings"
+
+// Seed XOR, as Coldcard implements it, splits a BIP-39 mnemonic into parts
+// which are themselves valid mnemonics of the same length; XORing the
+// parts' entropy gives the original's, all parts being needed. seed-xor
+// reads the parts, one per line, through --key-env or --key-fd, combines
+// them and reports the wallet the combined mnemonic restores, as mnemonic
+// does. The combined mnemonic itself is never output. It refuses to run
+// unless offline.
+
+// SeedXORReport is the wallet a set of Seed XOR parts restores.
+type SeedXORReport struct {
+	Parts      int             `json:"parts"`
+	Words      int             `json:"words"`
+	Passphrase bool            `json:"passphrase"`
+	Wallet     *RestoredWallet `json:"wallet"`
+}
+
+// combineSeedXOR XORs the entropy of parts, one mnemonic per line, and
+// returns the words of the combined mnemonic.
+func combineSeedXOR(parts string) ([]string, int, error) {
+	var combined []byte
+	defer func() { clear(combined) }()
+	count := 0
+	for _, line := range strings.FieldsFunc(parts, func(r rune) bool { return r == '\n' || r == '\r' || r == ',' }) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		count++
+		words, err := normalizeMnemonic(line)
+		if err != nil {
+			return nil, 0, errorWithCode(errInvalidMnemonic, "part %d: %v", count, err)
+		}
+		entropy, err := mnemonicToEntropy(words)
+		if err != nil {
+			return nil, 0, errorWithCode(errInvalidMnemonic, "part %d: %v", count, err)
+		}
+		if combined == nil {
+			combined = make([]byte, len(entropy))
+		}
+		if len(entropy) != len(combined) {
+			clear(entropy)
+			return nil, 0, errorWithCode(errInvalidMnemonic, "part %d has %d words; every Seed XOR part has as many words as the first", count, len(words))
+		}
+		for i, b := range entropy {
+			combined[i] ^= b
+		}
+		clear(entropy)
+	}
+	if count < 2 {
+		return nil, 0, errorWithCode(errInvalidMnemonic, "Seed XOR needs at least 2 parts, not %d", count)
+	}
+	mnemonic, err := entropyToMnemonic(combined)
+	if err != nil {
+		return nil, 0, err
+	}
+	return strings.Fields(mnemonic), count, nil
+}
+
+// restoreSeedXOR combines parts and derives the wallet req describes from
+// the combined mnemonic and passphrase.
+func restoreSeedXOR(parts, passphrase string, req *mnemonicRequest) (*SeedXORReport, error) {
+	words, count, err := combineSeedXOR(parts)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := restoreBIP39(words, passphrase, req)
+	if err != nil {
+		return nil, err
+	}
+	return &SeedXORReport{Parts: count, Words: len(words), Passphrase: passphrase != "", Wallet: wallet}, nil
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SelfCheckReport is the result of running the built-in official test
+// vectors against this binary.
+type SelfCheckReport struct {
+	Passed  bool          `json:"passed"`
+	Total   int           `json:"total"`
+	Failed  int           `json:"failed"`
+	Vectors []VectorCheck `json:"vectors"`
+}
+
+type VectorCheck struct {
+	Suite    string `json:"suite"`
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Expected string `json:"expected,omitempty"`
+	Got      string `json:"got,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// bip32Vector is one chain of an official BIP-32 test vector.
+type bip32Vector struct {
+	seed   string
+	path   string
+	extPub string
+	extPrv string
+}
+
+// Official BIP-32 test vectors 1-4.
+// https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+var bip32Vectors = []bip32Vector{
+	{"000102030405060708090a0b0c0d0e0f", "m",
+		"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+		"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'",
+		"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+		"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1",
+		"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+		"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'",
+		"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+		"xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2",
+		"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+		"xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000",
+		"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+		"xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m",
+		"xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB",
+		"xprv9s21ZrQH143K31xYSDQpPDxsXRTUcvj2iNHm5NUtrGiGG5e2DtALGdso3pGz6ssrdK4PFmM8NSpSBHNqPqm55Qn3LqFtT2emdEXVYsCzC2U"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0",
+		"xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH",
+		"xprv9vHkqa6EV4sPZHYqZznhT2NPtPCjKuDKGY38FBWLvgaDx45zo9WQRUT3dKYnjwih2yJD9mkrocEZXo1ex8G81dwSM1fwqWpWkeS3v86pgKt"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647'",
+		"xpub6ASAVgeehLbnwdqV6UKMHVzgqAG8Gr6riv3Fxxpj8ksbH9ebxaEyBLZ85ySDhKiLDBrQSARLq1uNRts8RuJiHjaDMBU4Zn9h8LZNnBC5y4a",
+		"xprv9wSp6B7kry3Vj9m1zSnLvN3xH8RdsPP1Mh7fAaR7aRLcQMKTR2vidYEeEg2mUCTAwCd6vnxVrcjfy2kRgVsFawNzmjuHc2YmYRmagcEPdU9"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647'/1",
+		"xpub6DF8uhdarytz3FWdA8TvFSvvAh8dP3283MY7p2V4SeE2wyWmG5mg5EwVvmdMVCQcoNJxGoWaU9DCWh89LojfZ537wTfunKau47EL2dhHKon",
+		"xprv9zFnWC6h2cLgpmSA46vutJzBcfJ8yaJGg8cX1e5StJh45BBciYTRXSd25UEPVuesF9yog62tGAQtHjXajPPdbRCHuWS6T8XA2ECKADdw4Ef"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647'/1/2147483646'",
+		"xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL",
+		"xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647'/1/2147483646'/2",
+		"xpub6FnCn6nSzZAw5Tw7cgR9bi15UV96gLZhjDstkXXxvCLsUXBGXPdSnLFbdpq8p9HmGsApME5hQTZ3emM2rnY5agb9rXpVGyy3bdW6EEgAtqt",
+		"xprvA2nrNbFZABcdryreWet9Ea4LvTJcGsqrMzxHx98MMrotbir7yrKCEXw7nadnHM8Dq38EGfSh6dqA9QWTyefMLEcBYJUuekgW4BYPJcr9E7j"},
+	{"4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be", "m",
+		"xpub661MyMwAqRbcEZVB4dScxMAdx6d4nFc9nvyvH3v4gJL378CSRZiYmhRoP7mBy6gSPSCYk6SzXPTf3ND1cZAceL7SfJ1Z3GC8vBgp2epUt13",
+		"xprv9s21ZrQH143K25QhxbucbDDuQ4naNntJRi4KUfWT7xo4EKsHt2QJDu7KXp1A3u7Bi1j8ph3EGsZ9Xvz9dGuVrtHHs7pXeTzjuxBrCmmhgC6"},
+	{"4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be", "m/0'",
+		"xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y",
+		"xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L"},
+	{"3ddd5602285899a946114506157c7997e5444528f3003f6134712147db19b678", "m",
+		"xpub661MyMwAqRbcGczjuMoRm6dXaLDEhW1u34gKenbeYqAix21mdUKJyuyu5F1rzYGVxyL6tmgBUAEPrEz92mBXjByMRiJdba9wpnN37RLLAXa",
+		"xprv9s21ZrQH143K48vGoLGRPxgo2JNkJ3J3fqkirQC2zVdk5Dgd5w14S7fRDyHH4dWNHUgkvsvNDCkvAwcSHNAQwhwgNMgZhLtQC63zxwhQmRv"},
+	{"3ddd5602285899a946114506157c7997e5444528f3003f6134712147db19b678", "m/0'",
+		"xpub69AUMk3qDBi3uW1sXgjCmVjJ2G6WQoYSnNHyzkmdCHEhSZ4tBok37xfFEqHd2AddP56Tqp4o56AePAgCjYdvpW2PU2jbUPFKsav5ut6Ch1m",
+		"xprv9vB7xEWwNp9kh1wQRfCCQMnZUEG21LpbR9NPCNN1dwhiZkjjeGRnaALmPXCX7SgjFTiCTT6bXes17boXtjq3xLpcDjzEuGLQBM5ohqkao9G"},
+	{"3ddd5602285899a946114506157c7997e5444528f3003f6134712147db19b678", "m/0'/1'",
+		"xpub6BJA1jSqiukeaesWfxe6sNK9CCGaujFFSJLomWHprUL9DePQ4JDkM5d88n49sMGJxrhpjazuXYWdMf17C9T5XnxkopaeS7jGk1GyyVziaMt",
+		"xprv9xJocDuwtYCMNAo3Zw76WENQeAS6WGXQ55RCy7tDJ8oALr4FWkuVoHJeHVAcAqiZLE7Je3vZJHxspZdFHfnBEjHqU5hG1Jaj32dVoS6XLT1"},
+}
+
+// The BIP-49/84/86 vectors all start from the BIP-39 test mnemonic.
+const (
+	selfCheckMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	selfCheckSeed     = "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+)
+
+// bip39Vectors are entropy and mnemonic pairs from the BIP-39 test vectors.
+var bip39Vectors = []struct{ entropy, mnemonic string }{
+	{"00000000000000000000000000000000", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"},
+	{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", "legal winner thank year wave sausage worth useful legal winner thank yellow"},
+	{"80808080808080808080808080808080", "letter advice cage absurd amount doctor acoustic avoid letter advice cage above"},
+	{"ffffffffffffffffffffffffffffffff", "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong"},
+	{"9e885d952ad362caeb4efe34a8e91bd2", "ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic"},
+	{"f585c11aec520db57dd353c69554b21a89b20fb0650966fa0a9d6f74fd989d8f", "void come effort suffer camp survey warrior heavy shoot primary clutch crush open amazing screen patrol group space point ten exist slush involve unfold"},
+}
+
+// bip85Master is the master key of the BIP-85 test vectors.
+// https://github.com/bitcoin/bips/blob/master/bip-0085.mediawiki
+const bip85Master = "xprv9s21ZrQH143K2LBWUUQRFXhucrQqBpKdRRxNVq2zBqsx8HVqFk2uYo8kmbaLLHRdqtQpUm98uKfu3vca1LqdGhUtyoFnCNkfmXRyPXLjbKb"
+
+// bip85Vectors are BIP-85 test vectors: the child secret of each request.
+var bip85Vectors = []struct {
+	name     string
+	request  bip85Request
+	expected string
+}{
+	{"bip39 12 words", bip85Request{app: "bip39", words: 12, scriptType: "native_segwit"}, "girl mad pet galaxy egg matter matrix prison refuse sense ordinary nose"},
+	{"bip39 24 words", bip85Request{app: "bip39", words: 24, scriptType: "native_segwit"}, "puppy ocean match cereal symbol another shed magic wrap hammer bulb intact gadget divorce twin tonight reason outdoor destroy simple truth cigar social volcano"},
+	{"wif", bip85Request{app: "wif"}, "Kzyv4uF39d4Jrw2W7UryTHwZr1zQVNk4dAFyqE6BuMrMh1Za7uhp"},
+	{"xprv", bip85Request{app: "xprv", scriptType: "native_segwit"}, "xprv9s21ZrQH143K2srSbCSg4m4kLvPMzcWydgmKEnMmoZUurYuBuYG46c6P71UGXMzmriLzCCBvKQWBUv3vPB3m1SATMhp3uEjXHJ42jFg7myX"},
+	{"hex 64 bytes", bip85Request{app: "hex", bytes: 64}, "492db4698cf3b73a5a24998aa3e9d7fa96275d85724a91e71aa2d645442f878555d078fd1f1f67e368976f04137b1f7a0d19232136ca50c44614af72b5582a5c"},
+}
+
+// seedXORVector is the Seed XOR example of the Coldcard documentation: three
+// parts and the mnemonic they combine to.
+// https://seedxor.com
+var seedXORVector = struct{ parts, mnemonic string }{
+	"romance wink lottery autumn shop bring dawn tongue range crater truth ability miss spice fitness easy legal release recall obey exchange recycle dragon room\n" +
+		"lion misery divide hurry latin fluid camp advance illegal lab pyramid unaware eager fringe sick camera series noodle toy crowd jeans select depth lounge\n" +
+		"vault nominee cradle silk own frown throw leg cactus recall talent worry gadget surface shy planet purpose coffee drip few seven term squeeze educate",
+	"silent toe meat possible chair blossom wait occur this worth option bag nurse find fish scene bench asthma bike wage world quit primary indoor",
+}
+
+// slip39Vectors are SLIP-39 test vectors, all with the passphrase
+// "TREZOR": the master secret each share set recombines.
+// https://github.com/trezor/python-shamir-mnemonic/blob/master/vectors.json
+var slip39Vectors = []struct {
+	name   string
+	shares string
+	secret string
+}{
+	{"1 of 1, 128 bits", "duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision keyboard", "bb54aac4b89dc868ba37d9cc21b2cece"},
+	{"2 of 3, 128 bits", "shadow pistol academic always adequate wildlife fancy gross oasis cylinder mustang wrist rescue view short owner flip making coding armed\nshadow pistol academic acid actress prayer class unknown daughter sweater depict flip twice unkind craft early superior advocate guest smoking", "b43ceb7e57a0ea8766221624d01b0864"},
+	{"1 of 1, 256 bits", "theory painting academic academic armed sweater year military elder discuss acne wildlife boring employer fused large satoshi bundle carbon diagnose anatomy hamster leaves tracks paces beyond phantom capital marvel lips brave detect luck", "989baf9dcaad5b10ca33dfd8cc75e42477025dce88ae83e75a230086a0e00e92"},
+}
+
+// accountVector is one BIP-49/84/86 account with its published addresses.
+type accountVector struct {
+	suite      string
+	path       string
+	network    string
+	scriptType string
+	accountPub string
+	addresses  []expectedVectorAddress
+}
+
+type expectedVectorAddress struct {
+	change  bool
+	index   uint32
+	address string
+}
+
+var accountVectors = []accountVector{
+	{"bip49", "m/49'/1'/0'", "testnet", "nested_segwit",
+		"upub5EFU65HtV5TeiSHmZZm7FUffBGy8UKeqp7vw43jYbvZPpoVsgU93oac7Wk3u6moKegAEWtGNF8DehrnHtv21XXEMYRUocHqguyjknFHYfgY",
+		[]expectedVectorAddress{
+			{false, 0, "2Mww8dCYPUpKHofjgcXcBCEGmniw9CoaiD2"},
+		}},
+	{"bip84", "m/84'/0'/0'", "mainnet", "native_segwit",
+		"zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs",
+		[]expectedVectorAddress{
+			{false, 0, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+			{false, 1, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+			{true, 0, "bc1q8c6fshw2dlwun7ekn9qwf37cu2rn755upcp6el"},
+		}},
+	{"bip86", "m/86'/0'/0'", "mainnet", "taproot",
+		"xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ",
+		[]expectedVectorAddress{
+			{false, 0, "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"},
+			{false, 1, "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh"},
+			{true, 0, "bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7"},
+		}},
+}
+
+// bip67Vector is an official BIP-67 sorted multisig vector (P2SH).
+type bip67Vector struct {
+	pubKeys   []string
+	threshold int
+	address   string
+}
+
+var bip67Vectors = []bip67Vector{
+	{[]string{
+		"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+		"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+	}, 2, "39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z"},
+	{[]string{
+		"02632b12f4ac5b1d1b72b2a3b508c19172de44f6f46bcee50ba33f3f9291e47ed0",
+		"027735a29bae7780a9755fae7a1c4374c656ac6a69ea9f3697fda61bb99a4f3e77",
+		"02e2cc6bd5f45edd43bebe7cb9b675f0ce9ed3efe613b177588290ad188d11b404",
+	}, 2, "3CKHTjBKxCARLzwABMu9yD85kvtm7WnMfH"},
+	{[]string{
+		"022df8750480ad5b26950b25c7ba79d3e37d75f640f8e5d9bcd5b150a0f85014da",
+		"03e3818b65bcc73a7d64064106a859cc1a5a728c4345ff0b641209fba0d90de6e9",
+		"021f2f6e1e50cb6a953935c3601284925decd3fd21bc445712576873fb8c6ebc18",
+	}, 2, "3Q4sF6tv9wsdqu2NtARzNCpQgwifm2rAba"},
+}
+
+// Valid KeyAgg cases from the BIP-327 test vectors. Key lists are
+// aggregated in the given order, without KeySort.
+// https://github.com/bitcoin/bips/blob/master/bip-0327/vectors/key_agg_vectors.json
+var musigKeyAggVectors = []struct {
+	keys     []string
+	expected string
+}{
+	{[]string{bip327Key0, bip327Key1, bip327Key2}, "90539eede565f5d054f32cc0c220126889ed1e5d193baf15aef344fe59d4610c"},
+	{[]string{bip327Key2, bip327Key1, bip327Key0}, "6204de8b083426dc6eaf9502d27024d53fc826bf7d2012148a0575435df54b2b"},
+	{[]string{bip327Key0, bip327Key0, bip327Key0}, "b436e3bad62b8cd409969a224731c193d051162d8c5ae8b109306127da3aa935"},
+	{[]string{bip327Key0, bip327Key0, bip327Key1, bip327Key1}, "69bc22bfa5d106306e48a20679de1d7389386124d07571d0d872686028c26a3e"},
+}
+
+const (
+	bip327Key0 = "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+	bip327Key1 = "03dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659"
+	bip327Key2 = "023590a94e768f8e1815c2f24b4d80a8e3149316c3518ce7b7ad338368d038ca66"
+)
+
+// BIP-390 musig() and script tree policies, receive index 3, cross-checked
+// against an independent from-scratch KeyAgg/BIP-328/BIP-341 implementation.
+const (
+	policyKeyA = "tpubDFPtPArj4GzBEFHohegg1Xatrc1Fi9oSox5LzuSRX91miwQxuUrEpBxpvDRsmZYJKYFhgdK3UStsjC8JKXfUbMinjFqiEM4uNwzVaCaHpys"
+	policyKeyB = "tpubDEfobrrtptRTbKf4gysDhoabneABDTAcdj3Vbn4XwPsLE2pmqpizSPRG6zHsbAMuiSgWmWPsYCLHTKTPpyrGJ5rAoTpKoQNZcxodiPf2tSJ"
+	policyKeyC = "tpubDDfvzhdVV4unsoKt5aE6dcsNsfeWbTgmLZPi8LQDYU2xixrYemMfWJ3BaVneH3u7DBQePdTwhpybaKRU95pi6PMUtLPBJLVQRpzEnjfjZzX"
+)
+
+var taprootPolicyVectors = []struct {
+	name, descriptor, address string
+}{
+	{"aggregate derivation", "tr(musig(" + policyKeyA + "," + policyKeyB + "," + policyKeyC + ")/0/*)",
+		"tb1ppl57wx4zz4tnl94sep2kd96mvcnvwzfx97cddg8k5e69kpat7v6qp7vlzu"},
+	{"participant derivation", "tr(musig(" + policyKeyA + "/0/*," + policyKeyB + "/0/*," + policyKeyC + "/0/*))",
+		"tb1p6asc05en0a0eff4kwtf0qrmqs4vad9d80haq2dfe35myajdvwj9sk88fkr"},
+	{"script tree", "tr(musig(" + policyKeyA + "," + policyKeyB + "," + policyKeyC + ")/0/*,{pk(musig(" + policyKeyA + "," + policyKeyB + ")/0/*),sortedmulti_a(2," + policyKeyA + "/0/*," + policyKeyC + "/0/*)})",
+		"tb1pnfncmarpjgddl98vcvw3rmfv3uy4tr8n7amwx62qtr4973z2z35seyvcms"},
+}
+
+// Official BIP-173/BIP-350 vectors.
+// https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+var (
+	validBech32Strings = []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	}
+	validBech32mStrings = []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	}
+	invalidBech32mStrings = []string{
+		"\x201xj0phk",
+		"\x7f1g6xzxy",
+		"\x801vctc34",
+		"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4",
+		"qyrz8wqd2c9m",
+		"1qyrz8wqd2c9m",
+		"y1b0jsk6g",
+		"lt1igcx5c0",
+		"in1muywd",
+		"mm1crxm3i",
+		"au1s5cgom",
+		"M1VUXWEZ",
+		"16plkw9",
+		"1p2gdwpf",
+	}
+	validSegwitAddresses = [][2]string{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "6002751e"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+		{"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	invalidSegwitAddresses = []string{
+		"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+		"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf",
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",
+		"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47",
+		"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4",
+		"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R",
+		"bc1pw5dgrnzv",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav",
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf",
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j",
+		"bc1gmk9yu",
+	}
+)
+
+// runSelfCheck runs every built-in vector and reports each result.
+func runSelfCheck() *SelfCheckReport {
+	report := &SelfCheckReport{Vectors: []VectorCheck{}}
+	add := func(suite, name, expected, got string, err error) {
+		check := VectorCheck{Suite: suite, Name: name, Expected: expected, Got: got}
+		if err != nil {
+			check.Error = err.Error()
+		} else {
+			check.Passed = got == expected
+		}
+		report.Vectors = append(report.Vectors, check)
+	}
+
+	for _, v := range bip32Vectors {
+		name := fmt.Sprintf("seed %s… %s", v.seed[:8], v.path)
+		key, err := selfCheckDerive(v.seed, v.path, &chaincfg.MainNetParams)
+		if err != nil {
+			add("bip32", name+" xprv", v.extPrv, "", err)
+			add("bip32", name+" xpub", v.extPub, "", err)
+			continue
+		}
+		add("bip32", name+" xprv", v.extPrv, key.String(), nil)
+		pub, err := key.Neuter()
+		if err != nil {
+			add("bip32", name+" xpub", v.extPub, "", err)
+			continue
+		}
+		add("bip32", name+" xpub", v.extPub, pub.String(), nil)
+	}
+
+	seed := pbkdf2.Key([]byte(selfCheckMnemonic), []byte("mnemonic"), 2048, 64, sha512.New)
+	add("bip39", "test mnemonic seed", selfCheckSeed, hex.EncodeToString(seed), nil)
+	wordlist := sha256.Sum256([]byte(bip39WordlistText))
+	add("bip39", "english wordlist", bip39WordlistSHA256, hex.EncodeToString(wordlist[:]), nil)
+	for _, v := range bip39Vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		got, err := entropyToMnemonic(entropy)
+		add("bip39", "entropy "+v.entropy, v.mnemonic, got, err)
+	}
+
+	for _, v := range bip85Vectors {
+		got, err := selfCheckBip85(v.request)
+		add("bip85", v.name, v.expected, got, err)
+	}
+
+	words, _, err := combineSeedXOR(seedXORVector.parts)
+	add("seed-xor", "3 parts, 24 words", seedXORVector.mnemonic, strings.Join(words, " "), err)
+
+	wordlist = sha256.Sum256([]byte(slip39WordlistText))
+	add("slip39", "wordlist", slip39WordlistSHA256, hex.EncodeToString(wordlist[:]), nil)
+	for _, v := range slip39Vectors {
+		secret, _, err := combineSLIP39(v.shares, "TREZOR")
+		add("slip39", v.name, v.secret, hex.EncodeToString(secret), err)
+	}
+
+	for _, v := range accountVectors {
+		account, err := selfCheckDerive(selfCheckSeed, v.path, getNetwork(v.network))
+		var pub *hdkeychain.ExtendedKey
+		if err == nil {
+			pub, err = account.Neuter()
+		}
+		if err != nil {
+			add(v.suite, v.path+" account", v.accountPub, "", err)
+			continue
+		}
+		standard := convertToStandardXpub(v.accountPub, v.network)
+		add(v.suite, v.path+" account", standard, pub.String(), nil)
+
+		for _, a := range v.addresses {
+			got, err := deriveSingleSig(v.accountPub, a.index, v.scriptType, a.change, v.network)
+			add(v.suite, v.path+"/"+chainIndex(a.change, a.index), a.address, got, err)
+		}
+	}
+
+	for i, v := range bip67Vectors {
+		got, err := selfCheckBip67(v)
+		add("bip67", fmt.Sprintf("vector %d", i+1), v.address, got, err)
+	}
+
+	for i, v := range musigKeyAggVectors {
+		got, err := selfCheckMuSigKeyAgg(v.keys)
+		add("bip327", fmt.Sprintf("key agg %d", i+1), v.expected, got, err)
+	}
+
+	for _, v := range taprootPolicyVectors {
+		var got string
+		spec, err := parseDescriptor(v.descriptor)
+		if err == nil {
+			got, err = spec.deriveAddress(false, 3)
+		}
+		add("bip390", v.name, v.address, got, err)
+	}
+
+	for _, name := range append(scriptTypeNames(false), scriptTypeNames(true)...) {
+		st := scriptTypes[name]
+		for _, v := range st.vectors {
+			var got string
+			var err error
+			if st.multisig {
+				got, err = deriveMultisig(v.xpubs, v.threshold, v.index, name, v.change, v.network)
+			} else {
+				got, err = deriveSingleSig(v.xpubs[0], v.index, name, v.change, v.network)
+			}
+			add("script-type", fmt.Sprintf("%s %s %s", name, v.network, chainIndex(v.change, v.index)), v.address, got, err)
+		}
+	}
+
+	// The same vectors again through the internal engine alone, whatever
+	// --engine selected.
+	for _, v := range accountVectors {
+		st := scriptTypes[v.scriptType]
+		for _, a := range v.addresses {
+			got, err := internalSingleSig(v.accountPub, a.index, st, a.change, v.network)
+			add("internal-engine", v.path+"/"+chainIndex(a.change, a.index), a.address, got, err)
+		}
+	}
+	for _, name := range append(scriptTypeNames(false), scriptTypeNames(true)...) {
+		st := scriptTypes[name]
+		for _, v := range st.vectors {
+			var got string
+			var err error
+			if st.multisig {
+				got, err = internalMultisig(v.xpubs, v.threshold, v.index, st, v.change, v.network)
+			} else {
+				got, err = internalSingleSig(v.xpubs[0], v.index, st, v.change, v.network)
+			}
+			add("internal-engine", fmt.Sprintf("%s %s %s", name, v.network, chainIndex(v.change, v.index)), v.address, got, err)
+		}
+	}
+
+	for _, s := range validBech32Strings {
+		add("bech32", s, "bech32", selfCheckBech32Variant(s), nil)
+	}
+	for _, s := range validBech32mStrings {
+		add("bech32m", s, "bech32m", selfCheckBech32Variant(s), nil)
+	}
+	for _, s := range invalidBech32mStrings {
+		add("bech32m", strconv.Quote(s), "invalid", selfCheckBech32Variant(s), nil)
+	}
+	for _, v := range validSegwitAddresses {
+		got, err := selfCheckSegwitScript(v[0])
+		add("segwit", v[0], v[1], got, err)
+	}
+	for _, addr := range invalidSegwitAddresses {
+		got := "invalid"
+		if _, err := selfCheckSegwitScript(addr); err == nil {
+			got = "valid"
+		}
+		add("segwit", addr, "invalid", got, nil)
+	}
+
+	report.Total = len(report.Vectors)
+	for _, v := range report.Vectors {
+		if !v.Passed {
+			report.Failed++
+		}
+	}
+	report.Passed = report.Failed == 0
+	return report
+}
+
+// chainIndex renders a change/index pair as "<chain>/<index>".
+func chainIndex(change bool, index uint32) string {
+	if change {
+		return fmt.Sprintf("1/%d", index)
+	}
+	return fmt.Sprintf("0/%d", index)
+}
+
+// selfCheckDerive derives a full path ("m/0'/1") from a hex seed.
+func selfCheckDerive(seedHex, path string, net *chaincfg.Params) (*hdkeychain.ExtendedKey, error) {
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hdkeychain.NewMaster(seed, net)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range strings.Split(path, "/")[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(step, "'") {
+			step = strings.TrimSuffix(step, "'")
+			offset = hdkeychain.HardenedKeyStart
+		}
+		n, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path step %q", step)
+		}
+		if key, err = key.Derive(uint32(n) + offset); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// selfCheckBip85 derives a BIP-85 test vector's child secret.
+func selfCheckBip85(req bip85Request) (string, error) {
+	master, err := hdkeychain.NewKeyFromString(bip85Master)
+	if err != nil {
+		return "", err
+	}
+	req.reveal = true
+	report, err := deriveBip85(master, &req)
+	if err != nil {
+		return "", err
+	}
+	for _, secret := range []string{report.Mnemonic, report.WIF, report.Xprv} {
+		if secret != "" {
+			return secret, nil
+		}
+	}
+	return report.Entropy, nil
+}
+
+func selfCheckBip67(v bip67Vector) (string, error) {
+	var pubKeys []*btcec.PublicKey
+	for _, h := range v.pubKeys {
+		raw, err := hex.DecodeString(h)
+		if err != nil {
+			return "", err
+		}
+		pk, err := btcec.ParsePubKey(raw)
+		if err != nil {
+			return "", err
+		}
+		pubKeys = append(pubKeys, pk)
+	}
+	p2sh, err := lookupScriptType("p2sh")
+	if err != nil {
+		return "", err
+	}
+	return p2sh.address(pubKeys, v.threshold, &chaincfg.MainNetParams)
+}
+
+// selfCheckBech32Variant classifies a string as "bech32", "bech32m" or
+// "invalid".
+func selfCheckBech32Variant(s string) string {
+	_, _, version, err := bech32.DecodeGeneric(s)
+	switch {
+	case err != nil:
+		return "invalid"
+	case version == bech32.VersionM:
+		return "bech32m"
+	default:
+		return "bech32"
+	}
+}
+
+// selfCheckSegwitScript decodes a segwit address for any witness version
+// (btcutil only supports v0 and v1) and returns its scriptPubKey as hex,
+// following the BIP-350 reference decoder.
+func selfCheckSegwitScript(addr string) (string, error) {
+	hrp, data, variant, err := bech32.DecodeGeneric(addr)
+	if err != nil {
+		return "", err
+	}
+	if hrp != "bc" && hrp != "tb" {
+		return "", fmt.Errorf("unknown hrp %q", hrp)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("empty data section")
+	}
+	version := data[0]
+	program, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case version > 16:
+		return "", fmt.Errorf("invalid witness version %d", version)
+	case len(program) < 2 || len(program) > 40:
+		return "", fmt.Errorf("invalid program length %d", len(program))
+	case version == 0 && len(program) != 20 && len(program) != 32:
+		return "", fmt.Errorf("invalid v0 program length %d", len(program))
+	case version == 0 && variant != bech32.Version0:
+		return "", fmt.Errorf("v0 address must use bech32")
+	case version != 0 && variant != bech32.VersionM:
+		return "", fmt.Errorf("v%d address must use bech32m", version)
+	}
+
+	op := byte(0)
+	if version > 0 {
+		op = 0x50 + version
+	}
+	script := append([]byte{op, byte(len(program))}, program...)
+	return hex.EncodeToString(script), nil
+}
+
+// selfCheckMuSigKeyAgg runs one BIP-327 KeyAgg vector.
+func selfCheckMuSigKeyAgg(keysHex []string) (string, error) {
+	keys := make([]*btcec.PublicKey, len(keysHex))
+	for i, h := range keysHex {
+		raw, err := hex.DecodeString(h)
+		if err != nil {
+			return "", err
+		}
+		if keys[i], err = btcec.ParsePubKey(raw); err != nil {
+			return "", err
+		}
+	}
+	agg, _, _, err := musig2.AggregateKeys(keys, false)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(schnorr.SerializePubKey(agg.PreTweakedKey)), nil
+}
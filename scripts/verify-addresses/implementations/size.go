@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Fees are paid per virtual byte, a quarter of the weight: bytes outside
+// the witness weigh 4, witness bytes 1. Input sizes are estimated for the
+// largest signatures a signer produces (72-byte DER ECDSA including the
+// sighash byte, 64-byte Schnorr with the default sighash), so a fee
+// computed from them never falls short of the rate asked for.
+
+const (
+	ecdsaSignatureSize   = 72
+	schnorrSignatureSize = 64
+
+	// lowRSignatureSize is the largest signature of a signer that grinds
+	// for a low R, as Bitcoin Core and most hardware signers do: its R fits
+	// in 32 bytes rather than 33.
+	lowRSignatureSize = 71
+
+	// outpointSize is the previous txid, output index and sequence of an
+	// input, all fixed-size.
+	outpointSize = 32 + 4 + 4
+)
+
+// inputSize is the estimated size of one input: its bytes outside the
+// witness and its witness bytes, counts included.
+type inputSize struct {
+	base      int
+	witness   int
+	scriptSig int
+}
+
+// weight is the input's weight.
+func (s inputSize) weight() int {
+	return 4*s.base + s.witness
+}
+
+// pushSize is the size of a minimal push of n bytes.
+func pushSize(n int) int {
+	switch {
+	case n < txscript.OP_PUSHDATA1:
+		return 1 + n
+	case n <= 0xff:
+		return 2 + n
+	default:
+		return 3 + n
+	}
+}
+
+// scriptSigInput is an input with a scriptSig of n bytes and no witness.
+func scriptSigInput(n int) inputSize {
+	return inputSize{base: outpointSize + wire.VarIntSerializeSize(uint64(n)) + n, scriptSig: n}
+}
+
+// witnessInput is an input with the given scriptSig size and a witness of
+// the given items.
+func witnessInput(scriptSig int, items ...int) inputSize {
+	s := scriptSigInput(scriptSig)
+	s.witness = wire.VarIntSerializeSize(uint64(len(items)))
+	for _, n := range items {
+		s.witness += wire.VarIntSerializeSize(uint64(n)) + n
+	}
+	return s
+}
+
+// smallIntSize is the size of the push of a multisig count: opcodes cover
+// up to 16, and the counts above, up to the 20-key limit, take a one-byte
+// push.
+func smallIntSize(v int) int {
+	if v <= 16 {
+		return 1
+	}
+	return 2
+}
+
+// multisigScriptSize is the size of an m-of-n sorted multisig script of
+// compressed keys.
+func multisigScriptSize(m, n int) int {
+	return smallIntSize(m) + 34*n + smallIntSize(n) + 1
+}
+
+// walletInputSize estimates the size of an input spending one of a
+// wallet's outputs. Taproot policies, whose spend depends on the leaf
+// used, are not supported.
+func walletInputSize(spec *WalletSpec) (inputSize, error) {
+	if spec.Taproot != nil {
+		return inputSize{}, fmt.Errorf("spend sizes of taproot policy wallets are not supported")
+	}
+	return scriptInputSize(spec.ScriptType, spec.Threshold, len(spec.Keys), spec.Uncompressed, ecdsaSignatureSize)
+}
+
+// scriptInputSize estimates the size of an input spending an output of
+// scriptType, m-of-n for the multisig types, with ECDSA signatures of
+// sigSize bytes.
+func scriptInputSize(scriptType string, m, n int, uncompressed bool, sigSize int) (inputSize, error) {
+	keySize := 33
+	if uncompressed {
+		keySize = 65
+	}
+	sig := 1 + sigSize
+
+	switch scriptType {
+	case "legacy":
+		return scriptSigInput(sig + 1 + keySize), nil
+	case "p2pk", "p2pk_uncompressed":
+		return scriptSigInput(sig), nil
+	case "nested_segwit":
+		return witnessInput(1+22, sigSize, 33), nil
+	case "native_segwit":
+		return witnessInput(0, sigSize, 33), nil
+	case "taproot":
+		return witnessInput(0, schnorrSignatureSize), nil
+	case "bare_multisig":
+		return scriptSigInput(1 + m*sig), nil
+	case "p2sh":
+		return scriptSigInput(1 + m*sig + pushSize(multisigScriptSize(m, n))), nil
+	case "p2wsh", "p2sh_p2wsh":
+		items := []int{0}
+		for i := 0; i < m; i++ {
+			items = append(items, sigSize)
+		}
+		items = append(items, multisigScriptSize(m, n))
+		scriptSig := 0
+		if scriptType == "p2sh_p2wsh" {
+			scriptSig = 1 + 34
+		}
+		return witnessInput(scriptSig, items...), nil
+	}
+	return inputSize{}, fmt.Errorf("spend size of script type %s is not known", scriptType)
+}
+
+// SpendSize is the size of an input spending one of a multisig wallet's
+// outputs with the threshold of signatures.
+type SpendSize struct {
+	Signatures int `json:"signatures"`
+	// ScriptSize is the multisig script's size, pushed in the witness or
+	// the scriptSig.
+	ScriptSize int `json:"script_size"`
+	// WorstCase has every signature at the 72 bytes a signer may produce;
+	// Typical at the 71 of a signer grinding for low R.
+	WorstCase SpendSizeCase `json:"worst_case"`
+	Typical   SpendSizeCase `json:"typical"`
+}
+
+// SpendSizeCase is an input's size for one signature size.
+type SpendSizeCase struct {
+	SignatureSize int `json:"signature_size"`
+	// ScriptSig and Witness are in bytes, the witness's item count and
+	// lengths included.
+	ScriptSig int     `json:"script_sig"`
+	Witness   int     `json:"witness"`
+	Weight    int     `json:"weight"`
+	VSize     float64 `json:"vsize"`
+}
+
+// walletSpendSize sizes a spend from a multisig wallet, or returns nil for
+// other wallets.
+func walletSpendSize(spec *WalletSpec) (*SpendSize, error) {
+	if !spec.isMultisig() || spec.Taproot != nil {
+		return nil, nil
+	}
+	m, n := spec.Threshold, len(spec.Keys)
+	s := &SpendSize{Signatures: m, ScriptSize: multisigScriptSize(m, n)}
+	for _, c := range []struct {
+		sigSize int
+		size    *SpendSizeCase
+	}{{ecdsaSignatureSize, &s.WorstCase}, {lowRSignatureSize, &s.Typical}} {
+		size, err := scriptInputSize(spec.ScriptType, m, n, false, c.sigSize)
+		if err != nil {
+			return nil, err
+		}
+		*c.size = SpendSizeCase{
+			SignatureSize: c.sigSize,
+			ScriptSig:     size.scriptSig,
+			Witness:       size.witness,
+			Weight:        size.weight(),
+			VSize:         float64(size.weight()) / 4,
+		}
+	}
+	return s, nil
+}
+
+// outputSize is the serialized size of an output paying to script.
+func outputSize(script []byte) int {
+	return 8 + wire.VarIntSerializeSize(uint64(len(script))) + len(script)
+}
+
+// txWeight estimates the weight of a transaction with the given inputs and
+// outputs. Once any input has a witness, every input carries a witness
+// count and the transaction the segwit marker and flag.
+func txWeight(inputs []inputSize, outputs [][]byte) int {
+	base := 4 + wire.VarIntSerializeSize(uint64(len(inputs))) + wire.VarIntSerializeSize(uint64(len(outputs))) + 4
+	for _, script := range outputs {
+		base += outputSize(script)
+	}
+	weight := 4 * base
+	segwit := false
+	for _, in := range inputs {
+		weight += in.weight()
+		segwit = segwit || in.witness > 0
+	}
+	if segwit {
+		weight += 2
+		for _, in := range inputs {
+			if in.witness == 0 {
+				weight++
+			}
+		}
+	}
+	return weight
+}
+
+// vsize is the virtual size of a weight, rounded up.
+func vsize(weight int) int {
+	return (weight + 3) / 4
+}
+
+// dustThreshold is the smallest value an output to script may carry and
+// still be relayed under Bitcoin Core's default dust relay fee of 3 sat/vB:
+// anything less costs more to spend than it is worth.
+func dustThreshold(script []byte) int64 {
+	spend := 148
+	if txscript.IsWitnessProgram(script) {
+		spend = 67
+	}
+	return int64(3 * (outputSize(script) + spend))
+}
+
+// maxFeeRate is the highest fee rate accepted, in sat/kvB: Bitcoin Core's
+// default -maxfeerate of 0.1 BTC/kvB, above which a rate is a typo.
+const maxFeeRate = 10_000_000
+
+// parseFeeRate parses a fee rate in sat/vB, such as "12" or "1.5", into
+// sat/kvB so fees can be computed exactly.
+func parseFeeRate(s string) (int64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(rate) || math.IsInf(rate, 0) || rate <= 0 {
+		return 0, fmt.Errorf("invalid fee rate: %q (want sat/vB, such as 12 or 1.5)", s)
+	}
+	kvB := int64(math.Round(rate * 1000))
+	if kvB < 1 || kvB > maxFeeRate {
+		return 0, fmt.Errorf("fee rate %s sat/vB is outside 0.001 to %d sat/vB", s, maxFeeRate/1000)
+	}
+	return kvB, nil
+}
+
+// feeForWeight is the fee at rate (sat/kvB) for a weight, rounded up.
+func feeForWeight(rate int64, weight int) int64 {
+	return (rate*int64(vsize(weight)) + 999) / 1000
+}
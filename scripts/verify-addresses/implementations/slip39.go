@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SLIP-39 splits a master secret into mnemonic shares, of which a threshold
+// of members in a threshold of groups recombine it. slip39 reads the shares,
+// one per line, through --key-env or --key-fd, recombines and decrypts the
+// master secret with the optional passphrase, and reports the wallet it
+// seeds, as mnemonic does for a BIP-39 backup. Shares beyond the thresholds
+// are checked against the recovered secret rather than ignored, so a test
+// restore also catches a bad spare share. slip39-english.txt is the SLIP's
+// wordlist, byte for byte; check --deep checks its hash and recombines the
+// SLIP's test vectors.
+
+//go:embed slip39-english.txt
+var slip39WordlistText string
+
+// slip39WordlistSHA256 is the SHA-256 of the SLIP-39 wordlist.
+const slip39WordlistSHA256 = "bcc4555340332d169718aed8bf31dd9d5248cb7da6e5d355140ef4f1e601eec3"
+
+var slip39Words = strings.Fields(slip39WordlistText)
+
+// slip39WordIndex maps each word, and each four-letter prefix, which
+// SLIP-39 makes unique, to its index.
+var slip39WordIndex = func() map[string]int {
+	index := make(map[string]int, 2*len(slip39Words))
+	for i, w := range slip39Words {
+		index[w] = i
+		if len(w) > 4 {
+			index[w[:4]] = i
+		}
+	}
+	return index
+}()
+
+// SLIP-39 share layout: a 40-bit header of four words, the share value, and
+// a three-word RS1024 checksum.
+const (
+	slip39HeaderWords   = 4
+	slip39ChecksumWords = 3
+	slip39MinWords      = 20
+	// slip39DigestIndex and slip39SecretIndex are the x coordinates of the
+	// digest share and the secret.
+	slip39DigestIndex = 254
+	slip39SecretIndex = 255
+	// slip39BaseIterations is the PBKDF2 iteration count of the whole
+	// Feistel network at iteration exponent 0.
+	slip39BaseIterations = 10000
+	slip39Rounds         = 4
+)
+
+// slip39Generator is the generator of the RS1024 checksum.
+var slip39Generator = [10]uint32{0xE0E040, 0x1C1C080, 0x3838100, 0x7070200, 0xE0E0009, 0x1C0C2412, 0x38086C24, 0x3090FC48, 0x21B1F890, 0x3F3F120}
+
+// SLIP39Report is the master secret a set of shares recombines and the
+// wallet it restores.
+type SLIP39Report struct {
+	Identifier        int  `json:"identifier"`
+	Extendable        bool `json:"extendable"`
+	IterationExponent int  `json:"iteration_exponent"`
+	GroupThreshold    int  `json:"group_threshold"`
+	GroupCount        int  `json:"group_count"`
+	// Groups are the groups the secret was recovered from.
+	Groups     []SLIP39Group   `json:"groups"`
+	Shares     int             `json:"shares"`
+	Bits       int             `json:"bits"`
+	Passphrase bool            `json:"passphrase"`
+	Wallet     *RestoredWallet `json:"wallet"`
+}
+
+// SLIP39Group is one group of shares.
+type SLIP39Group struct {
+	Index           int   `json:"index"`
+	MemberThreshold int   `json:"member_threshold"`
+	Members         []int `json:"members"`
+}
+
+// slip39Share is a decoded share.
+type slip39Share struct {
+	identifier        uint16
+	extendable        bool
+	iterationExponent int
+	groupIndex        int
+	groupThreshold    int
+	groupCount        int
+	memberIndex       int
+	memberThreshold   int
+	value             []byte
+}
+
+// slip39Polymod returns the RS1024 checksum state over values.
+func slip39Polymod(values []int) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 20
+		chk = (chk&0xFFFFF)<<10 ^ uint32(v)
+		for i := 0; i < 10; i++ {
+			if b>>i&1 != 0 {
+				chk ^= slip39Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// slip39Customization is the string the checksum of a share covers first.
+func slip39Customization(extendable bool) string {
+	if extendable {
+		return "shamir_extendable"
+	}
+	return "shamir"
+}
+
+// decodeSLIP39Share decodes and checks one share mnemonic; n numbers it in
+// errors.
+func decodeSLIP39Share(mnemonic string, n int) (*slip39Share, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < slip39MinWords {
+		return nil, errorWithCode(errInvalidMnemonic, "share %d has %d words; a SLIP-39 share has at least %d", n, len(words), slip39MinWords)
+	}
+	values := make([]int, len(words))
+	for i, w := range words {
+		index, ok := slip39WordIndex[w]
+		if !ok {
+			return nil, errorWithCode(errInvalidMnemonic, "word %d of share %d is not in the SLIP-39 wordlist", i+1, n)
+		}
+		values[i] = index
+	}
+	defer clear(values)
+
+	var header uint64
+	for _, v := range values[:slip39HeaderWords] {
+		header = header<<10 | uint64(v)
+	}
+	s := &slip39Share{
+		identifier:        uint16(header >> 25),
+		extendable:        header>>24&1 != 0,
+		iterationExponent: int(header >> 20 & 0xF),
+		groupIndex:        int(header >> 16 & 0xF),
+		groupThreshold:    int(header>>12&0xF) + 1,
+		groupCount:        int(header>>8&0xF) + 1,
+		memberIndex:       int(header >> 4 & 0xF),
+		memberThreshold:   int(header&0xF) + 1,
+	}
+	checked := make([]int, 0, len(values)+len(slip39Customization(true)))
+	for _, c := range []byte(slip39Customization(s.extendable)) {
+		checked = append(checked, int(c))
+	}
+	if slip39Polymod(append(checked, values...)) != 1 {
+		clear(checked)
+		return nil, errorWithCode(errInvalidMnemonic, "share %d checksum mismatch: a word is wrong or out of order", n)
+	}
+	clear(checked)
+	if s.groupThreshold > s.groupCount {
+		return nil, errorWithCode(errInvalidMnemonic, "share %d has a group threshold of %d above its %d groups", n, s.groupThreshold, s.groupCount)
+	}
+
+	data := values[slip39HeaderWords : len(values)-slip39ChecksumWords]
+	bits := len(data) * 10
+	padding := bits % 16
+	if padding > 8 {
+		return nil, errorWithCode(errInvalidMnemonic, "share %d has an invalid length", n)
+	}
+	s.value = make([]byte, (bits-padding)/8)
+	for w, v := range data {
+		for i := 0; i < 10; i++ {
+			if v>>(9-i)&1 == 0 {
+				continue
+			}
+			bit := w*10 + i - padding
+			if bit < 0 {
+				return nil, errorWithCode(errInvalidMnemonic, "share %d has non-zero padding", n)
+			}
+			s.value[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	if len(s.value) < 16 {
+		clear(s.value)
+		return nil, errorWithCode(errInvalidMnemonic, "share %d holds a secret of %d bits; SLIP-39 secrets have at least 128", n, len(s.value)*8)
+	}
+	return s, nil
+}
+
+// gf256Exp and gf256Log are the exponent and logarithm tables of GF(256)
+// with the polynomial x^8+x^4+x^3+x+1 and generator x+1.
+var gf256Exp, gf256Log = func() (exp [255]byte, log [256]byte) {
+	x := byte(1)
+	for i := range exp {
+		exp[i] = x
+		log[x] = byte(i)
+		// x *= 3
+		x ^= x<<1 ^ byte(int8(x)>>7)&0x1b
+	}
+	return exp, log
+}()
+
+// gf256Point is one (x, y) share of a Shamir scheme, y a byte string.
+type gf256Point struct {
+	x byte
+	y []byte
+}
+
+// interpolateGF256 evaluates at x the polynomial through points.
+func interpolateGF256(points []gf256Point, x byte) ([]byte, error) {
+	for _, p := range points {
+		if p.x == x {
+			return append([]byte(nil), p.y...), nil
+		}
+	}
+	// log of the product of (x - x_j), then each basis polynomial's
+	// weight divides out its own term and the product over the others.
+	logProduct := 0
+	for _, p := range points {
+		logProduct += int(gf256Log[p.x^x])
+	}
+	result := make([]byte, len(points[0].y))
+	for i, p := range points {
+		logBasis := logProduct - int(gf256Log[p.x^x])
+		for j, q := range points {
+			if i == j {
+				continue
+			}
+			if p.x == q.x {
+				return nil, fmt.Errorf("two shares have the same index")
+			}
+			logBasis -= int(gf256Log[p.x^q.x])
+		}
+		logBasis = (logBasis%255 + 255) % 255
+		for k, y := range p.y {
+			if y != 0 {
+				result[k] ^= gf256Exp[(int(gf256Log[y])+logBasis)%255]
+			}
+		}
+	}
+	return result, nil
+}
+
+// recoverSecret recombines the secret of a threshold scheme from threshold
+// points, checking its digest.
+func recoverSecret(threshold int, points []gf256Point) ([]byte, error) {
+	if threshold == 1 {
+		return append([]byte(nil), points[0].y...), nil
+	}
+	secret, err := interpolateGF256(points, slip39SecretIndex)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := interpolateGF256(points, slip39DigestIndex)
+	if err != nil {
+		clear(secret)
+		return nil, err
+	}
+	defer clear(digest)
+	mac := hmac.New(sha256.New, digest[4:])
+	mac.Write(secret)
+	if !hmac.Equal(mac.Sum(nil)[:4], digest[:4]) {
+		clear(secret)
+		return nil, errorWithCode(errInvalidMnemonic, "share digest mismatch: the shares are not from the same split")
+	}
+	return secret, nil
+}
+
+// slip39Decrypt decrypts an encrypted master secret with the four-round
+// Feistel network of SLIP-39.
+func slip39Decrypt(encrypted []byte, passphrase string, s *slip39Share) []byte {
+	half := len(encrypted) / 2
+	l := append([]byte(nil), encrypted[:half]...)
+	r := append([]byte(nil), encrypted[half:]...)
+	var salt []byte
+	if !s.extendable {
+		salt = append([]byte("shamir"), byte(s.identifier>>8), byte(s.identifier))
+	}
+	iterations := (slip39BaseIterations << s.iterationExponent) / slip39Rounds
+	for i := slip39Rounds - 1; i >= 0; i-- {
+		f := pbkdf2.Key(append([]byte{byte(i)}, passphrase...), append(append([]byte(nil), salt...), r...), iterations, half, sha256.New)
+		for k := range l {
+			l[k] ^= f[k]
+		}
+		l, r = r, l
+	}
+	secret := append(r, l...)
+	clear(l)
+	return secret
+}
+
+// combineSLIP39 recombines the master secret of shares, one mnemonic per
+// line, and decrypts it with passphrase.
+func combineSLIP39(mnemonics, passphrase string) ([]byte, *SLIP39Report, error) {
+	var shares []*slip39Share
+	defer func() {
+		for _, s := range shares {
+			clear(s.value)
+		}
+	}()
+	for _, line := range strings.FieldsFunc(mnemonics, func(r rune) bool { return r == '\n' || r == '\r' || r == ',' }) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		s, err := decodeSLIP39Share(line, len(shares)+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		shares = append(shares, s)
+	}
+	if len(shares) == 0 {
+		return nil, nil, errorWithCode(errInvalidMnemonic, "no SLIP-39 shares were given")
+	}
+
+	first := shares[0]
+	groups := map[int][]*slip39Share{}
+	for i, s := range shares {
+		if s.identifier != first.identifier || s.extendable != first.extendable || s.iterationExponent != first.iterationExponent ||
+			s.groupThreshold != first.groupThreshold || s.groupCount != first.groupCount || len(s.value) != len(first.value) {
+			return nil, nil, errorWithCode(errInvalidMnemonic, "share %d is not from the same backup as share 1", i+1)
+		}
+		members := groups[s.groupIndex]
+		if len(members) > 0 && s.memberThreshold != members[0].memberThreshold {
+			return nil, nil, errorWithCode(errInvalidMnemonic, "share %d has a different member threshold from the rest of group %d", i+1, s.groupIndex+1)
+		}
+		for _, m := range members {
+			if m.memberIndex == s.memberIndex {
+				return nil, nil, errorWithCode(errInvalidMnemonic, "share %d repeats member %d of group %d", i+1, s.memberIndex+1, s.groupIndex+1)
+			}
+		}
+		groups[s.groupIndex] = append(members, s)
+	}
+
+	report := &SLIP39Report{
+		Identifier:        int(first.identifier),
+		Extendable:        first.extendable,
+		IterationExponent: first.iterationExponent,
+		GroupThreshold:    first.groupThreshold,
+		GroupCount:        first.groupCount,
+		Groups:            []SLIP39Group{},
+		Shares:            len(shares),
+		Bits:              len(first.value) * 8,
+		Passphrase:        passphrase != "",
+	}
+	indexes := make([]int, 0, len(groups))
+	for index := range groups {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var groupPoints []gf256Point
+	defer func() {
+		for _, p := range groupPoints {
+			clear(p.y)
+		}
+	}()
+	for _, index := range indexes {
+		members := groups[index]
+		threshold := members[0].memberThreshold
+		if len(members) < threshold {
+			continue
+		}
+		points := make([]gf256Point, len(members))
+		group := SLIP39Group{Index: index + 1, MemberThreshold: threshold}
+		for i, m := range members {
+			points[i] = gf256Point{x: byte(m.memberIndex), y: m.value}
+			group.Members = append(group.Members, m.memberIndex+1)
+		}
+		sort.Ints(group.Members)
+		secret, err := recoverSecret(threshold, points[:threshold])
+		if err != nil {
+			return nil, nil, fmt.Errorf("group %d: %w", index+1, err)
+		}
+		if err := checkSpareShares(secret, threshold, points, fmt.Sprintf("group %d", index+1)); err != nil {
+			clear(secret)
+			return nil, nil, err
+		}
+		groupPoints = append(groupPoints, gf256Point{x: byte(index), y: secret})
+		report.Groups = append(report.Groups, group)
+	}
+	if len(groupPoints) < first.groupThreshold {
+		return nil, nil, errorWithCode(errInvalidMnemonic, "%d of the %d groups needed are complete; %s", len(groupPoints), first.groupThreshold, describeMissingShares(groups, indexes))
+	}
+	encrypted, err := recoverSecret(first.groupThreshold, groupPoints[:first.groupThreshold])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(encrypted)
+	if err := checkSpareShares(encrypted, first.groupThreshold, groupPoints, "the groups"); err != nil {
+		return nil, nil, err
+	}
+	return slip39Decrypt(encrypted, passphrase, first), report, nil
+}
+
+// checkSpareShares checks that points beyond the threshold lie on the same
+// polynomial as the first threshold points, whose secret is secret.
+func checkSpareShares(secret []byte, threshold int, points []gf256Point, what string) error {
+	if threshold == 1 {
+		for _, p := range points[1:] {
+			if !hmac.Equal(p.y, secret) {
+				return errorWithCode(errInvalidMnemonic, "a spare share of %s does not match the others", what)
+			}
+		}
+		return nil
+	}
+	for _, p := range points[threshold:] {
+		y, err := interpolateGF256(points[:threshold], p.x)
+		if err != nil {
+			return err
+		}
+		match := hmac.Equal(y, p.y)
+		clear(y)
+		if !match {
+			return errorWithCode(errInvalidMnemonic, "a spare share of %s does not match the others", what)
+		}
+	}
+	return nil
+}
+
+// describeMissingShares lists how many shares each incomplete group still
+// needs.
+func describeMissingShares(groups map[int][]*slip39Share, indexes []int) string {
+	var missing []string
+	for _, index := range indexes {
+		members := groups[index]
+		if need := members[0].memberThreshold - len(members); need > 0 {
+			missing = append(missing, fmt.Sprintf("group %d needs %d more", index+1, need))
+		}
+	}
+	if len(missing) == 0 {
+		return "shares from more groups are needed"
+	}
+	return strings.Join(missing, ", ")
+}
+
+// restoreSLIP39 recombines shares and derives the wallet req describes from
+// the master secret, which SLIP-39 uses as the BIP-32 seed.
+func restoreSLIP39(mnemonics, passphrase string, req *mnemonicRequest) (*SLIP39Report, error) {
+	for _, r := range passphrase {
+		if r < 0x20 || r > 0x7e {
+			return nil, fmt.Errorf("SLIP-39 passphrases are printable ASCII")
+		}
+	}
+	secret, report, err := combineSLIP39(mnemonics, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	if report.Wallet, err = restoreSeed(secret, req); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// specterWallet covers both Specter Desktop export shapes: the compact
+// "export to other wallet" file (label/descriptor/devices) and the full
+// wallet backup (recv_descriptor/change_descriptor plus current addresses).
+type specterWallet struct {
+	Label            string            `json:"label"`
+	Name             string            `json:"name"`
+	Descriptor       string            `json:"descriptor"`
+	RecvDescriptor   string            `json:"recv_descriptor"`
+	ChangeDescriptor string            `json:"change_descriptor"`
+	Devices          []json.RawMessage `json:"devices"`
+	BlockHeight      int64             `json:"blockheight"`
+	Address          string            `json:"address"`
+	AddressIndex     *uint32           `json:"address_index"`
+	ChangeAddress    string            `json:"change_address"`
+	ChangeIndex      *uint32           `json:"change_index"`
+}
+
+// specterDevice is a device entry; older exports list bare device types.
+type specterDevice struct {
+	Type  string `json:"type"`
+	Label string `json:"label"`
+}
+
+func parseSpecterWallet(data []byte) (*WalletSpec, error) {
+	var w specterWallet
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("failed to parse Specter wallet: %v", err)
+	}
+
+	desc := w.Descriptor
+	if desc == "" {
+		desc = w.RecvDescriptor
+	}
+	spec, err := parseDescriptor(desc)
+	if err != nil {
+		return nil, fmt.Errorf("specter descriptor: %v", err)
+	}
+
+	if w.ChangeDescriptor != "" {
+		change, err := parseDescriptor(w.ChangeDescriptor)
+		if err != nil {
+			return nil, fmt.Errorf("specter change descriptor: %v", err)
+		}
+		if !sameWalletKeys(spec, change) {
+			return nil, fmt.Errorf("specter change descriptor does not match receive descriptor")
+		}
+	}
+
+	spec.Name = w.Label
+	if spec.Name == "" {
+		spec.Name = w.Name
+	}
+	spec.BlockHeight = w.BlockHeight
+
+	if len(w.Devices) == len(spec.Keys) {
+		for i, raw := range w.Devices {
+			var device specterDevice
+			if err := json.Unmarshal(raw, &device); err != nil {
+				if err := json.Unmarshal(raw, &device.Type); err != nil {
+					return nil, fmt.Errorf("failed to parse Specter device: %v", err)
+				}
+			}
+			spec.Keys[i].Device = device.Type
+			spec.Keys[i].Label = device.Label
+		}
+	}
+
+	if w.Address != "" && w.AddressIndex != nil {
+		spec.Expected = append(spec.Expected, ExpectedAddress{Index: *w.AddressIndex, Address: w.Address})
+	}
+	if w.ChangeAddress != "" && w.ChangeIndex != nil {
+		spec.Expected = append(spec.Expected, ExpectedAddress{Change: true, Index: *w.ChangeIndex, Address: w.ChangeAddress})
+	}
+
+	return spec, nil
+}
+
+// sameWalletKeys reports whether two specs describe the same key set.
+func sameWalletKeys(a, b *WalletSpec) bool {
+	if a.ScriptType != b.ScriptType || a.Threshold != b.Threshold || len(a.Keys) != len(b.Keys) {
+		return false
+	}
+	for i := range a.Keys {
+		if a.Keys[i].Xpub != b.Keys[i].Xpub || a.Keys[i].Fingerprint != b.Keys[i].Fingerprint {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A taproot output is spent either by its key path, one Schnorr signature
+// for the output key, or by revealing one leaf of its script tree with the
+// signatures the leaf needs and a control block of 32 bytes per level of
+// depth. With a musig() internal key the key path takes every participant
+// signing together but costs no more than a single-sig spend; each leaf is
+// a fallback that costs more. spend-paths prices every path of a taproot
+// policy wallet, so the cost of falling back is known before it is needed.
+
+// SpendPathReport prices the spend paths of a taproot policy wallet.
+type SpendPathReport struct {
+	Network     string           `json:"network"`
+	FeeRate     float64          `json:"fee_rate"`
+	FeeEstimate *FeeRateEstimate `json:"fee_estimate,omitempty"`
+	KeyPath     SpendPath        `json:"key_path"`
+	ScriptPaths []SpendPath      `json:"script_paths"`
+}
+
+// SpendPath is the cost of spending one of the wallet's outputs by one
+// path.
+type SpendPath struct {
+	// Policy is the key expression or leaf, with the spec's keys written
+	// @0, @1, ... as BIP-388 wallet policies write them.
+	Policy     string `json:"policy"`
+	Signatures int    `json:"signatures"`
+	// Depth is the leaf's depth in the tree.
+	Depth int `json:"depth,omitempty"`
+	// Witness, Weight, VSize and Fee are for the input alone.
+	Witness int     `json:"witness"`
+	Weight  int     `json:"weight"`
+	VSize   float64 `json:"vsize"`
+	Fee     int64   `json:"fee"`
+	// TxVSize and TxFee are for a transaction spending the one output to
+	// one taproot output.
+	TxVSize int   `json:"tx_vsize"`
+	TxFee   int64 `json:"tx_fee"`
+	// ExtraVSize and ExtraFee are what the path costs over the key path.
+	ExtraVSize float64 `json:"extra_vsize"`
+	ExtraFee   int64   `json:"extra_fee"`
+}
+
+// spendPaths prices every spend path of spec's taproot policy at rate, in
+// sat/kvB.
+func spendPaths(spec *WalletSpec, rate int64) (*SpendPathReport, error) {
+	if spec.Taproot == nil {
+		return nil, fmt.Errorf("spend paths are priced for taproot policy wallets (musig() or a script tree)")
+	}
+	script, err := placeholderScript("taproot", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	price := func(p *SpendPath, size inputSize) {
+		p.Witness = size.witness
+		p.Weight = size.weight()
+		p.VSize = float64(p.Weight) / 4
+		p.Fee = feeForWeight(rate, p.Weight)
+		weight := txWeight([]inputSize{size}, [][]byte{script})
+		p.TxVSize = vsize(weight)
+		p.TxFee = feeForWeight(rate, weight)
+	}
+
+	report := &SpendPathReport{
+		Network:     spec.Network,
+		FeeRate:     float64(rate) / 1000,
+		KeyPath:     SpendPath{Policy: tapKeyPolicy(spec.Taproot.Internal), Signatures: 1},
+		ScriptPaths: []SpendPath{},
+	}
+	price(&report.KeyPath, witnessInput(0, schnorrSignatureSize))
+
+	var walk func(t *TapTree, depth int) error
+	walk = func(t *TapTree, depth int) error {
+		if t.Leaf == nil {
+			if err := walk(t.Left, depth+1); err != nil {
+				return err
+			}
+			return walk(t.Right, depth+1)
+		}
+		leaf, err := t.Leaf.script(spec, false, 0)
+		if err != nil {
+			return err
+		}
+		// multi_a takes an item for every key, empty for those not signing.
+		signatures := 1
+		items := []int{schnorrSignatureSize}
+		if t.Leaf.Script != "pk" {
+			signatures = t.Leaf.Threshold
+			items = make([]int, len(t.Leaf.Keys))
+			for i := 0; i < signatures; i++ {
+				items[i] = schnorrSignatureSize
+			}
+		}
+		items = append(items, len(leaf), 33+32*depth)
+		p := SpendPath{Policy: tapLeafPolicy(t.Leaf), Signatures: signatures, Depth: depth}
+		price(&p, witnessInput(0, items...))
+		p.ExtraVSize = p.VSize - report.KeyPath.VSize
+		p.ExtraFee = p.TxFee - report.KeyPath.TxFee
+		report.ScriptPaths = append(report.ScriptPaths, p)
+		return nil
+	}
+	if spec.Taproot.Tree != nil {
+		if err := walk(spec.Taproot.Tree, 0); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// tapKeyPolicy writes a key expression with placeholders for the keys.
+func tapKeyPolicy(k TapKey) string {
+	keys := make([]string, len(k.Keys))
+	for i, ki := range k.Keys {
+		keys[i] = "@" + strconv.Itoa(ki)
+	}
+	if !k.MuSig {
+		return keys[0]
+	}
+	return "musig(" + strings.Join(keys, ",") + ")"
+}
+
+// tapLeafPolicy writes a leaf with placeholders for the keys.
+func tapLeafPolicy(l *TapLeaf) string {
+	var args []string
+	if l.Script != "pk" {
+		args = append(args, strconv.Itoa(l.Threshold))
+	}
+	for _, k := range l.Keys {
+		args = append(args, tapKeyPolicy(k))
+	}
+	return l.Script + "(" + strings.Join(args, ",") + ")"
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// storeDriver is the database/sql driver used for the wallet state store.
+// It is set by store_sqlite.go, which is only built with -tags sqlite so
+// the default build stays free of cgo.
+var storeDriver string
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS wallets (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL DEFAULT '',
+	network    TEXT NOT NULL,
+	descriptor TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS addresses (
+	wallet_id TEXT NOT NULL REFERENCES wallets(id),
+	change    INTEGER NOT NULL,
+	idx       INTEGER NOT NULL,
+	address   TEXT NOT NULL,
+	used      INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (wallet_id, change, idx)
+);
+CREATE INDEX IF NOT EXISTS addresses_by_address ON addresses(address);
+CREATE TABLE IF NOT EXISTS labels (
+	wallet_id TEXT NOT NULL REFERENCES wallets(id),
+	address   TEXT NOT NULL,
+	label     TEXT NOT NULL,
+	PRIMARY KEY (wallet_id, address)
+);
+CREATE TABLE IF NOT EXISTS checkpoints (
+	wallet_id   TEXT NOT NULL REFERENCES wallets(id),
+	change      INTEGER NOT NULL,
+	next_index  INTEGER NOT NULL,
+	tip_height  INTEGER NOT NULL,
+	updated_at  INTEGER NOT NULL,
+	PRIMARY KEY (wallet_id, change)
+);
+CREATE TABLE IF NOT EXISTS scan_progress (
+	wallet_id  TEXT NOT NULL REFERENCES wallets(id),
+	kind       TEXT NOT NULL,
+	change     INTEGER NOT NULL,
+	backend    TEXT NOT NULL,
+	tip_height INTEGER NOT NULL,
+	next_index INTEGER NOT NULL,
+	state      TEXT NOT NULL DEFAULT '',
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (wallet_id, kind, change)
+);
+CREATE TABLE IF NOT EXISTS registered_wallets (
+	tenant     TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	wallet_id  TEXT NOT NULL REFERENCES wallets(id),
+	spec       TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (tenant, name)
+);
+CREATE TABLE IF NOT EXISTS derivation_cache (
+	wallet_id TEXT NOT NULL,
+	change    INTEGER NOT NULL,
+	idx       INTEGER NOT NULL,
+	address   TEXT NOT NULL,
+	checksum  TEXT NOT NULL,
+	PRIMARY KEY (wallet_id, change, idx)
+);
+`
+
+// storeMigrations bring a store up to date. Entry i moves a store from
+// schema version i, kept in the database's user_version, to version i+1.
+var storeMigrations = []func(*sql.Tx) error{
+	migrateNetworkWalletIDs,
+	dropDerivationCache,
+}
+
+// walletStore persists per-wallet state between runs: derived addresses,
+// which of them have been used, labels, and how far each chain has been
+// scanned.
+type walletStore struct {
+	db *sql.DB
+}
+
+// scanCheckpoint records how far a chain has been scanned. Every index
+// below NextIndex has been checked against the chain at TipHeight.
+type scanCheckpoint struct {
+	NextIndex uint32    `json:"next_index"`
+	TipHeight int64     `json:"tip_height"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// scanProgress is the position of an unfinished scan of one chain, saved
+// after every batch so an interrupted scan resumes where it stopped. Every
+// index below NextIndex was looked up through Backend; State holds whatever
+// else the scan needs to pick up again. The row is removed once the scan
+// completes.
+type scanProgress struct {
+	Backend   string
+	TipHeight int64
+	NextIndex uint32
+	State     string
+	UpdatedAt time.Time
+}
+
+// Kinds of scan whose progress is saved.
+const (
+	progressGapScan  = "gap"
+	progressUTXOScan = "utxos"
+)
+
+func openStore(path string) (*walletStore, error) {
+	if storeDriver == "" {
+		return nil, fmt.Errorf("wallet state store is not available in this build (rebuild with -tags sqlite)")
+	}
+	db, err := sql.Open(storeDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %v", err)
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store: %v", err)
+	}
+	if err := migrateStore(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate store: %v", err)
+	}
+	return &walletStore{db: db}, nil
+}
+
+// migrateStore applies the storeMigrations a store written by an older
+// build has not had yet, each in its own transaction.
+func migrateStore(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(storeMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := storeMigrations[version](tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNetworkWalletIDs is schema version 1. Before it wallet IDs hashed
+// only the receive descriptor, which Liquid shares with Bitcoin, so a
+// mainnet and a liquid wallet on the same key (or testnet and
+// liquidtestnet) shared one ID and each other's state.
+// Wallets and registrations are moved to their new IDs: a registration by
+// the network of its own spec, a wallet row by the network it was first
+// stored with. Keychain-backed wallets, whose descriptor is not kept, keep
+// their old ID until registered again. Derived addresses, used marks, scan
+// checkpoints, scan progress and the derivation cache may mix two networks
+// and cannot be told apart, so they are dropped; the next scan rebuilds
+// them from the chain.
+func migrateNetworkWalletIDs(tx *sql.Tx) error {
+	type wallet struct{ id, network, descriptor string }
+	var wallets []wallet
+	rows, err := tx.Query(`SELECT id, network, descriptor FROM wallets WHERE descriptor != ''`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var w wallet
+		if err := rows.Scan(&w.id, &w.network, &w.descriptor); err != nil {
+			rows.Close()
+			return err
+		}
+		wallets = append(wallets, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, w := range wallets {
+		id := descriptorWalletID(w.network, w.descriptor)
+		for _, table := range []string{"wallets", "labels", "registered_wallets"} {
+			column := "wallet_id"
+			if table == "wallets" {
+				column = "id"
+			}
+			if _, err := tx.Exec(`UPDATE `+table+` SET `+column+` = ? WHERE `+column+` = ?`, id, w.id); err != nil {
+				return err
+			}
+		}
+	}
+
+	type registration struct{ tenant, name, spec string }
+	var registrations []registration
+	rows, err = tx.Query(`SELECT tenant, name, spec FROM registered_wallets`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var r registration
+		if err := rows.Scan(&r.tenant, &r.name, &r.spec); err != nil {
+			rows.Close()
+			return err
+		}
+		registrations = append(registrations, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range registrations {
+		if keychainEntryOf(r.spec) != nil {
+			continue
+		}
+		var spec WalletSpec
+		if json.Unmarshal([]byte(r.spec), &spec) != nil {
+			continue
+		}
+		descriptor, err := walletDescriptor(&spec, false)
+		if err != nil {
+			continue
+		}
+		id := descriptorWalletID(spec.Network, descriptor)
+		if _, err := tx.Exec(
+			`INSERT INTO wallets (id, name, network, descriptor, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING`,
+			id, spec.Name, spec.Network, descriptor, time.Now().Unix(),
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE registered_wallets SET wallet_id = ? WHERE tenant = ? AND name = ?`, id, r.tenant, r.name); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{"addresses", "checkpoints", "scan_progress", "derivation_cache"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropDerivationCache is schema version 2, which keys the derivation cache
+// by engine and curve backend as well. Entries cached under a bare wallet
+// ID cannot say which engine derived them, so they are dropped.
+func dropDerivationCache(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM derivation_cache`)
+	return err
+}
+
+// openConfiguredStore opens the store named by the global --store flag. It
+// returns nil without error when no store is configured.
+func openConfiguredStore() (*walletStore, error) {
+	if options.storePath == "" {
+		return nil, nil
+	}
+	return openStore(options.storePath)
+}
+
+func (s *walletStore) Close() error {
+	return s.db.Close()
+}
+
+// walletID identifies a wallet in the store by its network and receive
+// descriptor, so the same wallet imported from different formats shares its
+// state. The network is needed because Liquid keys and descriptors are
+// written as Bitcoin's are.
+func walletID(spec *WalletSpec) (string, error) {
+	descriptor, err := walletDescriptor(spec, false)
+	if err != nil {
+		return "", err
+	}
+	return descriptorWalletID(spec.Network, descriptor), nil
+}
+
+func descriptorWalletID(network, descriptor string) string {
+	sum := sha256.Sum256([]byte(network + "\x00" + descriptor))
+	return hex.EncodeToString(sum[:16])
+}
+
+// registerWallet adds the wallet to the store if it is not already there
+// and returns its ID.
+func (s *walletStore) registerWallet(spec *WalletSpec) (string, error) {
+	id, err := walletID(spec)
+	if err != nil {
+		return "", err
+	}
+	descriptor, err := walletDescriptor(spec, false)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO wallets (id, name, network, descriptor, created_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET name = CASE WHEN excluded.name != '' THEN excluded.name ELSE name END`,
+		id, spec.Name, spec.Network, descriptor, time.Now().Unix(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to register wallet: %v", err)
+	}
+	return id, nil
+}
+
+// recordAddresses stores derived addresses for one chain. Existing rows
+// keep their used flag.
+func (s *walletStore) recordAddresses(id string, change bool, addresses []DerivedAddress) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, a := range addresses {
+		if _, err := tx.Exec(
+			`INSERT INTO addresses (wallet_id, change, idx, address) VALUES (?, ?, ?, ?)
+			 ON CONFLICT(wallet_id, change, idx) DO NOTHING`,
+			id, change, a.Index, a.Address,
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record address: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// markUsed records that an address has on-chain history.
+func (s *walletStore) markUsed(id string, change bool, index uint32, address string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO addresses (wallet_id, change, idx, address, used) VALUES (?, ?, ?, ?, 1)
+		 ON CONFLICT(wallet_id, change, idx) DO UPDATE SET used = 1`,
+		id, change, index, address,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark address used: %v", err)
+	}
+	return nil
+}
+
+// usedIndices returns the indices on a chain known to have been used.
+func (s *walletStore) usedIndices(id string, change bool) (map[uint32]bool, error) {
+	rows, err := s.db.Query(`SELECT idx FROM addresses WHERE wallet_id = ? AND change = ? AND used = 1`, id, change)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	used := map[uint32]bool{}
+	for rows.Next() {
+		var index uint32
+		if err := rows.Scan(&index); err != nil {
+			return nil, err
+		}
+		used[index] = true
+	}
+	return used, rows.Err()
+}
+
+func (s *walletStore) setLabel(id, address, label string) error {
+	var err error
+	if label == "" {
+		_, err = s.db.Exec(`DELETE FROM labels WHERE wallet_id = ? AND address = ?`, id, address)
+	} else {
+		_, err = s.db.Exec(
+			`INSERT INTO labels (wallet_id, address, label) VALUES (?, ?, ?)
+			 ON CONFLICT(wallet_id, address) DO UPDATE SET label = excluded.label`,
+			id, address, label,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set label: %v", err)
+	}
+	return nil
+}
+
+func (s *walletStore) labels(id string) (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT address, label FROM labels WHERE wallet_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	labels := map[string]string{}
+	for rows.Next() {
+		var address, label string
+		if err := rows.Scan(&address, &label); err != nil {
+			return nil, err
+		}
+		labels[address] = label
+	}
+	return labels, rows.Err()
+}
+
+// checkpoint returns the saved scan position for a chain, or nil if the
+// chain has never been scanned.
+func (s *walletStore) checkpoint(id string, change bool) (*scanCheckpoint, error) {
+	var cp scanCheckpoint
+	var updated int64
+	err := s.db.QueryRow(
+		`SELECT next_index, tip_height, updated_at FROM checkpoints WHERE wallet_id = ? AND change = ?`,
+		id, change,
+	).Scan(&cp.NextIndex, &cp.TipHeight, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	cp.UpdatedAt = time.Unix(updated, 0).UTC()
+	return &cp, nil
+}
+
+func (s *walletStore) saveCheckpoint(id string, change bool, cp scanCheckpoint) error {
+	_, err := s.db.Exec(
+		`INSERT INTO checkpoints (wallet_id, change, next_index, tip_height, updated_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(wallet_id, change) DO UPDATE SET
+			next_index = excluded.next_index, tip_height = excluded.tip_height, updated_at = excluded.updated_at`,
+		id, change, cp.NextIndex, cp.TipHeight, time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	return nil
+}
+
+// progress returns the saved position of an unfinished scan, or nil if
+// there is none.
+func (s *walletStore) progress(id, kind string, change bool) (*scanProgress, error) {
+	var p scanProgress
+	var updated int64
+	err := s.db.QueryRow(
+		`SELECT backend, tip_height, next_index, state, updated_at FROM scan_progress
+		 WHERE wallet_id = ? AND kind = ? AND change = ?`,
+		id, kind, change,
+	).Scan(&p.Backend, &p.TipHeight, &p.NextIndex, &p.State, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scan progress: %v", err)
+	}
+	p.UpdatedAt = time.Unix(updated, 0).UTC()
+	return &p, nil
+}
+
+func (s *walletStore) saveProgress(id, kind string, change bool, p scanProgress) error {
+	_, err := s.db.Exec(
+		`INSERT INTO scan_progress (wallet_id, kind, change, backend, tip_height, next_index, state, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(wallet_id, kind, change) DO UPDATE SET
+			backend = excluded.backend, tip_height = excluded.tip_height, next_index = excluded.next_index,
+			state = excluded.state, updated_at = excluded.updated_at`,
+		id, kind, change, p.Backend, p.TipHeight, p.NextIndex, p.State, time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save scan progress: %v", err)
+	}
+	return nil
+}
+
+// clearProgress forgets the position of a scan that has completed.
+func (s *walletStore) clearProgress(id, kind string, change bool) error {
+	_, err := s.db.Exec(`DELETE FROM scan_progress WHERE wallet_id = ? AND kind = ? AND change = ?`, id, kind, change)
+	if err != nil {
+		return fmt.Errorf("failed to clear scan progress: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// A migration ends with a transaction moving the old wallet's coins to the
+// new one. sweep-psbt builds it unsigned, as a PSBT for the old wallet's
+// signers: every unspent output the backend reports, to the new wallet's
+// next unused receive address, less a fee at --feerate or, without it, at
+// the backend's estimate for --fee-target and --fee-mode. With --amount it
+// sends that much instead, a test payment before the real sweep, taking
+// the largest outputs first and returning the rest to the old wallet's
+// next unused change address. Each input carries its keys' origins and
+// scripts, re-derived from the spec and checked against the address the
+// backend reported, and the previous transaction when the backend can
+// fetch it, which legacy inputs require. Inputs and outputs are in BIP-69
+// order, and the lock time is the tip height, as Bitcoin Core sets it
+// against fee sniping.
+
+// SweepReport describes a sweep PSBT.
+type SweepReport struct {
+	Network string          `json:"network"`
+	Backend string          `json:"backend"`
+	From    MigrationWallet `json:"from"`
+	To      MigrationWallet `json:"to"`
+	// FeeRate is the rate asked for, in sat/vB. The fee is computed from
+	// an estimate of the signed size that never falls short, so the rate
+	// paid is at least this.
+	FeeRate     float64        `json:"fee_rate"`
+	Inputs      []SweepInput   `json:"inputs"`
+	Skipped     []SweepSkipped `json:"skipped,omitempty"`
+	Outputs     []SweepOutput  `json:"outputs"`
+	InputTotal  int64          `json:"input_total"`
+	OutputTotal int64          `json:"output_total"`
+	Fee         int64          `json:"fee"`
+	Weight      int            `json:"weight"`
+	VSize       int            `json:"vsize"`
+	// DustToFee is change too small to relay, left to the fee instead.
+	DustToFee int64  `json:"dust_to_fee,omitempty"`
+	LockTime  uint32 `json:"lock_time"`
+	// PSBT is the unsigned PSBT, base64-encoded.
+	PSBT string `json:"psbt"`
+	// File is where --out wrote the PSBT in binary.
+	File string `json:"file,omitempty"`
+	// FeeEstimate is where the rate came from without --feerate.
+	FeeEstimate *FeeRateEstimate `json:"fee_estimate,omitempty"`
+}
+
+// SweepInput is an output of the old wallet the sweep spends.
+type SweepInput struct {
+	Outpoint      string `json:"outpoint"`
+	Address       string `json:"address"`
+	Value         int64  `json:"value"`
+	Change        bool   `json:"change"`
+	Index         uint32 `json:"index"`
+	Confirmations int64  `json:"confirmations"`
+	// PreviousTx is whether the input carries its whole previous
+	// transaction, as signers require for legacy inputs.
+	PreviousTx bool `json:"previous_tx"`
+}
+
+// SweepSkipped is an unspent output the sweep leaves alone.
+type SweepSkipped struct {
+	Outpoint string `json:"outpoint"`
+	Value    int64  `json:"value"`
+	// Reason is "unconfirmed" (below --min-confirmations), "uneconomic"
+	// (worth no more than the fee to spend it) or "unselected" (not needed
+	// for --amount).
+	Reason string `json:"reason"`
+}
+
+// SweepOutput is an output of the sweep.
+type SweepOutput struct {
+	Address string `json:"address"`
+	Value   int64  `json:"value"`
+	// Role is "destination", an address of the new wallet, or "change",
+	// one of the old wallet's.
+	Role  string `json:"role"`
+	Index uint32 `json:"index"`
+}
+
+// sweepRequest is a parsed sweep-psbt command line.
+type sweepRequest struct {
+	fee               feeChoice
+	amount            int64 // 0 sweeps everything
+	minConfirmations  int64
+	includeUneconomic bool
+	r                 *indexRange
+	gap               int
+}
+
+// sweepOutput is an output under construction.
+type sweepOutput struct {
+	SweepOutput
+	script []byte
+	// change is the old wallet's change output, which signers are told
+	// is theirs.
+	change *spendInfo
+}
+
+// buildSweep builds the PSBT moving from's unspent outputs to to. It
+// returns the report and the binary PSBT.
+func buildSweep(from, to *WalletSpec, backend ChainBackend, store *walletStore, req *sweepRequest) (*SweepReport, []byte, error) {
+	if from.Network != to.Network {
+		return nil, nil, errorWithCode(errInvalidNetwork, "the wallets are on different networks: %s and %s", from.Network, to.Network)
+	}
+	size, err := walletInputSize(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	feeRate, estimate, err := req.fee.resolve(backend, from.Network)
+	if err != nil {
+		return nil, nil, err
+	}
+	utxos, err := listWalletUTXOs(from, backend, store, req.r, req.gap, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &SweepReport{
+		Network:     from.Network,
+		Backend:     backend.Name(),
+		From:        migrationWallet(from),
+		To:          migrationWallet(to),
+		FeeRate:     float64(feeRate) / 1000,
+		FeeEstimate: estimate,
+		Inputs:      []SweepInput{},
+		LockTime:    uint32(utxos.TipHeight),
+	}
+
+	var eligible []WalletUTXO
+	inputFee := feeForWeight(feeRate, size.weight())
+	for _, u := range utxos.UTXOs {
+		switch {
+		case u.Confirmations < req.minConfirmations:
+			report.Skipped = append(report.Skipped, SweepSkipped{Outpoint: u.Outpoint, Value: u.Value, Reason: "unconfirmed"})
+		case u.Value <= inputFee && !req.includeUneconomic:
+			report.Skipped = append(report.Skipped, SweepSkipped{Outpoint: u.Outpoint, Value: u.Value, Reason: "uneconomic"})
+		default:
+			eligible = append(eligible, u)
+		}
+	}
+	if len(eligible) == 0 {
+		return nil, nil, fmt.Errorf("the wallet has no spendable outputs (%d found, %d skipped)", len(utxos.UTXOs), len(report.Skipped))
+	}
+
+	next, err := nextUnusedAddress(to, backend, store, req.gap)
+	if err != nil {
+		return nil, nil, err
+	}
+	destScript, err := addressScript(next.Address, to.Network)
+	if err != nil {
+		return nil, nil, err
+	}
+	dest := &sweepOutput{SweepOutput: SweepOutput{Address: next.Address, Role: "destination", Index: next.Index}, script: destScript}
+
+	var selected []WalletUTXO
+	outputs := []*sweepOutput{dest}
+	if req.amount == 0 {
+		selected = eligible
+		for _, u := range selected {
+			report.InputTotal += u.Value
+		}
+		report.Weight = txWeight(repeatInputSize(size, len(selected)), [][]byte{destScript})
+		report.Fee = feeForWeight(feeRate, report.Weight)
+		dest.Value = report.InputTotal - report.Fee
+		if dest.Value < dustThreshold(destScript) {
+			return nil, nil, fmt.Errorf("the wallet's %d sats do not cover the %d sat fee with a relayable output left", report.InputTotal, report.Fee)
+		}
+	} else {
+		if req.amount < dustThreshold(destScript) {
+			return nil, nil, fmt.Errorf("--amount %d is below the dust threshold of %d sats", req.amount, dustThreshold(destScript))
+		}
+		change, err := sweepChangeOutput(from, backend, store, req.gap)
+		if err != nil {
+			return nil, nil, err
+		}
+		dest.Value = req.amount
+		sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].Value > eligible[j].Value })
+		funded := false
+		for _, u := range eligible {
+			selected = append(selected, u)
+			report.InputTotal += u.Value
+			report.Weight = txWeight(repeatInputSize(size, len(selected)), [][]byte{destScript, change.script})
+			report.Fee = feeForWeight(feeRate, report.Weight)
+			if report.InputTotal < req.amount+report.Fee {
+				continue
+			}
+			change.Value = report.InputTotal - req.amount - report.Fee
+			if change.Value < dustThreshold(change.script) {
+				report.Weight = txWeight(repeatInputSize(size, len(selected)), [][]byte{destScript})
+				report.DustToFee = change.Value
+				report.Fee += change.Value
+			} else {
+				outputs = append(outputs, change)
+			}
+			funded = true
+			break
+		}
+		if !funded {
+			return nil, nil, fmt.Errorf("insufficient funds: %d sats spendable, %d needed for %d plus the %d sat fee",
+				report.InputTotal, req.amount+report.Fee, req.amount, report.Fee)
+		}
+		for _, u := range eligible[len(selected):] {
+			report.Skipped = append(report.Skipped, SweepSkipped{Outpoint: u.Outpoint, Value: u.Value, Reason: "unselected"})
+		}
+	}
+	report.VSize = vsize(report.Weight)
+
+	// BIP-69: inputs by txid as displayed, then index; outputs by value,
+	// then script.
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].TxID != selected[j].TxID {
+			return selected[i].TxID < selected[j].TxID
+		}
+		return selected[i].Vout < selected[j].Vout
+	})
+	sort.SliceStable(outputs, func(i, j int) bool {
+		if outputs[i].Value != outputs[j].Value {
+			return outputs[i].Value < outputs[j].Value
+		}
+		return bytes.Compare(outputs[i].script, outputs[j].script) < 0
+	})
+
+	packet := &psbtPacket{tx: wire.NewMsgTx(2)}
+	packet.tx.LockTime = report.LockTime
+	if packet.global, err = psbtGlobalXpubs(from); err != nil {
+		return nil, nil, err
+	}
+	missingPrevious := 0
+	for _, u := range selected {
+		m, previous, err := sweepInput(from, backend, u, size.witness > 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !previous && from.ScriptType != "taproot" {
+			missingPrevious++
+		}
+		hash, err := chainhash.NewHashFromStr(u.TxID)
+		if err != nil {
+			return nil, nil, err
+		}
+		in := wire.NewTxIn(wire.NewOutPoint(hash, u.Vout), nil, nil)
+		in.Sequence = wire.MaxTxInSequenceNum - 2
+		packet.tx.AddTxIn(in)
+		packet.inputs = append(packet.inputs, m)
+		report.Inputs = append(report.Inputs, SweepInput{
+			Outpoint:      u.Outpoint,
+			Address:       u.Address,
+			Value:         u.Value,
+			Change:        u.Change,
+			Index:         u.Index,
+			Confirmations: u.Confirmations,
+			PreviousTx:    previous,
+		})
+	}
+	if missingPrevious > 0 {
+		diagnostic("warning: %s cannot fetch transactions, so %d segwit inputs lack their previous transaction, which some signers require", backend.Name(), missingPrevious)
+	}
+	for _, out := range outputs {
+		packet.tx.AddTxOut(wire.NewTxOut(out.Value, out.script))
+		var m psbtMap
+		if out.change != nil {
+			out.change.addOutput(&m)
+		}
+		packet.outputs = append(packet.outputs, m)
+		report.Outputs = append(report.Outputs, out.SweepOutput)
+		report.OutputTotal += out.Value
+	}
+
+	raw, err := packet.serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	report.PSBT = base64.StdEncoding.EncodeToString(raw)
+	return report, raw, nil
+}
+
+// sweepInput builds the PSBT input map spending u, and reports whether it
+// holds the previous transaction.
+func sweepInput(spec *WalletSpec, backend ChainBackend, u WalletUTXO, segwit bool) (psbtMap, bool, error) {
+	info, err := walletSpendInfo(spec, u.Change, u.Index)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.address != u.Address {
+		return nil, false, fmt.Errorf("%s derives %s at %s, not %s", spec.ScriptType, info.address, childPath("", u.Change, u.Index), u.Address)
+	}
+	script, err := hex.DecodeString(u.ScriptPubKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var m psbtMap
+	previous := false
+	// Taproot signatures commit to every input's value and script, so
+	// signers do not need the previous transactions.
+	if spec.ScriptType != "taproot" {
+		tx, err := fetchTransaction(backend, u.TxID)
+		switch {
+		case errors.Is(err, errNoTransactions) && segwit:
+		case errors.Is(err, errNoTransactions):
+			return nil, false, fmt.Errorf("spending %s needs its previous transaction, which %s cannot fetch", u.Outpoint, backend.Name())
+		case err != nil:
+			return nil, false, err
+		default:
+			if int(u.Vout) >= len(tx.TxOut) {
+				return nil, false, fmt.Errorf("transaction %s has no output %d", u.TxID, u.Vout)
+			}
+			out := tx.TxOut[u.Vout]
+			if out.Value != u.Value || !bytes.Equal(out.PkScript, script) {
+				return nil, false, fmt.Errorf("%s reported %s as %d sats to %s, but the transaction pays %d sats to %x",
+					backend.Name(), u.Outpoint, u.Value, u.ScriptPubKey, out.Value, out.PkScript)
+			}
+			var b bytes.Buffer
+			if err := tx.SerializeNoWitness(&b); err != nil {
+				return nil, false, err
+			}
+			m.add(psbtInNonWitnessUTXO, nil, b.Bytes())
+			previous = true
+		}
+	}
+	if segwit {
+		m.add(psbtInWitnessUTXO, nil, witnessUTXO(u.Value, script))
+	}
+	info.addInput(&m)
+	return m, previous, nil
+}
+
+// parseAmount parses an amount in sats.
+func parseAmount(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 1 || n > btcutil.MaxSatoshi {
+		return 0, fmt.Errorf("invalid amount: %q (want sats)", s)
+	}
+	return n, nil
+}
+
+// sweepChangeOutput returns the old wallet's next unused change address as
+// an output, with no value yet.
+func sweepChangeOutput(spec *WalletSpec, backend ChainBackend, store *walletStore, gap int) (*sweepOutput, error) {
+	scan, err := scanChain(spec, backend, store, true, gap)
+	if err != nil {
+		return nil, err
+	}
+	next := scan.firstUnused()
+	info, err := walletSpendInfo(spec, true, next.Index)
+	if err != nil {
+		return nil, err
+	}
+	script, err := addressScript(next.Address, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	return &sweepOutput{SweepOutput: SweepOutput{Address: next.Address, Role: "change", Index: next.Index}, script: script, change: info}, nil
+}
+
+// repeatInputSize is n inputs of the same size.
+func repeatInputSize(size inputSize, n int) []inputSize {
+	sizes := make([]inputSize, n)
+	for i := range sizes {
+		sizes[i] = size
+	}
+	return sizes
+}
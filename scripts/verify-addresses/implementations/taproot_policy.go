@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// TaprootPolicy is a tr() output beyond a single BIP-86 key: a MuSig2
+// aggregate internal key (BIP-390 musig()) and/or a script tree of
+// fallback spending paths. Keys refer to the wallet spec's Keys by index.
+type TaprootPolicy struct {
+	Internal TapKey   `json:"internal"`
+	Tree     *TapTree `json:"tree,omitempty"`
+}
+
+// TapKey is a key expression in a taproot policy: one key, or the musig()
+// aggregate of several. With AggregatePath the wallet's /<chain>/* steps
+// are applied to the aggregate (musig(A,B)/0/*, via the BIP-328 synthetic
+// xpub) instead of to each participant (musig(A/0/*,B/0/*)).
+type TapKey struct {
+	Keys          []int `json:"keys"`
+	MuSig         bool  `json:"musig,omitempty"`
+	AggregatePath bool  `json:"aggregate_path,omitempty"`
+}
+
+// TapTree is a script tree node: either a leaf or a pair of branches.
+type TapTree struct {
+	Leaf  *TapLeaf `json:"leaf,omitempty"`
+	Left  *TapTree `json:"left,omitempty"`
+	Right *TapTree `json:"right,omitempty"`
+}
+
+// TapLeaf is a tapscript leaf: pk(KEY), or multi_a/sortedmulti_a(k, KEY...).
+type TapLeaf struct {
+	Script    string   `json:"script"`
+	Threshold int      `json:"threshold,omitempty"`
+	Keys      []TapKey `json:"keys"`
+}
+
+// musigChainCode is the chain code BIP-328 assigns to the synthetic xpub of
+// a MuSig2 aggregate key, SHA256("MuSig2MuSig2MuSig2").
+var musigChainCode = hexToBytes("868087ca02a6f974c4598924c36b57762d32cb45717167e300622c7167e38965")
+
+// musigAggregate computes the BIP-327 KeyAgg aggregate of the participant
+// keys, sorted with KeySort as BIP-390 requires.
+func musigAggregate(keys []*btcec.PublicKey) (*btcec.PublicKey, error) {
+	agg, _, _, err := musig2.AggregateKeys(keys, true)
+	if err != nil {
+		return nil, fmt.Errorf("musig key aggregation failed: %v", err)
+	}
+	return agg.PreTweakedKey, nil
+}
+
+func (p *TaprootPolicy) validate(spec *WalletSpec) error {
+	if err := p.Internal.validate(spec); err != nil {
+		return err
+	}
+	if p.Tree != nil {
+		return p.Tree.validate(spec)
+	}
+	return nil
+}
+
+func (k TapKey) validate(spec *WalletSpec) error {
+	if len(k.Keys) == 0 || (!k.MuSig && len(k.Keys) != 1) {
+		return fmt.Errorf("taproot key expression needs one key, or musig() of several")
+	}
+	if k.AggregatePath && !k.MuSig {
+		return fmt.Errorf("aggregate derivation only applies to musig()")
+	}
+	for _, i := range k.Keys {
+		if i < 0 || i >= len(spec.Keys) {
+			return fmt.Errorf("taproot key index %d out of range", i)
+		}
+	}
+	return nil
+}
+
+func (t *TapTree) validate(spec *WalletSpec) error {
+	if t.Leaf != nil {
+		if t.Left != nil || t.Right != nil {
+			return fmt.Errorf("tap tree node is both a leaf and a branch")
+		}
+		return t.Leaf.validate(spec)
+	}
+	if t.Left == nil || t.Right == nil {
+		return fmt.Errorf("tap tree branch needs two children")
+	}
+	if err := t.Left.validate(spec); err != nil {
+		return err
+	}
+	return t.Right.validate(spec)
+}
+
+func (l *TapLeaf) validate(spec *WalletSpec) error {
+	switch l.Script {
+	case "pk":
+		if len(l.Keys) != 1 {
+			return fmt.Errorf("pk() takes exactly one key")
+		}
+	case "multi_a", "sortedmulti_a":
+		if l.Threshold < 1 || l.Threshold > len(l.Keys) {
+			return fmt.Errorf("invalid %s threshold %d for %d keys", l.Script, l.Threshold, len(l.Keys))
+		}
+	default:
+		return fmt.Errorf("unsupported tapscript leaf: %s", l.Script)
+	}
+	for _, k := range l.Keys {
+		if err := k.validate(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derive returns the child public key of a key expression at change/index.
+func (k TapKey) derive(spec *WalletSpec, change bool, index uint32) (*btcec.PublicKey, error) {
+	if !k.MuSig {
+		return deriveChildKey(spec.Keys[k.Keys[0]].Xpub, index, change, spec.Network)
+	}
+
+	keys := make([]*btcec.PublicKey, len(k.Keys))
+	for i, ki := range k.Keys {
+		var err error
+		if k.AggregatePath {
+			keys[i], err = accountPublicKey(spec.Keys[ki].Xpub, spec.Network)
+		} else {
+			keys[i], err = deriveChildKey(spec.Keys[ki].Xpub, index, change, spec.Network)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	agg, err := musigAggregate(keys)
+	if err != nil || !k.AggregatePath {
+		return agg, err
+	}
+
+	net := getNetwork(spec.Network)
+	xpub := hdkeychain.NewExtendedKey(net.HDPublicKeyID[:], agg.SerializeCompressed(), musigChainCode, []byte{0, 0, 0, 0}, 0, 0, false)
+	return deriveChainIndex(xpub, index, change)
+}
+
+// accountPublicKey returns the public key of the extended key itself.
+func accountPublicKey(xpub, network string) (*btcec.PublicKey, error) {
+	extKey, err := hdkeychain.NewKeyFromString(convertToStandardXpub(xpub, network))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse xpub: %v", err)
+	}
+	return extKey.ECPubKey()
+}
+
+// script builds the leaf's tapscript for the child keys at change/index.
+func (l *TapLeaf) script(spec *WalletSpec, change bool, index uint32) ([]byte, error) {
+	keys := make([][]byte, len(l.Keys))
+	for i, k := range l.Keys {
+		pk, err := k.derive(spec, change, index)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = schnorr.SerializePubKey(pk)
+	}
+	if l.Script == "sortedmulti_a" {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	}
+
+	return buildScript(func(builder *txscript.ScriptBuilder) {
+		if l.Script == "pk" {
+			builder.AddData(keys[0]).AddOp(txscript.OP_CHECKSIG)
+			return
+		}
+		for i, key := range keys {
+			builder.AddData(key)
+			if i == 0 {
+				builder.AddOp(txscript.OP_CHECKSIG)
+			} else {
+				builder.AddOp(txscript.OP_CHECKSIGADD)
+			}
+		}
+		builder.AddInt64(int64(l.Threshold)).AddOp(txscript.OP_NUMEQUAL)
+	})
+}
+
+// merkleRoot hashes the tree in the shape the descriptor gives it.
+func (t *TapTree) merkleRoot(spec *WalletSpec, change bool, index uint32) (txscript.TapNode, error) {
+	if t.Leaf != nil {
+		script, err := t.Leaf.script(spec, change, index)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.NewBaseTapLeaf(script), nil
+	}
+	left, err := t.Left.merkleRoot(spec, change, index)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.Right.merkleRoot(spec, change, index)
+	if err != nil {
+		return nil, err
+	}
+	return txscript.NewTapBranch(left, right), nil
+}
+
+func (p *TaprootPolicy) address(spec *WalletSpec, change bool, index uint32) (string, error) {
+	internal, err := p.Internal.derive(spec, change, index)
+	if err != nil {
+		return "", err
+	}
+
+	outputKey := txscript.ComputeTaprootKeyNoScript(internal)
+	if p.Tree != nil {
+		root, err := p.Tree.merkleRoot(spec, change, index)
+		if err != nil {
+			return "", err
+		}
+		hash := root.TapHash()
+		outputKey = txscript.ComputeTaprootOutputKey(internal, hash[:])
+	}
+
+	addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), getNetwork(spec.Network))
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// isTaprootPolicyDescriptor reports whether a tr() descriptor needs the
+// policy parser rather than the single-key taproot template.
+func isTaprootPolicyDescriptor(desc string) bool {
+	return strings.HasPrefix(desc, "tr(") && (strings.Contains(desc, "musig(") || len(splitTopLevel(desc[3:len(desc)-1])) > 1)
+}
+
+// parseTaprootPolicy parses "tr(KEY)" or "tr(KEY,TREE)" where keys may be
+// musig() expressions and TREE is a leaf or a {TREE,TREE} pair.
+func parseTaprootPolicy(desc string) (*WalletSpec, error) {
+	if !strings.HasPrefix(desc, "tr(") || !strings.HasSuffix(desc, ")") {
+		return nil, fmt.Errorf("unsupported descriptor: %s", desc)
+	}
+	args := splitTopLevel(desc[3 : len(desc)-1])
+	if len(args) > 2 {
+		return nil, fmt.Errorf("tr() takes a key and at most one script tree")
+	}
+
+	p := &policyParser{spec: &WalletSpec{ScriptType: "taproot"}}
+	internal, err := p.key(args[0])
+	if err != nil {
+		return nil, err
+	}
+	policy := &TaprootPolicy{Internal: internal}
+	if len(args) == 2 {
+		if policy.Tree, err = p.tree(args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	spec := p.spec
+	spec.Taproot = policy
+	spec.Network = networkFromXpub(spec.Keys[0].Xpub)
+	return spec, nil
+}
+
+type policyParser struct {
+	spec *WalletSpec
+}
+
+// keyIndex adds a key to the spec, reusing the entry for a repeated xpub.
+func (p *policyParser) keyIndex(key WalletKey) int {
+	for i, k := range p.spec.Keys {
+		if k.Xpub == key.Xpub {
+			return i
+		}
+	}
+	p.spec.Keys = append(p.spec.Keys, key)
+	return len(p.spec.Keys) - 1
+}
+
+func (p *policyParser) key(expr string) (TapKey, error) {
+	if !strings.HasPrefix(expr, "musig(") {
+		key, err := parseDescriptorKey(expr)
+		if err != nil {
+			return TapKey{}, err
+		}
+		return TapKey{Keys: []int{p.keyIndex(key)}}, nil
+	}
+
+	end := matchingParen(expr, len("musig"))
+	if end < 0 {
+		return TapKey{}, fmt.Errorf("unterminated musig(): %s", expr)
+	}
+	tk := TapKey{MuSig: true}
+	switch derivation := strings.TrimPrefix(expr[end+1:], "/"); derivation {
+	case "":
+	case "0/*", "1/*", "<0;1>/*":
+		tk.AggregatePath = true
+	default:
+		return TapKey{}, fmt.Errorf("unsupported musig() derivation /%s (expected /0/*, /1/* or /<0;1>/*)", derivation)
+	}
+
+	for _, arg := range splitTopLevel(expr[len("musig("):end]) {
+		var key WalletKey
+		var err error
+		if tk.AggregatePath {
+			var derivation string
+			key, derivation, err = parseKeyOrigin(arg)
+			if err == nil && derivation != "" {
+				err = fmt.Errorf("musig() participants cannot have derivation steps when the aggregate is derived: %s", arg)
+			}
+		} else {
+			key, err = parseDescriptorKey(arg)
+		}
+		if err != nil {
+			return TapKey{}, err
+		}
+		tk.Keys = append(tk.Keys, p.keyIndex(key))
+	}
+	if len(tk.Keys) < 2 {
+		return TapKey{}, fmt.Errorf("musig() needs at least two keys")
+	}
+	return tk, nil
+}
+
+func (p *policyParser) tree(expr string) (*TapTree, error) {
+	if strings.HasPrefix(expr, "{") {
+		if !strings.HasSuffix(expr, "}") {
+			return nil, fmt.Errorf("unterminated script tree: %s", expr)
+		}
+		branches := splitTopLevel(expr[1 : len(expr)-1])
+		if len(branches) != 2 {
+			return nil, fmt.Errorf("script tree branch needs two children: %s", expr)
+		}
+		left, err := p.tree(branches[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.tree(branches[1])
+		if err != nil {
+			return nil, err
+		}
+		return &TapTree{Left: left, Right: right}, nil
+	}
+
+	open := strings.Index(expr, "(")
+	if open < 0 || !strings.HasSuffix(expr, ")") {
+		return nil, fmt.Errorf("invalid tapscript leaf: %s", expr)
+	}
+	leaf := &TapLeaf{Script: expr[:open]}
+	args := splitTopLevel(expr[open+1 : len(expr)-1])
+	switch leaf.Script {
+	case "pk":
+	case "multi_a", "sortedmulti_a":
+		threshold, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s threshold: %q", leaf.Script, args[0])
+		}
+		leaf.Threshold = threshold
+		args = args[1:]
+	default:
+		return nil, fmt.Errorf("unsupported tapscript leaf: %s", leaf.Script)
+	}
+	for _, arg := range args {
+		key, err := p.key(arg)
+		if err != nil {
+			return nil, err
+		}
+		leaf.Keys = append(leaf.Keys, key)
+	}
+	return &TapTree{Leaf: leaf}, leaf.validate(p.spec)
+}
+
+// taprootPolicyDescriptor renders the policy for one chain.
+func taprootPolicyDescriptor(spec *WalletSpec, chain string) (string, error) {
+	desc := "tr(" + renderTapKey(spec, spec.Taproot.Internal, chain)
+	if spec.Taproot.Tree != nil {
+		desc += "," + renderTapTree(spec, spec.Taproot.Tree, chain)
+	}
+	return withChecksum(desc + ")")
+}
+
+func renderTapKey(spec *WalletSpec, k TapKey, chain string) string {
+	suffix := "/" + chain + "/*"
+	if !k.MuSig {
+		return descriptorKey(spec.Keys[k.Keys[0]], spec.Network, suffix)
+	}
+	participantSuffix := suffix
+	if k.AggregatePath {
+		participantSuffix = ""
+	}
+	parts := make([]string, len(k.Keys))
+	for i, ki := range k.Keys {
+		parts[i] = descriptorKey(spec.Keys[ki], spec.Network, participantSuffix)
+	}
+	expr := "musig(" + strings.Join(parts, ",") + ")"
+	if k.AggregatePath {
+		expr += suffix
+	}
+	return expr
+}
+
+func renderTapTree(spec *WalletSpec, t *TapTree, chain string) string {
+	if t.Leaf == nil {
+		return "{" + renderTapTree(spec, t.Left, chain) + "," + renderTapTree(spec, t.Right, chain) + "}"
+	}
+	args := make([]string, 0, len(t.Leaf.Keys)+1)
+	if t.Leaf.Script != "pk" {
+		args = append(args, strconv.Itoa(t.Leaf.Threshold))
+	}
+	for _, k := range t.Leaf.Keys {
+		args = append(args, renderTapKey(spec, k, chain))
+	}
+	return t.Leaf.Script + "(" + strings.Join(args, ",") + ")"
+}
+
+// splitTopLevel splits s on commas that are not nested inside (), {}, []
+// or <>.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '{', '[', '<':
+			depth++
+		case ')', '}', ']', '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// matchingParen returns the index of the parenthesis closing the one at
+// open, or -1.
+func matchingParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --trace <url>, commands record OpenTelemetry spans and export them
+// as OTLP/HTTP JSON to the collector at <url>/v1/traces. Spans carry names,
+// timings and counts, never addresses or keys, and the collector is reached
+// directly rather than through --proxy.
+
+// OTLP span kinds.
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// traceFlushSize is how many finished spans are buffered before they are
+// exported mid-run.
+const traceFlushSize = 512
+
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	finish   time.Time
+	attrs    []spanAttr
+	failure  string
+	parent   *span
+
+	// derive is the open span coalescing a run of derivations made under
+	// this one; derivations and crossChecks are its counts.
+	derive      *span
+	derivations int
+	crossChecks int
+}
+
+// tracer holds the span stack of the run. Spans are started and ended in
+// nesting order on the one goroutine that runs commands.
+var tracer struct {
+	current  *span
+	finished []*span
+}
+
+func tracing() bool { return options.trace != "" }
+
+// checkTraceEndpoint validates the --trace collector URL.
+func checkTraceEndpoint(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --trace endpoint %q (want http://host:4318)", raw)
+	}
+	return requireOnline("exporting traces")
+}
+
+// startSpan starts a child of the current span, or the root of a new
+// trace, and makes it current. With tracing off it returns nil, whose
+// methods do nothing.
+func startSpan(name string, kind int, attrs ...spanAttr) *span {
+	if !tracing() {
+		return nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: attrs, parent: tracer.current}
+	if s.parent != nil {
+		s.parent.endDerive()
+		s.traceID, s.parentID = s.parent.traceID, s.parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	tracer.current = s
+	return s
+}
+
+// startRemoteSpan starts a span continuing a caller's W3C traceparent. It
+// only applies to a new root; inside a trace the span is a plain child.
+func startRemoteSpan(name, traceparent string, attrs ...spanAttr) *span {
+	root := tracer.current == nil
+	s := startSpan(name, spanServer, attrs...)
+	if s == nil || !root || traceparent == "" {
+		return s
+	}
+	traceID, parentID, ok := parseTraceparent(traceparent)
+	if !ok {
+		diagnostic("ignoring invalid traceparent %q", traceparent)
+		return s
+	}
+	s.traceID, s.parentID = traceID, parentID
+	return s
+}
+
+// parseTraceparent reads "00-<trace id>-<parent id>-<flags>".
+func parseTraceparent(tp string) (traceID [16]byte, parentID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(tp), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false
+	}
+	return traceID, parentID, true
+}
+
+func (s *span) set(key string, value interface{}) {
+	if s != nil {
+		s.attrs = append(s.attrs, spanAttr{key, value})
+	}
+}
+
+// fail sets the span's status to an error.
+func (s *span) fail(msg string) {
+	if s != nil && s.failure == "" {
+		s.failure = msg
+	}
+}
+
+// end finishes the span, failing it on err, and makes its parent current.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.endDerive()
+	s.finish = time.Now()
+	if err != nil {
+		s.fail(err.Error())
+	}
+	tracer.current = s.parent
+	finishSpan(s)
+}
+
+func finishSpan(s *span) {
+	tracer.finished = append(tracer.finished, s)
+	if len(tracer.finished) >= traceFlushSize {
+		flushTraces()
+	}
+}
+
+// traceDerivation records a derivation that began at start. Consecutive
+// derivations under one span share a single "derive" span with their
+// count, so a range of 100000 addresses is one span, not 100000.
+func traceDerivation(start time.Time) {
+	parent := tracer.current
+	if parent == nil {
+		return
+	}
+	d := parent.derive
+	if d == nil {
+		d = &span{traceID: parent.traceID, parentID: parent.spanID, name: "derive", kind: spanInternal, start: start}
+		rand.Read(d.spanID[:])
+		parent.derive = d
+	}
+	d.derivations++
+	if options.paranoid {
+		d.crossChecks += len(addressEngines) - 1
+	}
+	d.finish = time.Now()
+}
+
+func (s *span) endDerive() {
+	d := s.derive
+	if d == nil {
+		return
+	}
+	s.derive = nil
+	d.set("derive.count", d.derivations)
+	if d.crossChecks > 0 {
+		d.set("derive.cross_checks", d.crossChecks)
+	}
+	finishSpan(d)
+}
+
+// tracingBackend records a client span for every backend call.
+type tracingBackend struct {
+	inner ChainBackend
+}
+
+type tracingBatchBackend struct {
+	*tracingBackend
+}
+
+// traceBackend wraps backend when tracing is on.
+func traceBackend(backend ChainBackend) ChainBackend {
+	if !tracing() {
+		return backend
+	}
+	t := &tracingBackend{inner: backend}
+	if _, ok := backend.(batchBackend); ok {
+		return tracingBatchBackend{t}
+	}
+	return t
+}
+
+func (t *tracingBackend) call(method string, addresses int) *span {
+	s := startSpan(t.inner.Name()+" "+method, spanClient, spanAttr{"backend.name", t.inner.Name()})
+	if addresses > 0 {
+		s.set("backend.addresses", addresses)
+	}
+	return s
+}
+
+func (t *tracingBackend) Name() string { return t.inner.Name() }
+
+func (t *tracingBackend) Close() error { return t.inner.Close() }
+
+func (t *tracingBackend) TipHeight() (int64, error) {
+	s := t.call("TipHeight", 0)
+	tip, err := t.inner.TipHeight()
+	s.set("backend.tip_height", tip)
+	s.end(err)
+	return tip, err
+}
+
+func (t *tracingBackend) AddressHistory(address string) ([]TxRef, error) {
+	s := t.call("AddressHistory", 1)
+	history, err := t.inner.AddressHistory(address)
+	s.end(err)
+	return history, err
+}
+
+func (t *tracingBackend) AddressUTXOs(address string) ([]UTXO, error) {
+	s := t.call("AddressUTXOs", 1)
+	utxos, err := t.inner.AddressUTXOs(address)
+	s.end(err)
+	return utxos, err
+}
+
+func (t *tracingBackend) RawTransaction(txid string) ([]byte, error) {
+	s := t.call("RawTransaction", 0)
+	raw, err := rawTransaction(t.inner, txid)
+	s.end(err)
+	return raw, err
+}
+
+func (t *tracingBackend) BlockHash(height int64) (string, error) {
+	s := t.call("BlockHash", 0)
+	hash, err := backendBlockHash(t.inner, height)
+	s.end(err)
+	return hash, err
+}
+
+func (t *tracingBackend) RawBlock(hash string) ([]byte, error) {
+	s := t.call("RawBlock", 0)
+	raw, err := rawBlock(t.inner, hash)
+	s.end(err)
+	return raw, err
+}
+
+func (t *tracingBackend) FeeRate(target int, mode string) (int64, error) {
+	s := t.call("FeeRate", 0)
+	rate, err := backendFeeRate(t.inner, target, mode)
+	s.end(err)
+	return rate, err
+}
+
+func (t tracingBatchBackend) AddressHistories(addresses []string) ([][]TxRef, error) {
+	s := t.call("AddressHistories", len(addresses))
+	histories, err := t.inner.(batchBackend).AddressHistories(addresses)
+	s.end(err)
+	return histories, err
+}
+
+func (t tracingBatchBackend) AddressUTXOSets(addresses []string) ([][]UTXO, error) {
+	s := t.call("AddressUTXOSets", len(addresses))
+	sets, err := t.inner.(batchBackend).AddressUTXOSets(addresses)
+	s.end(err)
+	return sets, err
+}
+
+// flushTraces exports the finished spans. An export failure is reported
+// on stderr and never fails the command.
+func flushTraces() {
+	spans := tracer.finished
+	tracer.finished = nil
+	if len(spans) == 0 || !tracing() {
+		return
+	}
+	body, err := json.Marshal(otlpTraces(spans))
+	if err == nil {
+		var resp *http.Response
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err = client.Post(strings.TrimSuffix(options.trace, "/")+"/v1/traces", "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("collector answered %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		diagnostic("failed to export %d spans: %v", len(spans), err)
+	}
+}
+
+// otlpTraces renders spans as an OTLP ExportTraceServiceRequest in the
+// protocol's JSON mapping.
+func otlpTraces(spans []*span) map[string]interface{} {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "verify-addresses"
+	}
+	encoded := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		e := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.finish.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			e["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.failure != "" {
+			e["status"] = map[string]interface{}{"code": 2, "message": s.failure}
+		}
+		encoded[i] = e
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes([]spanAttr{{"service.name", service}}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "verify-addresses"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs []spanAttr) []interface{} {
+	encoded := []interface{}{}
+	for _, a := range attrs {
+		var value map[string]interface{}
+		switch v := a.value.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": a.key, "value": value})
+	}
+	return encoded
+}
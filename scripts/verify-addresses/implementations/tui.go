@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// The tui command walks an operator through building a wallet spec: the
+// network, the script type, the policy and each cosigner key, whose
+// fingerprints are shown as soon as it is entered so they can be read back
+// against the devices. It then pages through the addresses. The dialogue
+// is line based, on stderr, so it works on any terminal and can be
+// scripted; the finished spec is the result on stdout.
+
+// tuiPageSize is how many addresses one page shows.
+const tuiPageSize = 10
+
+type tui struct {
+	in   *bufio.Scanner
+	out  io.Writer
+	spec WalletSpec
+}
+
+// errTUIQuit ends the dialogue without a wallet.
+var errTUIQuit = fmt.Errorf("quit before the wallet was complete")
+
+func runTUI(in io.Reader, out io.Writer) (*WalletSpec, error) {
+	t := &tui{in: bufio.NewScanner(in), out: out}
+	t.printf("Wallet setup. Enter q at any prompt to quit.\n")
+	if err := t.chooseNetwork(); err != nil {
+		return nil, err
+	}
+	if err := t.chooseScriptType(); err != nil {
+		return nil, err
+	}
+	if err := t.choosePolicy(); err != nil {
+		return nil, err
+	}
+	if err := t.readKeys(); err != nil {
+		return nil, err
+	}
+	if err := t.spec.validate(); err != nil {
+		return nil, err
+	}
+	if descriptor, err := walletDescriptor(&t.spec, false); err == nil {
+		t.printf("\nReceive descriptor:\n  %s\n", descriptor)
+	}
+	if err := t.page(); err != nil {
+		return nil, err
+	}
+	return &t.spec, nil
+}
+
+func (t *tui) printf(format string, args ...interface{}) {
+	fmt.Fprintf(t.out, format, args...)
+}
+
+// ask prompts for a line. An empty answer returns def.
+func (t *tui) ask(prompt, def string) (string, error) {
+	if def != "" {
+		t.printf("%s [%s]: ", prompt, def)
+	} else {
+		t.printf("%s: ", prompt)
+	}
+	if !t.in.Scan() {
+		t.printf("\n")
+		if err := t.in.Err(); err != nil {
+			return "", err
+		}
+		return "", errTUIQuit
+	}
+	answer := strings.TrimSpace(t.in.Text())
+	if answer == "q" {
+		return "", errTUIQuit
+	}
+	if answer == "" {
+		answer = def
+	}
+	return answer, nil
+}
+
+// choose offers a numbered menu and returns the option picked by number or
+// by name.
+func (t *tui) choose(prompt string, choices []string, def string) (string, error) {
+	for {
+		for i, c := range choices {
+			t.printf("  %d) %s\n", i+1, c)
+		}
+		answer, err := t.ask(prompt, def)
+		if err != nil {
+			return "", err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1], nil
+		}
+		for _, c := range choices {
+			if c == answer {
+				return c, nil
+			}
+		}
+		t.printf("Pick one of the options by number or name.\n")
+	}
+}
+
+func (t *tui) chooseNetwork() error {
+	network, err := t.choose("Network", networkNames, networkNames[0])
+	t.spec.Network = network
+	return err
+}
+
+func (t *tui) chooseScriptType() error {
+	var choices []string
+	for _, name := range append(scriptTypeNames(false), scriptTypeNames(true)...) {
+		if checkNetworkScriptType(t.spec.Network, name) == nil {
+			choices = append(choices, name)
+		}
+	}
+	t.printf("\n")
+	scriptType, err := t.choose("Script type", choices, "native_segwit")
+	t.spec.ScriptType = scriptType
+	return err
+}
+
+func (t *tui) choosePolicy() error {
+	if !t.spec.isMultisig() {
+		t.spec.Keys = make([]WalletKey, 1)
+		return nil
+	}
+	t.printf("\n")
+	for {
+		answer, err := t.ask("Number of cosigners", "3")
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < 1 || n > 20 {
+			t.printf("Enter a number from 1 to 20.\n")
+			continue
+		}
+		t.spec.Keys = make([]WalletKey, n)
+		break
+	}
+	for {
+		answer, err := t.ask("Signatures required", strconv.Itoa(len(t.spec.Keys)/2+1))
+		if err != nil {
+			return err
+		}
+		t.spec.Threshold, err = parseThreshold(answer, len(t.spec.Keys))
+		if err == nil {
+			return nil
+		}
+		t.printf("%v\n", err)
+	}
+}
+
+// readKeys reads each cosigner's account key, showing its fingerprints
+// and warning about keys that do not fit the wallet.
+func (t *tui) readKeys() error {
+	t.printf("\nEnter each account key as an xpub, \"[fingerprint/path]xpub\" or ur:crypto-hdkey.\n")
+	for i := range t.spec.Keys {
+		for {
+			answer, err := t.ask(fmt.Sprintf("Key %d of %d", i+1, len(t.spec.Keys)), "")
+			if err != nil {
+				return err
+			}
+			key, err := t.parseKey(answer, i)
+			if err != nil {
+				t.printf("  %v\n", err)
+				continue
+			}
+			t.spec.Keys[i] = key
+			break
+		}
+	}
+	return nil
+}
+
+func (t *tui) parseKey(answer string, i int) (WalletKey, error) {
+	var key WalletKey
+	if strings.HasPrefix(strings.ToLower(answer), "ur:") {
+		xpub, err := xpubFromInput(answer)
+		if err != nil {
+			return key, err
+		}
+		key.Xpub = xpub
+	} else {
+		var derivation string
+		var err error
+		if key, derivation, err = parseKeyOrigin(answer); err != nil {
+			return key, err
+		}
+		if derivation != "" {
+			return key, fmt.Errorf("give the account key itself, without /%s", derivation)
+		}
+	}
+	info, err := describeXpub(key.Xpub)
+	if err != nil {
+		return key, errorWithCode(errInvalidKey, "invalid extended key: %v", err)
+	}
+	for j, other := range t.spec.Keys[:i] {
+		if other.Xpub == key.Xpub {
+			return key, fmt.Errorf("this is key %d again", j+1)
+		}
+	}
+
+	t.printf("  key fingerprint %s, parent %s, depth %d", info.fingerprint, info.parent, info.depth)
+	if key.Fingerprint != "" {
+		t.printf(", origin [%s%s]", key.Fingerprint, strings.TrimPrefix(key.Path, "m"))
+	}
+	t.printf("\n")
+	if keyNetwork, walletNetwork := networkFromXpub(key.Xpub), baseNetwork(t.spec.Network); keyNetwork != walletNetwork {
+		t.printf("  warning: this is a %s key, but the wallet is on %s\n", keyNetwork, t.spec.Network)
+	}
+	if info.depth == 0 && key.Fingerprint != "" && key.Fingerprint != info.fingerprint {
+		t.printf("  warning: a master key's fingerprint is %s, not %s\n", info.fingerprint, key.Fingerprint)
+	}
+	return key, nil
+}
+
+// xpubInfo is what an extended key says about its place in its tree.
+type xpubInfo struct {
+	depth       int
+	parent      string
+	fingerprint string
+}
+
+func describeXpub(xpub string) (*xpubInfo, error) {
+	b, err := base58CheckDecode(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 78 {
+		return nil, fmt.Errorf("extended key is %d bytes, want 78", len(b))
+	}
+	if _, err := ecDecompress(b[45:78]); err != nil {
+		return nil, err
+	}
+	return &xpubInfo{
+		depth:       int(b[4]),
+		parent:      hex.EncodeToString(b[5:9]),
+		fingerprint: hex.EncodeToString(hash160(b[45:78])[:4]),
+	}, nil
+}
+
+// baseNetwork is the Bitcoin network whose key versions a network uses.
+func baseNetwork(network string) string {
+	if network == "testnet" || network == "liquidtestnet" {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+// page shows the addresses a page at a time until the operator is done.
+func (t *tui) page() error {
+	start, change := uint32(0), false
+	for {
+		chain := "receive"
+		if change {
+			chain = "change"
+		}
+		t.printf("\n%s addresses %d to %d:\n", chain, start, start+tuiPageSize-1)
+		for i := start; i < start+tuiPageSize; i++ {
+			address, err := t.spec.deriveAddress(change, i)
+			if err != nil {
+				return err
+			}
+			path := ""
+			if len(t.spec.Keys) == 1 && t.spec.Keys[0].Path != "" {
+				path = "  " + childPath(t.spec.Keys[0].Path, change, i)
+			}
+			t.printf("  %6d  %s%s\n", i, address, path)
+		}
+		answer, err := t.ask("n next, p previous, c other chain, g <index> go to, d done", "n")
+		if err == errTUIQuit {
+			answer, err = "d", nil
+		}
+		if err != nil {
+			return err
+		}
+		switch cmd, arg, _ := strings.Cut(answer, " "); cmd {
+		case "n":
+			if start+2*tuiPageSize <= hdkeychain.HardenedKeyStart {
+				start += tuiPageSize
+			}
+		case "p":
+			start -= min(start, tuiPageSize)
+		case "c":
+			change = !change
+		case "g":
+			index, err := parseIndex(strings.TrimSpace(arg))
+			if err != nil {
+				t.printf("%v\n", err)
+				continue
+			}
+			start = min(index, hdkeychain.HardenedKeyStart-tuiPageSize)
+		case "d":
+			return nil
+		default:
+			t.printf("Unknown command %q.\n", answer)
+		}
+	}
+}
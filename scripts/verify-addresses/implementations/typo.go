@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// A bech32 checksum is a BCH code: besides detecting a mistyped address it
+// narrows down where the mistake is. When an expected segwit address fails
+// its checksum, the checks that compare it name the characters one or two
+// substitutions away from a valid checksum, so a support call can go
+// straight to them; since the code detects any four errors, such a
+// location is unique. Following BIP-173, the positions are reported, never
+// a corrected address: a "fixed" address with a valid checksum is not
+// necessarily the intended one. Hints are computed from the expected
+// address alone, never from the derived one.
+
+// bech32Constants are the checksum constants of bech32 and bech32m.
+var bech32Constants = map[string]uint32{"bech32": 1, "bech32m": 0x2bc830a3}
+
+// AddressHint locates the likely typos in an address that fails its
+// checksum.
+type AddressHint struct {
+	// Errors is how many substituted characters explain the checksum
+	// failure, or 0 when no one or two substitutions do.
+	Errors int `json:"errors"`
+	// Positions are the suspect characters, counted from 1 at the start of
+	// the address.
+	Positions []int `json:"positions"`
+	// Encodings are the checksum variants the substitutions satisfy.
+	Encodings []string `json:"encodings,omitempty"`
+	Message   string   `json:"message"`
+}
+
+// AddressDecode is an address decoded, or the reason it could not be.
+type AddressDecode struct {
+	Address        string       `json:"address"`
+	Valid          bool         `json:"valid"`
+	Network        string       `json:"network,omitempty"`
+	Type           string       `json:"type,omitempty"`
+	WitnessVersion *int         `json:"witness_version,omitempty"`
+	Program        string       `json:"program,omitempty"`
+	ScriptPubKey   string       `json:"script_pubkey,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	Hint           *AddressHint `json:"hint,omitempty"`
+}
+
+// addressTypoHint returns where an address is most likely mistyped, or nil
+// when it is not a bech32 address of a known network or its checksum is
+// valid.
+func addressTypoHint(address string) *AddressHint {
+	sep := strings.LastIndexByte(address, '1')
+	if sep < 1 || !isSegwitHRP(strings.ToLower(address[:sep])) || len(address)-sep-1 < 6 {
+		return nil
+	}
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return &AddressHint{Positions: []int{}, Message: "the address mixes upper and lower case, which bech32 does not allow"}
+	}
+	lower := strings.ToLower(address)
+	hrp := lower[:sep]
+
+	values := make([]byte, 0, len(lower)-sep-1)
+	var invalid []int
+	for i := sep + 1; i < len(lower); i++ {
+		v := strings.IndexByte(bech32Charset, lower[i])
+		if v < 0 {
+			invalid = append(invalid, i+1)
+			v = 0
+		}
+		values = append(values, byte(v))
+	}
+	if len(invalid) > 0 {
+		return &AddressHint{Errors: len(invalid), Positions: invalid, Message: "characters outside the bech32 alphabet (1, b, i and o are never used)"}
+	}
+
+	expanded := make([]byte, 0, 2*len(hrp)+1+len(values))
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	residue := bech32Polymod(append(expanded, values...))
+	for _, constant := range bech32Constants {
+		if residue == constant {
+			return nil
+		}
+	}
+
+	// The checksum is affine in the data, so a substitution of d at
+	// position p moves the residue by effect[p][d], whatever the other
+	// characters are.
+	zero := bech32Polymod(append(expanded, make([]byte, len(values))...))
+	effect := make([][32]uint32, len(values))
+	for p := range values {
+		data := make([]byte, len(values))
+		for d := 1; d < 32; d++ {
+			data[p] = byte(d)
+			effect[p][d] = bech32Polymod(append(expanded, data...)) ^ zero
+		}
+	}
+
+	hint := &AddressHint{Positions: []int{}}
+	positions := map[int]bool{}
+	for _, name := range []string{"bech32", "bech32m"} {
+		target := residue ^ bech32Constants[name]
+		found := false
+		for p := range values {
+			for d := 1; d < 32; d++ {
+				if effect[p][d] == target {
+					positions[sep+2+p] = true
+					found = true
+				}
+			}
+		}
+		if found {
+			hint.Encodings = append(hint.Encodings, name)
+		}
+	}
+	if len(positions) > 0 {
+		hint.Errors = 1
+	} else {
+		single := make(map[uint32][]int, 31*len(values))
+		for p := range values {
+			for d := 1; d < 32; d++ {
+				single[effect[p][d]] = append(single[effect[p][d]], p)
+			}
+		}
+		for _, name := range []string{"bech32", "bech32m"} {
+			target := residue ^ bech32Constants[name]
+			found := false
+			for q := range values {
+				for e := 1; e < 32; e++ {
+					for _, p := range single[target^effect[q][e]] {
+						if p < q {
+							positions[sep+2+p] = true
+							positions[sep+2+q] = true
+							found = true
+						}
+					}
+				}
+			}
+			if found {
+				hint.Encodings = append(hint.Encodings, name)
+			}
+		}
+		if len(positions) > 0 {
+			hint.Errors = 2
+		}
+	}
+	for p := range positions {
+		hint.Positions = append(hint.Positions, p)
+	}
+	sort.Ints(hint.Positions)
+
+	switch hint.Errors {
+	case 0:
+		hint.Message = "checksum mismatch: more than two characters are wrong, or one is missing or extra"
+	case 1:
+		hint.Message = fmt.Sprintf("checksum mismatch: one mistyped character, at position %s", joinPositions(hint.Positions))
+	default:
+		if len(hint.Positions) == 2 {
+			hint.Message = fmt.Sprintf("checksum mismatch: two mistyped characters, at positions %d and %d", hint.Positions[0], hint.Positions[1])
+		} else {
+			hint.Message = fmt.Sprintf("checksum mismatch: two mistyped characters, among positions %s", joinPositions(hint.Positions))
+		}
+	}
+	return hint
+}
+
+// isSegwitHRP reports whether hrp is the segwit prefix of a known network.
+func isSegwitHRP(hrp string) bool {
+	for _, n := range networkNames {
+		if getNetwork(n).Bech32HRPSegwit == hrp {
+			return true
+		}
+	}
+	return false
+}
+
+// joinPositions renders positions as "3, 17 or 40".
+func joinPositions(positions []int) string {
+	parts := make([]string, len(positions))
+	for i, p := range positions {
+		parts[i] = fmt.Sprint(p)
+	}
+	if len(parts) < 2 {
+		return strings.Join(parts, "")
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " or " + parts[len(parts)-1]
+}
+
+// mismatchHint returns the typo hint of an expected address a derived one
+// did not match.
+func mismatchHint(match bool, expected string) *AddressHint {
+	if match {
+		return nil
+	}
+	return addressTypoHint(expected)
+}
+
+// addressError rewords an address decoding error that would give the
+// checksum the address should have had, which amounts to a corrected
+// address.
+func addressError(err error) error {
+	var checksum bech32.ErrInvalidChecksum
+	if errors.As(err, &checksum) {
+		return errors.New("invalid checksum")
+	}
+	return err
+}
+
+// decodeAddress decodes an address on network, or on whichever known
+// network it belongs to when network is empty.
+func decodeAddress(address, network string) *AddressDecode {
+	report := &AddressDecode{Address: address}
+	networks := networkNames
+	if network != "" {
+		networks = []string{network}
+	}
+	var firstErr error
+	for _, n := range networks {
+		net := getNetwork(n)
+		addr, err := btcutil.DecodeAddress(address, net)
+		if err == nil && !addr.IsForNet(net) {
+			err = fmt.Errorf("address is not for %s", n)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			firstErr = err
+			continue
+		}
+		report.Valid = true
+		report.Network = n
+		report.ScriptPubKey = hex.EncodeToString(script)
+		class, _, _, _ := txscript.ExtractPkScriptAddrs(script, net)
+		report.Type = class.String()
+		if version, program, err := txscript.ExtractWitnessProgramInfo(script); err == nil {
+			v := version
+			report.WitnessVersion = &v
+			report.Program = hex.EncodeToString(program)
+		} else {
+			report.Program = hex.EncodeToString(addr.ScriptAddress())
+		}
+		return report
+	}
+	report.Error = addressError(firstErr).Error()
+	report.Hint = addressTypoHint(address)
+	return report
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The ui command serves one page on which an operator pastes a wallet spec
+// or a set of xpubs and sees the wallet's addresses with QR codes, each
+// derived by every engine and shown only if they agree. It is meant for a
+// desk without a CLI: the page is embedded and loads nothing from
+// elsewhere, and like --pprof it only listens on loopback, so the browser
+// runs on the verifier's machine.
+
+//go:embed ui
+var uiFiles embed.FS
+
+// defaultUIAddress is where ui listens without an address.
+const defaultUIAddress = "127.0.0.1:8088"
+
+// uiMaxCount caps the addresses per chain one page derives.
+const uiMaxCount = 1000
+
+// uiMaxQR caps the text a QR code is drawn for.
+const uiMaxQR = 200
+
+// uiRequest is what the page sends to /derive. Spec is any wallet spec
+// text, or xpubs one per line, which then take ScriptType and Threshold.
+type uiRequest struct {
+	Spec       string `json:"spec"`
+	ScriptType string `json:"script_type,omitempty"`
+	Threshold  int    `json:"threshold,omitempty"`
+	Count      int    `json:"count,omitempty"`
+}
+
+// UIResult is the answer of /derive. CrossCheck lists the engines every
+// address was derived and compared with.
+type UIResult struct {
+	RequestID  string        `json:"request_id"`
+	Wallet     *WalletReport `json:"wallet,omitempty"`
+	CrossCheck []string      `json:"cross_check,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Code       string        `json:"code,omitempty"`
+}
+
+// UIScriptTypes is the answer of /script-types, for the page's menus.
+type UIScriptTypes struct {
+	SingleSig []string `json:"single_sig"`
+	Multisig  []string `json:"multisig"`
+}
+
+// uiServer serializes derivations, which run with the process-wide options.
+type uiServer struct {
+	mu   sync.Mutex
+	port string
+}
+
+func serveUI(addr string) error {
+	ln, err := listenLoopback("ui", addr)
+	if err != nil {
+		return err
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	s := &uiServer{port: port}
+	static, _ := fs.Sub(uiFiles, "ui")
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/derive", s.derive)
+	mux.HandleFunc("/qr", s.qr)
+	mux.HandleFunc("/script-types", func(w http.ResponseWriter, r *http.Request) {
+		writeUIJSON(w, UIScriptTypes{SingleSig: scriptTypeNames(false), Multisig: scriptTypeNames(true)})
+	})
+	diagnostic("serving the verification UI on http://%s/", ln.Addr())
+	server := &http.Server{Handler: s.guard(mux), ReadHeaderTimeout: 10 * time.Second}
+	return server.Serve(ln)
+}
+
+// guard answers only requests addressed to the loopback listener by name,
+// which stops another site from reaching it through DNS rebinding, and
+// keeps the page from loading anything or being framed.
+func (s *uiServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port, err := net.SplitHostPort(r.Host)
+		ip := net.ParseIP(host)
+		if err != nil || port != s.port || host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self'; connect-src 'self'; form-action 'none'; frame-ancestors 'none'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *uiServer) derive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "POST application/json", http.StatusMethodNotAllowed)
+		return
+	}
+	var req uiRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeUIJSON(w, UIResult{Error: "failed to parse request: " + err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requestID = newRequestID()
+	defer func() { requestID = "" }()
+	sp := startSpan("ui derive", spanServer, spanAttr{"request.id", requestID})
+	result := s.run(&req)
+	sp.fail(result.Error)
+	sp.end(nil)
+	flushTraces()
+	writeUIJSON(w, result)
+}
+
+// run derives the request's addresses with every engine. A panic is
+// reported as an internal error rather than taking the server down.
+func (s *uiServer) run(req *uiRequest) (result UIResult) {
+	result.RequestID = requestID
+	fail := func(err error) UIResult {
+		result.Error, result.Code = err.Error(), errorCode(err)
+		return result
+	}
+	saved := options
+	options.paranoid, options.format, options.cache = true, "json", false
+	defer func() {
+		options = saved
+		if r := recover(); r != nil {
+			diagnostic("panic: %v\n%s", r, debug.Stack())
+			result = fail(errorWithCode(errInternal, "internal error: %v", r))
+		}
+	}()
+
+	count := req.Count
+	if count == 0 {
+		count = 10
+	}
+	if count < 1 || count > uiMaxCount {
+		return fail(errorWithCode(errInvalidCount, "invalid count: %d (want 1 to %d)", count, uiMaxCount))
+	}
+	spec, err := uiWalletSpec(req)
+	if err != nil {
+		return fail(err)
+	}
+	report, err := verifyWallet(spec, count)
+	if err != nil {
+		return fail(err)
+	}
+	result.Wallet, result.CrossCheck = report, engineNames()
+	return result
+}
+
+// uiWalletSpec parses the pasted text. It is never read as a file name or
+// a registry reference, as loadWalletSpec would.
+func uiWalletSpec(req *uiRequest) (*WalletSpec, error) {
+	lines := strings.Fields(req.Spec)
+	xpubs := len(lines) > 0
+	for _, line := range lines {
+		xpubs = xpubs && looksLikeXpub(line)
+	}
+	var spec *WalletSpec
+	if xpubs {
+		spec = &WalletSpec{ScriptType: req.ScriptType, Threshold: req.Threshold}
+		for _, line := range lines {
+			xpub, err := xpubFromInput(line)
+			if err != nil {
+				return nil, err
+			}
+			spec.Keys = append(spec.Keys, WalletKey{Xpub: xpub})
+		}
+		spec.Network = networkFromXpub(spec.Keys[0].Xpub)
+	} else {
+		var err error
+		if spec, err = parseWalletSpec([]byte(req.Spec)); err != nil {
+			return nil, err
+		}
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// looksLikeXpub matches a bare extended public key of any SLIP-132
+// version, or a ur:crypto-hdkey.
+func looksLikeXpub(s string) bool {
+	if strings.HasPrefix(strings.ToLower(s), "ur:crypto-hdkey/") {
+		return true
+	}
+	return len(s) > 100 && s[1:4] == "pub"
+}
+
+func (s *uiServer) qr(w http.ResponseWriter, r *http.Request) {
+	data := r.URL.Query().Get("data")
+	if data == "" || len(data) > uiMaxQR {
+		http.Error(w, fmt.Sprintf("data must be 1 to %d bytes", uiMaxQR), http.StatusBadRequest)
+		return
+	}
+	q, err := encodeQR([]byte(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, q.svg())
+}
+
+func writeUIJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
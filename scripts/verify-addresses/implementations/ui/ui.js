@@ -0,0 +1,122 @@
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+function cell(text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) td.className = className;
+  return td;
+}
+
+function qr(address) {
+  const td = document.createElement("td");
+  const img = document.createElement("img");
+  img.className = "qr";
+  img.alt = "QR code of " + address;
+  img.src = "qr?data=" + encodeURIComponent(address);
+  td.appendChild(img);
+  return td;
+}
+
+function addresses(tbody, list) {
+  tbody.replaceChildren();
+  for (const a of list) {
+    const tr = document.createElement("tr");
+    tr.append(cell(a.index), cell(a.address, "address"), qr(a.address));
+    tbody.appendChild(tr);
+  }
+}
+
+function summary(wallet) {
+  const dl = $("summary");
+  dl.replaceChildren();
+  const add = (term, value) => {
+    if (value === undefined || value === "" || value === 0) return;
+    const dt = document.createElement("dt");
+    const dd = document.createElement("dd");
+    dt.textContent = term;
+    dd.textContent = value;
+    dl.append(dt, dd);
+  };
+  add("Name", wallet.name);
+  add("Network", wallet.network);
+  add("Script type", wallet.script_type);
+  if (wallet.threshold) add("Policy", wallet.threshold + " of " + wallet.keys.length);
+  wallet.keys.forEach((k, i) => {
+    const origin = k.fingerprint ? "[" + k.fingerprint + (k.path ? "/" + k.path.replace(/^m\//, "") : "") + "] " : "";
+    add("Key " + (i + 1), origin + k.xpub);
+  });
+  add("Descriptor", wallet.descriptor);
+}
+
+function status(text, ok) {
+  const el = $("status");
+  el.textContent = text;
+  el.className = ok ? "ok" : "fail";
+}
+
+function show(result) {
+  if (result.error) {
+    $("wallet").hidden = true;
+    status(result.error + (result.code ? " (" + result.code + ")" : ""), false);
+    return;
+  }
+  const w = result.wallet;
+  summary(w);
+  addresses($("receive"), w.receive);
+  addresses($("change"), w.change);
+  const checks = w.checks || [];
+  $("checks").hidden = checks.length === 0;
+  const rows = $("check-rows");
+  rows.replaceChildren();
+  for (const c of checks) {
+    const tr = document.createElement("tr");
+    tr.append(cell(c.change ? "change" : "receive"), cell(c.index), cell(c.expected, "address"),
+      cell(c.derived, "address"), cell(c.match ? "match" : "MISMATCH"));
+    rows.appendChild(tr);
+  }
+  $("wallet").hidden = false;
+  const engines = result.cross_check.join(" and ");
+  if (w.verified) {
+    status("Every address was derived by " + engines + ", and they agree" +
+      (checks.length ? "; every address the export claims matches." : "."), true);
+  } else {
+    status("Derived by " + engines + ", but addresses the export claims do not match.", false);
+  }
+}
+
+async function derive(event) {
+  event.preventDefault();
+  status("Deriving...", true);
+  const body = {
+    spec: $("spec").value,
+    script_type: $("script-type").value,
+    threshold: Number($("threshold").value),
+    count: Number($("count").value),
+  };
+  try {
+    const resp = await fetch("derive", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(body),
+    });
+    show(await resp.json());
+  } catch (err) {
+    status("The verifier did not answer: " + err, false);
+  }
+}
+
+async function init() {
+  const types = await (await fetch("script-types")).json();
+  const select = $("script-type");
+  for (const [label, names] of [["Single-sig", types.single_sig], ["Multisig", types.multisig]]) {
+    const group = document.createElement("optgroup");
+    group.label = label;
+    for (const name of names) group.appendChild(new Option(name, name, false, name === "native_segwit"));
+    select.appendChild(group);
+  }
+  $("form").addEventListener("submit", derive);
+}
+
+init();
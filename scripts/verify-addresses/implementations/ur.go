@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// bytewordsList is the BCR-2020-012 Bytewords table, indexed by byte value.
+var bytewordsList = strings.Fields(`
+able acid also apex aqua arch atom aunt away axis back bald barn belt beta bias
+blue body brag brew bulb buzz calm cash cats chef city claw code cola cook cost
+crux curl cusp cyan dark data days deli dice diet door down draw drop drum dull
+duty each easy echo edge epic even exam exit eyes fact fair fern figs film fish
+fizz flap flew flux foxy free frog fuel fund gala game gear gems gift girl glow
+good gray grim guru gush gyro half hang hard hawk heat help high hill holy hope
+horn huts iced idea idle inch inky into iris iron item jade jazz join jolt jowl
+judo jugs jump junk jury keep keno kept keys kick kiln king kite kiwi knob lamb
+lava lazy leaf legs liar limp lion list logo loud love luau luck lung main many
+math maze memo menu meow mild mint miss monk nail navy need news next noon note
+numb obey oboe omit onyx open oval owls paid part peck play plus poem pool pose
+puff puma purr quad quiz race ramp real redo rich road rock roof ruby ruin runs
+rust safe saga scar sets silk skew slot soap solo song stub surf swan taco task
+taxi tent tied time tiny toil tomb toys trip tuna twin ugly undo unit urge user
+vast very veto vial vibe view visa void vows wall wand warm wasp wave waxy webs
+what when whiz wolf work yank yawn yell yoga yurt zaps zero zest zinc zone zoom`)
+
+// bytewordsMinimal maps minimal (first+last letter) bytewords to byte values.
+var bytewordsMinimal = func() map[string]byte {
+	m := make(map[string]byte, len(bytewordsList))
+	for i, w := range bytewordsList {
+		m[w[:1]+w[3:]] = byte(i)
+	}
+	return m
+}()
+
+// decodeBytewords decodes minimal bytewords and verifies the trailing
+// CRC32 checksum.
+func decodeBytewords(s string) ([]byte, error) {
+	s = strings.ToLower(s)
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("bytewords: odd length")
+	}
+	data := make([]byte, 0, len(s)/2)
+	for i := 0; i < len(s); i += 2 {
+		b, ok := bytewordsMinimal[s[i:i+2]]
+		if !ok {
+			return nil, fmt.Errorf("bytewords: invalid word %q at position %d", s[i:i+2], i/2)
+		}
+		data = append(data, b)
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("bytewords: missing checksum")
+	}
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(checksum) {
+		return nil, fmt.Errorf("bytewords: checksum mismatch")
+	}
+	return payload, nil
+}
+
+// urPart is one parsed "ur:<type>[/<seq>-<len>]/<bytewords>" string.
+type urPart struct {
+	Type    string
+	SeqNum  int
+	SeqLen  int
+	Payload []byte
+}
+
+func parseURPart(s string) (*urPart, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !strings.HasPrefix(s, "ur:") {
+		return nil, fmt.Errorf("not a UR: missing ur: prefix")
+	}
+	fields := strings.Split(s[3:], "/")
+
+	part := &urPart{Type: fields[0]}
+	switch len(fields) {
+	case 2:
+	case 3:
+		seq := strings.SplitN(fields[1], "-", 2)
+		if len(seq) != 2 {
+			return nil, fmt.Errorf("invalid UR sequence %q", fields[1])
+		}
+		var errNum, errLen error
+		part.SeqNum, errNum = strconv.Atoi(seq[0])
+		part.SeqLen, errLen = strconv.Atoi(seq[1])
+		if errNum != nil || errLen != nil || part.SeqNum < 1 || part.SeqLen < 1 {
+			return nil, fmt.Errorf("invalid UR sequence %q", fields[1])
+		}
+	default:
+		return nil, fmt.Errorf("invalid UR structure")
+	}
+
+	payload, err := decodeBytewords(fields[len(fields)-1])
+	if err != nil {
+		return nil, err
+	}
+	part.Payload = payload
+	return part, nil
+}
+
+// urFragment is the CBOR body of a multi-part UR:
+// [seqNum, seqLen, messageLen, checksum, fragment].
+type urFragment struct {
+	SeqNum     uint64
+	SeqLen     uint64
+	MessageLen uint64
+	Checksum   uint32
+	Data       []byte
+}
+
+func parseURFragment(payload []byte) (*urFragment, error) {
+	v, err := decodeCBOR(payload)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok || len(items) != 5 {
+		return nil, fmt.Errorf("invalid multi-part UR fragment")
+	}
+	f := &urFragment{}
+	var checksum uint64
+	var okNum, okLen, okMsg, okSum, okData bool
+	f.SeqNum, okNum = cborUint(items[0])
+	f.SeqLen, okLen = cborUint(items[1])
+	f.MessageLen, okMsg = cborUint(items[2])
+	checksum, okSum = cborUint(items[3])
+	f.Data, okData = items[4].([]byte)
+	if !okNum || !okLen || !okMsg || !okSum || !okData || checksum > 0xffffffff {
+		return nil, fmt.Errorf("invalid multi-part UR fragment")
+	}
+	f.Checksum = uint32(checksum)
+	return f, nil
+}
+
+// urDecoder accumulates UR parts, in any order and with any mix of plain
+// and fountain-coded parts, until the message is complete.
+type urDecoder struct {
+	urType   string
+	message  []byte
+	header   *urFragment
+	fountain *fountainDecoder
+}
+
+func newURDecoder() *urDecoder {
+	return &urDecoder{fountain: newFountainDecoder()}
+}
+
+// receive adds one UR part to the decoder. Parts arriving after the
+// message is complete are ignored.
+func (d *urDecoder) receive(s string) error {
+	part, err := parseURPart(s)
+	if err != nil {
+		return err
+	}
+	if d.urType != "" && part.Type != d.urType {
+		return fmt.Errorf("UR type mismatch: %s vs %s", part.Type, d.urType)
+	}
+	d.urType = part.Type
+	if d.complete() {
+		return nil
+	}
+
+	if part.SeqLen == 0 {
+		d.message = part.Payload
+		return nil
+	}
+
+	f, err := parseURFragment(part.Payload)
+	if err != nil {
+		return err
+	}
+	if d.header == nil {
+		if f.SeqLen == 0 || f.MessageLen == 0 || uint64(len(f.Data))*f.SeqLen < f.MessageLen {
+			return fmt.Errorf("invalid multi-part UR header")
+		}
+		d.header = f
+	}
+	h := d.header
+	if f.SeqLen != h.SeqLen || f.MessageLen != h.MessageLen || f.Checksum != h.Checksum || len(f.Data) != len(h.Data) {
+		return fmt.Errorf("UR part %d belongs to a different message", f.SeqNum)
+	}
+
+	d.fountain.add(f)
+	message := d.fountain.message(h.SeqLen)
+	if message == nil {
+		return nil
+	}
+	message = message[:h.MessageLen]
+	if crc32.ChecksumIEEE(message) != h.Checksum {
+		return fmt.Errorf("UR message checksum mismatch")
+	}
+	d.message = message
+	return nil
+}
+
+func (d *urDecoder) complete() bool {
+	return d.message != nil
+}
+
+// decodeUR decodes one single-part UR or a sufficient set of multi-part
+// URs and returns the UR type and CBOR message.
+func decodeUR(parts []string) (string, []byte, error) {
+	d := newURDecoder()
+	for _, p := range parts {
+		if err := d.receive(p); err != nil {
+			return "", nil, err
+		}
+	}
+	if !d.complete() {
+		expected := uint64(0)
+		if d.header != nil {
+			expected = d.header.SeqLen
+		}
+		return "", nil, fmt.Errorf("incomplete UR: recovered %d of %d fragments", len(d.fountain.simple), expected)
+	}
+	return d.urType, d.message, nil
+}
+
+// encodeBytewords encodes data as minimal bytewords followed by its CRC32
+// checksum.
+func encodeBytewords(data []byte) string {
+	var b strings.Builder
+	for _, c := range binary.BigEndian.AppendUint32(append([]byte(nil), data...), crc32.ChecksumIEEE(data)) {
+		w := bytewordsList[c]
+		b.WriteString(w[:1] + w[3:])
+	}
+	return b.String()
+}
+
+// minURFragmentSize is the shortest fragment a message is split into.
+const minURFragmentSize = 10
+
+// urEncoder splits a CBOR message into the fragments of a multi-part UR.
+// Parts 1 to len(fragments) carry one fragment each; later parts are
+// fountain-coded mixes, as chooseFragments picks them, that let a scanner
+// which missed some parts finish from the next ones that come round.
+type urEncoder struct {
+	urType    string
+	message   []byte
+	checksum  uint32
+	fragments [][]byte
+}
+
+// newUREncoder splits message into the fewest fragments of at most
+// maxFragment bytes, all of the same length, the last zero-padded.
+func newUREncoder(urType string, message []byte, maxFragment int) *urEncoder {
+	size := len(message)
+	for count := 1; count <= max(1, len(message)/minURFragmentSize); count++ {
+		size = (len(message) + count - 1) / count
+		if size <= maxFragment {
+			break
+		}
+	}
+	e := &urEncoder{urType: urType, message: message, checksum: crc32.ChecksumIEEE(message)}
+	for off := 0; off < len(message); off += size {
+		fragment := make([]byte, size)
+		copy(fragment, message[off:])
+		e.fragments = append(e.fragments, fragment)
+	}
+	return e
+}
+
+// part returns UR part seqNum, counted from 1. A message that fits one
+// fragment is a single-part UR, the same for every seqNum.
+func (e *urEncoder) part(seqNum int) string {
+	seqLen := len(e.fragments)
+	if seqLen == 1 {
+		return "ur:" + e.urType + "/" + encodeBytewords(e.message)
+	}
+	data := make([]byte, len(e.fragments[0]))
+	for _, i := range chooseFragments(uint64(seqNum), uint64(seqLen), e.checksum) {
+		for k := range data {
+			data[k] ^= e.fragments[i][k]
+		}
+	}
+	body := appendCBORHead(nil, 4, 5)
+	body = appendCBORHead(body, 0, uint64(seqNum))
+	body = appendCBORHead(body, 0, uint64(seqLen))
+	body = appendCBORHead(body, 0, uint64(len(e.message)))
+	body = appendCBORHead(body, 0, uint64(e.checksum))
+	body = append(appendCBORHead(body, 2, uint64(len(data))), data...)
+	return fmt.Sprintf("ur:%s/%d-%d/%s", e.urType, seqNum, seqLen, encodeBytewords(body))
+}
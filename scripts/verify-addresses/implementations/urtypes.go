@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// CBOR tags from the BCR-2020-006 UR registry. The UR 2.0 registry moved
+// them up by 40000 (e.g. hdkey is 40303); both forms are accepted.
+const (
+	urTagHDKey         = 303
+	urTagKeypath       = 304
+	urTagCoinInfo      = 305
+	urTagOutput        = 308
+	urTagPSBT          = 310
+	urTagAccount       = 311
+	urTagSH            = 400
+	urTagWSH           = 401
+	urTagPKH           = 403
+	urTagWPKH          = 404
+	urTagMulti         = 406
+	urTagSortedMulti   = 407
+	urTagTR            = 409
+	urTagRegistryShift = 40000
+)
+
+// urOutputScriptTypes maps nested script-expression tags to script types.
+var urOutputScriptTypes = map[string]string{
+	"403":         "legacy",
+	"400/404":     "nested_segwit",
+	"404":         "native_segwit",
+	"409":         "taproot",
+	"400/407":     "p2sh",
+	"401/407":     "p2wsh",
+	"400/401/407": "p2sh_p2wsh",
+}
+
+// purposeScriptTypes maps BIP-44 style purpose levels to single-sig types.
+var purposeScriptTypes = map[uint32]string{
+	44: "legacy",
+	49: "nested_segwit",
+	84: "native_segwit",
+	86: "taproot",
+}
+
+// URDecodeResult is the output of the decode-ur command.
+type URDecodeResult struct {
+	Type        string     `json:"type"`
+	Key         *WalletKey `json:"key,omitempty"`
+	Descriptors []string   `json:"descriptors,omitempty"`
+	PSBT        string     `json:"psbt,omitempty"`
+}
+
+func normalizeURTag(tag uint64) uint64 {
+	if tag > urTagRegistryShift {
+		return tag - urTagRegistryShift
+	}
+	return tag
+}
+
+// untagCBOR strips an expected tag from a value; untagged values are
+// accepted as-is since tags are optional at the top level of a UR.
+func untagCBOR(v interface{}, tag uint64) interface{} {
+	if t, ok := v.(cborTag); ok && normalizeURTag(t.Number) == tag {
+		return t.Content
+	}
+	return v
+}
+
+// decodeURPayload interprets a decoded UR message.
+func decodeURPayload(urType string, message []byte) (*URDecodeResult, error) {
+	v, err := decodeCBOR(message)
+	if err != nil {
+		return nil, err
+	}
+	result := &URDecodeResult{Type: urType}
+
+	switch urType {
+	case "crypto-hdkey", "hdkey":
+		key, _, err := urHDKey(untagCBOR(v, urTagHDKey))
+		if err != nil {
+			return nil, err
+		}
+		result.Key = key
+
+	case "crypto-output", "output-descriptor":
+		spec, err := urOutputSpec(untagCBOR(v, urTagOutput), "")
+		if err != nil {
+			return nil, err
+		}
+		desc, err := walletDescriptor(spec, false)
+		if err != nil {
+			return nil, err
+		}
+		result.Descriptors = []string{desc}
+
+	case "crypto-account", "account-descriptor":
+		specs, err := urAccountSpecs(untagCBOR(v, urTagAccount))
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range specs {
+			desc, err := walletDescriptor(spec, false)
+			if err != nil {
+				return nil, err
+			}
+			result.Descriptors = append(result.Descriptors, desc)
+		}
+
+	case "crypto-psbt", "psbt":
+		psbt, ok := untagCBOR(v, urTagPSBT).([]byte)
+		if !ok {
+			return nil, fmt.Errorf("crypto-psbt payload is not a byte string")
+		}
+		result.PSBT = base64.StdEncoding.EncodeToString(psbt)
+
+	default:
+		return nil, fmt.Errorf("unsupported UR type: %s", urType)
+	}
+
+	return result, nil
+}
+
+// urKeypath is a decoded crypto-keypath.
+type urKeypath struct {
+	Components  []urPathComponent
+	Fingerprint string
+	Depth       int
+}
+
+// urPathComponent is one keypath step; Index < 0 marks a wildcard and a
+// non-nil Pair marks a <a;b> multipath step.
+type urPathComponent struct {
+	Index    int64
+	Pair     []uint64
+	Hardened bool
+}
+
+func urParseKeypath(v interface{}) (*urKeypath, error) {
+	v = untagCBOR(v, urTagKeypath)
+	kp := &urKeypath{Depth: -1}
+
+	raw, _ := cborMap(v, 1)
+	items, ok := raw.([]interface{})
+	if !ok || len(items)%2 != 0 {
+		return nil, fmt.Errorf("invalid crypto-keypath components")
+	}
+	for i := 0; i < len(items); i += 2 {
+		c := urPathComponent{}
+		switch idx := items[i].(type) {
+		case uint64:
+			if idx >= hdkeychain.HardenedKeyStart {
+				return nil, fmt.Errorf("invalid keypath index %d", idx)
+			}
+			c.Index = int64(idx)
+		case []interface{}:
+			c.Index = -1
+			for _, p := range idx {
+				n, ok := cborUint(p)
+				if !ok {
+					return nil, fmt.Errorf("invalid keypath range")
+				}
+				c.Pair = append(c.Pair, n)
+			}
+		default:
+			return nil, fmt.Errorf("invalid keypath component")
+		}
+		c.Hardened, _ = items[i+1].(bool)
+		kp.Components = append(kp.Components, c)
+	}
+
+	if fp, ok := cborMap(v, 2); ok {
+		n, _ := cborUint(fp)
+		kp.Fingerprint = fmt.Sprintf("%08x", n)
+	}
+	if depth, ok := cborMap(v, 3); ok {
+		n, _ := cborUint(depth)
+		kp.Depth = int(n)
+	}
+	return kp, nil
+}
+
+// path renders a fixed keypath as "m/84'/0'/0'".
+func (kp *urKeypath) path() (string, error) {
+	parts := []string{"m"}
+	for _, c := range kp.Components {
+		if c.Index < 0 {
+			return "", fmt.Errorf("key origin contains a wildcard")
+		}
+		p := strconv.FormatInt(c.Index, 10)
+		if c.Hardened {
+			p += "'"
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// urHDKey converts a crypto-hdkey map to an extended public key.
+func urHDKey(v interface{}) (*WalletKey, *urKeypath, error) {
+	if private, _ := cborMap(v, 2); private == true {
+		return nil, nil, fmt.Errorf("crypto-hdkey contains private key material")
+	}
+	keyData, _ := cborMap(v, 3)
+	chainCode, _ := cborMap(v, 4)
+	pub, okPub := keyData.([]byte)
+	chain, okChain := chainCode.([]byte)
+	if !okPub || len(pub) != 33 || !okChain || len(chain) != 32 {
+		return nil, nil, fmt.Errorf("crypto-hdkey is missing key data or chain code")
+	}
+
+	version := []byte{0x04, 0x88, 0xB2, 0x1E} // xpub
+	if useInfo, ok := cborMap(v, 5); ok {
+		if network, _ := cborMap(untagCBOR(useInfo, urTagCoinInfo), 2); network == uint64(1) {
+			version = []byte{0x04, 0x35, 0x87, 0xCF} // tpub
+		}
+	}
+
+	key := &WalletKey{}
+	var origin *urKeypath
+	depth, childNum := 0, uint32(0)
+	if o, ok := cborMap(v, 6); ok {
+		var err error
+		if origin, err = urParseKeypath(o); err != nil {
+			return nil, nil, err
+		}
+		if key.Path, err = origin.path(); err != nil {
+			return nil, nil, err
+		}
+		key.Fingerprint = origin.Fingerprint
+		depth = len(origin.Components)
+		if origin.Depth >= 0 {
+			depth = origin.Depth
+		}
+		if n := len(origin.Components); n > 0 {
+			last := origin.Components[n-1]
+			childNum = uint32(last.Index)
+			if last.Hardened {
+				childNum += hdkeychain.HardenedKeyStart
+			}
+		}
+	}
+	if depth > 255 {
+		return nil, nil, fmt.Errorf("invalid crypto-hdkey depth %d", depth)
+	}
+
+	parentFP := make([]byte, 4)
+	if fp, ok := cborMap(v, 8); ok {
+		n, _ := cborUint(fp)
+		parentFP = []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	if children, ok := cborMap(v, 7); ok {
+		kp, err := urParseKeypath(children)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkURChildren(kp); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ext := hdkeychain.NewExtendedKey(version, pub, chain, parentFP, uint8(depth), childNum, false)
+	if _, err := ext.ECPubKey(); err != nil {
+		return nil, nil, fmt.Errorf("crypto-hdkey has an invalid public key: %v", err)
+	}
+	key.Xpub = ext.String()
+	if name, ok := cborMap(v, 9); ok {
+		key.Label, _ = name.(string)
+	}
+	return key, origin, nil
+}
+
+// checkURChildren accepts only the <chain>/* layout used by this verifier.
+func checkURChildren(kp *urKeypath) error {
+	c := kp.Components
+	if len(c) == 2 && c[1].Index < 0 && c[1].Pair == nil && !c[0].Hardened && !c[1].Hardened {
+		if c[0].Index == 0 || c[0].Index == 1 || (len(c[0].Pair) == 2 && c[0].Pair[0] == 0 && c[0].Pair[1] == 1) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported crypto-hdkey child derivation (expected /0/*, /1/* or /<0;1>/*)")
+}
+
+// urOutputSpec converts a crypto-output script expression to a wallet spec.
+// masterFingerprint fills in key origins that omit their source fingerprint.
+func urOutputSpec(v interface{}, masterFingerprint string) (*WalletSpec, error) {
+	var tags []string
+	for {
+		t, ok := v.(cborTag)
+		if !ok {
+			return nil, fmt.Errorf("invalid crypto-output: untagged script expression")
+		}
+		tag := normalizeURTag(t.Number)
+		if tag == urTagHDKey || tag == urTagMulti || tag == urTagSortedMulti {
+			if tag == urTagMulti {
+				return nil, fmt.Errorf("unsorted multi() outputs are not supported")
+			}
+			if tag == urTagSortedMulti {
+				tags = append(tags, strconv.FormatUint(tag, 10))
+			}
+			v = t
+			break
+		}
+		tags = append(tags, strconv.FormatUint(tag, 10))
+		v = t.Content
+	}
+
+	scriptType, ok := urOutputScriptTypes[strings.Join(tags, "/")]
+	if !ok {
+		return nil, fmt.Errorf("unsupported crypto-output script expression %s", strings.Join(tags, "/"))
+	}
+	spec := &WalletSpec{ScriptType: scriptType}
+
+	t := v.(cborTag)
+	keyValues := []interface{}{t}
+	if normalizeURTag(t.Number) == urTagSortedMulti {
+		threshold, _ := cborMap(t.Content, 1)
+		n, ok := cborUint(threshold)
+		if !ok {
+			return nil, fmt.Errorf("invalid sortedmulti threshold")
+		}
+		spec.Threshold = int(n)
+		raw, _ := cborMap(t.Content, 2)
+		if keyValues, ok = raw.([]interface{}); !ok {
+			return nil, fmt.Errorf("invalid sortedmulti keys")
+		}
+	}
+
+	for _, kv := range keyValues {
+		kt, ok := kv.(cborTag)
+		if !ok || normalizeURTag(kt.Number) != urTagHDKey {
+			return nil, fmt.Errorf("crypto-output keys must be crypto-hdkey")
+		}
+		key, _, err := urHDKey(kt.Content)
+		if err != nil {
+			return nil, err
+		}
+		if key.Fingerprint == "" || key.Fingerprint == "00000000" {
+			key.Fingerprint = masterFingerprint
+		}
+		spec.Keys = append(spec.Keys, *key)
+	}
+	spec.Network = networkFromXpub(spec.Keys[0].Xpub)
+	return spec, nil
+}
+
+// urAccountSpecs converts a crypto-account to one spec per output.
+func urAccountSpecs(v interface{}) ([]*WalletSpec, error) {
+	fp, _ := cborMap(v, 1)
+	n, ok := cborUint(fp)
+	if !ok {
+		return nil, fmt.Errorf("crypto-account is missing the master fingerprint")
+	}
+	master := fmt.Sprintf("%08x", n)
+
+	raw, _ := cborMap(v, 2)
+	outputs, ok := raw.([]interface{})
+	if !ok || len(outputs) == 0 {
+		return nil, fmt.Errorf("crypto-account has no output descriptors")
+	}
+	specs := make([]*WalletSpec, 0, len(outputs))
+	for _, o := range outputs {
+		spec, err := urOutputSpec(untagCBOR(o, urTagOutput), master)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// parseURWalletSpec builds a wallet spec from UR parts, one per line. A
+// bare crypto-hdkey takes its script type from the purpose in its origin.
+func parseURWalletSpec(text string) (*WalletSpec, error) {
+	urType, message, err := decodeUR(strings.Fields(text))
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeCBOR(message)
+	if err != nil {
+		return nil, err
+	}
+
+	switch urType {
+	case "crypto-output", "output-descriptor":
+		return urOutputSpec(untagCBOR(v, urTagOutput), "")
+
+	case "crypto-account", "account-descriptor":
+		specs, err := urAccountSpecs(untagCBOR(v, urTagAccount))
+		if err != nil {
+			return nil, err
+		}
+		if len(specs) != 1 {
+			return nil, fmt.Errorf("crypto-account holds %d accounts; use decode-ur and pick one descriptor", len(specs))
+		}
+		return specs[0], nil
+
+	case "crypto-hdkey", "hdkey":
+		key, origin, err := urHDKey(untagCBOR(v, urTagHDKey))
+		if err != nil {
+			return nil, err
+		}
+		if origin == nil || len(origin.Components) == 0 {
+			return nil, fmt.Errorf("crypto-hdkey has no origin to infer a script type from")
+		}
+		scriptType, ok := purposeScriptTypes[uint32(origin.Components[0].Index)]
+		if !ok {
+			return nil, fmt.Errorf("cannot infer script type from purpose %d", origin.Components[0].Index)
+		}
+		return &WalletSpec{
+			Network:    networkFromXpub(key.Xpub),
+			ScriptType: scriptType,
+			Keys:       []WalletKey{*key},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("UR type %s is not a wallet spec", urType)
+}
+
+// xpubFromInput returns the extended key for an xpub argument, decoding it
+// first when it is a single-part crypto-hdkey UR.
+func xpubFromInput(input string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(input), "ur:") {
+		return input, nil
+	}
+	urType, message, err := decodeUR([]string{input})
+	if err != nil {
+		return "", err
+	}
+	result, err := decodeURPayload(urType, message)
+	if err != nil {
+		return "", err
+	}
+	if result.Key == nil {
+		return "", fmt.Errorf("UR type %s is not an extended key", urType)
+	}
+	return result.Key.Xpub, nil
+}
+
+// defaultURFragmentSize keeps every part of a PSBT UR, with its header and
+// checksum, within the largest QR code encodeQR draws.
+const defaultURFragmentSize = 60
+
+// maxURParts bounds the parts encode-psbt-ur emits.
+const maxURParts = 10_000
+
+// PSBTUREncoding is a PSBT as the parts of an animated UR QR code, for a
+// signer that scans PSBTs in (BCR-2020-005, BCR-2020-006).
+type PSBTUREncoding struct {
+	TxID string `json:"txid"`
+	Type string `json:"type"`
+	// MessageSize is the length of the CBOR-wrapped PSBT, and FragmentSize
+	// the length of the fragments it is split into.
+	MessageSize  int `json:"message_size"`
+	FragmentSize int `json:"fragment_size"`
+	// Fragments is the fewest parts a scanner needs; parts past it are
+	// fountain-coded, so a missed frame does not wait for its turn.
+	Fragments int      `json:"fragments"`
+	Parts     []string `json:"parts"`
+	// Files are the QR codes --qr wrote, one SVG per part.
+	Files []string `json:"files,omitempty"`
+}
+
+// encodePSBTUR splits a PSBT into parts of a UR of urType, "crypto-psbt"
+// or "psbt", with fragments of at most fragmentSize bytes. parts is how
+// many to emit, 0 for one per fragment.
+func encodePSBTUR(p *psbtPacket, urType string, fragmentSize, parts int) (*PSBTUREncoding, error) {
+	if urType != "crypto-psbt" && urType != "psbt" {
+		return nil, fmt.Errorf("invalid UR type: %q (want crypto-psbt or psbt)", urType)
+	}
+	if fragmentSize < minURFragmentSize {
+		return nil, errorWithCode(errInvalidCount, "invalid fragment size %d (want at least %d bytes)", fragmentSize, minURFragmentSize)
+	}
+	raw, err := p.serialize()
+	if err != nil {
+		return nil, err
+	}
+	message := append(appendCBORHead(nil, 2, uint64(len(raw))), raw...)
+	e := newUREncoder(urType, message, fragmentSize)
+	if parts == 0 {
+		parts = len(e.fragments)
+	}
+	if parts < len(e.fragments) || parts > maxURParts {
+		return nil, errorWithCode(errInvalidCount, "invalid part count %d (want %d, one per fragment, to %d)", parts, len(e.fragments), maxURParts)
+	}
+	enc := &PSBTUREncoding{
+		TxID:         p.tx.TxHash().String(),
+		Type:         urType,
+		MessageSize:  len(message),
+		FragmentSize: len(e.fragments[0]),
+		Fragments:    len(e.fragments),
+	}
+	for i := 1; i <= parts; i++ {
+		enc.Parts = append(enc.Parts, e.part(i))
+	}
+	return enc, nil
+}
+
+// writeURQR writes each part as a QR code, dir/part-0001.svg and on, for
+// a display to cycle through.
+func writeURQR(dir string, parts []string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var files []string
+	for i, part := range parts {
+		q, err := encodeQR([]byte(part))
+		if err != nil {
+			return nil, fmt.Errorf("part %d does not fit in a QR code (%v); use a smaller --fragment-size", i+1, err)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("part-%04d.svg", i+1))
+		if err := os.WriteFile(file, []byte(q.svg()), 0o644); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
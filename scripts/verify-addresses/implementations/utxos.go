@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// WalletUTXO is one unspent output of a watch-only wallet, with everything
+// PSBT construction needs to locate and spend it.
+type WalletUTXO struct {
+	Outpoint      string `json:"outpoint"`
+	TxID          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Value         int64  `json:"value"`
+	Address       string `json:"address"`
+	ScriptPubKey  string `json:"script_pubkey"`
+	ScriptType    string `json:"script_type"`
+	Change        bool   `json:"change"`
+	Index         uint32 `json:"index"`
+	Confirmations int64  `json:"confirmations"`
+	Height        int64  `json:"height,omitempty"`
+}
+
+// UTXOReport lists a wallet's unspent outputs in chain and index order.
+type UTXOReport struct {
+	Backend   string       `json:"backend"`
+	TipHeight int64        `json:"tip_height"`
+	Range     *indexRange  `json:"range,omitempty"`
+	UTXOs     []WalletUTXO `json:"utxos"`
+	Total     int64        `json:"total"`
+}
+
+func walletUTXOs(spec *WalletSpec, backend ChainBackend, store *walletStore, r *indexRange, gap int) (*UTXOReport, error) {
+	return listWalletUTXOs(spec, backend, store, r, gap, streaming())
+}
+
+// listWalletUTXOs is walletUTXOs, streaming each output as a record instead
+// of listing it when stream is set.
+func listWalletUTXOs(spec *WalletSpec, backend ChainBackend, store *walletStore, r *indexRange, gap int, stream bool) (*UTXOReport, error) {
+	tip, err := backend.TipHeight()
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := chainRanges(spec, backend, store, r, gap)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UTXOReport{Backend: backend.Name(), TipHeight: tip, Range: r}
+	if !stream {
+		report.UTXOs = []WalletUTXO{}
+	}
+	err = collectUTXOs(spec, backend, store, ranges, func(a walletAddressUTXOs) error {
+		if len(a.UTXOs) == 0 {
+			return nil
+		}
+		script, err := addressScript(a.Address, spec.Network)
+		if err != nil {
+			return err
+		}
+		for _, u := range a.UTXOs {
+			confirmations := int64(0)
+			if u.Height > 0 {
+				confirmations = tip - u.Height + 1
+			}
+			utxo := WalletUTXO{
+				Outpoint:      fmt.Sprintf("%s:%d", u.TxID, u.Vout),
+				TxID:          u.TxID,
+				Vout:          u.Vout,
+				Value:         u.Value,
+				Address:       a.Address,
+				ScriptPubKey:  hex.EncodeToString(script),
+				ScriptType:    spec.ScriptType,
+				Change:        a.Change,
+				Index:         a.Index,
+				Confirmations: confirmations,
+				Height:        u.Height,
+			}
+			if stream {
+				streamRecord("utxo", utxo)
+			} else {
+				report.UTXOs = append(report.UTXOs, utxo)
+			}
+			report.Total += u.Value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
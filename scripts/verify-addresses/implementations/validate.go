@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// Command-line arguments are validated up front, before any key is
+// derived, so malformed input fails with a specific error code instead of
+// silently becoming zero or wrapping around.
+
+// parseIndex parses a non-hardened derivation index.
+func parseIndex(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n >= hdkeychain.HardenedKeyStart {
+		return 0, errorWithCode(errInvalidIndex, "invalid index: %q (want 0 to %d)", s, hdkeychain.HardenedKeyStart-1)
+	}
+	return uint32(n), nil
+}
+
+// parseCount parses a positive address count.
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > hdkeychain.HardenedKeyStart {
+		return 0, errorWithCode(errInvalidCount, "invalid count: %q", s)
+	}
+	return n, nil
+}
+
+// parseThreshold parses a multisig threshold and checks it against the
+// number of keys.
+func parseThreshold(s string, keys int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errorWithCode(errInvalidThreshold, "invalid threshold: %q", s)
+	}
+	return n, checkThreshold(n, keys)
+}
+
+func checkThreshold(threshold, keys int) error {
+	if threshold < 1 || threshold > keys {
+		return errorWithCode(errInvalidThreshold, "invalid threshold %d for %d keys", threshold, keys)
+	}
+	return nil
+}
+
+// parseBool parses a "true" or "false" argument.
+func parseBool(name, s string) (bool, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errorWithCode(errInvalidBoolean, "invalid %s: %q (want true or false)", name, s)
+}
+
+// networkNames lists the networks addresses can be derived for.
+var networkNames = []string{"mainnet", "testnet", "liquid", "liquidtestnet"}
+
+// publicKeyNetworks maps the version bytes of each extended public key
+// serialization, SLIP-132 variants included, to its network.
+var publicKeyNetworks = map[uint32]string{
+	0x0488b21e: "mainnet", // xpub
+	0x049d7cb2: "mainnet", // ypub
+	0x04b24746: "mainnet", // zpub
+	0x0295b43f: "mainnet", // Ypub
+	0x02aa7ed3: "mainnet", // Zpub
+	0x043587cf: "testnet", // tpub
+	0x044a5262: "testnet", // upub
+	0x045f1cf6: "testnet", // vpub
+	0x024289ef: "testnet", // Upub
+	0x02575483: "testnet", // Vpub
+}
+
+// checkKeyNetwork rejects an extended key whose version bytes are for the
+// other network. Keys are re-encoded for the network they derive on, so
+// a tpub would otherwise derive mainnet addresses without complaint.
+// Versions it does not know are left for the key parser to reject.
+func checkKeyNetwork(expr, network string) error {
+	key, _, err := parseKeyOrigin(expr)
+	if err != nil {
+		return err
+	}
+	decoded := base58.Decode(key.Xpub)
+	if len(decoded) < 4 {
+		return nil
+	}
+	keyNetwork, ok := publicKeyNetworks[binary.BigEndian.Uint32(decoded)]
+	if ok && keyNetwork != baseNetwork(network) {
+		return errorWithCode(errInvalidNetwork, "a %s key is for %s, not %s", key.Xpub[:4], keyNetwork, network)
+	}
+	return nil
+}
+
+// checkNetwork rejects networks not in networkNames.
+func checkNetwork(network string) error {
+	for _, n := range networkNames {
+		if n == network {
+			return nil
+		}
+	}
+	return errorWithCode(errInvalidNetwork, "unsupported network: %q", network)
+}
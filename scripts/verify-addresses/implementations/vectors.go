@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// verify-vectors runs a conformance fixture document (output/fixtures/
+// vectors.json, as generate-vectors writes it) against this binary's
+// engines. The document is only used once a minisign signature over it
+// checks out against the given public key, so a tampered vector file
+// cannot make a broken engine look correct. The pytest, Rust and vitest
+// modules written beside it embed their own copy of the vectors, so each
+// of them found there must carry a signature from the same key as well;
+// CI runs verify-vectors before any suite that uses them.
+
+// fixtureModules are the per-language fixture modules generate-vectors
+// writes next to vectors.json, as EMITTERS in fixtures.ts.
+var fixtureModules = []string{
+	"test_verified_vectors.py",
+	"verified_vectors.rs",
+	"verified-vectors.conformance.ts",
+}
+
+// fixtureSchemaVersion is the fixture layout verify-vectors reads, as
+// FIXTURE_SCHEMA_VERSION in fixtures.ts.
+const fixtureSchemaVersion = 1
+
+// conformanceFixtures is the part of the fixture document the vectors
+// need.
+type conformanceFixtures struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Generated     string `json:"generated"`
+	SingleSig     []struct {
+		Description     string `json:"description"`
+		Xpub            string `json:"xpub"`
+		ScriptType      string `json:"scriptType"`
+		Network         string `json:"network"`
+		Index           uint32 `json:"index"`
+		Change          bool   `json:"change"`
+		ExpectedAddress string `json:"expectedAddress"`
+	} `json:"singleSig"`
+	Multisig []struct {
+		Description     string   `json:"description"`
+		Xpubs           []string `json:"xpubs"`
+		Threshold       int      `json:"threshold"`
+		ScriptType      string   `json:"scriptType"`
+		Network         string   `json:"network"`
+		Index           uint32   `json:"index"`
+		Change          bool     `json:"change"`
+		ExpectedAddress string   `json:"expectedAddress"`
+	} `json:"multisig"`
+}
+
+// VectorsReport is the result of verify-vectors. Signature is nil, and
+// Modules empty, when the document was run --unsigned.
+type VectorsReport struct {
+	Signature *MinisignInfo   `json:"signature"`
+	Generated string          `json:"generated"`
+	Checked   int             `json:"checked"`
+	Failures  []VectorFailure `json:"failures"`
+	Verified  bool            `json:"verified"`
+	Modules   []SignedModule  `json:"modules"`
+}
+
+// SignedModule is a fixture module beside the document whose signature
+// verified.
+type SignedModule struct {
+	File      string        `json:"file"`
+	Signature *MinisignInfo `json:"signature"`
+}
+
+// VectorFailure is a vector this binary does not reproduce.
+type VectorFailure struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	Expected    string `json:"expected"`
+	Derived     string `json:"derived,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// MinisignInfo describes a signature that verified.
+type MinisignInfo struct {
+	KeyID          string `json:"key_id"`
+	Prehashed      bool   `json:"prehashed"`
+	TrustedComment string `json:"trusted_comment"`
+}
+
+func verifyVectors(path string, data []byte) (*VectorsReport, error) {
+	var fixtures conformanceFixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse vectors %s: %v", path, err)
+	}
+	if fixtures.SchemaVersion != fixtureSchemaVersion {
+		return nil, fmt.Errorf("vectors %s have schema version %d, want %d", path, fixtures.SchemaVersion, fixtureSchemaVersion)
+	}
+	report := &VectorsReport{Generated: fixtures.Generated, Failures: []VectorFailure{}}
+	check := func(kind, description, expected string, derived string, err error) {
+		report.Checked++
+		if err != nil {
+			report.Failures = append(report.Failures, VectorFailure{Kind: kind, Description: description, Expected: expected, Error: err.Error()})
+		} else if derived != expected {
+			report.Failures = append(report.Failures, VectorFailure{Kind: kind, Description: description, Expected: expected, Derived: derived})
+		}
+	}
+	for _, v := range fixtures.SingleSig {
+		address, err := deriveSingleSig(v.Xpub, v.Index, v.ScriptType, v.Change, v.Network)
+		check("single_sig", v.Description, v.ExpectedAddress, address, err)
+	}
+	for _, v := range fixtures.Multisig {
+		address, err := deriveMultisig(v.Xpubs, v.Threshold, v.Index, v.ScriptType, v.Change, v.Network)
+		check("multisig", v.Description, v.ExpectedAddress, address, err)
+	}
+	if report.Checked == 0 {
+		return nil, fmt.Errorf("vectors %s hold no vectors", path)
+	}
+	report.Verified = len(report.Failures) == 0
+	return report, nil
+}
+
+// verifyFixtureModules checks the signature of every fixture module next
+// to the document at path, each in its own <module>.minisig. Modules that
+// are not there are skipped; one that is there without a valid signature
+// fails the run.
+func verifyFixtureModules(pk *minisignPublicKey, path string) ([]SignedModule, error) {
+	modules := []SignedModule{}
+	for _, name := range fixtureModules {
+		module := filepath.Join(filepath.Dir(path), name)
+		data, err := os.ReadFile(module)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture module: %v", err)
+		}
+		sig, err := os.ReadFile(module + ".minisig")
+		if os.IsNotExist(err) {
+			return nil, errorWithCode(errInvalidSignature, "%s is not signed (no %s.minisig); sign every fixture module with minisign -Sm", module, module)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature: %v", err)
+		}
+		info, err := verifyMinisign(pk, data, sig)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", module, err)
+		}
+		modules = append(modules, SignedModule{File: name, Signature: info})
+	}
+	return modules, nil
+}
+
+// minisignPublicKey is a minisign Ed25519 public key.
+type minisignPublicKey struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+// parseMinisignPublicKey reads a public key given as its base64 line
+// ("RWQ...") or as a minisign.pub file.
+func parseMinisignPublicKey(arg string) (*minisignPublicKey, error) {
+	text := arg
+	if data, err := os.ReadFile(arg); err == nil {
+		text = string(data)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read public key: %v", err)
+	}
+	line := minisignKeyLine(text)
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+		return nil, errorWithCode(errInvalidSignature, "invalid minisign public key")
+	}
+	pk := &minisignPublicKey{key: ed25519.PublicKey(raw[10:])}
+	copy(pk.keyID[:], raw[2:10])
+	return pk, nil
+}
+
+// minisignKeyLine returns the first line of text that is not a comment.
+func minisignKeyLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			return line
+		}
+	}
+	return ""
+}
+
+// verifyMinisign checks a minisign signature file over data: the
+// signature itself, in the legacy form or over the BLAKE2b-512 hash
+// ("ED", minisign's default), and the global signature binding the
+// trusted comment to it.
+func verifyMinisign(pk *minisignPublicKey, data []byte, sigFile []byte) (*MinisignInfo, error) {
+	lines := strings.Split(strings.TrimRight(string(sigFile), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, errorWithCode(errInvalidSignature, "malformed minisign signature file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 74 {
+		return nil, errorWithCode(errInvalidSignature, "malformed minisign signature")
+	}
+	trusted, ok := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ok {
+		return nil, errorWithCode(errInvalidSignature, "minisign signature has no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, errorWithCode(errInvalidSignature, "malformed minisign global signature")
+	}
+
+	algorithm, keyID, signature := string(raw[:2]), raw[2:10], raw[10:]
+	if !bytes.Equal(keyID, pk.keyID[:]) {
+		return nil, errorWithCode(errInvalidSignature, "signed by key %s, not %s", minisignKeyID(keyID), minisignKeyID(pk.keyID[:]))
+	}
+	message := data
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	default:
+		return nil, errorWithCode(errInvalidSignature, "unknown minisign signature algorithm %q", algorithm)
+	}
+	if !ed25519.Verify(pk.key, message, signature) {
+		return nil, errorWithCode(errInvalidSignature, "the signature does not match the file")
+	}
+	if !ed25519.Verify(pk.key, append(append([]byte{}, signature...), trusted...), global) {
+		return nil, errorWithCode(errInvalidSignature, "the trusted comment does not match the signature")
+	}
+	return &MinisignInfo{KeyID: minisignKeyID(keyID), Prehashed: algorithm == "ED", TrustedComment: trusted}, nil
+}
+
+// minisignKeyID formats a key ID as minisign prints it.
+func minisignKeyID(id []byte) string {
+	return strings.ToUpper(hex.EncodeToString(binary.BigEndian.AppendUint64(nil, binary.LittleEndian.Uint64(id))))
+}
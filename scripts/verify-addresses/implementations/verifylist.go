@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ListReport is the result of checking a supplied index→address list.
+// Only mismatching rows are listed; Checked counts every row.
+type ListReport struct {
+	Format     string         `json:"format"`
+	Checked    int            `json:"checked"`
+	Mismatches []AddressCheck `json:"mismatches"`
+	Verified   bool           `json:"verified"`
+}
+
+// listRow is one (change, index, expected_address) row of a list.
+type listRow struct {
+	Change  bool
+	Index   uint32
+	Address string
+}
+
+// verifyAddressList re-derives every row of a CSV or JSON address list.
+// "-" reads the list from stdin.
+func verifyAddressList(spec *WalletSpec, listPath string) (*ListReport, error) {
+	var data []byte
+	var err error
+	if listPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(listPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address list: %v", err)
+	}
+
+	format := "csv"
+	var rows []listRow
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		format = "json"
+		rows, err = parseJSONListRows([]byte(trimmed))
+	} else {
+		rows, err = parseCSVListRows(trimmed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("address list has no rows")
+	}
+
+	report := &ListReport{Format: format, Mismatches: []AddressCheck{}, Verified: true}
+	for _, row := range rows {
+		derived, err := spec.deriveAddress(row.Change, row.Index)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+		check := AddressCheck{
+			Change:   row.Change,
+			Index:    row.Index,
+			Expected: row.Address,
+			Derived:  derived,
+			Match:    addressesMatch(derived, row.Address),
+		}
+		check.Hint = mismatchHint(check.Match, row.Address)
+		if streaming() {
+			streamRecord("check", check)
+		}
+		if !check.Match {
+			if !streaming() {
+				report.Mismatches = append(report.Mismatches, check)
+			}
+			report.Verified = false
+		}
+	}
+	return report, nil
+}
+
+// parseJSONListRows reads an array of {"change", "index",
+// "expected_address"} objects. change may be a boolean, 0/1, or a chain name.
+func parseJSONListRows(data []byte) ([]listRow, error) {
+	var raw []struct {
+		Change          json.RawMessage `json:"change"`
+		Index           *uint32         `json:"index"`
+		ExpectedAddress string          `json:"expected_address"`
+		Address         string          `json:"address"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse address list: %v", err)
+	}
+
+	rows := make([]listRow, 0, len(raw))
+	for i, r := range raw {
+		address := r.ExpectedAddress
+		if address == "" {
+			address = r.Address
+		}
+		if address == "" || r.Index == nil {
+			return nil, fmt.Errorf("address list row %d: needs index and expected_address", i+1)
+		}
+		change := false
+		if len(r.Change) > 0 {
+			var err error
+			if change, err = parseListChange(strings.Trim(string(r.Change), `"`)); err != nil {
+				return nil, fmt.Errorf("address list row %d: %v", i+1, err)
+			}
+		}
+		rows = append(rows, listRow{Change: change, Index: *r.Index, Address: address})
+	}
+	return rows, nil
+}
+
+// parseCSVListRows reads change,index,expected_address rows. A header row,
+// if present, may name the columns in any order; a "path" column can stand
+// in for change and index.
+func parseCSVListRows(text string) ([]listRow, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse address list: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	cols := map[string]int{"change": 0, "index": 1, "address": 2}
+	if isListHeader(records[0]) {
+		cols = map[string]int{}
+		for i, name := range records[0] {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "change", "chain", "is_change":
+				cols["change"] = i
+			case "index", "idx":
+				cols["index"] = i
+			case "expected_address", "expected", "address":
+				cols["address"] = i
+			case "path", "derivation_path":
+				cols["path"] = i
+			}
+		}
+		if _, ok := cols["address"]; !ok {
+			return nil, fmt.Errorf("address list header has no expected_address column")
+		}
+		_, havePath := cols["path"]
+		_, haveIndex := cols["index"]
+		if !havePath && !haveIndex {
+			return nil, fmt.Errorf("address list header has no index or path column")
+		}
+		records = records[1:]
+	}
+
+	field := func(rec []string, name string) (string, bool) {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return "", false
+		}
+		return strings.TrimSpace(rec[i]), true
+	}
+
+	rows := make([]listRow, 0, len(records))
+	for n, rec := range records {
+		row := listRow{}
+		var ok bool
+		if row.Address, ok = field(rec, "address"); !ok || row.Address == "" {
+			return nil, fmt.Errorf("address list row %d: missing expected_address", n+1)
+		}
+		if path, ok := field(rec, "path"); ok && path != "" {
+			if row.Change, row.Index, err = pathChainIndex(path); err != nil {
+				return nil, fmt.Errorf("address list row %d: %v", n+1, err)
+			}
+		} else {
+			index, ok := field(rec, "index")
+			i, err := strconv.ParseUint(index, 10, 31)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("address list row %d: invalid index %q", n+1, index)
+			}
+			row.Index = uint32(i)
+			if change, ok := field(rec, "change"); ok && change != "" {
+				if row.Change, err = parseListChange(change); err != nil {
+					return nil, fmt.Errorf("address list row %d: %v", n+1, err)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// isListHeader reports whether a record names columns rather than holding
+// data: data rows always carry a numeric index or a derivation path.
+func isListHeader(rec []string) bool {
+	for _, f := range rec {
+		f = strings.TrimSpace(f)
+		if _, err := strconv.ParseUint(f, 10, 31); err == nil || strings.HasPrefix(f, "m/") {
+			return false
+		}
+	}
+	return true
+}
+
+func parseListChange(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "0", "false", "receive", "external":
+		return false, nil
+	case "1", "true", "change", "internal":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid change value %q", s)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// WalletSpec describes a watch-only wallet independently of the format it
+// was imported from.
+type WalletSpec struct {
+	Name        string            `json:"name,omitempty"`
+	Network     string            `json:"network"`
+	ScriptType  string            `json:"script_type"`
+	Threshold   int               `json:"threshold,omitempty"`
+	Keys        []WalletKey       `json:"keys"`
+	BlockHeight int64             `json:"blockheight,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// Uncompressed serializes the wallet's child keys uncompressed, as
+	// wallets from before compressed keys did. Only legacy single-sig
+	// script types allow it, and such wallets have no descriptor form.
+	Uncompressed bool `json:"uncompressed,omitempty"`
+
+	// Taproot replaces the single-key template for tr() wallets using
+	// musig() keys or a script tree. Keys then lists every cosigner.
+	Taproot *TaprootPolicy `json:"taproot,omitempty"`
+
+	// Expected holds addresses the source format claims belong to the
+	// wallet, checked against independent derivation.
+	Expected []ExpectedAddress `json:"expected,omitempty"`
+}
+
+// WalletKey is one (co)signer's account-level extended public key.
+type WalletKey struct {
+	Xpub        string `json:"xpub"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Device      string `json:"device,omitempty"`
+	Label       string `json:"label,omitempty"`
+}
+
+// ExpectedAddress is an address a wallet export claims to own.
+type ExpectedAddress struct {
+	Change  bool   `json:"change"`
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+}
+
+type DerivedAddress struct {
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+	Label   string `json:"label,omitempty"`
+}
+
+type AddressCheck struct {
+	Change   bool   `json:"change"`
+	Index    uint32 `json:"index"`
+	Expected string `json:"expected"`
+	Derived  string `json:"derived"`
+	Match    bool   `json:"match"`
+	// Hint locates the likely typos of an expected address that fails
+	// its checksum.
+	Hint *AddressHint `json:"hint,omitempty"`
+}
+
+type WalletReport struct {
+	Name       string      `json:"name,omitempty"`
+	Network    string      `json:"network"`
+	ScriptType string      `json:"script_type"`
+	Threshold  int         `json:"threshold,omitempty"`
+	Keys       []WalletKey `json:"keys"`
+	// Uncompressed is set for wallets whose keys are used uncompressed.
+	Uncompressed bool             `json:"uncompressed,omitempty"`
+	Descriptor   string           `json:"descriptor,omitempty"`
+	Receive      []DerivedAddress `json:"receive"`
+	Change       []DerivedAddress `json:"change"`
+	Checks       []AddressCheck   `json:"checks,omitempty"`
+	Verified     bool             `json:"verified"`
+	// Backend names the curve backend that derived the addresses
+	// (--verbose).
+	Backend string `json:"backend,omitempty"`
+	// SpendSize is the size of an input spending a multisig wallet's
+	// outputs, for fee planning.
+	SpendSize *SpendSize `json:"spend_size,omitempty"`
+}
+
+func (s *WalletSpec) isMultisig() bool {
+	t, ok := scriptTypes[s.ScriptType]
+	return ok && t.multisig
+}
+
+func (s *WalletSpec) xpubs() []string {
+	xpubs := make([]string, len(s.Keys))
+	for i, k := range s.Keys {
+		xpubs[i] = k.Xpub
+	}
+	return xpubs
+}
+
+func (s *WalletSpec) validate() error {
+	if err := checkNetwork(s.Network); err != nil {
+		return err
+	}
+	if len(s.Keys) == 0 {
+		return fmt.Errorf("wallet spec has no keys")
+	}
+	for _, k := range s.Keys {
+		if err := checkKeyNetwork(k.Xpub, s.Network); err != nil {
+			return err
+		}
+	}
+	t, err := lookupScriptType(s.ScriptType)
+	if err != nil {
+		return err
+	}
+	if err := checkNetworkScriptType(s.Network, s.ScriptType); err != nil {
+		return err
+	}
+	if s.Uncompressed && t.uncompressedAddress == nil {
+		return errorWithCode(errUncompressedKey, "script type %s cannot use uncompressed keys", s.ScriptType)
+	}
+	if s.Taproot != nil {
+		if s.ScriptType != "taproot" {
+			return fmt.Errorf("taproot policy given for script type %s", s.ScriptType)
+		}
+		return s.Taproot.validate(s)
+	}
+	if s.isMultisig() {
+		return checkThreshold(s.Threshold, len(s.Keys))
+	}
+	if len(s.Keys) != 1 {
+		return fmt.Errorf("script type %s takes exactly one key, got %d", s.ScriptType, len(s.Keys))
+	}
+	return nil
+}
+
+// deriveAddress derives the address at change/index for the wallet, or
+// reads it from the derivation cache under --cache.
+func (s *WalletSpec) deriveAddress(change bool, index uint32) (string, error) {
+	cache, err := derivationCacheFor()
+	if err != nil {
+		return "", err
+	}
+	if cache == nil {
+		return s.derive(change, index)
+	}
+	if address, ok, err := cache.lookup(s, change, index); ok || err != nil {
+		return address, err
+	}
+	address, err := s.derive(change, index)
+	if err != nil {
+		return "", err
+	}
+	return address, cache.add(s, change, index, address)
+}
+
+func (s *WalletSpec) derive(change bool, index uint32) (string, error) {
+	if s.Taproot != nil {
+		if err := requireBtcsuiteEngine("taproot policies"); err != nil {
+			return "", err
+		}
+		return s.Taproot.address(s, change, index)
+	}
+	if s.isMultisig() {
+		return deriveMultisig(s.xpubs(), s.Threshold, index, s.ScriptType, change, s.Network)
+	}
+	if s.Uncompressed {
+		return deriveUncompressed(s.Keys[0].Xpub, index, s.ScriptType, change, s.Network)
+	}
+	return deriveSingleSig(s.Keys[0].Xpub, index, s.ScriptType, change, s.Network)
+}
+
+// networkFromXpub infers the network from an extended key's version prefix.
+func networkFromXpub(xpub string) string {
+	if len(xpub) > 0 && strings.ContainsRune("tuvUV", rune(xpub[0])) {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+// loadWalletSpec reads a wallet spec from a file, from stdin when the
+// argument is "-", from the registry for a "wallet:" reference, or treats
+// the argument as the spec itself when no such file exists. Encrypted
+// specs are decrypted first.
+func loadWalletSpec(arg string) (*WalletSpec, error) {
+	if ref, ok := strings.CutPrefix(arg, walletRefPrefix); ok {
+		return loadRegisteredWallet(ref)
+	}
+	data, err := readWalletSpecData(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := parseWalletSpec(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// readWalletSpecData reads the spec text a wallet spec argument gives,
+// decrypting an age-encrypted spec.
+func readWalletSpecData(arg string) ([]byte, error) {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if os.IsNotExist(err) {
+			data, err = []byte(arg), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet spec: %v", err)
+	}
+	if isAgeFile(data) {
+		return decryptWalletSpec(data)
+	}
+	return data, nil
+}
+
+// parseWalletSpec detects the spec format and parses it.
+func parseWalletSpec(data []byte) (*WalletSpec, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("empty wallet spec")
+	}
+
+	if bytes.HasPrefix(bytes.ToLower(trimmed), []byte("ur:")) {
+		return parseURWalletSpec(string(trimmed))
+	}
+	if trimmed[0] != '{' {
+		if isBlueWalletSetup(string(trimmed)) {
+			return parseBlueWalletSetup(string(trimmed))
+		}
+		return parseDescriptor(string(trimmed))
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet spec: %v", err)
+	}
+	if isWalletBundle(probe) {
+		return parseWalletBundle(trimmed)
+	}
+	if _, ok := probe["descriptor"]; ok {
+		return parseSpecterWallet(trimmed)
+	}
+	if _, ok := probe["recv_descriptor"]; ok {
+		return parseSpecterWallet(trimmed)
+	}
+
+	var spec WalletSpec
+	if err := json.Unmarshal(trimmed, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet spec: %v", err)
+	}
+	if spec.Network == "" && len(spec.Keys) > 0 {
+		spec.Network = networkFromXpub(spec.Keys[0].Xpub)
+	}
+	return &spec, nil
+}
+
+// verifyWallet derives the first count receive and change addresses and
+// checks every address the spec claims against them.
+func verifyWallet(spec *WalletSpec, count int) (*WalletReport, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("invalid count: %d", count)
+	}
+
+	// Types without a descriptor form are reported without one.
+	var descriptor string
+	if scriptTypes[spec.ScriptType].descriptor != "" && !spec.Uncompressed {
+		var err error
+		if descriptor, err = walletDescriptor(spec, false); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &WalletReport{
+		Name:         spec.Name,
+		Network:      spec.Network,
+		ScriptType:   spec.ScriptType,
+		Threshold:    spec.Threshold,
+		Keys:         spec.Keys,
+		Uncompressed: spec.Uncompressed,
+		Descriptor:   descriptor,
+		Receive:      []DerivedAddress{},
+		Change:       []DerivedAddress{},
+		Verified:     true,
+		Backend:      curveBackendName(),
+	}
+	var err error
+	if report.SpendSize, err = walletSpendSize(spec); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < count; i++ {
+		for _, change := range []bool{false, true} {
+			address, err := spec.deriveAddress(change, uint32(i))
+			if err != nil {
+				return nil, err
+			}
+			derived := DerivedAddress{Index: uint32(i), Address: address, Label: spec.Labels[address]}
+			switch {
+			case streaming() && change:
+				streamRecord("change", derived)
+			case streaming():
+				streamRecord("receive", derived)
+			case change:
+				report.Change = append(report.Change, derived)
+			default:
+				report.Receive = append(report.Receive, derived)
+			}
+		}
+	}
+
+	for _, expected := range spec.Expected {
+		derived, err := spec.deriveAddress(expected.Change, expected.Index)
+		if err != nil {
+			return nil, err
+		}
+		check := AddressCheck{
+			Change:   expected.Change,
+			Index:    expected.Index,
+			Expected: expected.Address,
+			Derived:  derived,
+			Match:    addressesMatch(derived, expected.Address),
+		}
+		check.Hint = mismatchHint(check.Match, expected.Address)
+		if streaming() {
+			streamRecord("check", check)
+		} else {
+			report.Checks = append(report.Checks, check)
+		}
+		if !check.Match {
+			report.Verified = false
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// watcher runs request files (in the run format) as they appear, in a
+// directory or on a named pipe, for integrations that can only drop files.
+// Each result is written atomically to the results directory under the
+// name of its request, and the request is moved to the archive directory.
+type watcher struct {
+	source string
+	fifo   bool
+	// pipe is the Windows named pipe watch serves, when source names one.
+	pipe     pipeListener
+	results  string
+	archive  string
+	interval time.Duration
+	// stuck holds requests that ran but could not be archived, so they
+	// are not run again on every poll.
+	stuck map[string]bool
+	// busy is held while a request file is processed, so a shutdown waits
+	// for it.
+	busy sync.Mutex
+}
+
+// watchedConfig is the backend config requests under watch use. watch
+// reads it at start and again on SIGHUP, so a config being edited is never
+// read half-written and every request file sees one config.
+var watchedConfig *BackendConfig
+
+// reloadPending is set by SIGHUP until the config is read again, before
+// the next request file.
+var reloadPending atomic.Bool
+
+// draining is set once watch has been asked to shut down. Requests not yet
+// started are then answered with errShuttingDown instead of being run.
+var draining atomic.Bool
+
+// defaultWatchInterval is how often a watched directory is polled.
+const defaultWatchInterval = time.Second
+
+// pipeListener accepts the clients of a named pipe, one at a time. Closing
+// a client disconnects it, after what was written to it has been read.
+type pipeListener interface {
+	accept() (io.ReadWriteCloser, error)
+}
+
+// isPipeName reports whether source names a Windows named pipe, which
+// watch creates rather than opens.
+func isPipeName(source string) bool {
+	return strings.HasPrefix(strings.ToLower(source), `\\.\pipe\`)
+}
+
+func newWatcher(source string, flags map[string]string) (*watcher, error) {
+	w := &watcher{source: source, interval: defaultWatchInterval, stuck: map[string]bool{}}
+	// Results and archive live inside a watched directory, next to a
+	// watched FIFO, and in the working directory for a Windows pipe.
+	base := "."
+	if !isPipeName(source) {
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch %s: %v", source, err)
+		}
+		w.fifo = info.Mode()&os.ModeNamedPipe != 0
+		if !w.fifo && !info.IsDir() {
+			return nil, fmt.Errorf("cannot watch %s: not a directory or named pipe", source)
+		}
+		base = source
+		if w.fifo {
+			base = filepath.Dir(source)
+		}
+	}
+	w.results, w.archive = filepath.Join(base, "results"), filepath.Join(base, "archive")
+	if v, ok := flags["results"]; ok {
+		w.results = v
+	}
+	if v, ok := flags["archive"]; ok {
+		w.archive = v
+	}
+	if v, ok := flags["interval"]; ok {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			return nil, errorWithCode(errInvalidCount, "invalid poll interval: %q", v)
+		}
+		w.interval = time.Duration(seconds) * time.Second
+	}
+	var err error
+	if isPipeName(source) {
+		if w.pipe, err = listenPipe(source); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range []string{w.results, w.archive} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	if watchedConfig, err = readBackendConfig(options.configPath); err != nil {
+		return nil, err
+	}
+	if _, err := backendFactory(watchedConfig); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// reloadOnSignal marks the config for reloading on SIGHUP.
+func (w *watcher) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reloadPending.Store(true)
+		}
+	}()
+}
+
+// reloadConfig reads the backend config again if SIGHUP asked for it. A
+// config that does not parse or names an unknown backend is reported, and
+// the previous one stays in use. Retry and circuit breaker state is reset,
+// as it describes the old endpoints.
+func (w *watcher) reloadConfig() {
+	if !reloadPending.Swap(false) {
+		return
+	}
+	cfg, err := readBackendConfig(options.configPath)
+	if err == nil {
+		_, err = backendFactory(cfg)
+	}
+	if err != nil {
+		diagnostic("keeping the previous backend config: %v", err)
+		return
+	}
+	watchedConfig = cfg
+	retryStates = map[string]*retryState{}
+	diagnostic("reloaded the backend config (%s backend)", cfg.Backend)
+}
+
+// run watches until the source fails.
+func (w *watcher) run() error {
+	diagnostic("watching %s (results in %s, archive in %s)", w.source, w.results, w.archive)
+	if w.fifo {
+		return w.readFIFO()
+	}
+	if w.pipe != nil {
+		return w.servePipe()
+	}
+	for {
+		if err := w.scanDir(); err != nil {
+			return err
+		}
+		time.Sleep(w.interval)
+	}
+}
+
+// scanDir runs every request file in the directory, in name order.
+// Dotfiles are skipped, so writers can create a request under a dot name
+// and rename it into place once complete.
+func (w *watcher) scanDir() error {
+	w.busy.Lock()
+	w.reloadConfig()
+	w.busy.Unlock()
+	entries, err := os.ReadDir(w.source)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", w.source, err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") || w.stuck[name] {
+			continue
+		}
+		w.process(filepath.Join(w.source, name), name)
+	}
+	return nil
+}
+
+// readFIFO runs each JSON request document written to the pipe, reopening
+// it whenever the writer closes.
+func (w *watcher) readFIFO() error {
+	for {
+		f, err := os.Open(w.source)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", w.source, err)
+		}
+		w.readRequests(f, nil)
+		f.Close()
+	}
+}
+
+// servePipe runs the requests of each client of the Windows named pipe in
+// turn, writing each result document back to the client as well.
+func (w *watcher) servePipe() error {
+	for {
+		client, err := w.pipe.accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept a client on %s: %v", w.source, err)
+		}
+		w.readRequests(client, client)
+		client.Close()
+	}
+}
+
+// readRequests runs each JSON request document read from r until it ends.
+// A document is first saved to the archive under a timestamp name, which
+// its result file then shares. With a reply writer, each result file is
+// also written to it, one document per line.
+func (w *watcher) readRequests(r io.Reader, reply io.Writer) {
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return
+		}
+		name := time.Now().UTC().Format("20060102T150405.000000000Z") + ".json"
+		if err != nil {
+			// The rest of the stream cannot be framed; drop it.
+			w.fail(name, fmt.Errorf("failed to parse request from %s: %v", w.source, err))
+			w.reply(reply, name)
+			return
+		}
+		path := filepath.Join(w.archive, name)
+		if err := writeFileAtomic(path, doc); err != nil {
+			w.fail(name, fmt.Errorf("failed to archive request: %v", err))
+		} else {
+			w.process(path, name)
+		}
+		if !w.reply(reply, name) {
+			return
+		}
+	}
+}
+
+// reply writes the named request's result file to a pipe client, reporting
+// whether the client is still there.
+func (w *watcher) reply(client io.Writer, name string) bool {
+	if client == nil {
+		return true
+	}
+	data, err := os.ReadFile(filepath.Join(w.results, name))
+	if err != nil {
+		diagnostic("failed to read result for %s: %v", name, err)
+		return true
+	}
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	if _, err := client.Write(data); err != nil {
+		diagnostic("failed to reply to the client of %s: %v", w.source, err)
+		return false
+	}
+	return true
+}
+
+// drainOnSignal shuts the watcher down on SIGTERM or SIGINT: the request
+// running is finished, the rest of its file is answered with
+// errShuttingDown, files still waiting in a watched directory are left for
+// the next verifier, and the derivation cache and traces are flushed. A
+// second signal exits at once.
+func (w *watcher) drainOnSignal() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		draining.Store(true)
+		// Not diagnostic, which reads the running request's ID.
+		fmt.Fprintf(os.Stderr, "%v: finishing the request in progress, then exiting\n", sig)
+		go func() {
+			<-signals
+			fmt.Fprintln(os.Stderr, "exiting without draining")
+			os.Exit(1)
+		}()
+		w.busy.Lock()
+		closeDerivationCache()
+		flushTraces()
+		os.Exit(0)
+	}()
+}
+
+// process runs one request file and archives it. A request file that
+// cannot be run gets an error document as its result. Once draining, a
+// file from a watched directory is left in place; one already read from a
+// pipe is answered with errShuttingDown.
+func (w *watcher) process(path, name string) {
+	w.busy.Lock()
+	defer w.busy.Unlock()
+	if draining.Load() && !w.fifo && w.pipe == nil {
+		return
+	}
+	w.reloadConfig()
+	report, err := runBatch(path, filepath.Join(w.results, name))
+	closeDerivationCache()
+	flushTraces()
+	if err != nil {
+		w.fail(name, err)
+	}
+	if archived := filepath.Join(w.archive, name); path != archived {
+		if err := os.Rename(path, archived); err != nil {
+			diagnostic("failed to archive %s: %v", path, err)
+			w.stuck[name] = true
+		}
+	}
+	if report != nil {
+		w.emit(report)
+	}
+}
+
+// fail writes err as the result of the named request.
+func (w *watcher) fail(name string, err error) {
+	result := Result{Error: err.Error(), Code: errorCode(err)}
+	data, _ := json.Marshal(result)
+	if werr := writeFileAtomic(filepath.Join(w.results, name), append(data, '\n')); werr != nil {
+		diagnostic("failed to write result for %s: %v", name, werr)
+	}
+	w.emit(result)
+}
+
+// emit reports a processed request file on stdout.
+func (w *watcher) emit(v interface{}) {
+	if streaming() {
+		streamRecord("batch", v)
+		return
+	}
+	outputJSON(v)
+}
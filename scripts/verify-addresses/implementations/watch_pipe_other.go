@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// listenPipe refuses Windows pipe names elsewhere; FIFOs made with mkfifo
+// are watched instead.
+func listenPipe(name string) (pipeListener, error) {
+	return nil, fmt.Errorf("cannot watch %s: Windows named pipes need Windows; watch a FIFO made with mkfifo instead", name)
+}
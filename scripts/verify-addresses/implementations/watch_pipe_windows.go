@@ -0,0 +1,131 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// Windows named pipes for watch, since Windows has no FIFOs. watch creates
+// the pipe itself, with a protected DACL granting the user it runs as, and
+// no one else, access. The pipe must not exist yet, so another process
+// cannot have created the name first to take the requests, and remote
+// clients are refused.
+
+var (
+	advapi32                        = syscall.NewLazyDLL("advapi32.dll")
+	convertStringSecurityDescriptor = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+	createNamedPipe                 = kernel32.NewProc("CreateNamedPipeW")
+	connectNamedPipe                = kernel32.NewProc("ConnectNamedPipe")
+	disconnectNamedPipe             = kernel32.NewProc("DisconnectNamedPipe")
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeBufferSize            = 64 << 10
+	sddlRevision1             = 1
+	errorPipeConnected        = syscall.Errno(535)
+)
+
+// namedPipe is the one instance of the pipe, which stays open between
+// clients so the name never comes free.
+type namedPipe struct {
+	handle syscall.Handle
+}
+
+// ownerOnlySecurityDescriptor is the SDDL of a DACL allowing the current
+// user full access and inheriting nothing.
+func ownerOnlySecurityDescriptor() (string, error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return "", err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return "", err
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return "", err
+	}
+	return "D:P(A;;GA;;;" + sid + ")", nil
+}
+
+func listenPipe(name string) (pipeListener, error) {
+	sddl, err := ownerOnlySecurityDescriptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the current user: %v", err)
+	}
+	sddlPtr, err := syscall.UTF16PtrFromString(sddl)
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	if r, _, err := convertStringSecurityDescriptor.Call(uintptr(unsafe.Pointer(sddlPtr)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return nil, fmt.Errorf("%s: %v", convertStringSecurityDescriptor.Name, err)
+	}
+	defer localFree.Call(sd)
+
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	sa := syscall.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(sa))
+	h, _, err := createNamedPipe.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		pipeAccessDuplex|fileFlagFirstPipeInstance,
+		pipeRejectRemoteClients,
+		1, pipeBufferSize, pipeBufferSize, 0,
+		uintptr(unsafe.Pointer(&sa)))
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, fmt.Errorf("failed to create %s: %v", name, err)
+	}
+	return &namedPipe{handle: syscall.Handle(h)}, nil
+}
+
+// accept waits for a client. One that connected before the wait is
+// reported as ERROR_PIPE_CONNECTED, which is a success.
+func (p *namedPipe) accept() (io.ReadWriteCloser, error) {
+	if r, _, err := connectNamedPipe.Call(uintptr(p.handle), 0); r == 0 && err != errorPipeConnected {
+		return nil, err
+	}
+	return &pipeClient{handle: p.handle}, nil
+}
+
+// pipeClient is the connected client of a namedPipe.
+type pipeClient struct {
+	handle syscall.Handle
+}
+
+// Read reports the client closing its end as the end of the stream.
+func (c *pipeClient) Read(b []byte) (int, error) {
+	var n uint32
+	err := syscall.ReadFile(c.handle, b, &n, nil)
+	if err == syscall.ERROR_BROKEN_PIPE {
+		return int(n), io.EOF
+	}
+	return int(n), err
+}
+
+func (c *pipeClient) Write(b []byte) (int, error) {
+	var n uint32
+	err := syscall.WriteFile(c.handle, b, &n, nil)
+	return int(n), err
+}
+
+// Close waits for the client to read the replies, then disconnects it,
+// leaving the pipe for the next one.
+func (c *pipeClient) Close() error {
+	syscall.FlushFileBuffers(c.handle)
+	if r, _, err := disconnectNamedPipe.Call(uintptr(c.handle)); r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Webhooks post monitor events to an HTTP endpoint, so a back office is
+// told of deposits without running an indexer. Each delivery is signed
+// with HMAC-SHA256 over "<timestamp>.<body>" using the webhook's secret,
+// and carries a delivery ID that stays the same across retries and
+// restarts, so the receiver can drop duplicates.
+
+// WebhookConfig is one entry of the config's "webhooks" list.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// SecretEnv names the environment variable holding the HMAC secret.
+	SecretEnv string `json:"secret_env"`
+	// Events lists the monitor events posted; the default is "received".
+	Events []string `json:"events,omitempty"`
+	// Confirmations, if set, also posts a "confirmations" event once an
+	// output reaches that many confirmations.
+	Confirmations int64 `json:"confirmations,omitempty"`
+}
+
+// eventConfirmations is posted to webhooks when an output reaches their
+// confirmation depth.
+const eventConfirmations = "confirmations"
+
+// webhookAttempts is how many times a delivery is tried.
+const webhookAttempts = 3
+
+type webhook struct {
+	url    string
+	secret []byte
+	events map[string]bool
+	depth  int64
+	client *http.Client
+}
+
+func newWebhook(cfg WebhookConfig) (*webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url: %q", cfg.URL)
+	}
+	if cfg.SecretEnv == "" {
+		return nil, fmt.Errorf("webhook %s has no secret_env", u.Host)
+	}
+	secret := os.Getenv(cfg.SecretEnv)
+	if secret == "" {
+		return nil, fmt.Errorf("webhook %s: environment variable %s is not set", u.Host, cfg.SecretEnv)
+	}
+	if cfg.Confirmations < 0 {
+		return nil, fmt.Errorf("webhook %s: invalid confirmations: %d", u.Host, cfg.Confirmations)
+	}
+	w := &webhook{url: cfg.URL, secret: []byte(secret), events: map[string]bool{}, depth: cfg.Confirmations, client: newHTTPClient(10 * time.Second)}
+	events := cfg.Events
+	if len(events) == 0 {
+		events = []string{eventReceived}
+	}
+	for _, e := range events {
+		switch e {
+		case eventReceived, eventConfirmed, eventSpent, eventReplaced, eventEvicted, eventCPFP, eventSettled:
+			w.events[e] = true
+		default:
+			return nil, fmt.Errorf("webhook %s: unknown event %q", u.Host, e)
+		}
+	}
+	return w, nil
+}
+
+// openWebhooks sets up the config's webhooks for a monitor.
+func openWebhooks(m *addressMonitor, cfg *BackendConfig) error {
+	if len(cfg.Webhooks) > 0 {
+		if err := requireOnline("webhooks"); err != nil {
+			return err
+		}
+	}
+	for _, c := range cfg.Webhooks {
+		w, err := newWebhook(c)
+		if err != nil {
+			return err
+		}
+		m.sinks = append(m.sinks, w.notify)
+		if w.depth > 0 {
+			m.depths = append(m.depths, w.depth)
+		}
+	}
+	return nil
+}
+
+// notify posts an event the webhook subscribes to. A delivery that still
+// fails after its retries is reported and dropped; monitoring goes on.
+func (w *webhook) notify(e MonitorEvent) {
+	if e.Type == eventConfirmations && e.Depth != w.depth || e.Type != eventConfirmations && !w.events[e.Type] {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := w.deliver(e, body); err != nil {
+		diagnostic("webhook %s: %v", w.url, err)
+	}
+}
+
+func (w *webhook) deliver(e MonitorEvent, body []byte) error {
+	id := deliveryID(e)
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(1<<(attempt-2)) * time.Second)
+		}
+		var retry bool
+		if retry, err = w.post(id, e.Type, body); err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("delivery %s of %s event failed: %v", id, e.Type, err)
+	}
+	return nil
+}
+
+// post makes one delivery attempt, and reports whether a failure is worth
+// retrying: connection errors and server errors are, refusals are not.
+func (w *webhook) post(id, eventType string, body []byte) (retry bool, err error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Verifier-Event", eventType)
+	req.Header.Set("X-Verifier-Delivery", id)
+	req.Header.Set("X-Verifier-Timestamp", timestamp)
+	req.Header.Set("X-Verifier-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return false, fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// deliveryID identifies an event by what it is about rather than when it
+// was seen.
+func deliveryID(e MonitorEvent) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d", e.WalletID, e.Type, outpointKey(e.TxID, e.Vout), e.Depth)))
+	return hex.EncodeToString(sum[:16])
+}
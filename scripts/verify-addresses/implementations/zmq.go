@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Bitcoin Core publishes every transaction entering its mempool and every
+// block it connects over ZeroMQ (-zmqpubrawtx, -zmqpubrawblock). monitor
+// subscribes to them when the config's core section names the endpoints,
+// and matches what arrives against the watched scripts as it arrives,
+// instead of waiting for the next poll. This is a ZMTP 3.0 SUB client with
+// the NULL mechanism, which is all Core's publisher speaks; it needs no
+// libzmq.
+
+// zmqMessage is one message of a Core notification topic.
+type zmqMessage struct {
+	topic string
+	body  []byte
+	seq   uint32
+}
+
+type zmqSubscriber struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// ZMTP frame flags.
+const (
+	zmtpMore    = 0x01
+	zmtpLong    = 0x02
+	zmtpCommand = 0x04
+)
+
+// zmtpMaxFrame bounds a frame; a mainnet block is at most 4MB.
+const zmtpMaxFrame = 32 << 20
+
+// dialZMQ connects to a tcp:// or ipc:// endpoint and subscribes to topic.
+func dialZMQ(endpoint, topic string) (*zmqSubscriber, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case strings.HasPrefix(endpoint, "tcp://"):
+		conn, err = dialBackend(context.Background(), strings.TrimPrefix(endpoint, "tcp://"), 30*time.Second)
+	case strings.HasPrefix(endpoint, "ipc://"):
+		conn, err = net.DialTimeout("unix", strings.TrimPrefix(endpoint, "ipc://"), 30*time.Second)
+	default:
+		return nil, fmt.Errorf("unsupported ZMQ endpoint %q (want tcp:// or ipc://)", endpoint)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", endpoint, err)
+	}
+	s := &zmqSubscriber{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := s.handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ZMQ handshake with %s failed: %v", endpoint, err)
+	}
+	conn.SetDeadline(time.Time{})
+	// A ZMTP 3.0 subscription is a message of 0x01 and the topic.
+	if err := s.writeFrame(0, append([]byte{1}, topic...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// handshake exchanges greetings and READY commands. The greeting offers
+// version 3.0, so the publisher expects subscriptions as messages.
+func (s *zmqSubscriber) handshake() error {
+	greeting := make([]byte, 64)
+	greeting[0], greeting[9] = 0xff, 0x7f
+	greeting[10], greeting[11] = 3, 0
+	copy(greeting[12:32], "NULL")
+	if _, err := s.conn.Write(greeting); err != nil {
+		return err
+	}
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(s.r, peer); err != nil {
+		return err
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f || peer[10] < 3 {
+		return fmt.Errorf("not a ZMTP 3 peer")
+	}
+	if mechanism := string(bytes.TrimRight(peer[12:32], "\x00")); mechanism != "NULL" {
+		return fmt.Errorf("unsupported security mechanism %s", mechanism)
+	}
+
+	ready := []byte("\x05READY")
+	ready = appendZMTPProperty(ready, "Socket-Type", "SUB")
+	if err := s.writeFrame(zmtpCommand, ready); err != nil {
+		return err
+	}
+	flags, body, err := s.readFrame()
+	if err != nil {
+		return err
+	}
+	if flags&zmtpCommand == 0 || len(body) < 1 || len(body) < 1+int(body[0]) {
+		return fmt.Errorf("expected a READY command")
+	}
+	if name := string(body[1 : 1+body[0]]); name != "READY" {
+		if name == "ERROR" && len(body) > 7 {
+			return fmt.Errorf("peer refused: %s", body[7:])
+		}
+		return fmt.Errorf("expected a READY command, got %s", name)
+	}
+	return nil
+}
+
+func appendZMTPProperty(b []byte, name, value string) []byte {
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+func (s *zmqSubscriber) writeFrame(flags byte, body []byte) error {
+	var frame []byte
+	if len(body) > 255 {
+		frame = binary.BigEndian.AppendUint64([]byte{flags | zmtpLong}, uint64(len(body)))
+	} else {
+		frame = []byte{flags, byte(len(body))}
+	}
+	_, err := s.conn.Write(append(frame, body...))
+	return err
+}
+
+func (s *zmqSubscriber) readFrame() (flags byte, body []byte, err error) {
+	if flags, err = s.r.ReadByte(); err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmtpLong != 0 {
+		var b [8]byte
+		if _, err := io.ReadFull(s.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > zmtpMaxFrame {
+		return 0, nil, fmt.Errorf("ZMQ frame of %d bytes is too large", size)
+	}
+	body = make([]byte, size)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// Receive returns the next notification: Core sends the topic, the body and
+// a little-endian sequence number per topic as one three-part message.
+func (s *zmqSubscriber) Receive() (zmqMessage, error) {
+	for {
+		var parts [][]byte
+		for {
+			flags, body, err := s.readFrame()
+			if err != nil {
+				return zmqMessage{}, err
+			}
+			if flags&zmtpCommand != 0 {
+				continue
+			}
+			parts = append(parts, body)
+			if flags&zmtpMore == 0 {
+				break
+			}
+		}
+		if len(parts) != 3 || len(parts[2]) != 4 {
+			continue
+		}
+		return zmqMessage{topic: string(parts[0]), body: parts[1], seq: binary.LittleEndian.Uint32(parts[2])}, nil
+	}
+}
+
+func (s *zmqSubscriber) Close() error {
+	return s.conn.Close()
+}